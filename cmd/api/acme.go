@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// autocertManager returns the manager which obtains and renews the certificates of the
+// -acme-domains from Let's Encrypt, or nil if ACME is disabled. The account key and the
+// certificates are cached in -acme-cache-dir, so that a restart doesn't request new ones.
+func (app *application) autocertManager() *autocert.Manager {
+	if len(app.config.acme.domains) == 0 {
+		return nil
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(app.config.acme.cacheDir),
+		HostPolicy: autocert.HostWhitelist(app.config.acme.domains...),
+		Email:      app.config.acme.email,
+	}
+}
+
+// challengeServer returns the plain HTTP server for -acme-http-port, which answers the ACME
+// HTTP-01 challenges of m and redirects every other request to HTTPS.
+func (app *application) challengeServer(m *autocert.Manager) *http.Server {
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.acme.httpPort),
+		Handler:      m.HTTPHandler(nil),
+		ErrorLog:     log.New(app.logger, "", 0),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/tomasen/realip"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// auditContextKey is used as a key for the *auditRecord of a mutating request in the request
+// context.
+const auditContextKey = contextKey("audit")
+
+// auditRecord collects what a handler changed. The entity and its id default to the ones named
+// by the route, see auditTarget(), and are replaced by the handler when it calls auditBefore()
+// or auditAfter().
+type auditRecord struct {
+	entity   string
+	entityID string
+	before   json.RawMessage
+	after    json.RawMessage
+}
+
+// auditLog records every successful POST, PUT, PATCH and DELETE request in the audit log. The
+// entry is written in the background once the response has been sent, so that a slow or
+// failing insert doesn't hold up or fail the request. It must run after authenticate(),
+// because it records the user making the request.
+func (app *application) auditLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		record := &auditRecord{}
+		r = r.WithContext(context.WithValue(r.Context(), auditContextKey, record))
+
+		metrics := httpsnoop.CaptureMetrics(next, w, r)
+		if metrics.Code < 200 || metrics.Code > 299 {
+			return
+		}
+
+		route, ok := matchRoute(app.apiRoutes, r.Method, r.URL.Path)
+		if !ok {
+			return
+		}
+
+		entry := &data.AuditEntry{
+			Method: r.Method,
+			Route:  route.path,
+			Path:   r.URL.Path,
+			Status: metrics.Code,
+			Before: record.before,
+			After:  record.after,
+			IP:     realip.FromRequest(r),
+		}
+
+		entry.Entity, entry.EntityID = auditTarget(route.path, r.URL.Path)
+		if record.entity != "" {
+			entry.Entity, entry.EntityID = record.entity, record.entityID
+		}
+
+		if user := app.contextGetUser(r); !user.IsAnonymous() && !user.IsTrial() {
+			entry.ActorID = &user.ID
+		}
+
+		app.background(func() {
+			err := app.models.AuditLog.Insert(entry)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"method": entry.Method, "path": entry.Path})
+			}
+		})
+	})
+}
+
+// auditBefore records the state of an entity before the request changes it.
+func (app *application) auditBefore(r *http.Request, entity string, id int64, v interface{}) {
+	if record := app.auditRecordFor(r, entity, id); record != nil {
+		record.before = app.auditJSON(v)
+	}
+}
+
+// auditAfter records the state of an entity after the request changed it.
+func (app *application) auditAfter(r *http.Request, entity string, id int64, v interface{}) {
+	if record := app.auditRecordFor(r, entity, id); record != nil {
+		record.after = app.auditJSON(v)
+	}
+}
+
+// auditRecordFor returns the audit record of the request, pointed at the given entity, or nil
+// if the request isn't audited.
+func (app *application) auditRecordFor(r *http.Request, entity string, id int64) *auditRecord {
+	record, ok := r.Context().Value(auditContextKey).(*auditRecord)
+	if !ok {
+		return nil
+	}
+
+	record.entity = entity
+	record.entityID = strconv.FormatInt(id, 10)
+	return record
+}
+
+// auditJSON encodes v right away, so that later changes to it don't leak into the record. An
+// entity which can't be encoded is logged and left out of the record.
+func (app *application) auditJSON(v interface{}) json.RawMessage {
+	js, err := json.Marshal(v)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"audit": "encode"})
+		return nil
+	}
+	return js
+}
+
+// matchRoute returns the registered route that method and path are served by. Static segments
+// win over wildcards, so "/v1/movies/batch" is matched rather than "/v1/movies/:id".
+func matchRoute(routes []apiRoute, method, path string) (apiRoute, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	var (
+		best      apiRoute
+		bestScore = -1
+	)
+
+	for _, route := range routes {
+		if route.method != method {
+			continue
+		}
+
+		pattern := strings.Split(strings.Trim(route.path, "/"), "/")
+		if len(pattern) != len(segments) {
+			continue
+		}
+
+		score := 0
+		for i, segment := range pattern {
+			switch {
+			case strings.HasPrefix(segment, ":") && segments[i] != "":
+			case segment == segments[i]:
+				score++
+			default:
+				score = -1
+			}
+			if score < 0 {
+				break
+			}
+		}
+
+		if score > bestScore {
+			best, bestScore = route, score
+		}
+	}
+
+	return best, bestScore >= 0
+}
+
+// auditTarget names the entity a route changes, and its id: the static segment in front of
+// the last wildcard and the value of that wildcard, e.g. "comments" and "7" for
+// "/v1/movies/3/comments/7". Routes without a wildcard name the collection after the version
+// segment, e.g. "users" for "/v1/users/activated", and have no id.
+func auditTarget(pattern, path string) (string, string) {
+	parts := strings.Split(strings.Trim(pattern, "/"), "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i := len(parts) - 1; i > 0; i-- {
+		if strings.HasPrefix(parts[i], ":") && !strings.HasPrefix(parts[i-1], ":") {
+			return parts[i-1], segments[i]
+		}
+	}
+
+	if len(parts) > 1 {
+		return parts[1], ""
+	}
+	return parts[0], ""
+}
+
+// listAuditLogHandler handles "GET /v1/admin/audit-log" and returns the audit log, newest
+// first, optionally filtered by actor, method, entity and time range.
+func (app *application) listAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filter data.AuditFilter
+	filter.ActorID = int64(app.readInt(qs, "actor_id", 0, v))
+	filter.Method = strings.ToUpper(app.readStrings(qs, "method", ""))
+	filter.Entity = app.readStrings(qs, "entity", "")
+	filter.EntityID = app.readStrings(qs, "entity_id", "")
+	filter.Since = app.readTime(qs, "since", v)
+	filter.Until = app.readTime(qs, "until", v)
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", DEFAULT_PAGE, v)
+	filters.PageSize = app.readInt(qs, "page_size", DEFAULT_PAGE_SIZE, v)
+	filters.Sort = app.readStrings(qs, "sort", "-id")
+	filters.SortSafeList = []string{"id", "-id"}
+
+	data.ValidateAuditFilter(v, filter)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.AuditLog.GetAll(filter, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"entries": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchRoute(t *testing.T) {
+	routes := []apiRoute{
+		{http.MethodPost, "/v1/movies"},
+		{http.MethodPatch, "/v1/movies/:id"},
+		{http.MethodPatch, "/v1/movies/batch"},
+		{http.MethodDelete, "/v1/movies/:id/comments/:comment_id"},
+	}
+
+	tests := []struct {
+		method, path string
+		want         string
+		ok           bool
+	}{
+		{http.MethodPost, "/v1/movies", "/v1/movies", true},
+		{http.MethodPatch, "/v1/movies/7", "/v1/movies/:id", true},
+		{http.MethodPatch, "/v1/movies/batch", "/v1/movies/batch", true},
+		{http.MethodDelete, "/v1/movies/7/comments/3", "/v1/movies/:id/comments/:comment_id", true},
+		{http.MethodDelete, "/v1/movies/7", "", false},
+		{http.MethodPost, "/v1/users", "", false},
+	}
+
+	for _, tt := range tests {
+		route, ok := matchRoute(routes, tt.method, tt.path)
+		if ok != tt.ok || route.path != tt.want {
+			t.Errorf("matchRoute(%s %s) = %q, %v; want %q, %v", tt.method, tt.path, route.path, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAuditTarget(t *testing.T) {
+	tests := []struct {
+		pattern, path    string
+		entity, entityID string
+	}{
+		{"/v1/movies", "/v1/movies", "movies", ""},
+		{"/v1/movies/:id", "/v1/movies/7", "movies", "7"},
+		{"/v1/movies/:id/note", "/v1/movies/7/note", "movies", "7"},
+		{"/v1/movies/:id/comments/:comment_id", "/v1/movies/7/comments/3", "comments", "3"},
+		{"/v1/lists/:slug/movies/:movie_id", "/v1/lists/noir/movies/7", "movies", "7"},
+		{"/v1/users/activated", "/v1/users/activated", "users", ""},
+	}
+
+	for _, tt := range tests {
+		entity, entityID := auditTarget(tt.pattern, tt.path)
+		if entity != tt.entity || entityID != tt.entityID {
+			t.Errorf("auditTarget(%q) = %q, %q; want %q, %q", tt.path, entity, entityID, tt.entity, tt.entityID)
+		}
+	}
+}
+
+func TestAuditBeforeAfter(t *testing.T) {
+	app := newTestApp()
+
+	// Requests which aren't audited have no record, and the hooks do nothing.
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies/7", nil)
+	app.auditBefore(r, "movies", 7, envelope{"title": "Casablanca"})
+
+	record := &auditRecord{}
+	r = r.WithContext(context.WithValue(r.Context(), auditContextKey, record))
+
+	movie := map[string]string{"title": "Casablanca"}
+	app.auditBefore(r, "movies", 7, movie)
+	movie["title"] = "Casablanca (1942)"
+	app.auditAfter(r, "movies", 7, movie)
+
+	if record.entity != "movies" || record.entityID != "7" {
+		t.Errorf("got entity %q %q", record.entity, record.entityID)
+	}
+
+	if string(record.before) != `{"title":"Casablanca"}` || string(record.after) != `{"title":"Casablanca (1942)"}` {
+		t.Errorf("got before %s, after %s", record.before, record.after)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// authEventInvalidToken is the reason of the activations and password resets which failed
+// because their token was invalid or expired. The failed logins use the loginFailure* reasons.
+const authEventInvalidToken = "invalid_token"
+
+// maxUserAgentLength is the length the user agents recorded with the authentication events are
+// cut to, so that a client can't fill the table with huge headers.
+const maxUserAgentLength = 512
+
+// authEventFor returns event filled in with the user it concerns, if known, and the IP address
+// and user agent of the client making the request r.
+func authEventFor(r *http.Request, user *data.User, event data.AuthEvent) *data.AuthEvent {
+	if user != nil {
+		event.UserID = &user.ID
+		event.Email = user.Email
+	}
+
+	event.IP = realip.FromRequest(r)
+	event.UserAgent = r.UserAgent()
+	if len(event.UserAgent) > maxUserAgentLength {
+		event.UserAgent = strings.ToValidUTF8(event.UserAgent[:maxUserAgentLength], "")
+	}
+
+	return &event
+}
+
+// recordAuthEvent records the authentication event in the background, like the audit log, so
+// that the response isn't held up. user is nil when the event can't be tied to a user.
+func (app *application) recordAuthEvent(r *http.Request, user *data.User, event data.AuthEvent) {
+	e := authEventFor(r, user, event)
+
+	app.background(func() {
+		err := app.models.AuthEvents.Insert(e)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"auth_event": e.Type})
+		}
+	})
+}
+
+// listSecurityEventsHandler handles "GET /v1/users/me/security-events" and returns the
+// authentication events of the current user, newest first, so that users can spot logins
+// they didn't make.
+func (app *application) listSecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	// Trial token holders have no account, and no ID to filter the events by.
+	user := app.contextGetUser(r)
+	if user.IsTrial() {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filter := data.AuthEventFilter{UserID: user.ID}
+	filter.Type = app.readStrings(qs, "type", "")
+	filter.Since = app.readTime(qs, "since", v)
+	filter.Until = app.readTime(qs, "until", v)
+
+	app.listAuthEvents(w, r, v, filter)
+}
+
+// listAuthEventsHandler handles "GET /v1/admin/auth-events" and returns the authentication
+// events of every user, newest first, optionally filtered by user, email address, type, IP
+// address, outcome and time range, for investigating incidents.
+func (app *application) listAuthEventsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filter data.AuthEventFilter
+	filter.UserID = int64(app.readInt(qs, "user_id", 0, v))
+	filter.Email = app.readStrings(qs, "email", "")
+	filter.Type = app.readStrings(qs, "type", "")
+	filter.IP = app.readStrings(qs, "ip", "")
+	filter.Failed = app.readBool(qs, "failed", false, v)
+	filter.Since = app.readTime(qs, "since", v)
+	filter.Until = app.readTime(qs, "until", v)
+
+	app.listAuthEvents(w, r, v, filter)
+}
+
+// listAuthEvents writes the page of the authentication events matching filter asked for in
+// the query string.
+func (app *application) listAuthEvents(w http.ResponseWriter, r *http.Request, v *validator.Validator, filter data.AuthEventFilter) {
+	qs := r.URL.Query()
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", DEFAULT_PAGE, v)
+	filters.PageSize = app.readInt(qs, "page_size", DEFAULT_PAGE_SIZE, v)
+	filters.Sort = app.readStrings(qs, "sort", "-id")
+	filters.SortSafeList = []string{"id", "-id"}
+
+	data.ValidateAuthEventFilter(v, filter)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.AuthEvents.GetAll(filter, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
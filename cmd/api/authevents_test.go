@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestAuthEventFor(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", nil)
+	r.RemoteAddr = "203.0.113.7:4321"
+	r.Header.Set("User-Agent", strings.Repeat("a", 2*maxUserAgentLength))
+
+	user := &data.User{ID: 42, Email: "alice@example.com"}
+	event := authEventFor(r, user, data.AuthEvent{Type: data.AuthEventLogin, Reason: loginFailureWrongPassword})
+
+	if event.UserID == nil || *event.UserID != 42 || event.Email != "alice@example.com" {
+		t.Errorf("got user %v and email %q", event.UserID, event.Email)
+	}
+	if event.IP != "203.0.113.7" {
+		t.Errorf("got IP %q", event.IP)
+	}
+	if len(event.UserAgent) != maxUserAgentLength {
+		t.Errorf("got a user agent of %d bytes; want %d", len(event.UserAgent), maxUserAgentLength)
+	}
+
+	event = authEventFor(r, nil, data.AuthEvent{Email: "nobody@example.com", Type: data.AuthEventLogin})
+	if event.UserID != nil || event.Email != "nobody@example.com" {
+		t.Errorf("got user %v and email %q for an unknown user", event.UserID, event.Email)
+	}
+}
+
+func TestListSecurityEventsRejectsTrialUsers(t *testing.T) {
+	app := newTestApp()
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/users/me/security-events", nil)
+	r = app.contextSetUser(r, data.NewTrialUser())
+	rr := httptest.NewRecorder()
+	app.listSecurityEventsHandler(rr, r)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/blob"
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+// Layout of the differential backups in the blob store. Every batch of changes is written as
+// an NDJSON file, followed by a manifest describing it. Sequence numbers in the keys are zero
+// padded so that lexical order is the same as replay order.
+const (
+	backupBatchPrefix    = "movies/changes/"
+	backupManifestPrefix = "movies/manifests/"
+	backupBatchSize      = 1000
+)
+
+// backupSettleTimeout is how long a backup run waits for the transactions recording changes to
+// finish, see data.ChangeWatermark, before it gives up until the next run.
+const backupSettleTimeout = 30 * time.Second
+
+// restoreBatchTimeout is how long restore may take to replay a single batch of changes.
+const restoreBatchTimeout = 5 * time.Minute
+
+// backupManifest describes a single batch of changes. A batch is only considered part of the
+// backup once its manifest has been written, so a crash half-way through a run never leaves a
+// batch that restore would pick up.
+type backupManifest struct {
+	FromSeq        int64     `json:"from_seq"`
+	ToSeq          int64     `json:"to_seq"`
+	Count          int       `json:"count"`
+	Batch          string    `json:"batch"`
+	SHA256         string    `json:"sha256"`
+	FirstChangedAt time.Time `json:"first_changed_at"`
+	LastChangedAt  time.Time `json:"last_changed_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func backupKey(prefix string, seq int64, ext string) string {
+	return fmt.Sprintf("%s%020d%s", prefix, seq, ext)
+}
+
+// startBackupJob runs a differential backup every interval for the lifetime of the
+// application. Each run is started with app.background(), so that graceful shutdown waits
+// for a run in progress to finish writing its batch.
+func (app *application) startBackupJob(store blob.Store, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			app.background(func() {
+				err := app.backupChanges(store)
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"job": "backup"})
+				}
+			})
+		}
+	}()
+}
+
+// backupChanges writes every change recorded since the last manifest to the blob store. The
+// next run starts after the last change written, so it only writes the changes up to a settled
+// watermark: a change committed after a later one had been written would be skipped for good.
+func (app *application) backupChanges(store blob.Store) error {
+	manifests, err := readBackupManifests(store)
+	if err != nil {
+		return err
+	}
+
+	var lastSeq int64
+	if len(manifests) > 0 {
+		lastSeq = manifests[len(manifests)-1].ToSeq
+	}
+
+	watermark, err := app.settledChangeWatermark(backupSettleTimeout)
+	if err != nil {
+		return err
+	}
+
+	for {
+		changes, err := app.models.Changes.GetSince(lastSeq, watermark.Seq, backupBatchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(changes) == 0 {
+			return nil
+		}
+
+		manifest, err := writeBackupBatch(store, changes)
+		if err != nil {
+			return err
+		}
+
+		app.logger.PrintInfo("backup batch written", map[string]string{
+			"from_seq": fmt.Sprint(manifest.FromSeq),
+			"to_seq":   fmt.Sprint(manifest.ToSeq),
+			"count":    fmt.Sprint(manifest.Count),
+		})
+
+		lastSeq = manifest.ToSeq
+	}
+}
+
+// settledChangeWatermark takes a change feed watermark and waits up to timeout for it to settle.
+func (app *application) settledChangeWatermark(timeout time.Duration) (data.ChangeWatermark, error) {
+	watermark, err := app.models.Changes.Watermark()
+	if err != nil {
+		return data.ChangeWatermark{}, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		settled, err := app.models.Changes.Settled(watermark)
+		if err != nil {
+			return data.ChangeWatermark{}, err
+		}
+		if settled {
+			return watermark, nil
+		}
+
+		if time.Now().After(deadline) {
+			return data.ChangeWatermark{}, fmt.Errorf("transactions running since change %d haven't finished within %s", watermark.Seq, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// writeBackupBatch writes the changes as an NDJSON batch, then the manifest which points to it.
+func writeBackupBatch(store blob.Store, changes []*data.MovieChange) (*backupManifest, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	for _, change := range changes {
+		err := enc.Encode(change)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	first, last := changes[0], changes[len(changes)-1]
+	sum := sha256.Sum256(buf.Bytes())
+
+	manifest := &backupManifest{
+		FromSeq:        first.Seq,
+		ToSeq:          last.Seq,
+		Count:          len(changes),
+		Batch:          backupKey(backupBatchPrefix, first.Seq, ".ndjson"),
+		SHA256:         hex.EncodeToString(sum[:]),
+		FirstChangedAt: first.ChangedAt,
+		LastChangedAt:  last.ChangedAt,
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	err := store.Put(manifest.Batch, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.Put(backupKey(backupManifestPrefix, manifest.FromSeq, ".json"), js)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// readBackupManifests returns every manifest in the blob store in replay order.
+func readBackupManifests(store blob.Store) ([]*backupManifest, error) {
+	keys, err := store.List(backupManifestPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make([]*backupManifest, 0, len(keys))
+
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+
+		js, err := store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+
+		var manifest backupManifest
+		err = json.Unmarshal(js, &manifest)
+		if err != nil {
+			return nil, fmt.Errorf("reading manifest %s: %w", key, err)
+		}
+
+		manifests = append(manifests, &manifest)
+	}
+
+	return manifests, nil
+}
+
+// restoreChanges replays the backed up changes, in order, against the database. Changes made
+// after until are skipped, which gives point-in-time recovery; pass the zero time to replay
+// everything. Restore is intended to be run against an empty database (with the schema
+// migrated), but because every change is applied idempotently it is also safe to re-run. Each
+// batch is replayed in its own transaction, without being recorded in the change feed again,
+// and the change feed then carries on after the last backed up change, so that the next backup
+// continues the same sequence.
+func (app *application) restoreChanges(store blob.Store, until time.Time) error {
+	manifests, err := readBackupManifests(store)
+	if err != nil {
+		return err
+	}
+
+	applied, skipped := 0, 0
+	var lastSeq int64
+
+	for _, manifest := range manifests {
+		if !until.IsZero() && manifest.FirstChangedAt.After(until) {
+			break
+		}
+
+		if manifest.FromSeq <= lastSeq {
+			return fmt.Errorf("manifest %d overlaps previous batch ending at %d", manifest.FromSeq, lastSeq)
+		}
+
+		batch, err := store.Get(manifest.Batch)
+		if err != nil {
+			return fmt.Errorf("reading batch %s: %w", manifest.Batch, err)
+		}
+
+		sum := sha256.Sum256(batch)
+		if hex.EncodeToString(sum[:]) != manifest.SHA256 {
+			return errors.New("checksum mismatch for batch " + manifest.Batch)
+		}
+
+		batchApplied, batchSkipped, err := app.restoreBatch(manifest, batch, until)
+		if err != nil {
+			return err
+		}
+
+		applied += batchApplied
+		skipped += batchSkipped
+		lastSeq = manifest.ToSeq
+	}
+
+	err = app.models.Changes.ResetSequences(lastSeq)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("restore completed", map[string]string{
+		"changes": fmt.Sprint(applied),
+		"skipped": fmt.Sprint(skipped),
+		"to_seq":  fmt.Sprint(lastSeq),
+	})
+
+	return nil
+}
+
+// restoreBatch replays the changes of a single batch up to until in one transaction. It
+// returns how many changes were applied, and how many were skipped because the movie belonged
+// to an organization missing from the database, see data.ChangeRestore.Apply.
+func (app *application) restoreBatch(manifest *backupManifest, batch []byte, until time.Time) (int, int, error) {
+	restore, err := app.models.Changes.BeginRestore(restoreBatchTimeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer restore.Rollback()
+
+	applied, skipped := 0, 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(batch))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var change data.MovieChange
+
+		err := json.Unmarshal(scanner.Bytes(), &change)
+		if err != nil {
+			return 0, 0, fmt.Errorf("reading batch %s: %w", manifest.Batch, err)
+		}
+
+		if !until.IsZero() && change.ChangedAt.After(until) {
+			break
+		}
+
+		ok, err := restore.Apply(&change)
+		if err != nil {
+			return 0, 0, fmt.Errorf("applying change %d: %w", change.Seq, err)
+		}
+
+		if ok {
+			applied++
+		} else {
+			skipped++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	err = restore.Commit()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return applied, skipped, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/blob"
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+)
+
+func TestRestoreIntoFreshSchema(t *testing.T) {
+	db := openTestSchema(t)
+
+	app := newTestApp()
+	app.logger = jsonlog.NewLogger(io.Discard, jsonlog.LevelOff)
+	app.models = data.NewModels(db, nil)
+
+	store, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The backup refers to a user and an organization, neither of which are backed up.
+	changedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := func(id int64, title string, version int, createdBy, orgID interface{}) json.RawMessage {
+		js, err := json.Marshal(map[string]interface{}{
+			"id": id, "created_at": changedAt, "title": title, "year": 2016, "runtime": 107,
+			"genres": []string{"animation"}, "certification": "", "version": version,
+			"created_by": createdBy, "org_id": orgID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return js
+	}
+
+	changes := []*data.MovieChange{
+		{Seq: 10, MovieID: 1, Op: data.ChangeOpInsert, Data: snapshot(1, "Moana", 1, 99, nil)},
+		{Seq: 11, MovieID: 2, Op: data.ChangeOpInsert, Data: snapshot(2, "Private", 1, nil, 5)},
+		{Seq: 12, MovieID: 3, Op: data.ChangeOpInsert, Data: snapshot(3, "Deleted", 1, nil, nil)},
+		{Seq: 13, MovieID: 1, Op: data.ChangeOpUpdate, Data: snapshot(1, "Moana 2", 2, 99, nil)},
+		{Seq: 14, MovieID: 3, Op: data.ChangeOpDelete, Data: snapshot(3, "Deleted", 1, nil, nil)},
+	}
+	for _, change := range changes {
+		change.ChangedAt = changedAt
+	}
+
+	_, err = writeBackupBatch(store, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.restoreChanges(store, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		count     int
+		title     string
+		createdBy sql.NullInt64
+	)
+	err = db.QueryRow(`SELECT COUNT(*), MAX(title), MAX(created_by) FROM movies`).Scan(&count, &title, &createdBy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || title != "Moana 2" || createdBy.Valid {
+		t.Errorf("got %d movies, %q created by %v; want only \"Moana 2\" created by no one", count, title, createdBy)
+	}
+
+	err = db.QueryRow(`SELECT COUNT(*) FROM movie_changes`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d changes recorded by the restore; want 0", count)
+	}
+
+	// Changes made after the restore carry on after the last backed up change.
+	_, err = db.Exec(`UPDATE movies SET title = 'Moana 3' WHERE id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seq int64
+	err = db.QueryRow(`SELECT change_seq FROM movie_changes`).Scan(&seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq <= 14 {
+		t.Errorf("got change_seq %d after the restore; want more than 14", seq)
+	}
+
+	// Restoring again is harmless.
+	err = app.restoreChanges(store, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+const (
+	// maxBatchSize is the largest number of movies a single batch request may change.
+	maxBatchSize = 500
+	// batchTimeout is how long a whole batch transaction may take.
+	batchTimeout = 30 * time.Second
+)
+
+// Status values reported for each item of a batch request.
+const (
+	batchItemUpdated = "updated"
+	batchItemDeleted = "deleted"
+	batchItemFailed  = "failed"
+)
+
+// batchItemNotOwned is the error of the items naming a movie which the user, only holding the
+// movies:write:own permission, didn't create.
+const batchItemNotOwned = "you can only change the movies you created"
+
+// batchItemResult reports what happened to a single movie in a batch request.
+type batchItemResult struct {
+	ID     int64       `json:"id"`
+	Status string      `json:"status"`
+	Error  interface{} `json:"error,omitempty"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+}
+
+// batchUpdateMoviesHandler handles "PATCH /v1/movies/batch". It takes a list of partial movie
+// updates, in the same format as "PATCH /v1/movies/:id" plus the movie id and an optional
+// expected version, and applies them in a single transaction. Either every update is committed
+// or, if any of them fails, none are; the response lists the result for every item either way.
+func (app *application) batchUpdateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Movies []struct {
+			ID            int64               `json:"id"`
+			Version       *int32              `json:"version"`
+			Title         *string             `json:"title"`
+			Year          *int32              `json:"year"`
+			Runtime       *data.Runtime       `json:"runtime"`
+			Genres        []string            `json:"genres"`
+			Certification *data.Certification `json:"certification"`
+		} `json:"movies"`
+	}
+
+	err := app.readJSONWithLimits(w, r, &input, app.config.json.withMaxArrayLength(maxBatchSize))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ids := make([]int64, len(input.Movies))
+	for i, item := range input.Movies {
+		ids[i] = item.ID
+	}
+
+	v := validator.New()
+	if validateBatchIDs(v, "movies", "id", ids); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Users with the movies:write:own permission may only update the movies they created.
+	owner, err := app.movieOwner(r, app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	batch, err := app.catalogue(r).OwnedBy(owner).BeginBatch(batchTimeout)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer batch.Rollback()
+
+	err = batch.Lock(ids)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	results := make([]batchItemResult, len(input.Movies))
+	failed := false
+
+	for i, item := range input.Movies {
+		results[i].ID = item.ID
+
+		movie, err := batch.Get(item.ID)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			results[i].Status, results[i].Error = batchItemFailed, "movie not found"
+			failed = true
+			continue
+		}
+
+		if !ownsMovie(owner, movie) {
+			results[i].Status, results[i].Error = batchItemFailed, batchItemNotOwned
+			failed = true
+			continue
+		}
+
+		if item.Version != nil && *item.Version != movie.Version {
+			results[i].Status, results[i].Error = batchItemFailed, "edit conflict"
+			failed = true
+			continue
+		}
+
+		if item.Title != nil {
+			movie.Title = *item.Title
+		}
+		if item.Year != nil {
+			movie.Year = *item.Year
+		}
+		if item.Runtime != nil {
+			movie.Runtime = *item.Runtime
+		}
+		if item.Genres != nil {
+			movie.Genres = item.Genres
+		}
+		if item.Certification != nil {
+			movie.Certification = *item.Certification
+		}
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie); !v.Valid() {
+			results[i].Status, results[i].Error = batchItemFailed, v.Errors
+			failed = true
+			continue
+		}
+
+		// Once an item has failed the batch will be rolled back, so we only keep validating
+		// the remaining items to report every problem in one response.
+		if failed {
+			continue
+		}
+
+		err = batch.Update(movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		results[i].Status, results[i].Movie = batchItemUpdated, movie
+	}
+
+	app.finishBatch(w, r, batch, results, failed)
+}
+
+// batchDeleteMoviesHandler handles "DELETE /v1/movies/batch". It takes a list of movie ids and
+// deletes them in a single transaction: if any of the movies doesn't exist, nothing is deleted.
+func (app *application) batchDeleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IDs []int64 `json:"ids"`
+	}
+
+	err := app.readJSONWithLimits(w, r, &input, app.config.json.withMaxArrayLength(maxBatchSize))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if validateBatchIDs(v, "ids", "", input.IDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Users with the movies:write:own permission may only delete the movies they created.
+	owner, err := app.movieOwner(r, app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	batch, err := app.catalogue(r).OwnedBy(owner).BeginBatch(batchTimeout)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer batch.Rollback()
+
+	err = batch.Lock(input.IDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	results := make([]batchItemResult, len(input.IDs))
+	failed := false
+
+	for i, id := range input.IDs {
+		results[i].ID = id
+
+		// The movies of other users would only be reported as not found by Delete, so they are
+		// looked up first to tell the user why.
+		if owner != 0 {
+			movie, err := batch.Get(id)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if err == nil && !ownsMovie(owner, movie) {
+				results[i].Status, results[i].Error = batchItemFailed, batchItemNotOwned
+				failed = true
+				continue
+			}
+		}
+
+		err := batch.Delete(id)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			results[i].Status, results[i].Error = batchItemFailed, "movie not found"
+			failed = true
+			continue
+		}
+
+		results[i].Status = batchItemDeleted
+	}
+
+	app.finishBatch(w, r, batch, results, failed)
+}
+
+// finishBatch commits the batch if no item failed and sends the per-item results. If any item
+// failed the batch is rolled back and the results are sent with a 422 status code, so that the
+// client can see which items need fixing.
+func (app *application) finishBatch(w http.ResponseWriter, r *http.Request, batch *data.MovieBatch,
+	results []batchItemResult, failed bool) {
+	status := http.StatusOK
+
+	if failed {
+		err := batch.Rollback()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		status = http.StatusUnprocessableEntity
+	} else {
+		err := batch.Commit()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, result := range results {
+			switch result.Status {
+			case batchItemUpdated:
+				app.publishEvent(data.EventMovieUpdated, envelope{"movie": result.Movie})
+			case batchItemDeleted:
+				app.publishEvent(data.EventMovieDeleted, envelope{"movie": envelope{"id": result.ID}})
+			}
+		}
+	}
+
+	err := app.writeResponse(w, r, status, envelope{"committed": !failed, "results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateBatchIDs checks that a batch request names between 1 and maxBatchSize movies, with
+// no movie named twice. The errors about an ID are keyed by its element of the array at key, or
+// by the field of that element when the IDs are in objects, e.g. "movies[2].id".
+func validateBatchIDs(v *validator.Validator, key, field string, ids []int64) {
+	v.Check(len(ids) > 0, key, "must contain at least 1 movie")
+	v.Check(len(ids) <= maxBatchSize, key, fmt.Sprintf("must not contain more than %d movies", maxBatchSize))
+
+	seen := make(map[int64]bool, len(ids))
+	for i, id := range ids {
+		idKey := validator.Field(validator.Index(key, i), field)
+		v.Check(id > 0, idKey, "must be a positive movie id")
+		v.Check(!seen[id], idKey, "must not name the same movie more than once")
+		seen[id] = true
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+func TestValidateBatchIDs(t *testing.T) {
+	v := validator.New()
+	validateBatchIDs(v, "movies", "id", []int64{4, 0, 4})
+
+	want := map[string]string{
+		"movies[1].id": "must be a positive movie id",
+		"movies[2].id": "must not name the same movie more than once",
+	}
+	if !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("got  %v\nwant %v", v.Errors, want)
+	}
+
+	v = validator.New()
+	validateBatchIDs(v, "ids", "", []int64{-1})
+	if want := map[string]string{"ids[0]": "must be a positive movie id"}; !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("got  %v\nwant %v", v.Errors, want)
+	}
+
+	v = validator.New()
+	validateBatchIDs(v, "ids", "", nil)
+	if want := map[string]string{"ids": "must contain at least 1 movie"}; !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("got  %v\nwant %v", v.Errors, want)
+	}
+}
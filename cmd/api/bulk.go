@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+const (
+	// bulkConfirmationTTL is how long the confirmation token from a dry-run stays valid.
+	bulkConfirmationTTL = 10 * time.Minute
+	// bulkDeleteBatchSize is the number of movies deleted per statement by a bulk delete.
+	bulkDeleteBatchSize = 100
+)
+
+// bulkDeleteMoviesHandler handles "POST /v1/movies/bulk-delete". It accepts the same title and
+// genres filters as the movie listing, and works in two steps:
+//
+//  1. With "dry_run": true it responds with the number of movies that would be deleted and a
+//     confirmation token, without deleting anything.
+//  2. With the same filters and "confirmation_token" set to that token, it starts deleting the
+//     movies in a background goroutine and responds with a 202 Accepted status code and the
+//     bulk operation, whose progress can be followed at "GET /v1/bulk-operations/:id".
+func (app *application) bulkDeleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title             string   `json:"title"`
+		Genres            []string `json:"genres"`
+		DryRun            bool     `json:"dry_run"`
+		ConfirmationToken string   `json:"confirmation_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	filters := data.MovieFilters{Title: input.Title, Genres: input.Genres, OrgID: app.orgID(r)}
+	if filters.Genres == nil {
+		filters.Genres = []string{}
+	}
+
+	user := app.contextGetUser(r)
+	v := validator.New()
+
+	// Refuse to delete the whole catalogue through this endpoint.
+	v.Check(filters.Title != "" || len(filters.Genres) > 0, "filters", "at least one of title or genres must be provided")
+
+	if !input.DryRun {
+		data.ValidateTokenPlaintext(v, input.ConfirmationToken)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	total, maxID, err := app.catalogue(r).Primary().CountForDelete(filters.Title, filters.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if input.DryRun {
+		op := &data.BulkOperation{
+			UserID:     user.ID,
+			Kind:       data.BulkKindMovieDelete,
+			Filters:    filters,
+			Total:      total,
+			MaxMovieID: maxID,
+		}
+
+		token, err := app.models.BulkOps.InsertPreview(op, bulkConfirmationTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{"preview": map[string]interface{}{
+			"count":              total,
+			"confirmation_token": token.Plaintext,
+			"expiry":             token.Expiry,
+		}}
+
+		err = app.writeResponse(w, r, http.StatusOK, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	op, err := app.models.BulkOps.StartForToken(user.ID, data.BulkKindMovieDelete, input.ConfirmationToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("confirmation_token", "invalid or expired confirmation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The token only confirms the exact filters of the preview. If more movies match now than
+	// when the preview was made, the editor has to look at the new count first.
+	var conflict error
+	switch {
+	case !op.Filters.Equal(filters):
+		conflict = errors.New("filters don't match the previewed filters")
+	case total > op.Total:
+		conflict = fmt.Errorf("%d movies match the filters now, but only %d were previewed", total, op.Total)
+	}
+
+	if conflict != nil {
+		if err := app.models.BulkOps.Finish(op.ID, 0, conflict); err != nil {
+			app.logger.PrintError(err, nil)
+		}
+		app.errorResponse(w, r, http.StatusConflict, conflict.Error()+", please run a new dry-run")
+		return
+	}
+
+	app.logger.PrintInfo("bulk delete started", map[string]string{
+		"operation_id": strconv.FormatInt(op.ID, 10),
+		"user_id":      strconv.FormatInt(user.ID, 10),
+		"title":        filters.Title,
+		"count":        strconv.Itoa(op.Total),
+	})
+
+	app.background(func() {
+		app.runBulkDelete(op)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/bulk-operations/%d", op.ID))
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"operation": op}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runBulkDelete deletes the movies selected by a bulk operation in batches, recording its
+// progress after every batch. The confirmation token only covers the movies previewed by the
+// dry-run, so only the movies up to the highest id it counted are deleted, leaving the movies
+// created since alone, and no more than the previewed total.
+func (app *application) runBulkDelete(op *data.BulkOperation) {
+	var (
+		processed int
+		opErr     error
+	)
+
+	for processed < op.Total {
+		limit := bulkDeleteBatchSize
+		if remaining := op.Total - processed; remaining < limit {
+			limit = remaining
+		}
+
+		ids, err := app.models.Movies.InOrg(op.Filters.OrgID).DeleteBatch(op.Filters.Title, op.Filters.Genres, op.MaxMovieID, limit)
+		if err != nil {
+			opErr = err
+			break
+		}
+
+		if len(ids) == 0 {
+			break
+		}
+
+		for _, id := range ids {
+			app.publishEvent(data.EventMovieDeleted, envelope{"movie": envelope{"id": id}})
+		}
+
+		processed += len(ids)
+
+		if err := app.models.BulkOps.UpdateProgress(op.ID, processed); err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+
+	if err := app.models.BulkOps.Finish(op.ID, processed, opErr); err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	properties := map[string]string{
+		"operation_id": strconv.FormatInt(op.ID, 10),
+		"processed":    strconv.Itoa(processed),
+	}
+
+	if opErr != nil {
+		app.logger.PrintError(opErr, properties)
+		return
+	}
+
+	app.logger.PrintInfo("bulk delete completed", properties)
+}
+
+// showBulkOperationHandler handles "GET /v1/bulk-operations/:id" and returns the bulk operation
+// along with its progress.
+func (app *application) showBulkOperationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	op, err := app.models.BulkOps.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"operation": op}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
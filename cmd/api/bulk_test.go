@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestBulkDeleteRequiresConfirmationToken(t *testing.T) {
+	app := newTestApp()
+	user := &data.User{ID: 1, Activated: true}
+
+	// Without a dry-run, the request is rejected before anything is counted, so the test app
+	// needs no database.
+	for _, body := range []string{
+		`{"title": "Moana"}`,
+		`{"title": "Moana", "confirmation_token": "guess"}`,
+	} {
+		status, js := serveAs(t, app, app.bulkDeleteMoviesHandler, user, http.MethodPost, nil, body)
+		if status != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d; want %d", body, status, http.StatusUnprocessableEntity)
+		}
+		if !strings.Contains(js, `"token"`) {
+			t.Errorf("%s: got %s; want an error about the confirmation token", body, js)
+		}
+	}
+}
+
+func TestBulkDeleteOnlyDeletesPreviewedMovies(t *testing.T) {
+	app := newDBTestApp(t)
+	editor := insertTestUser(t, app, "Editor", "movies:write")
+
+	first := insertTestMovie(t, app, "Moana")
+	second := insertTestMovie(t, app, "Moana")
+	other := insertTestMovie(t, app, "Up")
+
+	status, js := serveAs(t, app, app.bulkDeleteMoviesHandler, editor, http.MethodPost, nil, `{"title": "Moana", "dry_run": true}`)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d for the dry-run: %s", status, js)
+	}
+
+	var response struct {
+		Preview struct {
+			Count             int    `json:"count"`
+			ConfirmationToken string `json:"confirmation_token"`
+		} `json:"preview"`
+	}
+	err := json.Unmarshal([]byte(js), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Preview.Count != 2 {
+		t.Fatalf("got a preview of %d movies; want 2", response.Preview.Count)
+	}
+
+	// Replace a previewed movie with a new one, which keeps the number of matching movies, so
+	// only the bound keeps the new movie out of the delete.
+	err = app.models.Movies.Delete(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := insertTestMovie(t, app, "Moana")
+
+	status, _ = serveAs(t, app, app.bulkDeleteMoviesHandler, editor, http.MethodPost, nil, `{"title": "Moana", "confirmation_token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for a wrong confirmation token; want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	confirm := fmt.Sprintf(`{"title": "Moana", "confirmation_token": %q}`, response.Preview.ConfirmationToken)
+	status, js = serveAs(t, app, app.bulkDeleteMoviesHandler, editor, http.MethodPost, nil, confirm)
+	if status != http.StatusAccepted {
+		t.Fatalf("got status %d confirming the bulk delete: %s", status, js)
+	}
+	app.wg.Wait()
+
+	// The token can only be used once.
+	status, _ = serveAs(t, app, app.bulkDeleteMoviesHandler, editor, http.MethodPost, nil, confirm)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d reusing the confirmation token; want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	tests := []struct {
+		name    string
+		id      int64
+		deleted bool
+	}{
+		{"previewed movie", second, true},
+		{"movie added after the dry-run", added, false},
+		{"movie not matching the filters", other, false},
+	}
+
+	for _, tt := range tests {
+		_, err := app.models.Movies.Get(tt.id)
+		if got := errors.Is(err, data.ErrRecordNotFound); got != tt.deleted {
+			t.Errorf("%s: got deleted %t; want %t (%v)", tt.name, got, tt.deleted, err)
+		}
+	}
+}
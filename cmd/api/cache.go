@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// cacheClass describes how responses from a group of routes may be cached by browsers and
+// shared caches such as CDNs. The class is chosen per route in routes.go, and the policy for
+// each class is configured centrally with command-line flags.
+type cacheClass int
+
+const (
+	// cacheCatalogue is for reads of the published movie catalogue. Responses to anonymous
+	// and trial users are the same for everyone and may be stored by shared caches; responses
+	// to signed-in users may include personal data (such as their notes), so only their own
+	// browser may store them.
+	cacheCatalogue cacheClass = iota
+	// cacheNoStore is for user and authentication endpoints, whose responses contain personal
+	// data or credentials and must never be stored anywhere.
+	cacheNoStore
+	// cachePublicStats is for the public statistics, which are the same for everyone and only
+	// change when the statistics are refreshed. Shared caches may store them for a whole
+	// refresh interval, and keep serving a stale copy for another while they revalidate it.
+	cachePublicStats
+)
+
+// cacheStateContextKey is used to share a cacheState between the cacheControl() middleware and
+// the code that serves a response from the server-side cache.
+const cacheStateContextKey = contextKey("cacheState")
+
+// cacheState records when the response being served was originally generated, if it came from
+// the server-side cache rather than being generated for this request.
+type cacheState struct {
+	storedAt time.Time
+}
+
+// markServedFromCache tells the cacheControl() middleware that the response is being served
+// from the server-side cache and was generated at storedAt, so that the Age and Expires
+// headers reflect the age of the cached copy rather than the time of this request.
+func (app *application) markServedFromCache(r *http.Request, storedAt time.Time) {
+	if state, ok := r.Context().Value(cacheStateContextKey).(*cacheState); ok {
+		state.storedAt = storedAt
+	}
+}
+
+// cacheControl sets the Cache-Control header for the given route class. The headers are set
+// just before the status code is written, so error responses can be made uncacheable even on
+// routes whose successful responses may be cached.
+func (app *application) cacheControl(class cacheClass, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := &cacheState{}
+		r = r.WithContext(context.WithValue(r.Context(), cacheStateContextKey, state))
+
+		// setExpiry sets the Age and Expires headers, based on when the response was generated.
+		setExpiry := func(maxAge time.Duration) {
+			h := w.Header()
+
+			generatedAt := time.Now()
+			if !state.storedAt.IsZero() {
+				generatedAt = state.storedAt
+				h.Set("Age", fmt.Sprint(int(time.Since(state.storedAt).Seconds())))
+			}
+			h.Set("Expires", generatedAt.Add(maxAge).UTC().Format(http.TimeFormat))
+		}
+
+		written := false
+		setHeaders := func(status int) {
+			if written {
+				return
+			}
+			written = true
+
+			h := w.Header()
+
+			if class == cacheNoStore {
+				h.Set("Cache-Control", "no-store")
+				return
+			}
+
+			// Only successful reads are cacheable. Everything else, including errors on
+			// catalogue routes, must not be stored so that a transient failure isn't served
+			// from a cache after it has been fixed.
+			if (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+				(status != http.StatusOK && status != http.StatusNotModified) {
+				h.Set("Cache-Control", "no-store")
+				return
+			}
+
+			if class == cachePublicStats {
+				maxAge := app.config.stats.refresh
+				h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=%d",
+					int(maxAge.Seconds()), int(maxAge.Seconds())))
+				setExpiry(maxAge)
+				return
+			}
+
+			maxAge := app.config.cache.catalogueMaxAge
+			scope := "public"
+			if user := app.contextGetUser(r); !user.IsAnonymous() && !user.IsTrial() {
+				scope = "private"
+			}
+			h.Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+			setExpiry(maxAge)
+		}
+
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					setHeaders(code)
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					setHeaders(http.StatusOK)
+					return next(b)
+				}
+			},
+		})
+
+		next(w, r)
+	}
+}
+
+// noStorePrefixes are the path prefixes of the user, token and authentication endpoints. Their
+// responses contain credentials or personal data, and must never be stored by a cache, whatever
+// the individual handlers or route classes say.
+var noStorePrefixes = []string{"/v1/users", "/v1/tokens"}
+
+// isNoStorePath reports whether path is one of the endpoints under noStorePrefixes.
+func isNoStorePath(path string) bool {
+	for _, prefix := range noStorePrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enforceNoStore makes every response from the endpoints under noStorePrefixes uncacheable. It
+// sets "Cache-Control: no-store" and removes all validators and expiry headers just before the
+// status code is written, so it also covers error responses from other middleware and routes
+// which were registered without cacheControl(cacheNoStore). A validator such as an ETag would
+// let a cache revalidate, and so keep, a stored copy.
+func (app *application) enforceNoStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isNoStorePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		written := false
+		setHeaders := func() {
+			if written {
+				return
+			}
+			written = true
+
+			h := w.Header()
+			h.Set("Cache-Control", "no-store")
+			for _, name := range []string{"ETag", "Last-Modified", "Expires", "Age"} {
+				h.Del(name)
+			}
+		}
+
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					setHeaders()
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					setHeaders()
+					return next(b)
+				}
+			},
+		})
+
+		next.ServeHTTP(w, r)
+	})
+}
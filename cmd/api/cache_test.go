@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsNoStorePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/v1/tokens/authentication", true},
+		{"/v1/tokens/trial", true},
+		{"/v1/users", true},
+		{"/v1/users/activated", true},
+		{"/v1/users/7/lists", true},
+		{"/v1/usersettings", false},
+		{"/v1/movies/1", false},
+		{"/v1/healthcheck", false},
+	}
+
+	for _, tt := range tests {
+		if got := isNoStorePath(tt.path); got != tt.want {
+			t.Errorf("isNoStorePath(%q): want %t; got %t", tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestEnforceNoStore(t *testing.T) {
+	app := newTestApp()
+
+	// A misbehaving handler which marks its response as cacheable and adds validators.
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Header().Set("ETag", `"token-1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Header().Set("Expires", "Mon, 02 Jan 2006 16:04:05 GMT")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"authentication_token":{}}`))
+	})
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/v1/tokens/authentication"},
+		{http.MethodPost, "/v1/users"},
+		{http.MethodGet, "/v1/users/7/lists"},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.enforceNoStore(next).ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+
+		h := rr.Result().Header
+		if got := h.Get("Cache-Control"); got != "no-store" {
+			t.Errorf("%s %s: want Cache-Control no-store; got %q", tt.method, tt.path, got)
+		}
+		for _, name := range []string{"ETag", "Last-Modified", "Expires"} {
+			if got := h.Get(name); got != "" {
+				t.Errorf("%s %s: want no %s header; got %q", tt.method, tt.path, name, got)
+			}
+		}
+	}
+
+	// Other endpoints keep their own caching headers.
+	rr := httptest.NewRecorder()
+	app.enforceNoStore(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil))
+
+	if got := rr.Result().Header.Get("ETag"); got != `"token-1"` {
+		t.Errorf("GET /v1/movies/1: want ETag to be kept; got %q", got)
+	}
+}
+
+func TestEnforceNoStoreImplicitWriteHeader(t *testing.T) {
+	app := newTestApp()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"user-1"`)
+		w.Write([]byte(`{}`))
+	})
+
+	rr := httptest.NewRecorder()
+	app.enforceNoStore(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/users/1", nil))
+
+	h := rr.Result().Header
+	if h.Get("Cache-Control") != "no-store" || h.Get("ETag") != "" {
+		t.Errorf("want no-store and no ETag; got Cache-Control %q, ETag %q", h.Get("Cache-Control"), h.Get("ETag"))
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/configfile"
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+	"github.com/saalikmubeen/greenlight/internal/secrets"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// command is a subcommand of the api binary, such as "api migrate up". Each has its own flag
+// set, which for the commands that need the server configuration also holds the configuration
+// flags.
+type command struct {
+	name string
+	// args describes the positional arguments in the usage message, e.g. "up|down [n]".
+	args    string
+	summary string
+	// config is set for the commands which need the server configuration. They accept every
+	// configuration flag and -print-config, and read the settings from the environment and
+	// the configuration file the same way as serve.
+	config bool
+	// flags defines the command's own flags on fs. They can only be given on the command line.
+	flags func(fs *flag.FlagSet)
+	// run runs the command with its positional arguments. cfg and logger are only set for the
+	// commands with config set.
+	run func(cfg config, logger *jsonlog.Logger, args []string) error
+}
+
+// commands lists the subcommands, in the order they're listed in the usage message.
+var commands = []*command{
+	serveCommand(),
+	migrateCommand(),
+	seedCommand(),
+	createUserCommand(),
+	createAdminCommand(),
+	versionCommand(),
+}
+
+// defaultCommand is run when the arguments don't start with the name of a command, so that
+// "api -port=4000" still starts the server.
+const defaultCommand = "serve"
+
+// runCommand runs the command named by the first of args with the rest of them, and returns
+// the exit status of the process.
+func runCommand(args []string) int {
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		printUsage(os.Stdout, nil, nil)
+		return 0
+	}
+
+	var cmd *command
+	for _, c := range commands {
+		if c.name == name {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage(os.Stderr, nil, nil)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("api "+cmd.name, flag.ContinueOnError)
+	fs.Usage = func() { printUsage(fs.Output(), cmd, fs) }
+
+	var cfg config
+	var printEffectiveConfig *bool
+	if cmd.config {
+		registerConfigFlags(fs, &cfg)
+		printEffectiveConfig = fs.Bool("print-config", false, "Print the effective configuration, with secrets redacted, and exit")
+	}
+
+	// Every flag defined from here on is one of the command's own.
+	configFlags := make(map[string]bool)
+	fs.VisitAll(func(f *flag.Flag) {
+		configFlags[f.Name] = true
+	})
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	ownFlags := append([]string(nil), commandLineOnly...)
+	fs.VisitAll(func(f *flag.Flag) {
+		if !configFlags[f.Name] {
+			ownFlags = append(ownFlags, f.Name)
+		}
+	})
+
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+
+	if !cmd.config {
+		if err := cmd.run(cfg, nil, fs.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	if err := loadConfig(fs, &cfg, ownFlags); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	settings := effectiveConfig(fs, cfg)
+	for _, name := range ownFlags {
+		delete(settings, name)
+	}
+
+	if *printEffectiveConfig {
+		if err := printConfig(os.Stdout, settings); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	// Apply the email normalization policy before anything can register or look up a user.
+	data.EmailNormalization = data.EmailPolicy{
+		Lowercase:        cfg.email.lowercase,
+		FoldGmailAliases: cfg.email.foldGmailAliases,
+	}
+
+	// Apply the token hashing before any token is issued or looked up.
+	data.TokenHashing = tokenHasher(cfg)
+
+	// Initialize a new jsonlog.Logger which writes any messages *at or above* the
+	// -log-level severity level to the -log-output, the standard out stream by default.
+	out, err := logOutput(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logLevel, _ := jsonlog.ParseLevel(cfg.live.logLevel)
+	logger := jsonlog.NewLogger(out, logLevel)
+	logger.SetRedactedKeys(cfg.log.redactKeys)
+
+	// Forward the entries to the log store, if there is one, until the command is done.
+	if cfg.log.export != "" {
+		exporter := newLogExporter(cfg)
+		logger.SetExporter(exporter)
+		expvar.Publish("log_export", expvar.Func(func() interface{} {
+			return exporter.Stats()
+		}))
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := exporter.Shutdown(ctx); err != nil {
+				fmt.Fprintln(os.Stderr, "log export:", err)
+			}
+		}()
+	}
+
+	// Make the logger the default slog handler, so that the libraries logging with log/slog, or
+	// with the log package, which then writes through slog at the INFO level, end up in the
+	// same JSON log.
+	slog.SetDefault(slog.New(logger.Handler()))
+
+	logger.PrintInfo("effective configuration", settings)
+
+	// Read the credentials kept in Vault, and keep their leases renewed until the command is
+	// done.
+	if cfg.vault.addr != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		vault := secrets.NewVault(cfg.vault.addr, cfg.vault.token, cfg.vault.namespace)
+		leased, err := loadSecrets(ctx, vault, &cfg)
+		if err != nil {
+			logger.PrintError(err, nil)
+			return 1
+		}
+		for _, secret := range leased {
+			go renewLease(ctx, vault, secret, logger)
+		}
+	}
+
+	if err := cmd.run(cfg, logger, fs.Args()); err != nil {
+		logger.PrintError(err, nil)
+		return 1
+	}
+	return 0
+}
+
+// logOutput returns where the log entries are written. The connection to the system log or
+// journald stays open until the process exits.
+func logOutput(cfg config) (io.Writer, error) {
+	switch cfg.log.output {
+	case "syslog":
+		w, err := jsonlog.NewSyslog("greenlight")
+		if err != nil {
+			return nil, fmt.Errorf("log output: %w", err)
+		}
+		return w, nil
+	case "journald":
+		w, err := jsonlog.NewJournal("greenlight")
+		if err != nil {
+			return nil, fmt.Errorf("log output: %w", err)
+		}
+		return w, nil
+	default:
+		return os.Stdout, nil
+	}
+}
+
+// newLogExporter returns the exporter forwarding the log entries to -log-export-url, labelled
+// with the service name and the environment.
+func newLogExporter(cfg config) *jsonlog.Exporter {
+	if cfg.log.export == "otlp" {
+		return jsonlog.NewOTLPExporter(cfg.log.exportURL, map[string]string{
+			"service.name":           "greenlight",
+			"service.version":        version,
+			"deployment.environment": cfg.env,
+		}, cfg.log.exportBuffer)
+	}
+
+	return jsonlog.NewLokiExporter(cfg.log.exportURL, map[string]string{
+		"service": "greenlight",
+		"env":     cfg.env,
+	}, cfg.log.exportBuffer)
+}
+
+// loadConfig completes the configuration parsed from the command line into fs and cfg with the
+// environment and the configuration file, and validates it. ownFlags are the flags which are
+// only read from the command line.
+func loadConfig(fs *flag.FlagSet, cfg *config, ownFlags []string) error {
+	// In development, the environment variables below can be kept in a .env file rather than
+	// exported by hand. Only -env and GREENLIGHT_ENV can select the environment at this point,
+	// so a deployment which sets either to anything else never reads the file.
+	env := os.Getenv(configfile.EnvName("GREENLIGHT", "env"))
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "env" {
+			env = cfg.env
+		}
+	})
+	if env == "" {
+		env = cfg.env
+	}
+
+	if env == "development" {
+		err := configfile.LoadDotenv(".env")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Every flag can also be set with an environment variable named after it, e.g.
+	// GREENLIGHT_DB_DSN for -db-dsn, which is the usual way to pass secrets such as the
+	// database and SMTP passwords. Flags given on the command line override the environment,
+	// which overrides the configuration file, which overrides the defaults.
+	err := configfile.LoadEnv(fs, "GREENLIGHT", ownFlags...)
+	if err != nil {
+		return err
+	}
+
+	cfg.pinned = make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		cfg.pinned[f.Name] = f.Value.String()
+	})
+
+	if cfg.file != "" {
+		err := configfile.Load(fs, cfg.file, append(ownFlags, "config")...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The secrets given as files are read last, so that the file flags can come from any of
+	// the sources above.
+	err = configfile.LoadFiles(fs, fileFlags...)
+	if err != nil {
+		return err
+	}
+
+	// Check the assembled configuration before anything is started, so that a mistake is
+	// reported up front rather than as a failure later on.
+	v := validator.New()
+	if validateConfig(v, *cfg); !v.Valid() {
+		return errors.New(configErrors(v.Errors))
+	}
+
+	return nil
+}
+
+// printUsage writes the usage message of cmd, with its flags from fs, or the list of commands
+// if cmd is nil.
+func printUsage(w io.Writer, cmd *command, fs *flag.FlagSet) {
+	if cmd != nil {
+		fmt.Fprintf(w, "Usage: api %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+		return
+	}
+
+	fmt.Fprintf(w, "Usage: api <command> [flags] [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nThe command defaults to %s. Run \"api <command> -h\" for the flags of a command.\n", defaultCommand)
+}
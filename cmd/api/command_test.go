@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseMigrateArgs(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    int
+		wantErr bool
+	}{
+		{"up", 0, false},
+		{"down", 1, false},
+		{"down 3", 3, false},
+		{"down 0", 0, true},
+		{"down all", 0, true},
+		{"up 2", 0, true},
+		{"", 0, true},
+		{"sideways", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMigrateArgs(strings.Fields(tt.args))
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseMigrateArgs(%q) = %d, %v; want %d, error %t", tt.args, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRunCommandStatus(t *testing.T) {
+	tests := map[string]int{
+		"help":            0,
+		"version":         0,
+		"bogus":           2,
+		"serve -no-such":  2,
+		"migrate -h":      0,
+		"version -x":      2,
+		"createuser -bad": 2,
+		"create-admin -h": 0,
+	}
+
+	for args, want := range tests {
+		if got := runCommand(strings.Fields(args)); got != want {
+			t.Errorf("runCommand(%q) = %d; want %d", args, got, want)
+		}
+	}
+}
+
+func TestReadPassword(t *testing.T) {
+	for input, want := range map[string]string{
+		"pa55word\n":         "pa55word",
+		"pa55word\r\nmore\n": "pa55word",
+		"pa55word":           "pa55word",
+		"":                   "",
+	} {
+		got, err := readPassword(strings.NewReader(input))
+		if err != nil || got != want {
+			t.Errorf("readPassword(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("admin@example.com\npa55word\n"))
+	var w bytes.Buffer
+
+	email, err := prompt(r, &w, "Email: ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, err := prompt(r, &w, "Password: ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if email != "admin@example.com" || password != "pa55word" {
+		t.Errorf("got %q and %q; want each prompt to read its own line", email, password)
+	}
+	if got := w.String(); got != "Email: Password: " {
+		t.Errorf("got prompts %q", got)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	tests := map[string]struct {
+		status  schemaStatus
+		wantErr bool
+	}{
+		"current": {schemaStatus{Version: 23, Latest: 23}, false},
+		"newer":   {schemaStatus{Version: 24, Latest: 23}, false},
+		"pending": {schemaStatus{Version: 22, Latest: 23, Pending: true}, true},
+		"empty":   {schemaStatus{Version: 0, Latest: 23, Pending: true}, true},
+		"dirty":   {schemaStatus{Version: 23, Latest: 23, Dirty: true}, true},
+	}
+
+	for name, tt := range tests {
+		if err := checkSchema(tt.status); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v; want error %t", name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// hiddenCommentBody replaces the body of hidden comments for users who are not moderators, so
+// that replies to a hidden comment still make sense as a thread.
+const hiddenCommentBody = "[this comment has been hidden by a moderator]"
+
+// listCommentsHandler handles "GET /v1/movies/:id/comments" and returns a page of top-level
+// comments for the movie, each with its nested replies.
+// /v1/movies/1/comments?page=1&page_size=10&sort=-created_at
+func (app *application) listCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Make sure the movie exists, so that we return a 404 rather than an empty list for
+	// movies that were never created.
+	_, err = app.catalogue(r).Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", DEFAULT_PAGE, v)
+	filters.PageSize = app.readInt(qs, "page_size", DEFAULT_PAGE_SIZE, v)
+	filters.Sort = app.readStrings(qs, "sort", "created_at")
+	filters.SortSafeList = []string{"created_at", "-created_at"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := app.models.Comments.GetThreadsForMovie(movieID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	canModerate, err := app.userHasPermission(r, app.contextGetUser(r), "comments:moderate")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !canModerate {
+		redactHiddenComments(comments)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"comments": comments, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createCommentHandler handles "POST /v1/movies/:id/comments". Set "parent_id" in the request
+// body to reply to an existing comment on the same movie.
+func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Body     string `json:"body"`
+		ParentID *int64 `json:"parent_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	comment := &data.Comment{
+		MovieID:  movieID,
+		UserID:   app.contextGetUser(r).ID,
+		ParentID: input.ParentID,
+		Body:     input.Body,
+	}
+
+	v := validator.New()
+
+	if data.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.catalogue(r).Primary().Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Insert() returns ErrRecordNotFound if the parent comment doesn't exist on this movie.
+	err = app.models.Comments.Insert(comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("parent_id", "no matching comment found for this movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditAfter(r, "comments", comment.ID, comment)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/comments/%d", movieID, comment.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"comment": comment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateCommentHandler handles "PATCH /v1/movies/:id/comments/:comment_id". Authors can edit
+// the body of their own comments until the edit window closes. Moderators can edit any comment
+// at any time, and are the only ones allowed to change the "hidden" flag.
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.readCommentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Body   *string `json:"body"`
+		Hidden *bool   `json:"hidden"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	canModerate, err := app.userHasPermission(r, user, "comments:moderate")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !canModerate {
+		if comment.UserID != user.ID || input.Hidden != nil || comment.Deleted {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		if !comment.Editable(app.config.comments.editWindow) {
+			app.errorResponse(w, r, http.StatusForbidden, data.ErrEditWindowClosed.Error())
+			return
+		}
+	}
+
+	app.auditBefore(r, "comments", comment.ID, comment)
+
+	if input.Body != nil {
+		comment.Body = *input.Body
+	}
+
+	if input.Hidden != nil {
+		comment.Hidden = *input.Hidden
+	}
+
+	v := validator.New()
+
+	if data.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Comments.Update(comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditAfter(r, "comments", comment.ID, comment)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCommentHandler handles "DELETE /v1/movies/:id/comments/:comment_id". Moderators can
+// delete any comment, and its replies are deleted along with it. Authors can delete their own
+// comments, but those with replies are only emptied, so that other users' replies are kept, see
+// data.CommentModel.Withdraw.
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.readCommentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	canModerate, err := app.userHasPermission(r, user, "comments:moderate")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !canModerate && comment.UserID != user.ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	app.auditBefore(r, "comments", comment.ID, comment)
+
+	message := "comment successfully deleted"
+
+	if canModerate {
+		err = app.models.Comments.Delete(comment.MovieID, comment.ID)
+	} else {
+		var tombstoned bool
+		tombstoned, err = app.models.Comments.Withdraw(comment.MovieID, comment.ID)
+		if tombstoned {
+			message = "comment successfully deleted, its replies have been kept"
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readCommentFromPath reads the movie and comment ids from the URL and fetches the matching
+// comment. If anything goes wrong the error response is sent and ok is false.
+func (app *application) readCommentFromPath(w http.ResponseWriter, r *http.Request) (*data.Comment, bool) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	commentID, err := app.readNamedIDParam(r, "comment_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	comment, err := app.models.Comments.Get(movieID, commentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return comment, true
+}
+
+// redactHiddenComments walks the comment trees and blanks out the body of hidden comments.
+func redactHiddenComments(comments []*data.Comment) {
+	for _, comment := range comments {
+		if comment.Hidden {
+			comment.Body = hiddenCommentBody
+		}
+		redactHiddenComments(comment.Replies)
+	}
+}
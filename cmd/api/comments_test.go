@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+// commentParams returns the router parameters of the comment routes.
+func commentParams(movieID, commentID int64) httprouter.Params {
+	params := httprouter.Params{{Key: "id", Value: strconv.FormatInt(movieID, 10)}}
+	if commentID != 0 {
+		params = append(params, httprouter.Param{Key: "comment_id", Value: strconv.FormatInt(commentID, 10)})
+	}
+	return params
+}
+
+// postComment posts a comment as user and returns its id.
+func postComment(t *testing.T, app *application, user *data.User, movieID int64, body string) int64 {
+	t.Helper()
+
+	status, js := serveAs(t, app, app.createCommentHandler, user, http.MethodPost, commentParams(movieID, 0), body)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d posting %s: %s", status, body, js)
+	}
+
+	var response struct {
+		Comment data.Comment `json:"comment"`
+	}
+	err := json.Unmarshal([]byte(js), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response.Comment.ID
+}
+
+// listComments lists the comment threads of the movie as user.
+func listComments(t *testing.T, app *application, user *data.User, movieID int64) []*data.Comment {
+	t.Helper()
+
+	status, js := serveAs(t, app, app.listCommentsHandler, user, http.MethodGet, commentParams(movieID, 0), "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d listing comments: %s", status, js)
+	}
+
+	var response struct {
+		Comments []*data.Comment `json:"comments"`
+	}
+	err := json.Unmarshal([]byte(js), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response.Comments
+}
+
+func TestCommentThreads(t *testing.T) {
+	app := newDBTestApp(t)
+
+	alice := insertTestUser(t, app, "Alice")
+	bob := insertTestUser(t, app, "Bob")
+	movieID := insertTestMovie(t, app, "Moana")
+	otherMovieID := insertTestMovie(t, app, "Up")
+
+	rootID := postComment(t, app, alice, movieID, `{"body": "Great songs"}`)
+	replyID := postComment(t, app, bob, movieID, fmt.Sprintf(`{"body": "Agreed", "parent_id": %d}`, rootID))
+	postComment(t, app, alice, movieID, fmt.Sprintf(`{"body": "Especially the ocean one", "parent_id": %d}`, replyID))
+
+	// Replies must be to a comment on the same movie.
+	status, _ := serveAs(t, app, app.createCommentHandler, bob, http.MethodPost, commentParams(otherMovieID, 0),
+		fmt.Sprintf(`{"body": "Wrong movie", "parent_id": %d}`, rootID))
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d replying to a comment on another movie; want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	threads := listComments(t, app, bob, movieID)
+	if len(threads) != 1 || threads[0].ID != rootID {
+		t.Fatalf("got %d threads; want the one started by comment %d", len(threads), rootID)
+	}
+	if replies := threads[0].Replies; len(replies) != 1 || replies[0].ID != replyID || len(replies[0].Replies) != 1 {
+		t.Errorf("got replies %+v; want comment %d with one reply of its own", replies, replyID)
+	}
+}
+
+func TestCommentEditWindow(t *testing.T) {
+	app := newDBTestApp(t)
+	app.config.comments.editWindow = time.Hour
+
+	alice := insertTestUser(t, app, "Alice")
+	bob := insertTestUser(t, app, "Bob")
+	moderator := insertTestUser(t, app, "Moderator", "comments:moderate")
+	movieID := insertTestMovie(t, app, "Moana")
+
+	commentID := postComment(t, app, alice, movieID, `{"body": "Great songs"}`)
+	params := commentParams(movieID, commentID)
+
+	tests := []struct {
+		name   string
+		user   *data.User
+		body   string
+		status int
+	}{
+		{"author within the window", alice, `{"body": "Great songs!"}`, http.StatusOK},
+		{"another user", bob, `{"body": "Bad songs"}`, http.StatusForbidden},
+		{"author hiding", alice, `{"hidden": true}`, http.StatusForbidden},
+		{"moderator hiding", moderator, `{"hidden": true}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		status, js := serveAs(t, app, app.updateCommentHandler, tt.user, http.MethodPatch, params, tt.body)
+		if status != tt.status {
+			t.Errorf("%s: got status %d; want %d: %s", tt.name, status, tt.status, js)
+		}
+	}
+
+	// Hidden comments are only shown to moderators.
+	if got := listComments(t, app, bob, movieID)[0].Body; got != hiddenCommentBody {
+		t.Errorf("got body %q for a hidden comment; want %q", got, hiddenCommentBody)
+	}
+	if got := listComments(t, app, moderator, movieID)[0].Body; got != "Great songs!" {
+		t.Errorf("got body %q for a moderator; want %q", got, "Great songs!")
+	}
+
+	// Close the edit window, which only binds the author.
+	app.config.comments.editWindow = 0
+
+	status, _ := serveAs(t, app, app.updateCommentHandler, alice, http.MethodPatch, params, `{"body": "Too late"}`)
+	if status != http.StatusForbidden {
+		t.Errorf("got status %d for the author after the edit window; want %d", status, http.StatusForbidden)
+	}
+
+	status, _ = serveAs(t, app, app.updateCommentHandler, moderator, http.MethodPatch, params, `{"body": "Edited"}`)
+	if status != http.StatusOK {
+		t.Errorf("got status %d for a moderator after the edit window; want %d", status, http.StatusOK)
+	}
+}
+
+func TestDeleteComment(t *testing.T) {
+	app := newDBTestApp(t)
+	app.config.comments.editWindow = time.Hour
+
+	alice := insertTestUser(t, app, "Alice")
+	bob := insertTestUser(t, app, "Bob")
+	moderator := insertTestUser(t, app, "Moderator", "comments:moderate")
+	movieID := insertTestMovie(t, app, "Moana")
+
+	rootID := postComment(t, app, alice, movieID, `{"body": "Great songs"}`)
+	replyID := postComment(t, app, bob, movieID, fmt.Sprintf(`{"body": "Agreed", "parent_id": %d}`, rootID))
+	leafID := postComment(t, app, alice, movieID, `{"body": "Watched it twice"}`)
+
+	// Only the author and moderators can delete a comment.
+	status, _ := serveAs(t, app, app.deleteCommentHandler, bob, http.MethodDelete, commentParams(movieID, rootID), "")
+	if status != http.StatusForbidden {
+		t.Errorf("got status %d deleting another user's comment; want %d", status, http.StatusForbidden)
+	}
+
+	// The author deleting a comment with replies keeps the replies.
+	for _, id := range []int64{rootID, leafID} {
+		status, js := serveAs(t, app, app.deleteCommentHandler, alice, http.MethodDelete, commentParams(movieID, id), "")
+		if status != http.StatusOK {
+			t.Fatalf("got status %d deleting comment %d: %s", status, id, js)
+		}
+	}
+
+	threads := listComments(t, app, bob, movieID)
+	if len(threads) != 1 {
+		t.Fatalf("got %d threads; want only the one with a reply left", len(threads))
+	}
+	if root := threads[0]; !root.Deleted || root.Body != data.DeletedCommentBody || len(root.Replies) != 1 || root.Replies[0].ID != replyID {
+		t.Errorf("got %+v; want the deleted comment emptied with its reply %d kept", root, replyID)
+	}
+
+	// Deleted comments can't be edited by their author any more.
+	status, _ = serveAs(t, app, app.updateCommentHandler, alice, http.MethodPatch, commentParams(movieID, rootID), `{"body": "Back"}`)
+	if status != http.StatusForbidden {
+		t.Errorf("got status %d editing a deleted comment; want %d", status, http.StatusForbidden)
+	}
+
+	// Moderators delete the whole thread.
+	status, _ = serveAs(t, app, app.deleteCommentHandler, moderator, http.MethodDelete, commentParams(movieID, rootID), "")
+	if status != http.StatusOK {
+		t.Errorf("got status %d for a moderator; want %d", status, http.StatusOK)
+	}
+	if threads := listComments(t, app, bob, movieID); len(threads) != 0 {
+		t.Errorf("got %d threads after a moderator deleted the thread; want 0", len(threads))
+	}
+}
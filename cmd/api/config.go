@@ -0,0 +1,389 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/errorreport"
+	"github.com/saalikmubeen/greenlight/internal/schedule"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// commandLineOnly lists the flags, besides the own flags of each command, which trigger a
+// one-off action rather than configure the server, so they are never read from the environment
+// or a configuration file.
+var commandLineOnly = []string{"print-config"}
+
+// secretFlags lists the flags whose values are redacted from the effective configuration.
+var secretFlags = []string{
+	"smtp-password", "idp-scim-token", "debug-password", "redis-password",
+	"ses-secret-access-key", "sendgrid-api-key", "mailgun-api-key", "sentry-dsn",
+	"token-pepper", "token-previous-pepper", "vault-token",
+}
+
+// fileFlags lists the flags which can also be read from a file, e.g. -db-dsn from the file named
+// by -db-dsn-file: the secrets, and the DSNs, which usually hold a password.
+var fileFlags = append([]string{"db-dsn", "db-read-dsn"}, secretFlags...)
+
+// redacted replaces secrets in the effective configuration.
+const redacted = "REDACTED"
+
+// minTokenPepperLength is the minimum length of -token-pepper, so that it can't be guessed
+// from the hashes in a database dump.
+const minTokenPepperLength = 32
+
+// validateConfig checks the assembled configuration, whichever mix of flags, environment
+// variables and configuration file it came from. Errors are keyed by flag name.
+func validateConfig(v *validator.Validator, cfg config) {
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	if network, address := listenAddress(cfg.listen.address, cfg.port); network == "unix" {
+		v.Check(address != "", "listen", "must give the socket path, e.g. unix:/run/greenlight.sock")
+		_, err := parseSocketMode(cfg.listen.socketMode)
+		v.Check(err == nil, "listen-socket-mode", "must be octal permissions, e.g. 0660")
+		v.Check(len(cfg.acme.domains) == 0, "acme-domains", "must not be set when listening on a unix socket")
+	} else if cfg.listen.address != "" {
+		_, port, err := net.SplitHostPort(address)
+		v.Check(err == nil && port != "", "listen", "must be host:port or unix:/path/to.sock")
+	}
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns >= 0, "db-max-open-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	if d, err := time.ParseDuration(cfg.db.maxIdleTime); err != nil {
+		v.AddError("db-max-idle-time", "must be a duration, e.g. 15m")
+	} else {
+		v.Check(d >= 0, "db-max-idle-time", "must not be negative")
+	}
+	v.Check(cfg.db.slowQuery >= 0, "db-slow-query-threshold", "must not be negative")
+	v.Check(cfg.db.movieCacheSize >= 0, "db-movie-cache-size", "must not be negative")
+	if cfg.db.movieCacheSize > 0 {
+		v.Check(cfg.db.movieCacheTTL > 0, "db-movie-cache-ttl", "must be greater than zero when the movie cache is enabled")
+	}
+	v.Check(cfg.db.permissionCacheSize >= 0, "db-permission-cache-size", "must not be negative")
+	if cfg.db.permissionCacheSize > 0 {
+		v.Check(cfg.db.permissionCacheTTL > 0, "db-permission-cache-ttl", "must be greater than zero when the permission cache is enabled")
+	}
+
+	v.Check(cfg.db.poolCheckInterval >= 0, "db-pool-check-interval", "must not be negative")
+	if cfg.db.poolCheckInterval > 0 {
+		v.Check(cfg.db.poolSaturation > 0 && cfg.db.poolSaturation <= 1, "db-pool-saturation", "must be greater than 0 and at most 1")
+	}
+
+	v.Check(cfg.cors.maxAge >= 0, "cors-max-age", "must not be negative")
+	v.Check(cfg.cors.publicMaxAge >= 0, "cors-public-max-age", "must not be negative")
+
+	validateLiveConfig(v, cfg.live)
+
+	// Registration, activation and password resets always send emails, so the settings of the
+	// mail provider must be complete. The local providers would leak the tokens in the emails
+	// to the logs or the disk of a production server.
+	switch provider := mailProvider(cfg); provider {
+	case "smtp":
+		v.Check(cfg.smtp.host != "", "smtp-host", "must be provided")
+		v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	case "ses":
+		v.Check(cfg.mail.sesRegion != "", "ses-region", "must be provided when mail-provider is ses")
+		v.Check(cfg.mail.sesAccessKeyID != "", "ses-access-key-id", "must be provided when mail-provider is ses")
+		v.Check(cfg.mail.sesSecretAccessKey != "", "ses-secret-access-key", "must be provided when mail-provider is ses")
+	case "sendgrid":
+		v.Check(cfg.mail.sendGridAPIKey != "", "sendgrid-api-key", "must be provided when mail-provider is sendgrid")
+	case "mailgun":
+		u, err := url.Parse(cfg.mail.mailgunAPIBase)
+		v.Check(err == nil && u.Scheme == "https" && u.Host != "", "mailgun-api-base", "must be an https URL")
+		v.Check(cfg.mail.mailgunDomain != "", "mailgun-domain", "must be provided when mail-provider is mailgun")
+		v.Check(cfg.mail.mailgunAPIKey != "", "mailgun-api-key", "must be provided when mail-provider is mailgun")
+	case "log", "file":
+		v.Check(provider == "log" || cfg.mail.dir != "", "mail-dir", "must be provided when mail-provider is file")
+		v.Check(cfg.env != "production", "mail-provider", "must not be log or file in production")
+	default:
+		v.AddError("mail-provider", "must be smtp, ses, sendgrid, mailgun, log or file")
+	}
+	_, err := mail.ParseAddress(cfg.smtp.sender)
+	v.Check(err == nil, "smtp-sender", "must be an email address, e.g. Greenlight <no-reply@example.com>")
+	// The credentials read from Vault replace these, so they can be missing or partial until then.
+	if cfg.vault.smtpPath == "" {
+		v.Check(cfg.smtp.password == "" || cfg.smtp.username != "", "smtp-username", "must be provided with smtp-password")
+		v.Check(cfg.smtp.username == "" || cfg.smtp.password != "", "smtp-password", "must be provided with smtp-username")
+	}
+	v.Check(!cfg.smtp.check || mailProvider(cfg) == "smtp", "smtp-check", "must only be set when mail-provider is smtp")
+	if cfg.smtp.dkim.privateKeyFile != "" {
+		v.Check(mailProvider(cfg) == "smtp", "dkim-private-key-file", "must only be set when mail-provider is smtp, the other providers sign the emails themselves")
+		v.Check(validator.Matches(cfg.smtp.dkim.selector, dkimSelectorRX), "dkim-selector", "must be a DNS label, such as greenlight")
+		v.Check(cfg.smtp.dkim.domain == "" || validator.Matches(cfg.smtp.dkim.domain, dkimDomainRX), "dkim-domain", "must be a domain name")
+	}
+	v.Check(cfg.smtp.retry.Attempts >= 1, "smtp-retry-attempts", "must be at least 1")
+	v.Check(cfg.smtp.retry.Backoff >= 0, "smtp-retry-backoff", "must not be negative")
+	v.Check(cfg.smtp.retry.MaxBackoff >= cfg.smtp.retry.Backoff, "smtp-retry-max-backoff", "must not be less than smtp-retry-backoff")
+	v.Check(cfg.smtp.retry.Jitter >= 0 && cfg.smtp.retry.Jitter <= 1, "smtp-retry-jitter", "must be between 0 and 1")
+
+	// ACME certificates are only for production, where the domains resolve to this server;
+	// elsewhere, Let's Encrypt can't complete the challenges and would rate limit the retries.
+	if len(cfg.acme.domains) > 0 {
+		v.Check(cfg.env == "production", "acme-domains", "must only be set in production")
+		for _, domain := range cfg.acme.domains {
+			if strings.ContainsAny(domain, ":/") || !strings.Contains(domain, ".") {
+				v.AddError("acme-domains", fmt.Sprintf("%q is not a domain name, e.g. api.example.com", domain))
+				break
+			}
+		}
+		v.Check(cfg.acme.cacheDir != "", "acme-cache-dir", "must be provided when acme-domains is set")
+		v.Check(cfg.acme.httpPort > 0 && cfg.acme.httpPort <= 65535, "acme-http-port", "must be between 1 and 65535")
+		v.Check(cfg.acme.httpPort != cfg.port, "acme-http-port", "must differ from port")
+		if cfg.acme.email != "" {
+			_, err := mail.ParseAddress(cfg.acme.email)
+			v.Check(err == nil, "acme-email", "must be an email address")
+		}
+	}
+
+	v.Check(cfg.cache.catalogueMaxAge >= 0, "cache-catalogue-max-age", "must not be negative")
+	v.Check(cfg.cache.movieTTL >= 0, "cache-movie-ttl", "must not be negative")
+	v.Check(cfg.cache.movieListTTL >= 0, "cache-movie-list-ttl", "must not be negative")
+	if cfg.cache.movieTTL > 0 || cfg.cache.movieListTTL > 0 {
+		v.Check(cfg.cache.maxEntries > 0, "cache-max-entries", "must be greater than zero when the response cache is enabled")
+	}
+	v.Check(cfg.stats.refresh > 0, "stats-refresh", "must be greater than zero")
+	v.Check(cfg.stats.rps > 0, "stats-rps", "must be greater than zero")
+	v.Check(cfg.stats.burst > 0, "stats-burst", "must be greater than zero")
+	v.Check(cfg.json.maxArrayLength >= 0, "json-max-array-length", "must not be negative")
+	v.Check(cfg.json.maxObjectKeys >= 0, "json-max-object-keys", "must not be negative")
+	for _, contentType := range cfg.contentTypes {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != strings.ToLower(contentType) || len(params) > 0 {
+			v.AddError("content-types", fmt.Sprintf("%q is not a media type, e.g. application/json", contentType))
+			break
+		}
+	}
+	v.Check(cfg.diagnostics.dir != "", "diagnostics-dir", "must be provided")
+	for _, cidr := range cfg.debug.allowCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			v.AddError("debug-allow-cidrs", fmt.Sprintf("%q is not a CIDR, e.g. 10.0.0.0/8", cidr))
+			break
+		}
+	}
+	v.Check(cfg.debug.password == "" || cfg.debug.username != "", "debug-username", "must be provided with debug-password")
+	v.Check(cfg.debug.username == "" || cfg.debug.password != "", "debug-password", "must be provided with debug-username")
+
+	v.Check(validator.In(cfg.log.output, "stdout", "syslog", "journald"), "log-output", "must be stdout, syslog or journald")
+	if cfg.sentry.dsn != "" {
+		v.Check(errorreport.CheckDSN(cfg.sentry.dsn) == nil, "sentry-dsn", "must be a Sentry DSN, e.g. https://key@o0.ingest.sentry.io/1")
+	}
+	v.Check(cfg.sentry.sampleRate >= 0 && cfg.sentry.sampleRate <= 1, "sentry-sample-rate", "must be between 0 and 1")
+
+	if cfg.log.export != "" {
+		v.Check(validator.In(cfg.log.export, "loki", "otlp"), "log-export", "must be loki or otlp")
+		u, err := url.Parse(cfg.log.exportURL)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "log-export-url", "must be an http or https URL")
+		v.Check(cfg.log.exportBuffer > 0, "log-export-buffer", "must be greater than zero")
+	}
+	for _, key := range cfg.log.redactKeys {
+		if !logKeyRX.MatchString(key) {
+			v.AddError("log-redact-keys", fmt.Sprintf("%q is not a key, e.g. password", key))
+			break
+		}
+	}
+
+	v.Check(cfg.quota.monthly >= 0, "quota-monthly", "must not be negative")
+	v.Check(len(cfg.quota.tiers) == 0 || cfg.quota.monthly > 0, "quota-tiers", "can only be used when quota-monthly is set")
+
+	v.Check(cfg.comments.editWindow >= 0, "comments-edit-window", "must not be negative")
+
+	if cfg.trial.enabled {
+		v.Check(cfg.trial.ttl > 0, "trial-ttl", "must be greater than zero")
+		v.Check(cfg.trial.maxPerIP > 0, "trial-max-per-ip", "must be greater than zero")
+		v.Check(cfg.trial.rps > 0, "trial-rps", "must be greater than zero")
+		v.Check(cfg.trial.burst > 0, "trial-burst", "must be greater than zero")
+		for _, cidr := range cfg.trial.trustedProxyCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				v.AddError("trial-trusted-proxy-cidrs", fmt.Sprintf("%q is not a CIDR, e.g. 10.0.0.0/8", cidr))
+				break
+			}
+		}
+	}
+
+	if cfg.credentials.enabled {
+		v.Check(cfg.credentials.ipRPS > 0, "credentials-ip-rps", "must be greater than zero")
+		v.Check(cfg.credentials.ipBurst > 0, "credentials-ip-burst", "must be greater than zero")
+		v.Check(cfg.credentials.emailRPS > 0, "credentials-email-rps", "must be greater than zero")
+		v.Check(cfg.credentials.emailBurst > 0, "credentials-email-burst", "must be greater than zero")
+	}
+
+	v.Check(validator.In(cfg.tokens.hash, data.TokenHashAlgorithms...), "token-hash", "must be sha256 or sha512")
+	v.Check(cfg.tokens.pepper == "" || len(cfg.tokens.pepper) >= minTokenPepperLength, "token-pepper",
+		fmt.Sprintf("must be at least %d characters long", minTokenPepperLength))
+	if cfg.tokens.previousHash != "" {
+		v.Check(validator.In(cfg.tokens.previousHash, data.TokenHashAlgorithms...), "token-previous-hash", "must be sha256 or sha512")
+		v.Check(cfg.tokens.previousHash != cfg.tokens.hash || cfg.tokens.previousPepper != cfg.tokens.pepper,
+			"token-previous-hash", "must differ from token-hash or token-pepper")
+	} else {
+		v.Check(cfg.tokens.previousPepper == "", "token-previous-pepper", "can only be used together with token-previous-hash")
+	}
+
+	v.Check(cfg.backup.interval >= 0, "backup-interval", "must not be negative")
+	if cfg.backup.interval > 0 {
+		v.Check(cfg.backup.dir != "", "backup-dir", "must be provided when backups are enabled")
+	}
+
+	v.Check(cfg.outbox.interval > 0, "outbox-interval", "must be greater than zero")
+	v.Check(cfg.webhooks.deliveryInterval > 0, "webhook-delivery-interval", "must be greater than zero")
+
+	for _, job := range []struct{ name, spec string }{
+		{"schedule-token-purge", cfg.maintenance.tokenPurge},
+		{"schedule-account-cleanup", cfg.maintenance.accountCleanup},
+		{"schedule-popularity", cfg.maintenance.popularity},
+	} {
+		if _, err := schedule.Parse(job.spec); job.spec != "" && err != nil {
+			v.AddError(job.name, "must be a cron expression or a shorthand, e.g. 30 3 * * * or @every 1h")
+		}
+	}
+	if cfg.maintenance.accountCleanup != "" {
+		v.Check(cfg.maintenance.unactivatedAge > 0, "unactivated-account-age", "must be greater than zero")
+	}
+	if cfg.maintenance.popularity != "" {
+		v.Check(cfg.maintenance.popularityWindow > 0, "popularity-window", "must be greater than zero")
+	}
+
+	if cfg.idp.scimURL != "" {
+		u, err := url.Parse(cfg.idp.scimURL)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "idp-scim-url", "must be an http or https URL")
+		v.Check(cfg.idp.scimToken != "", "idp-scim-token", "must be provided when idp-scim-url is set")
+		v.Check(cfg.idp.interval > 0, "idp-sync-interval", "must be greater than zero")
+	}
+
+	if cfg.vault.addr != "" {
+		u, err := url.Parse(cfg.vault.addr)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "vault-addr", "must be an http or https URL")
+		v.Check(cfg.vault.token != "", "vault-token", "must be provided when vault-addr is set")
+		v.Check(cfg.vault.dbPath != "" || cfg.vault.smtpPath != "", "vault-db-path", "must be provided, or vault-smtp-path, when vault-addr is set")
+	} else {
+		v.Check(cfg.vault.dbPath == "", "vault-db-path", "must only be set with vault-addr")
+		v.Check(cfg.vault.smtpPath == "", "vault-smtp-path", "must only be set with vault-addr")
+	}
+}
+
+// tokenHasher returns the data.TokenHasher described by the -token-* settings.
+func tokenHasher(cfg config) data.TokenHasher {
+	hasher := data.TokenHasher{Algorithm: cfg.tokens.hash, Pepper: []byte(cfg.tokens.pepper)}
+	if cfg.tokens.previousHash != "" {
+		hasher.Previous = &data.TokenHasher{Algorithm: cfg.tokens.previousHash, Pepper: []byte(cfg.tokens.previousPepper)}
+	}
+	return hasher
+}
+
+// configErrors formats the errors of validateConfig one per line, sorted by flag name.
+func configErrors(problems map[string]string) string {
+	names := make([]string, 0, len(problems))
+	for name := range problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n  -%s: %s", name, problems[name])
+	}
+
+	return b.String()
+}
+
+// effectiveConfig returns the value of every setting of the server, keyed by flag name, with
+// secrets redacted. The flags bound to the config struct report its values, but the ones
+// defined with flag.Func don't remember theirs, so those are read from cfg instead.
+func effectiveConfig(fs *flag.FlagSet, cfg config) map[string]string {
+	settings := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		settings[f.Name] = f.Value.String()
+	})
+
+	for _, name := range append(commandLineOnly, "config") {
+		delete(settings, name)
+	}
+
+	providers := make([]string, 0, len(cfg.webhooks.secrets))
+	for provider := range cfg.webhooks.secrets {
+		providers = append(providers, provider+"="+redacted)
+	}
+	sort.Strings(providers)
+	settings["webhook-secrets"] = strings.Join(providers, " ")
+
+	groups := make([]string, 0, len(cfg.idp.groupPermissions))
+	for group, permissions := range cfg.idp.groupPermissions {
+		groups = append(groups, group+"="+strings.Join(permissions, ","))
+	}
+	sort.Strings(groups)
+	settings["idp-group-permissions"] = strings.Join(groups, " ")
+
+	for _, name := range secretFlags {
+		if settings[name] != "" {
+			settings[name] = redacted
+		}
+	}
+	settings["db-dsn"] = redactDSN(settings["db-dsn"])
+	if dsn, ok := settings["db-read-dsn"]; ok {
+		settings["db-read-dsn"] = redactDSN(dsn)
+	}
+
+	return settings
+}
+
+// logKeyRX matches a key redacted in the log, a name made of letters, digits, dashes, dots and
+// underscores.
+var logKeyRX = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// dkimSelectorRX matches a DNS label, which DKIM selectors are, and dkimDomainRX a domain name.
+var (
+	dkimSelectorRX = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	dkimDomainRX   = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)+$`)
+)
+
+// dsnPassword matches the password of a key=value PostgreSQL connection string.
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(\\.|[^'])*'|\S+)`)
+
+// redactDSN removes the password from a PostgreSQL connection string, in either URL or
+// key=value form.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		if q := u.Query(); q.Get("password") != "" {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// printConfig writes the effective configuration as YAML, which can be used with -config once
+// the redacted secrets are filled in.
+func printConfig(w io.Writer, settings map[string]string) error {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		_, err := fmt.Fprintf(w, "%s: %s\n", name, strconv.Quote(settings[name]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/saalikmubeen/greenlight/internal/codec"
+)
+
+// defaultContentTypes are the media types of the request bodies the API decodes: JSON, the two
+// patch formats of PATCH /v1/movies/:id, and the XML and MessagePack encodings readJSON converts
+// to JSON.
+var defaultContentTypes = []string{
+	codec.JSON,
+	contentTypeJSONPatch,
+	contentTypeMergePatch,
+	codec.XML,
+	codec.MessagePack,
+}
+
+// requireContentType rejects the POST, PUT, PATCH and DELETE requests with a body whose
+// Content-Type isn't one of the -content-types, with a 415 Unsupported Media Type response,
+// rather than letting the handlers attempt to decode a form or a file as JSON. The requests
+// without a body, such as POST /v1/admin/emails/dead-letters/:id/requeue, don't need one. The
+// check is disabled when -content-types is empty.
+func (app *application) requireContentType(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			// A ContentLength of -1 means the length is unknown, e.g. for a chunked body.
+			if r.ContentLength != 0 && len(app.config.contentTypes) > 0 {
+				if err := checkContentType(r.Header.Get("Content-Type"), app.config.contentTypes); err != nil {
+					app.unsupportedMediaTypeResponse(w, r, err)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkContentType returns an error unless the media type of contentType is one of allowed,
+// either as is or as the canonical name of an alias from the codec package, such as text/xml
+// for application/xml. The only charset accepted is UTF-8, which JSON text must be encoded in
+// (RFC 8259) and the others are decoded as anyway. A missing charset means UTF-8.
+func checkContentType(contentType string, allowed []string) error {
+	if contentType == "" {
+		return fmt.Errorf("the Content-Type header must be provided, one of %s", strings.Join(allowed, ", "))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errors.New("the Content-Type header is malformed")
+	}
+
+	if !slices.Contains(allowed, mediaType) && !slices.Contains(allowed, codec.Canonical(mediaType)) {
+		return fmt.Errorf("the Content-Type %s is not supported, use one of %s", mediaType, strings.Join(allowed, ", "))
+	}
+
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "utf8") {
+		return fmt.Errorf("the request body must be encoded in UTF-8, not %s", charset)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		wantErr     string
+	}{
+		{"application/json", ""},
+		{"application/json; charset=UTF-8", ""},
+		{"Application/JSON;charset=utf8", ""},
+		{"text/xml", ""},
+		{"application/merge-patch+json", ""},
+		{"", "the Content-Type header must be provided"},
+		{"application/json; charset", "the Content-Type header is malformed"},
+		{"application/x-www-form-urlencoded", "the Content-Type application/x-www-form-urlencoded is not supported"},
+		{"multipart/form-data; boundary=x", "the Content-Type multipart/form-data is not supported"},
+		{"application/json; charset=iso-8859-1", "the request body must be encoded in UTF-8, not iso-8859-1"},
+	}
+
+	for _, tt := range tests {
+		err := checkContentType(tt.contentType, defaultContentTypes)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%q: want no error; got %v", tt.contentType, err)
+		case tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)):
+			t.Errorf("%q: want error %q; got %v", tt.contentType, tt.wantErr, err)
+		}
+	}
+}
+
+func TestRequireContentType(t *testing.T) {
+	app := newTestApp()
+	app.config.contentTypes = []string{"application/json"}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		method      string
+		body        string
+		contentType string
+		want        int
+	}{
+		{http.MethodPost, `{"title":"Up"}`, "application/json", http.StatusNoContent},
+		{http.MethodPost, `title=Up`, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{http.MethodPatch, `{"title":"Up"}`, "", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "", "", http.StatusNoContent},
+		{http.MethodGet, `{"title":"Up"}`, "text/plain", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/v1/movies", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		rr := httptest.NewRecorder()
+		app.requireContentType(next).ServeHTTP(rr, r)
+
+		if rr.Code != tt.want {
+			t.Errorf("%s %q with %q: want status %d; got %d", tt.method, tt.body, tt.contentType, tt.want, rr.Code)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"net/http"
+	"sync"
 
 	"github.com/saalikmubeen/greenlight/internal/data"
 )
@@ -32,3 +33,64 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// orgContextKey is used as a key for getting and setting the organization the request was made
+// in, see resolveOrg.
+const orgContextKey = contextKey("org")
+
+// contextSetOrg returns a new copy of the request with the provided Organization added to the
+// context.
+func (app *application) contextSetOrg(r *http.Request, org *data.Organization) *http.Request {
+	ctx := context.WithValue(r.Context(), orgContextKey, org)
+	return r.WithContext(ctx)
+}
+
+// contextGetOrg retrieves the Organization the request was made in from the request context.
+// Unlike contextGetUser it returns nil when there is none, as the requests made outside of an
+// organization work with the shared catalogue.
+func (app *application) contextGetOrg(r *http.Request) *data.Organization {
+	org, _ := r.Context().Value(orgContextKey).(*data.Organization)
+	return org
+}
+
+// viewAsContextKey marks the requests an administrator makes as another user, see viewAs.
+const viewAsContextKey = contextKey("view-as")
+
+// contextSetViewAs returns a new copy of the request marked as made with X-View-As.
+func (app *application) contextSetViewAs(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), viewAsContextKey, true)
+	return r.WithContext(ctx)
+}
+
+// contextIsViewAs reports whether the request is made with X-View-As, in which case the user
+// in the context is the one viewed as, not the one who made the request.
+func (app *application) contextIsViewAs(r *http.Request) bool {
+	viewAs, _ := r.Context().Value(viewAsContextKey).(bool)
+	return viewAs
+}
+
+// permissionsContextKey is used as a key for getting and setting the permissions looked up
+// during the request, see userPermissions.
+const permissionsContextKey = contextKey("permissions")
+
+// requestPermissions holds the permissions of the users looked up during a request, so that a
+// request checking several permissions, or the same one in several places, only queries them
+// once. It is keyed by user ID as viewAs swaps the user of the request.
+type requestPermissions struct {
+	mu     sync.Mutex
+	byUser map[int64]data.Permissions
+}
+
+// contextSetRequestPermissions returns a new copy of the request with an empty
+// requestPermissions added to the context.
+func (app *application) contextSetRequestPermissions(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, &requestPermissions{byUser: make(map[int64]data.Permissions)})
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestPermissions retrieves the requestPermissions from the request context, or nil
+// if the request didn't go through authenticate.
+func (app *application) contextGetRequestPermissions(r *http.Request) *requestPermissions {
+	permissions, _ := r.Context().Value(permissionsContextKey).(*requestPermissions)
+	return permissions
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// corsPolicy is the CORS policy of a group of routes: the origins allowed to call them from a
+// browser, and what the preflight requests are answered with.
+type corsPolicy struct {
+	// anyOrigin allows every origin, with "Access-Control-Allow-Origin: *", rather than only
+	// the trusted ones. Browsers never send credentials to such routes.
+	anyOrigin bool
+	methods   []string
+	headers   []string
+	// maxAge is how long browsers may cache the answer to a preflight request.
+	maxAge time.Duration
+	// allowCredentials lets browsers send cookies and read the responses to the requests which
+	// carry them. It's only ever set for trusted origins.
+	allowCredentials bool
+}
+
+// corsGroup is a group of routes sharing a CORS policy. A path ending in "/" covers every path
+// under it.
+type corsGroup struct {
+	paths  []string
+	policy corsPolicy
+}
+
+// corsGroups returns the CORS policies of the API. The public endpoints may be called from any
+// page, without credentials, as their responses are the same for everyone. Every other route
+// follows the default policy, which only allows the trusted origins, see
+// -cors-trusted-origins, and lets them send credentials if -cors-allow-credentials is set.
+func (app *application) corsGroups() (groups []corsGroup, defaultPolicy corsPolicy) {
+	public := corsGroup{
+		paths: []string{"/v1/healthcheck", "/v1/openapi.json", "/v1/stats/public"},
+		policy: corsPolicy{
+			anyOrigin: true,
+			methods:   []string{"OPTIONS", "GET", "HEAD"},
+			maxAge:    app.config.cors.publicMaxAge,
+		},
+	}
+
+	defaultPolicy = corsPolicy{
+		methods:          []string{"OPTIONS", "PUT", "PATCH", "DELETE"},
+		headers:          []string{"Authorization", "Content-Type", orgHeader},
+		maxAge:           app.config.cors.maxAge,
+		allowCredentials: app.config.cors.allowCredentials,
+	}
+
+	return []corsGroup{public}, defaultPolicy
+}
+
+// corsPolicyFor returns the policy of the first group covering path, or defaultPolicy if none
+// does.
+func corsPolicyFor(groups []corsGroup, defaultPolicy corsPolicy, path string) corsPolicy {
+	for _, group := range groups {
+		for _, p := range group.paths {
+			if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+				return group.policy
+			}
+		}
+	}
+	return defaultPolicy
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header for a request from
+// origin, or "" if the policy doesn't allow the origin.
+func (p corsPolicy) allowOrigin(origin string, trustedOrigins []string) string {
+	if p.anyOrigin {
+		return "*"
+	}
+	for _, trusted := range trustedOrigins {
+		if origin == trusted {
+			return origin
+		}
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEnableCORSPolicies(t *testing.T) {
+	app := newTestApp()
+	app.config.cors.allowCredentials = true
+	app.config.cors.maxAge = time.Minute
+	app.config.cors.publicMaxAge = time.Hour
+	app.setLiveConfig(liveConfig{trustedOrigins: []string{"https://app.example.com"}})
+
+	h := app.enableCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name            string
+		path            string
+		origin          string
+		wantOrigin      string
+		wantCredentials string
+		wantMethods     string
+		wantMaxAge      string
+	}{
+		{"trusted origin", "/v1/movies", "https://app.example.com", "https://app.example.com", "true", "OPTIONS, PUT, PATCH, DELETE", "60"},
+		{"untrusted origin", "/v1/movies", "https://evil.example.com", "", "", "", ""},
+		{"public endpoint", "/v1/stats/public", "https://evil.example.com", "*", "", "OPTIONS, GET, HEAD", "3600"},
+		{"public endpoint from a trusted origin", "/v1/healthcheck", "https://app.example.com", "*", "", "OPTIONS, GET, HEAD", "3600"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+		r.Header.Set("Origin", tt.origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		got := rr.Header()
+		if got.Get("Access-Control-Allow-Origin") != tt.wantOrigin ||
+			got.Get("Access-Control-Allow-Credentials") != tt.wantCredentials ||
+			got.Get("Access-Control-Allow-Methods") != tt.wantMethods ||
+			got.Get("Access-Control-Max-Age") != tt.wantMaxAge {
+			t.Errorf("%s: got headers %v", tt.name, got)
+		}
+		if vary := got.Values("Vary"); len(vary) < 2 || vary[0] != "Origin" {
+			t.Errorf("%s: got Vary %v; want Origin first", tt.name, vary)
+		}
+	}
+}
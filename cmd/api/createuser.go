@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// createUserCommand creates a user with the given permissions, e.g. the first administrator of
+// a new deployment. The password is read from the first line of standard input, so that it
+// doesn't end up in the shell history or the process list.
+func createUserCommand() *command {
+	var (
+		name        string
+		email       string
+		permissions []string
+		activated   bool
+	)
+
+	return &command{
+		name:    "createuser",
+		summary: "Create a user, reading their password from standard input",
+		config:  true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&name, "name", "", "Name of the user")
+			fs.StringVar(&email, "email", "", "Email address of the user")
+			fs.Var((*fieldsValue)(&permissions), "permissions", "Permissions granted to the user (space separated)")
+			fs.BoolVar(&activated, "activated", true, "Create the user already activated")
+		},
+		run: func(cfg config, logger *jsonlog.Logger, args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("unexpected arguments %q", args)
+			}
+
+			password, err := readPassword(os.Stdin)
+			if err != nil {
+				return err
+			}
+
+			user := &data.User{Name: name, Email: email, Activated: activated}
+			err = user.Password.Set(password)
+			if err != nil {
+				return err
+			}
+
+			v := validator.New()
+			if data.ValidateUser(v, user); !v.Valid() {
+				return errors.New(configErrors(v.Errors))
+			}
+
+			db, err := openDB(cfg, cfg.db.dsn)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			return createUser(data.NewModels(db, nil), logger, user, permissions)
+		},
+	}
+}
+
+// createAdminCommand creates an activated user with every permission there is, to bootstrap a
+// fresh environment. The email address and password which aren't given as flags are prompted
+// for on standard input.
+func createAdminCommand() *command {
+	var (
+		name     string
+		email    string
+		password string
+	)
+
+	return &command{
+		name:    "create-admin",
+		summary: "Create an activated user with every permission",
+		config:  true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&name, "name", "Admin", "Name of the user")
+			fs.StringVar(&email, "email", "", "Email address of the user (prompted for if empty)")
+			fs.StringVar(&password, "password", "", "Password of the user (prompted for if empty)")
+		},
+		run: func(cfg config, logger *jsonlog.Logger, args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("unexpected arguments %q", args)
+			}
+
+			// The prompts share one reader, so that the password isn't lost in the buffer of
+			// the one reading the email address.
+			stdin := bufio.NewReader(os.Stdin)
+
+			var err error
+			if email == "" {
+				email, err = prompt(stdin, os.Stderr, "Email: ")
+				if err != nil {
+					return err
+				}
+			}
+			if password == "" {
+				password, err = prompt(stdin, os.Stderr, "Password: ")
+				if err != nil {
+					return err
+				}
+			}
+
+			user := &data.User{Name: name, Email: email, Activated: true}
+			err = user.Password.Set(password)
+			if err != nil {
+				return err
+			}
+
+			v := validator.New()
+			if data.ValidateUser(v, user); !v.Valid() {
+				return errors.New(configErrors(v.Errors))
+			}
+
+			db, err := openDB(cfg, cfg.db.dsn)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			models := data.NewModels(db, nil)
+
+			permissions, err := models.Permissions.GetAll()
+			if err != nil {
+				return err
+			}
+
+			return createUser(models, logger, user, permissions)
+		},
+	}
+}
+
+// readPassword returns the first line read from r.
+func readPassword(r io.Reader) (string, error) {
+	return readLine(bufio.NewReader(r))
+}
+
+// prompt writes label to w and returns the next line read from r.
+func prompt(r *bufio.Reader, w io.Writer, label string) (string, error) {
+	fmt.Fprint(w, label)
+	return readLine(r)
+}
+
+// readLine returns the next line read from r, without its line ending.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// createUser inserts user and grants them permissions in a single transaction.
+func createUser(models data.Models, logger *jsonlog.Logger, user *data.User, permissions []string) error {
+	tx, err := models.Begin(5 * time.Second)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.InsertUser(user)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			return errors.New("a user with this email address already exists")
+		}
+		return err
+	}
+
+	if len(permissions) > 0 {
+		err = tx.AddPermissionsForUser(user.ID, permissions...)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// Permission codes which don't exist are skipped when they're added, so report the ones
+	// the user actually has.
+	granted, err := models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	logger.PrintInfo("user created", map[string]string{
+		"id":          strconv.FormatInt(user.ID, 10),
+		"email":       user.Email,
+		"activated":   strconv.FormatBool(user.Activated),
+		"permissions": strings.Join(granted, " "),
+	})
+	return nil
+}
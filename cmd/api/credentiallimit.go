@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/codec"
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/ratelimit"
+	"github.com/tomasen/realip"
+)
+
+// maxCredentialBodyBytes is how much of the body credentialRateLimit() reads to find the email
+// address. It's the limit readJSON() enforces, so a body it accepts is always read in full.
+const maxCredentialBodyBytes = 1_048_576
+
+// credentialRateLimit gives the endpoints which take an email address and a password, or send
+// an email to an address, their own much lower rate limits: one per client IP address, and one
+// per email address, so that credential stuffing is slowed down both from a single address and
+// when it is spread over many. It runs on top of the regular rateLimit() middleware but is
+// configured and enabled apart from it, see -credentials-limiter-enabled. Each endpoint, named
+// name, has limiters of its own. They are kept in Redis when there is one, like the ones of
+// rateLimit(), so that they apply across instances.
+func (app *application) credentialRateLimit(name string, next http.HandlerFunc) http.HandlerFunc {
+	// Clients that haven't been seen recently are removed, in the same way as for the
+	// rateLimit() middleware, but only after their bucket has had time to refill.
+	cfg := app.config.credentials
+	byIP := app.newClientStore(name+":ip", ratelimit.TokenBucket, cfg.ipRPS, cfg.ipBurst, 30*time.Minute)
+	byEmail := app.newClientStore(name+":email", ratelimit.TokenBucket, cfg.emailRPS, cfg.emailBurst, 30*time.Minute)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.enabled {
+			next(w, r)
+			return
+		}
+
+		if !app.allowRequest(w, r, byIP, realip.FromRequest(r)) {
+			return
+		}
+
+		// The bodies without an email address are left for the handler to reject.
+		if key := credentialEmailKey(r); key != "" {
+			if !app.allowRequest(w, r, byEmail, key) {
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// credentialEmailKey returns the key of the email address in the body of r for the per-email
+// limiter, or "" if there is none. The body is put back for the handler to read. The address is
+// normalized like the handler does, and folded to lower case whatever the policy, so that its
+// variants share one limit, and hashed, so that the addresses aren't kept in Redis.
+func credentialEmailKey(r *http.Request) string {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCredentialBodyBytes+1))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return ""
+	}
+
+	if mediaType := requestMediaType(r); mediaType == codec.XML || mediaType == codec.MessagePack {
+		body, err = codec.ToJSON(mediaType, body)
+		if err != nil {
+			return ""
+		}
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &input) != nil {
+		return ""
+	}
+
+	email := strings.ToLower(data.NormalizeEmail(input.Email))
+	if email == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(sum[:])
+}
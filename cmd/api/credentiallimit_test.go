@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCredentialEmailKey(t *testing.T) {
+	newRequest := func(body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		return r
+	}
+
+	r := newRequest(`{"email": "Alice@Example.com", "password": "pa55word"}`)
+	key := credentialEmailKey(r)
+
+	if key == "" || strings.Contains(key, "alice") {
+		t.Errorf("got key %q; want a hash of the address", key)
+	}
+	if body, _ := io.ReadAll(r.Body); string(body) != `{"email": "Alice@Example.com", "password": "pa55word"}` {
+		t.Errorf("got body %q left for the handler", body)
+	}
+	if other := credentialEmailKey(newRequest(`{"email": " alice@example.com"}`)); other != key {
+		t.Errorf("got a different key for a variant of the same address")
+	}
+
+	for _, body := range []string{`{"password": "pa55word"}`, `not json`, ``} {
+		if key := credentialEmailKey(newRequest(body)); key != "" {
+			t.Errorf("%q: got key %q; want none", body, key)
+		}
+	}
+}
+
+func TestCredentialRateLimit(t *testing.T) {
+	app := newTestApp()
+	app.config.credentials.enabled = true
+	app.config.credentials.ipRPS = 0.001
+	app.config.credentials.ipBurst = 3
+	app.config.credentials.emailRPS = 0.001
+	app.config.credentials.emailBurst = 2
+
+	h := app.credentialRateLimit("authentication", func(w http.ResponseWriter, r *http.Request) {})
+
+	send := func(ip, email string) int {
+		r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(`{"email": "`+email+`"}`))
+		r.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		h(rr, r)
+		return rr.Code
+	}
+
+	tests := []struct {
+		ip, email string
+		want      int
+	}{
+		{"203.0.113.1", "alice@example.com", http.StatusOK},
+		{"203.0.113.2", "alice@example.com", http.StatusOK},
+		// The address has used its burst, whichever IP address it comes from.
+		{"203.0.113.3", "alice@example.com", http.StatusTooManyRequests},
+		{"203.0.113.1", "bob@example.com", http.StatusOK},
+		{"203.0.113.1", "carol@example.com", http.StatusOK},
+		// The IP address has used its burst, whichever address it tries.
+		{"203.0.113.1", "dave@example.com", http.StatusTooManyRequests},
+	}
+
+	for i, tt := range tests {
+		if got := send(tt.ip, tt.email); got != tt.want {
+			t.Errorf("request %d from %s for %s: got status %d; want %d", i, tt.ip, tt.email, got, tt.want)
+		}
+	}
+}
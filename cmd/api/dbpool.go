@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"expvar"
+	"strconv"
+	"time"
+)
+
+// Pool saturation warning reasons used as keys in the poolWarnings expvar map, prefixed with
+// the name of the pool, e.g. "primary:waits".
+const (
+	poolWarningWaits     = "waits"
+	poolWarningNearLimit = "near_limit"
+)
+
+// poolWarnings counts the saturation warnings logged by the connection pool monitors, see
+// startDBPoolMonitor. Like authOutcomes it is published at package level, as expvar panics if
+// the same name is published twice.
+var poolWarnings = expvar.NewMap("total_db_pool_warnings")
+
+// poolCheck is the outcome of comparing the statistics of a connection pool with the ones of
+// the previous check.
+type poolCheck struct {
+	// waits and waitDuration are the number of connections waited for since the previous
+	// check, and the total time spent waiting for them.
+	waits        int64
+	waitDuration time.Duration
+	// nearLimit is true if the connections in use are at least the saturation fraction of the
+	// maximum number of open connections.
+	nearLimit bool
+}
+
+// checkPoolStats compares the statistics cur of a connection pool with prev, taken at the
+// previous check. saturation is the fraction, between 0 and 1, of the maximum number of open
+// connections from which the pool is reported as near its limit. A pool without a maximum is
+// never near it.
+func checkPoolStats(prev, cur sql.DBStats, saturation float64) poolCheck {
+	check := poolCheck{
+		waits:        cur.WaitCount - prev.WaitCount,
+		waitDuration: cur.WaitDuration - prev.WaitDuration,
+	}
+	if cur.MaxOpenConnections > 0 {
+		check.nearLimit = float64(cur.InUse) >= saturation*float64(cur.MaxOpenConnections)
+	}
+	return check
+}
+
+// startDBPoolMonitor checks the statistics of the connection pool db, named name in the logs,
+// every interval for the lifetime of the application. It logs a warning, and counts it in
+// poolWarnings, whenever requests had to wait for a connection since the previous check, or the
+// connections in use approach the maximum, so that the pool running out is diagnosed before
+// the requests start timing out.
+func (app *application) startDBPoolMonitor(name string, db *sql.DB, interval time.Duration, saturation float64) {
+	go func() {
+		defer app.recoverBackgroundPanic(map[string]string{"job": "db-pool-monitor", "pool": name})
+
+		prev := db.Stats()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			cur := db.Stats()
+			check := checkPoolStats(prev, cur, saturation)
+			prev = cur
+
+			properties := map[string]string{
+				"pool":          name,
+				"in_use":        strconv.Itoa(cur.InUse),
+				"open":          strconv.Itoa(cur.OpenConnections),
+				"max_open":      strconv.Itoa(cur.MaxOpenConnections),
+				"wait_count":    strconv.FormatInt(check.waits, 10),
+				"wait_duration": check.waitDuration.String(),
+			}
+
+			if check.waits > 0 {
+				poolWarnings.Add(name+":"+poolWarningWaits, 1)
+				app.logger.PrintWarn("requests waited for a database connection", properties)
+			}
+			if check.nearLimit {
+				poolWarnings.Add(name+":"+poolWarningNearLimit, 1)
+				app.logger.PrintWarn("database connection pool near its limit", properties)
+			}
+		}
+	}()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestCheckPoolStats(t *testing.T) {
+	prev := sql.DBStats{MaxOpenConnections: 25, InUse: 5, WaitCount: 10, WaitDuration: time.Second}
+
+	tests := []struct {
+		name string
+		cur  sql.DBStats
+		want poolCheck
+	}{
+		{"idle", sql.DBStats{MaxOpenConnections: 25, InUse: 5, WaitCount: 10, WaitDuration: time.Second}, poolCheck{}},
+		{"waits", sql.DBStats{MaxOpenConnections: 25, InUse: 10, WaitCount: 13, WaitDuration: 1500 * time.Millisecond},
+			poolCheck{waits: 3, waitDuration: 500 * time.Millisecond}},
+		{"near limit", sql.DBStats{MaxOpenConnections: 25, InUse: 20, WaitCount: 10, WaitDuration: time.Second},
+			poolCheck{nearLimit: true}},
+		{"no limit", sql.DBStats{InUse: 200, WaitCount: 10, WaitDuration: time.Second}, poolCheck{}},
+	}
+
+	for _, tt := range tests {
+		if got := checkPoolStats(prev, tt.cur, 0.8); got != tt.want {
+			t.Errorf("%s: got %+v; want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// recordDeadLetter saves an email which couldn't be sent as a dead letter, so that it can be
+// requeued from the admin endpoints. A failure to save it is logged, along with the email's
+// own error, so that the email is at least in the logs.
+func (app *application) recordDeadLetter(letter *data.DeadLetter) {
+	properties := map[string]string{"recipient": letter.Recipient, "template": letter.Template}
+	if letter.OutboxID != nil {
+		properties["outbox_id"] = strconv.FormatInt(*letter.OutboxID, 10)
+	}
+
+	err := app.models.DeadLetters.Insert(letter)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+		app.logger.PrintError(errors.New(letter.Error), properties)
+		return
+	}
+
+	properties["dead_letter_id"] = strconv.FormatInt(letter.ID, 10)
+	app.logger.PrintError(errors.New("email moved to the dead letters: "+letter.Error), properties)
+}
+
+// listDeadLettersHandler handles "GET /v1/admin/emails/dead-letters" and returns the latest
+// dead letters, newest first. The ones already requeued are only included with
+// "requeued=true", and the "limit" query string parameter sets how many, between 1 and 100
+// (default 50).
+func (app *application) listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	limit := app.readIntRange(qs, "limit", 50, 1, 100, v)
+	requeued := app.readBool(qs, "requeued", false, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	letters, err := app.models.DeadLetters.GetAll(requeued, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"dead_letters": letters}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// requeueDeadLetterHandler handles "POST /v1/admin/emails/dead-letters/:id/requeue" and queues
+// the email of a dead letter in the outbox again, to be sent by the outbox dispatcher. A dead
+// letter can only be requeued once; if the email fails again, it becomes a new dead letter.
+func (app *application) requeueDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	letter, err := app.models.DeadLetters.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if letter.RequeuedAt != nil {
+		app.errorResponse(w, r, http.StatusConflict, "the dead letter has already been requeued")
+		return
+	}
+
+	msg, err := app.models.DeadLetters.Requeue(letter)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.errorResponse(w, r, http.StatusConflict, "the dead letter has already been requeued")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"dead_letter": letter, "outbox_id": msg.ID}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// debugPathPrefix is the prefix of the debug endpoints, such as /debug/vars, which expose
+// build information, goroutine counts and database statistics.
+const debugPathPrefix = "/debug/"
+
+// requireDebugAccess only lets a request through to next when one of the enabled checks of the
+// -debug-* settings passes:
+//
+//   - the client connects from one of -debug-allow-cidrs. This is the address of the TCP peer,
+//     not X-Forwarded-For, which any client can set; behind a reverse proxy every request
+//     comes from the proxy.
+//   - the request carries the -debug-username and -debug-password basic auth credentials,
+//     for scrapers which can't obtain a token.
+//   - the user is authenticated and has the -debug-permission permission.
+//
+// Otherwise, anonymous clients get a 401 Unauthorized response and others a 403 Forbidden.
+func (app *application) requireDebugAccess(next http.Handler) http.HandlerFunc {
+	networks := parseNetworks(app.config.debug.allowCIDRs)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if peerInNetworks(r.RemoteAddr, networks) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if app.config.debug.password != "" {
+			username, password, ok := r.BasicAuth()
+			if ok && app.debugCredentialsMatch(username, password) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		user := app.contextGetUser(r)
+
+		if app.config.debug.permission != "" && !user.IsAnonymous() && user.Activated {
+			ok, err := app.userHasPermission(r, user, app.config.debug.permission)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if user.IsAnonymous() {
+			if app.config.debug.password != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="debug", charset="UTF-8"`)
+			}
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		authOutcomes.Add(authOutcomeInsufficientPermission, 1)
+		app.notPermittedResponse(w, r)
+	}
+}
+
+// debugCredentialsMatch compares basic auth credentials with the -debug-username and
+// -debug-password settings in constant time. Hashing first makes the comparison independent
+// of the lengths too.
+func (app *application) debugCredentialsMatch(username, password string) bool {
+	gotUser, gotPass := sha256.Sum256([]byte(username)), sha256.Sum256([]byte(password))
+	wantUser := sha256.Sum256([]byte(app.config.debug.username))
+	wantPass := sha256.Sum256([]byte(app.config.debug.password))
+
+	userMatch := subtle.ConstantTimeCompare(gotUser[:], wantUser[:])
+	passMatch := subtle.ConstantTimeCompare(gotPass[:], wantPass[:])
+
+	return userMatch&passMatch == 1
+}
+
+// parseNetworks parses the CIDRs of a setting, which validateConfig checked on startup.
+func parseNetworks(cidrs []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// peerInNetworks reports whether the host of remoteAddr, an http.Request.RemoteAddr, is in one
+// of networks. Requests over a unix socket have no IP address and never are.
+func peerInNetworks(remoteAddr string, networks []*net.IPNet) bool {
+	if len(networks) == 0 {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isBasicAuthToDebug reports whether r sends basic auth credentials to a debug endpoint, which
+// the authenticate() middleware leaves for requireDebugAccess to check instead of rejecting
+// them as a malformed bearer token.
+func (app *application) isBasicAuthToDebug(r *http.Request) bool {
+	return app.config.debug.password != "" &&
+		strings.HasPrefix(r.URL.Path, debugPathPrefix) &&
+		strings.HasPrefix(r.Header.Get("Authorization"), "Basic ")
+}
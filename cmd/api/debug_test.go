@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestRequireDebugAccess(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowCIDRs []string
+		username   string
+		password   string
+		wantCode   int
+	}{
+		{"no credentials", nil, "", "", http.StatusUnauthorized},
+		{"allowed network", []string{"127.0.0.0/8"}, "", "", http.StatusOK},
+		{"other network", []string{"10.0.0.0/8"}, "", "", http.StatusUnauthorized},
+		{"basic auth", nil, "metrics", "s3cr3t", http.StatusOK},
+		{"wrong password", nil, "metrics", "guess", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			app.config.debug.allowCIDRs = tt.allowCIDRs
+			app.config.debug.username = "metrics"
+			app.config.debug.password = "s3cr3t"
+			app.config.debug.permission = "admin:read"
+
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			ts := newTestServer(app.authenticate(app.requireDebugAccess(ok)))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/debug/vars", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != tt.wantCode {
+				t.Errorf("got %d; want %d", res.StatusCode, tt.wantCode)
+			}
+			if res.StatusCode == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == "" {
+				t.Error("got no WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestPeerInNetworks(t *testing.T) {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	networks := []*net.IPNet{private}
+
+	tests := map[string]bool{
+		"10.1.2.3:51234":  true,
+		"192.0.2.1:51234": false,
+		"[::1]:51234":     false,
+		"@":               false,
+		"":                false,
+	}
+
+	for addr, want := range tests {
+		if got := peerInNetworks(addr, networks); got != want {
+			t.Errorf("peerInNetworks(%q) = %t; want %t", addr, got, want)
+		}
+	}
+}
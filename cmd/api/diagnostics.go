@@ -0,0 +1,192 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"syscall"
+	"time"
+)
+
+// diagnosticsPrefix is the key prefix of diagnostic bundles in the blob store.
+const diagnosticsPrefix = "diagnostics/"
+
+// diagnosticsBundle describes a diagnostic bundle written to the blob store. The bundle itself
+// is a gzipped tar archive containing:
+//
+//	bundle.json     this description, plus the host name, process id and Go version
+//	goroutines.txt  the stack of every goroutine, in the same format as the default SIGQUIT dump
+//	heap.txt        a summary of the heap profile and memory statistics
+//	expvar.json     a snapshot of every published expvar, as served by /debug/vars
+//	inflight.json   the requests being handled, as served by /v1/admin/inflight
+type diagnosticsBundle struct {
+	Key        string    `json:"key"`
+	Reason     string    `json:"reason"`
+	Size       int       `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
+	Hostname   string    `json:"hostname"`
+	PID        int       `json:"pid"`
+	GoVersion  string    `json:"go_version"`
+	Goroutines int       `json:"goroutines"`
+}
+
+// startDiagnosticsOnSIGQUIT writes a diagnostic bundle every time the process receives
+// SIGQUIT. Catching the signal replaces Go's default behaviour of dumping the goroutine stacks
+// and exiting, so the server keeps running and the dump ends up in the bundle instead.
+func (app *application) startDiagnosticsOnSIGQUIT() {
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGQUIT)
+
+		for range quit {
+			bundle, err := app.writeDiagnostics("sigquit")
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"signal": "SIGQUIT"})
+				continue
+			}
+
+			app.logger.PrintInfo("wrote diagnostic bundle", map[string]string{
+				"signal": "SIGQUIT",
+				"key":    bundle.Key,
+			})
+		}
+	}()
+}
+
+// writeDiagnostics captures a diagnostic bundle and writes it to the diagnostics blob store.
+// reason is recorded in the bundle and its key, such as "sigquit" or "admin".
+func (app *application) writeDiagnostics(reason string) (*diagnosticsBundle, error) {
+	if app.diagnostics == nil {
+		return nil, errors.New("diagnostics store is not configured")
+	}
+
+	now := time.Now().UTC()
+	hostname, _ := os.Hostname()
+
+	bundle := &diagnosticsBundle{
+		Key:        fmt.Sprintf("%s%s-%s.tar.gz", diagnosticsPrefix, now.Format("20060102T150405.000Z"), reason),
+		Reason:     reason,
+		CreatedAt:  now,
+		Hostname:   hostname,
+		PID:        os.Getpid(),
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+	}
+
+	archive, err := buildDiagnosticsArchive(bundle, app.inflight.snapshot(now))
+	if err != nil {
+		return nil, err
+	}
+	bundle.Size = len(archive)
+
+	err = app.diagnostics.Put(bundle.Key, archive)
+	if err != nil {
+		return nil, err
+	}
+
+	return bundle, nil
+}
+
+// buildDiagnosticsArchive captures the current state of the process and returns it as a
+// gzipped tar archive, in the layout described on diagnosticsBundle.
+func buildDiagnosticsArchive(bundle *diagnosticsBundle, inflight []inflightRequest) ([]byte, error) {
+	var goroutines, heap bytes.Buffer
+
+	err := pprof.Lookup("goroutine").WriteTo(&goroutines, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pprof.Lookup("heap").WriteTo(&heap, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// The values of expvars are already JSON, so they are copied in as they are.
+	vars := make(map[string]json.RawMessage)
+	expvar.Do(func(kv expvar.KeyValue) {
+		vars[kv.Key] = json.RawMessage(kv.Value.String())
+	})
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"bundle.json", bundle},
+		{"goroutines.txt", goroutines.Bytes()},
+		{"heap.txt", heap.Bytes()},
+		{"expvar.json", vars},
+		{"inflight.json", inflight},
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, f := range files {
+		content, ok := f.data.([]byte)
+		if !ok {
+			content, err = json.MarshalIndent(f.data, "", "\t")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = tw.WriteHeader(&tar.Header{
+			Name:    f.name,
+			Mode:    0o640,
+			Size:    int64(len(content)),
+			ModTime: bundle.CreatedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tw.Write(content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// createDiagnosticsHandler handles "POST /v1/admin/diagnostics". It writes the same bundle as
+// SIGQUIT, for when the on-call engineer can reach the API but not the host, and returns a
+// description of the bundle including its key in the blob store.
+func (app *application) createDiagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	bundle, err := app.writeDiagnostics("admin")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("wrote diagnostic bundle", map[string]string{
+		"key":     bundle.Key,
+		"user_id": fmt.Sprint(app.contextGetUser(r).ID),
+	})
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"diagnostics": bundle}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDiagnosticsArchive(t *testing.T) {
+	bundle := &diagnosticsBundle{Key: "diagnostics/x.tar.gz", Reason: "test", CreatedAt: time.Now()}
+	inflight := []inflightRequest{{ID: 1, Method: "GET", Path: "/v1/movies"}}
+
+	archive, err := buildDiagnosticsArchive(bundle, inflight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files[hdr.Name], err = io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"bundle.json", "goroutines.txt", "heap.txt", "expvar.json", "inflight.json"} {
+		if len(files[name]) == 0 {
+			t.Errorf("archive has no %s", name)
+		}
+	}
+
+	if !strings.Contains(string(files["goroutines.txt"]), "TestBuildDiagnosticsArchive") {
+		t.Error("goroutines.txt doesn't contain the stack of the test goroutine")
+	}
+
+	var vars map[string]interface{}
+	if err := json.Unmarshal(files["expvar.json"], &vars); err != nil {
+		t.Errorf("expvar.json is not valid JSON: %v", err)
+	}
+	if _, ok := vars["memstats"]; !ok {
+		t.Error("expvar.json has no memstats")
+	}
+
+	var requests []inflightRequest
+	if err := json.Unmarshal(files["inflight.json"], &requests); err != nil || len(requests) != 1 {
+		t.Errorf("inflight.json: want 1 request; got %v (%v)", requests, err)
+	}
+}
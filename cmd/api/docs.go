@@ -0,0 +1,25 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// docsPage is a Swagger UI page which reads the OpenAPI document from /v1/openapi.json. The
+// page itself is embedded in the binary, the Swagger UI scripts and styles are loaded from
+// the unpkg CDN by the browser.
+//
+//go:embed "docs/index.html"
+var docsPage []byte
+
+// docsHandler handles "GET /v1/docs" and serves the interactive API documentation. The route is
+// only registered outside of production, see routes.go.
+func (app *application) docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	_, err := w.Write(docsPage)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/errorreport"
 )
 
 // logError method is a generic helper for logging an error message in *application, as well
@@ -14,6 +18,16 @@ func (app *application) logError(r *http.Request, err error) {
 	})
 }
 
+// reportError sends err to the error reporter, along with the request and the user making it,
+// if they're authenticated.
+func (app *application) reportError(r *http.Request, err error) {
+	var user *errorreport.User
+	if u, ok := r.Context().Value(userContextKey).(*data.User); ok && !u.IsAnonymous() {
+		user = &errorreport.User{ID: u.ID, Email: u.Email}
+	}
+	app.errorReporter.Report(err, r, user)
+}
+
 // errorResponse method is a generic helper for sending JSON-formatted error messages to the
 // client with a given status code. Note that we're using an interface{} type for the message
 // parameter, rather than just a string type, as this gives us more flexibility over the values
@@ -21,10 +35,10 @@ func (app *application) logError(r *http.Request, err error) {
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	env := envelope{"error": message}
 
-	// Write the response using the writeJSON() helper. If this happens to return an error
+	// Write the response using the writeResponse() helper. If this happens to return an error
 	// then log it, and fall back to sending the client an empty response with a 500 Internal
 	// Server Error status code
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeResponse(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -37,6 +51,7 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 // to the client
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
+	app.reportError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
 	app.errorResponse(w, r, 500, message)
@@ -57,8 +72,27 @@ func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.
 }
 
 // badRequestResponse sends JSON-formatted error message with 400 Bad Request status code.
+// Errors from readJSON about the size or shape of the body get their own status codes: 413
+// Request Entity Too Large for a body over the size limit, and a 422 Unprocessable Entity
+// validation error naming the offending field for one which exceeds the jsonLimits.
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	var tooLarge *bodyTooLargeError
+	var limitErr *jsonLimitError
+
+	switch {
+	case errors.As(err, &tooLarge):
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.As(err, &limitErr):
+		app.failedValidationResponse(w, r, map[string]string{limitErr.field(): limitErr.message})
+	default:
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	}
+}
+
+// unsupportedMediaTypeResponse sends a JSON-formatted error message with a 415 Unsupported
+// Media Type status code to a client whose request body isn't in a format the API accepts.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, err.Error())
 }
 
 // failedValidationResponse sends JSON-formatted error message to client with UnprocessableEntity
@@ -76,13 +110,30 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// preconditionFailedResponse sends a JSON-formatted error message to the client with a 412
+// Precondition Failed status code. It is used when the If-Match header of a request doesn't
+// match the current ETag of the record.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since you fetched it, please fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
 // rateLimitExceedResponse sends a JSON-formatted error message with a 429 Too Many Requests
 // status code to the client.
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	app.countRateLimitRejection(r)
 	message := "rate limited exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+// quotaExceededResponse sends a JSON-formatted error message with a 429 Too Many Requests
+// status code to a user who has used up their monthly request quota.
+func (app *application) quotaExceededResponse(w http.ResponseWriter, r *http.Request) {
+	app.countRateLimitRejection(r)
+	message := "monthly request quota exceeded, see /v1/users/me/usage"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 // invalidCredentialsResponse sends a JSON-formatted error with a 401 Unauthorized status code
 // to the client.
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
@@ -125,3 +176,24 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// viewAsDeniedResponse sends a JSON-formatted error with a 403 Forbidden status code to an
+// administrator requesting a resource private to the user with X-View-As, see denyViewAs.
+func (app *application) viewAsDeniedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource is private to the user and can't be viewed with the X-View-As header"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// notOwnerResponse sends a JSON-formatted error with a 403 Forbidden status code to a user who
+// may only change the records they created, and tried to change someone else's.
+func (app *application) notOwnerResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account can only change the records it created"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// orgNotFoundResponse sends a JSON-formatted error with a 404 Not Found status code when the
+// X-Organization header names an organization which doesn't exist or the user isn't a member of.
+func (app *application) orgNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the organization could not be found, or your user account isn't a member of it"
+	app.errorResponse(w, r, http.StatusNotFound, message)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/saalikmubeen/greenlight/internal/codec"
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+// movieETag returns a strong ETag for a movie. Every change to a movie increments its version
+// number, so the id and version together identify the exact representation we send.
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"movie-%d-%d"`, movie.ID, movie.Version)
+}
+
+// movieNoteETag returns the ETag of a movie as sent to a user who has written note about it,
+// which is part of the representation too. It is movieETag when note is nil.
+func movieNoteETag(movie *data.Movie, note *data.Note) string {
+	if note == nil {
+		return movieETag(movie)
+	}
+	return fmt.Sprintf(`"movie-%d-%d-note-%d"`, movie.ID, movie.Version, note.UpdatedAt.Unix())
+}
+
+// noteETagRX matches the part of a movie ETag added by movieNoteETag.
+var noteETagRX = regexp.MustCompile(`-note-\d+`)
+
+// moviePreconditionFailed is preconditionFailed for a request changing movie. Updating or
+// deleting a movie doesn't change the user's note about it, so the note part of the ETags in
+// If-Match is ignored, and the client can send back whichever ETag it was sent for the movie.
+func moviePreconditionFailed(r *http.Request, movie *data.Movie) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return false
+	}
+
+	return !etagMatchesStrong(noteETagRX.ReplaceAllString(ifMatch, ""), representationETag(r, movieETag(movie)))
+}
+
+// movieListETag returns a strong ETag for a page of movies. The page is fully described by the
+// id and version of every movie in it together with the pagination metadata, so we hash those
+// rather than the encoded response body.
+func movieListETag(movies []*data.Movie, metadata data.Metadata) string {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%d:%d:%d;", metadata.CurrentPage, metadata.PageSize, metadata.TotalRecords)
+	for _, movie := range movies {
+		fmt.Fprintf(h, "%d:%d;", movie.ID, movie.Version)
+	}
+
+	return `"movies-` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// representationETag returns the ETag of the representation negotiated for the request (see
+// writeResponse). The ETags above describe the JSON representation, so the XML and MessagePack
+// ones get a suffix: a strong ETag has to differ between representations of the same record.
+func representationETag(r *http.Request, etag string) string {
+	var suffix string
+
+	switch negotiateMediaType(r.Header.Get("Accept")) {
+	case codec.XML:
+		suffix = "-xml"
+	case codec.MessagePack:
+		suffix = "-msgpack"
+	default:
+		return etag
+	}
+
+	return strings.TrimSuffix(etag, `"`) + suffix + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches the given ETag. The header
+// may contain a comma-separated list of ETags or "*". If-None-Match uses the weak comparison
+// function (RFC 7232, section 3.2), so a W/ prefix is ignored.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// etagMatchesStrong reports whether an If-Match header value matches the given ETag. Unlike
+// If-None-Match, If-Match uses the strong comparison function (RFC 7232, section 3.1), so weak
+// ETags never match. "*" matches any current representation.
+func etagMatchesStrong(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// preconditionFailed reports whether the request carries an If-Match header which doesn't
+// match the given ETag, in which case the handler must not apply the change and should send a
+// 412 Precondition Failed response. Requests without If-Match always pass.
+func preconditionFailed(r *http.Request, etag string) bool {
+	etag = representationETag(r, etag)
+
+	ifMatch := r.Header.Get("If-Match")
+	return ifMatch != "" && !etagMatchesStrong(ifMatch, etag)
+}
+
+// notModified sets the ETag header on the response and, if the request's If-None-Match header
+// matches it, sends a 304 Not Modified response with no body. Handlers should return without
+// writing anything else when it returns true.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	etag = representationETag(r, etag)
+	w.Header().Set("ETag", etag)
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		etag        string
+		want        bool
+	}{
+		{`"movie-1-2"`, `"movie-1-2"`, true},
+		{`"movie-1-1"`, `"movie-1-2"`, false},
+		{`W/"movie-1-2"`, `"movie-1-2"`, true},
+		{`"movie-1-1", "movie-1-2"`, `"movie-1-2"`, true},
+		{`*`, `"movie-1-2"`, true},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, tt.etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q): want %t; got %t", tt.ifNoneMatch, tt.etag, tt.want, got)
+		}
+	}
+}
+
+func TestETagMatchesStrong(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		etag    string
+		want    bool
+	}{
+		{`"movie-1-2"`, `"movie-1-2"`, true},
+		{`"movie-1-1"`, `"movie-1-2"`, false},
+		{`W/"movie-1-2"`, `"movie-1-2"`, false},
+		{`"movie-1-1", "movie-1-2"`, `"movie-1-2"`, true},
+		{`*`, `"movie-1-2"`, true},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatchesStrong(tt.ifMatch, tt.etag); got != tt.want {
+			t.Errorf("etagMatchesStrong(%q, %q): want %t; got %t", tt.ifMatch, tt.etag, tt.want, got)
+		}
+	}
+}
+
+func TestMovieETagWithNote(t *testing.T) {
+	app := newTestApp()
+	movie := &data.Movie{ID: 1, Version: 2}
+	note := &data.Note{UpdatedAt: time.Unix(1700000000, 0)}
+
+	for _, accept := range []string{"application/json", "application/xml"} {
+		// GET /v1/movies/1 by a user who has a note about the movie.
+		get := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+		get.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		app.notModified(rr, get, movieNoteETag(movie, note))
+		etag := rr.Header().Get("ETag")
+
+		// PATCH /v1/movies/1 with the ETag the client was sent.
+		patch := httptest.NewRequest(http.MethodPatch, "/v1/movies/1", nil)
+		patch.Header.Set("Accept", accept)
+		patch.Header.Set("If-Match", etag)
+		if moviePreconditionFailed(patch, movie) {
+			t.Errorf("%s: If-Match %s failed for the unchanged movie", accept, etag)
+		}
+
+		changed := &data.Movie{ID: 1, Version: 3}
+		if !moviePreconditionFailed(patch, changed) {
+			t.Errorf("%s: If-Match %s passed for a changed movie", accept, etag)
+		}
+	}
+}
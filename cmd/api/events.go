@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/events"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+const (
+	// eventHistorySize is how many events the bus keeps for clients resuming the change feed.
+	eventHistorySize = 1000
+	// eventBufferSize is how many events may be waiting to be sent to a single client before it
+	// is disconnected; it can then resume from the last event it received.
+	eventBufferSize = 64
+
+	// sseStreamDuration is how long a single change feed response lasts. It has to end before
+	// the server's WriteTimeout, after which EventSource clients reconnect on their own and
+	// resume with the Last-Event-ID header.
+	sseStreamDuration = 25 * time.Second
+	// sseRetry is the reconnection delay sent to clients.
+	sseRetry = time.Second
+	// sseHeartbeat is how often a comment is sent on an idle stream, so that proxies don't
+	// close it.
+	sseHeartbeat = 10 * time.Second
+
+	// eventReset tells change feed clients that events may have been missed, and that they
+	// should reload the movies instead of relying on the feed.
+	eventReset = "reset"
+)
+
+// publishEvent publishes an event on the application's event bus, which feeds the movie change
+// feed, and queues its delivery to the webhook subscriptions for its type. When the database
+// listener is enabled, movie events reach the bus through it instead, so that the change feed
+// of every instance carries the changes made through every other instance, see listener.go.
+func (app *application) publishEvent(eventType string, payload envelope) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"event_type": eventType})
+		return
+	}
+
+	app.publishOnBus(eventType, js)
+
+	if validator.In(eventType, data.EventTypes...) {
+		app.enqueueWebhookDeliveries(eventType, js)
+	}
+}
+
+// commitWithEvent commits a transaction together with the outbox message which delivers an
+// event to the webhook subscriptions for its type, and then publishes the event on the bus like
+// publishEvent.
+func (app *application) commitWithEvent(tx *data.Tx, eventType string, payload envelope) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if validator.In(eventType, data.EventTypes...) {
+		msg, err := newWebhookMessage(eventType, js)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Enqueue(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	app.publishOnBus(eventType, js)
+
+	return nil
+}
+
+// publishOnBus publishes an event on the bus. When the database listener is enabled, movie
+// events reach the bus through it instead, but only once the database has notified it of the
+// change, so they're announced to the bus handlers right away: the caches must drop what the
+// change makes stale before the client which made it reads it back.
+func (app *application) publishOnBus(eventType string, js json.RawMessage) {
+	if app.config.db.listen && isMovieEvent(eventType) {
+		app.events.Announce(eventType, js)
+		return
+	}
+
+	app.events.Publish(eventType, js)
+}
+
+// invalidateCaches is registered as a handler on the event bus, so that every movie change,
+// whichever instance or tool made it, drops what it makes stale from the movie cache and the
+// response cache. Events which don't name a movie, such as "reset" after which changes may
+// have been missed, empty both caches.
+func (app *application) invalidateCaches(event events.Event) {
+	if !isMovieEvent(event.Type) {
+		return
+	}
+
+	var payload struct {
+		Movie struct {
+			ID      int64 `json:"id"`
+			Version int32 `json:"version"`
+		} `json:"movie"`
+	}
+	if err := json.Unmarshal(event.Data, &payload); err != nil || payload.Movie.ID == 0 {
+		app.models.Movies.Cache.Purge()
+		if app.responseCache != nil {
+			app.responseCache.Purge()
+		}
+		return
+	}
+
+	id := payload.Movie.ID
+	if event.Type == data.EventMovieDeleted {
+		app.models.Movies.Cache.Delete(id)
+	} else {
+		app.models.Movies.Cache.Expire(id, payload.Movie.Version)
+	}
+
+	if app.responseCache != nil {
+		app.responseCache.Invalidate(movieListCacheTag, movieCacheTag(id))
+	}
+}
+
+// movieEventsHandler handles "GET /v1/movies/events". It streams a Server-Sent Event for every
+// movie created, updated or deleted, with the event type as the SSE event name and the movie
+// (only its id for deletes) as the data.
+//
+// Every event has an id, made of the bus epoch and the bus event id, so clients which reconnect
+// with the Last-Event-ID header get the events they missed, as long as they are still in the
+// bus history. If they aren't, e.g. because the server restarted or the client reconnected to
+// another instance, a "reset" event is sent first to tell the client to reload the movies it
+// shows. A "reset" event is also sent when the database listener had to reconnect, see
+// listener.go.
+func (app *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("response writer does not support flushing"))
+		return
+	}
+
+	lastID, known := parseLastEventID(r.Header.Get("Last-Event-ID"), app.events.Epoch())
+
+	sub, backlog, complete := app.events.Subscribe(lastID)
+	defer sub.Cancel()
+
+	if !known {
+		complete = false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+
+	if !complete {
+		writeServerSentEvent(w, app.events.Epoch(), events.Event{ID: sub.Start, Type: eventReset, Data: json.RawMessage("{}")})
+	}
+
+	for _, event := range backlog {
+		if isMovieEvent(event.Type) {
+			writeServerSentEvent(w, app.events.Epoch(), event)
+		}
+	}
+
+	flusher.Flush()
+
+	deadline := time.NewTimer(sseStreamDuration)
+	defer deadline.Stop()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline.C:
+			return
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !isMovieEvent(event.Type) {
+				continue
+			}
+
+			err := writeServerSentEvent(w, app.events.Epoch(), event)
+			if err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+// writeServerSentEvent writes an event in the text/event-stream format. The data is JSON
+// encoded without newlines, so it always fits on a single "data:" line.
+func writeServerSentEvent(w io.Writer, epoch string, event events.Event) error {
+	_, err := fmt.Fprintf(w, "id: %s-%d\nevent: %s\ndata: %s\n\n", epoch, event.ID, event.Type, event.Data)
+	return err
+}
+
+// parseLastEventID parses a Last-Event-ID header sent by writeServerSentEvent. known is false
+// if the header is malformed or comes from a bus with another epoch; an empty header is known
+// and means that the client hasn't seen any events yet.
+func parseLastEventID(header, epoch string) (lastID int64, known bool) {
+	if header == "" {
+		return 0, true
+	}
+
+	headerEpoch, id, ok := strings.Cut(header, "-")
+	if !ok || headerEpoch != epoch {
+		return 0, false
+	}
+
+	lastID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || lastID < 0 {
+		return 0, false
+	}
+
+	return lastID, true
+}
+
+// isMovieEvent reports whether an event belongs on the movie change feed.
+func isMovieEvent(eventType string) bool {
+	return strings.HasPrefix(eventType, "movie.") || eventType == eventReset
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestMovieEventsHandler(t *testing.T) {
+	app := newTestApp()
+
+	ts := httptest.NewServer(http.HandlerFunc(app.movieEventsHandler))
+	defer ts.Close()
+
+	app.events.Publish(data.EventMovieCreated, json.RawMessage(`{"movie":{"id":1}}`))
+	app.events.Publish(data.EventUserActivated, json.RawMessage(`{"user":{"id":1}}`))
+	app.events.Publish(data.EventMovieDeleted, json.RawMessage(`{"movie":{"id":1}}`))
+
+	// readEvents connects with the given Last-Event-ID and returns the lines of the first n
+	// events, skipping the retry field.
+	readEvents := func(lastEventID string, n int) []string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("got Content-Type %q", ct)
+		}
+
+		var lines []string
+		scanner := bufio.NewScanner(resp.Body)
+		for n > 0 && scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "retry:"):
+				scanner.Scan()
+			case line == "":
+				n--
+			default:
+				lines = append(lines, line)
+			}
+		}
+
+		return lines
+	}
+
+	epoch := app.events.Epoch()
+
+	// The user event is left out of the feed.
+	got := strings.Join(readEvents(epoch+"-1", 1), "\n")
+	want := "id: " + epoch + "-3\nevent: movie.deleted\ndata: {\"movie\":{\"id\":1}}"
+	if got != want {
+		t.Errorf("resuming after event 1: got\n%s\nwant\n%s", got, want)
+	}
+
+	for _, lastEventID := range []string{epoch + "-7", "0123456789abcdef-1", "1"} {
+		got = strings.Join(readEvents(lastEventID, 1), "\n")
+		want = "id: " + epoch + "-3\nevent: reset\ndata: {}"
+		if got != want {
+			t.Errorf("resuming after %q: got\n%s\nwant\n%s", lastEventID, got, want)
+		}
+	}
+}
+
+func TestParseLastEventID(t *testing.T) {
+	tests := []struct {
+		header string
+		lastID int64
+		known  bool
+	}{
+		{"", 0, true},
+		{"abc-0", 0, true},
+		{"abc-42", 42, true},
+		{"def-42", 0, false},
+		{"abc-x", 0, false},
+		{"abc--1", 0, false},
+		{"42", 0, false},
+	}
+
+	for _, tt := range tests {
+		lastID, known := parseLastEventID(tt.header, "abc")
+		if lastID != tt.lastID || known != tt.known {
+			t.Errorf("parseLastEventID(%q) = %d, %v; want %d, %v", tt.header, lastID, known, tt.lastID, tt.known)
+		}
+	}
+}
@@ -2,6 +2,10 @@ package main
 
 import (
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/migrations"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -15,12 +19,97 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		},
 	}
 
+	// Report the schema migration status, so that deploy tooling can hold back traffic while
+	// the schema is behind this build or a migration failed part way through. The test
+	// application has no database, so there is nothing to report there.
+	if app.models.Migrations.DB != nil {
+		status, err := app.schemaStatus()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["schema"] = status
+	}
+
+	// Report whether the SMTP server accepts our connection and credentials, so that deploy
+	// tooling notices a misconfiguration before the first registration email fails.
+	if app.config.smtp.check {
+		env["smtp"] = app.checkSMTP()
+	}
+
 	// Add a 4 second delay to test for graceful shutdown of the server.
 	// time.Sleep(4 * time.Second)
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeResponse(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 }
+
+// schemaStatus describes the database schema in the healthcheck response. Latest is the
+// newest migration embedded in this build, and pending is true when the database hasn't
+// been migrated up to it yet.
+type schemaStatus struct {
+	Version int64 `json:"version"`
+	Latest  int64 `json:"latest"`
+	Dirty   bool  `json:"dirty"`
+	Pending bool  `json:"pending"`
+}
+
+func (app *application) schemaStatus() (schemaStatus, error) {
+	applied, err := app.models.Migrations.Status()
+	if err != nil {
+		return schemaStatus{}, err
+	}
+
+	latest, err := migrations.Latest()
+	if err != nil {
+		return schemaStatus{}, err
+	}
+
+	return schemaStatus{
+		Version: applied.Version,
+		Latest:  latest,
+		Dirty:   applied.Dirty,
+		Pending: applied.Version < latest,
+	}, nil
+}
+
+// smtpCheckTTL is how long the outcome of an SMTP check is reused for, so that frequent
+// readiness probes don't each open a connection to the SMTP server.
+const smtpCheckTTL = time.Minute
+
+// smtpStatus describes the outcome of the latest SMTP check in the healthcheck response. The
+// error itself is only logged, as the response is public.
+type smtpStatus struct {
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// smtpCheckState holds the outcome of the latest SMTP check. The zero value is ready to use.
+type smtpCheckState struct {
+	mu   sync.Mutex
+	last smtpStatus
+}
+
+// checkSMTP connects and authenticates to the SMTP server, unless it was checked within
+// smtpCheckTTL, and returns the outcome. Concurrent calls wait for the same check.
+func (app *application) checkSMTP() smtpStatus {
+	app.smtpCheck.mu.Lock()
+	defer app.smtpCheck.mu.Unlock()
+
+	if last := app.smtpCheck.last; !last.CheckedAt.IsZero() && time.Since(last.CheckedAt) < smtpCheckTTL {
+		return last
+	}
+
+	status := smtpStatus{Status: "available", CheckedAt: time.Now().UTC()}
+	err := app.mailer.Check()
+	if err != nil {
+		status.Status = "unavailable"
+		app.logger.PrintError(err, map[string]string{"check": "smtp", "smtp_host": app.config.smtp.host})
+	}
+
+	app.smtpCheck.last = status
+	return status
+}
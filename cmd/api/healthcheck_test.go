@@ -1,8 +1,16 @@
 package main
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+	"github.com/saalikmubeen/greenlight/internal/mailer"
 )
 
 // TestHealthcheck tests ping handler for the correct response status code, 200 and
@@ -31,3 +39,45 @@ func TestHealthcheck(t *testing.T) {
 		t.Errorf("want body to equal %q,\n but got %q", expResp, string(body))
 	}
 }
+
+// checkingSender is a mailer.Sender whose Check fails with err, counting the checks.
+type checkingSender struct {
+	checks int
+	err    error
+}
+
+func (s *checkingSender) Send(msg *mailer.Message) error {
+	return nil
+}
+
+func (s *checkingSender) Check() error {
+	s.checks++
+	return s.err
+}
+
+func TestHealthcheckSMTP(t *testing.T) {
+	app := newTestApp()
+	app.logger = jsonlog.NewLogger(io.Discard, jsonlog.LevelOff)
+	app.config.smtp.check = true
+	sender := &checkingSender{err: errors.New("535 authentication failed")}
+	app.mailer = mailer.New(sender, "Greenlight <no-reply@example.com>", mailer.DefaultRetryPolicy)
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		app.healthcheckHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil))
+		if body := rr.Body.String(); rr.Code != http.StatusOK || !strings.Contains(body, `"status": "unavailable"`) ||
+			strings.Contains(body, "535") {
+			t.Errorf("got %d %s; want the SMTP server reported unavailable, without the error", rr.Code, body)
+		}
+	}
+	if sender.checks != 1 {
+		t.Errorf("got %d checks; want the outcome reused within smtpCheckTTL", sender.checks)
+	}
+
+	// A stale outcome is checked again.
+	sender.err = nil
+	app.smtpCheck.last.CheckedAt = time.Now().Add(-smtpCheckTTL)
+	if status := app.checkSMTP(); status.Status != "available" || sender.checks != 2 {
+		t.Errorf("got %+v after %d checks; want the server checked again", status, sender.checks)
+	}
+}
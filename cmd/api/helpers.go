@@ -1,16 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
 	"net/http"
 	"net/url"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/saalikmubeen/greenlight/internal/codec"
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/errorreport"
 	"github.com/saalikmubeen/greenlight/internal/validator"
 )
 
@@ -20,29 +29,78 @@ type envelope map[string]interface{}
 // readIDParam reads interpolated "id" from request URL and returns it and nil. If there is an error
 // it returns and 0 and an error.
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// readNamedIDParam works like readIDParam but for any named URL parameter, for routes which
+// contain more than one id, such as "/v1/movies/:id/comments/:comment_id".
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
 }
 
+// dispatchIDParam returns a handler for a route ending in the ":id" wildcard which also needs to
+// serve one or more static paths in the same position, such as "/v1/movies/bulk-delete". The
+// version of httprouter we use panics if a static segment and a wildcard share a position, so
+// the static paths are registered through the wildcard and dispatched here on its value. All
+// other values go to next, or get a 404 Not Found response if next is nil.
+func (app *application) dispatchIDParam(static map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := static[params.ByName("id")]; ok {
+			handler(w, r)
+			return
+		}
+
+		if next == nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
 // writeJSON marshals data structure to encoded JSON response. It returns an error if there are
-// any issues, else error is nil.
+// any issues, else error is nil. Handlers should normally use writeResponse instead, which
+// also honors the Accept header.
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope,
 	headers http.Header) error {
-	// Use the json.MarshalIndent() function so that whitespace is added to the encoded JSON. Use
-	// no line prefix and tab indents for each element.
-	js, err := json.MarshalIndent(data, "", "\t")
+	return app.writeEncoded(w, status, codec.JSON, data, headers)
+}
+
+// writeResponse works like writeJSON, but encodes the response as XML or MessagePack instead
+// of JSON if the request's Accept header prefers one of them. The envelope is the same in all
+// three encodings.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope,
+	headers http.Header) error {
+	// The body now depends on the Accept header, so tell caches about it.
+	w.Header().Add("Vary", "Accept")
+
+	return app.writeEncoded(w, status, negotiateMediaType(r.Header.Get("Accept")), data, headers)
+}
+
+// writeEncoded marshals data to the given media type and writes it as the response.
+func (app *application) writeEncoded(w http.ResponseWriter, status int, mediaType string, data envelope,
+	headers http.Header) error {
+	// Indent JSON and XML with tabs so that the output is easy to read. MessagePack ignores the
+	// indent.
+	body, err := codec.Marshal(mediaType, data, "\t")
 	if err != nil {
 		return err
 	}
 
-	// Append a newline to make it easier to view in terminal applications.
-	js = append(js, '\n')
+	// Append a newline to the text encodings to make it easier to view in terminal applications.
+	if mediaType != codec.MessagePack {
+		body = append(body, '\n')
+	}
 
 	// At this point, we know that we won't encounter any more errors before writing the response,
 	// so it's safe to add any headers that we want to include. We loop through the header map
@@ -53,10 +111,10 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 		w.Header()[key] = value
 	}
 
-	// Add the "Content-Type: application/json" header, then write the status code and JSON response.
-	w.Header().Set("Content-Type", "application/json")
+	// Add the Content-Type header, then write the status code and the response body.
+	w.Header().Set("Content-Type", mediaType)
 	w.WriteHeader(status)
-	if _, err := w.Write(js); err != nil {
+	if _, err := w.Write(body); err != nil {
 		app.logger.PrintError(err, nil)
 		return err
 	}
@@ -65,22 +123,63 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 }
 
 // readJSON decodes request Body into corresponding Go type. It triages for any potential errors
-// and returns corresponding appropriate errors.
+// and returns corresponding appropriate errors. The body is checked against the configured
+// jsonLimits before it is decoded.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	return app.readJSONWithLimits(w, r, dst, app.config.json)
+}
+
+// readJSONWithLimits works like readJSON but with the given limits, for endpoints which need
+// tighter limits than the configured ones.
+func (app *application) readJSONWithLimits(w http.ResponseWriter, r *http.Request, dst interface{}, limits jsonLimits) error {
 	// Use http.MaxBytesReader() to limit the size of the request body to 1MB to prevent
 	// any potential nefarious DoS attacks.
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
+	// Read the whole body, so that its shape can be checked before anything is decoded. If the
+	// request body exceeds 1MB in size then reading it will fail with the error "http: request
+	// body too large". There is an open issue about turning this into a distinct error type at
+	// https://github.com/golang/go/issues/30715.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		if err.Error() == "http: request body too large" {
+			return &bodyTooLargeError{maxBytes: maxBytes}
+		}
+		return err
+	}
+
+	// XML and MessagePack bodies are converted to JSON up front, so that they are decoded and
+	// checked in exactly the same way as JSON bodies below.
+	if mediaType := requestMediaType(r); mediaType == codec.XML || mediaType == codec.MessagePack {
+		body, err = codec.ToJSON(mediaType, body)
+		if err != nil {
+			return fmt.Errorf("body contains badly-formed %s: %s", codecNames[mediaType],
+				strings.TrimPrefix(err.Error(), "codec: "))
+		}
+	}
+
+	err = checkJSONLimits(body, limits)
+	if err != nil {
+		return err
+	}
+
+	// Unless strict types are disabled, a value of the wrong JSON type is an error below.
+	if limits.coerceTypes {
+		body = coerceJSONTypes(body, reflect.TypeOf(dst))
+	}
+
 	// Initialize the json.Decoder, and call the DisallowUnknownFields() method on it
-	// before decoding. So, if the JSON from the client includes any field which
-	// cannot be mapped to the target destination, the decoder will return an error
-	// instead of just ignoring the field.
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
+	// before decoding, unless the limits ignore them. So, if the JSON from the client
+	// includes any field which cannot be mapped to the target destination, the decoder
+	// will return an error instead of just ignoring the field.
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if !limits.ignoreUnknownFields {
+		dec.DisallowUnknownFields()
+	}
 
 	// Decode the request body to the destination.
-	err := dec.Decode(dst)
+	err = dec.Decode(dst)
 	if err != nil {
 		// If there is an error during decoding, start the error triage...
 		var syntaxError *json.SyntaxError
@@ -128,12 +227,6 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
 			return fmt.Errorf("body contains unknown key %s", fieldName)
 
-		// If the request body exceeds 1MB in size then decode will now fail with the
-		// error "http: request body too large". There is an open issue about turning
-		// this into a distinct error type at https://github.com/golang/go/issues/30715.
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
-
 		// A json.InvalidUnmarshalError error will be returned if we pass a non-nil
 		// pointer to Decode(). We catch this and panic, rather than returning an error
 		// to our handler. At the end of this chapter we'll talk about panicking
@@ -160,6 +253,23 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// codecNames are the names of the request body encodings used in error messages.
+var codecNames = map[string]string{
+	codec.XML:         "XML",
+	codec.MessagePack: "MessagePack",
+}
+
+// requestMediaType returns the canonical media type of the request body from its Content-Type
+// header, or an empty string if it is missing or not one of the encodings in the codec package.
+func requestMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	return codec.Canonical(mediaType)
+}
+
 // url.Values:
 // type Values map[string][]string
 
@@ -220,8 +330,94 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readTime is a helper method on application type that reads an RFC3339 time from the URL query
+// string. If no matching key is found then it returns the zero time. If the value couldn't be
+// parsed, then we record an error message in the provided Validator instance, and return the
+// zero time.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC3339 time")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// readBool is a helper method on application type that reads a boolean, such as "true", "false",
+// "1" or "0", from the URL query string. If no matching key is found then it returns the
+// provided default value. If the value couldn't be parsed, then we record an error message in
+// the provided Validator instance, and return the default value.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// readFloat is a helper method on application type that reads a decimal number from the URL
+// query string. If no matching key is found then it returns the provided default value. If the
+// value couldn't be parsed, or isn't a finite number, then we record an error message in the
+// provided Validator instance, and return the default value.
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+
+	return f
+}
+
+// readDate is a helper method on application type that reads a calendar date, such as
+// 2024-03-31, from the URL query string. If no matching key is found then it returns the zero
+// time. If the value couldn't be parsed, then we record an error message in the provided
+// Validator instance, and return the zero time. The date is returned as midnight UTC.
+func (app *application) readDate(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(validator.DateLayout, s)
+	if err != nil {
+		v.AddError(key, "must be a date in the format YYYY-MM-DD")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// readIntRange is a helper method on application type that reads an integer from the URL query
+// string like readInt does, and also records an error message in the provided Validator
+// instance if it is outside of the range min to max, inclusive.
+func (app *application) readIntRange(qs url.Values, key string, defaultValue, min, max int, v *validator.Validator) int {
+	i := app.readInt(qs, key, defaultValue, v)
+	v.Check(i >= min && i <= max, key, fmt.Sprintf("must be between %d and %d", min, max))
+	return i
+}
+
 // background is a helper that accepts an arbitrary function as a parameter and runs it in a
-// in goroutine in the background.
+// in goroutine in the background. A panic in fn is recovered with recoverBackgroundPanic, so
+// that it doesn't crash the server.
 func (app *application) background(fn func()) {
 	// Increment the WaitGroup counter
 	app.wg.Add(1)
@@ -231,13 +427,138 @@ func (app *application) background(fn func()) {
 		defer app.wg.Done() // similar to app.wg.Add(-1)
 
 		// Recover from any panic
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
-			}
-		}()
+		defer app.recoverBackgroundPanic(nil)
 
 		// Execute the arbitrary function that we passed as the parameter
 		fn()
 	}()
 }
+
+// recoverBackgroundPanic recovers from a panic in a goroutine running in the background, which
+// would otherwise crash the whole server, as there is no recoverPanic middleware there. The
+// panic is logged with its stack trace and the properties describing the task, and sent to the
+// error reporter. It must be deferred by the function running in the background, e.g.
+//
+//	defer app.recoverBackgroundPanic(map[string]string{"job": "outbox"})
+func (app *application) recoverBackgroundPanic(properties map[string]string) {
+	p := recover()
+	if p == nil {
+		return
+	}
+
+	// The stack trace of the entry is taken here, while the frames which panicked are still
+	// on the stack.
+	err := errorreport.Recovered(p)
+	app.logger.PrintError(fmt.Errorf("panic in background task: %w", err), properties)
+	app.errorReporter.Report(err, nil, nil)
+}
+
+// sendEmail sends an email using the mailer, unless the recipient's address is on the
+// suppression list because earlier mail to it bounced or was marked as spam. Sending to a
+// suppressed address is not an error, it is just skipped.
+func (app *application) sendEmail(recipient, templateFile string, data interface{}) error {
+	suppressed, err := app.models.Suppressed.Exists(recipient)
+	if err != nil {
+		return err
+	}
+
+	if suppressed {
+		app.logger.PrintInfo("email not sent to suppressed address", map[string]string{
+			"template": templateFile,
+		})
+		return nil
+	}
+
+	return app.mailer.Send(recipient, templateFile, data)
+}
+
+// backoffDelay returns how long to wait before retrying something which has failed attempts
+// times. The delay doubles with every attempt, starting at base and capped at max.
+func backoffDelay(attempts int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	return delay
+}
+
+// negotiateCollation picks a collation for sorting from an Accept-Language header. Languages
+// are tried in order of preference and the first one with a matching entry in data.Collations
+// wins; only the primary subtag is used, so "fr-CA" selects the "fr" collation. An empty string
+// is returned if nothing matches.
+func negotiateCollation(acceptLanguage string) string {
+	for _, tag := range preferredValues(acceptLanguage) {
+		primary, _, _ := strings.Cut(tag, "-")
+		if _, ok := data.Collations[primary]; ok {
+			return primary
+		}
+	}
+
+	return ""
+}
+
+// negotiateMediaType picks the encoding of a response from an Accept header. Media types are
+// tried in order of preference and the first one supported by the codec package wins. A
+// missing header, a wildcard, or a header listing only unsupported types all select JSON, so
+// clients which don't ask for anything in particular keep getting JSON.
+func negotiateMediaType(accept string) string {
+	for _, mediaType := range preferredValues(accept) {
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return codec.JSON
+		}
+		if canonical := codec.Canonical(mediaType); canonical != "" {
+			return canonical
+		}
+	}
+
+	return codec.JSON
+}
+
+// preferredValues parses a header with quality values, such as Accept or Accept-Language, and
+// returns its lower-cased values in order of preference. Values with q=0 are dropped.
+func preferredValues(header string) []string {
+	type value struct {
+		v string
+		q float64
+	}
+
+	var values []value
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		v := strings.ToLower(strings.TrimSpace(fields[0]))
+		if v == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+
+		if q > 0 {
+			values = append(values, value{v: v, q: q})
+		}
+	}
+
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].q > values[j].q
+	})
+
+	preferred := make([]string, len(values))
+	for i, v := range values {
+		preferred[i] = v.v
+	}
+
+	return preferred
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/codec"
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+func TestNegotiateCollation(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", ""},
+		{"fr-CA", "fr"},
+		{"xx, de;q=0.8, en;q=0.9", "en"},
+		{"en;q=0, sv", "sv"},
+		{"*", ""},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateCollation(tt.acceptLanguage); got != tt.want {
+			t.Errorf("negotiateCollation(%q): want %q; got %q", tt.acceptLanguage, tt.want, got)
+		}
+	}
+}
+
+func TestNegotiateMediaType(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", codec.JSON},
+		{"*/*", codec.JSON},
+		{"application/xml", codec.XML},
+		{"text/xml", codec.XML},
+		{"application/x-msgpack", codec.MessagePack},
+		{"application/json;q=0.5, application/msgpack", codec.MessagePack},
+		{"text/html, application/xml;q=0.9, */*;q=0.8", codec.XML},
+		{"text/html", codec.JSON},
+		{"application/xml;q=0, application/*", codec.JSON},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateMediaType(tt.accept); got != tt.want {
+			t.Errorf("negotiateMediaType(%q): want %q; got %q", tt.accept, tt.want, got)
+		}
+	}
+}
+
+func TestBackgroundPanic(t *testing.T) {
+	var buf bytes.Buffer
+	app := newTestApp()
+	app.logger = jsonlog.NewLogger(&buf, jsonlog.LevelInfo)
+
+	app.background(func() {
+		panicInBackground()
+	})
+	app.wg.Wait()
+
+	got := buf.String()
+	if !strings.Contains(got, `"message":"panic in background task: boom"`) {
+		t.Errorf("got %q; want the panic logged", got)
+	}
+	if !strings.Contains(got, "panicInBackground") {
+		t.Errorf("got %q; want the stack trace of the panic", got)
+	}
+}
+
+func panicInBackground() {
+	panic("boom")
+}
+
+func TestReadQueryParams(t *testing.T) {
+	app := newTestApp()
+	qs := url.Values{
+		"dry_run":   {"true"},
+		"bad_bool":  {"yes"},
+		"rating":    {"7.5"},
+		"bad_float": {"NaN"},
+		"since":     {"2024-02-29"},
+		"bad_date":  {"2023-02-29"},
+		"limit":     {"20"},
+		"too_big":   {"500"},
+		"bad_int":   {"ten"},
+	}
+	v := validator.New()
+
+	if got := app.readBool(qs, "dry_run", false, v); !got {
+		t.Errorf("readBool(dry_run): want true; got %v", got)
+	}
+	if got := app.readBool(qs, "missing", true, v); !got {
+		t.Errorf("readBool(missing): want the default true; got %v", got)
+	}
+	if got := app.readBool(qs, "bad_bool", true, v); !got {
+		t.Errorf("readBool(bad_bool): want the default true; got %v", got)
+	}
+	if got := app.readFloat(qs, "rating", 0, v); got != 7.5 {
+		t.Errorf("readFloat(rating): want 7.5; got %v", got)
+	}
+	if got := app.readFloat(qs, "bad_float", 1, v); got != 1 {
+		t.Errorf("readFloat(bad_float): want the default 1; got %v", got)
+	}
+	if got := app.readDate(qs, "since", v); !got.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("readDate(since): want 2024-02-29; got %v", got)
+	}
+	if got := app.readDate(qs, "bad_date", v); !got.IsZero() {
+		t.Errorf("readDate(bad_date): want the zero time; got %v", got)
+	}
+	if got := app.readIntRange(qs, "limit", 50, 1, 100, v); got != 20 {
+		t.Errorf("readIntRange(limit): want 20; got %d", got)
+	}
+	app.readIntRange(qs, "too_big", 50, 1, 100, v)
+	app.readIntRange(qs, "bad_int", 50, 1, 100, v)
+
+	want := map[string]string{
+		"bad_bool":  "must be a boolean value",
+		"bad_float": "must be a number",
+		"bad_date":  "must be a date in the format YYYY-MM-DD",
+		"too_big":   "must be between 1 and 100",
+		"bad_int":   "must be an integer value",
+	}
+	if !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("want errors %v; got %v", want, v.Errors)
+	}
+}
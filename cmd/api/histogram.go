@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets request durations are counted
+// in. They are the default buckets of the Prometheus client libraries, so that the dashboards
+// built for other services work for this one too.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestDurations is the histogram of the durations of the requests, recorded by the metrics()
+// middleware. Like authOutcomes it is published at package level, as expvar panics if the same
+// name is published twice.
+var requestDurations = newLatencyHistogram(latencyBuckets)
+
+func init() {
+	expvar.Publish("request_duration_histogram", requestDurations)
+}
+
+// latencyHistogram counts durations in buckets, from which percentiles can be estimated. The
+// average of the cumulative total hides the slow tail of the requests, which the p95 and p99
+// show. It is safe for concurrent use and implements expvar.Var.
+type latencyHistogram struct {
+	bounds []float64
+	// counts holds the number of durations in each bucket, the last one for the durations
+	// above every bound. They aren't cumulative, unlike the Prometheus buckets.
+	counts    []atomic.Int64
+	count     atomic.Int64
+	sumMicros atomic.Int64
+}
+
+// newLatencyHistogram returns an empty histogram with buckets up to each of bounds, which must
+// be sorted, in seconds.
+func newLatencyHistogram(bounds []float64) *latencyHistogram {
+	return &latencyHistogram{
+		bounds: bounds,
+		counts: make([]atomic.Int64, len(bounds)+1),
+	}
+}
+
+// Observe counts the duration d.
+func (h *latencyHistogram) Observe(d time.Duration) {
+	i := sort.SearchFloat64s(h.bounds, d.Seconds())
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sumMicros.Add(d.Microseconds())
+}
+
+// Reset sets every count of the histogram back to zero. The durations observed while it runs
+// may be partly kept.
+func (h *latencyHistogram) Reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.count.Store(0)
+	h.sumMicros.Store(0)
+}
+
+// latencySnapshot is the state of a latencyHistogram at one point in time. cumulative[i] is the
+// number of durations up to bounds[i], and the last one is count.
+type latencySnapshot struct {
+	bounds     []float64
+	cumulative []int64
+	count      int64
+	sum        time.Duration
+}
+
+// snapshot returns the counts of the histogram. They are read one at a time, so a snapshot taken
+// while durations are being observed may be off by those, which is fine for monitoring.
+func (h *latencyHistogram) snapshot() latencySnapshot {
+	s := latencySnapshot{bounds: h.bounds, cumulative: make([]int64, len(h.counts))}
+
+	var total int64
+	for i := range h.counts {
+		total += h.counts[i].Load()
+		s.cumulative[i] = total
+	}
+	s.count = total
+	s.sum = time.Duration(h.sumMicros.Load()) * time.Microsecond
+	return s
+}
+
+// quantile estimates the q quantile, between 0 and 1, of the durations, interpolating linearly
+// within the bucket it falls in as Prometheus' histogram_quantile does. The durations above the
+// last bound are reported as the last bound. It returns 0 when nothing was observed.
+func (s latencySnapshot) quantile(q float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+
+	rank := q * float64(s.count)
+	i := sort.Search(len(s.cumulative), func(i int) bool { return float64(s.cumulative[i]) >= rank })
+	if i >= len(s.bounds) {
+		return seconds(s.bounds[len(s.bounds)-1])
+	}
+
+	lower, below := 0.0, int64(0)
+	if i > 0 {
+		lower, below = s.bounds[i-1], s.cumulative[i-1]
+	}
+	inBucket := s.cumulative[i] - below
+	if inBucket == 0 {
+		return seconds(lower)
+	}
+
+	return seconds(lower + (s.bounds[i]-lower)*(rank-float64(below))/float64(inBucket))
+}
+
+// seconds converts a number of seconds to a time.Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Round(s * float64(time.Second)))
+}
+
+// String returns the histogram as JSON for /debug/vars: the cumulative count of each bucket by
+// its bound in seconds, the count and sum, and the p50, p95 and p99 estimated from them in
+// milliseconds.
+func (h *latencyHistogram) String() string {
+	s := h.snapshot()
+
+	buckets := make(map[string]int64, len(s.cumulative))
+	for i, bound := range s.bounds {
+		buckets[formatBound(bound)] = s.cumulative[i]
+	}
+	buckets["+Inf"] = s.count
+
+	ms := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+
+	out, err := json.Marshal(map[string]interface{}{
+		"buckets":     buckets,
+		"count":       s.count,
+		"sum_seconds": s.sum.Seconds(),
+		"p50_ms":      ms(s.quantile(0.50)),
+		"p95_ms":      ms(s.quantile(0.95)),
+		"p99_ms":      ms(s.quantile(0.99)),
+	})
+	if err != nil {
+		return "{}"
+	}
+	return string(out)
+}
+
+// formatBound formats a bucket bound the way Prometheus labels them, e.g. 0.005 or 10.
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'g', -1, 64)
+}
+
+// writePrometheusHeader writes the HELP and TYPE lines of a metric in the Prometheus text
+// exposition format, which come once before its samples.
+func writePrometheusHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writePrometheusSample writes a sample of a metric with labels, as returned by
+// prometheusLabels, in the Prometheus text exposition format.
+func writePrometheusSample(w io.Writer, name, labels string, value int64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s%s %d\n", name, labels, value)
+}
+
+// prometheusLabels formats the label names and values of pairs, e.g. method="GET".
+func prometheusLabels(pairs ...string) string {
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf("%s=%q", pairs[i], pairs[i+1]))
+	}
+	return strings.Join(labels, ",")
+}
+
+// writePrometheusHistogram writes the series of a histogram with labels in the Prometheus text
+// exposition format: a bucket series per bound, and the sum and count series.
+func writePrometheusHistogram(w io.Writer, name, labels string, s latencySnapshot) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, bound := range s.bounds {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatBound(bound), s.cumulative[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, s.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(s.sum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, s.count)
+}
+
+// prometheusMetricsHandler handles "GET /debug/metrics" and returns the request metrics of the
+// metrics() middleware, overall and by route, along with the rate limit rejections and the
+// authentication failures, in the Prometheus text exposition format, for the scrapers which
+// don't read the expvar JSON of /debug/vars.
+func (app *application) prometheusMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if v, ok := expvar.Get("total_requests_received").(*expvar.Int); ok {
+		writePrometheusHeader(w, "greenlight_http_requests_total", "Requests received.", "counter")
+		writePrometheusSample(w, "greenlight_http_requests_total", "", v.Value())
+	}
+
+	if v, ok := expvar.Get("total_responses_sent_by_status").(*expvar.Map); ok {
+		writePrometheusCounterMap(w, "greenlight_http_responses_total", "Responses sent, by status code.", "code", v)
+	}
+
+	writePrometheusHeader(w, "greenlight_http_request_duration_seconds", "Time taken to handle the requests.", "histogram")
+	writePrometheusHistogram(w, "greenlight_http_request_duration_seconds", "", requestDurations.snapshot())
+
+	requestsByRoute.writePrometheus(w)
+
+	writePrometheusHeader(w, "greenlight_rate_limit_rejections_total", "Requests rejected with 429 Too Many Requests, by route.", "counter")
+	rateLimitRejections.Do(func(kv expvar.KeyValue) {
+		if count, ok := kv.Value.(*expvar.Int); ok {
+			method, route, _ := strings.Cut(kv.Key, " ")
+			writePrometheusSample(w, "greenlight_rate_limit_rejections_total", prometheusLabels("method", method, "route", route), count.Value())
+		}
+	})
+
+	writePrometheusCounterMap(w, "greenlight_authentication_outcomes_total", "Requests by authentication outcome.", "outcome", authOutcomes)
+	writePrometheusCounterMap(w, "greenlight_failed_logins_total", "Failed login attempts, by reason.", "reason", failedLogins)
+}
+
+// writePrometheusCounterMap writes the counters of the expvar map m as the samples of the
+// counter name, labelled with their key as label.
+func writePrometheusCounterMap(w io.Writer, name, help, label string, m *expvar.Map) {
+	writePrometheusHeader(w, name, help, "counter")
+	m.Do(func(kv expvar.KeyValue) {
+		if count, ok := kv.Value.(*expvar.Int); ok {
+			writePrometheusSample(w, name, prometheusLabels(label, kv.Key), count.Value())
+		}
+	})
+}
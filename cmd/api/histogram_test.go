@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogramQuantiles(t *testing.T) {
+	h := newLatencyHistogram([]float64{0.01, 0.1, 1})
+
+	// 90 fast requests, 9 slow ones and one slower than every bucket.
+	for i := 0; i < 90; i++ {
+		h.Observe(5 * time.Millisecond)
+	}
+	for i := 0; i < 9; i++ {
+		h.Observe(500 * time.Millisecond)
+	}
+	h.Observe(3 * time.Second)
+
+	s := h.snapshot()
+	if s.count != 100 {
+		t.Fatalf("got count %d; want 100", s.count)
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		// Half of the first bucket, which holds 90 of the durations, is at 50/90 of its width.
+		{0.50, 5555556 * time.Nanosecond},
+		{0.95, 600 * time.Millisecond},
+		{0.99, time.Second},
+		{1, time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := s.quantile(tt.q); got != tt.want {
+			t.Errorf("quantile(%v): got %v; want %v", tt.q, got, tt.want)
+		}
+	}
+
+	if got := newLatencyHistogram(latencyBuckets).snapshot().quantile(0.99); got != 0 {
+		t.Errorf("got %v for an empty histogram; want 0", got)
+	}
+}
+
+func TestLatencyHistogramString(t *testing.T) {
+	h := newLatencyHistogram([]float64{0.01, 0.1})
+	h.Observe(50 * time.Millisecond)
+
+	var got struct {
+		Buckets map[string]int64 `json:"buckets"`
+		Count   int64            `json:"count"`
+		P99     float64          `json:"p99_ms"`
+	}
+	if err := json.Unmarshal([]byte(h.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Count != 1 || got.Buckets["0.01"] != 0 || got.Buckets["0.1"] != 1 || got.Buckets["+Inf"] != 1 {
+		t.Errorf("got %+v", got)
+	}
+	if got.P99 != 99.1 {
+		t.Errorf("got p99 %vms; want 99.1ms", got.P99)
+	}
+}
+
+func TestWritePrometheusHistogram(t *testing.T) {
+	h := newLatencyHistogram([]float64{0.005, 0.5})
+	h.Observe(time.Millisecond)
+	h.Observe(250 * time.Millisecond)
+	h.Observe(2 * time.Second)
+
+	var b strings.Builder
+	writePrometheusHeader(&b, "http_request_duration_seconds", "Time taken.", "histogram")
+	writePrometheusHistogram(&b, "http_request_duration_seconds", "", h.snapshot())
+	writePrometheusHistogram(&b, "http_request_duration_seconds", prometheusLabels("route", "/v1/movies/:id"), h.snapshot())
+
+	want := `# HELP http_request_duration_seconds Time taken.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.005"} 1
+http_request_duration_seconds_bucket{le="0.5"} 2
+http_request_duration_seconds_bucket{le="+Inf"} 3
+http_request_duration_seconds_sum 2.251
+http_request_duration_seconds_count 3
+http_request_duration_seconds_bucket{route="/v1/movies/:id",le="0.005"} 1
+http_request_duration_seconds_bucket{route="/v1/movies/:id",le="0.5"} 2
+http_request_duration_seconds_bucket{route="/v1/movies/:id",le="+Inf"} 3
+http_request_duration_seconds_sum{route="/v1/movies/:id"} 2.251
+http_request_duration_seconds_count{route="/v1/movies/:id"} 3
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
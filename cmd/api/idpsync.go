@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/idp"
+)
+
+// idpSyncReport describes what a single identity provider sync changed.
+type idpSyncReport struct {
+	Source     string          `json:"source"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Users      int             `json:"users"` // number of users read from the directory
+	Changes    []idpSyncChange `json:"changes"`
+	Errors     []string        `json:"errors"`
+}
+
+// idpSyncChange is a change made to a single account.
+type idpSyncChange struct {
+	UserID  int64    `json:"user_id"`
+	Email   string   `json:"email"`
+	Action  string   `json:"action"` // created, linked, activated, deactivated or permissions
+	Granted []string `json:"granted,omitempty"`
+	Revoked []string `json:"revoked,omitempty"`
+}
+
+// idpSyncState holds the report of the latest sync. The zero value is ready to use.
+type idpSyncState struct {
+	mu   sync.Mutex
+	last *idpSyncReport
+}
+
+// startIdPSyncJob syncs the accounts with the identity provider straight away, and then every
+// interval for the lifetime of the application.
+func (app *application) startIdPSyncJob(source idp.Source, interval time.Duration) {
+	run := func() {
+		report, err := app.syncIdentityProvider(source)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "idp-sync"})
+			return
+		}
+
+		app.idpSync.mu.Lock()
+		app.idpSync.last = report
+		app.idpSync.mu.Unlock()
+
+		app.logger.PrintInfo("identity provider sync finished", map[string]string{
+			"source":  report.Source,
+			"users":   strconv.Itoa(report.Users),
+			"changes": strconv.Itoa(len(report.Changes)),
+			"errors":  strconv.Itoa(len(report.Errors)),
+		})
+	}
+
+	go func() {
+		app.background(run)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			app.background(run)
+		}
+	}()
+}
+
+// syncIdentityProvider reconciles the local accounts with the users of the identity provider:
+//
+//   - Active directory users without an account get one. Users whose email address matches an
+//     existing account are linked to it instead.
+//   - Accounts of users who are inactive in, or have been removed from, the directory are
+//     deactivated and logged out. Accounts of users who are active again are reactivated.
+//   - Permissions mapped from the user's groups are granted, and mapped permissions the user no
+//     longer gets from any group are revoked. Permissions which aren't mapped from any group are
+//     left alone, so they can still be managed by hand.
+//
+// Failures for a single account are recorded in the report and don't stop the sync.
+func (app *application) syncIdentityProvider(source idp.Source) (*idpSyncReport, error) {
+	report := &idpSyncReport{Source: source.Name(), StartedAt: time.Now(), Changes: []idpSyncChange{}, Errors: []string{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	users, err := source.Users(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Users = len(users)
+
+	identities, err := app.models.Identities.GetAllForProvider(source.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty directory is far more likely to be a misconfiguration than a company without
+	// any employees, so refuse to deactivate every linked account because of it.
+	if len(users) == 0 && len(identities) > 0 {
+		return nil, errors.New("identity provider returned no users, refusing to deactivate every linked account")
+	}
+
+	seen := make(map[string]bool, len(users))
+
+	for _, u := range users {
+		seen[u.ExternalID] = true
+
+		err := app.syncIdPUser(source.Name(), u, identities, report)
+		if err != nil {
+			report.Errors = append(report.Errors, u.Email+": "+err.Error())
+		}
+	}
+
+	for externalID, userID := range identities {
+		if seen[externalID] {
+			continue
+		}
+
+		user, err := app.models.Users.Get(userID)
+		if err != nil {
+			report.Errors = append(report.Errors, "user "+strconv.FormatInt(userID, 10)+": "+err.Error())
+			continue
+		}
+
+		err = app.reconcileIdPAccount(user, false, nil, report)
+		if err != nil {
+			report.Errors = append(report.Errors, user.Email+": "+err.Error())
+		}
+	}
+
+	report.FinishedAt = time.Now()
+
+	return report, nil
+}
+
+// syncIdPUser finds, links or creates the account of a single directory user and reconciles it.
+func (app *application) syncIdPUser(provider string, u idp.User, identities map[string]int64, report *idpSyncReport) error {
+	var user *data.User
+
+	if userID, ok := identities[u.ExternalID]; ok {
+		var err error
+		user, err = app.models.Users.Get(userID)
+		if err != nil {
+			return err
+		}
+	} else {
+		if u.Email == "" {
+			return errors.New("directory user has no email address")
+		}
+
+		existing, err := app.models.Users.GetByEmail(u.Email)
+		switch {
+		case err == nil:
+			user = existing
+
+			// Anyone can register an account with someone else's address, so an account that
+			// was never activated isn't trusted to belong to the directory user: its password
+			// and tokens are replaced before the directory can activate it.
+			if !user.Activated {
+				err = app.takeOverIdPAccount(user)
+				if err != nil {
+					return err
+				}
+			}
+			report.Changes = append(report.Changes, idpSyncChange{UserID: user.ID, Email: user.Email, Action: "linked"})
+		case errors.Is(err, data.ErrRecordNotFound):
+			// Don't create accounts for users who couldn't use them anyway.
+			if !u.Active {
+				return nil
+			}
+
+			user, err = app.createIdPAccount(u)
+			if err != nil {
+				return err
+			}
+			report.Changes = append(report.Changes, idpSyncChange{UserID: user.ID, Email: user.Email, Action: "created"})
+		default:
+			return err
+		}
+
+		err = app.models.Identities.Link(user.ID, provider, u.ExternalID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.reconcileIdPAccount(user, u.Active, u.Groups, report)
+}
+
+// createIdPAccount creates an activated account for a directory user. The account gets a random
+// password, so the user has to request a password reset before they can log in.
+func (app *application) createIdPAccount(u idp.User) (*data.User, error) {
+	user := &data.User{
+		Name:      u.Name,
+		Email:     u.Email,
+		Activated: true,
+	}
+
+	err := setRandomPassword(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// takeOverIdPAccount makes an unactivated account with the address of a directory user safe to
+// link to it: the account gets a random password and all of its tokens are deleted, so that
+// whoever registered it can't log in or activate it, and the directory user has to request a
+// password reset, like for a new account.
+func (app *application) takeOverIdPAccount(user *data.User) error {
+	err := setRandomPassword(user)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Tokens.DeleteAllScopesForUser(user.ID)
+}
+
+// setRandomPassword sets the password of user to a random one that nobody knows.
+func setRandomPassword(user *data.User) error {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	return user.Password.Set(hex.EncodeToString(randomBytes))
+}
+
+// reconcileIdPAccount activates or deactivates an account and adjusts its group-mapped
+// permissions to match the directory.
+func (app *application) reconcileIdPAccount(user *data.User, active bool, groups []string, report *idpSyncReport) error {
+	if user.Activated != active {
+		user.Activated = active
+
+		err := app.models.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		action := "activated"
+		if !active {
+			action = "deactivated"
+
+			err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+		report.Changes = append(report.Changes, idpSyncChange{UserID: user.ID, Email: user.Email, Action: action})
+	}
+
+	current, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	grant, revoke := diffIdPPermissions(app.config.idp.groupPermissions, groups, active, current)
+
+	if len(grant) > 0 {
+		err = app.models.Permissions.AddForUser(user.ID, grant...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(revoke) > 0 {
+		err = app.models.Permissions.RemoveForUser(user.ID, revoke...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(grant) > 0 || len(revoke) > 0 {
+		report.Changes = append(report.Changes, idpSyncChange{
+			UserID: user.ID, Email: user.Email, Action: "permissions", Granted: grant, Revoked: revoke,
+		})
+	}
+
+	return nil
+}
+
+// diffIdPPermissions returns the permissions to grant and to revoke so that the mapped
+// permissions of a user match the groups they are a member of. Inactive users keep none of the
+// mapped permissions. Permissions which aren't mapped from any group are never revoked.
+func diffIdPPermissions(mapping map[string][]string, groups []string, active bool, current data.Permissions) (grant, revoke []string) {
+	managed := make(map[string]bool)
+	for _, codes := range mapping {
+		for _, code := range codes {
+			managed[code] = true
+		}
+	}
+
+	desired := make(map[string]bool)
+	if active {
+		for _, group := range groups {
+			for _, code := range mapping[group] {
+				desired[code] = true
+			}
+		}
+	}
+
+	for code := range desired {
+		if !current.Contains(code) {
+			grant = append(grant, code)
+		}
+	}
+
+	for _, code := range current {
+		if managed[code] && !desired[code] {
+			revoke = append(revoke, code)
+		}
+	}
+
+	sort.Strings(grant)
+	sort.Strings(revoke)
+
+	return grant, revoke
+}
+
+// showIdPSyncReportHandler handles "GET /v1/admin/idp-sync" and returns the report of the
+// latest identity provider sync.
+func (app *application) showIdPSyncReportHandler(w http.ResponseWriter, r *http.Request) {
+	app.idpSync.mu.Lock()
+	report := app.idpSync.last
+	app.idpSync.mu.Unlock()
+
+	if report == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
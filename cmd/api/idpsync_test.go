@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestDiffIdPPermissions(t *testing.T) {
+	mapping := map[string][]string{
+		"editors": {"movies:read", "movies:write"},
+		"viewers": {"movies:read"},
+		"ops":     {"admin:read"},
+	}
+
+	tests := []struct {
+		name    string
+		groups  []string
+		active  bool
+		current data.Permissions
+		grant   []string
+		revoke  []string
+	}{
+		{"new editor", []string{"editors"}, true, nil, []string{"movies:read", "movies:write"}, nil},
+		{"demoted to viewer", []string{"viewers"}, true, data.Permissions{"movies:read", "movies:write"}, nil, []string{"movies:write"}},
+		{"unmapped permission kept", []string{"viewers"}, true, data.Permissions{"movies:read", "comments:moderate"}, nil, nil},
+		{"unknown group ignored", []string{"marketing"}, true, nil, nil, nil},
+		{"inactive loses mapped permissions", []string{"editors", "ops"}, false, data.Permissions{"movies:read", "admin:read", "comments:moderate"}, nil, []string{"admin:read", "movies:read"}},
+	}
+
+	for _, tt := range tests {
+		grant, revoke := diffIdPPermissions(mapping, tt.groups, tt.active, tt.current)
+		if !reflect.DeepEqual(grant, tt.grant) || !reflect.DeepEqual(revoke, tt.revoke) {
+			t.Errorf("%s: got grant %v, revoke %v; want grant %v, revoke %v", tt.name, grant, revoke, tt.grant, tt.revoke)
+		}
+	}
+}
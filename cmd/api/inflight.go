@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tomasen/realip"
+)
+
+// inflightRequest describes a request that is currently being handled.
+type inflightRequest struct {
+	ID       uint64    `json:"id"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	UserID   int64     `json:"user_id,omitempty"` // zero for anonymous and trial users
+	ClientIP string    `json:"client_ip"`
+	Started  time.Time `json:"started_at"`
+	Elapsed  string    `json:"elapsed"`
+}
+
+// inflightRegistry keeps track of the requests currently being handled. The zero value is
+// ready to use.
+type inflightRegistry struct {
+	mu       sync.Mutex
+	nextID   uint64
+	requests map[uint64]*inflightRequest
+}
+
+// add registers a request and returns a function which removes it again.
+func (reg *inflightRegistry) add(req *inflightRequest) func() {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.requests == nil {
+		reg.requests = make(map[uint64]*inflightRequest)
+	}
+
+	reg.nextID++
+	req.ID = reg.nextID
+	reg.requests[req.ID] = req
+
+	return func() {
+		reg.mu.Lock()
+		defer reg.mu.Unlock()
+		delete(reg.requests, req.ID)
+	}
+}
+
+// snapshot returns a copy of the registered requests, longest running first.
+func (reg *inflightRegistry) snapshot(now time.Time) []inflightRequest {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	requests := make([]inflightRequest, 0, len(reg.requests))
+	for _, req := range reg.requests {
+		r := *req
+		r.Elapsed = now.Sub(r.Started).Round(time.Millisecond).String()
+		requests = append(requests, r)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Started.Before(requests[j].Started)
+	})
+
+	return requests
+}
+
+// trackInFlight registers every request in the in-flight registry for as long as it is being
+// handled. It must run after authenticate(), because it records the user making the request.
+func (app *application) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &inflightRequest{
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			ClientIP: realip.FromRequest(r),
+			Started:  time.Now(),
+		}
+
+		if user := app.contextGetUser(r); !user.IsAnonymous() && !user.IsTrial() {
+			req.UserID = user.ID
+		}
+
+		remove := app.inflight.add(req)
+		defer remove()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listInFlightRequestsHandler handles "GET /v1/admin/inflight" and returns the requests the
+// server is currently handling, longest running first. The request for this endpoint itself
+// is included, which also shows that the server is still able to answer.
+func (app *application) listInFlightRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	requests := app.inflight.snapshot(time.Now())
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"requests": requests, "count": len(requests)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// count returns the number of requests being handled.
+func (reg *inflightRegistry) count() int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	return len(reg.requests)
+}
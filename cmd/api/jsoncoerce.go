@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// coerceJSONTypes rewrites a request body so that the values sent with the wrong JSON type for
+// the fields of t, which readJSON decodes into, still decode when they convert without loss:
+// strings holding numbers or booleans for number and boolean fields, and numbers and booleans
+// for string fields. It is used when strict types are disabled with -json-coerce-types, as
+// many integrations send every value as a string. The body is returned unchanged when there is
+// nothing to convert, or when it isn't a single JSON value, leaving the errors to the decoder.
+func coerceJSONTypes(body []byte, t reflect.Type) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return body
+	}
+
+	coerced, changed := coerceJSONValue(v, t)
+	if !changed {
+		return body
+	}
+
+	out, err := json.Marshal(coerced)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// coerceJSONValue converts v, a value decoded with UseNumber, for a destination of type t, and
+// reports whether anything was converted. The types with their own decoding, such as
+// data.Runtime, are left alone.
+func coerceJSONValue(v interface{}, t reflect.Type) (interface{}, bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(jsonUnmarshalerType) ||
+		t.Implements(textUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return v, false
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if s, ok := v.(string); ok {
+			s = strings.TrimSpace(s)
+			if _, err := strconv.ParseFloat(s, 64); err == nil {
+				return json.Number(s), true
+			}
+		}
+
+	case reflect.Bool:
+		if s, ok := v.(string); ok {
+			if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+				return b, true
+			}
+		}
+
+	case reflect.String:
+		switch x := v.(type) {
+		case json.Number:
+			return x.String(), true
+		case bool:
+			return strconv.FormatBool(x), true
+		}
+
+	case reflect.Slice, reflect.Array:
+		// []byte is decoded from a base64 string, which there is nothing to convert in.
+		values, ok := v.([]interface{})
+		if !ok || t.Elem().Kind() == reflect.Uint8 {
+			return v, false
+		}
+		changed := false
+		for i, elem := range values {
+			var c bool
+			values[i], c = coerceJSONValue(elem, t.Elem())
+			changed = changed || c
+		}
+		return values, changed
+
+	case reflect.Map:
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			return v, false
+		}
+		changed := false
+		for k, elem := range object {
+			var c bool
+			object[k], c = coerceJSONValue(elem, t.Elem())
+			changed = changed || c
+		}
+		return object, changed
+
+	case reflect.Struct:
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			return v, false
+		}
+		fields := jsonFields(t)
+		changed := false
+		for k, elem := range object {
+			ft, ok := fields[k]
+			if !ok {
+				// encoding/json matches the keys to the field names in any case too.
+				for name, typ := range fields {
+					if strings.EqualFold(name, k) {
+						ft, ok = typ, true
+						break
+					}
+				}
+			}
+			if !ok {
+				continue
+			}
+			var c bool
+			object[k], c = coerceJSONValue(elem, ft)
+			changed = changed || c
+		}
+		return object, changed
+	}
+
+	return v, false
+}
+
+// jsonFields returns the types of the fields of the struct type t by their JSON names, including
+// the fields of its embedded structs. The fields decoded with the ",string" option are left out,
+// as they already expect strings.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for k, v := range jsonFields(ft) {
+				if _, ok := fields[k]; !ok {
+					fields[k] = v
+				}
+			}
+			continue
+		}
+		if !f.IsExported() || strings.Contains(","+opts+",", ",string,") {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestCoerceJSONTypes(t *testing.T) {
+	type embedded struct {
+		Page int `json:"page"`
+	}
+	var dst struct {
+		embedded
+		Title   string       `json:"title"`
+		Year    *int32       `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Public  bool         `json:"public"`
+		IDs     []int64      `json:"ids"`
+		Scores  map[string]float64
+		Count   int         `json:"count,string"`
+		Any     interface{} `json:"any"`
+	}
+
+	tests := []struct {
+		body, want string
+	}{
+		{`{"title": 1984, "year": " 1984", "public": "true", "ids": ["1", 2], "page": "3", "Scores": {"imdb": "8.1"}}`,
+			`{"Scores":{"imdb":8.1},"ids":[1,2],"page":3,"public":true,"title":"1984","year":1984}`},
+		{`{"TITLE": true}`, `{"TITLE":"true"}`},
+		// Nothing to convert, values which don't convert, and types with their own decoding.
+		{`{"title": "Moana", "year": 2016}`, `{"title": "Moana", "year": 2016}`},
+		{`{"year": "soon", "public": "yes"}`, `{"year": "soon", "public": "yes"}`},
+		{`{"runtime": "107 mins", "count": "3", "any": "1"}`, `{"runtime": "107 mins", "count": "3", "any": "1"}`},
+		{`{"year": "2016"} {"year": "2017"}`, `{"year": "2016"} {"year": "2017"}`},
+		{`{"year": "2016"`, `{"year": "2016"`},
+	}
+
+	for _, tt := range tests {
+		if got := string(coerceJSONTypes([]byte(tt.body), reflect.TypeOf(&dst))); got != tt.want {
+			t.Errorf("coerceJSONTypes(%s) = %s; want %s", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestReadJSONLeniency(t *testing.T) {
+	app := newTestApp()
+
+	read := func(limits jsonLimits, body string) error {
+		var input struct {
+			Title string `json:"title"`
+			Year  int32  `json:"year"`
+		}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		return app.readJSONWithLimits(httptest.NewRecorder(), r, &input, limits)
+	}
+
+	body := `{"source": "crm", "title": "Moana", "year": "2016"}`
+	if err := read(jsonLimits{}, body); err == nil || !strings.Contains(err.Error(), `unknown key "source"`) {
+		t.Errorf("got %v; want the unknown key rejected", err)
+	}
+	if err := read(jsonLimits{}.withIgnoredUnknownFields(), body); err == nil || !strings.Contains(err.Error(), `incorrect JSON type for field "year"`) {
+		t.Errorf("got %v; want the string year rejected", err)
+	}
+	if err := read(jsonLimits{ignoreUnknownFields: true, coerceTypes: true}, body); err != nil {
+		t.Errorf("got %v; want the body accepted", err)
+	}
+}
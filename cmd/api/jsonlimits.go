@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// jsonLimits caps the shape of JSON request bodies. The 1MB size limit alone still lets a
+// client send hundreds of thousands of array elements or object keys, each of which the
+// decoder turns into an allocation, so readJSON checks the body against these limits before
+// decoding it. A limit of 0 means no limit.
+//
+// They also set how forgiving readJSON is: ignoreUnknownFields drops the keys which don't
+// match a field of the destination rather than rejecting the body, and coerceTypes turns off
+// strict types, converting the values sent with the wrong JSON type, see coerceJSONTypes. The
+// zero value is strict on both counts.
+type jsonLimits struct {
+	maxArrayLength      int
+	maxObjectKeys       int
+	ignoreUnknownFields bool
+	coerceTypes         bool
+}
+
+// withMaxArrayLength returns a copy of the limits with maxArrayLength lowered to n, for
+// endpoints which accept fewer items than the default, such as the batch endpoints.
+func (l jsonLimits) withMaxArrayLength(n int) jsonLimits {
+	if l.maxArrayLength == 0 || n < l.maxArrayLength {
+		l.maxArrayLength = n
+	}
+	return l
+}
+
+// withIgnoredUnknownFields returns a copy of the limits which ignores the unknown fields, for
+// endpoints called by third-party integrations which send more than we read.
+func (l jsonLimits) withIgnoredUnknownFields() jsonLimits {
+	l.ignoreUnknownFields = true
+	return l
+}
+
+// unknownFieldsValue is the flag.Value of -json-unknown-fields, which reads "reject" or
+// "ignore" into ignoreUnknownFields.
+type unknownFieldsValue struct {
+	ignore *bool
+}
+
+func (v unknownFieldsValue) String() string {
+	if v.ignore != nil && *v.ignore {
+		return "ignore"
+	}
+	return "reject"
+}
+
+func (v unknownFieldsValue) Set(s string) error {
+	switch s {
+	case "reject":
+		*v.ignore = false
+	case "ignore":
+		*v.ignore = true
+	default:
+		return errors.New(`must be "reject" or "ignore"`)
+	}
+	return nil
+}
+
+// bodyTooLargeError is returned by readJSON for a body larger than its size limit. It is sent
+// as a 413 Request Entity Too Large response by badRequestResponse.
+type bodyTooLargeError struct {
+	maxBytes int
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return fmt.Sprintf("body must not be larger than %d bytes", e.maxBytes)
+}
+
+// jsonLimitError is returned by readJSON for a body which exceeds the jsonLimits. path locates
+// the offending array or object, such as "movies[3].genres", and is empty for the top-level
+// value. It is sent as a 422 Unprocessable Entity response by badRequestResponse, in the same
+// format as a validation error.
+type jsonLimitError struct {
+	path    string
+	message string
+}
+
+func (e *jsonLimitError) Error() string {
+	return fmt.Sprintf("body %s %s", e.field(), e.message)
+}
+
+// field returns the key of the error in a validation error response.
+func (e *jsonLimitError) field() string {
+	if e.path == "" {
+		return "body"
+	}
+	return e.path
+}
+
+// checkJSONLimits scans the first JSON value in body token by token, without building it in
+// memory, and returns a *jsonLimitError for the first array or object which exceeds the limits.
+// Syntax errors are ignored: the decoder that runs afterwards reports them with their position.
+func checkJSONLimits(body []byte, limits jsonLimits) error {
+	if limits.maxArrayLength == 0 && limits.maxObjectKeys == 0 {
+		return nil
+	}
+
+	// container is an array or object which is being scanned. For objects, key is the last
+	// key read and expectKey tells whether the next token is a key or a value.
+	type container struct {
+		array     bool
+		path      string
+		count     int
+		key       string
+		expectKey bool
+	}
+
+	var stack []*container
+
+	// valueDone is called at the end of every value. It returns true when the top-level value
+	// is complete.
+	valueDone := func() bool {
+		if len(stack) == 0 {
+			return true
+		}
+		if top := stack[len(stack)-1]; !top.array {
+			top.expectKey = true
+		}
+		return false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+
+		delim, isDelim := tok.(json.Delim)
+
+		if isDelim && (delim == ']' || delim == '}') {
+			stack = stack[:len(stack)-1]
+			if valueDone() {
+				return nil
+			}
+			continue
+		}
+
+		var parent *container
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+
+		switch {
+		case parent == nil:
+		case parent.array:
+			parent.count++
+			if limits.maxArrayLength > 0 && parent.count > limits.maxArrayLength {
+				return &jsonLimitError{path: parent.path,
+					message: fmt.Sprintf("must not contain more than %d items", limits.maxArrayLength)}
+			}
+		case parent.expectKey:
+			parent.count++
+			if limits.maxObjectKeys > 0 && parent.count > limits.maxObjectKeys {
+				return &jsonLimitError{path: parent.path,
+					message: fmt.Sprintf("must not contain more than %d keys", limits.maxObjectKeys)}
+			}
+			parent.key, _ = tok.(string)
+			parent.expectKey = false
+			continue
+		}
+
+		if isDelim {
+			// Paths are only built for nested arrays and objects, as they are the only values
+			// errors are reported for.
+			path := ""
+			switch {
+			case parent == nil:
+			case parent.array:
+				path = fmt.Sprintf("%s[%d]", parent.path, parent.count-1)
+			case parent.path == "":
+				path = parent.key
+			default:
+				path = parent.path + "." + parent.key
+			}
+
+			stack = append(stack, &container{array: delim == '[', path: path, expectKey: delim == '{'})
+			continue
+		}
+
+		if valueDone() {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckJSONLimits(t *testing.T) {
+	limits := jsonLimits{maxArrayLength: 3, maxObjectKeys: 2}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantPath string
+	}{
+		{"within limits", `{"title": "Moana", "genres": ["a", "b", "c"]}`, ""},
+		{"empty containers", `{"a": [], "b": {}}`, ""},
+		{"long top-level array", `[1, 2, 3, 4]`, "body"},
+		{"long nested array", `{"genres": ["a", "b", "c", "d"]}`, "genres"},
+		{"too many keys", `{"a": 1, "b": 2, "c": 3}`, "body"},
+		{"deeply nested", `{"movies": [{"id": 1}, {"id": 2, "genres": [[1, 2, 3, 4]]}]}`, "movies[1].genres[0]"},
+		{"nested object keys", `{"a": {"b": {"x": 1, "y": 2, "z": 3}}}`, "a.b"},
+		{"strings look like keys", `{"a": "b", "c": ["d", "e", "f"]}`, ""},
+		{"syntax error", `{"genres": ["a", "b"`, ""},
+		{"trailing values", `[1] [1, 2, 3, 4]`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkJSONLimits([]byte(tt.body), limits)
+
+			if tt.wantPath == "" {
+				if err != nil {
+					t.Errorf("want no error; got %v", err)
+				}
+				return
+			}
+
+			var limitErr *jsonLimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("want *jsonLimitError; got %v", err)
+			}
+			if limitErr.field() != tt.wantPath {
+				t.Errorf("want path %q; got %q", tt.wantPath, limitErr.field())
+			}
+		})
+	}
+}
+
+func TestCheckJSONLimitsLargeArray(t *testing.T) {
+	body := `{"genres": [` + strings.Repeat(`"x",`, 1_000_000) + `"x"]}`
+
+	err := checkJSONLimits([]byte(body), jsonLimits{maxArrayLength: 1000})
+
+	var limitErr *jsonLimitError
+	if !errors.As(err, &limitErr) || limitErr.path != "genres" {
+		t.Fatalf("want a limit error for genres; got %v", err)
+	}
+}
+
+func TestJSONLimitsWithMaxArrayLength(t *testing.T) {
+	tests := []struct {
+		limits jsonLimits
+		n      int
+		want   int
+	}{
+		{jsonLimits{maxArrayLength: 1000}, 500, 500},
+		{jsonLimits{maxArrayLength: 100}, 500, 100},
+		{jsonLimits{}, 500, 500},
+	}
+
+	for _, tt := range tests {
+		if got := tt.limits.withMaxArrayLength(tt.n).maxArrayLength; got != tt.want {
+			t.Errorf("%+v.withMaxArrayLength(%d): want %d; got %d", tt.limits, tt.n, tt.want, got)
+		}
+	}
+}
+
+func TestUnknownFieldsValue(t *testing.T) {
+	var ignore bool
+	v := unknownFieldsValue{&ignore}
+
+	if err := v.Set("ignore"); err != nil || !ignore || v.String() != "ignore" {
+		t.Errorf("got %v, %t; want unknown fields ignored", err, ignore)
+	}
+	if err := v.Set("reject"); err != nil || ignore || v.String() != "reject" {
+		t.Errorf("got %v, %t; want unknown fields rejected", err, ignore)
+	}
+	if err := v.Set("drop"); err == nil {
+		t.Error("want an error for an unknown handling")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// link is a single entry of the "_links" block added to resource envelopes, telling clients
+// where a related action or resource can be found without hard-coding its path.
+type link struct {
+	Href   string `json:"href"`
+	Method string `json:"method"`
+}
+
+// movieRels and userRels map the relation names used in "_links" to the routes they point at.
+// The paths are the patterns registered in routes.go.
+var (
+	movieRels = map[string]apiRoute{
+		"self":       {http.MethodGet, "/v1/movies/:id"},
+		"update":     {http.MethodPatch, "/v1/movies/:id"},
+		"delete":     {http.MethodDelete, "/v1/movies/:id"},
+		"comments":   {http.MethodGet, "/v1/movies/:id/comments"},
+		"note":       {http.MethodGet, "/v1/movies/:id/note"},
+		"collection": {http.MethodGet, "/v1/movies"},
+	}
+
+	userRels = map[string]apiRoute{
+		"lists":        {http.MethodGet, "/v1/users/:id/lists"},
+		"activate":     {http.MethodPut, "/v1/users/activated"},
+		"authenticate": {http.MethodPost, "/v1/tokens/authentication"},
+	}
+)
+
+// movieLinks returns the "_links" block for a movie.
+func (app *application) movieLinks(id int64) map[string]link {
+	return app.buildLinks(movieRels, map[string]string{"id": strconv.FormatInt(id, 10)})
+}
+
+// userLinks returns the "_links" block for a user. The "activate" link is left out once the
+// account has been activated.
+func (app *application) userLinks(id int64, activated bool) map[string]link {
+	links := app.buildLinks(userRels, map[string]string{"id": strconv.FormatInt(id, 10)})
+	if activated {
+		delete(links, "activate")
+	}
+	return links
+}
+
+// buildLinks expands the path of each relation with params. Relations pointing at routes which
+// aren't registered with the router are left out, so a link never leads to a 404.
+func (app *application) buildLinks(rels map[string]apiRoute, params map[string]string) map[string]link {
+	registered := make(map[apiRoute]bool, len(app.apiRoutes))
+	for _, route := range app.apiRoutes {
+		registered[route] = true
+	}
+
+	links := make(map[string]link, len(rels))
+	for rel, route := range rels {
+		if !registered[route] {
+			continue
+		}
+		links[rel] = link{Href: expandPath(route.path, params), Method: route.method}
+	}
+
+	return links
+}
+
+// expandPath replaces each ":name" parameter in a route pattern with the escaped value of
+// params[name], e.g. "/v1/movies/:id" becomes "/v1/movies/1".
+func expandPath(pattern string, params map[string]string) string {
+	return pathParamRX.ReplaceAllStringFunc(pattern, func(param string) string {
+		return url.PathEscape(params[param[1:]])
+	})
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestExpandPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		params  map[string]string
+		want    string
+	}{
+		{"/v1/movies", nil, "/v1/movies"},
+		{"/v1/movies/:id", map[string]string{"id": "7"}, "/v1/movies/7"},
+		{"/v1/movies/:id/comments/:comment_id", map[string]string{"id": "7", "comment_id": "12"}, "/v1/movies/7/comments/12"},
+		{"/v1/lists/:slug", map[string]string{"slug": "a b/c"}, "/v1/lists/a%20b%2Fc"},
+	}
+
+	for _, tt := range tests {
+		if got := expandPath(tt.pattern, tt.params); got != tt.want {
+			t.Errorf("expandPath(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
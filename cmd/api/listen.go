@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/saalikmubeen/greenlight/internal/systemd"
+)
+
+// unixPrefix marks a -listen value as the path of a unix socket.
+const unixPrefix = "unix:"
+
+// listenAddress returns the network and address the server listens on: the unix socket of a
+// "unix:/path/to.sock" -listen value, the TCP address of any other -listen value, or every
+// interface at port when listen is empty.
+func listenAddress(listen string, port int) (network, address string) {
+	switch {
+	case strings.HasPrefix(listen, unixPrefix):
+		return "unix", strings.TrimPrefix(listen, unixPrefix)
+	case listen != "":
+		return "tcp", listen
+	default:
+		return "tcp", fmt.Sprintf(":%d", port)
+	}
+}
+
+// parseSocketMode parses the octal permissions of -listen-socket-mode, such as "0660".
+func parseSocketMode(s string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid socket mode %q", s)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// listen opens the listener of the server. For a unix socket, a socket file left behind by a
+// server which didn't shut down cleanly is removed first, and the permissions of the new one
+// are set to -listen-socket-mode, so that only the reverse proxy's group can connect. The
+// socket file is removed again when the listener is closed, which Shutdown does.
+//
+// When systemd passes a socket through socket activation, that socket is used instead and
+// -listen and -port are ignored.
+func (app *application) listen() (net.Listener, error) {
+	ln, err := systemd.Listener()
+	if err != nil || ln != nil {
+		return ln, err
+	}
+
+	network, address := listenAddress(app.config.listen.address, app.config.port)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	mode, err := parseSocketMode(app.config.listen.socketMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only remove a stale socket, never a regular file which happens to have the same path.
+	if info, err := os.Lstat(address); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", address)
+		}
+		if err := os.Remove(address); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err = net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(address, mode)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenAddress(t *testing.T) {
+	tests := []struct {
+		listen  string
+		network string
+		address string
+	}{
+		{"", "tcp", ":4000"},
+		{"127.0.0.1:8080", "tcp", "127.0.0.1:8080"},
+		{"unix:/run/greenlight.sock", "unix", "/run/greenlight.sock"},
+	}
+
+	for _, tt := range tests {
+		network, address := listenAddress(tt.listen, 4000)
+		if network != tt.network || address != tt.address {
+			t.Errorf("listenAddress(%q) = %q, %q; want %q, %q", tt.listen, network, address, tt.network, tt.address)
+		}
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "greenlight.sock")
+
+	// A socket left behind by a previous run is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	app := newTestApp()
+	app.config.listen.address = "unix:" + path
+	app.config.listen.socketMode = "0600"
+
+	ln, err := app.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("got socket mode %v; want 0600", info.Mode().Perm())
+	}
+
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("got %v; want the socket removed on close", err)
+	}
+
+	// A regular file is never removed.
+	err = os.WriteFile(path, nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.listen(); err == nil {
+		t.Error("got no error listening over a regular file")
+	}
+	if _, err := parseSocketMode("0999"); err == nil {
+		t.Error("got no error for an invalid socket mode")
+	}
+	if mode, _ := parseSocketMode("0660"); mode != fs.FileMode(0o660) {
+		t.Errorf("got %v; want 0660", mode)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+const (
+	// movieEventsChannel is the channel the movies table trigger notifies, see migration
+	// 000020_create_movie_events_notify_trigger.
+	movieEventsChannel = "movie_events"
+	// listenerPingInterval is how often an idle listener connection is checked.
+	listenerPingInterval = 90 * time.Second
+)
+
+// movieNotification is the payload of a notification on movieEventsChannel.
+type movieNotification struct {
+	Op string `json:"op"`
+	ID int64  `json:"id"`
+}
+
+// startMovieEventListener listens for the notifications sent by the database whenever a movie
+// is inserted, updated or deleted, and publishes a movie event on the event bus for each of
+// them. As the notifications are sent on commit by the database itself, every instance of the
+// API publishes every change exactly once, whichever instance or tool made it, and changes
+// which are rolled back are never published.
+//
+// The listener uses its own connection, outside of the pool, and reconnects on its own. Events
+// may be lost while it is disconnected, so a "reset" event is published after it reconnects.
+func (app *application) startMovieEventListener(dsn string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintWarn("movie event listener disconnected", map[string]string{"listener": movieEventsChannel, "error": err.Error()})
+		}
+	})
+
+	err := listener.Listen(movieEventsChannel)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case n := <-listener.Notify:
+				// A nil notification means the connection was re-established.
+				if n == nil {
+					app.logger.PrintInfo("movie event listener reconnected", nil)
+					app.events.Publish(eventReset, json.RawMessage("{}"))
+					continue
+				}
+
+				app.handleMovieNotification(n.Extra)
+			case <-time.After(listenerPingInterval):
+				go func() {
+					if err := listener.Ping(); err != nil {
+						app.logger.PrintError(err, map[string]string{"listener": movieEventsChannel})
+					}
+				}()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// handleMovieNotification publishes the movie event for a notification payload, logging the
+// errors. A panic is recovered, so that the listener keeps going with the next notification.
+func (app *application) handleMovieNotification(payload string) {
+	defer app.recoverBackgroundPanic(map[string]string{"listener": movieEventsChannel, "payload": payload})
+
+	err := app.publishMovieNotification(payload)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"listener": movieEventsChannel, "payload": payload})
+	}
+}
+
+// publishMovieNotification publishes the movie event for a notification payload. Created and
+// updated movies are read back from the database, so that the event carries the movie in the
+// same format as the API responses; if the movie has been deleted in the meantime nothing is
+// published, as its delete notification follows.
+func (app *application) publishMovieNotification(payload string) error {
+	var n movieNotification
+
+	err := json.Unmarshal([]byte(payload), &n)
+	if err != nil {
+		return err
+	}
+
+	var (
+		eventType string
+		movie     interface{}
+	)
+
+	switch n.Op {
+	case "insert", "update":
+		eventType = data.EventMovieCreated
+		if n.Op == "update" {
+			eventType = data.EventMovieUpdated
+		}
+
+		// Reading the movie from the primary also brings the movie cache up to date with the
+		// change, whichever instance made it.
+		m, err := app.models.Movies.Primary().Get(n.ID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		movie = m
+	case "delete":
+		eventType = data.EventMovieDeleted
+		movie = envelope{"id": n.ID}
+	default:
+		return fmt.Errorf("unknown operation %q", n.Op)
+	}
+
+	js, err := json.Marshal(envelope{"movie": movie})
+	if err != nil {
+		return err
+	}
+
+	app.events.Publish(eventType, js)
+	return nil
+}
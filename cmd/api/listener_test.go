@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestPublishMovieNotification(t *testing.T) {
+	app := newTestApp()
+
+	sub, _, _ := app.events.Subscribe(0)
+	defer sub.Cancel()
+
+	err := app.publishMovieNotification(`{"op":"delete","id":5}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := <-sub.C
+	if event.Type != data.EventMovieDeleted || string(event.Data) != `{"movie":{"id":5}}` {
+		t.Errorf("got %s %s", event.Type, event.Data)
+	}
+
+	for _, payload := range []string{`{"op":"truncate","id":5}`, `not json`} {
+		if err := app.publishMovieNotification(payload); err == nil {
+			t.Errorf("publishMovieNotification(%q) returned no error", payload)
+		}
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// listMovieListsHandler handles "GET /v1/lists" and returns the lists the authenticated user
+// owns or collaborates on.
+func (app *application) listMovieListsHandler(w http.ResponseWriter, r *http.Request) {
+	lists, err := app.models.Lists.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"lists": lists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listUserMovieListsHandler handles "GET /v1/users/:id/lists" and returns the public lists of
+// a user.
+func (app *application) listUserMovieListsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	lists, err := app.models.Lists.GetPublicForOwner(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"lists": lists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMovieListHandler handles "POST /v1/lists". Lists are private unless a "visibility" is
+// given.
+func (app *application) createMovieListHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	list := &data.MovieList{
+		OwnerID:     app.contextGetUser(r).ID,
+		Name:        input.Name,
+		Description: input.Description,
+		Visibility:  input.Visibility,
+	}
+
+	if list.Visibility == "" {
+		list.Visibility = data.ListPrivate
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovieList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.Insert(list)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/lists/%s", list.Slug))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMovieListHandler handles "GET /v1/lists/:slug" and returns the list with its movies.
+// Public and unlisted lists can be seen by anyone with the slug, private lists only by the
+// owner, the collaborators and invited users.
+func (app *application) showMovieListHandler(w http.ResponseWriter, r *http.Request) {
+	list, _, ok := app.readMovieListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	movies, err := app.models.Lists.GetMovies(list.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	list.Movies = movies
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateMovieListHandler handles "PATCH /v1/lists/:slug". Only the owner can rename a list or
+// change its visibility.
+func (app *application) updateMovieListHandler(w http.ResponseWriter, r *http.Request) {
+	list, role, ok := app.readMovieListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if role != data.ListRoleOwner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Visibility  *string `json:"visibility"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+
+	if input.Description != nil {
+		list.Description = *input.Description
+	}
+
+	if input.Visibility != nil {
+		list.Visibility = *input.Visibility
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovieList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.Update(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteMovieListHandler handles "DELETE /v1/lists/:slug". Only the owner can delete a list.
+func (app *application) deleteMovieListHandler(w http.ResponseWriter, r *http.Request) {
+	list, role, ok := app.readMovieListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if role != data.ListRoleOwner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err := app.models.Lists.Delete(list.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "list successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addMovieListItemHandler handles "PUT /v1/lists/:slug/movies/:movie_id". The owner and the
+// collaborators can add movies to a list.
+func (app *application) addMovieListItemHandler(w http.ResponseWriter, r *http.Request) {
+	list, role, ok := app.readMovieListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if role != data.ListRoleOwner && role != data.ListRoleCollaborator {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Check the movie exists so that we can send a 404 rather than a foreign key violation.
+	_, err = app.catalogue(r).Primary().Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Lists.AddMovie(list.ID, movieID, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully added to list"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeMovieListItemHandler handles "DELETE /v1/lists/:slug/movies/:movie_id".
+func (app *application) removeMovieListItemHandler(w http.ResponseWriter, r *http.Request) {
+	list, role, ok := app.readMovieListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if role != data.ListRoleOwner && role != data.ListRoleCollaborator {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Lists.RemoveMovie(list.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully removed from list"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listMovieListCollaboratorsHandler handles "GET /v1/lists/:slug/collaborators" and returns
+// the collaborators and pending invitations of a list.
+func (app *application) listMovieListCollaboratorsHandler(w http.ResponseWriter, r *http.Request) {
+	list, role, ok := app.readMovieListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if role != data.ListRoleOwner && role != data.ListRoleCollaborator {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	collaborators, err := app.models.Lists.GetCollaborators(list.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"collaborators": collaborators}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// inviteMovieListCollaboratorHandler handles "POST /v1/lists/:slug/collaborators". The owner
+// invites another user by email address, and the user is sent an email with the slug of the
+// list to accept the invitation with.
+func (app *application) inviteMovieListCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	list, role, ok := app.readMovieListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if role != data.ListRoleOwner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invitee, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if invitee.ID == list.OwnerID {
+		v.AddError("email", "must not be the owner of the list")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collaborator, err := app.models.Lists.InviteCollaborator(list.ID, invitee.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCollaborator):
+			v.AddError("email", "this user has already been invited")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	collaborator.Name = invitee.Name
+
+	inviter := app.contextGetUser(r)
+
+	app.background(func() {
+		templateData := map[string]interface{}{
+			"inviterName": inviter.Name,
+			"listName":    list.Name,
+			"listSlug":    list.Slug,
+		}
+
+		err := app.sendEmail(invitee.Email, "list_invitation.tmpl", templateData)
+		if err != nil {
+			letter, letterErr := data.NewDeadLetter(invitee.Email, "list_invitation.tmpl", templateData, 1, err)
+			if letterErr != nil {
+				app.logger.PrintError(err, nil)
+				return
+			}
+			app.recordDeadLetter(letter)
+		}
+	})
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"collaborator": collaborator}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// acceptMovieListInvitationHandler handles "PUT /v1/lists/:slug/invitation", which the invited
+// user sends to start collaborating on the list.
+func (app *application) acceptMovieListInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	list, role, ok := app.readMovieListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if role != data.ListRoleInvited {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.models.Lists.AcceptInvitation(list.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeMovieListCollaboratorHandler handles "DELETE /v1/lists/:slug/collaborators/:user_id".
+// The owner can remove any collaborator or withdraw an invitation, and users can remove
+// themselves to leave a list or decline an invitation.
+func (app *application) removeMovieListCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	list, role, ok := app.readMovieListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if role != data.ListRoleOwner && userID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.Lists.RemoveCollaborator(list.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "collaborator successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readMovieListFromPath fetches the list named by the "slug" URL parameter along with the role
+// the current user has on it. Private lists are reported as not found to users without a role,
+// so that their existence isn't revealed. If anything goes wrong the error response is sent and
+// ok is false.
+func (app *application) readMovieListFromPath(w http.ResponseWriter, r *http.Request) (*data.MovieList, string, bool) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	list, err := app.models.Lists.GetBySlug(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, "", false
+	}
+
+	var role string
+
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() && !user.IsTrial() {
+		role, err = app.models.Lists.Role(list, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, "", false
+		}
+	}
+
+	if list.Visibility == data.ListPrivate && role == "" {
+		app.notFoundResponse(w, r)
+		return nil, "", false
+	}
+
+	return list, role, true
+}
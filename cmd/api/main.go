@@ -6,26 +6,32 @@ import (
 	"expvar"
 	"flag"
 	"fmt"
+	"net/mail"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+	"github.com/saalikmubeen/greenlight/internal/blob"
+	"github.com/saalikmubeen/greenlight/internal/cache"
+	"github.com/saalikmubeen/greenlight/internal/configfile"
 	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/errorreport"
+	"github.com/saalikmubeen/greenlight/internal/events"
+	"github.com/saalikmubeen/greenlight/internal/idp"
 	"github.com/saalikmubeen/greenlight/internal/jsonlog"
 	"github.com/saalikmubeen/greenlight/internal/mailer"
+	"github.com/saalikmubeen/greenlight/internal/ratelimit"
 	"github.com/saalikmubeen/greenlight/internal/vcs"
 
 	// Import the pq driver so that it can register itself with the database/sql
 	// package. Note that we alias this import to the blank identifier, to stop the Go
 	// compiler complaining that the package isn't being used.
 	_ "github.com/lib/pq"
-	//  The golang-migrate/migrate Go package to automatically execute your
-	//  database migrations on application start up.
-	// "github.com/golang-migrate/migrate/v4"
-	// "github.com/golang-migrate/migrate/v4/database/postgres"
-	// _ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
 // Set version of application corresponding to value of vcs.Version.
@@ -43,17 +49,25 @@ var (
 	// The -X linker flag allows you to set the value of a string variable at compile time.
 
 	// To test this, you can run the following command in the terminal:
-	// ./bin/api -version
+	// ./bin/api version
 )
 
 // Define a config struct.
 type config struct {
 	port int
 	env  string
+	// listen holds the address the server listens on instead of port, and the permissions of
+	// its socket file when it is a unix socket, see listen.go.
+	listen struct {
+		address    string
+		socketMode string
+	}
 	// db struct field holds the configuration settings for our database connection pool.
 	// For now this only holds the DSN, which we read in from a command-line flag.
 	db struct {
 		dsn string
+		// readDSN is the DSN of an optional read replica, see data.ReadPool.
+		readDSN string
 
 		/* You should explicitly set a MaxOpenConns value. This should be comfortably below any hard limits
 		on the number of connections imposed by your database and infrastructure.
@@ -82,24 +96,252 @@ type config struct {
 		It’s probably OK to leave ConnMaxLifetime as unlimited, unless your database imposes a
 		hard limit on connection lifetime. */
 		// ConnMaxLifeTime
+
+		// listen enables the LISTEN/NOTIFY listener which feeds movie changes to the event bus,
+		// see listener.go.
+		listen bool
+
+		// slowQuery is the duration from which queries are logged as slow, see
+		// data.SetSlowQueryLog. 0 disables the slow query log.
+		slowQuery time.Duration
+
+		// movieCacheSize and movieCacheTTL configure the data.MovieCache in front of
+		// MovieModel.Get. A size of 0 disables it.
+		movieCacheSize int
+		movieCacheTTL  time.Duration
+
+		// permissionCacheSize and permissionCacheTTL configure the data.PermissionCache in
+		// front of PermissionModel.GetAllForUser. A size of 0 disables it.
+		permissionCacheSize int
+		permissionCacheTTL  time.Duration
+
+		// poolCheckInterval is how often the connection pools are checked for saturation, see
+		// startDBPoolMonitor. 0 disables the checks. poolSaturation is the fraction of
+		// maxOpenConns in use from which a pool is reported as near its limit.
+		poolCheckInterval time.Duration
+		poolSaturation    float64
 	}
-	// Add a new limiter struct containing fields for the request-per-second and burst
-	// values, and a boolean field which we can use to enable/disable rate limiting.
-	limiter struct {
-		rps     float64 // requests per second
-		burst   int     // burst or bucket size
-		enabled bool
-	}
+	// live holds the rate limiter, CORS origin and log level settings, which can be reloaded
+	// without a restart, see reload.go.
+	live liveConfig
+	// smtp holds the SMTP server settings, and the policy for retrying the emails it fails to
+	// take, see mailer.RetryPolicy. dkim is the key the emails sent over SMTP are signed with, if
+	// any, see mailer.DKIMSigner; the domain defaults to the one of the sender address.
 	smtp struct {
 		host     string
 		port     int
 		username string
 		password string
 		sender   string
+		retry    mailer.RetryPolicy
+		// check dials the server at startup and in the healthcheck, see checkSMTP.
+		check bool
+		dkim  struct {
+			privateKeyFile string
+			selector       string
+			domain         string
+		}
+	}
+	// mail holds the provider emails are sent through: "smtp", with the settings above, the
+	// HTTP API of "ses", "sendgrid" or "mailgun", with their own settings, or, for local
+	// development, "log" or "file", which write the emails to the log or to .eml files in dir
+	// instead, see mailProvider. The sender address and the retry policy in smtp apply to every
+	// provider. templateDir is a directory of templates overriding the embedded ones, see
+	// mailer.Mailer.WithTemplateDir.
+	mail struct {
+		provider           string
+		dir                string
+		templateDir        string
+		sesRegion          string
+		sesAccessKeyID     string
+		sesSecretAccessKey string
+		sendGridAPIKey     string
+		mailgunAPIBase     string
+		mailgunDomain      string
+		mailgunAPIKey      string
 	}
 	cors struct {
-		trustedOrigins []string
+		// privateNetwork allows trusted origins to reach the API from public pages when it
+		// runs on a private network, as described in the Private Network Access spec.
+		privateNetwork bool
+		// allowCredentials lets trusted origins send cookies with their requests, and maxAge is
+		// how long browsers cache the answers to their preflight requests. publicMaxAge is the
+		// same for the public endpoints, which any origin may call, see cors.go.
+		allowCredentials bool
+		maxAge           time.Duration
+		publicMaxAge     time.Duration
+	}
+	// acme holds the settings for serving HTTPS with certificates obtained from an ACME
+	// certificate authority such as Let's Encrypt, see acme.go. It's disabled when domains is
+	// empty. Certificates are kept in cacheDir, and the HTTP-01 challenges are answered on
+	// httpPort.
+	acme struct {
+		domains  []string
+		cacheDir string
+		email    string
+		httpPort int
+	}
+	// comments holds the settings for the movie comments subsystem. editWindow is the amount
+	// of time after posting during which the author of a comment may still edit it.
+	comments struct {
+		editWindow time.Duration
+	}
+	// trial holds the settings for anonymous, read-only trial tokens. maxPerIP caps the number
+	// of trial tokens a single IP address can be issued in 24 hours, and rps/burst configure
+	// the dedicated rate limiter for requests made with a trial token. Both are keyed on the
+	// address of the TCP peer, unless it is one of trustedProxyCIDRs, see trialClientIP.
+	trial struct {
+		enabled           bool
+		ttl               time.Duration
+		maxPerIP          int
+		rps               float64
+		burst             int
+		trustedProxyCIDRs []string
+	}
+	// credentials holds the settings of the dedicated rate limiters of the endpoints which take
+	// credentials, one per client IP address and one per email address, see
+	// credentialRateLimit().
+	credentials struct {
+		enabled    bool
+		ipRPS      float64
+		ipBurst    int
+		emailRPS   float64
+		emailBurst int
+	}
+	// email holds the email address normalization policy applied on registration and lookup.
+	email struct {
+		lowercase        bool
+		foldGmailAliases bool
+	}
+	// tokens holds how the tokens are hashed, see data.TokenHasher: the algorithm and the
+	// optional pepper, and the ones they were hashed with before, which are accepted until the
+	// tokens hashed with them have expired. previousHash is empty when there are none.
+	tokens struct {
+		hash           string
+		pepper         string
+		previousHash   string
+		previousPepper string
+	}
+	// vault holds the settings for reading the database and SMTP credentials from HashiCorp
+	// Vault at startup, which is disabled when addr is empty.
+	vault struct {
+		addr      string
+		token     string
+		namespace string
+		dbPath    string
+		smtpPath  string
+	}
+	// backup holds the settings for differential backups of the movie change feed. dir is the
+	// blob store the batches are written to; the job is disabled when interval is 0.
+	backup struct {
+		dir      string
+		interval time.Duration
+	}
+	// cache holds the HTTP caching policy. catalogueMaxAge is how long browsers and CDNs may
+	// cache reads of the movie catalogue. movieTTL and movieListTTL are how long the server
+	// itself serves movie reads from its response cache, which holds up to maxEntries
+	// responses when it's kept in memory, see responsecache.go.
+	cache struct {
+		catalogueMaxAge time.Duration
+		movieTTL        time.Duration
+		movieListTTL    time.Duration
+		maxEntries      int
+	}
+	// redis holds the address and password of the Redis server the response cache and the
+	// rate limiters are shared through. Both are kept in memory when addr is empty.
+	redis struct {
+		addr     string
+		password string
+	}
+	// sentry holds where the server errors are reported, see errors.go: the DSN of the Sentry
+	// project, or empty to disable reporting, the fraction of the errors reported, and whether
+	// the events include personal data such as email and IP addresses.
+	sentry struct {
+		dsn        string
+		sampleRate float64
+		sendPII    bool
+	}
+	// stats holds the settings for the public statistics endpoint. The statistics are
+	// recomputed every refresh, and rps/burst configure its dedicated rate limiter.
+	stats struct {
+		refresh time.Duration
+		rps     float64
+		burst   int
+	}
+	// idp holds the settings for syncing accounts and permissions from an external identity
+	// provider over SCIM. The sync is disabled when scimURL is empty. groupPermissions maps
+	// directory group names to the permission codes their members get.
+	idp struct {
+		scimURL          string
+		scimToken        string
+		interval         time.Duration
+		groupPermissions map[string][]string
+	}
+	// json holds the limits on the shape of JSON request bodies, see jsonlimits.go.
+	json jsonLimits
+	// contentTypes are the media types accepted for request bodies, see contenttype.go.
+	contentTypes []string
+	// diagnostics holds the directory of the blob store that diagnostic bundles are written to
+	// on SIGQUIT or from the admin endpoint.
+	diagnostics struct {
+		dir string
+	}
+	// debug holds who may read the /debug/ endpoints, see debug.go: clients connecting from
+	// allowCIDRs, clients presenting the basic auth username and password, and users with
+	// permission. Each check is disabled when its setting is empty.
+	debug struct {
+		allowCIDRs []string
+		username   string
+		password   string
+		permission string
+	}
+	// log holds where the log entries are written, stdout, syslog or journald, and the keys
+	// whose values are masked in them, see jsonlog.SetRedactedKeys. The entries are also
+	// forwarded to a loki or otlp endpoint at exportURL when export is set, buffering up to
+	// exportBuffer of them.
+	log struct {
+		output       string
+		redactKeys   []string
+		export       string
+		exportURL    string
+		exportBuffer int
+	}
+	// quota holds the monthly request quota of authenticated users, see quota.go. monthly is
+	// the default quota, and 0 disables quotas; tiers give the users with some permissions a
+	// different quota.
+	quota struct {
+		monthly int64
+		tiers   quotaTiers
+	}
+	// outbox holds how often the outbox dispatcher sends the due emails and webhook events.
+	outbox struct {
+		interval time.Duration
 	}
+
+	// maintenance holds the schedules of the maintenance jobs, see scheduler.go, in cron syntax
+	// or a shorthand such as "@every 1h". An empty schedule disables its job. Accounts not
+	// activated within unactivatedAge of registering are deleted, and the popularity of the
+	// movies is scored from the activity within popularityWindow.
+	maintenance struct {
+		tokenPurge       string
+		accountCleanup   string
+		popularity       string
+		unactivatedAge   time.Duration
+		popularityWindow time.Duration
+	}
+
+	// webhooks holds the shared secret used to verify inbound webhooks, keyed by provider, and
+	// the interval at which due outbound webhook deliveries are sent.
+	webhooks struct {
+		secrets          map[string]string
+		deliveryInterval time.Duration
+	}
+
+	// file is the path of the configuration file, which is read again on SIGHUP, and pinned
+	// holds the flags set on the command line or in the environment, which the file can't
+	// override.
+	file   string
+	pinned map[string]string
 }
 
 // Define an application struct to hold dependencies for our HTTP handlers, helpers, and
@@ -110,114 +352,151 @@ type application struct {
 	models data.Models
 	mailer mailer.Mailer
 	wg     sync.WaitGroup
+	// inflight tracks the requests currently being handled, see inflight.go.
+	inflight inflightRegistry
+	// apiRoutes lists the routes registered by routes(), for the OpenAPI document.
+	apiRoutes []apiRoute
+	// publicStats is the snapshot served by the public statistics endpoint, see stats.go.
+	publicStats publicStatsCache
+	// idpSync holds the report of the latest identity provider sync, see idpsync.go.
+	idpSync idpSyncState
+	// diagnostics is the blob store diagnostic bundles are written to, see diagnostics.go.
+	diagnostics blob.Store
+	// events is the bus movie and user events are published on, see events.go.
+	events *events.Bus
+	// responseCache holds the cached responses to movie reads, see responsecache.go. It is nil
+	// when the response cache is disabled.
+	responseCache *cache.Cache
+	// redis is the client of the Redis server set with -redis-addr, or nil if there is none.
+	redis *redis.Client
+	// errorReporter sends the server errors to Sentry, see errors.go. It is nil, and reports
+	// nothing, when -sentry-dsn is empty.
+	errorReporter *errorreport.Reporter
+	// scheduler runs the maintenance jobs, see scheduler.go.
+	scheduler *scheduler
+	// smtpCheck holds the outcome of the latest SMTP check, see healthcheck.go.
+	smtpCheck smtpCheckState
+	// live holds the current liveConfig, which is replaced on SIGHUP, see reload.go.
+	live atomic.Value
+	// limiters holds the per-client rate limiters, created on first use by clientLimiters().
+	limiters     ratelimit.ClientStore
+	limitersOnce sync.Once
 }
 
 func main() {
-	// Declare an instance of the config struct.
-	var cfg config
-
-	// Read the value of the port and env command-line flags into the config struct.
-	// We default to using the port number 4000 and the environment "development" if no
-	// corresponding flags are provided.
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production")
-
-	// Read the DSN Value from the db-dsn command-line flag into the config struct.
-	// We default to using our development DSN if no flag is provided.
-	pw := os.Getenv("DB_PW")
-	flag.StringVar(&cfg.db.dsn, "db-dsn",
-		fmt.Sprintf("postgres://greenlight:%s@localhost/greenlight?sslmode=disable",
-			pw), "PostgreSQL DSN")
-
-	// Read the connection pool settings from command-line flags into the config struct.
-	// Notice the default values that we're using?
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25,
-		"PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25,
-		"PostgreSQL max open idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m",
-		"PostgreSQL max connection idle time")
-
-	// Read the limiter settings from the command-line flags into the config struct.
-	// We use true as the default for 'enabled' setting.
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-
-	// Read the SMTP server configuration settings into the config struct, using the
-	// Mailtrap settings as the default values.
-	mtUser := os.Getenv("MAILTRAP_USER")
-	mtPw := os.Getenv("MAILTRAP_PW")
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", mtUser, "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", mtPw, "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "DoNotReply <3fc3f54366-09689f+1@inbox.mailtrap.io>", "SMTP sender")
-
-	// Use flag.Func function to process the -cors-trusted-origins command line flag. In this we
-	// use the strings.Field function to split the flag value into slice based on whitespace
-	// characters and assign it to our config struct. Importantly, if the -cors-trusted-origins
-	// flag is not present, contains the empty string, or contains only whitespace, then
-	// strings.Fields will return an empty []string slice.
-	// cors-trusted-origins will be a string containing a space-separated list of trusted origins.
-	// For example, "http://localhost:4000 http://localhost:4001 http://localhost:4002"
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
-	})
+	os.Exit(runCommand(os.Args[1:]))
+}
 
-	// Create a new version boolean flag with the default value of false.
-	displayVersion := flag.Bool("version", false, "Display version and exit")
+// serveCommand runs the API server. -backup-restore replays the backup into the database
+// (optionally only up to -backup-restore-until) and exits instead of starting the server.
+func serveCommand() *command {
+	var (
+		restore      bool
+		restoreUntil time.Time
+	)
 
-	flag.Parse()
+	return &command{
+		name:    "serve",
+		summary: "Run the API server",
+		config:  true,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&restore, "backup-restore", false, "Restore movies from the backup and exit")
+			fs.Func("backup-restore-until", "Only restore changes made up to this RFC3339 time", func(val string) error {
+				t, err := time.Parse(time.RFC3339, val)
+				if err != nil {
+					return err
+				}
+				restoreUntil = t
+				return nil
+			})
+		},
+		run: func(cfg config, logger *jsonlog.Logger, args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("unexpected arguments %q", args)
+			}
+			return runServe(cfg, logger, restore, restoreUntil)
+		},
+	}
+}
 
-	// If the version flag value is true, then print out the version number and immediately exit.
-	if *displayVersion {
-		fmt.Printf("Version:\t%s\n", version)
+// versionCommand prints the version of the binary and when it was built.
+func versionCommand() *command {
+	return &command{
+		name:    "version",
+		summary: "Print the version and build time",
+		run: func(_ config, _ *jsonlog.Logger, args []string) error {
+			fmt.Printf("Version:\t%s\n", version)
 
-		// Print out the contents of the buildTime variable.
-		fmt.Printf("Build time:\t%s\n", buildTime)
-		os.Exit(0)
+			// Print out the contents of the buildTime variable.
+			fmt.Printf("Build time:\t%s\n", buildTime)
+			return nil
+		},
 	}
+}
 
-	// Initialize a new jsonlog.Logger which writes any messages *at or above* the INFO
-	// severity level to the standard out stream.
-	logger := jsonlog.NewLogger(os.Stdout, jsonlog.LevelInfo)
+// runServe starts the background jobs and the API server, and returns once the server has
+// shut down, or after restoring the backup if restore is set.
+func runServe(cfg config, logger *jsonlog.Logger, restore bool, restoreUntil time.Time) error {
+	// Set up the mailer first, so that broken email template overrides stop the application
+	// before it connects to anything.
+	appMailer, err := newMailer(cfg, logger)
+	if err != nil {
+		return err
+	}
+	switch provider := mailProvider(cfg); provider {
+	case "log", "file":
+		logger.PrintInfo("emails are not sent, the "+provider+" mail provider keeps them locally", map[string]string{"mail_dir": cfg.mail.dir})
+	}
 
 	// Call the openDB() helper function (see below) to create teh connection pool,
-	// passing in the config struct. If this returns an error,
-	// we log it and exit the application immediately.
-	db, err := openDB(cfg)
+	// passing in the config struct. If this returns an error, we return it and the
+	// application exits immediately.
+	db, err := openDB(cfg, cfg.db.dsn)
 	if err != nil {
-		logger.PrintFatal(err, nil)
-	}
-
-	// To automatically execute your database migrations on application start up
-	// golang-migrate/migrate
-	// migrationDriver, err := postgres.WithInstance(db, &postgres.Config{})
-	// if err != nil {
-	// 	logger.PrintFatal(err, nil)
-	// }
-	// migrator, err := migrate.NewWithDatabaseInstance("../../migrations", "postgres", migrationDriver)
-	// if err != nil {
-	// 	logger.PrintFatal(err, nil)
-	// }
-	// err = migrator.Up()
-	// if err != nil && err != migrate.ErrNoChange {
-	// 	logger.PrintFatal(err, nil)
-	// }
-	// fmt.Printf("database migrations applied")
-
-	// Defer a call to db.Close() so that the connection pool is closed before the main()
-	// function exits.
+		return err
+	}
+
+	// Defer a call to db.Close() so that the connection pool is closed before the command
+	// returns.
 	defer func() {
 		if err := db.Close(); err != nil {
-			logger.PrintFatal(err, nil)
+			logger.PrintError(err, nil)
 		}
 	}()
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	// Log the queries which are slower than -db-slow-query-threshold. Only the name of the
+	// model method which ran the query is logged, never its SQL or parameters.
+	data.SetSlowQueryLog(cfg.db.slowQuery, func(name string, duration time.Duration) {
+		logger.PrintInfo("slow database query", map[string]string{
+			"query":    name,
+			"duration": duration.String(),
+		})
+	})
+
+	// Open the read replica's connection pool, if there is one. The replica being unreachable
+	// doesn't stop the application from starting, as reads fall back to the primary until it's
+	// back.
+	var replica *sql.DB
+	if cfg.db.readDSN != "" {
+		replica, err = newDBPool(cfg, cfg.db.readDSN)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := replica.Close(); err != nil {
+				logger.PrintError(err, nil)
+			}
+		}()
+
+		if err := pingDB(replica); err != nil {
+			logger.PrintWarn("read replica unreachable, reading from the primary", map[string]string{"error": err.Error()})
+		} else {
+			logger.PrintInfo("read replica connection pool established", nil)
+		}
+	}
+
 	// Publish a new "version" varaible in the expar var handler
 	// containing our application version number.
 	// The first part of this — expvar.NewString("version") — creates a new
@@ -244,25 +523,565 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	// Report the server errors to Sentry, if it's configured, sending the events still queued
+	// when the server stops.
+	var errorReporter *errorreport.Reporter
+	if cfg.sentry.dsn != "" {
+		hostname, _ := os.Hostname()
+		errorReporter, err = errorreport.New(errorreport.Options{
+			DSN:         cfg.sentry.dsn,
+			Environment: cfg.env,
+			Release:     version,
+			ServerName:  hostname,
+			SampleRate:  cfg.sentry.sampleRate,
+			SendPII:     cfg.sentry.sendPII,
+		})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := errorReporter.Shutdown(ctx); err != nil {
+				logger.PrintError(err, nil)
+			}
+		}()
+		expvar.Publish("error_reports", expvar.Func(func() interface{} {
+			return errorReporter.Stats()
+		}))
+	}
+
 	// Declare an instance of the application struct, containing the config struct and the infoLog.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username,
-			cfg.smtp.password, cfg.smtp.sender),
+		config:        cfg,
+		logger:        logger,
+		models:        data.NewModels(db, replica),
+		mailer:        appMailer,
+		events:        events.NewBus(eventHistorySize, eventBufferSize),
+		errorReporter: errorReporter,
+	}
+	app.setLiveConfig(cfg.live)
+
+	// Check the SMTP server straight away, so that a misconfiguration shows up in the logs of
+	// the deploy. It doesn't stop the application from starting, as the emails are retried
+	// from the outbox once the server is back.
+	if cfg.smtp.check {
+		if app.checkSMTP().Status == "available" {
+			logger.PrintInfo("SMTP server check passed", map[string]string{"smtp_host": cfg.smtp.host})
+		}
+	}
+
+	// Warn when the connection pools run short of connections, before the requests start
+	// timing out waiting for one.
+	if cfg.db.poolCheckInterval > 0 {
+		app.startDBPoolMonitor("primary", db, cfg.db.poolCheckInterval, cfg.db.poolSaturation)
+		if replica != nil {
+			app.startDBPoolMonitor("replica", replica, cfg.db.poolCheckInterval, cfg.db.poolSaturation)
+		}
+	}
+
+	// Keep the most recently read movies in memory, so that popular titles don't cost a
+	// database round trip on every view.
+	if cfg.db.movieCacheSize > 0 {
+		app.models.Movies.Cache = data.NewMovieCache(cfg.db.movieCacheSize, cfg.db.movieCacheTTL)
+		expvar.Publish("movie_cache", expvar.Func(func() interface{} {
+			return app.models.Movies.Cache.Stats()
+		}))
+	}
+
+	// Keep the permissions of the active users in memory, as every authorized request checks
+	// them. The changes made through this instance apply at once, the others within the TTL,
+	// which is why the cache is opt-in. Each request looks them up at most once either way.
+	if cfg.db.permissionCacheSize > 0 {
+		app.models.Permissions.Cache = data.NewPermissionCache(cfg.db.permissionCacheSize, cfg.db.permissionCacheTTL)
+		expvar.Publish("permission_cache", expvar.Func(func() interface{} {
+			return app.models.Permissions.Cache.Stats()
+		}))
+	}
+
+	// Connect to Redis, if it's configured, to share the response cache and the rate limiters
+	// with the other instances. Redis being unreachable doesn't stop the application from
+	// starting, as both are kept in memory until it's back.
+	if cfg.redis.addr != "" {
+		app.redis = redis.NewClient(&redis.Options{Addr: cfg.redis.addr, Password: cfg.redis.password})
+		defer func() {
+			if err := app.redis.Close(); err != nil {
+				logger.PrintError(err, nil)
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := app.redis.Ping(ctx).Err()
+		cancel()
+		if err != nil {
+			logger.PrintWarn("redis unreachable, keeping the cache and rate limiters in memory", map[string]string{"error": err.Error()})
+		} else {
+			logger.PrintInfo("redis connection established", nil)
+		}
+	}
+
+	// Cache the responses to movie reads if either of their TTLs is set, in Redis if there is
+	// one or else in memory.
+	if cfg.cache.movieTTL > 0 || cfg.cache.movieListTTL > 0 {
+		var store cache.Store = cache.NewMemory(cfg.cache.maxEntries)
+		if app.redis != nil {
+			store = cache.NewRedis(app.redis, "greenlight:cache:")
+		}
+		app.responseCache = cache.New(store, func(err error) {
+			logger.PrintWarn("response cache error", map[string]string{"cache": "response", "error": err.Error()})
+		})
+		expvar.Publish("response_cache", expvar.Func(func() interface{} {
+			return app.responseCache.Stats()
+		}))
+	}
+
+	// Keep the caches consistent with the movie changes published on the event bus.
+	app.events.Handle(app.invalidateCaches)
+
+	// Check that the database schema is the one the models were written against before
+	// anything queries it.
+	schema, err := app.schemaStatus()
+	if err != nil {
+		return err
+	}
+	if err := checkSchema(schema); err != nil {
+		return err
+	}
+	if schema.Version > schema.Latest {
+		logger.PrintInfo("database schema is newer than this build", map[string]string{
+			"version": strconv.FormatInt(schema.Version, 10),
+			"latest":  strconv.FormatInt(schema.Latest, 10),
+		})
+	}
+
+	// Open the backup blob store if it's needed, and either run a restore and exit, or start
+	// the scheduled differential backup job.
+	if restore || cfg.backup.interval > 0 {
+		store, err := blob.NewFileStore(cfg.backup.dir)
+		if err != nil {
+			return err
+		}
+
+		if restore {
+			return app.restoreChanges(store, restoreUntil)
+		}
+
+		app.startBackupJob(store, cfg.backup.interval)
+	}
+
+	// Open the blob store for diagnostic bundles, and write one whenever we receive SIGQUIT.
+	app.diagnostics, err = blob.NewFileStore(cfg.diagnostics.dir)
+	if err != nil {
+		return err
+	}
+	app.startDiagnosticsOnSIGQUIT()
+
+	if cfg.db.listen {
+		err = app.startMovieEventListener(cfg.db.dsn)
+		if err != nil {
+			return err
+		}
+	}
+
+	app.startPublicStatsJob(cfg.stats.refresh)
+	app.startOutboxDispatcher(cfg.outbox.interval)
+	app.startWebhookDeliveryJob(cfg.webhooks.deliveryInterval)
+
+	jobs, err := app.maintenanceJobs()
+	if err != nil {
+		return err
+	}
+	app.startScheduler(jobs)
+	expvar.Publish("scheduled_jobs", expvar.Func(func() interface{} {
+		return app.scheduler.snapshot()
+	}))
+
+	if cfg.idp.scimURL != "" {
+		app.startIdPSyncJob(idp.NewSCIMSource(cfg.idp.scimURL, cfg.idp.scimToken), cfg.idp.interval)
 	}
 
 	// Call app.server() to start the server.
-	if err := app.serve(); err != nil {
-		logger.PrintFatal(err, nil)
+	return app.serve()
+}
+
+// registerConfigFlags defines the flags of the server configuration on fs, which every command
+// that needs the configuration accepts, see command.go.
+func registerConfigFlags(fs *flag.FlagSet, cfg *config) {
+	// Read the value of the port and env command-line flags into the config struct.
+	// We default to using the port number 4000 and the environment "development" if no
+	// corresponding flags are provided.
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production")
+
+	// Listen on a unix socket, e.g. -listen=unix:/run/greenlight.sock, or on a specific TCP
+	// address, instead of on every interface at -port.
+	fs.StringVar(&cfg.listen.address, "listen", "", "Address to listen on: host:port or unix:/path/to.sock (default all interfaces at -port)")
+	fs.StringVar(&cfg.listen.socketMode, "listen-socket-mode", "0660", "Permissions of the unix socket file, in octal")
+
+	// Read the DSN Value from the db-dsn command-line flag into the config struct.
+	// We default to using our development DSN if no flag is provided.
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "postgres://greenlight@localhost/greenlight?sslmode=disable", "PostgreSQL DSN")
+	fs.StringVar(&cfg.db.readDSN, "db-read-dsn", "", "PostgreSQL DSN of a read replica for read-only queries (default none)")
+
+	// Read the connection pool settings from command-line flags into the config struct.
+	// Notice the default values that we're using?
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25,
+		"PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25,
+		"PostgreSQL max open idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m",
+		"PostgreSQL max connection idle time")
+	fs.BoolVar(&cfg.db.listen, "db-listen", true,
+		"Publish movie changes from PostgreSQL notifications, so that every instance sees every change")
+	fs.DurationVar(&cfg.db.slowQuery, "db-slow-query-threshold", 500*time.Millisecond,
+		"Log database queries which take at least this long (0 disables)")
+	fs.IntVar(&cfg.db.movieCacheSize, "db-movie-cache-size", 1000,
+		"Number of movies kept in memory in front of the database (0 disables)")
+	fs.DurationVar(&cfg.db.movieCacheTTL, "db-movie-cache-ttl", time.Minute,
+		"How long a movie is kept in memory in front of the database")
+	fs.IntVar(&cfg.db.permissionCacheSize, "db-permission-cache-size", 0,
+		"Number of users whose permissions are kept in memory in front of the database, e.g. 10000 (0 disables)")
+	fs.DurationVar(&cfg.db.permissionCacheTTL, "db-permission-cache-ttl", 5*time.Second,
+		"How long the permissions of a user are kept in memory, which bounds how long the changes made by other instances take to apply")
+	fs.DurationVar(&cfg.db.poolCheckInterval, "db-pool-check-interval", 10*time.Second,
+		"How often to check the database connection pools for saturation (0 disables the checks)")
+	fs.Float64Var(&cfg.db.poolSaturation, "db-pool-saturation", 0.8,
+		"Fraction of db-max-open-conns in use from which a warning is logged")
+
+	// Read the rate limiter, CORS origin and log level settings, which can be reloaded.
+	registerLiveFlags(fs, &cfg.live)
+
+	// Read the SMTP server configuration settings into the config struct, using the
+	// Mailtrap settings as the default values.
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "DoNotReply <3fc3f54366-09689f+1@inbox.mailtrap.io>", "Sender of the emails, whichever the provider")
+
+	// Read the email provider settings. The API keys are read from the environment by default
+	// so that they don't show up in the process list.
+	fs.BoolVar(&cfg.smtp.check, "smtp-check", false, "Check the SMTP server accepts the credentials at startup and in the healthcheck")
+	fs.StringVar(&cfg.smtp.dkim.privateKeyFile, "dkim-private-key-file", "", "PEM file of the RSA private key emails sent over SMTP are DKIM signed with")
+	fs.StringVar(&cfg.smtp.dkim.selector, "dkim-selector", "", "DKIM selector of the public key in DNS")
+	fs.StringVar(&cfg.smtp.dkim.domain, "dkim-domain", "", "DKIM signing domain (default the domain of -smtp-sender)")
+	fs.StringVar(&cfg.mail.provider, "mail-provider", "", "Provider emails are sent through (smtp, ses, sendgrid, mailgun, log or file; default log in development or without -smtp-host, else smtp)")
+	fs.StringVar(&cfg.mail.dir, "mail-dir", "", "Directory the file mail provider writes the emails to (default provider in development when set)")
+	fs.StringVar(&cfg.mail.sesRegion, "ses-region", "", "AWS region of the Amazon SES API")
+	fs.StringVar(&cfg.mail.sesAccessKeyID, "ses-access-key-id", "", "AWS access key ID for the Amazon SES API")
+	fs.StringVar(&cfg.mail.sesSecretAccessKey, "ses-secret-access-key", "", "AWS secret access key for the Amazon SES API")
+	fs.StringVar(&cfg.mail.sendGridAPIKey, "sendgrid-api-key", "", "SendGrid API key")
+	fs.StringVar(&cfg.mail.mailgunAPIBase, "mailgun-api-base", "https://api.mailgun.net", "Mailgun API base URL (https://api.eu.mailgun.net for EU domains)")
+	fs.StringVar(&cfg.mail.mailgunDomain, "mailgun-domain", "", "Mailgun sending domain")
+	fs.StringVar(&cfg.mail.mailgunAPIKey, "mailgun-api-key", "", "Mailgun API key")
+	fs.StringVar(&cfg.mail.templateDir, "mail-template-dir", "", "Directory of email templates overriding the embedded ones")
+
+	// Read the policy for retrying emails the SMTP server fails to take. Emails it rejects
+	// outright, such as for a bad address, aren't retried.
+	fs.IntVar(&cfg.smtp.retry.Attempts, "smtp-retry-attempts", mailer.DefaultRetryPolicy.Attempts, "Number of attempts to send an email")
+	fs.DurationVar(&cfg.smtp.retry.Backoff, "smtp-retry-backoff", mailer.DefaultRetryPolicy.Backoff, "Delay before retrying an email, doubled after every failed attempt")
+	fs.DurationVar(&cfg.smtp.retry.MaxBackoff, "smtp-retry-max-backoff", mailer.DefaultRetryPolicy.MaxBackoff, "Maximum delay before retrying an email")
+	fs.Float64Var(&cfg.smtp.retry.Jitter, "smtp-retry-jitter", 0.2, "Fraction of the retry delay it is randomly spread by (0 to 1)")
+
+	// Answer Private Network Access preflights from trusted origins. This is off by default,
+	// and only needs turning on when the API is served from a private network address.
+	fs.BoolVar(&cfg.cors.privateNetwork, "cors-private-network", false,
+		"Allow trusted CORS origins to make Private Network Access requests")
+
+	// The CORS policies of the route groups, see cors.go. Credentials are off by default, as
+	// the API authenticates with bearer tokens, which browsers don't send on their own.
+	fs.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", false,
+		"Allow trusted CORS origins to send credentials such as cookies")
+	fs.DurationVar(&cfg.cors.maxAge, "cors-max-age", time.Minute,
+		"How long browsers may cache the answers to CORS preflight requests")
+	fs.DurationVar(&cfg.cors.publicMaxAge, "cors-public-max-age", time.Hour,
+		"How long browsers may cache the answers to CORS preflight requests for the public endpoints")
+
+	// Serve HTTPS with certificates from Let's Encrypt for the listed domains. This is off by
+	// default; the certificates are provisioned on the first request for each domain and
+	// renewed automatically before they expire.
+	fs.Var((*fieldsValue)(&cfg.acme.domains), "acme-domains", "Domains to obtain Let's Encrypt certificates for (space separated)")
+	fs.StringVar(&cfg.acme.cacheDir, "acme-cache-dir", "./acme", "Directory the ACME account key and certificates are cached in")
+	fs.StringVar(&cfg.acme.email, "acme-email", "", "Contact email address for the ACME account")
+	fs.IntVar(&cfg.acme.httpPort, "acme-http-port", 80, "Port for ACME HTTP-01 challenges and redirects to HTTPS")
+
+	// Read the HTTP caching policy for catalogue reads.
+	fs.DurationVar(&cfg.cache.catalogueMaxAge, "cache-catalogue-max-age", time.Minute,
+		"How long clients and CDNs may cache movie catalogue reads")
+	fs.DurationVar(&cfg.cache.movieTTL, "cache-movie-ttl", 10*time.Second,
+		"How long the server caches GET /v1/movies/:id responses (0 disables)")
+	fs.DurationVar(&cfg.cache.movieListTTL, "cache-movie-list-ttl", 10*time.Second,
+		"How long the server caches GET /v1/movies responses (0 disables)")
+	fs.IntVar(&cfg.cache.maxEntries, "cache-max-entries", 1000,
+		"Maximum number of responses held in the server's response cache")
+
+	// Read the Redis server settings. The password is read from the environment by default so
+	// that it doesn't show up in the process list.
+	fs.StringVar(&cfg.redis.addr, "redis-addr", "", "Address of a Redis server to share the response cache and rate limiters through (empty disables)")
+	fs.StringVar(&cfg.redis.password, "redis-password", "", "Redis password")
+
+	// Read the error reporting settings. The DSN is read from the environment by default, as it
+	// holds the client key of the project.
+	fs.StringVar(&cfg.sentry.dsn, "sentry-dsn", "", "Sentry DSN to report server errors and panics to (empty disables)")
+	fs.Float64Var(&cfg.sentry.sampleRate, "sentry-sample-rate", 1, "Fraction of the server errors reported to Sentry")
+	fs.BoolVar(&cfg.sentry.sendPII, "sentry-send-pii", false, "Include email addresses, IP addresses and query strings in Sentry events")
+
+	// Read the public statistics settings. The statistics are served from memory, so the
+	// refresh interval bounds the load the endpoint can put on the database.
+	fs.DurationVar(&cfg.stats.refresh, "stats-refresh", 10*time.Minute, "Interval between refreshes of the public statistics")
+	fs.Float64Var(&cfg.stats.rps, "stats-rps", 0.2, "Public statistics rate limiter maximum requests per second")
+	fs.IntVar(&cfg.stats.burst, "stats-burst", 2, "Public statistics rate limiter maximum burst")
+
+	// Read the limits on JSON request bodies. The batch and JSON Patch endpoints lower the
+	// array limit further for their own lists.
+	fs.IntVar(&cfg.json.maxArrayLength, "json-max-array-length", 1000, "Maximum number of items in a JSON request body array (0 disables)")
+	fs.IntVar(&cfg.json.maxObjectKeys, "json-max-object-keys", 100, "Maximum number of keys in a JSON request body object (0 disables)")
+	fs.Var(unknownFieldsValue{&cfg.json.ignoreUnknownFields}, "json-unknown-fields", "Handling of unknown keys in JSON request bodies (reject|ignore)")
+	fs.BoolVar(&cfg.json.coerceTypes, "json-coerce-types", false, "Disable strict types, accepting e.g. \"42\" for a number in JSON request bodies")
+
+	// Read the media types accepted for request bodies, which are all of the ones the API can
+	// decode by default.
+	cfg.contentTypes = defaultContentTypes
+	fs.Var((*fieldsValue)(&cfg.contentTypes), "content-types", "Media types accepted for request bodies (space separated, empty disables)")
+
+	// Read the directory diagnostic bundles are written to.
+	fs.StringVar(&cfg.diagnostics.dir, "diagnostics-dir", "./diagnostics", "Directory of the blob store for diagnostic bundles")
+
+	// Read who may access the /debug/ endpoints. By default only users with the admin:read
+	// permission can.
+	fs.Var((*fieldsValue)(&cfg.debug.allowCIDRs), "debug-allow-cidrs", "Networks allowed to access /debug/ endpoints (space separated CIDRs)")
+	fs.StringVar(&cfg.debug.username, "debug-username", "", "Basic auth username for /debug/ endpoints")
+	fs.StringVar(&cfg.debug.password, "debug-password", "", "Basic auth password for /debug/ endpoints")
+	fs.StringVar(&cfg.debug.permission, "debug-permission", "admin:read", "Permission allowing users to access /debug/ endpoints (empty disables)")
+
+	// Read where the log entries are written. The system log and journald record the level of
+	// the entries as their priority.
+	fs.StringVar(&cfg.log.output, "log-output", "stdout", "Log output (stdout|syslog|journald)")
+
+	// Read the keys whose values are masked in the log, so that credentials logged by accident
+	// don't leak.
+	cfg.log.redactKeys = jsonlog.DefaultRedactedKeys
+	fs.Var((*fieldsValue)(&cfg.log.redactKeys), "log-redact-keys", "Keys whose values are masked in log entries (space separated)")
+
+	// Read where the log entries are forwarded to, if anywhere. The entries are dropped rather
+	// than slowing the application down when the endpoint can't keep up.
+	fs.StringVar(&cfg.log.export, "log-export", "", "Forward log entries to a log store (loki|otlp)")
+	fs.StringVar(&cfg.log.exportURL, "log-export-url", "", "Loki push or OTLP/HTTP logs endpoint, e.g. http://localhost:3100/loki/api/v1/push")
+	fs.IntVar(&cfg.log.exportBuffer, "log-export-buffer", 1000, "Maximum number of log entries waiting to be forwarded")
+
+	// Read the monthly request quotas. They are off by default.
+	fs.Int64Var(&cfg.quota.monthly, "quota-monthly", 0, "Monthly request quota of authenticated users (0 disables quotas)")
+	fs.Var(&cfg.quota.tiers, "quota-tiers", "Monthly request quotas of users with a permission (space separated permission=limit pairs, 0 is unlimited)")
+
+	// Read the comment edit window. After this duration has passed only moderators can
+	// change a comment.
+	fs.DurationVar(&cfg.comments.editWindow, "comments-edit-window", 15*time.Minute,
+		"Time during which a comment can be edited by its author")
+
+	// Read the trial token settings.
+	fs.BoolVar(&cfg.trial.enabled, "trial-enabled", false, "Enable anonymous trial tokens")
+	fs.DurationVar(&cfg.trial.ttl, "trial-ttl", time.Hour, "Lifetime of an anonymous trial token")
+	fs.IntVar(&cfg.trial.maxPerIP, "trial-max-per-ip", 3, "Maximum trial tokens issued per IP in 24 hours")
+	fs.Float64Var(&cfg.trial.rps, "trial-rps", 0.5, "Trial token rate limiter maximum requests per second")
+	fs.IntVar(&cfg.trial.burst, "trial-burst", 2, "Trial token rate limiter maximum burst")
+	fs.Var((*fieldsValue)(&cfg.trial.trustedProxyCIDRs), "trial-trusted-proxy-cidrs", "Proxies whose X-Forwarded-For is trusted for the trial token limits (space separated CIDRs)")
+
+	// Read the credential endpoints limiter settings. By default a client IP address can try 10
+	// logins in a row and then one every 10 seconds, and an email address 5 and then one a
+	// minute.
+	fs.BoolVar(&cfg.credentials.enabled, "credentials-limiter-enabled", true,
+		"Enable the rate limiters of the login and password reset endpoints")
+	fs.Float64Var(&cfg.credentials.ipRPS, "credentials-ip-rps", 0.1,
+		"Login and password reset rate limiter maximum requests per second per IP address")
+	fs.IntVar(&cfg.credentials.ipBurst, "credentials-ip-burst", 10,
+		"Login and password reset rate limiter maximum burst per IP address")
+	fs.Float64Var(&cfg.credentials.emailRPS, "credentials-email-rps", 1.0/60,
+		"Login and password reset rate limiter maximum requests per second per email address")
+	fs.IntVar(&cfg.credentials.emailBurst, "credentials-email-burst", 5,
+		"Login and password reset rate limiter maximum burst per email address")
+
+	// Read the email normalization policy. Lowercasing is on by default, folding Gmail dot
+	// and "+tag" aliases into a single address is opt-in.
+	fs.BoolVar(&cfg.email.lowercase, "email-lowercase", true, "Lowercase email addresses on registration and lookup")
+	fs.BoolVar(&cfg.email.foldGmailAliases, "email-fold-gmail", false, "Fold Gmail dot and plus aliases into one address")
+
+	// Read the token hashing settings. The pepper is a secret, usually set with the
+	// GREENLIGHT_TOKEN_PEPPER environment variable. To change the algorithm or the pepper,
+	// move the old values to -token-previous-hash and -token-previous-pepper, and remove them
+	// once the longest-lived tokens, the 7-day organization invitations, have expired.
+	fs.StringVar(&cfg.tokens.hash, "token-hash", data.TokenHashSHA256, "Algorithm tokens are hashed with (sha256|sha512)")
+	fs.StringVar(&cfg.tokens.pepper, "token-pepper", "", "Secret the token hashes are keyed with (default none)")
+	fs.StringVar(&cfg.tokens.previousHash, "token-previous-hash", "",
+		"Algorithm the tokens still in use were hashed with before -token-hash was changed (default none)")
+	fs.StringVar(&cfg.tokens.previousPepper, "token-previous-pepper", "",
+		"Secret the tokens still in use were keyed with before -token-pepper was changed (default none)")
+
+	// Read the differential backup settings.
+	fs.StringVar(&cfg.backup.dir, "backup-dir", "./backups", "Directory of the blob store for movie backups")
+	fs.DurationVar(&cfg.backup.interval, "backup-interval", 0, "Interval between differential backups (0 disables)")
+
+	// Read the inbound webhook secrets as a space-separated list of provider=secret pairs,
+	// e.g. "smtp=s3cr3t metadata=an0th3r". Webhooks are only accepted from providers listed here.
+	cfg.webhooks.secrets = make(map[string]string)
+	fs.Func("webhook-secrets", "Inbound webhook secrets (space separated provider=secret pairs)", func(val string) error {
+		for _, pair := range strings.Fields(val) {
+			provider, secret, ok := strings.Cut(pair, "=")
+			if !ok || provider == "" || secret == "" {
+				return fmt.Errorf("invalid webhook secret %q, must be provider=secret", pair)
+			}
+			cfg.webhooks.secrets[provider] = secret
+		}
+		return nil
+	})
+	fs.DurationVar(&cfg.outbox.interval, "outbox-interval", 2*time.Second, "Interval between runs of the outbox dispatcher")
+	fs.DurationVar(&cfg.webhooks.deliveryInterval, "webhook-delivery-interval", 5*time.Second, "Interval between runs of the outbound webhook delivery job")
+
+	// Read the maintenance job schedules.
+	fs.StringVar(&cfg.maintenance.tokenPurge, "schedule-token-purge", "@hourly", "Schedule of the expired token purge (cron syntax or @every <duration>, empty disables)")
+	fs.StringVar(&cfg.maintenance.accountCleanup, "schedule-account-cleanup", "30 3 * * *", "Schedule of the unactivated account cleanup (cron syntax or @every <duration>, empty disables)")
+	fs.StringVar(&cfg.maintenance.popularity, "schedule-popularity", "*/15 * * * *", "Schedule of the movie popularity recomputation (cron syntax or @every <duration>, empty disables)")
+	fs.DurationVar(&cfg.maintenance.unactivatedAge, "unactivated-account-age", 7*24*time.Hour, "Age at which accounts which were never activated are deleted")
+	fs.DurationVar(&cfg.maintenance.popularityWindow, "popularity-window", 30*24*time.Hour, "Period of activity movie popularity is scored from")
+
+	// Read the identity provider sync settings. The SCIM token is read from the environment
+	// by default so that it doesn't show up in the process list.
+	fs.StringVar(&cfg.idp.scimURL, "idp-scim-url", "", "SCIM 2.0 base URL of the identity provider to sync users from (empty disables)")
+	fs.StringVar(&cfg.idp.scimToken, "idp-scim-token", "", "Bearer token for the identity provider's SCIM API")
+	fs.DurationVar(&cfg.idp.interval, "idp-sync-interval", time.Hour, "Interval between identity provider syncs")
+	fs.Func("idp-group-permissions", "Permissions granted to members of identity provider groups (space separated group=permission[,permission...] pairs)", func(val string) error {
+		mapping, err := idp.ParseGroupPermissions(val)
+		if err != nil {
+			return err
+		}
+		cfg.idp.groupPermissions = mapping
+		return nil
+	})
+
+	// Read the HashiCorp Vault settings. The database path can hold a "dsn", or the "username"
+	// and "password" of e.g. dynamic credentials, which then replace those in -db-dsn and
+	// -db-read-dsn. The SMTP path holds a "username" and a "password".
+	fs.StringVar(&cfg.vault.addr, "vault-addr", "", "Vault address to read secrets from, e.g. https://vault.example.com:8200 (empty disables)")
+	fs.StringVar(&cfg.vault.token, "vault-token", "", "Vault token")
+	fs.StringVar(&cfg.vault.namespace, "vault-namespace", "", "Vault Enterprise namespace (default none)")
+	fs.StringVar(&cfg.vault.dbPath, "vault-db-path", "", "Vault path of the database credentials, e.g. database/creds/greenlight (default none)")
+	fs.StringVar(&cfg.vault.smtpPath, "vault-smtp-path", "", "Vault path of the SMTP credentials, e.g. secret/data/greenlight/smtp (default none)")
+
+	// Settings can also be read from a YAML or TOML file, so that deployments don't need a
+	// long list of flags.
+	fs.StringVar(&cfg.file, "config", "", "Path of a YAML or TOML configuration file")
+
+	// Secrets can also be read from files, such as the Docker and Kubernetes secrets mounted in
+	// the container, e.g. -smtp-password-file or GREENLIGHT_SMTP_PASSWORD_FILE.
+	configfile.AddFileFlags(fs, fileFlags...)
+}
+
+// newMailer returns the mailer sending the emails through the provider selected by
+// -mail-provider, with the templates in -mail-template-dir, if set, overriding the embedded ones.
+func newMailer(cfg config, logger *jsonlog.Logger) (mailer.Mailer, error) {
+	sender, err := newMailSender(cfg, logger)
+	if err != nil {
+		return mailer.Mailer{}, err
+	}
+
+	m := mailer.New(sender, cfg.smtp.sender, cfg.smtp.retry)
+	if cfg.mail.templateDir == "" {
+		return m, nil
+	}
+
+	m, err = m.WithTemplateDir(cfg.mail.templateDir)
+	if err != nil {
+		return m, fmt.Errorf("mail-template-dir: %w", err)
+	}
+	return m, nil
+}
+
+// mailProvider returns the provider selected by -mail-provider. Without one, the emails are
+// logged, or written to -mail-dir if it's set, in development or when -smtp-host is empty, so
+// that the application runs locally without SMTP credentials, and sent over SMTP otherwise.
+func mailProvider(cfg config) string {
+	switch {
+	case cfg.mail.provider != "":
+		return cfg.mail.provider
+	case cfg.env == "development" || cfg.smtp.host == "":
+		if cfg.mail.dir != "" {
+			return "file"
+		}
+		return "log"
+	default:
+		return "smtp"
+	}
+}
+
+// newMailSender returns the sender of the email provider selected by mailProvider. The SMTP
+// sender signs the emails with DKIM when -dkim-private-key-file is set.
+func newMailSender(cfg config, logger *jsonlog.Logger) (mailer.Sender, error) {
+	switch mailProvider(cfg) {
+	case "ses":
+		return mailer.NewSES(cfg.mail.sesRegion, cfg.mail.sesAccessKeyID, cfg.mail.sesSecretAccessKey), nil
+	case "sendgrid":
+		return mailer.NewSendGrid(cfg.mail.sendGridAPIKey), nil
+	case "mailgun":
+		return mailer.NewMailgun(cfg.mail.mailgunAPIBase, cfg.mail.mailgunDomain, cfg.mail.mailgunAPIKey), nil
+	case "log":
+		return mailer.NewLog(logger), nil
+	case "file":
+		return mailer.NewFile(cfg.mail.dir), nil
+	}
+
+	sender := mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password)
+	if cfg.smtp.dkim.privateKeyFile == "" {
+		return sender, nil
+	}
+
+	key, err := os.ReadFile(cfg.smtp.dkim.privateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("dkim-private-key-file: %w", err)
+	}
+	signer, err := mailer.NewDKIMSigner(dkimDomain(cfg), cfg.smtp.dkim.selector, key)
+	if err != nil {
+		return nil, fmt.Errorf("dkim-private-key-file: %w", err)
+	}
+	sender.SetDKIMSigner(signer)
+
+	return sender, nil
+}
+
+// dkimDomain returns the DKIM signing domain: -dkim-domain, or else the domain of -smtp-sender.
+func dkimDomain(cfg config) string {
+	if cfg.smtp.dkim.domain != "" {
+		return cfg.smtp.dkim.domain
 	}
+
+	addr, err := mail.ParseAddress(cfg.smtp.sender)
+	if err != nil {
+		return ""
+	}
+	return addr.Address[strings.LastIndex(addr.Address, "@")+1:]
 }
 
-// openDB returns a sql.DB connection pool to postgres database
-func openDB(cfg config) (*sql.DB, error) {
-	// Use sql.Open() to create an empty connection pool, using the DSN from the config struct.
-	db, err := sql.Open("postgres", cfg.db.dsn)
+// openDB returns a sql.DB connection pool to the postgres database at dsn, after checking that
+// it can connect to it.
+func openDB(cfg config, dsn string) (*sql.DB, error) {
+	db, err := newDBPool(cfg, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pingDB(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Return the sql.DB connection pool.
+	return db, nil
+}
+
+// newDBPool returns a sql.DB connection pool to the postgres database at dsn, with the pool
+// settings from cfg. It doesn't connect to the database yet.
+func newDBPool(cfg config, dsn string) (*sql.DB, error) {
+	// Use sql.Open() to create an empty connection pool, using the DSN given.
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -285,6 +1104,11 @@ func openDB(cfg config) (*sql.DB, error) {
 	// Set the maximum idle timeout.
 	db.SetConnMaxIdleTime(duration)
 
+	return db, nil
+}
+
+// pingDB checks that a connection to db can be established within 5 seconds.
+func pingDB(db *sql.DB) error {
 	// Create a context with a 5-second timeout deadline.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -293,13 +1117,7 @@ func openDB(cfg config) (*sql.DB, error) {
 	// passing in the context we created above as a parameter.
 	// If connection couldn't be established successfully within the 5-second deadline,
 	// then this will return an error.
-	err = db.PingContext(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	// Return the sql.DB connection pool.
-	return db, nil
+	return db.PingContext(ctx)
 }
 
 // To run the application with the flags, you can use the following command:
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// Authentication outcome values used as keys in the authOutcomes expvar map.
+const (
+	authOutcomeAnonymous              = "anonymous"
+	authOutcomeValidToken             = "valid_token"
+	authOutcomeTrialToken             = "trial_token"
+	authOutcomeExpiredToken           = "expired_token"
+	authOutcomeInvalidToken           = "invalid_token"
+	authOutcomeInactiveAccount        = "inactive_account"
+	authOutcomeInsufficientPermission = "insufficient_permission"
+)
+
+// Failed login reasons used as keys in the failedLogins expvar map.
+const (
+	loginFailureUnknownEmail  = "unknown_email"
+	loginFailureWrongPassword = "wrong_password"
+)
+
+// Unlike the counters in the metrics() middleware, these are published at package level because
+// they are incremented from several middlewares and handlers. expvar panics if the same name is
+// published twice, so they must only ever be created once.
+var (
+	// authOutcomes counts requests by the outcome of authentication and authorization, so that
+	// a spike in expired tokens or permission failures shows up on the dashboards.
+	authOutcomes = expvar.NewMap("total_authentication_outcomes")
+
+	// failedLogins counts the failed attempts to log in by reason. Many unknown emails point to
+	// someone probing for accounts, and many wrong passwords to someone guessing them.
+	failedLogins = expvar.NewMap("total_failed_logins")
+
+	// rateLimitRejections counts the requests rejected with a 429 Too Many Requests response by
+	// method and route, such as "GET /v1/movies/:id", so that the routes being hammered stand
+	// out.
+	rateLimitRejections = expvar.NewMap("total_rate_limit_rejections")
+)
+
+// countRateLimitRejection counts the request in rateLimitRejections. The limiters run before
+// the router, so the route is looked up among the registered ones; the requests which match
+// none are counted under unmatchedRoute, as in the metrics by route.
+func (app *application) countRateLimitRejection(r *http.Request) {
+	pattern := unmatchedRoute
+	if route, ok := matchRoute(app.apiRoutes, r.Method, r.URL.Path); ok {
+		pattern = route.path
+	}
+	rateLimitRejections.Add(r.Method+" "+pattern, 1)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRateLimitRejectionsByRoute(t *testing.T) {
+	app := newTestApp()
+	app.apiRoutes = []apiRoute{{http.MethodGet, "/v1/movies/:id"}}
+
+	before := expvarMap("total_rate_limit_rejections")
+
+	app.rateLimitExceededResponse(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/movies/42", nil))
+	app.quotaExceededResponse(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/movies/7", nil))
+	app.rateLimitExceededResponse(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/unknown", nil))
+
+	after := expvarMap("total_rate_limit_rejections")
+	if got := after["GET /v1/movies/:id"] - before["GET /v1/movies/:id"]; got != 2 {
+		t.Errorf("got %d rejections for the route; want 2", got)
+	}
+	if got := after["GET unmatched"] - before["GET unmatched"]; got != 1 {
+		t.Errorf("got %d rejections for the unmatched requests; want 1", got)
+	}
+
+	rr := httptest.NewRecorder()
+	app.prometheusMetricsHandler(rr, httptest.NewRequest(http.MethodGet, "/debug/metrics", nil))
+
+	for _, line := range []string{
+		`greenlight_rate_limit_rejections_total{method="GET",route="/v1/movies/:id"}`,
+		"# TYPE greenlight_authentication_outcomes_total counter",
+		"# TYPE greenlight_failed_logins_total counter",
+	} {
+		if !strings.Contains(rr.Body.String(), line) {
+			t.Errorf("missing %q in:\n%s", line, rr.Body.String())
+		}
+	}
+}
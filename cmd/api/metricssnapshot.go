@@ -0,0 +1,166 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// resettableCounters are the names of the expvar request counters which DELETE
+// /v1/admin/metrics sets back to zero. The gauges, such as the goroutines and the cache sizes,
+// aren't counters and are left alone.
+var resettableCounters = []string{
+	"total_requests_received",
+	"total_responses_sent",
+	"total_processing_time_µs",
+	"total_responses_sent_by_status",
+	"total_authentication_outcomes",
+	"total_failed_logins",
+	"total_rate_limit_rejections",
+}
+
+// metricsWindow records when the request counters started counting: when the server started,
+// or when they were last reset.
+var metricsWindow = struct {
+	mu    sync.Mutex
+	since time.Time
+}{since: time.Now()}
+
+// latencyPercentiles are the estimated percentiles of the request durations, in milliseconds.
+type latencyPercentiles struct {
+	P50 float64 `json:"p50_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+}
+
+// metricsSnapshot is the point-in-time state of the request counters, along with the values
+// derived from them which /debug/vars leaves to the reader to compute.
+type metricsSnapshot struct {
+	Since                     time.Time               `json:"since"`
+	ElapsedSeconds            float64                 `json:"elapsed_seconds"`
+	RequestsReceived          int64                   `json:"requests_received"`
+	ResponsesSent             int64                   `json:"responses_sent"`
+	InFlight                  int                     `json:"in_flight"`
+	RequestsPerSecond         float64                 `json:"requests_per_second"`
+	TotalProcessingTimeMicros int64                   `json:"total_processing_time_µs"`
+	AverageProcessingTimeMs   float64                 `json:"average_processing_time_ms"`
+	Latency                   latencyPercentiles      `json:"latency"`
+	ResponsesByStatus         map[string]int64        `json:"responses_by_status"`
+	AuthenticationOutcomes    map[string]int64        `json:"authentication_outcomes"`
+	FailedLogins              map[string]int64        `json:"failed_logins"`
+	RateLimitRejections       map[string]int64        `json:"rate_limit_rejections"`
+	Routes                    map[string]routeSummary `json:"routes"`
+}
+
+// takeMetricsSnapshot reads the request counters at now. inFlight is the number of requests
+// being handled, which is taken from the in-flight registry rather than computed as the
+// requests received minus the responses sent, as that goes wrong after a reset while requests
+// are being handled.
+func takeMetricsSnapshot(now time.Time, inFlight int) metricsSnapshot {
+	metricsWindow.mu.Lock()
+	since := metricsWindow.since
+	metricsWindow.mu.Unlock()
+
+	s := metricsSnapshot{
+		Since:                     since,
+		ElapsedSeconds:            now.Sub(since).Seconds(),
+		RequestsReceived:          expvarInt("total_requests_received"),
+		ResponsesSent:             expvarInt("total_responses_sent"),
+		InFlight:                  inFlight,
+		TotalProcessingTimeMicros: expvarInt("total_processing_time_µs"),
+		ResponsesByStatus:         expvarMap("total_responses_sent_by_status"),
+		AuthenticationOutcomes:    expvarMap("total_authentication_outcomes"),
+		FailedLogins:              expvarMap("total_failed_logins"),
+		RateLimitRejections:       expvarMap("total_rate_limit_rejections"),
+		Routes:                    requestsByRoute.summary(),
+	}
+
+	if s.ElapsedSeconds > 0 {
+		s.RequestsPerSecond = float64(s.RequestsReceived) / s.ElapsedSeconds
+	}
+	if s.ResponsesSent > 0 {
+		s.AverageProcessingTimeMs = float64(s.TotalProcessingTimeMicros) / float64(s.ResponsesSent) / 1000
+	}
+
+	durations := requestDurations.snapshot()
+	ms := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+	s.Latency = latencyPercentiles{
+		P50: ms(durations.quantile(0.50)),
+		P95: ms(durations.quantile(0.95)),
+		P99: ms(durations.quantile(0.99)),
+	}
+
+	return s
+}
+
+// resetMetrics sets the request counters, the latency histograms and the metrics by route back
+// to zero, and starts a new window at now.
+func resetMetrics(now time.Time) {
+	metricsWindow.mu.Lock()
+	defer metricsWindow.mu.Unlock()
+
+	for _, name := range resettableCounters {
+		switch v := expvar.Get(name).(type) {
+		case *expvar.Int:
+			v.Set(0)
+		case *expvar.Map:
+			v.Init()
+		}
+	}
+	requestDurations.Reset()
+	requestsByRoute.Reset()
+
+	metricsWindow.since = now
+}
+
+// expvarInt returns the value of the expvar.Int published as name, or 0 if there is none.
+func expvarInt(name string) int64 {
+	if v, ok := expvar.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// expvarMap returns the integer values of the expvar.Map published as name.
+func expvarMap(name string) map[string]int64 {
+	out := make(map[string]int64)
+	if v, ok := expvar.Get(name).(*expvar.Map); ok {
+		v.Do(func(kv expvar.KeyValue) {
+			if i, ok := kv.Value.(*expvar.Int); ok {
+				out[kv.Key] = i.Value()
+			}
+		})
+	}
+	return out
+}
+
+// showMetricsHandler handles "GET /v1/admin/metrics" and returns a snapshot of the request
+// counters with the in-flight requests, the request rate and the average and percentile
+// processing times computed from them.
+func (app *application) showMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := takeMetricsSnapshot(time.Now(), app.inflight.count())
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"metrics": snapshot}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resetMetricsHandler handles "DELETE /v1/admin/metrics", which sets the request counters back
+// to zero, e.g. between the runs of a load test, and returns the snapshot taken just before.
+func (app *application) resetMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	snapshot := takeMetricsSnapshot(now, app.inflight.count())
+	resetMetrics(now)
+
+	app.logger.PrintInfo("request metrics reset", map[string]string{"user_id": strconv.FormatInt(app.contextGetUser(r).ID, 10)})
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"metrics": snapshot}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetricsSnapshotAndReset(t *testing.T) {
+	resetMetrics(time.Now())
+
+	authOutcomes.Add(authOutcomeValidToken, 2)
+	requestDurations.Observe(20 * time.Millisecond)
+	requestsByRoute.Observe("GET", "/v1/movies", 200, 20*time.Millisecond)
+
+	now := time.Now().Add(10 * time.Second)
+	s := takeMetricsSnapshot(now, 3)
+
+	if s.InFlight != 3 || s.ElapsedSeconds < 10 {
+		t.Errorf("got in_flight %d and elapsed %vs", s.InFlight, s.ElapsedSeconds)
+	}
+	if s.AuthenticationOutcomes[authOutcomeValidToken] != 2 {
+		t.Errorf("got authentication outcomes %v", s.AuthenticationOutcomes)
+	}
+	if s.Latency.P50 <= 10 || s.Latency.P50 > 25 {
+		t.Errorf("got p50 %vms; want it in the bucket of the request", s.Latency.P50)
+	}
+	if s.Routes["GET /v1/movies"].Requests != 1 {
+		t.Errorf("got routes %v", s.Routes)
+	}
+
+	resetMetrics(now)
+	s = takeMetricsSnapshot(now, 0)
+
+	if len(s.AuthenticationOutcomes) != 0 || len(s.Routes) != 0 || s.Latency.P99 != 0 {
+		t.Errorf("got %+v after a reset", s)
+	}
+	if !s.Since.Equal(now) || s.RequestsPerSecond != 0 {
+		t.Errorf("got since %v and %v requests per second; want a new window", s.Since, s.RequestsPerSecond)
+	}
+}
@@ -3,18 +3,20 @@ package main
 import (
 	"errors"
 	"expvar"
-	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/felixge/httpsnoop"
+	"github.com/julienschmidt/httprouter"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 
 	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/errorreport"
+	"github.com/saalikmubeen/greenlight/internal/ratelimit"
 	"github.com/saalikmubeen/greenlight/internal/validator"
 )
 
@@ -46,11 +48,12 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				// connection after a response has been sent.
 				w.Header().Set("Connection:", "close")
 				// The value returned by recover() has the type interface{}, so we use
-				// fmt.Errorf() to normalize it into an error and call our
+				// errorreport.Recovered() to normalize it into an error, which keeps the
+				// stack trace of the panic for the error reporter, and call our
 				// serverErrorResponse() helper. In turn, this will log the error using our
 				// custom Logger type at the ERROR level and send the client a
 				// 500 Internal Server Error response.
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+				app.serverErrorResponse(w, r, errorreport.Recovered(err))
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -108,99 +111,122 @@ func (app *application) globalRateLimit(next http.Handler) http.Handler {
 	})
 }
 
-// IP-based Rate Limiting:
+// Per-client Rate Limiting:
 // A separate rate limiter for each client, so that one bad client making too
-// many requests doesn’t affect all the others.
-// Create an in-memory map of rate limiters, using the IP address for each client as the map key.
+// many requests doesn't affect all the others. The limiters are kept in a ratelimit.Store,
+// keyed by user ID or IP address (see rateLimitKey), and use the algorithm chosen with
+// -limiter-algorithm, see the internal/ratelimit package.
 func (app *application) rateLimit(next http.Handler) http.Handler {
-	// Define a client struct to hold the rate limiter and last seen time for reach client
-	// ! one time initialization
-	// This is a one time initialization of the client struct, meaning that it will only
-	// be run once when the application starts up. And after that the same client struct
-	// will be available to each request.
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only carry out the check if rate limited is enabled. The settings are read on every
+		// request, as they can be reloaded on SIGHUP.
+		if app.liveConfig().limiter.enabled {
+			store := app.clientLimiters()
+
+			// Authenticated users are limited by user ID, so that users behind a shared NAT
+			// don't share one limit and a user can't get around it by switching IP address.
+			// Everyone else, including trial token holders, is limited by the client's real
+			// IP address, which we get with the realip.FromRequest function.
+			key := rateLimitKey(app.contextGetUser(r), realip.FromRequest(r))
+
+			// If the request isn't allowed, send a 429 Too Many Requests response.
+			if !app.allowRequest(w, r, store, key) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientLimiters returns the store of the per-client rate limiters, configured with the current
+// live settings. The store is created on first use, and the same one is then used for every
+// request, by rateLimit() and by authenticate() for the requests it rejects. When there is a
+// Redis server, the limiters are kept there, so that a client's requests count against one
+// limit whichever instance they reach.
+func (app *application) clientLimiters() ratelimit.ClientStore {
+	live := app.liveConfig()
+
+	app.limitersOnce.Do(func() {
+		app.limiters = app.newClientStore("", ratelimit.Algorithm(live.limiter.algorithm), live.limiter.rps, live.limiter.burst, 3*time.Minute)
+	})
+
+	app.limiters.Configure(ratelimit.Algorithm(live.limiter.algorithm), live.limiter.rps, live.limiter.burst)
+	return app.limiters
+}
+
+// newClientStore returns a store of per-client rate limiters. When there is a Redis server, the
+// limiters are kept there, under a key prefix of their own for each name, so that a client's
+// requests count against one limit whichever instance they reach. The limiters of the clients
+// not seen within maxIdle are removed once every minute.
+func (app *application) newClientStore(name string, algorithm ratelimit.Algorithm, rps float64, burst int, maxIdle time.Duration) ratelimit.ClientStore {
+	var store ratelimit.ClientStore = ratelimit.NewStore(algorithm, rps, burst)
+
+	if app.redis != nil {
+		prefix, limiter := "greenlight:ratelimit:", "redis"
+		if name != "" {
+			prefix, limiter = prefix+name+":", name
+		}
+
+		store = ratelimit.NewRedisStore(app.redis, prefix, algorithm, rps, burst, func(err error) {
+			app.logger.PrintWarn("redis rate limiter error", map[string]string{"limiter": limiter, "error": err.Error()})
+		})
 	}
 
-	// Declare a mutex and a map to hold pointers to a client struct.
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	// Launch a background goroutine which removes old entries (any clients that we haven’t
-	// been seen recently from the clients map) from the clients map once every minute.
-	go func() {
-		for range time.Tick(time.Minute) {
-			// Or instead of using for range with time.Tick we can
-			// use simple for loop with time.Sleep as:
-			// for {
-			// 	time.Sleep(time.Minute)
-			//
-			//   rest of code ...
-			// }
-
-			// Lock the mutex to prevent any rate limiter checks from happening while the cleanup
-			// is taking place.
-			mu.Lock()
-
-			// Loop through all clients. if they haven't been seen within the last three minutes,
-			// then delete the corresponding entry from the clients map.
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
+	store.StartCleanup(time.Minute, maxIdle)
+	return store
+}
 
-			// Importantly, unlock the mutex when the cleanup is complete.
-			mu.Unlock()
+// rejectAuthentication sends the 401 Unauthorized response for a request whose Authorization
+// header was rejected. Such a request never gets to rateLimit(), which comes after
+// authenticate(), so the failure is charged to the client's IP address here instead: guessing
+// tokens is throttled like any other anonymous traffic, and once the limit is used up the
+// client gets a 429 Too Many Requests response rather than another answer to its guess.
+func (app *application) rejectAuthentication(w http.ResponseWriter, r *http.Request) {
+	if app.liveConfig().limiter.enabled {
+		key := rateLimitKey(data.AnonymousUser, realip.FromRequest(r))
+		if !app.allowRequest(w, r, app.clientLimiters(), key) {
+			return
 		}
-	}()
+	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Only carry out the check if rate limited is enabled.
-		if app.config.limiter.enabled {
-
-			// ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			// if err != nil {
-			// 	app.serverErrorResponse(w, r, err)
-			// 	return
-			// }
-
-			// Use the realip.FromRequest function to get the client's real IP address.
-			ip := realip.FromRequest(r)
-
-			// Lock the mutex to prevent this code from being executed concurrently.
-			mu.Lock()
-
-			// Check to see if the IP address already exists in the map. If it doesn't,
-			// then initialize a new rate limiter and add the IP address and limiter to the map.
-			if _, found := clients[ip]; !found {
-				// Use the requests-per-second and burst values from the app.config struct.
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
-			}
+	app.invalidAuthenticationTokenResponse(w, r)
+}
 
-			// Update the last seen time for the client.
-			clients[ip].lastSeen = time.Now()
+// allowRequest checks the request of the client identified by key against store. It sets the
+// X-RateLimit-* headers, so that clients can pace themselves, and returns true if the request
+// may go ahead; otherwise it sends a 429 Too Many Requests response with a Retry-After header.
+// When several limiters apply to a request, the headers of the last, most specific one win.
+func (app *application) allowRequest(w http.ResponseWriter, r *http.Request, store ratelimit.ClientStore, key string) bool {
+	res := store.Allow(key)
+
+	// X-RateLimit-Reset is the number of seconds until a full burst is available again.
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
 
-			// Call the limiter.Allow() method on the rate limiter for the current IP address.
-			// If the request isn't allowed, unlock the mutex and send a 429 Too Many Requests
-			// response.
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
+	return true
+}
 
-			// Very importantly, unlock the mutex before calling the next handler in the chain.
-			// Notice that we DON'T use defer to unlock the mutex, as that would mean that the mutex
-			// isn't unlocked until all handlers downstream of this middleware have also returned.
-			mu.Unlock()
-		}
-		next.ServeHTTP(w, r)
-	})
+// ceilSeconds rounds d up to whole seconds, so that a client waiting that long is never too
+// early.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// rateLimitKey returns the key of the rate limiter for a request made by user from ip: the
+// user ID for authenticated users, and the IP address otherwise. The prefixes keep the two
+// kinds of key apart.
+func rateLimitKey(user *data.User, ip string) string {
+	if user.IsAnonymous() || user.IsTrial() {
+		return "ip:" + ip
+	}
+	return "user:" + strconv.FormatInt(user.ID, 10)
 }
 
 // we need to add the authenticate() middleware to our handler chain.
@@ -216,6 +242,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// on the value of the Authorization header in the request.
 		w.Header().Set("Vary", "Authorization")
 
+		// Look the permissions of the user up at most once for the whole request.
+		r = app.contextSetRequestPermissions(r)
+
 		// Retrieve the value of the Authorization header from teh request.
 		// This will return the empty string "" if there is no such header found.
 		authorizationHeader := r.Header.Get("Authorization")
@@ -223,7 +252,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// If there is no Authorization header found, use the contextSetUser() helper to add
 		// an AnonymousUser to the request context. Then we call the next handler in the chain
 		// and return without executing any of the code below.
-		if authorizationHeader == "" {
+		// Basic auth credentials for the debug endpoints are checked by requireDebugAccess.
+		if authorizationHeader == "" || app.isBasicAuthToDebug(r) {
+			authOutcomes.Add(authOutcomeAnonymous, 1)
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
@@ -239,7 +270,8 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// invalidAuthenticationTokenResponse helper.
 		headerParts := strings.Split(authorizationHeader, " ")
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
+			authOutcomes.Add(authOutcomeInvalidToken, 1)
+			app.rejectAuthentication(w, r)
 			return
 		}
 
@@ -252,7 +284,8 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// If the token isn't valid, use the invalidAuthenticationtokenResponse
 		// helper to send a response, rather than the failedValidatedResponse helper.
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
+			authOutcomes.Add(authOutcomeInvalidToken, 1)
+			app.rejectAuthentication(w, r)
 			return
 		}
 
@@ -264,13 +297,33 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
+				// The token may be an anonymous trial token instead, which are kept in
+				// their own table.
+				if app.config.trial.enabled {
+					valid, err := app.models.TrialTokens.Valid(token)
+					if err != nil {
+						app.serverErrorResponse(w, r, err)
+						return
+					}
+
+					if valid {
+						authOutcomes.Add(authOutcomeTrialToken, 1)
+						r = app.contextSetUser(r, data.NewTrialUser())
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+
+				app.recordFailedTokenOutcome(token)
+				app.rejectAuthentication(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
 			return
 		}
 
+		authOutcomes.Add(authOutcomeValidToken, 1)
+
 		// Call the contextSetUser helper to add the user information to the request context.
 		r = app.contextSetUser(r, user)
 
@@ -279,6 +332,23 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// recordFailedTokenOutcome works out whether a token that didn't match any user was expired or
+// simply unknown, and increments the corresponding authentication outcome counter. This costs
+// an extra query, but only on the failure path.
+func (app *application) recordFailedTokenOutcome(tokenPlaintext string) {
+	expired, err := app.models.Tokens.IsExpired(data.ScopeAuthentication, tokenPlaintext)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	if expired {
+		authOutcomes.Add(authOutcomeExpiredToken, 1)
+		return
+	}
+
+	authOutcomes.Add(authOutcomeInvalidToken, 1)
+}
+
 /*
  A 401 Unauthorized response should be used when you have missing or bad authentication,
  and a 403 Forbidden response should be used afterwards, when the user is authenticated
@@ -302,12 +372,41 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 			return
 		}
 
+		// Trial tokens are read-only, whatever permissions the route asks for.
+		if user.IsTrial() && !isSafeMethod(r.Method) {
+			authOutcomes.Add(authOutcomeInsufficientPermission, 1)
+			app.notPermittedResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	}
 }
 
-// requiredActivatedUser checks that the user is both authenticated and activated.
+// isSafeMethod reports whether the HTTP method is one which doesn't modify any state.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requiredActivatedUser checks that the user is both authenticated and activated, and not a
+// trial user: trial users have no account, so they can't use the routes working with the
+// user's own data. The routes they can use are guarded by requirePermissions instead, which
+// only grants them the data.TrialPermissions.
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return app.requireActivatedAccount(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetUser(r).IsTrial() {
+			authOutcomes.Add(authOutcomeInsufficientPermission, 1)
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireActivatedAccount checks that the user is both authenticated and activated, which trial
+// users are treated as.
+func (app *application) requireActivatedAccount(next http.HandlerFunc) http.HandlerFunc {
 	// Rather than returning this http.HandlerFunc we assign it to the variable fn.
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Use the contextGetUser() helper that we made earlier to retrieve the user
@@ -316,6 +415,7 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 		// Check that a user is activated
 		if !user.Activated {
+			authOutcomes.Add(authOutcomeInactiveAccount, 1)
 			app.inactiveAccountResponse(w, r)
 			return
 		}
@@ -329,37 +429,245 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 }
 
 // Note that the first parameter for the middleware function is the
-// permission code that we require the user to have.
+// permission code that we require the user to have. It is also granted by the wildcard
+// permissions covering it, such as "movies:*" or "*:write", see data.Permissions.Include.
 func (app *application) requirePermissions(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.requireAnyPermission([]string{code}, next)
+}
+
+// requireAnyPermission works like requirePermissions, but lets the request through if the user
+// has any one of the permission codes, such as either "movies:write:own" or "movies:write:any".
+// The handler then tells what the user may do from the permissions they have.
+func (app *application) requireAnyPermission(codes []string, next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Retrieve the user from the request context.
 		user := app.contextGetUser(r)
 
-		// Get the slice of permission for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// Check if the user's permissions include one of the required permissions, directly or
+		// through a wildcard. If they don't, then return a 403 Forbidden response.
+		for _, code := range codes {
+			ok, err := app.userHasPermission(r, user, code)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			// They have a required permission so we call the next handler in the chain.
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		authOutcomes.Add(authOutcomeInsufficientPermission, 1)
+		app.notPermittedResponse(w, r)
+	})
+
+	// Wrap this with the requireActivatedAccount middleware before returning, which lets trial
+	// users through to the permission check.
+	return app.requireActivatedAccount(fn)
+}
+
+// handleHead lets every GET route answer HEAD requests as well, without registering each route
+// twice. If the router has no HEAD handler for the path but does have a GET handler, the request
+// is passed on as a GET. Go's http.Server never sends a response body for HEAD requests (it
+// inspects the original request, not our copy), so the client only receives the status code
+// and headers, including Content-Length and X-Total-Count on listings.
+func (app *application) handleHead(router *httprouter.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			if h, _, _ := router.Lookup(http.MethodHead, r.URL.Path); h == nil {
+				if h, _, _ := router.Lookup(http.MethodGet, r.URL.Path); h != nil {
+					r = r.Clone(r.Context())
+					r.Method = http.MethodGet
+				}
+			}
+		}
+
+		router.ServeHTTP(w, r)
+	})
+}
+
+// userHasPermission reports whether the user is granted the given permission code, by the code
+// itself or a wildcard. Anonymous users never hold any permissions, and trial users only hold
+// data.TrialPermissions.
+func (app *application) userHasPermission(r *http.Request, user *data.User, code string) (bool, error) {
+	if user.IsAnonymous() {
+		return false, nil
+	}
+
+	if user.IsTrial() {
+		return data.TrialPermissions.Include(code), nil
+	}
+
+	permissions, err := app.userPermissions(r, user)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
+
+// userPermissions returns the permissions of a registered user. They are only looked up once
+// per request, see requestPermissions, and then come from the permission cache if it's enabled.
+func (app *application) userPermissions(r *http.Request, user *data.User) (data.Permissions, error) {
+	memo := app.contextGetRequestPermissions(r)
+	if memo == nil {
+		return app.models.Permissions.GetAllForUser(user.ID)
+	}
+
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	if permissions, ok := memo.byUser[user.ID]; ok {
+		return permissions, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	memo.byUser[user.ID] = permissions
+	return permissions, nil
+}
+
+// viewAs lets an administrator make read-only requests as if they were another user, by
+// sending the id of that user in the X-View-As header. Permissions and visibility are then
+// evaluated for that user, which helps to debug "why can't this customer see X" reports
+// without the administrator needing the customer's credentials. Only GET and HEAD requests
+// are allowed, the administrator needs the "users:view-as" permission, and every request is
+// recorded in the view_as_audits table before it is served. The user's private notes and
+// security events stay hidden, see denyViewAs. It must run after authenticate().
+func (app *application) viewAs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "X-View-As")
+
+		header := r.Header.Get("X-View-As")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			app.errorResponse(w, r, http.StatusBadRequest, "the X-View-As header can only be used with GET requests")
+			return
+		}
+
+		admin := app.contextGetUser(r)
+
+		allowed, err := app.userHasPermission(r, admin, "users:view-as")
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		// Check if the slice includes the required permission. If it doesn't, then return a 403
-		// Forbidden response.
-		if !permissions.Include(code) {
+		if !allowed || !admin.Activated {
 			app.notPermittedResponse(w, r)
 			return
 		}
 
-		// Otherwise, they have the required permission so we call the next handler in the chain.
+		targetID, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || targetID < 1 {
+			app.errorResponse(w, r, http.StatusBadRequest, "the X-View-As header must contain a user id")
+			return
+		}
+
+		target, err := app.models.Users.Get(targetID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.errorResponse(w, r, http.StatusBadRequest, "the X-View-As header refers to an unknown user")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// Record the request before serving it, so there is never an unaudited view.
+		err = app.models.ViewAs.Insert(&data.ViewAsAudit{
+			AdminID:      admin.ID,
+			TargetUserID: target.ID,
+			Method:       r.Method,
+			Path:         r.URL.RequestURI(),
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.logger.PrintInfo("view-as request", map[string]string{
+			"admin_id":       strconv.FormatInt(admin.ID, 10),
+			"target_user_id": strconv.FormatInt(target.ID, 10),
+			"method":         r.Method,
+			"path":           r.URL.RequestURI(),
+		})
+
+		w.Header().Set("X-Viewed-As", strconv.FormatInt(target.ID, 10))
+
+		r = app.contextSetUser(r, target)
+		r = app.contextSetViewAs(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// denyViewAs rejects the requests made with X-View-As for the resources private to the user,
+// such as their notes and security events, which an administrator viewing as them mustn't see
+// either.
+func (app *application) denyViewAs(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextIsViewAs(r) {
+			app.viewAsDeniedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// trialRateLimit applies a much stricter, per-IP rate limit to requests authenticated with an
+// anonymous trial token, on top of the regular rateLimit() middleware. It must run after
+// authenticate(), because it relies on the user in the request context.
+func (app *application) trialRateLimit(next http.Handler) http.Handler {
+	store := app.newClientStore("trial", ratelimit.TokenBucket, app.config.trial.rps, app.config.trial.burst, 3*time.Minute)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.contextGetUser(r).IsTrial() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !app.allowRequest(w, r, store, app.trialClientIP(r)) {
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
+}
+
+// trialClientIP returns the IP address the trial token limits are keyed on. It is the address of
+// the TCP peer, as any client can set X-Forwarded-For to get around the limits, unless the peer
+// is one of the -trial-trusted-proxy-cidrs, whose forwarding headers are trusted.
+func (app *application) trialClientIP(r *http.Request) string {
+	if peerInNetworks(r.RemoteAddr, parseNetworks(app.config.trial.trustedProxyCIDRs)) {
+		return realip.FromRequest(r)
+	}
 
-	// Wrap this with the requireActivatedUser middleware before returning
-	return app.requireActivatedUser(fn)
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 // enableCORS sets the Vary: Origin and Access-Control-Allow-Origin response headers in order to
-// enabled CORS for trusted origins.
+// enabled CORS for trusted origins, following the CORS policy of the route group the request is
+// for, see cors.go.
 func (app *application) enableCORS(next http.Handler) http.Handler {
+	// The policies are built once, when the middleware chain is built. The trusted origins are
+	// read on every request, as they can be reloaded on SIGHUP.
+	groups, defaultPolicy := app.corsGroups()
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		// The response will be different depending on the origin that the request
@@ -376,10 +684,13 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		*/
 
 		// Add the "Vary: Origin" header.
-		w.Header().Set("Vary", "Origin")
+		w.Header().Add("Vary", "Origin")
 
 		// Add the "Vary: Access-Control-Request-Method" header.
-		w.Header().Set("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		// The preflight response also depends on the Private Network Access request header.
+		w.Header().Add("Vary", "Access-Control-Request-Private-Network")
 
 		// Get the value of the request's Origin header.
 		origin := r.Header.Get("Origin")
@@ -392,37 +703,57 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 
 		// On run this if there's an Origin request header present.
 		if origin != "" {
-			// Loop through the list of trusted origins, checking to see if the request
-			// origin exactly matches one of them. If there are no trusted origins, then the
-			// loop won't be iterated.
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
-					// If there is a match, then set an "Access-Control-Allow-Origin" response
-					// header with the request origin as the value and break out of the loop.
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-
-					// Check if the request is a preflight request
-					// Check if the request has the HTTP method OPTIONS and contains the
-					// "Access-Control-Request-Method" header. If it does, then we treat it as a
-					// preflight request.
-					// The preflight requests always have three components:
-					// the HTTP method OPTIONS , an Origin header, and an
-					// Access-Control-Request-Method header.
-					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-						// Set the necessary preflight response headers.
-						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-
-						// Set max cached times for headers for 60 seconds.
-						w.Header().Set("Access-Control-Max-Age", "60")
-
-						// Write the headers along with a 200 OK status and return from the
-						// middleware with no further action.
-						w.WriteHeader(http.StatusOK)
-						return
+			// Look up the policy of the route group the request is for, and check the request
+			// origin against it. Unless the group allows every origin, the request origin must
+			// exactly match one of the trusted origins. If there are no trusted origins, then
+			// no origin matches.
+			policy := corsPolicyFor(groups, defaultPolicy, r.URL.Path)
+			if allowed := policy.allowOrigin(origin, app.liveConfig().trustedOrigins); allowed != "" {
+				// If there is a match, then set an "Access-Control-Allow-Origin" response
+				// header with the request origin (or "*") as the value.
+				w.Header().Set("Access-Control-Allow-Origin", allowed)
+
+				// Let the browser send cookies with the requests, and read the responses to
+				// them, if the policy allows it. It's never set alongside the "*" wildcard, see
+				// below.
+				if policy.allowCredentials && !policy.anyOrigin {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+
+				// Let browser clients read the rate limit headers set by allowRequest().
+				w.Header().Set("Access-Control-Expose-Headers",
+					"X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After")
+
+				// Check if the request is a preflight request
+				// Check if the request has the HTTP method OPTIONS and contains the
+				// "Access-Control-Request-Method" header. If it does, then we treat it as a
+				// preflight request.
+				// The preflight requests always have three components:
+				// the HTTP method OPTIONS , an Origin header, and an
+				// Access-Control-Request-Method header.
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					// Set the necessary preflight response headers from the policy.
+					w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.methods, ", "))
+					if len(policy.headers) > 0 {
+						w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.headers, ", "))
+					}
+
+					// Browsers implementing the Private Network Access spec send an
+					// "Access-Control-Request-Private-Network: true" header when a public
+					// page calls an API on a private network (e.g. an internal dashboard).
+					// If enabled, we opt in to that by answering with the matching allow
+					// header. Without it the browser blocks the request.
+					if app.config.cors.privateNetwork && r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+						w.Header().Set("Access-Control-Allow-Private-Network", "true")
 					}
 
-					break
+					// Let the browser cache the answer for the max age of the policy.
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.maxAge.Seconds())))
+
+					// Write the headers along with a 200 OK status and return from the
+					// middleware with no further action.
+					w.WriteHeader(http.StatusOK)
+					return
 				}
 			}
 		}
@@ -471,6 +802,10 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		// use the Add method to increment the number of requests received by 1.
 		totalRequestsReceived.Add(1)
 
+		// Let the router record the pattern of the route the request matches, which the
+		// metrics by route are keyed by rather than the path.
+		r, route := withMatchedRoute(r)
+
 		// Call the httpsnoop.CaptureMetrics function, passing in the next handler in the chain
 		// along with the existing http.ResponseWriter and http.Request.
 		// This returns the Metrics struct.
@@ -498,10 +833,17 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		// and increment the cumulative processing time.
 		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
 
+		// Count the processing time in the latency histogram too, from which the percentiles
+		// the cumulative total hides can be estimated.
+		requestDurations.Observe(metrics.Duration)
+
 		// Use the Add method to increment the count for the given status code by 1.
 		// Note, the expvar map is string-keyed, so we need to use the strconv.Itoa
 		// function to convert the status (an integer) to a string.
 		totalResponsesSentbyStatus.Add(strconv.Itoa(metrics.Code), 1)
+
+		// Count the request, its outcome and its duration for the route it matched.
+		requestsByRoute.Observe(r.Method, route.pattern, metrics.Code, metrics.Duration)
 	})
 }
 
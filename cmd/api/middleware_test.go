@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/ratelimit"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	tests := []struct {
+		user *data.User
+		want string
+	}{
+		{data.AnonymousUser, "ip:192.0.2.1"},
+		{&data.User{ID: 42}, "user:42"},
+	}
+
+	for _, tt := range tests {
+		if got := rateLimitKey(tt.user, "192.0.2.1"); got != tt.want {
+			t.Errorf("rateLimitKey(%+v) = %q; want %q", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestAllowRequest(t *testing.T) {
+	app := newTestApp()
+	store := ratelimit.NewStore(ratelimit.FixedWindow, 1, 2)
+
+	tests := []struct {
+		code      int
+		remaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	}
+
+	for i, tt := range tests {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+
+		if app.allowRequest(rr, r, store, "ip:192.0.2.1") {
+			rr.WriteHeader(http.StatusOK)
+		}
+
+		if rr.Code != tt.code {
+			t.Fatalf("request %d: got %d; want %d", i+1, rr.Code, tt.code)
+		}
+		if got := rr.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: got X-RateLimit-Limit %q; want 2", i+1, got)
+		}
+		if got := rr.Header().Get("X-RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("request %d: got X-RateLimit-Remaining %q; want %q", i+1, got, tt.remaining)
+		}
+		if tt.code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("request %d: got no Retry-After header", i+1)
+		}
+	}
+}
+
+func TestRejectedAuthenticationIsRateLimited(t *testing.T) {
+	app := newTestApp()
+	var live liveConfig
+	live.limiter.enabled = true
+	live.limiter.rps = 1
+	live.limiter.burst = 2
+	live.limiter.algorithm = string(ratelimit.FixedWindow)
+	app.setLiveConfig(live)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := app.authenticate(app.rateLimit(ok))
+
+	// Malformed tokens are rejected before any database lookup, and each rejection counts
+	// against the client's IP address.
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.Header.Set("Authorization", "Bearer guess")
+
+		handler.ServeHTTP(rr, r)
+
+		if rr.Code != want {
+			t.Fatalf("request %d: got %d; want %d", i+1, rr.Code, want)
+		}
+	}
+
+	// So do anonymous requests from the same address.
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("got %d for an anonymous request; want %d", rr.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestDenyViewAs(t *testing.T) {
+	app := newTestApp()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := app.denyViewAs(ok)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/movies/1/note", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("got %d for the user's own request; want %d", rr.Code, http.StatusOK)
+	}
+
+	rr = httptest.NewRecorder()
+	r := app.contextSetViewAs(httptest.NewRequest(http.MethodGet, "/v1/movies/1/note", nil))
+	handler.ServeHTTP(rr, r)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("got %d for a view-as request; want %d", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestCeilSeconds(t *testing.T) {
+	tests := map[time.Duration]int{
+		0:                       0,
+		time.Millisecond:        1,
+		time.Second:             1,
+		1500 * time.Millisecond: 2,
+	}
+
+	for d, want := range tests {
+		if got := ceilSeconds(d); got != want {
+			t.Errorf("ceilSeconds(%v) = %d; want %d", d, got, want)
+		}
+	}
+}
+
+func TestUserPermissionsOncePerRequest(t *testing.T) {
+	app := newTestApp()
+
+	r := app.contextSetRequestPermissions(httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+	app.contextGetRequestPermissions(r).byUser[7] = data.Permissions{"movies:read"}
+
+	// The test app has no database, so the permissions can only come from the request.
+	for _, code := range []string{"movies:read", "movies:write"} {
+		ok, err := app.userHasPermission(r, &data.User{ID: 7}, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := code == "movies:read"; ok != want {
+			t.Errorf("%s: got %t; want %t", code, ok, want)
+		}
+	}
+}
+
+func TestTrialUsersOnlyReadTheCatalogue(t *testing.T) {
+	app := newTestApp()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		want    int
+	}{
+		{"catalogue read", app.requirePermissions("movies:read", ok), http.MethodGet, http.StatusOK},
+		{"catalogue write", app.requirePermissions("movies:read", ok), http.MethodPost, http.StatusForbidden},
+		{"other permission", app.requirePermissions("movies:write", ok), http.MethodGet, http.StatusForbidden},
+		{"user's own data", app.requireActivatedUser(ok), http.MethodGet, http.StatusForbidden},
+		{"note", app.requirePermissions("movies:read", app.requireActivatedUser(ok)), http.MethodGet, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		r := app.contextSetUser(httptest.NewRequest(tt.method, "/", nil), data.NewTrialUser())
+		rr := httptest.NewRecorder()
+		tt.handler(rr, r)
+		if rr.Code != tt.want {
+			t.Errorf("%s: got %d; want %d", tt.name, rr.Code, tt.want)
+		}
+	}
+}
+
+func TestTrialClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		want       string
+	}{
+		{"no trusted proxies", nil, "10.0.0.2:4321", "10.0.0.2"},
+		{"trusted proxy", []string{"10.0.0.0/8"}, "10.0.0.2:4321", "198.51.100.1"},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "203.0.113.7:4321", "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		app := newTestApp()
+		app.config.trial.trustedProxyCIDRs = tt.trusted
+
+		r := httptest.NewRequest(http.MethodPost, "/v1/tokens/trial", nil)
+		r.RemoteAddr = tt.remoteAddr
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+		if got := app.trialClientIP(r); got != tt.want {
+			t.Errorf("%s: got %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
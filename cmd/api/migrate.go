@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+	"github.com/saalikmubeen/greenlight/migrations"
+)
+
+// migrateCommand applies the database migrations embedded in the binary: "migrate up" applies
+// every pending one, and "migrate down n" rolls back the latest n.
+func migrateCommand() *command {
+	return &command{
+		name:    "migrate",
+		args:    "up | down [n]",
+		summary: "Apply or roll back database migrations",
+		config:  true,
+		run: func(cfg config, logger *jsonlog.Logger, args []string) error {
+			down, err := parseMigrateArgs(args)
+			if err != nil {
+				return err
+			}
+
+			db, err := openDB(cfg, cfg.db.dsn)
+			if err != nil {
+				return err
+			}
+
+			return runMigrations(db, logger, down)
+		},
+	}
+}
+
+// parseMigrateArgs returns the number of migrations to roll back for the arguments of the
+// migrate command, or 0 to apply the pending ones. "down" on its own rolls back one.
+func parseMigrateArgs(args []string) (int, error) {
+	switch {
+	case len(args) == 1 && args[0] == "up":
+		return 0, nil
+	case len(args) == 1 && args[0] == "down":
+		return 1, nil
+	case len(args) == 2 && args[0] == "down":
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid number of migrations %q, must be a positive integer", args[1])
+		}
+		return n, nil
+	}
+
+	return 0, errors.New(`expected "up" or "down [n]"`)
+}
+
+// migrateLogger passes golang-migrate's progress messages, one per migration applied, to our
+// logger.
+type migrateLogger struct {
+	logger *jsonlog.Logger
+}
+
+func (l migrateLogger) Printf(format string, v ...interface{}) {
+	l.logger.PrintInfo(strings.TrimSpace(fmt.Sprintf(format, v...)), nil)
+}
+
+func (l migrateLogger) Verbose() bool {
+	return false
+}
+
+// runMigrations applies every pending up migration embedded in the binary when down is 0, or
+// else rolls back the latest down migrations. golang-migrate holds a PostgreSQL advisory lock
+// while it runs, so instances started together apply each migration only once. It closes db.
+func runMigrations(db *sql.DB, logger *jsonlog.Logger, down int) error {
+	source, err := iofs.New(migrations.Files, ".")
+	if err != nil {
+		return err
+	}
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		return err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	m.Log = migrateLogger{logger: logger}
+
+	if down > 0 {
+		err = m.Steps(-down)
+	} else {
+		err = m.Up()
+	}
+
+	var dirty migrate.ErrDirty
+	switch {
+	case errors.Is(err, migrate.ErrNoChange):
+		logger.PrintInfo("database schema is already up to date", nil)
+	case errors.As(err, &dirty):
+		return fmt.Errorf("migration %d failed part way through and must be fixed by hand before migrating again: %w", dirty.Version, err)
+	case err != nil:
+		return err
+	}
+
+	version, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+
+	logger.PrintInfo("database migrations complete", map[string]string{
+		"version": fmt.Sprint(version),
+	})
+	return nil
+}
+
+// checkSchema returns a descriptive error if the database schema in s isn't the one this build
+// was compiled against, so that the server refuses to start rather than failing requests with
+// SQL errors about missing tables and columns. A schema newer than the build is accepted, as
+// migrations are written to be backwards compatible for rolling back a release.
+func checkSchema(s schemaStatus) error {
+	switch {
+	case s.Dirty:
+		return fmt.Errorf("database migration %d failed part way through and must be fixed by hand", s.Version)
+	case s.Version == 0:
+		return fmt.Errorf("database has no migrations applied, run \"api migrate up\" to create the schema at version %d", s.Latest)
+	case s.Pending:
+		return fmt.Errorf("database schema is at version %d but this build needs version %d, run \"api migrate up\"", s.Version, s.Latest)
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/saalikmubeen/greenlight/internal/data"
 	"github.com/saalikmubeen/greenlight/internal/validator"
@@ -18,10 +19,11 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// request body (not that the field names and types in the struct are a subset of the Movie
 	// struct). This struct will be our *target decode destination*.
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title         string             `json:"title"`
+		Year          int32              `json:"year"`
+		Runtime       data.Runtime       `json:"runtime"`
+		Genres        []string           `json:"genres"`
+		Certification data.Certification `json:"certification"`
 	}
 
 	// Use the readJSON() helper to decode the request body into the struct.
@@ -33,12 +35,16 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Copy the values from the input struct to a new Movie struct.
+	// Copy the values from the input struct to a new Movie struct, which is owned by the user
+	// creating it and belongs to the catalogue of the organization the request was made in.
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:         input.Title,
+		Year:          input.Year,
+		Runtime:       input.Runtime,
+		Genres:        input.Genres,
+		Certification: input.Certification,
+		CreatedBy:     app.contextGetUser(r).ID,
+		OrgID:         app.orgID(r),
 	}
 
 	// Initialize a new Validator instance.
@@ -51,14 +57,30 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Call the Insert() method on our movies model, passing in a pointer to the validated movie
-	// struct. This will create a record in the database and update the movie struct with the
-	// system-generated information.
-	err = app.models.Movies.Insert(movie)
+	// Insert the movie in a transaction, which also writes the movie.created webhook event to
+	// the outbox. This will create a record in the database and update the movie struct with
+	// the system-generated information.
+	tx, err := app.models.Begin(3 * time.Second)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	defer tx.Rollback()
+
+	err = tx.InsertMovie(movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.commitWithEvent(tx, data.EventMovieCreated, envelope{"movie": movie})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditAfter(r, "movies", movie.ID, movie)
+
 	// When sending an HTTP response,
 	// we want to include a Location header to let the client know which URL they can find the
 	// newly created resource at. We make an empty http.Header map and then use the Set()
@@ -69,7 +91,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Write a JSON response with a 201 Created status code, the movie data in the response body,
 	// and the Location header.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": movie, "_links": app.movieLinks(movie.ID)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -92,7 +114,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	// We also need to use the errors.Is()
 	// function to check if it returns a data.ErrRecordNotFound error,
 	// in which case we send a 404 Not Found response to the client.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.catalogue(r).Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -105,7 +127,33 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 
 	// Create an envelope{"movie": movie} instance and pass it to writeJSON(), instead of passing
 	// the plain movie struct.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	env := envelope{"movie": movie, "_links": app.movieLinks(movie.ID)}
+
+	// If the user has written a private note about this movie, return it inline.
+	// The note is part of the representation, so it is part of the ETag too. An administrator
+	// viewing as the user doesn't get it, as it is only ever visible to the user who wrote it.
+	user := app.contextGetUser(r)
+	var note *data.Note
+
+	if !user.IsAnonymous() && !user.IsTrial() && !app.contextIsViewAs(r) {
+		note, err = app.models.Notes.Get(user.ID, movie.ID)
+		switch {
+		case err == nil:
+			env["note"] = note
+		case errors.Is(err, data.ErrRecordNotFound):
+			note = nil
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Send a 304 Not Modified response if the client already has this version of the movie.
+	if app.notModified(w, r, movieNoteETag(movie, note)) {
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -122,9 +170,9 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Fetch the existing movie record from the database.
-	// Send a 404 Not Found response to the client if we couldn't find a matching record.
-	movie, err := app.models.Movies.Get(id)
+	// Fetch the existing movie record from the primary database, since a replica may not have the
+	// latest version yet. Send a 404 Not Found response to the client if we couldn't find a matching record.
+	movie, err := app.catalogue(r).Primary().Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -135,6 +183,17 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Users with the movies:write:own permission may only update the movies they created.
+	owner, err := app.movieOwner(r, app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ownsMovie(owner, movie) {
+		app.notOwnerResponse(w, r)
+		return
+	}
+
 	// ** Round-trip locking
 	// One of the nice things about the optimistic locking pattern that we’ve used here
 	// is that you can extend it so the client passes the version number that
@@ -149,50 +208,74 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	// Use pointers for Title, Year, and Runtime fields, so that we can use their zero values of
-	// nil as part of the partial record update logic. Slice's zero value is already nil.
-	// ** Pointers have the zero-value nil .
-	var input struct {
-		// Title will be nil if there is no corresponding key in the JSON. If there
-		// is a key with an empty string then empty string will be placed while decoding
-		// json into input struct. But if there is no title key in json then Title will
-		// be nil after decoding json, that means user has not provided title field.
-		// In contrast to if Title was string and not *string, Title will be an empty
-		// string in both the cases when user provides title as an empty string
-		// or doesn't provide the field title in the json at all.
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// Clients can also send the ETag they last saw in an If-Match header. If the movie has
+	// changed since then we refuse the update with a 412 Precondition Failed response.
+	if moviePreconditionFailed(r, movie) {
+		app.preconditionFailedResponse(w, r)
 		return
 	}
 
-	// If the input.Title value is nil then we know that no corresponding "title" key/value pair
-	// was provided in the JSON request body. So, we move on and leave the movie record unchanged.
-	// Otherwise, we update the movie record with the new title value. Importantly, because
-	// input.Title is now a pointer to a string, we need to dereference the pointer using the *
-	// operator to get the underlying value before assigning it to our movie record.
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
+	app.auditBefore(r, "movies", movie.ID, movie)
 
-	// Also do the same for the other fields in the input struct
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
+	// Clients can send a JSON Patch (application/json-patch+json) or JSON Merge Patch
+	// (application/merge-patch+json) document instead of a partial movie. Patches make it
+	// possible to add or remove single genres without resending the whole list.
+	if contentType := patchContentType(r); contentType != "" {
+		if !app.applyMoviePatch(w, r, contentType, movie) {
+			return
+		}
+	} else {
+		// Use pointers for Title, Year, and Runtime fields, so that we can use their zero values of
+		// nil as part of the partial record update logic. Slice's zero value is already nil.
+		// ** Pointers have the zero-value nil .
+		var input struct {
+			// Title will be nil if there is no corresponding key in the JSON. If there
+			// is a key with an empty string then empty string will be placed while decoding
+			// json into input struct. But if there is no title key in json then Title will
+			// be nil after decoding json, that means user has not provided title field.
+			// In contrast to if Title was string and not *string, Title will be an empty
+			// string in both the cases when user provides title as an empty string
+			// or doesn't provide the field title in the json at all.
+			Title         *string             `json:"title"`
+			Year          *int32              `json:"year"`
+			Runtime       *data.Runtime       `json:"runtime"`
+			Genres        []string            `json:"genres"`
+			Certification *data.Certification `json:"certification"`
+		}
 
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
+		// Read the JSON request body data into the input struct.
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
 
-	if input.Genres != nil {
-		movie.Genres = input.Genres // Note that we don't need to dereference a slice because its zero is already nil
+		// If the input.Title value is nil then we know that no corresponding "title" key/value pair
+		// was provided in the JSON request body. So, we move on and leave the movie record unchanged.
+		// Otherwise, we update the movie record with the new title value. Importantly, because
+		// input.Title is now a pointer to a string, we need to dereference the pointer using the *
+		// operator to get the underlying value before assigning it to our movie record.
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+
+		// Also do the same for the other fields in the input struct
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+
+		if input.Genres != nil {
+			movie.Genres = input.Genres // Note that we don't need to dereference a slice because its zero is already nil
+		}
+
+		// An empty string removes the certification.
+		if input.Certification != nil {
+			movie.Certification = *input.Certification
+		}
 	}
 
 	// Validate the updated movie record,
@@ -204,10 +287,22 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Pass the updated movie record to the Update() method.
-	err = app.models.Movies.Update(movie)
+	// Update the movie in a transaction, which also writes the movie.updated webhook event to
+	// the outbox.
+	tx, err := app.models.Begin(3 * time.Second)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.UpdateOwnMovie(movie, owner)
 	if err != nil {
 		switch {
+		// If the movie changed between our Get() and Update() calls, a client which sent
+		// If-Match gets a 412 response, as its precondition no longer holds.
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -217,8 +312,19 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.commitWithEvent(tx, data.EventMovieUpdated, envelope{"movie": movie})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditAfter(r, "movies", movie.ID, movie)
+
+	// Write the updated movie record in a JSON response, along with its new ETag.
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie, "_links": app.movieLinks(movie.ID)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -236,9 +342,8 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Delete the movie from the database. Send a 404 Not Found response to the client if
-	// there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	// Fetch the movie first, so that the audit log records what was deleted.
+	movie, err := app.catalogue(r).Primary().Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -249,11 +354,61 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Users with the movies:write:own permission may only delete the movies they created.
+	owner, err := app.movieOwner(r, app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ownsMovie(owner, movie) {
+		app.notOwnerResponse(w, r)
+		return
+	}
+	movies := app.catalogue(r).OwnedBy(owner)
+
+	app.auditBefore(r, "movies", movie.ID, movie)
+
+	// If the client sent an If-Match header, only delete the movie if it hasn't changed since
+	// the client last fetched it. We check the ETag of the movie fetched above and then delete
+	// that exact version, so a concurrent update in between also fails the precondition.
+	if r.Header.Get("If-Match") != "" {
+		if moviePreconditionFailed(r, movie) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+
+		err = movies.DeleteVersion(id, movie.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.preconditionFailedResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	} else {
+		// Delete the movie from the database. Send a 404 Not Found response to the client if
+		// there isn't a matching record.
+		err = movies.Delete(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	app.publishEvent(data.EventMovieDeleted, envelope{"movie": envelope{"id": id}})
+
 	// Return a 200 OK status code along with a success message.
 	// You may prefer to send an empty response body and a 204 No Content status code
 	// here, rather than a "movie successfully deleted" message. It really depends on who
 	// your clients are
-	err = app.writeJSON(w, 200, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeResponse(w, r, 200, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -266,9 +421,10 @@ var DEFAULT_SORT = "id"
 // /v1/movies?title=godfather&genres=crime,drama&page=1&page_size=5&sort=-year
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title        string
-		Genres       []string
-		data.Filters // Embed the Filters struct type which holds fields for filtering and sorting.
+		Title         string
+		Genres        []string
+		Certification data.Certification
+		data.Filters  // Embed the Filters struct type which holds fields for filtering and sorting.
 	}
 
 	// Initialize a new Validator instance.
@@ -283,6 +439,14 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Title = app.readStrings(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// Only list movies with a particular age rating if ?certification= is given, e.g.
+	// ?certification=US:PG-13.
+	certification, err := data.ParseCertification(app.readStrings(qs, "certification", ""))
+	if err != nil {
+		v.AddError("certification", "must be a known certification, e.g. US:PG-13")
+	}
+	input.Certification = certification
+
 	// Ge the page and page_size query string value as integers. Notice that we set the default
 	// page value to 1 and default page_size to 20, and that we pass the validator instance
 	// as the final argument.
@@ -301,6 +465,20 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		"-id", "-title", "-year", "-runtime",
 	}
 
+	// Titles can be sorted using the rules of a particular language, chosen with the
+	// collation query string parameter or, failing that, the Accept-Language header. As the
+	// response then depends on Accept-Language, we tell caches about it with a Vary header.
+	input.Filters.Collation = app.readStrings(qs, "collation", "")
+	if input.Filters.Collation == "" {
+		input.Filters.Collation = negotiateCollation(r.Header.Get("Accept-Language"))
+	}
+	input.Filters.CollateColumns = []string{"title"}
+	w.Header().Add("Vary", "Accept-Language")
+
+	// When count_only=true is given we only return the number of matching movies, which is
+	// much cheaper for dashboards than fetching pages of movie records.
+	countOnly := app.readBool(qs, "count_only", false, v)
+
 	// Execute the validation checks on the Filters struct and send a response
 	// containing the errors if necessary.
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
@@ -308,16 +486,41 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if countOnly {
+		total, err := app.catalogue(r).Count(input.Title, input.Genres, input.Certification)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		headers := make(http.Header)
+		headers.Set("X-Total-Count", strconv.Itoa(total))
+
+		if err := app.writeResponse(w, r, http.StatusOK, envelope{"count": total}, headers); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	// Call the MovieModel.GetAll method to retrieve the movies,
 	// passing in the various filter parameters.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.catalogue(r).GetAll(input.Title, input.Genres, input.Certification, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// Send a 304 Not Modified response if the client already has this page.
+	if app.notModified(w, r, movieListETag(movies, metadata)) {
+		return
+	}
+
+	// Also report the total in a header, so that a HEAD request is enough to get it.
+	headers := make(http.Header)
+	headers.Set("X-Total-Count", strconv.Itoa(metadata.TotalRecords))
+
 	// Send a JSON response containing the movie data.
-	if err := app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil); err != nil {
+	if err := app.writeResponse(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, headers); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
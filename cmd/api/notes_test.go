@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/saalikmubeen/greenlight/internal/cache"
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestNotes(t *testing.T) {
+	app := newDBTestApp(t)
+
+	alice := insertTestUser(t, app, "Alice")
+	bob := insertTestUser(t, app, "Bob")
+	movieID := insertTestMovie(t, app, "Moana")
+	params := httprouter.Params{{Key: "id", Value: strconv.FormatInt(movieID, 10)}}
+	missing := httprouter.Params{{Key: "id", Value: strconv.FormatInt(movieID+1000, 10)}}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		user    *data.User
+		method  string
+		params  httprouter.Params
+		body    string
+		status  int
+		want    string
+	}{
+		{"missing note", app.showNoteHandler, alice, http.MethodGet, params, "", http.StatusNotFound, ""},
+		{"empty body", app.putNoteHandler, alice, http.MethodPut, params, `{"body": ""}`, http.StatusUnprocessableEntity, ""},
+		{"missing movie", app.putNoteHandler, alice, http.MethodPut, missing, `{"body": "Rewatch"}`, http.StatusNotFound, ""},
+		{"create", app.putNoteHandler, alice, http.MethodPut, params, `{"body": "Rewatch"}`, http.StatusOK, "Rewatch"},
+		{"replace", app.putNoteHandler, alice, http.MethodPut, params, `{"body": "Rewatch soon"}`, http.StatusOK, "Rewatch soon"},
+		{"show", app.showNoteHandler, alice, http.MethodGet, params, "", http.StatusOK, "Rewatch soon"},
+		{"another user's note", app.showNoteHandler, bob, http.MethodGet, params, "", http.StatusNotFound, ""},
+		{"delete another user's note", app.deleteNoteHandler, bob, http.MethodDelete, params, "", http.StatusNotFound, ""},
+		{"delete", app.deleteNoteHandler, alice, http.MethodDelete, params, "", http.StatusOK, ""},
+		{"deleted", app.showNoteHandler, alice, http.MethodGet, params, "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		status, body := serveAs(t, app, tt.handler, tt.user, tt.method, tt.params, tt.body)
+		if status != tt.status {
+			t.Errorf("%s: got status %d; want %d: %s", tt.name, status, tt.status, body)
+		}
+		if !strings.Contains(body, tt.want) {
+			t.Errorf("%s: got %s; want it to contain %q", tt.name, body, tt.want)
+		}
+	}
+}
+
+func TestMovieShowsOwnNote(t *testing.T) {
+	app := newDBTestApp(t)
+	app.responseCache = cache.New(cache.NewMemory(10), nil)
+	app.config.cache.movieTTL = time.Minute
+
+	alice := insertTestUser(t, app, "Alice")
+	bob := insertTestUser(t, app, "Bob")
+	carol := insertTestUser(t, app, "Carol")
+	movieID := insertTestMovie(t, app, "Moana")
+	params := httprouter.Params{{Key: "id", Value: strconv.FormatInt(movieID, 10)}}
+
+	for user, note := range map[*data.User]string{alice: "Alice's note", bob: "Bob's note"} {
+		status, body := serveAs(t, app, app.putNoteHandler, user, http.MethodPut, params, `{"body": "`+note+`"}`)
+		if status != http.StatusOK {
+			t.Fatalf("got status %d writing %s: %s", status, note, body)
+		}
+	}
+
+	// The movie is read through the response cache, as it is routed, and alice's response is
+	// cached before anyone else reads the movie.
+	showMovie := app.cacheMovie(app.showMovieHandler)
+	get := func(user *data.User, viewAs bool) string {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies/"+strconv.FormatInt(movieID, 10), nil)
+		r = withParams(r, params)
+		r = app.contextSetUser(r, user)
+		if viewAs {
+			r = app.contextSetViewAs(r)
+		}
+
+		rr := httptest.NewRecorder()
+		showMovie(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		return rr.Body.String()
+	}
+
+	tests := []struct {
+		name   string
+		user   *data.User
+		viewAs bool
+		want   string
+	}{
+		{"alice", alice, false, "Alice's note"},
+		{"bob", bob, false, "Bob's note"},
+		{"carol", carol, false, ""},
+		{"anonymous", data.AnonymousUser, false, ""},
+		{"viewing as alice", alice, true, ""},
+		{"alice again", alice, false, "Alice's note"},
+	}
+
+	for _, tt := range tests {
+		body := get(tt.user, tt.viewAs)
+
+		for _, note := range []string{"Alice's note", "Bob's note"} {
+			if got := strings.Contains(body, note); got != (note == tt.want) {
+				t.Errorf("%s: got %s; want only the note %q", tt.name, body, tt.want)
+			}
+		}
+	}
+}
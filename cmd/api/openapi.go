@@ -0,0 +1,669 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// documentedRouter wraps httprouter.Router and records every route registered on it, so that
+// the OpenAPI document can be generated from the routes that actually exist. Routes without an
+// entry in apiOperations are still listed, and TestOpenAPIRoutesDocumented fails for them, so
+// the two can't drift apart unnoticed.
+type documentedRouter struct {
+	*httprouter.Router
+	routes []apiRoute
+}
+
+// apiRoute is a method and path as registered with the router, e.g. "GET" "/v1/movies/:id".
+type apiRoute struct {
+	method string
+	path   string
+}
+
+// HandlerFunc registers and documents a route. The handler records the route pattern for the
+// metrics by route, see recordRoute.
+func (dr *documentedRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	dr.document(method, path)
+	dr.Router.HandlerFunc(method, path, recordRoute(path, handler))
+}
+
+// Handler registers and documents a route like HandlerFunc.
+func (dr *documentedRouter) Handler(method, path string, handler http.Handler) {
+	dr.document(method, path)
+	dr.Router.Handler(method, path, recordRoute(path, handler))
+}
+
+// document records a route without registering a handler for it. It's used for the static
+// paths which are served through dispatchIDParam(), such as "/v1/movies/batch".
+func (dr *documentedRouter) document(method, path string) {
+	dr.routes = append(dr.routes, apiRoute{method: method, path: path})
+}
+
+// apiOperation describes a single route for the OpenAPI document.
+type apiOperation struct {
+	summary    string
+	permission string            // permission code required, "" if none
+	auth       bool              // whether an authenticated (bearer token) user is required
+	request    string            // name of the request body schema, "" if there is no body
+	status     int               // status code of a successful response
+	response   map[string]string // envelope key -> schema name of a successful response
+	query      []string          // supported query string parameters
+	stream     bool              // whether a successful response is a text/event-stream
+}
+
+// apiOperations documents every route, keyed by method and path exactly as in routes.go.
+var apiOperations = map[apiRoute]apiOperation{
+	{http.MethodGet, "/v1/healthcheck"}: {
+		summary: "Report the status and version of the API, the schema migration status and, with -smtp-check, the SMTP server status", status: http.StatusOK,
+		response: map[string]string{"status": "String", "system_info": "Object", "schema": "SchemaStatus", "smtp": "SMTPStatus"},
+	},
+	{http.MethodGet, "/debug/vars"}: {
+		summary: "Expose runtime metrics in expvar format", permission: "admin:read", status: http.StatusOK,
+	},
+	{http.MethodGet, "/debug/metrics"}: {
+		summary: "Expose the request counts and latency histograms, overall and by route, in Prometheus format", permission: "admin:read", status: http.StatusOK,
+	},
+	{http.MethodGet, "/v1/openapi.json"}: {
+		summary: "Return this OpenAPI document", status: http.StatusOK,
+	},
+	{http.MethodGet, "/v1/docs"}: {
+		summary: "Interactive API documentation (not served in production)", status: http.StatusOK,
+	},
+	{http.MethodGet, "/v1/stats/public"}: {
+		summary: "Return high-level catalogue statistics", status: http.StatusOK,
+		response: map[string]string{"stats": "CatalogueStats"},
+	},
+	{http.MethodGet, "/v1/movies"}: {
+		summary: "List movies", permission: "movies:read", status: http.StatusOK,
+		response: map[string]string{"movies": "[]Movie", "metadata": "Metadata"},
+		query:    []string{"title", "genres", "certification", "page", "page_size", "sort", "collation", "count_only"},
+	},
+	{http.MethodPost, "/v1/movies"}: {
+		summary: "Create a movie", permission: permissionMoviesWriteOwn, request: "MovieInput",
+		status: http.StatusCreated, response: map[string]string{"movie": "Movie", "_links": "Links"},
+	},
+	{http.MethodGet, "/v1/movies/:id"}: {
+		summary: "Show a movie", permission: "movies:read", status: http.StatusOK,
+		response: map[string]string{"movie": "Movie", "note": "Note", "_links": "Links"},
+	},
+	{http.MethodGet, "/v1/movies/events"}: {
+		summary:    "Stream movie changes as Server-Sent Events, resuming after the Last-Event-ID header",
+		permission: "movies:read", status: http.StatusOK, stream: true,
+	},
+	{http.MethodPatch, "/v1/movies/:id"}: {
+		summary:    "Update a movie with a partial movie, JSON Patch or JSON Merge Patch document, which users without movies:write:any must have created",
+		permission: permissionMoviesWriteOwn, request: "MovieInput", status: http.StatusOK,
+		response: map[string]string{"movie": "Movie", "_links": "Links"},
+	},
+	{http.MethodDelete, "/v1/movies/:id"}: {
+		summary: "Delete a movie, which users without movies:write:any must have created", permission: permissionMoviesWriteOwn, status: http.StatusOK,
+		response: map[string]string{"message": "String"},
+	},
+	{http.MethodPatch, "/v1/movies/batch"}: {
+		summary: "Update many movies in one transaction", permission: permissionMoviesWriteOwn,
+		request: "BatchUpdate", status: http.StatusOK, response: map[string]string{"committed": "Boolean", "results": "[]BatchResult"},
+	},
+	{http.MethodDelete, "/v1/movies/batch"}: {
+		summary: "Delete many movies in one transaction", permission: permissionMoviesWriteOwn,
+		request: "BatchDelete", status: http.StatusOK, response: map[string]string{"committed": "Boolean", "results": "[]BatchResult"},
+	},
+	{http.MethodPost, "/v1/movies/bulk-delete"}: {
+		summary: "Preview or start deleting every movie matching a filter", permission: permissionMoviesWriteAny,
+		request: "BulkDelete", status: http.StatusAccepted, response: map[string]string{"operation": "BulkOperation"},
+	},
+	{http.MethodGet, "/v1/bulk-operations/:id"}: {
+		summary: "Show the progress of a bulk operation", permission: permissionMoviesWriteAny, status: http.StatusOK,
+		response: map[string]string{"operation": "BulkOperation"},
+	},
+	{http.MethodGet, "/v1/movies/:id/comments"}: {
+		summary: "List the comment threads of a movie", permission: "movies:read", status: http.StatusOK,
+		response: map[string]string{"comments": "[]Comment", "metadata": "Metadata"},
+		query:    []string{"page", "page_size", "sort"},
+	},
+	{http.MethodPost, "/v1/movies/:id/comments"}: {
+		summary: "Comment on a movie or reply to a comment", auth: true, request: "CommentInput",
+		status: http.StatusCreated, response: map[string]string{"comment": "Comment"},
+	},
+	{http.MethodPatch, "/v1/movies/:id/comments/:comment_id"}: {
+		summary: "Edit or moderate a comment", auth: true, request: "CommentInput",
+		status: http.StatusOK, response: map[string]string{"comment": "Comment"},
+	},
+	{http.MethodDelete, "/v1/movies/:id/comments/:comment_id"}: {
+		summary: "Delete a comment, and its replies when moderating", auth: true, status: http.StatusOK,
+		response: map[string]string{"message": "String"},
+	},
+	{http.MethodGet, "/v1/movies/:id/note"}: {
+		summary: "Show your private note on a movie", permission: "movies:read", status: http.StatusOK,
+		response: map[string]string{"note": "Note"},
+	},
+	{http.MethodPut, "/v1/movies/:id/note"}: {
+		summary: "Create or replace your private note on a movie", permission: "movies:read",
+		request: "NoteInput", status: http.StatusOK, response: map[string]string{"note": "Note"},
+	},
+	{http.MethodDelete, "/v1/movies/:id/note"}: {
+		summary: "Delete your private note on a movie", permission: "movies:read", status: http.StatusOK,
+		response: map[string]string{"message": "String"},
+	},
+	{http.MethodGet, "/v1/lists"}: {
+		summary: "List the movie lists you own or collaborate on", auth: true, status: http.StatusOK,
+		response: map[string]string{"lists": "[]MovieList"},
+	},
+	{http.MethodPost, "/v1/lists"}: {
+		summary: "Create a movie list", auth: true, request: "MovieListInput",
+		status: http.StatusCreated, response: map[string]string{"list": "MovieList"},
+	},
+	{http.MethodGet, "/v1/lists/:slug"}: {
+		summary: "Show a movie list and its movies", status: http.StatusOK,
+		response: map[string]string{"list": "MovieList"},
+	},
+	{http.MethodPatch, "/v1/lists/:slug"}: {
+		summary: "Rename a movie list or change its visibility", auth: true, request: "MovieListInput",
+		status: http.StatusOK, response: map[string]string{"list": "MovieList"},
+	},
+	{http.MethodDelete, "/v1/lists/:slug"}: {
+		summary: "Delete a movie list", auth: true, status: http.StatusOK,
+		response: map[string]string{"message": "String"},
+	},
+	{http.MethodPut, "/v1/lists/:slug/movies/:movie_id"}: {
+		summary: "Add a movie to a list", auth: true, status: http.StatusOK,
+		response: map[string]string{"message": "String"},
+	},
+	{http.MethodDelete, "/v1/lists/:slug/movies/:movie_id"}: {
+		summary: "Remove a movie from a list", auth: true, status: http.StatusOK,
+		response: map[string]string{"message": "String"},
+	},
+	{http.MethodGet, "/v1/lists/:slug/collaborators"}: {
+		summary: "List the collaborators and pending invitations of a list", auth: true,
+		status: http.StatusOK, response: map[string]string{"collaborators": "[]ListCollaborator"},
+	},
+	{http.MethodPost, "/v1/lists/:slug/collaborators"}: {
+		summary: "Invite a user to edit a list", auth: true, request: "EmailInput",
+		status: http.StatusCreated, response: map[string]string{"collaborator": "ListCollaborator"},
+	},
+	{http.MethodDelete, "/v1/lists/:slug/collaborators/:user_id"}: {
+		summary: "Remove a collaborator, withdraw an invitation or leave a list", auth: true,
+		status: http.StatusOK, response: map[string]string{"message": "String"},
+	},
+	{http.MethodPut, "/v1/lists/:slug/invitation"}: {
+		summary: "Accept an invitation to edit a list", auth: true, status: http.StatusOK,
+		response: map[string]string{"list": "MovieList"},
+	},
+	{http.MethodGet, "/v1/users/:id/lists"}: {
+		summary: "List the public movie lists of a user", status: http.StatusOK,
+		response: map[string]string{"lists": "[]MovieList"},
+	},
+	{http.MethodGet, "/v1/orgs"}: {
+		summary: "List the organizations you are a member of", auth: true, status: http.StatusOK,
+		response: map[string]string{"organizations": "[]Organization"},
+	},
+	{http.MethodPost, "/v1/orgs"}: {
+		summary: "Create an organization, which you become the owner of", auth: true,
+		request: "OrganizationInput", status: http.StatusCreated,
+		response: map[string]string{"organization": "Organization"},
+	},
+	{http.MethodGet, "/v1/orgs/:slug/members"}: {
+		summary: "List the members of an organization", auth: true, status: http.StatusOK,
+		response: map[string]string{"members": "[]OrgMember"},
+	},
+	{http.MethodPut, "/v1/orgs/:slug/members"}: {
+		summary: "Add a member to an organization or change their role", auth: true,
+		request: "OrgMemberInput", status: http.StatusOK, response: map[string]string{"member": "OrgMember"},
+	},
+	{http.MethodDelete, "/v1/orgs/:slug/members/:user_id"}: {
+		summary: "Remove a member from an organization or leave it", auth: true,
+		status: http.StatusOK, response: map[string]string{"message": "String"},
+	},
+	{http.MethodGet, "/v1/orgs/:slug/invitations"}: {
+		summary: "List the pending invitations to join an organization", auth: true, status: http.StatusOK,
+		response: map[string]string{"invitations": "[]OrgInvitation"},
+	},
+	{http.MethodPost, "/v1/orgs/:slug/invitations"}: {
+		summary: "Email an invitation to join an organization", auth: true, request: "OrgMemberInput",
+		status: http.StatusCreated, response: map[string]string{"invitation": "OrgInvitation"},
+	},
+	{http.MethodDelete, "/v1/orgs/:slug/invitations/:id"}: {
+		summary: "Revoke an invitation to join an organization", auth: true, status: http.StatusOK,
+		response: map[string]string{"message": "String"},
+	},
+	{http.MethodPut, "/v1/orgs/:slug/invitation"}: {
+		summary: "Accept an invitation to join an organization", auth: true, request: "TokenInput",
+		status: http.StatusOK, response: map[string]string{"organization": "Organization"},
+	},
+	{http.MethodGet, "/v1/users/me/usage"}: {
+		summary: "Show the requests made this month and the monthly quota", auth: true, status: http.StatusOK,
+		response: map[string]string{"usage": "Usage"},
+	},
+	{http.MethodGet, "/v1/users/me/security-events"}: {
+		summary: "List the logins, activations and password resets of the current user, newest first", auth: true,
+		status: http.StatusOK, response: map[string]string{"events": "[]AuthEvent", "metadata": "Metadata"},
+		query: []string{"type", "since", "until", "page", "page_size", "sort"},
+	},
+	{http.MethodPost, "/v1/users"}: {
+		summary: "Register a new user", request: "UserInput", status: http.StatusAccepted,
+		response: map[string]string{"user": "User", "_links": "Links"},
+	},
+	{http.MethodPut, "/v1/users/activated"}: {
+		summary: "Activate a user account", request: "TokenInput", status: http.StatusOK,
+		response: map[string]string{"user": "User", "_links": "Links"},
+	},
+	{http.MethodPut, "/v1/users/password"}: {
+		summary: "Reset a password with a password reset token", request: "PasswordResetInput",
+		status: http.StatusOK, response: map[string]string{"message": "String"},
+	},
+	{http.MethodPost, "/v1/tokens/activation"}: {
+		summary: "Send a new activation token", request: "EmailInput", status: http.StatusAccepted,
+		response: map[string]string{"message": "String"},
+	},
+	{http.MethodPost, "/v1/tokens/trial"}: {
+		summary: "Issue an anonymous, read-only trial token", status: http.StatusCreated,
+		response: map[string]string{"trial_token": "Token", "permissions": "[]String"},
+	},
+	{http.MethodPost, "/v1/tokens/authentication"}: {
+		summary: "Log in and issue an authentication token", request: "CredentialsInput",
+		status: http.StatusCreated, response: map[string]string{"authentication_token": "Token"},
+	},
+	{http.MethodPost, "/v1/tokens/password-reset"}: {
+		summary: "Send a password reset token", request: "EmailInput", status: http.StatusAccepted,
+		response: map[string]string{"message": "String"},
+	},
+	{http.MethodGet, "/v1/admin/inflight"}: {
+		summary: "List the requests currently being handled", permission: "admin:read", status: http.StatusOK,
+		response: map[string]string{"requests": "[]Object", "count": "Integer"},
+	},
+	{http.MethodGet, "/v1/admin/metrics"}: {
+		summary:    "Show a snapshot of the request counters with the in-flight requests, request rate and average and percentile processing times",
+		permission: "admin:read", status: http.StatusOK, response: map[string]string{"metrics": "Object"},
+	},
+	{http.MethodDelete, "/v1/admin/metrics"}: {
+		summary: "Reset the request counters and return the snapshot taken just before", permission: "admin:write",
+		status: http.StatusOK, response: map[string]string{"metrics": "Object"},
+	},
+	{http.MethodGet, "/v1/admin/idp-sync"}: {
+		summary: "Show the report of the latest identity provider sync", permission: "admin:read",
+		status: http.StatusOK, response: map[string]string{"report": "Object"},
+	},
+	{http.MethodPost, "/v1/admin/diagnostics"}: {
+		summary: "Write a diagnostic bundle to the blob store", permission: "admin:read",
+		status: http.StatusCreated, response: map[string]string{"diagnostics": "Object"},
+	},
+	{http.MethodGet, "/v1/admin/audit-log"}: {
+		summary: "List the audit log of changes, newest first", permission: "admin:read", status: http.StatusOK,
+		response: map[string]string{"entries": "[]AuditEntry", "metadata": "Metadata"},
+		query:    []string{"actor_id", "method", "entity", "entity_id", "since", "until", "page", "page_size", "sort"},
+	},
+	{http.MethodGet, "/v1/admin/auth-events"}: {
+		summary:    "List the logins, token issuances, activations and password resets of every user, newest first",
+		permission: "admin:read", status: http.StatusOK,
+		response: map[string]string{"events": "[]AuthEvent", "metadata": "Metadata"},
+		query:    []string{"user_id", "email", "type", "ip", "failed", "since", "until", "page", "page_size", "sort"},
+	},
+	{http.MethodGet, "/v1/admin/emails/dead-letters"}: {
+		summary: "List the emails which couldn't be sent, newest first", permission: "admin:read", status: http.StatusOK,
+		response: map[string]string{"dead_letters": "[]DeadLetter"},
+		query:    []string{"requeued", "limit"},
+	},
+	{http.MethodPost, "/v1/admin/emails/dead-letters/:id/requeue"}: {
+		summary: "Queue the email of a dead letter to be sent again", permission: "admin:write", status: http.StatusAccepted,
+		response: map[string]string{"dead_letter": "DeadLetter", "outbox_id": "Integer"},
+	},
+	{http.MethodGet, "/v1/webhooks"}: {
+		summary: "List your webhook subscriptions", permission: "webhooks:write", status: http.StatusOK,
+		response: map[string]string{"subscriptions": "[]WebhookSubscription"},
+	},
+	{http.MethodPost, "/v1/webhooks"}: {
+		summary: "Subscribe a URL to events; the signing secret is only returned here", permission: "webhooks:write",
+		request: "WebhookSubscriptionInput", status: http.StatusCreated,
+		response: map[string]string{"subscription": "WebhookSubscription", "secret": "String"},
+	},
+	{http.MethodDelete, "/v1/webhooks/:id"}: {
+		summary: "Delete a webhook subscription", permission: "webhooks:write", status: http.StatusOK,
+		response: map[string]string{"message": "String"},
+	},
+	{http.MethodGet, "/v1/webhooks/:id/deliveries"}: {
+		summary: "List the latest deliveries to a webhook subscription", permission: "webhooks:write", status: http.StatusOK,
+		response: map[string]string{"deliveries": "[]WebhookDelivery"},
+		query:    []string{"limit"},
+	},
+	{http.MethodPost, "/v1/integrations/:provider/webhook"}: {
+		summary: "Receive a signed webhook event from an integration", request: "WebhookEvent",
+		status: http.StatusAccepted, response: map[string]string{"message": "String"},
+	},
+}
+
+// apiSchemas are the reusable schemas referred to by name from apiOperations.
+var apiSchemas = map[string]interface{}{
+	"Movie": object(map[string]interface{}{
+		"id": integer(), "title": str(), "year": integer(), "runtime": strExample("102 mins"),
+		"genres": array(str()), "certification": strExample("US:PG-13"), "version": integer(),
+	}),
+	"MovieInput": object(map[string]interface{}{
+		"title": str(), "year": integer(), "runtime": strExample("102 mins"),
+		"genres": array(str()), "certification": strExample("US:PG-13"),
+	}),
+	"CatalogueStats": object(map[string]interface{}{
+		"total_movies": integer(), "total_genres": integer(), "newest_addition": str(),
+		"popular": array(ref("PopularMovie")),
+	}),
+	"PopularMovie": object(map[string]interface{}{
+		"id": integer(), "title": str(), "score": integer(),
+	}),
+	// Links is the "_links" block of a resource, mapping relation names to links.
+	"Links": map[string]interface{}{
+		"type": "object",
+		"additionalProperties": object(map[string]interface{}{
+			"href": strExample("/v1/movies/1"), "method": strExample("GET"),
+		}),
+	},
+	"Metadata": object(map[string]interface{}{
+		"current_page": integer(), "page_size": integer(), "first_page": integer(),
+		"last_page": integer(), "total_records": integer(),
+	}),
+	"BatchUpdate": object(map[string]interface{}{
+		"movies": array(ref("MovieInput")),
+	}),
+	"BatchDelete": object(map[string]interface{}{
+		"ids": array(integer()),
+	}),
+	"BatchResult": object(map[string]interface{}{
+		"id": integer(), "status": str(), "error": map[string]interface{}{}, "movie": ref("Movie"),
+	}),
+	"BulkDelete": object(map[string]interface{}{
+		"title": str(), "genres": array(str()), "dry_run": boolean(), "confirmation_token": str(),
+	}),
+	"BulkOperation": object(map[string]interface{}{
+		"id": integer(), "kind": str(), "status": str(), "total": integer(), "processed": integer(),
+		"error": str(), "filters": map[string]interface{}{"type": "object"},
+	}),
+	"Comment": object(map[string]interface{}{
+		"id": integer(), "movie_id": integer(), "user_id": integer(), "parent_id": integer(),
+		"body": str(), "hidden": boolean(), "deleted": boolean(), "version": integer(),
+		"replies": array(ref("Comment")),
+	}),
+	"CommentInput": object(map[string]interface{}{
+		"body": str(), "parent_id": integer(), "hidden": boolean(),
+	}),
+	"Note": object(map[string]interface{}{
+		"movie_id": integer(), "body": str(),
+	}),
+	"NoteInput": object(map[string]interface{}{
+		"body": str(),
+	}),
+	"MovieList": object(map[string]interface{}{
+		"id": integer(), "owner_id": integer(), "slug": str(), "name": str(), "description": str(),
+		"visibility": strExample("unlisted"), "version": integer(), "movies": array(ref("Movie")),
+	}),
+	"MovieListInput": object(map[string]interface{}{
+		"name": str(), "description": str(), "visibility": strExample("unlisted"),
+	}),
+	"ListCollaborator": object(map[string]interface{}{
+		"user_id": integer(), "name": str(), "invited_at": str(), "accepted_at": str(),
+	}),
+	"Organization": object(map[string]interface{}{
+		"id": integer(), "created_at": str(), "slug": strExample("my-team"), "name": str(),
+		"version": integer(), "role": strExample("owner"),
+	}),
+	"OrganizationInput": object(map[string]interface{}{
+		"slug": strExample("my-team"), "name": str(),
+	}),
+	"OrgMember": object(map[string]interface{}{
+		"user_id": integer(), "name": str(), "role": strExample("member"), "created_at": str(),
+	}),
+	"OrgMemberInput": object(map[string]interface{}{
+		"email": str(), "role": strExample("member"),
+	}),
+	"OrgInvitation": object(map[string]interface{}{
+		"id": integer(), "email": str(), "role": strExample("member"), "invited_by": integer(),
+		"created_at": str(), "expiry": str(),
+	}),
+	"User": object(map[string]interface{}{
+		"id": integer(), "name": str(), "email": str(), "activated": boolean(),
+	}),
+	"UserInput": object(map[string]interface{}{
+		"name": str(), "email": str(), "password": str(),
+	}),
+	"Token": object(map[string]interface{}{
+		"token": str(), "expiry": str(),
+	}),
+	"TokenInput": object(map[string]interface{}{
+		"token": str(),
+	}),
+	"EmailInput": object(map[string]interface{}{
+		"email": str(),
+	}),
+	"CredentialsInput": object(map[string]interface{}{
+		"email": str(), "password": str(),
+	}),
+	"PasswordResetInput": object(map[string]interface{}{
+		"password": str(), "token": str(),
+	}),
+	"WebhookEvent": object(map[string]interface{}{
+		"id": str(), "type": str(), "data": map[string]interface{}{"type": "object"},
+	}),
+	"WebhookSubscription": object(map[string]interface{}{
+		"id": integer(), "created_at": str(), "user_id": integer(), "url": str(), "events": array(str()),
+	}),
+	"WebhookSubscriptionInput": object(map[string]interface{}{
+		"url": str(), "events": array(strExample("movie.created")),
+	}),
+	"WebhookDelivery": object(map[string]interface{}{
+		"id": integer(), "created_at": str(), "subscription_id": integer(), "event_id": str(),
+		"event_type": str(), "payload": map[string]interface{}{"type": "object"}, "status": strExample("delivered"),
+		"attempts": integer(), "next_attempt_at": str(), "response_status": integer(), "error": str(),
+		"delivered_at": str(),
+	}),
+	"DeadLetter": object(map[string]interface{}{
+		"id": integer(), "created_at": str(), "outbox_id": integer(), "recipient": str(),
+		"template": strExample("user_welcome.tmpl"), "attempts": integer(), "error": str(), "requeued_at": str(),
+	}),
+	"AuditEntry": object(map[string]interface{}{
+		"id": integer(), "created_at": str(), "actor_id": integer(), "method": strExample("PATCH"),
+		"route": strExample("/v1/movies/:id"), "path": strExample("/v1/movies/1"), "status": integer(),
+		"entity": strExample("movies"), "entity_id": strExample("1"),
+		"before": map[string]interface{}{"type": "object"}, "after": map[string]interface{}{"type": "object"},
+		"ip": str(),
+	}),
+	"AuthEvent": object(map[string]interface{}{
+		"id": integer(), "created_at": str(), "user_id": integer(), "email": str(), "type": strExample("login"),
+		"success": boolean(), "reason": strExample("wrong_password"), "ip": str(), "user_agent": str(),
+	}),
+	"Usage": object(map[string]interface{}{
+		"month": strExample("2024-01"), "requests": integer(), "limit": integer(), "remaining": integer(),
+		"resets_at": str(),
+	}),
+	"SchemaStatus": object(map[string]interface{}{
+		"version": integer(), "latest": integer(), "dirty": boolean(), "pending": boolean(),
+	}),
+	"SMTPStatus": object(map[string]interface{}{
+		"status": strExample("available"), "checked_at": str(),
+	}),
+	// Every error response uses the same envelope. "error" is a message for most errors, and
+	// an object mapping each invalid field to a message for validation errors.
+	"Error": object(map[string]interface{}{
+		"error": str(),
+	}),
+	"ValidationError": object(map[string]interface{}{
+		"error": map[string]interface{}{"type": "object", "additionalProperties": str()},
+	}),
+}
+
+func object(properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func array(items interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": items}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func str() map[string]interface{}     { return map[string]interface{}{"type": "string"} }
+func integer() map[string]interface{} { return map[string]interface{}{"type": "integer"} }
+func boolean() map[string]interface{} { return map[string]interface{}{"type": "boolean"} }
+
+func strExample(example string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "example": example}
+}
+
+// schemaFor turns a schema name used in apiOperations into a schema: "[]X" is an array of X,
+// the scalar names are inlined and anything else refers to a component schema.
+func schemaFor(name string) interface{} {
+	if strings.HasPrefix(name, "[]") {
+		return array(schemaFor(strings.TrimPrefix(name, "[]")))
+	}
+
+	switch name {
+	case "String":
+		return str()
+	case "Integer":
+		return integer()
+	case "Boolean":
+		return boolean()
+	case "Object":
+		return map[string]interface{}{"type": "object"}
+	default:
+		return ref(name)
+	}
+}
+
+var pathParamRX = regexp.MustCompile(`:([a-z_]+)`)
+
+// openAPIDocument builds the OpenAPI 3 document for the given routes.
+func openAPIDocument(routes []apiRoute) map[string]interface{} {
+	errorResponse := func(description, schema string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": ref(schema)},
+			},
+		}
+	}
+
+	paths := map[string]map[string]interface{}{}
+
+	sorted := append([]apiRoute(nil), routes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].path != sorted[j].path {
+			return sorted[i].path < sorted[j].path
+		}
+		return sorted[i].method < sorted[j].method
+	})
+
+	for _, route := range sorted {
+		op, ok := apiOperations[route]
+		if !ok {
+			op = apiOperation{summary: "Undocumented", status: http.StatusOK}
+		}
+
+		path := pathParamRX.ReplaceAllString(route.path, "{$1}")
+
+		var params []interface{}
+		for _, match := range pathParamRX.FindAllStringSubmatch(route.path, -1) {
+			params = append(params, map[string]interface{}{
+				"name": match[1], "in": "path", "required": true, "schema": str(),
+			})
+		}
+		for _, name := range op.query {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "query", "schema": str(),
+			})
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(op.status)}
+		if len(op.response) > 0 {
+			properties := map[string]interface{}{}
+			for key, schema := range op.response {
+				properties[key] = schemaFor(schema)
+			}
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": object(properties)},
+			}
+		}
+		if op.stream {
+			success["content"] = map[string]interface{}{
+				"text/event-stream": map[string]interface{}{"schema": str()},
+			}
+		}
+
+		responses := map[string]interface{}{
+			strconv.Itoa(op.status): success,
+			"429":                   errorResponse("Rate limit exceeded", "Error"),
+			"500":                   errorResponse("Internal server error", "Error"),
+		}
+
+		operation := map[string]interface{}{
+			"summary":     op.summary,
+			"operationId": strings.ToLower(route.method) + pathParamRX.ReplaceAllString(strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(route.path), "$1"),
+			"responses":   responses,
+		}
+
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		if len(params) > len(op.query) {
+			responses["404"] = errorResponse("Not found", "Error")
+		}
+
+		if op.request != "" {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": ref(op.request)},
+				},
+			}
+			responses["400"] = errorResponse("Badly-formed request body", "Error")
+			responses["413"] = errorResponse("Request body too large", "Error")
+			responses["415"] = errorResponse("Unsupported Content-Type or charset", "Error")
+			responses["422"] = errorResponse("Failed validation, or too many array items or object keys", "ValidationError")
+		}
+
+		if op.auth || op.permission != "" {
+			operation["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+			responses["401"] = errorResponse("Missing or invalid authentication token", "Error")
+			responses["403"] = errorResponse("Inactive account or missing permission", "Error")
+			if op.permission != "" {
+				operation["x-required-permission"] = op.permission
+			}
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(route.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Greenlight API",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": apiSchemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Authentication or trial token from the /v1/tokens endpoints",
+				},
+			},
+		},
+	}
+}
+
+// openAPIHandler handles "GET /v1/openapi.json" and returns the OpenAPI document for the
+// routes registered in routes().
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, openAPIDocument(app.apiRoutes), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+// TestOpenAPIRoutesDocumented checks that apiOperations documents exactly the routes that
+// routes() registers, so the OpenAPI document can't drift from routes.go.
+func TestOpenAPIRoutesDocumented(t *testing.T) {
+	app := newTestApp()
+
+	registered := make(map[apiRoute]bool)
+	for _, route := range app.registerRoutes().routes {
+		registered[route] = true
+	}
+
+	for route := range registered {
+		if _, ok := apiOperations[route]; !ok {
+			t.Errorf("route %s %s is missing from apiOperations", route.method, route.path)
+		}
+	}
+
+	for route := range apiOperations {
+		if !registered[route] {
+			t.Errorf("apiOperations documents %s %s, which is not registered", route.method, route.path)
+		}
+	}
+}
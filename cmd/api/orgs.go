@@ -0,0 +1,633 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// orgHeader is the request header naming, by its slug, the organization whose catalogue the
+// request works with. Requests without it work with the shared catalogue.
+const orgHeader = "X-Organization"
+
+// orgPathPrefix is the path prefix naming the organization in the URL instead, as in
+// /orgs/my-team/v1/movies, for the clients which can't set headers, such as links and feeds.
+const orgPathPrefix = "/orgs/"
+
+// orgInvitationTTL is how long the invitations to join an organization can be accepted for.
+const orgInvitationTTL = 7 * 24 * time.Hour
+
+// orgPath lets the organization be named by a path prefix: it strips /orgs/<slug> from the
+// requests for /orgs/<slug>/v1/... and sets the X-Organization header to the slug instead, so
+// that the router and resolveOrg only have to deal with the header. It must wrap the whole
+// chain, as the other middleware expects the paths the router sees.
+func (app *application) orgPath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug, path, ok := splitOrgPath(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if header := r.Header.Get(orgHeader); header != "" && header != slug {
+			app.errorResponse(w, r, http.StatusBadRequest, "the X-Organization header doesn't match the organization in the path")
+			return
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = path
+		r2.URL.RawPath = ""
+		r2.RequestURI = r2.URL.RequestURI()
+		r2.Header.Set(orgHeader, slug)
+
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// splitOrgPath splits a path of the form /orgs/<slug>/v1/... into the slug and the API path
+// /v1/.... ok is false for the other paths.
+func splitOrgPath(path string) (slug, rest string, ok bool) {
+	trimmed, found := strings.CutPrefix(path, orgPathPrefix)
+	if !found {
+		return "", "", false
+	}
+
+	slug, rest, found = strings.Cut(trimmed, "/")
+	if !found || slug == "" || !strings.HasPrefix(rest, "v1/") {
+		return "", "", false
+	}
+
+	return slug, "/" + rest, true
+}
+
+// resolveOrg looks up the organization named by the X-Organization header and adds it, with the
+// role the user has in it, to the request context, see contextGetOrg. Only the members of an
+// organization can work with it; for everyone else it doesn't exist, so that the organizations
+// can't be discovered. It must come after authenticate and viewAs.
+func (app *application) resolveOrg(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", orgHeader)
+
+		slug := r.Header.Get(orgHeader)
+		if slug == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() || user.IsTrial() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		org, err := app.models.Organizations.GetBySlug(slug)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.orgNotFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		org.Role, err = app.models.Organizations.Role(org.ID, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if org.Role == "" {
+			app.orgNotFoundResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetOrg(r, org))
+	})
+}
+
+// catalogue returns the movies of the organization the request was made in, or of the shared
+// catalogue if there is none. The handlers must use it rather than app.models.Movies, so that
+// the organizations only ever see their own movies.
+func (app *application) catalogue(r *http.Request) data.MovieModel {
+	return app.models.Movies.InOrg(app.orgID(r))
+}
+
+// orgID returns the ID of the organization the request was made in, or 0 for the shared
+// catalogue.
+func (app *application) orgID(r *http.Request) int64 {
+	if org := app.contextGetOrg(r); org != nil {
+		return org.ID
+	}
+	return 0
+}
+
+// listOrganizationsHandler handles "GET /v1/orgs" and returns the organizations the
+// authenticated user is a member of, with their role.
+func (app *application) listOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	orgs, err := app.models.Organizations.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"organizations": orgs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createOrganizationHandler handles "POST /v1/orgs". The user creating the organization becomes
+// its owner.
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Slug string `json:"slug"`
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	org := &data.Organization{
+		Slug: input.Slug,
+		Name: input.Name,
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.Insert(org, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateOrgSlug):
+			v.AddError("slug", "an organization with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/orgs/%s/members", org.Slug))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"organization": org}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOrganizationMembersHandler handles "GET /v1/orgs/:slug/members" and returns the members
+// of the organization to its members.
+func (app *application) listOrganizationMembersHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.readOrganizationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := app.models.Organizations.GetMembers(org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setOrganizationMemberHandler handles "PUT /v1/orgs/:slug/members", which adds the user with
+// the given email address to the organization, or changes the role of a member. Owners and
+// admins manage the members, but only owners can make others owners or admins, or change the
+// role of one, and the last owner can't step down.
+func (app *application) setOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.readOrganizationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if org.Role != data.OrgRoleOwner && org.Role != data.OrgRoleAdmin {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = data.OrgRoleMember
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(data.ValidOrgRole(input.Role), "role", "must be owner, admin or member")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	current, err := app.models.Organizations.Role(org.ID, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if org.Role != data.OrgRoleOwner && (input.Role != data.OrgRoleMember || current == data.OrgRoleOwner || current == data.OrgRoleAdmin) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if current == data.OrgRoleOwner && input.Role != data.OrgRoleOwner {
+		if ok := app.checkNotLastOwner(w, r, org); !ok {
+			return
+		}
+	}
+
+	member, err := app.models.Organizations.SetMember(org.ID, user.ID, input.Role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	member.Name = user.Name
+
+	status := http.StatusOK
+	if current == "" {
+		status = http.StatusCreated
+	}
+
+	err = app.writeResponse(w, r, status, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeOrganizationMemberHandler handles "DELETE /v1/orgs/:slug/members/:user_id". Owners and
+// admins can remove the members, only owners can remove the owners and admins, and users can
+// remove themselves to leave the organization, unless they are its last owner.
+func (app *application) removeOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.readOrganizationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	role, err := app.models.Organizations.Role(org.ID, userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if role == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if userID != app.contextGetUser(r).ID {
+		switch org.Role {
+		case data.OrgRoleOwner:
+		case data.OrgRoleAdmin:
+			if role != data.OrgRoleMember {
+				app.notPermittedResponse(w, r)
+				return
+			}
+		default:
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	if role == data.OrgRoleOwner {
+		if ok := app.checkNotLastOwner(w, r, org); !ok {
+			return
+		}
+	}
+
+	err = app.models.Organizations.RemoveMember(org.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "member successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOrganizationInvitationsHandler handles "GET /v1/orgs/:slug/invitations" and returns the
+// pending invitations of the organization to its owners and admins.
+func (app *application) listOrganizationInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.readOrganizationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if org.Role != data.OrgRoleOwner && org.Role != data.OrgRoleAdmin {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	invitations, err := app.models.Organizations.GetInvitations(org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"invitations": invitations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createOrganizationInvitationHandler handles "POST /v1/orgs/:slug/invitations", which emails
+// an invitation to join the organization to an email address, whether or not it already has a
+// user account. Owners and admins invite members, and only owners can invite owners and admins.
+func (app *application) createOrganizationInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.readOrganizationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if org.Role != data.OrgRoleOwner && org.Role != data.OrgRoleAdmin {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = data.OrgRoleMember
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(data.ValidOrgRole(input.Role), "role", "must be owner, admin or member")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if org.Role != data.OrgRoleOwner && input.Role != data.OrgRoleMember {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	invitee, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		role, err := app.models.Organizations.Role(org.ID, invitee.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if role != "" {
+			v.AddError("email", "this user is already a member of the organization")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	inviter := app.contextGetUser(r)
+
+	invitation := &data.OrgInvitation{
+		OrgID:     org.ID,
+		Email:     input.Email,
+		Role:      input.Role,
+		InvitedBy: inviter.ID,
+	}
+
+	token, err := app.models.Organizations.Invite(invitation, orgInvitationTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateOrgInvitation):
+			v.AddError("email", "this email address has already been invited, revoke the invitation to send a new one")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		templateData := map[string]interface{}{
+			"inviterName":     inviter.Name,
+			"orgName":         org.Name,
+			"orgSlug":         org.Slug,
+			"role":            invitation.Role,
+			"invitationToken": token.Plaintext,
+		}
+
+		err := app.sendEmail(invitation.Email, "org_invitation.tmpl", templateData)
+		if err != nil {
+			letter, letterErr := data.NewDeadLetter(invitation.Email, "org_invitation.tmpl", templateData, 1, err)
+			if letterErr != nil {
+				app.logger.PrintError(err, nil)
+				return
+			}
+			app.recordDeadLetter(letter)
+		}
+	})
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeOrganizationInvitationHandler handles "DELETE /v1/orgs/:slug/invitations/:id". Owners
+// and admins can revoke the pending invitations, after which their token doesn't accept them.
+func (app *application) revokeOrganizationInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.readOrganizationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if org.Role != data.OrgRoleOwner && org.Role != data.OrgRoleAdmin {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Organizations.RevokeInvitation(org.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "invitation successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// acceptOrganizationInvitationHandler handles "PUT /v1/orgs/:slug/invitation", which the
+// invited user sends with the token from the invitation email to join the organization. The
+// user must be signed in with the email address the invitation was sent to.
+func (app *application) acceptOrganizationInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	org, err := app.models.Organizations.GetBySlug(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	org.Role, err = app.models.Organizations.AcceptInvitation(org.ID, input.TokenPlaintext, app.contextGetUser(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInvitationEmailMismatch):
+			app.errorResponse(w, r, http.StatusForbidden, "this invitation was sent to another email address than the one of your user account")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"organization": org}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkNotLastOwner sends a 409 Conflict response and returns false if org has a single owner,
+// who therefore can't leave or step down.
+func (app *application) checkNotLastOwner(w http.ResponseWriter, r *http.Request, org *data.Organization) bool {
+	owners, err := app.models.Organizations.CountOwners(org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if owners <= 1 {
+		app.errorResponse(w, r, http.StatusConflict, "an organization must keep at least one owner, make another member an owner first")
+		return false
+	}
+
+	return true
+}
+
+// readOrganizationFromPath fetches the organization named by the "slug" URL parameter, with the
+// role the current user has in it. Organizations are reported as not found to the users who
+// aren't members. If anything goes wrong the error response is sent and ok is false.
+func (app *application) readOrganizationFromPath(w http.ResponseWriter, r *http.Request) (*data.Organization, bool) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	org, err := app.models.Organizations.GetBySlug(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	org.Role, err = app.models.Organizations.Role(org.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if org.Role == "" {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return org, true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestSplitOrgPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		wantSlug string
+		wantRest string
+		wantOK   bool
+	}{
+		{"/orgs/my-team/v1/movies", "my-team", "/v1/movies", true},
+		{"/orgs/my-team/v1/movies/1/comments", "my-team", "/v1/movies/1/comments", true},
+		{"/v1/movies", "", "", false},
+		{"/v1/orgs/my-team/members", "", "", false},
+		{"/orgs/my-team", "", "", false},
+		{"/orgs/my-team/debug/vars", "", "", false},
+		{"/orgs//v1/movies", "", "", false},
+	}
+
+	for _, tt := range tests {
+		slug, rest, ok := splitOrgPath(tt.path)
+		if slug != tt.wantSlug || rest != tt.wantRest || ok != tt.wantOK {
+			t.Errorf("%q: want (%q, %q, %t); got (%q, %q, %t)", tt.path, tt.wantSlug, tt.wantRest, tt.wantOK, slug, rest, ok)
+		}
+	}
+}
+
+func TestOrgPath(t *testing.T) {
+	app := newTestApp()
+
+	var gotPath, gotHeader string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotHeader = r.URL.Path, r.Header.Get(orgHeader)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		path       string
+		header     string
+		wantStatus int
+		wantPath   string
+		wantHeader string
+	}{
+		{"/orgs/my-team/v1/movies", "", http.StatusNoContent, "/v1/movies", "my-team"},
+		{"/orgs/my-team/v1/movies", "my-team", http.StatusNoContent, "/v1/movies", "my-team"},
+		{"/orgs/my-team/v1/movies", "other-team", http.StatusBadRequest, "", ""},
+		{"/v1/movies", "other-team", http.StatusNoContent, "/v1/movies", "other-team"},
+	}
+
+	for _, tt := range tests {
+		gotPath, gotHeader = "", ""
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set(orgHeader, tt.header)
+		}
+		rr := httptest.NewRecorder()
+		app.orgPath(next).ServeHTTP(rr, r)
+
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s with %q: want status %d; got %d", tt.path, tt.header, tt.wantStatus, rr.Code)
+		}
+		if gotPath != tt.wantPath || gotHeader != tt.wantHeader {
+			t.Errorf("%s with %q: want path %q and header %q; got %q and %q", tt.path, tt.header, tt.wantPath, tt.wantHeader, gotPath, gotHeader)
+		}
+	}
+}
+
+func TestResolveOrgRequiresAuthentication(t *testing.T) {
+	app := newTestApp()
+
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if org := app.contextGetOrg(r); org != nil {
+			t.Errorf("want no organization; got %q", org.Slug)
+		}
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r = app.contextSetUser(r, data.AnonymousUser)
+	rr := httptest.NewRecorder()
+	app.resolveOrg(next).ServeHTTP(rr, r)
+
+	if !called {
+		t.Error("want the request without X-Organization to be passed on")
+	}
+
+	called = false
+	r.Header.Set(orgHeader, "my-team")
+	rr = httptest.NewRecorder()
+	app.resolveOrg(next).ServeHTTP(rr, r)
+
+	if called || rr.Code != http.StatusUnauthorized {
+		t.Errorf("want status %d for an anonymous user; got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
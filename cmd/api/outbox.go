@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/mailer"
+)
+
+// The outbox dispatcher sends the emails and webhook events written to the outbox table. They
+// are written in the same transaction as the change they belong to, so that a crash after the
+// commit can't lose them, and are retried with exponential backoff until they are sent or
+// maxOutboxAttempts is reached.
+const (
+	outboxBatch       = 20
+	outboxLease       = 2 * time.Minute
+	outboxRetryBase   = 30 * time.Second
+	outboxRetryMax    = time.Hour
+	maxOutboxAttempts = 10
+)
+
+// startOutboxDispatcher sends the due outbox messages every interval for the lifetime of the
+// application. Each run is started with app.background(), so that graceful shutdown waits for
+// the messages being sent.
+func (app *application) startOutboxDispatcher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			app.background(func() {
+				err := app.dispatchOutbox()
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"job": "outbox"})
+				}
+			})
+		}
+	}()
+}
+
+// dispatchOutbox claims a batch of due outbox messages, sends them concurrently and records the
+// outcome of each.
+func (app *application) dispatchOutbox() error {
+	messages, err := app.models.Outbox.ClaimDue(outboxBatch, outboxLease)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+
+	for _, msg := range messages {
+		wg.Add(1)
+
+		go func(msg *data.OutboxMessage) {
+			defer wg.Done()
+			defer app.recoverBackgroundPanic(map[string]string{"job": "outbox", "outbox_id": strconv.FormatInt(msg.ID, 10)})
+
+			err := app.sendOutboxMessage(msg)
+			recordOutboxAttempt(msg, err, time.Now())
+
+			properties := map[string]string{"outbox_id": strconv.FormatInt(msg.ID, 10), "kind": msg.Kind}
+
+			if msg.Status == data.OutboxFailed && msg.Kind != data.OutboxEmail {
+				app.logger.PrintError(fmt.Errorf("giving up on outbox message: %w", err), properties)
+			}
+
+			recordErr := app.models.Outbox.RecordAttempt(msg)
+			if recordErr != nil {
+				app.logger.PrintError(recordErr, properties)
+			}
+			app.logger.PrintDebug("outbox message attempted", map[string]string{
+				"outbox_id": properties["outbox_id"],
+				"kind":      msg.Kind,
+				"status":    msg.Status,
+				"attempts":  strconv.Itoa(msg.Attempts),
+			})
+
+			// Emails which can't be sent become dead letters, for an administrator to requeue.
+			if msg.Status == data.OutboxFailed && msg.Kind == data.OutboxEmail {
+				letter, letterErr := outboxDeadLetter(msg, err)
+				if letterErr != nil {
+					app.logger.PrintError(fmt.Errorf("giving up on outbox message: %w", err), properties)
+					app.logger.PrintError(letterErr, properties)
+					return
+				}
+				app.recordDeadLetter(letter)
+			}
+		}(msg)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// sendOutboxMessage sends a single outbox message: emails are sent with the mailer, and webhook
+// events are queued for delivery to every subscription to their type.
+func (app *application) sendOutboxMessage(msg *data.OutboxMessage) error {
+	switch msg.Kind {
+	case data.OutboxEmail:
+		var payload data.OutboxEmailPayload
+
+		err := json.Unmarshal(msg.Payload, &payload)
+		if err != nil {
+			return err
+		}
+
+		return app.sendEmail(payload.Recipient, payload.Template, payload.Data)
+	case data.OutboxWebhook:
+		var payload data.OutboxWebhookPayload
+
+		err := json.Unmarshal(msg.Payload, &payload)
+		if err != nil {
+			return err
+		}
+
+		_, err = app.models.Deliveries.Enqueue(payload.EventID, payload.EventType, payload.Body)
+		return err
+	default:
+		return fmt.Errorf("unknown outbox message kind %q", msg.Kind)
+	}
+}
+
+// outboxDeadLetter returns the dead letter of an email outbox message which failed with err.
+func outboxDeadLetter(msg *data.OutboxMessage, err error) (*data.DeadLetter, error) {
+	var payload data.OutboxEmailPayload
+
+	jsErr := json.Unmarshal(msg.Payload, &payload)
+	if jsErr != nil {
+		return nil, jsErr
+	}
+
+	letter, letterErr := data.NewDeadLetter(payload.Recipient, payload.Template, payload.Data, msg.Attempts, err)
+	if letterErr != nil {
+		return nil, letterErr
+	}
+	letter.OutboxID = &msg.ID
+
+	return letter, nil
+}
+
+// recordOutboxAttempt updates a message with the outcome of an attempt made at now. Messages
+// which couldn't be sent stay pending, and are retried after an exponential backoff, until they
+// have been attempted maxOutboxAttempts times. Emails the mailer reports as permanent failures
+// aren't retried.
+func recordOutboxAttempt(msg *data.OutboxMessage, err error, now time.Time) {
+	msg.Attempts++
+	msg.NextAttemptAt = nil
+	msg.Error = ""
+
+	switch {
+	case err == nil:
+		msg.Status = data.OutboxSent
+	case msg.Attempts >= maxOutboxAttempts || mailer.IsPermanent(err):
+		msg.Status = data.OutboxFailed
+		msg.Error = err.Error()
+	default:
+		msg.Status = data.OutboxPending
+		msg.Error = err.Error()
+		next := now.Add(backoffDelay(msg.Attempts, outboxRetryBase, outboxRetryMax))
+		msg.NextAttemptAt = &next
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/mailer"
+)
+
+func TestRecordOutboxAttempt(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	msg := &data.OutboxMessage{Status: data.OutboxPending}
+	recordOutboxAttempt(msg, errors.New("smtp unavailable"), now)
+
+	if msg.Status != data.OutboxPending || msg.Attempts != 1 || msg.Error != "smtp unavailable" {
+		t.Errorf("after a failure: got status %q, %d attempts, error %q", msg.Status, msg.Attempts, msg.Error)
+	}
+	if msg.NextAttemptAt == nil || !msg.NextAttemptAt.Equal(now.Add(outboxRetryBase)) {
+		t.Errorf("after a failure: got next attempt %v; want %v", msg.NextAttemptAt, now.Add(outboxRetryBase))
+	}
+
+	recordOutboxAttempt(msg, nil, now)
+
+	if msg.Status != data.OutboxSent || msg.Error != "" || msg.NextAttemptAt != nil {
+		t.Errorf("after a success: got status %q, error %q, next attempt %v", msg.Status, msg.Error, msg.NextAttemptAt)
+	}
+
+	msg = &data.OutboxMessage{Status: data.OutboxPending, Attempts: maxOutboxAttempts - 1}
+	recordOutboxAttempt(msg, errors.New("smtp unavailable"), now)
+
+	if msg.Status != data.OutboxFailed || msg.NextAttemptAt != nil {
+		t.Errorf("after the last attempt: got status %q, next attempt %v", msg.Status, msg.NextAttemptAt)
+	}
+
+	msg = &data.OutboxMessage{Status: data.OutboxPending}
+	recordOutboxAttempt(msg, &mailer.PermanentError{Err: errors.New("550 no such user")}, now)
+
+	if msg.Status != data.OutboxFailed || msg.Attempts != 1 {
+		t.Errorf("after a permanent failure: got status %q after %d attempts", msg.Status, msg.Attempts)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{1000, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := backoffDelay(tt.attempts, time.Second, 10*time.Second); got != tt.want {
+			t.Errorf("backoffDelay(%d) = %v; want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestNewWebhookMessage(t *testing.T) {
+	msg, err := newWebhookMessage(data.EventMovieCreated, json.RawMessage(`{"movie":{"id":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload data.OutboxWebhookPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	var body webhookPayload
+	if err := json.Unmarshal(payload.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Kind != data.OutboxWebhook || payload.EventType != data.EventMovieCreated || body.ID != payload.EventID || body.Type != data.EventMovieCreated {
+		t.Errorf("got message %+v with payload %+v and body %+v", msg, payload, body)
+	}
+}
+
+func TestSendOutboxMessageRejectsUnknownKinds(t *testing.T) {
+	app := newTestApp()
+
+	err := app.sendOutboxMessage(&data.OutboxMessage{Kind: "fax", Payload: json.RawMessage("{}")})
+	if err == nil {
+		t.Error("got no error for an unknown kind")
+	}
+}
+
+func TestOutboxDeadLetter(t *testing.T) {
+	msg, err := data.NewOutboxEmail("alice@example.com", "user_welcome.tmpl", map[string]interface{}{"userID": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.ID = 7
+	msg.Attempts = maxOutboxAttempts
+
+	letter, err := outboxDeadLetter(msg, errors.New("connection refused"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if letter.Recipient != "alice@example.com" || letter.Template != "user_welcome.tmpl" ||
+		letter.Attempts != maxOutboxAttempts || letter.Error != "connection refused" ||
+		letter.OutboxID == nil || *letter.OutboxID != 7 {
+		t.Errorf("got %+v", letter)
+	}
+	if string(letter.Data) != `{"userID":1}` {
+		t.Errorf("got data %s; want the template data kept to requeue the email", letter.Data)
+	}
+
+	js, err := json.Marshal(letter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(js), "userID") {
+		t.Errorf("got %s; want the template data, which may hold tokens, left out of the JSON", js)
+	}
+}
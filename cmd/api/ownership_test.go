@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestOwnsMovie(t *testing.T) {
+	tests := []struct {
+		owner     int64
+		createdBy int64
+		want      bool
+	}{
+		{0, 7, true},
+		{0, 0, true},
+		{7, 7, true},
+		{7, 8, false},
+		{7, 0, false},
+	}
+
+	for _, tt := range tests {
+		movie := &data.Movie{ID: 1, CreatedBy: tt.createdBy}
+		if got := ownsMovie(tt.owner, movie); got != tt.want {
+			t.Errorf("ownsMovie(%d, created by %d): want %v; got %v", tt.owner, tt.createdBy, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/jsonpatch"
+)
+
+// Content types for the two patch formats accepted by PATCH /v1/movies/:id. Any other content
+// type is treated as a plain partial JSON document, as before.
+const (
+	contentTypeJSONPatch  = "application/json-patch+json"
+	contentTypeMergePatch = "application/merge-patch+json"
+)
+
+// maxPatchOperations is the largest number of operations in a JSON Patch document. The movie
+// document only has a handful of fields, so any real patch is far smaller.
+const maxPatchOperations = 100
+
+// patchContentType returns the media type of the request if it is one of the patch formats,
+// or an empty string otherwise.
+func patchContentType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	switch mediaType {
+	case contentTypeJSONPatch, contentTypeMergePatch:
+		return mediaType
+	default:
+		return ""
+	}
+}
+
+// movieDocument is the document that patches are applied to. It only contains the fields a
+// client may change, so a patch which refers to "/id" or "/version" fails with a "path not
+// found" error, and one which adds them is rejected as an unknown key.
+type movieDocument struct {
+	Title         string             `json:"title"`
+	Year          int32              `json:"year"`
+	Runtime       data.Runtime       `json:"runtime"`
+	Genres        []string           `json:"genres"`
+	Certification data.Certification `json:"certification"`
+}
+
+// applyMoviePatch reads a JSON Patch or JSON Merge Patch document from the request body and
+// applies it to the movie. The movie still has to be validated afterwards. If the patch can't
+// be applied an error response is sent and false is returned.
+func (app *application) applyMoviePatch(w http.ResponseWriter, r *http.Request, contentType string, movie *data.Movie) bool {
+	genres := movie.Genres
+	if genres == nil {
+		genres = []string{}
+	}
+
+	doc, err := json.Marshal(movieDocument{
+		Title:         movie.Title,
+		Year:          movie.Year,
+		Runtime:       movie.Runtime,
+		Genres:        genres,
+		Certification: movie.Certification,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	var patched []byte
+
+	switch contentType {
+	case contentTypeJSONPatch:
+		var ops []jsonpatch.Operation
+
+		err = app.readJSONWithLimits(w, r, &ops, app.config.json.withMaxArrayLength(maxPatchOperations))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return false
+		}
+
+		patched, err = jsonpatch.Apply(doc, ops)
+	default:
+		var patch json.RawMessage
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return false
+		}
+
+		patched, err = jsonpatch.MergePatch(doc, patch)
+	}
+
+	if err != nil {
+		switch {
+		// RFC 5789 suggests 409 Conflict when the patch can't be applied to the current
+		// state of the resource, which is exactly what a failed "test" operation means.
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, jsonpatch.ErrInvalidPatch):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		}
+		return false
+	}
+
+	var result movieDocument
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&result)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "patched movie is invalid: "+err.Error())
+		return false
+	}
+
+	movie.Title = result.Title
+	movie.Year = result.Year
+	movie.Runtime = result.Runtime
+	movie.Genres = result.Genres
+	movie.Certification = result.Certification
+
+	return true
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+// usagePath is the endpoint users check their quota with. It's never counted or refused, so
+// that users over their quota can still see it.
+const usagePath = "/v1/users/me/usage"
+
+// quotaTiers maps a permission code to the monthly request quota of the users who have it, with
+// 0 meaning unlimited. It's set from a space-separated list of permission=limit pairs, e.g.
+// "movies:write=100000 admin:read=0".
+type quotaTiers map[string]int64
+
+func (q *quotaTiers) String() string {
+	if q == nil {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(*q))
+	for code, limit := range *q {
+		pairs = append(pairs, code+"="+strconv.FormatInt(limit, 10))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, " ")
+}
+
+func (q *quotaTiers) Set(val string) error {
+	tiers := make(quotaTiers)
+
+	for _, pair := range strings.Fields(val) {
+		code, limit, ok := strings.Cut(pair, "=")
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if !ok || code == "" || err != nil || n < 0 {
+			return fmt.Errorf("invalid quota tier %q, must be permission=limit", pair)
+		}
+		tiers[code] = n
+	}
+
+	*q = tiers
+	return nil
+}
+
+// quotaFor returns the monthly quota of a user with permissions: the largest quota of the tiers
+// they belong to, or monthly if they belong to none. 0 means unlimited.
+func quotaFor(permissions data.Permissions, monthly int64, tiers quotaTiers) int64 {
+	quota, inTier := int64(0), false
+
+	for code, limit := range tiers {
+		if !permissions.Include(code) {
+			continue
+		}
+		if limit == 0 {
+			return 0
+		}
+		if !inTier || limit > quota {
+			quota, inTier = limit, true
+		}
+	}
+
+	if !inTier {
+		return monthly
+	}
+	return quota
+}
+
+// monthlyQuota returns the monthly quota of user, see quotaFor.
+func (app *application) monthlyQuota(r *http.Request, user *data.User) (int64, error) {
+	if len(app.config.quota.tiers) == 0 {
+		return app.config.quota.monthly, nil
+	}
+
+	permissions, err := app.userPermissions(r, user)
+	if err != nil {
+		return 0, err
+	}
+
+	return quotaFor(permissions, app.config.quota.monthly, app.config.quota.tiers), nil
+}
+
+// nextUsageMonth returns the start of the month after the one t falls in, when the usage
+// counts start again from zero.
+func nextUsageMonth(t time.Time) time.Time {
+	return data.UsageMonth(t).AddDate(0, 1, 0)
+}
+
+// enforceQuota counts every request made by an authenticated user against their monthly quota,
+// and refuses the requests over it with a 429 Too Many Requests response. Anonymous requests
+// and trial tokens have no account to count against, and are only rate limited. Quotas are
+// disabled when -quota-monthly is 0. It must run after authenticate().
+func (app *application) enforceQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if app.config.quota.monthly == 0 || user.IsAnonymous() || user.IsTrial() || r.URL.Path == usagePath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit, err := app.monthlyQuota(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		now := time.Now()
+		_, ok, err := app.models.Usage.Consume(user.ID, data.UsageMonth(now), limit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			retryAfter := nextUsageMonth(now).Sub(now)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+			app.quotaExceededResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// usage is the response of the usage endpoint. Limit and Remaining are nil when the quota is
+// unlimited.
+type usage struct {
+	Month     string    `json:"month"`
+	Requests  int64     `json:"requests"`
+	Limit     *int64    `json:"limit"`
+	Remaining *int64    `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// showUsageHandler reports the requests the user made this month, and their monthly quota.
+func (app *application) showUsageHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	now := time.Now()
+	month := data.UsageMonth(now)
+
+	requests, err := app.models.Usage.Get(user.ID, month)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	u := usage{
+		Month:    month.Format("2006-01"),
+		Requests: requests,
+		ResetsAt: nextUsageMonth(now),
+	}
+
+	if app.config.quota.monthly > 0 {
+		limit, err := app.monthlyQuota(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if limit > 0 {
+			remaining := limit - requests
+			if remaining < 0 {
+				remaining = 0
+			}
+			u.Limit, u.Remaining = &limit, &remaining
+		}
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"usage": u}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestQuotaTiers(t *testing.T) {
+	var tiers quotaTiers
+	if err := tiers.Set("movies:write=100000  admin:read=0"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tiers.String(), "admin:read=0 movies:write=100000"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	for _, val := range []string{"movies:write", "=10", "movies:write=-1", "movies:write=lots"} {
+		if err := tiers.Set(val); err == nil {
+			t.Errorf("got no error for %q", val)
+		}
+	}
+}
+
+func TestQuotaFor(t *testing.T) {
+	tiers := quotaTiers{"movies:read": 5000, "movies:write": 100000, "admin:read": 0}
+
+	tests := []struct {
+		permissions data.Permissions
+		want        int64
+	}{
+		{nil, 1000},
+		{data.Permissions{"movies:read"}, 5000},
+		{data.Permissions{"movies:read", "movies:write"}, 100000},
+		{data.Permissions{"movies:read", "admin:read"}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := quotaFor(tt.permissions, 1000, tiers); got != tt.want {
+			t.Errorf("quotaFor(%v) = %d; want %d", tt.permissions, got, tt.want)
+		}
+	}
+}
+
+func TestNextUsageMonth(t *testing.T) {
+	tests := map[time.Time]time.Time{
+		time.Date(2024, 1, 31, 23, 59, 0, 0, time.UTC):                  time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 12, 15, 12, 0, 0, 0, time.UTC):                  time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 0, 30, 0, 0, time.FixedZone("CET", 3600)): time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for now, want := range tests {
+		if got := nextUsageMonth(now); !got.Equal(want) {
+			t.Errorf("nextUsageMonth(%v) = %v; want %v", now, got, want)
+		}
+	}
+}
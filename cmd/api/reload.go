@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/saalikmubeen/greenlight/internal/configfile"
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+	"github.com/saalikmubeen/greenlight/internal/ratelimit"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// liveConfig holds the settings which can be changed while the server is running, by editing
+// the configuration file and sending the process SIGHUP. Handlers and middleware must read
+// them with app.liveConfig() rather than from app.config, which keeps the startup values.
+type liveConfig struct {
+	// limiter holds the request-per-second and burst values of the rate limiter, whether it
+	// is enabled at all, and the algorithm it uses, see the internal/ratelimit package.
+	limiter struct {
+		rps       float64 // requests per second
+		burst     int     // burst or bucket size
+		enabled   bool
+		algorithm string
+	}
+	// trustedOrigins are the origins allowed to make cross-origin requests.
+	trustedOrigins []string
+	// logLevel is the minimum level of the log entries written, see jsonlog.ParseLevel.
+	logLevel string
+}
+
+// registerLiveFlags defines the flags of the live settings on fs. It's used both for the
+// command line and for reading the configuration file again on SIGHUP.
+func registerLiveFlags(fs *flag.FlagSet, live *liveConfig) {
+	// Read the limiter settings from the command-line flags into the config struct.
+	// We use true as the default for 'enabled' setting.
+	fs.Float64Var(&live.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&live.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&live.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	fs.StringVar(&live.limiter.algorithm, "limiter-algorithm", string(ratelimit.TokenBucket),
+		"Rate limiter algorithm (token-bucket|fixed-window|sliding-log)")
+
+	// -cors-trusted-origins is a space-separated list of trusted origins, e.g.
+	// "http://localhost:4000 http://localhost:4001". If the flag is not present, contains the
+	// empty string, or contains only whitespace, then no origin is trusted.
+	fs.Var((*fieldsValue)(&live.trustedOrigins), "cors-trusted-origins", "Trusted CORS origins (space separated)")
+
+	fs.StringVar(&live.logLevel, "log-level", "info", "Minimum level of log entries (debug|info|warn|error|fatal|off)")
+}
+
+// validateLiveConfig checks the live settings. Errors are keyed by flag name.
+func validateLiveConfig(v *validator.Validator, live liveConfig) {
+	if live.limiter.enabled {
+		v.Check(live.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+		v.Check(live.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+		_, err := ratelimit.ParseAlgorithm(live.limiter.algorithm)
+		v.Check(err == nil, "limiter-algorithm", "must be token-bucket, fixed-window or sliding-log")
+	}
+
+	for _, origin := range live.trustedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			v.AddError("cors-trusted-origins", fmt.Sprintf("%q is not an origin, e.g. https://example.com", origin))
+			break
+		}
+	}
+
+	_, err := jsonlog.ParseLevel(live.logLevel)
+	v.Check(err == nil, "log-level", "must be debug, info, warn, error, fatal or off")
+}
+
+// liveConfig returns the current live settings.
+func (app *application) liveConfig() liveConfig {
+	live, _ := app.live.Load().(liveConfig)
+	return live
+}
+
+// setLiveConfig makes live the current live settings, and applies the log level to the logger.
+func (app *application) setLiveConfig(live liveConfig) {
+	app.live.Store(live)
+
+	if level, err := jsonlog.ParseLevel(live.logLevel); err == nil && app.logger != nil {
+		app.logger.SetLevel(level)
+	}
+}
+
+// reloadConfig reads the configuration file again and applies the live settings in it. As on
+// startup, settings given on the command line or in the environment take precedence over the
+// file, and settings missing from the file get their default values. The other settings in
+// the file are ignored; changing them requires a restart. Nothing is changed if the file or
+// any of the live settings in it are invalid.
+func (app *application) reloadConfig() error {
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+
+	var live liveConfig
+	registerLiveFlags(fs, &live)
+
+	for name, value := range app.config.pinned {
+		if fs.Lookup(name) != nil {
+			if err := fs.Set(name, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if app.config.file != "" {
+		src, err := os.ReadFile(app.config.file)
+		if err != nil {
+			return err
+		}
+
+		settings, err := configfile.Parse(app.config.file, string(src))
+		if err != nil {
+			return err
+		}
+
+		var liveSettings []configfile.Setting
+		for _, s := range settings {
+			if fs.Lookup(s.Key) != nil {
+				liveSettings = append(liveSettings, s)
+			}
+		}
+
+		err = configfile.Apply(fs, app.config.file, liveSettings)
+		if err != nil {
+			return err
+		}
+	}
+
+	v := validator.New()
+	if validateLiveConfig(v, live); !v.Valid() {
+		return errors.New(configErrors(v.Errors))
+	}
+
+	app.setLiveConfig(live)
+	return nil
+}
+
+// fieldsValue is a flag.Value for a list of strings given as one space-separated value.
+type fieldsValue []string
+
+func (f *fieldsValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, " ")
+}
+
+func (f *fieldsValue) Set(val string) error {
+	*f = strings.Fields(val)
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+)
+
+func TestReloadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "greenlight.yaml")
+	write := func(src string) {
+		err := os.WriteFile(file, []byte(src), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	app := newTestApp()
+	app.logger = jsonlog.NewLogger(&buf, jsonlog.LevelInfo)
+	app.config.file = file
+	app.config.pinned = map[string]string{"limiter-burst": "10", "port": "4000"}
+
+	write("port: 5000\nlimiter-rps: 8\nlimiter-burst: 20\ncors-trusted-origins: [https://a.example.com, https://b.example.com]\nlog-level: error\n")
+
+	err := app.reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := app.liveConfig()
+	if live.limiter.rps != 8 || live.limiter.burst != 10 || !live.limiter.enabled {
+		t.Errorf("got limiter %+v; want rps 8, pinned burst 10 and the default enabled", live.limiter)
+	}
+	if want := []string{"https://a.example.com", "https://b.example.com"}; !reflect.DeepEqual(live.trustedOrigins, want) {
+		t.Errorf("got origins %q; want %q", live.trustedOrigins, want)
+	}
+
+	app.logger.PrintInfo("not written", nil)
+	if buf.Len() != 0 {
+		t.Errorf("got %q written at log level error", buf.String())
+	}
+
+	// An invalid file leaves the current settings in place.
+	write("limiter-rps: 0\nlog-level: verbose\n")
+
+	err = app.reloadConfig()
+	if err == nil {
+		t.Fatal("got no error for an invalid file")
+	}
+	if got := app.liveConfig(); !reflect.DeepEqual(got, live) {
+		t.Errorf("got %+v after a failed reload; want %+v", got, live)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/cache"
+)
+
+// movieListCacheTag is the tag of every cached page of the movie list, which any change to any
+// movie can affect.
+const movieListCacheTag = "movies"
+
+// movieCacheTag returns the tag of the cached responses showing the movie with id.
+func movieCacheTag(id int64) string {
+	return "movie:" + strconv.FormatInt(id, 10)
+}
+
+// responseCacheKey returns the key a catalogue read is cached under: the negotiated
+// representation, the scope of the response and the URL. The cache assumes that the scope fully
+// describes what a response shows, so it has to include every input that changes visibility:
+// anonymous users all share the same responses, as do trial users, signed-in users get their own
+// as the responses can include their notes, view-as requests are kept apart from the user's own,
+// and each organization the request is made in gets its own too.
+func (app *application) responseCacheKey(r *http.Request) string {
+	var scope string
+	switch user := app.contextGetUser(r); {
+	case user.IsAnonymous():
+		scope = "public"
+	case user.IsTrial():
+		scope = "trial"
+	default:
+		scope = "user:" + strconv.FormatInt(user.ID, 10)
+	}
+	if app.contextIsViewAs(r) {
+		scope += " view-as"
+	}
+	if orgID := app.orgID(r); orgID != 0 {
+		scope += " org:" + strconv.FormatInt(orgID, 10)
+	}
+
+	return fmt.Sprintf("%s %s %s", negotiateMediaType(r.Header.Get("Accept")), scope, r.URL.RequestURI())
+}
+
+// cacheMovieList serves "GET /v1/movies" from the server-side response cache for
+// -cache-movie-list-ttl, see cacheResponses.
+func (app *application) cacheMovieList(next http.HandlerFunc) http.HandlerFunc {
+	return app.cacheResponses(app.config.cache.movieListTTL, func(r *http.Request) (string, []string) {
+		return app.responseCacheKey(r), []string{movieListCacheTag}
+	}, next)
+}
+
+// cacheMovie serves "GET /v1/movies/:id" from the server-side response cache for
+// -cache-movie-ttl, see cacheResponses.
+func (app *application) cacheMovie(next http.HandlerFunc) http.HandlerFunc {
+	return app.cacheResponses(app.config.cache.movieTTL, func(r *http.Request) (string, []string) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			return "", nil
+		}
+		return app.responseCacheKey(r), []string{movieCacheTag(id)}
+	}, next)
+}
+
+// cacheResponses serves the successful responses of next from the server-side response cache
+// for ttl. It must be wrapped by cacheControl(), which it tells the age of cached responses, and
+// by the middleware checking the user's permissions, which has to run on every request. The key
+// must tell apart every response that can differ in what it shows, see responseCacheKey. Cached
+// responses are invalidated whenever a movie changes, see invalidateCaches. Requests made with
+// X-View-As bypass the cache: they must neither be served the user's own responses, which can
+// include their notes, nor store the responses made for the administrator under the user's key.
+func (app *application) cacheResponses(ttl time.Duration, key func(*http.Request) (string, []string), next http.HandlerFunc) http.HandlerFunc {
+	if app.responseCache == nil {
+		return next
+	}
+
+	cached := app.responseCache.Middleware(cache.Rule{
+		TTL: ttl,
+		Key: key,
+		Hit: app.markServedFromCache,
+	}, next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextIsViewAs(r) {
+			next(w, r)
+			return
+		}
+
+		cached(w, r)
+	}
+}
+
+// invalidateCachedMovie drops the cached responses showing the movie with id, e.g. after a note
+// about it changed.
+func (app *application) invalidateCachedMovie(id int64) {
+	if app.responseCache != nil {
+		app.responseCache.Invalidate(movieCacheTag(id))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/cache"
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/events"
+)
+
+func TestInvalidateCaches(t *testing.T) {
+	app := newTestApp()
+	store := cache.NewMemory(10)
+	app.responseCache = cache.New(store, nil)
+	app.models.Movies.Cache = data.NewMovieCache(10, time.Minute)
+
+	// Cache the movie list and the movies with ids 1 and 2.
+	handler := app.cacheResponses(time.Minute, func(r *http.Request) (string, []string) {
+		tag := movieListCacheTag
+		if id := r.URL.Query().Get("id"); id != "" {
+			tag = "movie:" + id
+		}
+		return r.URL.String(), []string{tag}
+	}, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	fill := func() {
+		for _, target := range []string{"/v1/movies", "/v1/movies?id=1", "/v1/movies?id=2"} {
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		}
+	}
+
+	tests := []struct {
+		eventType string
+		payload   string
+		want      int
+	}{
+		{data.EventMovieUpdated, `{"movie": {"id": 1, "title": "Moana", "version": 2}}`, 1},
+		{data.EventMovieDeleted, `{"movie": {"id": 3}}`, 2},
+		{eventReset, `{}`, 0},
+		{data.EventUserActivated, `{"user": {"id": 1}}`, 3},
+	}
+
+	for _, tt := range tests {
+		fill()
+		app.invalidateCaches(events.Event{Type: tt.eventType, Data: json.RawMessage(tt.payload)})
+		if got := store.Len(); got != tt.want {
+			t.Errorf("%s %s: got %d cached responses left; want %d", tt.eventType, tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidateCachedMovies(t *testing.T) {
+	app := newTestApp()
+	movies := data.NewMovieCache(10, time.Minute)
+	app.models.Movies.Cache = movies
+
+	movies.Add(&data.Movie{ID: 1, Version: 1})
+	movies.Add(&data.Movie{ID: 2, Version: 1})
+	movies.Add(&data.Movie{ID: 3, Version: 1})
+
+	app.invalidateCaches(events.Event{Type: data.EventMovieUpdated, Data: json.RawMessage(`{"movie": {"id": 1, "version": 2}}`)})
+	app.invalidateCaches(events.Event{Type: data.EventMovieDeleted, Data: json.RawMessage(`{"movie": {"id": 2}}`)})
+
+	if _, found := movies.Get(1); found {
+		t.Error("want the updated movie dropped")
+	}
+	if movie, found := movies.Get(2); !found || movie != nil {
+		t.Error("want the deleted movie recorded as deleted")
+	}
+	if _, found := movies.Get(3); !found {
+		t.Error("want the other movie kept")
+	}
+
+	app.invalidateCaches(events.Event{Type: eventReset})
+	if got := movies.Stats().Entries; got != 0 {
+		t.Errorf("got %d movies after a reset; want 0", got)
+	}
+}
+
+func TestViewAsBypassesResponseCache(t *testing.T) {
+	app := newTestApp()
+	app.responseCache = cache.New(cache.NewMemory(10), nil)
+
+	// The handler stands in for showMovieHandler, which only includes the user's note outside
+	// of view-as requests.
+	handler := app.cacheResponses(time.Minute, func(r *http.Request) (string, []string) {
+		return app.responseCacheKey(r), []string{movieCacheTag(1)}
+	}, func(w http.ResponseWriter, r *http.Request) {
+		if app.contextIsViewAs(r) {
+			fmt.Fprint(w, "movie")
+			return
+		}
+		fmt.Fprint(w, "movie with note")
+	})
+
+	target := &data.User{ID: 7, Activated: true}
+	get := func(viewAs bool) string {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+		r = app.contextSetUser(r, target)
+		if viewAs {
+			r = app.contextSetViewAs(r)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, r)
+		return rr.Body.String()
+	}
+
+	// Prime the cache as the target user, then read the movie viewing as them.
+	if got := get(false); got != "movie with note" {
+		t.Fatalf("got %q as the user; want %q", got, "movie with note")
+	}
+	if got := get(true); got != "movie" {
+		t.Errorf("got %q viewing as the user; want %q", got, "movie")
+	}
+	if got := get(false); got != "movie with note" {
+		t.Errorf("got %q as the user after a view-as request; want %q", got, "movie with note")
+	}
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	app := newTestApp()
+
+	user := &data.User{ID: 7, Activated: true}
+	org := &data.Organization{ID: 3}
+
+	tests := []struct {
+		name   string
+		user   *data.User
+		viewAs bool
+		org    *data.Organization
+		want   string
+	}{
+		{"anonymous", data.AnonymousUser, false, nil, "application/json public /v1/movies/1"},
+		{"trial", data.NewTrialUser(), false, nil, "application/json trial /v1/movies/1"},
+		{"user", user, false, nil, "application/json user:7 /v1/movies/1"},
+		{"view-as", user, true, nil, "application/json user:7 view-as /v1/movies/1"},
+		{"org", user, false, org, "application/json user:7 org:3 /v1/movies/1"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+		r = app.contextSetUser(r, tt.user)
+		if tt.viewAs {
+			r = app.contextSetViewAs(r)
+		}
+		if tt.org != nil {
+			r = app.contextSetOrg(r, tt.org)
+		}
+
+		if got := app.responseCacheKey(r); got != tt.want {
+			t.Errorf("%s: got key %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
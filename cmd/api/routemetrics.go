@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// unmatchedRoute is the route the requests which match no route, such as 404s, are counted
+// under, so that scanners trying random paths can't create a metric per path.
+const unmatchedRoute = "unmatched"
+
+// routeContextKey is used as a key for the matchedRoute of a request.
+const routeContextKey = contextKey("route")
+
+// matchedRoute is filled in with the pattern of the route a request matched, such as
+// "/v1/movies/:id", by the handler registered for it. The metrics() middleware, which runs
+// before the router has matched anything, adds it to the request context and reads it back
+// once the request was handled.
+type matchedRoute struct {
+	pattern string
+}
+
+// withMatchedRoute returns a new copy of the request with an empty matchedRoute added to the
+// context, along with the matchedRoute.
+func withMatchedRoute(r *http.Request) (*http.Request, *matchedRoute) {
+	route := &matchedRoute{}
+	return r.WithContext(context.WithValue(r.Context(), routeContextKey, route)), route
+}
+
+// recordRoute wraps the handler registered for the route pattern, recording the pattern in the
+// matchedRoute of the request.
+func recordRoute(pattern string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeContextKey).(*matchedRoute); ok {
+			route.pattern = pattern
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// routeStats counts the requests of one route by outcome, and their durations.
+type routeStats struct {
+	requests     atomic.Int64
+	clientErrors atomic.Int64
+	serverErrors atomic.Int64
+	durations    *latencyHistogram
+}
+
+// routeMetrics holds the routeStats of every route, keyed by method and route pattern, such as
+// "GET /v1/movies/:id". The number of keys is bounded by the number of routes.
+type routeMetrics struct {
+	routes sync.Map
+}
+
+// requestsByRoute are the metrics of the requests by route, recorded by the metrics()
+// middleware.
+var requestsByRoute = &routeMetrics{}
+
+func init() {
+	expvar.Publish("requests_by_route", expvar.Func(func() interface{} {
+		return requestsByRoute.summary()
+	}))
+}
+
+// Observe counts a request for route, "" for the requests which matched no route, that got a
+// response with status after d.
+func (m *routeMetrics) Observe(method, route string, status int, d time.Duration) {
+	if route == "" {
+		route = unmatchedRoute
+	}
+	key := method + " " + route
+
+	v, ok := m.routes.Load(key)
+	if !ok {
+		v, _ = m.routes.LoadOrStore(key, &routeStats{durations: newLatencyHistogram(latencyBuckets)})
+	}
+	stats := v.(*routeStats)
+
+	stats.requests.Add(1)
+	switch {
+	case status >= 500:
+		stats.serverErrors.Add(1)
+	case status >= 400:
+		stats.clientErrors.Add(1)
+	}
+	stats.durations.Observe(d)
+}
+
+// Reset forgets the requests of every route.
+func (m *routeMetrics) Reset() {
+	m.routes.Range(func(key, _ interface{}) bool {
+		m.routes.Delete(key)
+		return true
+	})
+}
+
+// routeSummary is the summary of the requests of one route published on /debug/vars. The error
+// rate is the fraction of the requests which failed with a 5xx status; 4xx responses are
+// mostly the clients' doing and are counted apart.
+type routeSummary struct {
+	Requests     int64   `json:"requests"`
+	ClientErrors int64   `json:"client_errors"`
+	ServerErrors int64   `json:"server_errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	P50ms        float64 `json:"p50_ms"`
+	P95ms        float64 `json:"p95_ms"`
+	P99ms        float64 `json:"p99_ms"`
+}
+
+// summary returns the routeSummary of every route.
+func (m *routeMetrics) summary() map[string]routeSummary {
+	ms := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+
+	out := make(map[string]routeSummary)
+	m.routes.Range(func(key, v interface{}) bool {
+		stats := v.(*routeStats)
+		s := stats.durations.snapshot()
+
+		summary := routeSummary{
+			Requests:     stats.requests.Load(),
+			ClientErrors: stats.clientErrors.Load(),
+			ServerErrors: stats.serverErrors.Load(),
+			P50ms:        ms(s.quantile(0.50)),
+			P95ms:        ms(s.quantile(0.95)),
+			P99ms:        ms(s.quantile(0.99)),
+		}
+		if summary.Requests > 0 {
+			summary.ErrorRate = float64(summary.ServerErrors) / float64(summary.Requests)
+		}
+
+		out[key.(string)] = summary
+		return true
+	})
+	return out
+}
+
+// writePrometheus writes the requests by route and outcome, and the histogram of their
+// durations by route, in the Prometheus text exposition format.
+func (m *routeMetrics) writePrometheus(w io.Writer) {
+	type route struct {
+		method, pattern string
+		stats           *routeStats
+	}
+
+	var routes []route
+	m.routes.Range(func(key, v interface{}) bool {
+		method, pattern, _ := strings.Cut(key.(string), " ")
+		routes = append(routes, route{method, pattern, v.(*routeStats)})
+		return true
+	})
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].pattern != routes[j].pattern {
+			return routes[i].pattern < routes[j].pattern
+		}
+		return routes[i].method < routes[j].method
+	})
+
+	writePrometheusHeader(w, "greenlight_http_route_requests_total", "Requests by route and outcome.", "counter")
+	for _, rt := range routes {
+		requests := rt.stats.requests.Load()
+		clientErrors := rt.stats.clientErrors.Load()
+		serverErrors := rt.stats.serverErrors.Load()
+
+		for _, outcome := range []struct {
+			name  string
+			count int64
+		}{
+			{"success", requests - clientErrors - serverErrors},
+			{"client_error", clientErrors},
+			{"server_error", serverErrors},
+		} {
+			writePrometheusSample(w, "greenlight_http_route_requests_total",
+				prometheusLabels("method", rt.method, "route", rt.pattern, "outcome", outcome.name), outcome.count)
+		}
+	}
+
+	writePrometheusHeader(w, "greenlight_http_route_request_duration_seconds", "Time taken to handle the requests, by route.", "histogram")
+	for _, rt := range routes {
+		writePrometheusHistogram(w, "greenlight_http_route_request_duration_seconds",
+			prometheusLabels("method", rt.method, "route", rt.pattern), rt.stats.durations.snapshot())
+	}
+}
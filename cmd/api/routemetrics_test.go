@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestRecordRoute(t *testing.T) {
+	router := &documentedRouter{Router: httprouter.New()}
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v1/movies/42", "/v1/movies/:id"},
+		{"/v1/unknown", ""},
+	}
+
+	for _, tt := range tests {
+		r, route := withMatchedRoute(httptest.NewRequest(http.MethodGet, tt.path, nil))
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		if route.pattern != tt.want {
+			t.Errorf("%s: got route %q; want %q", tt.path, route.pattern, tt.want)
+		}
+	}
+}
+
+func TestRouteMetrics(t *testing.T) {
+	m := &routeMetrics{}
+	m.Observe(http.MethodGet, "/v1/movies/:id", http.StatusOK, 20*time.Millisecond)
+	m.Observe(http.MethodGet, "/v1/movies/:id", http.StatusNotFound, 2*time.Millisecond)
+	m.Observe(http.MethodGet, "/v1/movies/:id", http.StatusInternalServerError, 3*time.Second)
+	m.Observe(http.MethodGet, "/v1/movies/:id", http.StatusOK, 30*time.Millisecond)
+	m.Observe(http.MethodGet, "", http.StatusNotFound, time.Millisecond)
+
+	summary := m.summary()
+
+	got := summary["GET /v1/movies/:id"]
+	if got.Requests != 4 || got.ClientErrors != 1 || got.ServerErrors != 1 || got.ErrorRate != 0.25 {
+		t.Errorf("got %+v", got)
+	}
+	if got.P50ms <= 10 || got.P50ms > 50 {
+		t.Errorf("got p50 %vms; want it in the bucket of the fast requests", got.P50ms)
+	}
+	if got := summary["GET unmatched"]; got.Requests != 1 {
+		t.Errorf("got %+v for the unmatched requests", got)
+	}
+
+	var b strings.Builder
+	m.writePrometheus(&b)
+
+	for _, line := range []string{
+		`greenlight_http_route_requests_total{method="GET",route="/v1/movies/:id",outcome="success"} 2`,
+		`greenlight_http_route_requests_total{method="GET",route="/v1/movies/:id",outcome="server_error"} 1`,
+		`greenlight_http_route_request_duration_seconds_bucket{method="GET",route="/v1/movies/:id",le="+Inf"} 4`,
+		`greenlight_http_route_request_duration_seconds_count{method="GET",route="unmatched"} 1`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("want the line %s in:\n%s", line, b.String())
+		}
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-// routes is our main application's router.
-func (app *application) routes() http.Handler {
-	router := httprouter.New()
+// registerRoutes creates the router and registers every route of the API on it. The router
+// records the routes, which is used to generate the OpenAPI document served at
+// /v1/openapi.json (see openapi.go).
+func (app *application) registerRoutes() *documentedRouter {
+	router := &documentedRouter{Router: httprouter.New()}
 
 	// Convert the app.notFoundResponse helper to a http.Handler using the http.HandlerFunc()
 	// adapter, and then set it as the custom error handler for 404 Not Found responses.
@@ -26,39 +28,187 @@ func (app *application) routes() http.Handler {
 	// expvar.Handler() handler displays information about memory usage, along with a
 	// reminder of what command-line flags you used when starting the application,
 	// all outputted in JSON format.
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	// As it also exposes build information and database statistics, access is restricted
+	// by requireDebugAccess, see debug.go.
+	router.HandlerFunc(http.MethodGet, "/debug/vars", app.cacheControl(cacheNoStore, app.requireDebugAccess(expvar.Handler())))
+	// The request counts and the latency histogram in the Prometheus text format, behind the
+	// same access checks.
+	router.HandlerFunc(http.MethodGet, "/debug/metrics", app.cacheControl(cacheNoStore, app.requireDebugAccess(http.HandlerFunc(app.prometheusMetricsHandler))))
+
+	// OpenAPI 3 document describing every route below.
+	router.HandlerFunc(http.MethodGet, "/v1/openapi.json", app.openAPIHandler)
+
+	// Interactive API docs (Swagger UI) to explore and try the endpoints. Not served in
+	// production.
+	if app.config.env != "production" {
+		router.HandlerFunc(http.MethodGet, "/v1/docs", app.cacheControl(cacheNoStore, app.docsHandler))
+	}
+
+	// Public catalogue statistics for the marketing site. Always served from memory, with its
+	// own small rate limit.
+	router.HandlerFunc(http.MethodGet, "/v1/stats/public", app.cacheControl(cachePublicStats, app.publicStatsRateLimit(app.publicStatsHandler)))
 
 	// Movies handlers. Note, that these movie endpoints use the `requireActivatedUser` middleware.
+	// Catalogue reads are wrapped with cacheControl(cacheCatalogue), and user, token and note
+	// endpoints with cacheControl(cacheNoStore), see cache.go. Everything under /v1/users and
+	// /v1/tokens is made uncacheable by the enforceNoStore() middleware in any case. The movie
+	// reads are also served from the server's own response cache, see responsecache.go.
 	// /v1/movies?title=godfather&genres=crime,drama&page=1&page_size=5&sort=-year
 	// Required Permission: "movies:read"
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermissions("movies:read", app.listMoviesHandler))
-	// Required Permission: "movies:write"
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermissions("movies:write", app.createMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies", app.cacheControl(cacheCatalogue, app.requirePermissions("movies:read", app.cacheMovieList(app.listMoviesHandler))))
+	// Required Permission: "movies:write:own" or "movies:write:any", both granted by
+	// "movies:write". Users with "movies:write:own" may only update and delete the movies they
+	// created, which the handlers check, see ownership.go.
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requireAnyPermission(movieWritePermissions, app.createMovieHandler))
 	// Required Permission: "movies:read"
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermissions("movies:read", app.showMovieHandler))
-	// Required Permission: "movies:write"
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermissions("movies:write", app.updateMovieHandler))
-	// Required Permission: "movies:write"
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermissions("movies:write", app.deleteMovieHandler))
+	// "/v1/movies/events" streams the movie change feed as Server-Sent Events, see events.go.
+	// It is dispatched from the ":id" wildcard like "/v1/movies/batch" below.
+	router.document(http.MethodGet, "/v1/movies/events")
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermissions("movies:read", app.dispatchIDParam(map[string]http.HandlerFunc{
+		"events": app.cacheControl(cacheNoStore, app.movieEventsHandler),
+	}, app.cacheControl(cacheCatalogue, app.cacheMovie(app.showMovieHandler)))))
+	// Required Permission: "movies:write:own" or "movies:write:any"
+	// "/v1/movies/batch" updates or deletes many movies in one transaction. Like
+	// "/v1/movies/bulk-delete" below, it is dispatched from the ":id" wildcard.
+	router.document(http.MethodPatch, "/v1/movies/batch")
+	router.document(http.MethodDelete, "/v1/movies/batch")
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requireAnyPermission(movieWritePermissions, app.dispatchIDParam(map[string]http.HandlerFunc{
+		"batch": app.batchUpdateMoviesHandler,
+	}, app.updateMovieHandler)))
+	// Required Permission: "movies:write:own" or "movies:write:any"
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requireAnyPermission(movieWritePermissions, app.dispatchIDParam(map[string]http.HandlerFunc{
+		"batch": app.batchDeleteMoviesHandler,
+	}, app.deleteMovieHandler)))
+
+	// Bulk delete movies matching the listing filters, after a dry-run preview. The operation
+	// runs in the background and its progress can be followed with the bulk-operations endpoint.
+	// Required Permission: "movies:write:any", as the filters match the movies of every user.
+	// Note: httprouter doesn't allow a static segment next to the :id wildcard, so
+	// "/v1/movies/bulk-delete" is registered as "/v1/movies/:id" and dispatched on the value.
+	// POST /v1/movies/:id only exists to serve bulk-delete, so it's registered on the inner
+	// router and left out of the OpenAPI document.
+	router.document(http.MethodPost, "/v1/movies/bulk-delete")
+	router.Router.HandlerFunc(http.MethodPost, "/v1/movies/:id", recordRoute("/v1/movies/:id", app.dispatchIDParam(map[string]http.HandlerFunc{
+		"bulk-delete": app.requirePermissions(permissionMoviesWriteAny, app.bulkDeleteMoviesHandler),
+	}, nil)))
+	router.HandlerFunc(http.MethodGet, "/v1/bulk-operations/:id", app.requirePermissions(permissionMoviesWriteAny, app.showBulkOperationHandler))
+
+	// Comments handlers. Reading comments requires "movies:read", posting, editing and
+	// deleting only requires an activated account. Ownership and the "comments:moderate"
+	// permission are checked inside the handlers.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/comments", app.cacheControl(cacheCatalogue, app.requirePermissions("movies:read", app.listCommentsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/comments", app.requireActivatedUser(app.createCommentHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/comments/:comment_id", app.requireActivatedUser(app.updateCommentHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/comments/:comment_id", app.requireActivatedUser(app.deleteCommentHandler))
+
+	// Private notes handlers. A note is only ever visible to the user who wrote it, and trial
+	// users, who have no account, can't have any.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/note", app.cacheControl(cacheNoStore, app.requirePermissions("movies:read", app.requireActivatedUser(app.denyViewAs(app.showNoteHandler)))))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/note", app.requirePermissions("movies:read", app.requireActivatedUser(app.putNoteHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/note", app.requirePermissions("movies:read", app.requireActivatedUser(app.deleteNoteHandler)))
+
+	// Movie lists handlers. Lists are identified by their slug, which is shareable. Reading a
+	// list doesn't require authentication, the visibility and the user's role on the list are
+	// checked inside the handlers.
+	router.HandlerFunc(http.MethodGet, "/v1/lists", app.cacheControl(cacheNoStore, app.requireActivatedUser(app.listMovieListsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/lists", app.requireActivatedUser(app.createMovieListHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/lists/:slug", app.cacheControl(cacheNoStore, app.showMovieListHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/lists/:slug", app.requireActivatedUser(app.updateMovieListHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/lists/:slug", app.requireActivatedUser(app.deleteMovieListHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/lists/:slug/movies/:movie_id", app.requireActivatedUser(app.addMovieListItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/lists/:slug/movies/:movie_id", app.requireActivatedUser(app.removeMovieListItemHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/lists/:slug/collaborators", app.cacheControl(cacheNoStore, app.requireActivatedUser(app.listMovieListCollaboratorsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/lists/:slug/collaborators", app.requireActivatedUser(app.inviteMovieListCollaboratorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/lists/:slug/collaborators/:user_id", app.requireActivatedUser(app.removeMovieListCollaboratorHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/lists/:slug/invitation", app.requireActivatedUser(app.acceptMovieListInvitationHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/lists", app.cacheControl(cacheNoStore, app.listUserMovieListsHandler))
+
+	// Organizations handlers. Every organization has its own movie catalogue, which the
+	// requests naming it in the X-Organization header, or with the /orgs/<slug> path prefix,
+	// work with, see resolveOrg. Only its members can see an organization.
+	router.HandlerFunc(http.MethodGet, "/v1/orgs", app.cacheControl(cacheNoStore, app.requireActivatedUser(app.listOrganizationsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/orgs", app.requireActivatedUser(app.createOrganizationHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/orgs/:slug/members", app.cacheControl(cacheNoStore, app.requireActivatedUser(app.listOrganizationMembersHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/orgs/:slug/members", app.requireActivatedUser(app.setOrganizationMemberHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/orgs/:slug/members/:user_id", app.requireActivatedUser(app.removeOrganizationMemberHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/orgs/:slug/invitations", app.cacheControl(cacheNoStore, app.requireActivatedUser(app.listOrganizationInvitationsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/orgs/:slug/invitations", app.requireActivatedUser(app.createOrganizationInvitationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/orgs/:slug/invitations/:id", app.requireActivatedUser(app.revokeOrganizationInvitationHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/orgs/:slug/invitation", app.requireActivatedUser(app.acceptOrganizationInvitationHandler))
+	// The requests the user made this month, and their monthly quota, see quota.go. Like
+	// "/v1/movies/batch", it's dispatched from the ":id" wildcard.
+	router.document(http.MethodGet, "/v1/users/me/usage")
+	router.Router.HandlerFunc(http.MethodGet, "/v1/users/:id/usage", recordRoute("/v1/users/me/usage", app.dispatchIDParam(map[string]http.HandlerFunc{
+		"me": app.cacheControl(cacheNoStore, app.requireActivatedUser(app.showUsageHandler)),
+	}, nil)))
+	// The logins, activations and password resets of the user, see authevents.go. Dispatched from
+	// the ":id" wildcard too.
+	router.document(http.MethodGet, "/v1/users/me/security-events")
+	router.Router.HandlerFunc(http.MethodGet, "/v1/users/:id/security-events", recordRoute("/v1/users/me/security-events", app.dispatchIDParam(map[string]http.HandlerFunc{
+		"me": app.cacheControl(cacheNoStore, app.requireActivatedUser(app.denyViewAs(app.listSecurityEventsHandler))),
+	}, nil)))
 
 	// Users handlers
 	// Register a new user
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.cacheControl(cacheNoStore, app.registerUserHandler))
 	// Activate the user account who has just registered
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.cacheControl(cacheNoStore, app.activateUserHandler))
 
 	// Tokens handlers
 	// Endpoint to send the activation token or account activation email to the user
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.cacheControl(cacheNoStore, app.createActivationTokenHandler))
+	// Issue a short-lived, read-only trial token without an account
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/trial", app.cacheControl(cacheNoStore, app.createTrialTokenHandler))
 	// Log in the user and return an authentication token
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.cacheControl(cacheNoStore, app.credentialRateLimit("authentication", app.createAuthenticationTokenHandler)))
 
 	// Password reset handlers
 	// Endpoint where user submits a new password to be stored in the database
 	// along with the plain text password reset token they received in their email.
-	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.cacheControl(cacheNoStore, app.updateUserPasswordHandler))
 	// Endpoint where user can request a password reset token or link to be sent to their email
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.cacheControl(cacheNoStore, app.credentialRateLimit("password-reset", app.createPasswordResetTokenHandler)))
+
+	// Operational endpoints for on-call engineers.
+	// Required Permission: "admin:read"
+	router.HandlerFunc(http.MethodGet, "/v1/admin/inflight", app.cacheControl(cacheNoStore, app.requirePermissions("admin:read", app.listInFlightRequestsHandler)))
+	// A snapshot of the request counters, and resetting them, e.g. between load test runs.
+	// Required Permission: "admin:read" to read them, "admin:write" to reset them
+	router.HandlerFunc(http.MethodGet, "/v1/admin/metrics", app.cacheControl(cacheNoStore, app.requirePermissions("admin:read", app.showMetricsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/metrics", app.requirePermissions("admin:write", app.resetMetricsHandler))
+	// Required Permission: "admin:read"
+	router.HandlerFunc(http.MethodGet, "/v1/admin/idp-sync", app.cacheControl(cacheNoStore, app.requirePermissions("admin:read", app.showIdPSyncReportHandler)))
+	// Write a diagnostic bundle, like sending SIGQUIT to the process.
+	// Required Permission: "admin:read"
+	router.HandlerFunc(http.MethodPost, "/v1/admin/diagnostics", app.requirePermissions("admin:read", app.createDiagnosticsHandler))
+	// Required Permission: "admin:read"
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit-log", app.cacheControl(cacheNoStore, app.requirePermissions("admin:read", app.listAuditLogHandler)))
+	// Every user's logins, activations and password resets, for investigating incidents.
+	router.HandlerFunc(http.MethodGet, "/v1/admin/auth-events", app.cacheControl(cacheNoStore, app.requirePermissions("admin:read", app.listAuthEventsHandler)))
+	// Emails which couldn't be sent, and requeueing them.
+	// Required Permission: "admin:read"
+	router.HandlerFunc(http.MethodGet, "/v1/admin/emails/dead-letters", app.cacheControl(cacheNoStore, app.requirePermissions("admin:read", app.listDeadLettersHandler)))
+	// Required Permission: "admin:write"
+	router.HandlerFunc(http.MethodPost, "/v1/admin/emails/dead-letters/:id/requeue", app.requirePermissions("admin:write", app.requeueDeadLetterHandler))
+
+	// Outbound webhook subscriptions to catalogue and user events, and their delivery logs.
+	// Required Permission: "webhooks:write"
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.cacheControl(cacheNoStore, app.requirePermissions("webhooks:write", app.listWebhookSubscriptionsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermissions("webhooks:write", app.createWebhookSubscriptionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermissions("webhooks:write", app.deleteWebhookSubscriptionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.cacheControl(cacheNoStore, app.requirePermissions("webhooks:write", app.listWebhookDeliveriesHandler)))
+
+	// Inbound webhooks from third-party integrations. These are authenticated with a signature
+	// over the request body instead of a bearer token.
+	router.HandlerFunc(http.MethodPost, "/v1/integrations/:provider/webhook", app.receiveWebhookHandler)
+
+	return router
+}
+
+// routes is our main application's router, wrapped in the middleware chain.
+func (app *application) routes() http.Handler {
+	router := app.registerRoutes()
+	app.apiRoutes = router.routes
 
 	// Use the authenticate() middleware on all requests.
 	// Wrap the router with the panic recovery middleware and rate limit middleware.
@@ -72,16 +222,31 @@ func (app *application) routes() http.Handler {
 	// The middleware functions are REGISTERED once and run from RIGHT to LEFT upon the
 	// application startup in the routes() method. However, for each incoming request, the
 	// middleware functions are EXECUTED from LEFT to RIGHT.
+	// The handleHead() wrapper sits directly in front of the router so that every GET route
+	// also answers HEAD requests. trialRateLimit() needs the user that authenticate() adds to
+	// the request context, so it has to come after it. viewAs() swaps that user for the one
+	// named in the X-View-As header, so it also has to come after authenticate(). trackInFlight()
+	// sits between the two so that it records the user who actually made the request, and so
+	// does auditLog(). rateLimit() limits authenticated users by user ID rather than IP
+	// address, so it comes after authenticate() too; the requests authenticate() rejects never
+	// get to it, so authenticate() charges those to the client's IP address in the same store
+	// (see rejectAuthentication), and enforceQuota() comes after rateLimit() so that
+	// rate limited requests don't count towards the monthly quota. requireContentType() comes
+	// last, so that a request with an unsupported body still gets the CORS headers and counts
+	// towards the rate limits.
+	// resolveOrg() looks up the organization for the user viewAs() settled on, so it comes after
+	// it. orgPath() rewrites the /orgs/<slug> paths before anything else sees them, so that the
+	// metrics and the audit log record the routes rather than every organization's paths.
 	// Registration order:
-	// 1. authenticate -> 2. rateLimit -> 3. enableCORS -> 4. recoverPanic -> 5. metrics
+	// 1. rateLimit -> 2. authenticate -> 3. enableCORS -> 4. recoverPanic -> 5. metrics
 	// The order of execution is:
-	// 1. metrics -> 2. recoverPanic -> 3. enableCORS -> 4. rateLimit -> 5. authenticate
+	// 1. metrics -> 2. recoverPanic -> 3. enableCORS -> 4. authenticate -> 5. rateLimit
 	// And finally when all the middleware functions have run by calling next.ServeHTTP(w, r)
 	// the request is passed to the router for handling, after which the response is passed back
 	// through the middleware functions chain in the reverse order i.e any code after
 	// next.ServeHTTP(w, r) is executed in the reverse order.
 	// So the order of execution for the response is:
-	// 1. authenticate -> 2. rateLimit -> 3. enableCORS -> 4. recoverPanic -> 5. metrics
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	// 1. rateLimit -> 2. authenticate -> 3. enableCORS -> 4. recoverPanic -> 5. metrics
+	return app.orgPath(app.metrics(app.enforceNoStore(app.recoverPanic(app.enableCORS(app.authenticate(app.rateLimit(app.enforceQuota(app.trackInFlight(app.auditLog(app.viewAs(app.trialRateLimit(app.resolveOrg(app.requireContentType(app.handleHead(router.Router)))))))))))))))
 
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/schedule"
+)
+
+// scheduledJob is a maintenance job run by the scheduler whenever its schedule is due.
+type scheduledJob struct {
+	name     string
+	schedule schedule.Schedule
+	run      func() error
+}
+
+// jobStatus is what the scheduler reports about a job on /debug/vars.
+type jobStatus struct {
+	Running   bool       `json:"running"`
+	LastRun   *time.Time `json:"last_run"`
+	LastError string     `json:"last_error,omitempty"`
+	NextRun   *time.Time `json:"next_run"`
+}
+
+// scheduler runs the maintenance jobs on their schedules. Every run is started with
+// app.background(), so that graceful shutdown waits for a run in progress to finish, and no
+// run starts once the scheduler has been stopped. A job whose previous run is still in
+// progress when it's due again skips that run.
+type scheduler struct {
+	mu     sync.Mutex
+	status map[string]*jobStatus
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	// loops counts the goroutines waiting for the jobs to be due.
+	loops sync.WaitGroup
+}
+
+// Stop stops starting new runs, and returns once no more can start. Runs in progress carry on.
+// It is safe to call on a nil scheduler.
+func (s *scheduler) Stop() {
+	if s == nil {
+		return
+	}
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.loops.Wait()
+}
+
+// begin marks the job as running, unless it already is.
+func (s *scheduler) begin(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status[name]
+	if status.Running {
+		return false
+	}
+	status.Running = true
+	return true
+}
+
+// end records the outcome of a run of the job which started at start.
+func (s *scheduler) end(name string, start time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status[name]
+	status.Running = false
+	status.LastRun = &start
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
+
+// setNext records when the job is due next.
+func (s *scheduler) setNext(name string, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if next.IsZero() {
+		s.status[name].NextRun = nil
+		return
+	}
+	s.status[name].NextRun = &next
+}
+
+// snapshot returns a copy of the status of every job.
+func (s *scheduler) snapshot() map[string]jobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make(map[string]jobStatus, len(s.status))
+	for name, status := range s.status {
+		snapshot[name] = *status
+	}
+	return snapshot
+}
+
+// startScheduler runs the jobs on their schedules for the lifetime of the application. It is
+// stopped when the server shuts down, see serve().
+func (app *application) startScheduler(jobs []scheduledJob) {
+	s := &scheduler{
+		status: make(map[string]*jobStatus, len(jobs)),
+		stop:   make(chan struct{}),
+	}
+	for _, job := range jobs {
+		s.status[job.name] = &jobStatus{}
+	}
+	app.scheduler = s
+
+	for _, job := range jobs {
+		s.loops.Add(1)
+		go app.runScheduledJob(s, job)
+	}
+}
+
+// runScheduledJob waits for each time the job is due, and starts a run then.
+func (app *application) runScheduledJob(s *scheduler, job scheduledJob) {
+	defer s.loops.Done()
+
+	for {
+		next := job.schedule.Next(time.Now())
+		s.setNext(job.name, next)
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// The timer and stop may have fired together.
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if !s.begin(job.name) {
+			app.logger.PrintWarn("skipped scheduled job, the previous run is still in progress", map[string]string{"job": job.name})
+			continue
+		}
+
+		app.background(func() {
+			start := time.Now()
+			var err error
+			defer func() {
+				if p := recover(); p != nil {
+					err = fmt.Errorf("%v", p)
+				}
+				s.end(job.name, start, err)
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"job": job.name})
+				}
+			}()
+
+			err = job.run()
+		})
+	}
+}
+
+// maintenanceJobs returns the maintenance jobs which have a schedule set in the configuration.
+func (app *application) maintenanceJobs() ([]scheduledJob, error) {
+	cfg := app.config.maintenance
+
+	var jobs []scheduledJob
+	for _, job := range []struct {
+		name string
+		spec string
+		run  func() error
+	}{
+		{"token-purge", cfg.tokenPurge, app.purgeExpiredTokens},
+		{"account-cleanup", cfg.accountCleanup, app.deleteUnactivatedAccounts},
+		{"popularity", cfg.popularity, app.recomputePopularity},
+	} {
+		if job.spec == "" {
+			continue
+		}
+
+		s, err := schedule.Parse(job.spec)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, scheduledJob{name: job.name, schedule: s, run: job.run})
+	}
+
+	return jobs, nil
+}
+
+// tokenPurgeBatchSize is the number of expired tokens deleted per statement.
+const tokenPurgeBatchSize = 5000
+
+// purgeExpiredTokens deletes the expired tokens, in batches. Expired trial tokens are kept for
+// a day, as they count towards the number of trial tokens an IP address can be issued in 24
+// hours.
+func (app *application) purgeExpiredTokens() error {
+	var tokens int64
+	for {
+		deleted, err := app.models.Tokens.DeleteExpired(tokenPurgeBatchSize)
+		if err != nil {
+			return err
+		}
+		tokens += deleted
+		if deleted < tokenPurgeBatchSize {
+			break
+		}
+	}
+
+	trialTokens, err := app.models.TrialTokens.DeleteExpired(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("purged expired tokens", map[string]string{
+		"job":          "token-purge",
+		"tokens":       fmt.Sprint(tokens),
+		"trial_tokens": fmt.Sprint(trialTokens),
+	})
+	return nil
+}
+
+// accountCleanupBatchSize is the number of unactivated accounts deleted per statement.
+const accountCleanupBatchSize = 1000
+
+// deleteUnactivatedAccounts deletes the accounts which were never activated within
+// -unactivated-account-age of registering, in batches, so that their email addresses can be
+// registered again.
+func (app *application) deleteUnactivatedAccounts() error {
+	registeredBefore := time.Now().Add(-app.config.maintenance.unactivatedAge)
+
+	var users int64
+	for {
+		deleted, err := app.models.Users.DeleteUnactivated(registeredBefore, accountCleanupBatchSize)
+		if err != nil {
+			return err
+		}
+		users += deleted
+		if deleted < accountCleanupBatchSize {
+			break
+		}
+	}
+
+	app.logger.PrintInfo("deleted unactivated accounts", map[string]string{
+		"job":   "account-cleanup",
+		"users": fmt.Sprint(users),
+	})
+	return nil
+}
+
+// recomputePopularity scores the movies from the activity within -popularity-window.
+func (app *application) recomputePopularity() error {
+	scored, err := app.models.Popularity.Recompute(time.Now().Add(-app.config.maintenance.popularityWindow))
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("recomputed movie popularity", map[string]string{
+		"job":    "popularity",
+		"movies": fmt.Sprint(scored),
+	})
+	return nil
+}
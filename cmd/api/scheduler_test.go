@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+)
+
+// soon is a schedule due every few milliseconds.
+type soon struct{}
+
+func (soon) Next(t time.Time) time.Time {
+	return t.Add(5 * time.Millisecond)
+}
+
+func TestScheduler(t *testing.T) {
+	app := newTestApp()
+	app.logger = jsonlog.NewLogger(io.Discard, jsonlog.LevelOff)
+
+	var runs, slowRuns int64
+	release := make(chan struct{})
+	app.startScheduler([]scheduledJob{
+		{name: "fails", schedule: soon{}, run: func() error {
+			atomic.AddInt64(&runs, 1)
+			return errors.New("boom")
+		}},
+		{name: "slow", schedule: soon{}, run: func() error {
+			atomic.AddInt64(&slowRuns, 1)
+			<-release
+			return nil
+		}},
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	app.scheduler.Stop()
+	close(release)
+	app.wg.Wait()
+
+	if got := atomic.LoadInt64(&runs); got < 2 {
+		t.Errorf("got %d runs; want the job run every time it's due", got)
+	}
+	if got := atomic.LoadInt64(&slowRuns); got != 1 {
+		t.Errorf("got %d runs; want the runs due while the previous one is in progress skipped", got)
+	}
+
+	status := app.scheduler.snapshot()
+	if s := status["fails"]; s.LastRun == nil || s.LastError != "boom" {
+		t.Errorf("got %+v; want the error of the last run recorded", s)
+	}
+	if s := status["slow"]; s.Running || s.LastError != "" {
+		t.Errorf("got %+v; want the run recorded as finished", s)
+	}
+
+	// No run starts once the scheduler is stopped.
+	before := atomic.LoadInt64(&runs)
+	time.Sleep(20 * time.Millisecond)
+	if got := atomic.LoadInt64(&runs); got != before {
+		t.Errorf("got %d runs after Stop; want %d", got, before)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/fixtures"
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+)
+
+// seedCommand adds development data to the database, see seedDatabase.
+func seedCommand() *command {
+	var movies int
+
+	return &command{
+		name:    "seed",
+		summary: "Add development users and movies to the database",
+		config:  true,
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&movies, "movies", 100, "Number of movies to add")
+		},
+		run: func(cfg config, logger *jsonlog.Logger, args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("unexpected arguments %q", args)
+			}
+
+			db, err := openDB(cfg, cfg.db.dsn)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			return seedDatabase(cfg, db, logger, movies)
+		},
+	}
+}
+
+// seedDatabase adds the development users and the given number of movies to the database,
+// skipping those which are already there, so it's safe to run again. It refuses to run in
+// production.
+func seedDatabase(cfg config, db *sql.DB, logger *jsonlog.Logger, movies int) error {
+	if cfg.env == "production" {
+		return errors.New("seed adds users with known passwords, so it can't be used in production")
+	}
+	if movies < 0 {
+		return errors.New("-movies must not be negative")
+	}
+
+	loaded, created, err := fixtures.Generate(movies).Seed(data.NewModels(db, nil).Interfaces())
+	if err != nil {
+		return err
+	}
+
+	logger.PrintInfo("database seeded", map[string]string{
+		"users":    strconv.Itoa(len(loaded.Users)),
+		"movies":   strconv.Itoa(len(loaded.Movies)),
+		"created":  strconv.Itoa(created),
+		"password": fixtures.SeedPassword,
+	})
+	return nil
+}
@@ -3,13 +3,16 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/systemd"
 )
 
 func (app *application) serve() error {
@@ -27,8 +30,9 @@ func (app *application) serve() error {
 	to use a regular log.Logger instance from the standard library which writes to our
 	own Logger as the target destination.
 	*/
+	_, address := listenAddress(app.config.listen.address, app.config.port)
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", app.config.port),
+		Addr:    address,
 		Handler: app.routes(),
 		// Create a new Go log.Logger instance with the log.New() function, passing in
 		// our custom Logger as the first parameter. The "" and 0 indicate that the
@@ -39,6 +43,61 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// Close the event bus when shutting down, so that the change feed streams end instead of
+	// holding up the shutdown.
+	srv.RegisterOnShutdown(app.events.Close)
+
+	// Stop the scheduler when shutting down, so that no maintenance job starts while the
+	// background tasks are being waited for.
+	srv.RegisterOnShutdown(app.scheduler.Stop)
+
+	// In ACME mode, serve HTTPS with the certificates of the autocert manager, and answer its
+	// HTTP-01 challenges on a second, plain HTTP server which is shut down along with srv. The
+	// TLS-ALPN-01 challenges are answered by srv itself, so a failure to listen on the
+	// challenge port is logged rather than fatal.
+	certManager := app.autocertManager()
+	var challengeSrv *http.Server
+	if certManager != nil {
+		srv.TLSConfig = certManager.TLSConfig()
+		challengeSrv = app.challengeServer(certManager)
+
+		go func() {
+			err := challengeSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{"addr": challengeSrv.Addr})
+			}
+		}()
+	}
+
+	// Reload the rate limiter, CORS origin and log level settings from the configuration file
+	// whenever we receive SIGHUP, see reload.go. A reload which fails leaves the current
+	// settings in place.
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			app.notifySystemd(systemd.Reloading)
+			err := app.reloadConfig()
+			app.notifySystemd(systemd.Ready)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"signal": "SIGHUP"})
+				continue
+			}
+
+			live := app.liveConfig()
+			app.logger.PrintInfo("reloaded configuration", map[string]string{
+				"signal":               "SIGHUP",
+				"limiter-enabled":      strconv.FormatBool(live.limiter.enabled),
+				"limiter-rps":          strconv.FormatFloat(live.limiter.rps, 'g', -1, 64),
+				"limiter-burst":        strconv.Itoa(live.limiter.burst),
+				"limiter-algorithm":    live.limiter.algorithm,
+				"cors-trusted-origins": strings.Join(live.trustedOrigins, " "),
+				"log-level":            live.logLevel,
+			})
+		}
+	}()
+
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
@@ -75,6 +134,10 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
+		// Tell systemd that the service is stopping, so that it doesn't treat the shutdown
+		// as a crash.
+		app.notifySystemd(systemd.Stopping)
+
 		// Create a context with a 5-second timeout.
 		// Give any in-flight requests a ‘grace period’ of 5 seconds to complete
 		// before the application is terminated.
@@ -87,6 +150,11 @@ func (app *application) serve() error {
 		// because the shutdown didn't complete before the 5-second context deadline is
 		// hit). We relay this return value to the shutdownError channel.
 		err := srv.Shutdown(ctx)
+		if challengeSrv != nil {
+			if challengeErr := challengeSrv.Shutdown(ctx); err == nil {
+				err = challengeErr
+			}
+		}
 		if err != nil {
 			shutdownError <- err
 		}
@@ -106,17 +174,34 @@ func (app *application) serve() error {
 
 	}()
 
+	// Open the TCP or unix socket listener. Shutdown() closes it, which also removes the
+	// socket file.
+	ln, err := app.listen()
+	if err != nil {
+		return err
+	}
+
 	// Log a "starting server" message.
 	app.logger.PrintInfo("starting server", map[string]string{
-		"addr": srv.Addr,
+		"addr": ln.Addr().String(),
 		"env":  app.config.env,
+		"tls":  strconv.FormatBool(certManager != nil),
 	})
 
-	// Calling Shutdown() on our server will cause ListenAndServer() to immediately
+	// Calling Shutdown() on our server will cause Serve() to immediately
 	// return a http.ErrServerClosed error. So, if we see this error, it is actually a good thing
 	// and an indication that the graceful shutdown has started. So, we specifically check for this,
 	// only returning the error if it is NOT http.ErrServerClosed.
-	err := srv.ListenAndServe()
+	// The listener is open, so requests can be accepted from here on: tell systemd the
+	// service is ready when it runs with Type=notify.
+	app.notifySystemd(systemd.Ready)
+
+	if certManager != nil {
+		// The certificate and key come from srv.TLSConfig, so no files are given.
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -151,5 +236,15 @@ SIGTERM 	   Terminate process in orderly manner	      -                       Ye
 // 2. Send the signal to the process id:
 // kill -SIGINT <pid>
 // kill -SIGTERM <pid>
-// kill -SIGQUIT <pid>
+// kill -SIGQUIT <pid> // This writes a diagnostic bundle and keeps running, see diagnostics.go
+// kill -SIGHUP <pid>  // This reloads the live settings from the config file, see reload.go
 // kill -SIGKILL <pid> // This will terminate the process immediately
+
+// notifySystemd sends state to systemd, logging rather than returning a failure, as the
+// notification only informs the service manager and the server works without it.
+func (app *application) notifySystemd(state string) {
+	err := systemd.Notify(state)
+	if err != nil {
+		app.logger.PrintWarn("systemd notification failed", map[string]string{"state": state, "error": err.Error()})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/ratelimit"
+	"github.com/tomasen/realip"
+)
+
+// publicStatsCache holds the latest snapshot of the public catalogue statistics. It is only
+// ever filled in by the refresh job, so serving the statistics never touches the database. The
+// zero value is ready to use and holds no snapshot.
+type publicStatsCache struct {
+	mu          sync.RWMutex
+	stats       *data.CatalogueStats
+	refreshedAt time.Time
+}
+
+func (c *publicStatsCache) set(stats *data.CatalogueStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats = stats
+	c.refreshedAt = time.Now()
+}
+
+func (c *publicStatsCache) get() (*data.CatalogueStats, time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.stats, c.refreshedAt
+}
+
+// publicStatsPopular is the number of popular movies listed in the public statistics.
+const publicStatsPopular = 10
+
+// startPublicStatsJob refreshes the public statistics straight away, and then every interval
+// for the lifetime of the application.
+func (app *application) startPublicStatsJob(interval time.Duration) {
+	refresh := func() {
+		defer app.recoverBackgroundPanic(map[string]string{"job": "public-stats"})
+
+		stats, err := app.models.Movies.Stats()
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "public-stats"})
+			return
+		}
+		stats.Popular, err = app.models.Popularity.Top(publicStatsPopular)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "public-stats"})
+			return
+		}
+		app.publicStats.set(stats)
+	}
+
+	go func() {
+		refresh()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			refresh()
+		}
+	}()
+}
+
+// publicStatsHandler handles "GET /v1/stats/public" and returns high-level catalogue numbers
+// for the marketing site. It is served from the snapshot kept by startPublicStatsJob.
+func (app *application) publicStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, refreshedAt := app.publicStats.get()
+	if stats == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "statistics are not available yet, please try again later")
+		return
+	}
+
+	app.markServedFromCache(r, refreshedAt)
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// publicStatsRateLimit gives the public statistics endpoint its own, much smaller per-IP rate
+// limit bucket, on top of the regular rateLimit() middleware.
+func (app *application) publicStatsRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	store := ratelimit.NewStore(ratelimit.TokenBucket, app.config.stats.rps, app.config.stats.burst)
+
+	// Remove clients that haven't been seen recently once every minute, in the same way as
+	// the rateLimit() middleware does.
+	store.StartCleanup(time.Minute, 3*time.Minute)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.liveConfig().limiter.enabled {
+			next(w, r)
+			return
+		}
+
+		if !app.allowRequest(w, r, store, realip.FromRequest(r)) {
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// Outbound webhooks. Users with the "webhooks:write" permission subscribe a URL to event types,
+// and every matching event is POSTed to it as a JSON object with an "id", a "type", a
+// "created_at" time and the event "data". Deliveries are signed in the same way as the inbound
+// webhooks we accept (see verifyWebhookSignature), with the secret returned when the
+// subscription was created, and are retried with exponential backoff until the receiver
+// responds with a 2xx status code or maxWebhookAttempts is reached.
+const (
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+	webhookDeliveryTimeout = 10 * time.Second
+	webhookDeliveryBatch   = 20
+	webhookRetryBase       = 30 * time.Second
+	webhookRetryMax        = 6 * time.Hour
+	maxWebhookAttempts     = 10
+)
+
+// webhookPayload is the body of a delivery.
+type webhookPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// enqueueWebhookDeliveries writes an event to the outbox, from which the outbox dispatcher
+// queues a delivery of it to every subscription to its type. It runs in the background, so that
+// it never slows down or fails the request which caused the event. Changes made in a data.Tx
+// should use commitWithEvent instead, so that the event is written in the same transaction.
+func (app *application) enqueueWebhookDeliveries(eventType string, payload json.RawMessage) {
+	app.background(func() {
+		msg, err := newWebhookMessage(eventType, payload)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		err = app.models.Outbox.Insert(msg)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"event_type": eventType})
+		}
+	})
+}
+
+// newWebhookMessage returns the outbox message for an event, with a new event id.
+func newWebhookMessage(eventType string, payload json.RawMessage) (*data.OutboxMessage, error) {
+	eventID, err := generateEventID()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(webhookPayload{
+		ID:        eventID,
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data.NewOutboxWebhook(eventID, eventType, body)
+}
+
+// generateEventID returns a random 16 byte event id, hex encoded.
+func generateEventID() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// startWebhookDeliveryJob sends the due webhook deliveries every interval for the lifetime of
+// the application. Each run is started with app.background(), so that graceful shutdown waits
+// for the deliveries in progress.
+func (app *application) startWebhookDeliveryJob(interval time.Duration) {
+	client := &http.Client{Timeout: webhookDeliveryTimeout}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			app.background(func() {
+				err := app.sendDueWebhooks(client)
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"job": "webhook-delivery"})
+				}
+			})
+		}
+	}()
+}
+
+// sendDueWebhooks claims a batch of due deliveries, sends them concurrently and records the
+// outcome of each.
+func (app *application) sendDueWebhooks(client *http.Client) error {
+	// The lease has to outlast the slowest possible delivery, so that a delivery being sent is
+	// never claimed a second time.
+	deliveries, err := app.models.Deliveries.ClaimDue(webhookDeliveryBatch, 2*webhookDeliveryTimeout)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+
+	for _, d := range deliveries {
+		wg.Add(1)
+
+		go func(d *data.WebhookDelivery) {
+			defer wg.Done()
+			defer app.recoverBackgroundPanic(map[string]string{"job": "webhook-delivery", "delivery_id": strconv.FormatInt(d.ID, 10)})
+
+			status, err := sendWebhook(client, d, time.Now())
+			recordWebhookAttempt(d, status, err, time.Now())
+
+			err = app.models.Deliveries.RecordAttempt(d)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"delivery_id": strconv.FormatInt(d.ID, 10)})
+			}
+		}(d)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// sendWebhook POSTs a delivery to its subscription's URL and returns the response status code.
+// Any status code other than 2xx is returned as an error.
+func sendWebhook(client *http.Client, d *data.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, d.EventType)
+	req.Header.Set(webhookDeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(webhookMAC(d.Secret, timestamp, d.Payload)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Read (some of) the body, so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// recordWebhookAttempt updates a delivery with the outcome of an attempt made at now. Failed
+// deliveries stay pending, and are retried after webhookRetryDelay, until they have been
+// attempted maxWebhookAttempts times.
+func recordWebhookAttempt(d *data.WebhookDelivery, status int, err error, now time.Time) {
+	d.Attempts++
+	d.ResponseStatus = nil
+	d.NextAttemptAt = nil
+	d.Error = ""
+
+	if status != 0 {
+		d.ResponseStatus = &status
+	}
+
+	switch {
+	case err == nil:
+		d.Status = data.DeliveryDelivered
+	case d.Attempts >= maxWebhookAttempts:
+		d.Status = data.DeliveryFailed
+		d.Error = err.Error()
+	default:
+		d.Status = data.DeliveryPending
+		d.Error = err.Error()
+		next := now.Add(webhookRetryDelay(d.Attempts))
+		d.NextAttemptAt = &next
+	}
+}
+
+// webhookRetryDelay returns how long to wait before retrying a delivery which has failed
+// attempts times.
+func webhookRetryDelay(attempts int) time.Duration {
+	return backoffDelay(attempts, webhookRetryBase, webhookRetryMax)
+}
+
+// createWebhookSubscriptionHandler handles "POST /v1/webhooks". The response includes the
+// secret used to sign the deliveries, which is not shown again.
+func (app *application) createWebhookSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	// Subscriptions are created by integrations, which often send more than these fields.
+	err := app.readJSONWithLimits(w, r, &input, app.config.json.withIgnoredUnknownFields())
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	sub := &data.WebhookSubscription{
+		UserID: app.contextGetUser(r).ID,
+		URL:    input.URL,
+		Events: input.Events,
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhookSubscription(v, sub); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Subscriptions.Insert(sub)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", sub.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"subscription": sub, "secret": sub.Secret}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listWebhookSubscriptionsHandler handles "GET /v1/webhooks" and returns the subscriptions of
+// the user.
+func (app *application) listWebhookSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	subs, err := app.models.Subscriptions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"subscriptions": subs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteWebhookSubscriptionHandler handles "DELETE /v1/webhooks/:id". Pending deliveries to
+// the subscription are dropped along with it.
+func (app *application) deleteWebhookSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := app.readWebhookSubscriptionFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Subscriptions.Delete(sub.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "webhook subscription successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listWebhookDeliveriesHandler handles "GET /v1/webhooks/:id/deliveries" and returns the latest
+// deliveries to the subscription, newest first. The "limit" query string parameter sets how
+// many, between 1 and 100 (default 50).
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := app.readWebhookSubscriptionFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	limit := app.readIntRange(r.URL.Query(), "limit", 50, 1, 100, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, err := app.models.Deliveries.GetAllForSubscription(sub.ID, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"deliveries": deliveries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readWebhookSubscriptionFromPath fetches the subscription named by the ":id" URL parameter.
+// Subscriptions of other users are reported as not found. If the subscription can't be
+// returned an error response is sent and false is returned.
+func (app *application) readWebhookSubscriptionFromPath(w http.ResponseWriter, r *http.Request) (*data.WebhookSubscription, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	sub, err := app.models.Subscriptions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if sub.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return sub, true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestWebhookRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{9, 128 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := webhookRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("webhookRetryDelay(%d) = %v; want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRecordWebhookAttempt(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	d := &data.WebhookDelivery{Status: data.DeliveryPending}
+	recordWebhookAttempt(d, http.StatusServiceUnavailable, errors.New("unavailable"), now)
+
+	if d.Status != data.DeliveryPending || d.Attempts != 1 || d.Error == "" {
+		t.Errorf("after a failure: got status %q, %d attempts, error %q", d.Status, d.Attempts, d.Error)
+	}
+	if d.NextAttemptAt == nil || !d.NextAttemptAt.Equal(now.Add(webhookRetryBase)) {
+		t.Errorf("after a failure: got next attempt %v; want %v", d.NextAttemptAt, now.Add(webhookRetryBase))
+	}
+	if d.ResponseStatus == nil || *d.ResponseStatus != http.StatusServiceUnavailable {
+		t.Errorf("after a failure: got response status %v", d.ResponseStatus)
+	}
+
+	recordWebhookAttempt(d, http.StatusOK, nil, now)
+
+	if d.Status != data.DeliveryDelivered || d.Attempts != 2 || d.Error != "" || d.NextAttemptAt != nil {
+		t.Errorf("after a success: got status %q, %d attempts, error %q, next attempt %v", d.Status, d.Attempts, d.Error, d.NextAttemptAt)
+	}
+
+	d = &data.WebhookDelivery{Status: data.DeliveryPending, Attempts: maxWebhookAttempts - 1}
+	recordWebhookAttempt(d, 0, errors.New("connection refused"), now)
+
+	if d.Status != data.DeliveryFailed || d.NextAttemptAt != nil || d.ResponseStatus != nil {
+		t.Errorf("after the last attempt: got status %q, next attempt %v, response status %v", d.Status, d.NextAttemptAt, d.ResponseStatus)
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	now := time.Now()
+	payload := []byte(`{"id":"abc","type":"movie.created","data":{}}`)
+
+	var verifyErr error
+	var event string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verifyErr = verifyWebhookSignature("s3cr3t", r.Header.Get(webhookTimestampHeader), r.Header.Get(webhookSignatureHeader), body, now)
+		event = r.Header.Get(webhookEventHeader)
+
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	d := &data.WebhookDelivery{ID: 1, EventType: data.EventMovieCreated, Payload: payload, URL: ts.URL, Secret: "s3cr3t"}
+
+	status, err := sendWebhook(ts.Client(), d, now)
+	if status != http.StatusOK || err != nil {
+		t.Fatalf("got %d, %v; want 200, nil", status, err)
+	}
+	if verifyErr != nil {
+		t.Errorf("receiver rejected the signature: %v", verifyErr)
+	}
+	if event != data.EventMovieCreated {
+		t.Errorf("got event header %q; want %q", event, data.EventMovieCreated)
+	}
+
+	d.URL = ts.URL + "/fail"
+
+	status, err = sendWebhook(ts.Client(), d, now)
+	if status != http.StatusInternalServerError || err == nil {
+		t.Errorf("got %d, %v; want 500 and an error", status, err)
+	}
+}
@@ -1,10 +1,22 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/events"
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
 )
 
 // Define a custom testServer type which anonymously embeds a httptest.Server instance.
@@ -18,6 +30,7 @@ func newTestApp() *application {
 	app := new(application)
 	cfg := config{env: "testing"}
 	app.config = cfg
+	app.events = events.NewBus(eventHistorySize, eventBufferSize)
 
 	return app
 }
@@ -58,3 +71,123 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, []byt
 
 	return rs.StatusCode, rs.Header, body
 }
+
+// openTestSchema creates a fresh schema in the database GREENLIGHT_TEST_DB_DSN points to,
+// migrates it and returns a connection pool using it. The schema is dropped when the test ends.
+// The test is skipped when GREENLIGHT_TEST_DB_DSN isn't set.
+func openTestSchema(t *testing.T) *sql.DB {
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN isn't set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("greenlight_test_%d", time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema+",public")
+	u.RawQuery = query.Encode()
+
+	open := func() *sql.DB {
+		db, err := sql.Open("postgres", u.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+
+	// runMigrations closes the pool it is given.
+	err = runMigrations(open(), jsonlog.NewLogger(io.Discard, jsonlog.LevelOff), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := open()
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newDBTestApp returns a test application whose models use a fresh schema, see openTestSchema.
+func newDBTestApp(t *testing.T) *application {
+	app := newTestApp()
+	app.logger = jsonlog.NewLogger(io.Discard, jsonlog.LevelOff)
+	app.models = data.NewModels(openTestSchema(t), nil)
+
+	return app
+}
+
+// insertTestUser inserts an activated user holding the given permissions.
+func insertTestUser(t *testing.T, app *application, name string, permissions ...string) *data.User {
+	user := &data.User{Name: name, Email: strings.ToLower(name) + "@example.com", Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(permissions) > 0 {
+		err = app.models.Permissions.AddForUser(user.ID, permissions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return user
+}
+
+// insertTestMovie inserts a movie in the shared catalogue and returns its id.
+func insertTestMovie(t *testing.T, app *application, title string) int64 {
+	movie := &data.Movie{Title: title, Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+
+	err := app.models.Movies.Insert(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return movie.ID
+}
+
+// serveAs calls handler with a request made by user, with the given router parameters and JSON
+// body, and returns the response status code and body.
+func serveAs(t *testing.T, app *application, handler http.HandlerFunc, user *data.User, method string, params httprouter.Params, body string) (int, string) {
+	t.Helper()
+
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r = withParams(r, params)
+	r = app.contextSetUser(r, user)
+
+	rr := httptest.NewRecorder()
+	handler(rr, r)
+
+	return rr.Code, rr.Body.String()
+}
+
+// withParams returns a copy of the request carrying the router parameters, as httprouter adds
+// them.
+func withParams(r *http.Request, params httprouter.Params) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+}
@@ -52,31 +52,18 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	// Otherwise, create a new activation token.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	// Otherwise, create a new activation token and email it to the user through the outbox.
+	err = app.newTokenWithEmail(user, 3*24*time.Hour, data.ScopeActivation, "token_activation.tmpl", "activationToken")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Email the user with their additional activation token in a background goroutine.
-	app.background(func() {
-		data := map[string]interface{}{
-			"activationToken": token.Plaintext,
-		}
-
-		// Since email addresses MAY be case sensitive, notice that we are sending this
-		// email using the address stored in our database for the user --- not to the
-		// input.Email address provided by the client in this request.
-		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
-	})
+	app.recordAuthEvent(r, user, data.AuthEvent{Type: data.AuthEventTokenIssued, Success: true, Reason: data.ScopeActivation})
 
 	// Send a 202 Accepted response and confirmation message to the client.
 	env := envelope{"message": "an email will be sent to you containing activation instructions"}
-	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -116,6 +103,8 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			failedLogins.Add(loginFailureUnknownEmail, 1)
+			app.recordAuthEvent(r, nil, data.AuthEvent{Email: input.Email, Type: data.AuthEventLogin, Reason: loginFailureUnknownEmail})
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -133,6 +122,8 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// If the passwords don't match, then call the app.invalidCredentialsResponse() helper
 	// and return
 	if !match {
+		failedLogins.Add(loginFailureWrongPassword, 1)
+		app.recordAuthEvent(r, user, data.AuthEvent{Type: data.AuthEventLogin, Reason: loginFailureWrongPassword})
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
@@ -145,8 +136,10 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	app.recordAuthEvent(r, user, data.AuthEvent{Type: data.AuthEventLogin, Success: true})
+
 	// Encode the token to JSON and send it in the response along with a 201 Created status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
 
 	// after encoding the token to JSON, it will look like this:
 	// {
@@ -207,29 +200,19 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		return
 	}
 
-	// Otherwise, create a new password reset token with a 45-minute expiry time.
-	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+	// Otherwise, create a new password reset token with a 45-minute expiry time and email it
+	// to the user through the outbox.
+	err = app.newTokenWithEmail(user, 45*time.Minute, data.ScopePasswordReset, "token_password_reset.tmpl", "passwordResetToken")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Email the user with their password reset token.
-	app.background(func() {
-		data := map[string]interface{}{
-			"passwordResetToken": token.Plaintext}
-		// Since email addresses MAY be case sensitive, notice that we are sending this
-		// email using the address stored in our database for the user --- not to the
-		// input.Email address provided by the client in this request.
-		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
-	})
+	app.recordAuthEvent(r, user, data.AuthEvent{Type: data.AuthEventTokenIssued, Success: true, Reason: data.ScopePasswordReset})
 
 	// Send a 202 Accepted response and confirmation message to the client.
 	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
-	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -265,6 +248,7 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.recordAuthEvent(r, nil, data.AuthEvent{Type: data.AuthEventPasswordReset, Reason: authEventInvalidToken})
 			v.AddError("token", "invalid or expired password reset token")
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
@@ -300,9 +284,11 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 		return
 	}
 
+	app.recordAuthEvent(r, user, data.AuthEvent{Type: data.AuthEventPasswordReset, Success: true})
+
 	// Send the user a confirmation message.
 	env := envelope{"message": "your password was successfully reset"}
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -352,3 +338,70 @@ to perform a database lookup to find out who a request is coming from.
 
 
 */
+
+// createTrialTokenHandler handles "POST /v1/tokens/trial". It issues a short-lived, read-only
+// token that doesn't belong to any account, so that prospective integrators can try the API
+// straight from the documentation examples. The number of trial tokens per client IP is capped,
+// and requests made with them go through the much stricter trialRateLimit() limiter.
+func (app *application) createTrialTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.trial.enabled {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ip := app.trialClientIP(r)
+
+	token, err := app.models.TrialTokens.New(ip, app.config.trial.ttl, time.Now().Add(-24*time.Hour), app.config.trial.maxPerIP)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTrialLimit):
+			app.countRateLimitRejection(r)
+			app.errorResponse(w, r, http.StatusTooManyRequests,
+				"trial token limit reached for your IP address, please create an account")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"trial_token": token,
+		"permissions": data.TrialPermissions,
+	}
+
+	err = app.writeResponse(w, r, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// newTokenWithEmail creates a new token for a user and writes the email which sends it to them
+// to the outbox, in a single transaction. tokenKey is the name the email template expects the
+// plaintext token under.
+func (app *application) newTokenWithEmail(user *data.User, ttl time.Duration, scope, template, tokenKey string) error {
+	tx, err := app.models.Begin(3 * time.Second)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	token, err := tx.NewToken(user.ID, ttl, scope)
+	if err != nil {
+		return err
+	}
+
+	// Since email addresses MAY be case sensitive, notice that we are sending this
+	// email using the address stored in our database for the user --- not to the
+	// input.Email address provided by the client in this request.
+	msg, err := data.NewOutboxEmail(user.Email, template, map[string]interface{}{tokenKey: token.Plaintext})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Enqueue(msg)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCreateTrialTokenDisabled(t *testing.T) {
+	app := newTestApp()
+
+	rr := httptest.NewRecorder()
+	app.createTrialTokenHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/tokens/trial", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("got %d with trial tokens disabled; want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestCreateTrialTokenCap(t *testing.T) {
+	app := newDBTestApp(t)
+	app.config.trial.enabled = true
+	app.config.trial.ttl = time.Hour
+	app.config.trial.maxPerIP = 2
+
+	issue := func(remoteAddr, forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodPost, "/v1/tokens/trial", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+
+		rr := httptest.NewRecorder()
+		app.createTrialTokenHandler(rr, r)
+		return rr.Code
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		want         int
+	}{
+		{"first", "203.0.113.7:1000", "198.51.100.1", http.StatusCreated},
+		{"second", "203.0.113.7:1001", "198.51.100.2", http.StatusCreated},
+		{"over the cap with another X-Forwarded-For", "203.0.113.7:1002", "198.51.100.3", http.StatusTooManyRequests},
+		{"another client", "203.0.113.8:1000", "198.51.100.1", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		if got := issue(tt.remoteAddr, tt.forwardedFor); got != tt.want {
+			t.Errorf("%s: got %d; want %d", tt.name, got, tt.want)
+		}
+	}
+}
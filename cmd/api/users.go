@@ -51,8 +51,20 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// ** Transactional outbox
+	// The user, their permissions, their activation token and the welcome email are all written
+	// in a single transaction. The email is only sent by the outbox dispatcher once the
+	// transaction has committed, and retried until it succeeds, so that a crash or a restart at
+	// an unlucky moment can't leave a new user without their welcome email, see outbox.go.
+	tx, err := app.models.Begin(5 * time.Second)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
 	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	err = tx.InsertUser(user)
 	if err != nil {
 		switch {
 		// If we get an ErrDuplicateEmail error, use the v.AddError() method to manually add
@@ -68,7 +80,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Add the "movies:read" permission for the new user.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	err = tx.AddPermissionsForUser(user.ID, "movies:read")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -76,46 +88,40 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	// After the user record has been created in the database, generate a new activation
 	// token for the user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := tx.NewToken(user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// ** Graceful Shutdown of Background Tasks
-	// When we initiate a graceful shutdown of our application, it won’t wait for any
-	// background goroutines that we’ve launched to complete. So — if we happen to shutdown
-	// our server at an unlucky moment — it’s possible that a new client will be created on our
-	// system but they will never be sent their welcome email.
+	// Write the welcome email, with the data for the welcome email template, to the outbox.
+	msg, err := data.NewOutboxEmail(user.Email, "user_welcome.tmpl", map[string]interface{}{
+		"activationToken": token.Plaintext,
+		"userID":          user.ID,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	// We can prevent this by using Go’s sync.WaitGroup functionality to coordinate the
-	// graceful shutdown and our background goroutines.
+	err = tx.Enqueue(msg)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	// Launch a goroutine which runs an anonymous function that sends the welcome email using
-	// the background helper function.
-	app.background(func() {
-		// Create map to act as a 'holding structure' for the data we send to the weclome email
-		// template.
-		data := map[string]interface{}{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-		}
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-		// Call the Send() method on our Mailer, passing in the user's email address, name of the
-		// template file, and the data map containing the activationToken and the user's ID.
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			// Importantly, if there is an error sending the email then we log the error
-			// instead of raising a server error like before when we handled
-			// the email send functionality without a goroutine
-			app.logger.PrintError(err, nil)
-		}
-	})
+	app.recordAuthEvent(r, user, data.AuthEvent{Type: data.AuthEventTokenIssued, Success: true, Reason: data.ScopeActivation})
 
 	// Note that we also change this to send the client a 202 Accepted status code which
 	// indicates that the request has been accepted for processing, but the processing has
 	// not been completed.
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"user": user, "_links": app.userLinks(user.ID, user.Activated)}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -150,6 +156,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.recordAuthEvent(r, nil, data.AuthEvent{Type: data.AuthEventActivation, Reason: authEventInvalidToken})
 			v.AddError("token", "invalid or expired activation token")
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
@@ -181,7 +188,12 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	app.recordAuthEvent(r, user, data.AuthEvent{Type: data.AuthEventActivation, Success: true})
+
+	// Subscribers are told which user was activated, but not the user's email address.
+	app.publishEvent(data.EventUserActivated, envelope{"user": envelope{"id": user.ID, "name": user.Name, "created_at": user.CreatedAt}})
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user": user, "_links": app.userLinks(user.ID, user.Activated)}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
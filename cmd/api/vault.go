@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/jsonlog"
+	"github.com/saalikmubeen/greenlight/internal/secrets"
+)
+
+// minLeaseRetry is the shortest wait before retrying a failed lease renewal.
+const minLeaseRetry = 5 * time.Second
+
+// loadSecrets reads the database and SMTP credentials from provider into cfg, from the paths
+// set with -vault-db-path and -vault-smtp-path. It returns the secrets which have a renewable
+// lease, to be kept renewed with renewLease for as long as the credentials are in use.
+func loadSecrets(ctx context.Context, provider secrets.Provider, cfg *config) ([]*secrets.Secret, error) {
+	var leased []*secrets.Secret
+
+	if cfg.vault.dbPath != "" {
+		secret, err := provider.Read(ctx, cfg.vault.dbPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if dsn, ok := secret.Data["dsn"]; ok {
+			cfg.db.dsn = dsn
+		} else if username, password := secret.Data["username"], secret.Data["password"]; username != "" && password != "" {
+			cfg.db.dsn, err = withCredentials(cfg.db.dsn, username, password)
+			if err != nil {
+				return nil, fmt.Errorf("db-dsn: %w", err)
+			}
+			if cfg.db.readDSN != "" {
+				cfg.db.readDSN, err = withCredentials(cfg.db.readDSN, username, password)
+				if err != nil {
+					return nil, fmt.Errorf("db-read-dsn: %w", err)
+				}
+			}
+		} else {
+			return nil, fmt.Errorf("%s: %s has neither a dsn nor a username and a password", provider.Name(), secret.Path)
+		}
+
+		if secret.Renewable && secret.LeaseID != "" {
+			leased = append(leased, secret)
+		}
+	}
+
+	if cfg.vault.smtpPath != "" {
+		secret, err := provider.Read(ctx, cfg.vault.smtpPath)
+		if err != nil {
+			return nil, err
+		}
+
+		username, password := secret.Data["username"], secret.Data["password"]
+		if username == "" || password == "" {
+			return nil, fmt.Errorf("%s: %s must have a username and a password", provider.Name(), secret.Path)
+		}
+		cfg.smtp.username, cfg.smtp.password = username, password
+
+		if secret.Renewable && secret.LeaseID != "" {
+			leased = append(leased, secret)
+		}
+	}
+
+	return leased, nil
+}
+
+// withCredentials returns the PostgreSQL connection string dsn, either a URL or key=value pairs,
+// with its user and password replaced.
+func withCredentials(dsn, username, password string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		u.User = url.UserPassword(username, password)
+		return u.String(), nil
+	}
+
+	// A key given twice takes its last value.
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+	}
+	return fmt.Sprintf("%s user=%s password=%s", dsn, quote(username), quote(password)), nil
+}
+
+// renewLease keeps the lease of secret renewed until ctx is done, renewing it for its original
+// duration once two thirds of the current one have passed. A failed renewal is retried until
+// the lease expires. The store caps the renewals at the maximum lifetime of the lease, after
+// which the credentials are revoked; the warning logged as it draws near is the cue to restart
+// the server with new ones.
+func renewLease(ctx context.Context, provider secrets.Provider, secret *secrets.Secret, logger *jsonlog.Logger) {
+	properties := map[string]string{"provider": provider.Name(), "path": secret.Path}
+	expiry := time.Now().Add(secret.LeaseDuration)
+	wait := secret.LeaseDuration * 2 / 3
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		duration, err := provider.Renew(ctx, secret.LeaseID, secret.LeaseDuration)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.PrintError(fmt.Errorf("renewing lease: %w", err), properties)
+
+			wait = time.Until(expiry) / 3
+			if wait < minLeaseRetry {
+				logger.PrintWarn("secret lease expired", properties)
+				return
+			}
+			continue
+		}
+
+		expiry = time.Now().Add(duration)
+		wait = duration * 2 / 3
+		if duration < secret.LeaseDuration {
+			logger.PrintWarn("secret lease nearing its maximum lifetime", map[string]string{
+				"provider":   provider.Name(),
+				"path":       secret.Path,
+				"expires_at": expiry.UTC().Format(time.RFC3339),
+			})
+		}
+		if duration < minLeaseRetry {
+			return
+		}
+	}
+}
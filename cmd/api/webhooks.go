@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// Inbound webhooks are signed by the sender with a secret shared per provider. The signature
+// header holds "sha256=" followed by the hex-encoded HMAC-SHA256 of the timestamp header, a
+// dot and the raw request body. Deliveries with a timestamp further than webhookTolerance from
+// our clock are rejected, which together with the event id check stops replayed deliveries.
+const (
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookTolerance       = 5 * time.Minute
+	webhookMaxBytes        = 1_048_576
+)
+
+var (
+	errWebhookSignature = errors.New("invalid webhook signature")
+	errWebhookTimestamp = errors.New("webhook timestamp is missing or outside the allowed tolerance")
+)
+
+// webhookProcessor processes a single event for a provider. It runs in the background after
+// the delivery has been acknowledged, so any error is recorded against the event rather than
+// returned to the sender.
+type webhookProcessor func(event *data.WebhookEvent) error
+
+// webhookProcessors returns the processor for each provider we accept webhooks from. A
+// provider also needs a secret in the -webhook-secrets flag before its endpoint is enabled.
+func (app *application) webhookProcessors() map[string]webhookProcessor {
+	return map[string]webhookProcessor{
+		"smtp":     app.processMailEvent,
+		"metadata": app.processMetadataEvent,
+	}
+}
+
+// receiveWebhookHandler handles "POST /v1/integrations/:provider/webhook". The body must be a
+// JSON object with a unique "id", a "type" and the event "data". Valid events are recorded and
+// acknowledged with a 202 Accepted response, then processed in the background. Events that were
+// already received are acknowledged with a 200 OK response without being processed again.
+func (app *application) receiveWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	provider := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+
+	process, ok := app.webhookProcessors()[provider]
+	secret := app.config.webhooks.secrets[provider]
+	if !ok || secret == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBytes))
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", webhookMaxBytes))
+		return
+	}
+
+	err = verifyWebhookSignature(secret, r.Header.Get(webhookTimestampHeader), r.Header.Get(webhookSignatureHeader), body, time.Now())
+	if err != nil {
+		app.errorResponse(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var input struct {
+		ID   string          `json:"id"`
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+
+	err = json.Unmarshal(body, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("body contains badly-formed JSON"))
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.ID != "", "id", "must be provided")
+	v.Check(len(input.ID) <= 200, "id", "must not be more than 200 bytes long")
+	v.Check(input.Type != "", "type", "must be provided")
+	v.Check(len(input.Data) > 0, "data", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	event := &data.WebhookEvent{
+		Provider:  provider,
+		EventID:   input.ID,
+		EventType: input.Type,
+		Payload:   input.Data,
+	}
+
+	err = app.models.Webhooks.Insert(event)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateWebhookEvent):
+			err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "event already received"}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		procErr := process(event)
+		if procErr != nil {
+			app.logger.PrintError(procErr, map[string]string{
+				"provider": event.Provider,
+				"event_id": event.EventID,
+			})
+		}
+
+		err := app.models.Webhooks.Finish(event.Provider, event.EventID, procErr)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"message": "event accepted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyWebhookSignature checks the timestamp and signature headers of a webhook delivery.
+func verifyWebhookSignature(secret, timestamp, signature string, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errWebhookTimestamp
+	}
+
+	age := now.Sub(time.Unix(ts, 0))
+	if age > webhookTolerance || age < -webhookTolerance {
+		return errWebhookTimestamp
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return errWebhookSignature
+	}
+
+	// hmac.Equal() compares in constant time, so the comparison doesn't leak how much of a
+	// forged signature was correct.
+	if !hmac.Equal(got, webhookMAC(secret, timestamp, body)) {
+		return errWebhookSignature
+	}
+
+	return nil
+}
+
+// webhookMAC returns the HMAC-SHA256 of the timestamp, a dot and the body. It is used to verify
+// inbound webhooks, and to sign the deliveries of our own outbound webhooks in the same way.
+func webhookMAC(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// processMailEvent handles events from our SMTP provider. Addresses that hard bounce or whose
+// owner marks our mail as spam are added to the suppression list, so we stop sending to them.
+// Other event types are ignored.
+func (app *application) processMailEvent(event *data.WebhookEvent) error {
+	switch event.EventType {
+	case "bounce", "complaint":
+	default:
+		return nil
+	}
+
+	var payload struct {
+		Email  string `json:"email"`
+		Reason string `json:"reason"`
+	}
+
+	err := json.Unmarshal(event.Payload, &payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.Email == "" {
+		return errors.New("mail event has no email address")
+	}
+
+	reason := event.EventType
+	if payload.Reason != "" {
+		reason += ": " + payload.Reason
+	}
+
+	return app.models.Suppressed.Insert(payload.Email, reason)
+}
+
+// processMetadataEvent handles "movie.updated" events from an external metadata source, which
+// carry a movie id and the fields that changed. The movie is validated in the same way as an
+// update through the API. Other event types are ignored.
+func (app *application) processMetadataEvent(event *data.WebhookEvent) error {
+	if event.EventType != "movie.updated" {
+		return nil
+	}
+
+	var payload struct {
+		MovieID       int64               `json:"movie_id"`
+		Title         *string             `json:"title"`
+		Year          *int32              `json:"year"`
+		Runtime       *data.Runtime       `json:"runtime"`
+		Genres        []string            `json:"genres"`
+		Certification *data.Certification `json:"certification"`
+	}
+
+	err := json.Unmarshal(event.Payload, &payload)
+	if err != nil {
+		return err
+	}
+
+	movie, err := app.models.Movies.Primary().Get(payload.MovieID)
+	if err != nil {
+		return fmt.Errorf("movie %d: %w", payload.MovieID, err)
+	}
+
+	if payload.Title != nil {
+		movie.Title = *payload.Title
+	}
+	if payload.Year != nil {
+		movie.Year = *payload.Year
+	}
+	if payload.Runtime != nil {
+		movie.Runtime = *payload.Runtime
+	}
+	if payload.Genres != nil {
+		movie.Genres = payload.Genres
+	}
+	if payload.Certification != nil {
+		movie.Certification = *payload.Certification
+	}
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return fmt.Errorf("movie %d failed validation: %v", movie.ID, v.Errors)
+	}
+
+	err = app.models.Movies.Update(movie)
+	if err != nil {
+		return err
+	}
+
+	app.publishEvent(data.EventMovieUpdated, envelope{"movie": movie})
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"id":"evt_1","type":"bounce","data":{}}`)
+
+	sign := func(secret, timestamp string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	ts := strconv.FormatInt(now.Unix(), 10)
+	old := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		want      error
+	}{
+		{"valid", ts, sign("secret", ts), nil},
+		{"wrong secret", ts, sign("other", ts), errWebhookSignature},
+		{"missing prefix", ts, sign("secret", ts)[len("sha256="):], errWebhookSignature},
+		{"stale timestamp", old, sign("secret", old), errWebhookTimestamp},
+		{"missing timestamp", "", sign("secret", ""), errWebhookTimestamp},
+	}
+
+	for _, tt := range tests {
+		err := verifyWebhookSignature("secret", tt.timestamp, tt.signature, body, now)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: want %v; got %v", tt.name, tt.want, err)
+		}
+	}
+}
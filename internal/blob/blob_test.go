@@ -0,0 +1,42 @@
+package blob
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a/2.json", "a/1.json", "b/1.json"} {
+		if err := store.Put(key, []byte(key)); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+
+	got, err := store.Get("a/1.json")
+	if err != nil || string(got) != "a/1.json" {
+		t.Errorf("Get(a/1.json) = %q, %v", got, err)
+	}
+
+	_, err = store.Get("a/3.json")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(a/3.json) error = %v; want ErrNotFound", err)
+	}
+
+	keys, err := store.List("a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/1.json", "a/2.json"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List(a/) = %v; want %v", keys, want)
+	}
+
+	if err := store.Put("../escape.json", nil); err == nil {
+		t.Error("Put(../escape.json) succeeded; want error")
+	}
+}
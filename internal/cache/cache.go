@@ -0,0 +1,266 @@
+// Package cache implements a cache of HTTP responses. Handlers wrapped with Cache.Middleware
+// have their successful responses stored under a key chosen by the caller, and served from the
+// cache until they expire or are invalidated.
+//
+// Every response is stored with a list of tags, such as the ids of the records it was made
+// from, so that when a record changes the caller can drop exactly the responses which included
+// it with Invalidate.
+//
+// The responses are kept in a Store: Memory keeps them in the process, and Redis keeps them in
+// a Redis server shared by every instance of the application.
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// storeDownTime is how long the cache is bypassed after its store returned an error.
+const storeDownTime = 30 * time.Second
+
+// Response is a stored HTTP response.
+type Response struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"stored_at"`
+}
+
+// Store holds the cached responses.
+type Store interface {
+	// Get returns the unexpired response stored under key. ok is false if there is none.
+	Get(key string) (resp *Response, ok bool, err error)
+	// Set stores resp under key for ttl, together with tags. Nothing is stored if the store
+	// has been invalidated since Generation returned generation.
+	Set(key string, resp *Response, tags []string, ttl time.Duration, generation int64) error
+	// Generation returns a number which changes on every call to Invalidate or Purge.
+	Generation() (int64, error)
+	// Invalidate drops every response stored with any of tags.
+	Invalidate(tags ...string) error
+	// Purge drops every response.
+	Purge() error
+}
+
+// Rule describes how the responses of a handler are cached.
+type Rule struct {
+	// TTL is how long a response is served from the cache. Zero disables caching.
+	TTL time.Duration
+	// Key returns the key a request's response is stored under and the tags it is stored
+	// with. Requests with the same key must get the same response, so the key has to include
+	// everything the response depends on, such as the URL, the user and the negotiated
+	// representation. An empty key bypasses the cache.
+	Key func(r *http.Request) (key string, tags []string)
+	// Hit, if set, is called before a response is served from the cache, with the time it was
+	// stored at.
+	Hit func(r *http.Request, storedAt time.Time)
+}
+
+// Stats counts the lookups made in a cache and the errors returned by its store.
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+// Cache serves responses from a Store. When the store returns an error, e.g. because the Redis
+// server is down, the cache is bypassed for a while and requests go straight to the handlers.
+// Invalidations may have been lost in the meantime, so the store is purged before it's used
+// again. Create a Cache with New; it is safe for concurrent use.
+type Cache struct {
+	store   Store
+	onError func(error)
+	now     func() time.Time
+
+	hits, misses, errors int64
+	// downUntil is the time, in Unix nanoseconds, until which the store isn't used.
+	downUntil int64
+	// stale is 1 when the store has to be purged before it's used again.
+	stale int32
+}
+
+// New returns a cache keeping its responses in store. onError, if not nil, is called with the
+// errors returned by the store.
+func New(store Store, onError func(error)) *Cache {
+	return &Cache{store: store, onError: onError, now: time.Now}
+}
+
+// Middleware serves GET requests from the cache according to rule, and stores the 200 OK
+// responses of next. Other requests and responses pass through untouched. Headers set by
+// middleware outside of this one, such as Cache-Control, aren't stored.
+func (c *Cache) Middleware(rule Rule, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rule.TTL <= 0 || r.Method != http.MethodGet {
+			next(w, r)
+			return
+		}
+
+		key, tags := rule.Key(r)
+		if key == "" || !c.available() {
+			next(w, r)
+			return
+		}
+
+		resp, ok, err := c.store.Get(key)
+		if err != nil {
+			c.fail(err)
+			next(w, r)
+			return
+		}
+		if ok {
+			atomic.AddInt64(&c.hits, 1)
+			if rule.Hit != nil {
+				rule.Hit(r, resp.StoredAt)
+			}
+			writeResponse(w, r, resp)
+			return
+		}
+		atomic.AddInt64(&c.misses, 1)
+
+		generation, err := c.store.Generation()
+		if err != nil {
+			c.fail(err)
+			next(w, r)
+			return
+		}
+
+		rec := &recorder{header: make(http.Header)}
+		next(rec, r)
+
+		resp = &Response{
+			Status:   rec.statusCode(),
+			Header:   rec.header,
+			Body:     rec.body.Bytes(),
+			StoredAt: c.now(),
+		}
+		if resp.Status == http.StatusOK {
+			if err := c.store.Set(key, resp, tags, rule.TTL, generation); err != nil {
+				c.fail(err)
+			}
+		}
+		writeResponse(w, r, resp)
+	}
+}
+
+// Invalidate drops every response stored with any of tags.
+func (c *Cache) Invalidate(tags ...string) {
+	if !c.available() {
+		return
+	}
+	if err := c.store.Invalidate(tags...); err != nil {
+		c.fail(err)
+	}
+}
+
+// Purge drops every response.
+func (c *Cache) Purge() {
+	if !c.available() {
+		return
+	}
+	if err := c.store.Purge(); err != nil {
+		c.fail(err)
+	}
+}
+
+// Stats returns the lookups made and the store errors so far.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+		Errors: atomic.LoadInt64(&c.errors),
+	}
+}
+
+// available reports whether the store can be used, purging it first if it has just come back.
+func (c *Cache) available() bool {
+	if c.now().UnixNano() < atomic.LoadInt64(&c.downUntil) {
+		return false
+	}
+
+	if atomic.CompareAndSwapInt32(&c.stale, 1, 0) {
+		if err := c.store.Purge(); err != nil {
+			c.fail(err)
+			return false
+		}
+	}
+
+	return true
+}
+
+// fail reports err and stops using the store for storeDownTime.
+func (c *Cache) fail(err error) {
+	atomic.AddInt64(&c.errors, 1)
+	atomic.StoreInt64(&c.downUntil, c.now().Add(storeDownTime).UnixNano())
+	atomic.StoreInt32(&c.stale, 1)
+
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
+
+// writeResponse sends resp to w. A request whose If-None-Match header matches the ETag of a
+// 200 OK response gets a 304 Not Modified response instead.
+func writeResponse(w http.ResponseWriter, r *http.Request, resp *Response) {
+	h := w.Header()
+	for name, values := range resp.Header {
+		h[name] = append([]string(nil), values...)
+	}
+
+	if etag := resp.Header.Get("ETag"); resp.Status == http.StatusOK && etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// etagMatches reports whether an If-None-Match header value, a comma-separated list of ETags or
+// "*", matches etag. If-None-Match uses the weak comparison function, so W/ prefixes are ignored.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recorder is the http.ResponseWriter a handler writes a response to be cached into.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
+
+// statusCode returns the status code written by the handler, which is 200 OK if it only wrote
+// a body.
+func (rec *recorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// counter is a handler which responds with the number of times it has been called.
+type counter struct {
+	calls  int
+	status int
+}
+
+func (h *counter) serve(w http.ResponseWriter, r *http.Request) {
+	h.calls++
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, h.calls))
+	if h.status != 0 {
+		w.WriteHeader(h.status)
+	}
+	fmt.Fprint(w, h.calls)
+}
+
+func urlKey(r *http.Request) (string, []string) {
+	return r.URL.String(), []string{"tag:" + r.URL.Path}
+}
+
+func get(handler http.HandlerFunc, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	store := NewMemory(10)
+	c := New(store, nil)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	h := &counter{}
+	handler := c.Middleware(Rule{TTL: time.Minute, Key: urlKey}, h.serve)
+
+	if body := get(handler, "/a").Body.String(); body != "1" {
+		t.Fatalf("got %q; want the handler's response", body)
+	}
+	if w := get(handler, "/a"); w.Body.String() != "1" || w.Header().Get("ETag") != `"1"` {
+		t.Errorf("got %q; want the stored response with its headers", w.Body.String())
+	}
+	if body := get(handler, "/b").Body.String(); body != "2" {
+		t.Errorf("got %q for another key; want a new response", body)
+	}
+	if w := get(handler, "/a", "If-None-Match", `W/"1"`); w.Code != http.StatusNotModified {
+		t.Errorf("got status %d for a matching If-None-Match; want 304", w.Code)
+	}
+
+	now = now.Add(time.Minute)
+	if body := get(handler, "/a").Body.String(); body != "3" {
+		t.Errorf("got %q after the TTL; want a new response", body)
+	}
+
+	if got := c.Stats(); got.Hits != 2 || got.Misses != 3 || store.Len() != 2 {
+		t.Errorf("got %+v with %d entries", got, store.Len())
+	}
+}
+
+func TestMiddlewareSkips(t *testing.T) {
+	h := &counter{}
+	c := New(NewMemory(10), nil)
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"zero TTL":  c.Middleware(Rule{Key: urlKey}, h.serve),
+		"empty key": c.Middleware(Rule{TTL: time.Minute, Key: func(*http.Request) (string, []string) { return "", nil }}, h.serve),
+	} {
+		before := h.calls
+		get(handler, "/a")
+		get(handler, "/a")
+		if h.calls != before+2 {
+			t.Errorf("%s: want every request to reach the handler", name)
+		}
+	}
+
+	h = &counter{status: http.StatusNotFound}
+	handler := c.Middleware(Rule{TTL: time.Minute, Key: urlKey}, h.serve)
+	get(handler, "/missing")
+	if w := get(handler, "/missing"); w.Code != http.StatusNotFound || h.calls != 2 {
+		t.Errorf("got status %d after %d calls; want errors passed through and not stored", w.Code, h.calls)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	store := NewMemory(10)
+	c := New(store, nil)
+	h := &counter{}
+	handler := c.Middleware(Rule{TTL: time.Minute, Key: urlKey}, h.serve)
+
+	get(handler, "/a")
+	get(handler, "/b")
+	c.Invalidate("tag:/a")
+
+	if body := get(handler, "/a").Body.String(); body != "3" {
+		t.Errorf("got %q; want the invalidated entry regenerated", body)
+	}
+	if body := get(handler, "/b").Body.String(); body != "2" {
+		t.Errorf("got %q; want the other entry kept", body)
+	}
+
+	c.Purge()
+	if got := store.Len(); got != 0 {
+		t.Errorf("got %d entries after Purge; want 0", got)
+	}
+}
+
+func TestInvalidateDuringRequest(t *testing.T) {
+	c := New(NewMemory(10), nil)
+	calls := 0
+	handler := c.Middleware(Rule{TTL: time.Minute, Key: urlKey}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// The data changes while the response is being generated.
+		c.Invalidate("tag:/a")
+		fmt.Fprint(w, calls)
+	})
+
+	get(handler, "/a")
+	if get(handler, "/a"); calls != 2 {
+		t.Error("want a response generated across an invalidation not to be stored")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	store := NewMemory(2)
+	c := New(store, nil)
+	h := &counter{}
+	handler := c.Middleware(Rule{TTL: time.Minute, Key: urlKey}, h.serve)
+
+	get(handler, "/a")
+	get(handler, "/b")
+	get(handler, "/a")
+	get(handler, "/c")
+
+	if got := store.Len(); got != 2 {
+		t.Errorf("got %d entries; want 2", got)
+	}
+	if body := get(handler, "/a").Body.String(); body != "1" {
+		t.Errorf("got %q; want the recently used entry kept", body)
+	}
+	if body := get(handler, "/b").Body.String(); body != "4" {
+		t.Errorf("got %q; want the least recently used entry evicted", body)
+	}
+}
+
+// failingStore is a Store which fails every call while down is set.
+type failingStore struct {
+	*Memory
+	down   bool
+	purges int
+}
+
+var errDown = errors.New("store down")
+
+func (s *failingStore) Get(key string) (*Response, bool, error) {
+	if s.down {
+		return nil, false, errDown
+	}
+	return s.Memory.Get(key)
+}
+
+func (s *failingStore) Purge() error {
+	if s.down {
+		return errDown
+	}
+	s.purges++
+	return s.Memory.Purge()
+}
+
+func TestStoreDown(t *testing.T) {
+	store := &failingStore{Memory: NewMemory(10)}
+	var errs []error
+	c := New(store, func(err error) { errs = append(errs, err) })
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	h := &counter{}
+	handler := c.Middleware(Rule{TTL: time.Hour, Key: urlKey}, h.serve)
+	get(handler, "/a")
+
+	store.down = true
+	if body := get(handler, "/a").Body.String(); body != "2" {
+		t.Errorf("got %q while the store is down; want the handler's response", body)
+	}
+	get(handler, "/a")
+	if len(errs) != 1 || h.calls != 3 {
+		t.Errorf("got %d errors after %d calls; want the store bypassed after the first error", len(errs), h.calls)
+	}
+
+	store.down = false
+	now = now.Add(storeDownTime)
+	if body := get(handler, "/a").Body.String(); body != "4" || store.purges != 1 {
+		t.Errorf("got %q after %d purges; want the store purged of responses which may be stale", body, store.purges)
+	}
+	if got := c.Stats(); got.Errors != 1 || got.Hits != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
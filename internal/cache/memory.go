@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// memoryEntry is a response stored in a Memory store.
+type memoryEntry struct {
+	key     string
+	tags    []string
+	resp    *Response
+	expires time.Time
+}
+
+// Memory is a Store which holds up to a fixed number of responses in the process, dropping the
+// least recently used ones when it is full. Create one with NewMemory.
+type Memory struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	// lru holds the entries, the most recently used first.
+	lru *list.List
+	// tags maps every tag to the keys of the entries stored with it.
+	tags map[string]map[string]bool
+	// generation is incremented by every invalidation.
+	generation int64
+	now        func() time.Time
+}
+
+// NewMemory returns a store which holds up to maxEntries responses.
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		tags:       make(map[string]map[string]bool),
+		now:        time.Now,
+	}
+}
+
+// Get returns the unexpired response stored under key.
+func (m *Memory) Get(key string) (*Response, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	e := el.Value.(*memoryEntry)
+	if !m.now().Before(e.expires) {
+		m.remove(el)
+		return nil, false, nil
+	}
+
+	m.lru.MoveToFront(el)
+	return e.resp, true, nil
+}
+
+// Set stores resp under key for ttl, replacing any response with the same key and evicting
+// the least recently used responses if the store is full.
+func (m *Memory) Set(key string, resp *Response, tags []string, ttl time.Duration, generation int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if generation != m.generation || m.maxEntries < 1 {
+		return nil
+	}
+
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	for m.lru.Len() >= m.maxEntries {
+		m.remove(m.lru.Back())
+	}
+
+	m.entries[key] = m.lru.PushFront(&memoryEntry{
+		key:     key,
+		tags:    tags,
+		resp:    resp,
+		expires: m.now().Add(ttl),
+	})
+	for _, tag := range tags {
+		if m.tags[tag] == nil {
+			m.tags[tag] = make(map[string]bool)
+		}
+		m.tags[tag][key] = true
+	}
+
+	return nil
+}
+
+// Generation returns the number of invalidations so far.
+func (m *Memory) Generation() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.generation, nil
+}
+
+// Invalidate drops every response stored with any of tags.
+func (m *Memory) Invalidate(tags ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.generation++
+	for _, tag := range tags {
+		for key := range m.tags[tag] {
+			if el, ok := m.entries[key]; ok {
+				m.remove(el)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Purge drops every response.
+func (m *Memory) Purge() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.generation++
+	m.entries = make(map[string]*list.Element)
+	m.lru.Init()
+	m.tags = make(map[string]map[string]bool)
+
+	return nil
+}
+
+// Len returns the number of responses stored.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lru.Len()
+}
+
+// remove drops the entry held by el. The caller must hold m.mu.
+func (m *Memory) remove(el *list.Element) {
+	e := m.lru.Remove(el).(*memoryEntry)
+	delete(m.entries, e.key)
+
+	for _, tag := range e.tags {
+		delete(m.tags[tag], e.key)
+		if len(m.tags[tag]) == 0 {
+			delete(m.tags, tag)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout is how long a Redis command may take before the cache gives up on it.
+const redisTimeout = 500 * time.Millisecond
+
+// The Redis store keeps every response in a string key holding its JSON encoding, and every
+// tag in a set holding the names of the response keys stored with it. The keys of both start
+// with the prefix and the current epoch, so that Purge only has to increment the epoch: the
+// keys of the previous epoch are never read again and expire on their own.
+var (
+	redisGetScript = redis.NewScript(`
+local epoch = redis.call('GET', KEYS[1]) or '0'
+return redis.call('GET', ARGV[1] .. epoch .. ':response:' .. ARGV[2])
+`)
+
+	redisSetScript = redis.NewScript(`
+if (redis.call('GET', KEYS[2]) or '0') ~= ARGV[5] then
+	return 0
+end
+local epoch = redis.call('GET', KEYS[1]) or '0'
+local key = ARGV[1] .. epoch .. ':response:' .. ARGV[2]
+redis.call('SET', key, ARGV[3], 'PX', ARGV[4])
+for i = 6, #ARGV do
+	local tag = ARGV[1] .. epoch .. ':tag:' .. ARGV[i]
+	redis.call('SADD', tag, key)
+	redis.call('PEXPIRE', tag, ARGV[4])
+end
+return 1
+`)
+
+	redisInvalidateScript = redis.NewScript(`
+redis.call('INCR', KEYS[2])
+local epoch = redis.call('GET', KEYS[1]) or '0'
+for i = 2, #ARGV do
+	local tag = ARGV[1] .. epoch .. ':tag:' .. ARGV[i]
+	for _, key in ipairs(redis.call('SMEMBERS', tag)) do
+		redis.call('DEL', key)
+	end
+	redis.call('DEL', tag)
+end
+return 1
+`)
+)
+
+// Redis is a Store which keeps the responses in a Redis server, so that every instance of the
+// application shares them. Create one with NewRedis.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis returns a store keeping its responses in client, in keys starting with prefix.
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+// Get returns the unexpired response stored under key.
+func (s *Redis) Get(key string) (*Response, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	js, err := redisGetScript.Run(ctx, s.client, s.metaKeys(), s.prefix, key).Text()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	var resp Response
+	err = json.Unmarshal([]byte(js), &resp)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &resp, true, nil
+}
+
+// Set stores resp under key for ttl.
+func (s *Redis) Set(key string, resp *Response, tags []string, ttl time.Duration, generation int64) error {
+	js, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	args := []interface{}{s.prefix, key, js, ttl.Milliseconds(), generation}
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+
+	return redisSetScript.Run(ctx, s.client, s.metaKeys(), args...).Err()
+}
+
+// Generation returns the number of invalidations made through any instance so far.
+func (s *Redis) Generation() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	generation, err := s.client.Get(ctx, s.prefix+"generation").Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return generation, err
+}
+
+// Invalidate drops every response stored with any of tags.
+func (s *Redis) Invalidate(tags ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	args := []interface{}{s.prefix}
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+
+	return redisInvalidateScript.Run(ctx, s.client, s.metaKeys(), args...).Err()
+}
+
+// Purge drops every response, by moving on to the next epoch.
+func (s *Redis) Purge() error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, s.prefix+"epoch")
+		pipe.Incr(ctx, s.prefix+"generation")
+		return nil
+	})
+	return err
+}
+
+// metaKeys returns the keys holding the epoch and the generation, which the scripts expect in
+// that order.
+func (s *Redis) metaKeys() []string {
+	return []string{s.prefix + "epoch", s.prefix + "generation"}
+}
@@ -0,0 +1,104 @@
+// Package codec converts API payloads between JSON and the other encodings the API supports,
+// XML and MessagePack.
+//
+// Payloads are always built as JSON first, so that the custom JSON marshalers (such as the
+// "102 mins" format of data.Runtime) and the json struct tags apply to every encoding. The JSON
+// is then decoded into a generic tree of maps, slices and scalars and re-encoded. Request
+// bodies take the same path in reverse, so handlers only ever decode JSON.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// The media types supported by Marshal and ToJSON.
+const (
+	JSON        = "application/json"
+	XML         = "application/xml"
+	MessagePack = "application/msgpack"
+)
+
+// ErrUnsupported is returned for a media type which isn't supported.
+var ErrUnsupported = errors.New("codec: unsupported media type")
+
+// aliases maps other common names of the supported media types to the names above.
+var aliases = map[string]string{
+	JSON:                      JSON,
+	XML:                       XML,
+	"text/xml":                XML,
+	MessagePack:               MessagePack,
+	"application/x-msgpack":   MessagePack,
+	"application/vnd.msgpack": MessagePack,
+}
+
+// Canonical returns the canonical name of a supported media type, or "" if it isn't supported.
+func Canonical(mediaType string) string {
+	return aliases[mediaType]
+}
+
+// Marshal encodes v as JSON and then converts it to mediaType. indent is only used for JSON and
+// XML.
+func Marshal(mediaType string, v interface{}, indent string) ([]byte, error) {
+	mediaType = Canonical(mediaType)
+	if mediaType == "" {
+		return nil, ErrUnsupported
+	}
+
+	if mediaType == JSON {
+		return json.MarshalIndent(v, "", indent)
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := decodeTree(js)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mediaType {
+	case XML:
+		return encodeXML(tree, indent)
+	default:
+		return encodeMsgpack(tree)
+	}
+}
+
+// ToJSON converts a request body encoded as mediaType to JSON.
+func ToJSON(mediaType string, body []byte) ([]byte, error) {
+	var (
+		tree interface{}
+		err  error
+	)
+
+	switch Canonical(mediaType) {
+	case JSON:
+		return body, nil
+	case XML:
+		tree, err = decodeXML(body)
+	case MessagePack:
+		tree, err = decodeMsgpack(body)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(tree)
+}
+
+// decodeTree decodes JSON into a generic tree, keeping numbers as json.Number so that large
+// integers survive the round trip.
+func decodeTree(js []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var tree interface{}
+	err := dec.Decode(&tree)
+	return tree, err
+}
@@ -0,0 +1,114 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const envelope = `{"movie":{"id":9007199254740993,"title":"Casablanca","year":1942,"rating":8.5,
+	"genres":["drama","romance"],"tags":[],"meta":{},"certification":null,"archived":false,
+	"_links":{"self":{"href":"/v1/movies/1","method":"GET"}},"a b":"<&>","xmlns":"x"},
+	"negatives":[-1,-33,-200,-40000,-3000000000],"positives":[200,70000,5000000000]}`
+
+func TestRoundTrip(t *testing.T) {
+	want, err := decodeTree([]byte(envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON, _ := json.Marshal(want)
+
+	for _, mediaType := range []string{XML, MessagePack, "text/xml", "application/x-msgpack"} {
+		encoded, err := Marshal(mediaType, want, "\t")
+		if err != nil {
+			t.Fatalf("Marshal(%q) error: %v", mediaType, err)
+		}
+
+		got, err := ToJSON(mediaType, encoded)
+		if err != nil {
+			t.Fatalf("ToJSON(%q) error: %v", mediaType, err)
+		}
+
+		if !bytes.Equal(got, wantJSON) {
+			t.Errorf("%s round trip = %s; want %s", mediaType, got, wantJSON)
+		}
+	}
+}
+
+func TestMarshalXML(t *testing.T) {
+	tree := map[string]interface{}{
+		"movie": map[string]interface{}{"title": "Casablanca", "year": json.Number("1942"), "genres": []interface{}{"drama"}},
+	}
+
+	got, err := Marshal(XML, tree, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<response><movie><genres type="array"><item>drama</item></genres>` +
+		`<title>Casablanca</title><year type="number">1942</year></movie></response>`
+	if !strings.HasSuffix(string(got), want) {
+		t.Errorf("Marshal(XML) = %s; want %s", got, want)
+	}
+}
+
+func TestXMLRequestBody(t *testing.T) {
+	body := `<?xml version="1.0"?>
+		<movie>
+			<title>Moana</title>
+			<year type="number">2016</year>
+			<genres type="array"><item>animation</item><item>adventure</item></genres>
+		</movie>`
+
+	got, err := ToJSON(XML, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"genres":["animation","adventure"],"title":"Moana","year":2016}`
+	if string(got) != want {
+		t.Errorf("ToJSON(XML) = %s; want %s", got, want)
+	}
+}
+
+func TestMalformedBodies(t *testing.T) {
+	tests := []struct {
+		name      string
+		mediaType string
+		body      string
+	}{
+		{"empty XML", XML, ""},
+		{"unclosed XML", XML, "<movie><title>Moana</title>"},
+		{"two XML documents", XML, "<a/><b/>"},
+		{"bad XML number", XML, `<year type="number">soon</year>`},
+		{"unknown XML type", XML, `<year type="date">2016</year>`},
+		{"empty MessagePack", MessagePack, ""},
+		{"truncated MessagePack string", MessagePack, "\xa5abc"},
+		{"huge MessagePack array", MessagePack, "\xdd\xff\xff\xff\xff"},
+		{"MessagePack extension", MessagePack, "\xd4\x01\x00"},
+		{"MessagePack integer key", MessagePack, "\x81\x01\x02"},
+		{"trailing MessagePack data", MessagePack, "\x01\x02"},
+		{"MessagePack NaN", MessagePack, "\xcb\x7f\xf8\x00\x00\x00\x00\x00\x01"},
+		{"unsupported type", "text/csv", "a,b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ToJSON(tt.mediaType, []byte(tt.body))
+			if err == nil {
+				t.Errorf("ToJSON(%q, %q) returned no error", tt.mediaType, tt.body)
+			}
+		})
+	}
+}
+
+func TestMessagePackNesting(t *testing.T) {
+	body := bytes.Repeat([]byte{0x91}, maxDepth+2)
+	body = append(body, 0xc0)
+
+	_, err := ToJSON(MessagePack, body)
+	if err == nil {
+		t.Error("ToJSON accepted a body nested deeper than maxDepth")
+	}
+}
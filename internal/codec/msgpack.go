@@ -0,0 +1,323 @@
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// A minimal MessagePack (https://msgpack.org) encoder and decoder for trees. Integers use the
+// smallest encoding that fits, other numbers are encoded as float 64. Decoding accepts every
+// format except extension types, and treats binary data as strings.
+
+var errTruncated = errors.New("codec: MessagePack body is truncated")
+
+func encodeMsgpack(tree interface{}) ([]byte, error) {
+	return appendMsgpack(nil, tree)
+}
+
+func appendMsgpack(b []byte, value interface{}) ([]byte, error) {
+	var err error
+
+	switch v := value.(type) {
+	case nil:
+		b = append(b, 0xc0)
+	case bool:
+		if v {
+			b = append(b, 0xc3)
+		} else {
+			b = append(b, 0xc2)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			b = appendMsgpackInt(b, i)
+			break
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xcb)
+		b = appendUint64(b, math.Float64bits(f))
+	case string:
+		b = appendMsgpackHeader(b, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		b = append(b, v...)
+	case []interface{}:
+		b = appendMsgpackHeader(b, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			if b, err = appendMsgpack(b, item); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b = appendMsgpackHeader(b, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			if b, err = appendMsgpack(b, k); err != nil {
+				return nil, err
+			}
+			if b, err = appendMsgpack(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("codec: unexpected %T in tree", value)
+	}
+
+	return b, nil
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 127:
+		return append(b, byte(i))
+	case i >= -32 && i < 0:
+		return append(b, byte(0xe0|(i+32)))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(b, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return appendUint16(append(b, 0xcd), uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return appendUint32(append(b, 0xce), uint32(i))
+	case i >= 0:
+		return appendUint64(append(b, 0xcf), uint64(i))
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(int8(i)))
+	case i >= math.MinInt16:
+		return appendUint16(append(b, 0xd1), uint16(int16(i)))
+	case i >= math.MinInt32:
+		return appendUint32(append(b, 0xd2), uint32(int32(i)))
+	default:
+		return appendUint64(append(b, 0xd3), uint64(i))
+	}
+}
+
+// appendMsgpackHeader appends the header of a string, array or map of length n. Lengths up to
+// fixMax use the fix format, the others the 8 (if any), 16 or 32-bit formats.
+func appendMsgpackHeader(b []byte, n int, fix byte, fixMax int, f8, f16, f32 byte) []byte {
+	switch {
+	case n <= fixMax:
+		return append(b, fix|byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		return append(b, f8, byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, f16), uint16(n))
+	default:
+		return appendUint32(append(b, f32), uint32(n))
+	}
+}
+
+type msgpackDecoder struct {
+	b []byte
+}
+
+func decodeMsgpack(body []byte) (interface{}, error) {
+	d := &msgpackDecoder{b: body}
+
+	tree, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(d.b) > 0 {
+		return nil, errors.New("codec: MessagePack body must only contain a single value")
+	}
+
+	return tree, nil
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.b) < n {
+		return nil, errTruncated
+	}
+
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+func (d *msgpackDecoder) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("codec: MessagePack body is nested too deeply")
+	}
+
+	tb, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	t := tb[0]
+
+	switch {
+	case t <= 0x7f:
+		return json.Number(strconv.Itoa(int(t))), nil
+	case t >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(t)))), nil
+	case t&0xf0 == 0x80:
+		return d.mapValue(int(t&0x0f), depth)
+	case t&0xf0 == 0x90:
+		return d.arrayValue(int(t&0x0f), depth)
+	case t&0xe0 == 0xa0:
+		return d.stringValue(int(t & 0x1f))
+	}
+
+	switch t {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		return d.sizedString(1)
+	case 0xc5, 0xda:
+		return d.sizedString(2)
+	case 0xc6, 0xdb:
+		return d.sizedString(4)
+	case 0xca:
+		u, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return floatNumber(float64(math.Float32frombits(uint32(u))))
+	case 0xcb:
+		u, err := d.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return floatNumber(math.Float64frombits(u))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.uint(1 << (t - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(u, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (t - 0xd0)
+		u, err := d.uint(n)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend the n-byte value.
+		shift := 64 - 8*n
+		return json.Number(strconv.FormatInt(int64(u<<shift)>>shift, 10)), nil
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayValue(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(int(n), depth)
+	default:
+		return nil, fmt.Errorf("codec: unsupported MessagePack type 0x%02x", t)
+	}
+}
+
+func (d *msgpackDecoder) sizedString(sizeBytes int) (interface{}, error) {
+	n, err := d.uint(sizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	return d.stringValue(int(n))
+}
+
+func (d *msgpackDecoder) stringValue(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) arrayValue(n int, depth int) (interface{}, error) {
+	// Every item takes at least one byte, which stops a bogus length from allocating a huge
+	// slice up front.
+	if n > len(d.b) {
+		return nil, errTruncated
+	}
+
+	array := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, item)
+	}
+	return array, nil
+}
+
+func (d *msgpackDecoder) mapValue(n int, depth int) (interface{}, error) {
+	if n > len(d.b) {
+		return nil, errTruncated
+	}
+
+	object := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		k, ok := key.(string)
+		if !ok {
+			return nil, errors.New("codec: MessagePack map keys must be strings")
+		}
+
+		object[k], err = d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return object, nil
+}
+
+func floatNumber(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errors.New("codec: MessagePack body contains NaN or infinity")
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
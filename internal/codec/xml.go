@@ -0,0 +1,245 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The XML encoding of a tree. The document element is <response>, and each object key becomes a
+// child element of the same name. Keys which aren't valid element names are written as
+// <entry key="...">. Array items are written as <item> elements. Values other than strings and
+// objects carry a type attribute, so that they can be decoded back to the same tree:
+//
+//	<response>
+//		<movie>
+//			<title>Casablanca</title>
+//			<year type="number">1942</year>
+//			<genres type="array">
+//				<item>drama</item>
+//			</genres>
+//		</movie>
+//	</response>
+//
+// The same rules apply to request bodies: elements without a type attribute are objects if
+// they have child elements and strings otherwise.
+
+const maxDepth = 100
+
+var elementNameRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+func encodeXML(tree interface{}, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", indent)
+
+	err := encodeXMLElement(enc, "response", tree)
+	if err != nil {
+		return nil, err
+	}
+
+	err = enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeXMLElement(enc *xml.Encoder, key string, value interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: key}}
+	if !elementNameRX.MatchString(key) || strings.HasPrefix(strings.ToLower(key), "xml") {
+		start.Name.Local = "entry"
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "key"}, Value: key})
+	}
+
+	var text string
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			start.Attr = append(start.Attr, typeAttr("object"))
+		}
+	case []interface{}:
+		start.Attr = append(start.Attr, typeAttr("array"))
+	case string:
+		text = v
+	case json.Number:
+		start.Attr = append(start.Attr, typeAttr("number"))
+		text = v.String()
+	case bool:
+		start.Attr = append(start.Attr, typeAttr("boolean"))
+		text = strconv.FormatBool(v)
+	case nil:
+		start.Attr = append(start.Attr, typeAttr("null"))
+	default:
+		return fmt.Errorf("codec: unexpected %T in tree", value)
+	}
+
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if err := encodeXMLElement(enc, k, v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := encodeXMLElement(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	default:
+		if text != "" {
+			if err := enc.EncodeToken(xml.CharData(text)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+func typeAttr(t string) xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: "type"}, Value: t}
+}
+
+func decodeXML(body []byte) (interface{}, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("codec: XML body has no document element")
+			}
+			return nil, fmt.Errorf("codec: %w", err)
+		}
+
+		if start, ok := tok.(xml.StartElement); ok {
+			tree, err := decodeXMLElement(dec, start, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			// Only comments, processing instructions and whitespace may follow.
+			for {
+				tok, err := dec.Token()
+				if errors.Is(err, io.EOF) {
+					return tree, nil
+				}
+				if err != nil {
+					return nil, fmt.Errorf("codec: %w", err)
+				}
+				if _, ok := tok.(xml.StartElement); ok {
+					return nil, errors.New("codec: XML body has more than one document element")
+				}
+			}
+		}
+	}
+}
+
+func decodeXMLElement(dec *xml.Decoder, start xml.StartElement, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("codec: XML body is nested too deeply")
+	}
+
+	var typ string
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "type" {
+			typ = attr.Value
+		}
+	}
+
+	var (
+		text     strings.Builder
+		object   = map[string]interface{}{}
+		array    = []interface{}{}
+		children int
+	)
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("codec: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			children++
+
+			value, err := decodeXMLElement(dec, t, depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			key := t.Name.Local
+			if key == "entry" {
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "key" {
+						key = attr.Value
+					}
+				}
+			}
+
+			object[key] = value
+			array = append(array, value)
+
+		case xml.CharData:
+			text.Write(t)
+
+		case xml.EndElement:
+			return xmlValue(start.Name.Local, typ, text.String(), object, array, children)
+		}
+	}
+}
+
+func xmlValue(name, typ, text string, object map[string]interface{}, array []interface{}, children int) (interface{}, error) {
+	switch typ {
+	case "array":
+		return array, nil
+	case "object":
+		return object, nil
+	case "number":
+		text = strings.TrimSpace(text)
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return nil, fmt.Errorf("codec: element <%s> must contain a number", name)
+		}
+		return json.Number(text), nil
+	case "boolean":
+		b, err := strconv.ParseBool(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("codec: element <%s> must contain true or false", name)
+		}
+		return b, nil
+	case "null":
+		return nil, nil
+	case "", "string":
+		if typ == "" && children > 0 {
+			return object, nil
+		}
+		return text, nil
+	default:
+		return nil, fmt.Errorf("codec: element <%s> has unknown type %q", name, typ)
+	}
+}
@@ -0,0 +1,294 @@
+// Package configfile reads settings for command-line flags from a YAML or TOML configuration
+// file or from environment variables, so that a deployment can keep its settings in one file
+// or in its environment rather than on the command line.
+//
+// Every setting is named after the flag it sets. Nested mappings (YAML) and tables (TOML) are
+// joined to their keys with hyphens, and underscores are read as hyphens, so these all set the
+// -db-max-open-conns flag:
+//
+//	db-max-open-conns: 25
+//
+//	db:
+//	  max_open_conns: 25
+//
+//	[db]
+//	max-open-conns = 25
+//
+// Lists are joined with spaces, which is how flags with several values, such as
+// -cors-trusted-origins, expect them. Only the subset of YAML and TOML needed for this is
+// supported: mappings, tables, scalars and lists of scalars.
+package configfile
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Setting is a single flag value read from a configuration file.
+type Setting struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+// Error reports every problem found in a configuration file, so that they can all be fixed at
+// once.
+type Error struct {
+	File     string
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Problems) == 1 {
+		return fmt.Sprintf("%s: %s", e.File, e.Problems[0])
+	}
+
+	return fmt.Sprintf("%s: %d problems:\n  %s", e.File, len(e.Problems), strings.Join(e.Problems, "\n  "))
+}
+
+// Load reads the configuration file at path and sets the flags of fs from it. Flags which were
+// set on the command line keep their value, so the command line overrides the file. Settings
+// for flags listed in exclude, such as the flag naming the file itself, are rejected.
+func Load(fs *flag.FlagSet, path string, exclude ...string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	settings, err := Parse(path, string(src))
+	if err != nil {
+		return err
+	}
+
+	return Apply(fs, path, settings, exclude...)
+}
+
+// Parse reads the settings in src, which is YAML or TOML depending on the extension of name.
+func Parse(name, src string) ([]Setting, error) {
+	var (
+		settings []Setting
+		err      error
+	)
+
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml":
+		settings, err = parseYAML(src)
+	case ".toml":
+		settings, err = parseTOML(src)
+	default:
+		return nil, fmt.Errorf("%s: unsupported configuration file format %q (use .yaml, .yml or .toml)", name, ext)
+	}
+
+	if err != nil {
+		return nil, &Error{File: name, Problems: []string{err.Error()}}
+	}
+
+	return settings, nil
+}
+
+// Apply sets the flags of fs from settings, which were read from file. Every problem is
+// reported in the returned *Error: settings for unknown or excluded flags, settings repeated
+// in the file and values that the flag rejects.
+func Apply(fs *flag.FlagSet, file string, settings []Setting, exclude ...string) error {
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	excluded := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+
+	seen := make(map[string]int)
+	cfgErr := &Error{File: file}
+
+	for _, s := range settings {
+		problem := func(format string, args ...interface{}) {
+			cfgErr.Problems = append(cfgErr.Problems, fmt.Sprintf("line %d: ", s.Line)+fmt.Sprintf(format, args...))
+		}
+
+		if excluded[s.Key] {
+			problem("%q can only be set on the command line", s.Key)
+			continue
+		}
+
+		if fs.Lookup(s.Key) == nil {
+			if suggestion := closestFlag(fs, s.Key, excluded); suggestion != "" {
+				problem("unknown setting %q (did you mean %q?)", s.Key, suggestion)
+			} else {
+				problem("unknown setting %q", s.Key)
+			}
+			continue
+		}
+
+		if line, ok := seen[s.Key]; ok {
+			problem("%q is already set on line %d", s.Key, line)
+			continue
+		}
+		seen[s.Key] = s.Line
+
+		if onCommandLine[s.Key] {
+			continue
+		}
+
+		err := fs.Set(s.Key, s.Value)
+		if err != nil {
+			problem("invalid value %q for %q: %v", s.Value, s.Key, err)
+		}
+	}
+
+	if len(cfgErr.Problems) > 0 {
+		return cfgErr
+	}
+
+	return nil
+}
+
+// closestFlag returns the name of the flag in fs which is the fewest edits away from name, or
+// "" if none is close enough to be a likely typo.
+func closestFlag(fs *flag.FlagSet, name string, excluded map[string]bool) string {
+	var candidates []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !excluded[f.Name] {
+			candidates = append(candidates, f.Name)
+		}
+	})
+	sort.Strings(candidates)
+
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// settingKey joins a table or mapping prefix and a key into a flag name.
+func settingKey(prefix, key string) string {
+	key = strings.ReplaceAll(key, "_", "-")
+	if prefix == "" {
+		return key
+	}
+	return prefix + "-" + key
+}
+
+// validKey reports whether key is a bare key: letters, digits, hyphens and underscores.
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// stripComment removes a trailing "#" comment from line, ignoring "#" inside quoted strings.
+func stripComment(line string) string {
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && opensQuote(line, i):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// splitList splits the inside of a "[a, b, c]" list on the commas outside of quoted strings.
+func splitList(s string) []string {
+	var (
+		items []string
+		quote byte
+		start int
+	)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && opensQuote(s, i):
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+
+	return items
+}
+
+// opensQuote reports whether the quote at s[i] starts a quoted string, rather than being part
+// of an unquoted value such as "Greenlight's".
+func opensQuote(s string, i int) bool {
+	return i == 0 || strings.IndexByte(" \t[,:=", s[i-1]) >= 0
+}
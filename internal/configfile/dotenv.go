@@ -0,0 +1,125 @@
+package configfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadDotenv reads the KEY=value lines of the .env file at path into the environment of the
+// process, so that local runs can keep their secrets in a file instead of exporting them.
+// Variables which are already set are left alone, and a missing file is not an error.
+func LoadDotenv(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	vars, err := parseDotenv(string(src))
+	if err != nil {
+		return &Error{File: path, Problems: []string{err.Error()}}
+	}
+
+	for _, v := range vars {
+		if _, ok := os.LookupEnv(v.Key); ok {
+			continue
+		}
+
+		err := os.Setenv(v.Key, v.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseDotenv reads the variables of a .env file: "KEY=value" lines, optionally starting with
+// "export", with single-quoted values taken literally, double-quoted values unescaped and
+// unquoted values ending at a " #" comment.
+func parseDotenv(src string) ([]Setting, error) {
+	var vars []Setting
+
+	for n, raw := range strings.Split(src, "\n") {
+		line := n + 1
+
+		content := strings.TrimSpace(raw)
+		if content == "" || content[0] == '#' {
+			continue
+		}
+
+		content = strings.TrimPrefix(content, "export ")
+
+		key, value, ok := strings.Cut(content, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validEnvName(key) {
+			return nil, fmt.Errorf(`line %d: expected "KEY=value"`, line)
+		}
+
+		value = strings.TrimSpace(value)
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := closingQuote(value)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated double-quoted value", line)
+			}
+
+			v, err := strconv.Unquote(value[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid double-quoted value", line)
+			}
+			value = v
+		case strings.HasPrefix(value, "'"):
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single-quoted value", line)
+			}
+			value = value[1 : end+1]
+		default:
+			value = strings.TrimSpace(stripComment(value))
+		}
+
+		vars = append(vars, Setting{Key: key, Value: value, Line: line})
+	}
+
+	return vars, nil
+}
+
+// closingQuote returns the index of the double quote which closes the string starting at s[0],
+// or -1 if there is none.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// validEnvName reports whether name is a valid environment variable name: letters, digits and
+// underscores, not starting with a digit.
+func validEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
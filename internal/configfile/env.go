@@ -0,0 +1,65 @@
+package configfile
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// EnvName returns the environment variable for the flag name, e.g. "GREENLIGHT_DB_DSN" for
+// "db-dsn" with the prefix "GREENLIGHT".
+func EnvName(prefix, name string) string {
+	return prefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// LoadEnv sets the flags of fs from environment variables named by EnvName. Flags which were
+// set on the command line keep their value, so the command line overrides the environment.
+// Flags listed in exclude are not read from the environment. Every invalid value is reported
+// in the returned *Error.
+//
+// Call LoadEnv before Load, so that the environment also overrides the configuration file.
+func LoadEnv(fs *flag.FlagSet, prefix string, exclude ...string) error {
+	return loadEnv(fs, prefix, os.LookupEnv, exclude...)
+}
+
+func loadEnv(fs *flag.FlagSet, prefix string, lookup func(string) (string, bool), exclude ...string) error {
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	excluded := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !onCommandLine[f.Name] && !excluded[f.Name] {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+
+	envErr := &Error{File: "environment"}
+
+	for _, name := range names {
+		value, ok := lookup(EnvName(prefix, name))
+		if !ok {
+			continue
+		}
+
+		err := fs.Set(name, value)
+		if err != nil {
+			envErr.Problems = append(envErr.Problems, fmt.Sprintf("invalid value %q for %s: %v", value, EnvName(prefix, name), err))
+		}
+	}
+
+	if len(envErr.Problems) > 0 {
+		return envErr
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package configfile
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FileSuffix is appended to the name of a flag to get the name of the flag which reads its
+// value from a file, e.g. -db-dsn-file for -db-dsn.
+const FileSuffix = "-file"
+
+// AddFileFlags registers a flag named by FileSuffix for each of names, e.g. -smtp-password-file
+// for -smtp-password. Like every flag, they can be set from the environment too, e.g. with
+// GREENLIGHT_SMTP_PASSWORD_FILE, which is how Docker and Kubernetes secrets mounted as files
+// are usually passed in, so that the secrets themselves appear neither in the arguments of the
+// process nor in its environment.
+func AddFileFlags(fs *flag.FlagSet, names ...string) {
+	for _, name := range names {
+		fs.String(name+FileSuffix, "", fmt.Sprintf("File to read -%s from (default none)", name))
+	}
+}
+
+// LoadFiles sets each of names whose file flag, registered by AddFileFlags, was set, from the
+// content of that file. A single trailing newline, which editors and "echo" add, is removed.
+// Setting both a flag and its file flag is a problem, as it isn't clear which one is meant.
+// Every problem is reported in the returned *Error.
+//
+// Call LoadFiles after LoadEnv and Load, so that the file flags can be set from either.
+func LoadFiles(fs *flag.FlagSet, names ...string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	filesErr := &Error{File: "secret files"}
+
+	for _, name := range names {
+		fileFlag := name + FileSuffix
+		if !set[fileFlag] {
+			continue
+		}
+
+		path := fs.Lookup(fileFlag).Value.String()
+		if set[name] {
+			filesErr.Problems = append(filesErr.Problems, fmt.Sprintf("set either %s or %s, not both", name, fileFlag))
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			filesErr.Problems = append(filesErr.Problems, fmt.Sprintf("%s: %v", fileFlag, err))
+			continue
+		}
+
+		value := strings.TrimSuffix(strings.TrimSuffix(string(content), "\n"), "\r")
+		err = fs.Set(name, value)
+		if err != nil {
+			// The value is left out of the message, as it is usually a secret.
+			filesErr.Problems = append(filesErr.Problems, fmt.Sprintf("invalid value in %s for %s: %v", path, name, err))
+		}
+	}
+
+	if len(filesErr.Problems) > 0 {
+		return filesErr
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package configfile
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTOML reads the settings of a TOML document made of tables, dotted keys, and strings,
+// numbers, booleans, date-times and arrays of those as values. Arrays may span several lines.
+// Multi-line strings, inline tables and arrays of tables are not supported.
+func parseTOML(src string) ([]Setting, error) {
+	var (
+		settings []Setting
+		table    string
+	)
+
+	lines := strings.Split(src, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := i + 1
+
+		content := strings.TrimSpace(stripComment(lines[i]))
+		if content == "" {
+			continue
+		}
+
+		if strings.HasPrefix(content, "[[") {
+			return nil, fmt.Errorf("line %d: arrays of tables are not supported", line)
+		}
+
+		if strings.HasPrefix(content, "[") {
+			if !strings.HasSuffix(content, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", line)
+			}
+
+			name, err := tomlKey(content[1 : len(content)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+
+			table = name
+			continue
+		}
+
+		key, value, ok := strings.Cut(content, "=")
+		if !ok {
+			return nil, fmt.Errorf(`line %d: expected "key = value"`, line)
+		}
+
+		name, err := tomlKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		// An array continues on the following lines until its closing bracket.
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "[") {
+			for !strings.HasSuffix(value, "]") && i+1 < len(lines) {
+				i++
+				value += " " + strings.TrimSpace(stripComment(lines[i]))
+			}
+		}
+
+		v, err := parseTOMLValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		settings = append(settings, Setting{Key: settingKey(table, name), Value: v, Line: line})
+	}
+
+	return settings, nil
+}
+
+// tomlKey joins the parts of a bare or dotted key, e.g. "db.max_open_conns", with hyphens.
+func tomlKey(s string) (string, error) {
+	var name string
+
+	for _, part := range strings.Split(s, ".") {
+		part = strings.TrimSpace(part)
+		if !validKey(part) {
+			return "", fmt.Errorf("invalid key %q", strings.TrimSpace(s))
+		}
+		name = settingKey(name, part)
+	}
+
+	return name, nil
+}
+
+// parseTOMLValue parses a value. Arrays are joined with spaces.
+func parseTOMLValue(s string) (string, error) {
+	switch {
+	case s == "":
+		return "", errors.New("missing value")
+	case strings.HasPrefix(s, `"""`), strings.HasPrefix(s, "'''"):
+		return "", errors.New("multi-line strings are not supported")
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid literal string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s[0] == '{':
+		return "", errors.New("inline tables are not supported")
+	case s[0] == '[':
+		if !strings.HasSuffix(s, "]") {
+			return "", errors.New("unterminated array")
+		}
+
+		var items []string
+		for _, item := range splitList(s[1 : len(s)-1]) {
+			item = strings.TrimSpace(item)
+			if strings.HasPrefix(item, "[") {
+				return "", errors.New("nested arrays are not supported")
+			}
+
+			v, err := parseTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, v)
+		}
+
+		return strings.Join(items, " "), nil
+	case s == "true", s == "false":
+		return s, nil
+	}
+
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64); err == nil {
+		return strings.ReplaceAll(s, "_", ""), nil
+	}
+
+	if _, err := time.Parse(time.RFC3339, s); err == nil {
+		return s, nil
+	}
+
+	return "", fmt.Errorf("invalid value %s (strings must be quoted)", s)
+}
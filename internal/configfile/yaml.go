@@ -0,0 +1,188 @@
+package configfile
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads the settings of a YAML document made of block mappings, scalars and lists of
+// scalars, either as block lists ("- item" lines) or flow lists ("[a, b]"). Anchors, flow
+// mappings, multi-line strings and multiple documents are not supported.
+func parseYAML(src string) ([]Setting, error) {
+	// mapping is a block mapping being read. indent is the indentation of the key which
+	// opened it, and child the indentation of its own keys, -1 until the first one is read.
+	type mapping struct {
+		indent int
+		child  int
+		prefix string
+	}
+
+	var (
+		settings []Setting
+		stack    = []mapping{{indent: -1, child: -1}}
+
+		// pending is a key without a value, whose value is the nested mapping or block list
+		// on the lines that follow, or empty if there is neither.
+		pending       *Setting
+		pendingIndent int
+
+		// list is the key of the block list being read, and items its items so far.
+		list       *Setting
+		listIndent int
+		items      []string
+	)
+
+	for n, raw := range strings.Split(src, "\n") {
+		line := n + 1
+
+		content := strings.TrimSpace(stripComment(raw))
+		if content == "" {
+			continue
+		}
+
+		indentation := raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))]
+		if strings.Contains(indentation, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", line)
+		}
+		indent := len(indentation)
+
+		if content == "---" {
+			if len(settings) > 0 || pending != nil || list != nil {
+				return nil, fmt.Errorf("line %d: only a single document is supported", line)
+			}
+			continue
+		}
+
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			switch {
+			case pending != nil && indent >= pendingIndent:
+				list, listIndent, items = pending, indent, nil
+				pending = nil
+			case list != nil && indent == listIndent:
+			default:
+				return nil, fmt.Errorf("line %d: unexpected list item", line)
+			}
+
+			item, err := parseYAMLScalar(strings.TrimSpace(content[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+
+			items = append(items, item)
+			continue
+		}
+
+		if list != nil {
+			list.Value = strings.Join(items, " ")
+			settings = append(settings, *list)
+			list = nil
+		}
+
+		if pending != nil {
+			if indent > pendingIndent {
+				stack = append(stack, mapping{indent: pendingIndent, child: indent, prefix: pending.Key})
+			} else {
+				settings = append(settings, *pending)
+			}
+			pending = nil
+		}
+
+		for indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		top := &stack[len(stack)-1]
+		if top.child == -1 {
+			top.child = indent
+		} else if indent != top.child {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line)
+		}
+
+		key, value, ok := strings.Cut(content, ":")
+		if !ok || (value != "" && value[0] != ' ') {
+			return nil, fmt.Errorf(`line %d: expected "key: value"`, line)
+		}
+
+		key = strings.TrimSpace(key)
+		if !validKey(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", line, key)
+		}
+
+		setting := Setting{Key: settingKey(top.prefix, key), Line: line}
+
+		value = strings.TrimSpace(value)
+		if value == "" {
+			pending, pendingIndent = &setting, indent
+			continue
+		}
+
+		v, err := parseYAMLValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		setting.Value = v
+		settings = append(settings, setting)
+	}
+
+	if list != nil {
+		list.Value = strings.Join(items, " ")
+		settings = append(settings, *list)
+	}
+
+	if pending != nil {
+		settings = append(settings, *pending)
+	}
+
+	return settings, nil
+}
+
+// parseYAMLValue parses the value of a key: a scalar or a flow list of scalars, which is
+// joined with spaces.
+func parseYAMLValue(s string) (string, error) {
+	switch s[0] {
+	case '[':
+		if !strings.HasSuffix(s, "]") {
+			return "", errors.New("unterminated list (lists must be on a single line or written as \"- item\" lines)")
+		}
+
+		var items []string
+		for _, item := range splitList(s[1 : len(s)-1]) {
+			v, err := parseYAMLScalar(strings.TrimSpace(item))
+			if err != nil {
+				return "", err
+			}
+			items = append(items, v)
+		}
+
+		return strings.Join(items, " "), nil
+	case '{', '|', '>', '&', '*', '!':
+		return "", fmt.Errorf("unsupported value %q (only scalars and lists of scalars are supported)", s)
+	}
+
+	return parseYAMLScalar(s)
+}
+
+// parseYAMLScalar parses a plain, single-quoted or double-quoted scalar. Null is read as an
+// empty string.
+func parseYAMLScalar(s string) (string, error) {
+	switch {
+	case s == "", s == "~", s == "null", s == "Null", s == "NULL":
+		return "", nil
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid double-quoted string %s", s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid single-quoted string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+
+	return s, nil
+}
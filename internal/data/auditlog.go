@@ -0,0 +1,174 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// AuditEntry is a record of a single change made through the API. Route is the route pattern,
+// e.g. "/v1/movies/:id", and Path the path that was requested. EntityID is a string, as some
+// entities, such as movie lists, are identified by a slug. Before and After hold the entity
+// as JSON before and after the change, when the handler making it provides them.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	ActorID   *int64          `json:"actor_id"` // nil for anonymous requests
+	Method    string          `json:"method"`
+	Route     string          `json:"route"`
+	Path      string          `json:"path"`
+	Status    int             `json:"status"`
+	Entity    string          `json:"entity"`
+	EntityID  string          `json:"entity_id,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	IP        string          `json:"ip"`
+}
+
+// AuditFilter narrows down the entries returned by AuditLogModel.GetAll. Zero values don't
+// filter.
+type AuditFilter struct {
+	ActorID  int64
+	Method   string
+	Entity   string
+	EntityID string
+	Since    time.Time
+	Until    time.Time
+}
+
+// ValidateAuditFilter runs validation checks on the AuditFilter type.
+func ValidateAuditFilter(v *validator.Validator, f AuditFilter) {
+	v.Check(f.ActorID >= 0, "actor_id", "must be a positive integer")
+	if f.Method != "" {
+		v.Check(validator.In(f.Method, "POST", "PUT", "PATCH", "DELETE"), "method", "must be POST, PUT, PATCH or DELETE")
+	}
+	if f.EntityID != "" {
+		v.Check(f.Entity != "", "entity_id", "can only be used together with entity")
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() {
+		v.Check(f.Since.Before(f.Until), "until", "must be later than since")
+	}
+}
+
+// AuditLogModel struct wraps a sql.DB connection pool and allows us to work with the audit_log
+// table in our database.
+type AuditLogModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert records an audit entry.
+func (m AuditLogModel) Insert(entry *AuditEntry) error {
+	query := `
+		INSERT INTO audit_log (actor_id, method, route, path, status, entity, entity_id, before, after, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{
+		entry.ActorID, entry.Method, entry.Route, entry.Path, entry.Status, entry.Entity,
+		entry.EntityID, nullJSON(entry.Before), nullJSON(entry.After), entry.IP,
+	}
+
+	ctx, cancel := queryContext("AuditLogModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// GetAll returns the audit entries matching filter, paginated and sorted by filters.
+func (m AuditLogModel) GetAll(filter AuditFilter, filters Filters) ([]*AuditEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, actor_id, method, route, path, status, entity,
+			entity_id, before, after, ip
+		FROM audit_log
+		WHERE (actor_id = $1 OR $1 = 0)
+		AND (method = $2 OR $2 = '')
+		AND (entity = $3 OR $3 = '')
+		AND (entity_id = $4 OR $4 = '')
+		AND (created_at >= $5 OR $5 IS NULL)
+		AND (created_at < $6 OR $6 IS NULL)
+		ORDER BY %s %s, id DESC
+		LIMIT $7 OFFSET $8`,
+		filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{
+		filter.ActorID, filter.Method, filter.Entity, filter.EntityID,
+		nullTime(filter.Since), nullTime(filter.Until), filters.limit(), filters.offset(),
+	}
+
+	ctx, cancel := queryContext("AuditLogModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var (
+			entry         AuditEntry
+			before, after []byte
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorID,
+			&entry.Method,
+			&entry.Route,
+			&entry.Path,
+			&entry.Status,
+			&entry.Entity,
+			&entry.EntityID,
+			&before,
+			&after,
+			&entry.IP,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entry.Before = before
+		entry.After = after
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// nullJSON returns js as a query argument, or nil for a NULL if it is empty.
+func nullJSON(js json.RawMessage) interface{} {
+	if len(js) == 0 {
+		return nil
+	}
+	return []byte(js)
+}
+
+// nullTime returns t as a query argument, or nil for a NULL if it is the zero time.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
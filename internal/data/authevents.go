@@ -0,0 +1,160 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// The types of the authentication events.
+const (
+	// AuthEventLogin is an attempt to log in, which issues an authentication token when it
+	// succeeds.
+	AuthEventLogin = "login"
+	// AuthEventTokenIssued is an activation or password reset token emailed to a user. Reason
+	// holds the scope of the token.
+	AuthEventTokenIssued = "token_issued"
+	// AuthEventPasswordReset is an attempt to set a new password with a password reset token.
+	AuthEventPasswordReset = "password_reset"
+	// AuthEventActivation is an attempt to activate an account with an activation token.
+	AuthEventActivation = "activation"
+)
+
+// AuthEventTypes are the types of the authentication events, for validating filters.
+var AuthEventTypes = []string{AuthEventLogin, AuthEventTokenIssued, AuthEventPasswordReset, AuthEventActivation}
+
+// AuthEvent is a record of an authentication event. UserID is nil when the event can't be tied
+// to a user, such as a login with an unknown email address or an invalid token. Reason says
+// why a failed event failed, e.g. "wrong_password", or which token was issued.
+type AuthEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    *int64    `json:"user_id"`
+	Email     string    `json:"email,omitempty"`
+	Type      string    `json:"type"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// AuthEventFilter narrows down the events returned by AuthEventModel.GetAll. Zero values don't
+// filter.
+type AuthEventFilter struct {
+	UserID int64
+	Email  string
+	Type   string
+	IP     string
+	// Failed only returns the events which failed.
+	Failed bool
+	Since  time.Time
+	Until  time.Time
+}
+
+// ValidateAuthEventFilter runs validation checks on the AuthEventFilter type.
+func ValidateAuthEventFilter(v *validator.Validator, f AuthEventFilter) {
+	v.Check(f.UserID >= 0, "user_id", "must be a positive integer")
+	if f.Type != "" {
+		v.Check(validator.In(f.Type, AuthEventTypes...), "type", "must be login, token_issued, password_reset or activation")
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() {
+		v.Check(f.Since.Before(f.Until), "until", "must be later than since")
+	}
+}
+
+// AuthEventModel struct wraps a sql.DB connection pool and allows us to work with the
+// auth_events table in our database.
+type AuthEventModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert records an authentication event.
+func (m AuthEventModel) Insert(event *AuthEvent) error {
+	query := `
+		INSERT INTO auth_events (user_id, email, type, success, reason, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{
+		event.UserID, event.Email, event.Type, event.Success, event.Reason, event.IP, event.UserAgent,
+	}
+
+	ctx, cancel := queryContext("AuthEventModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// GetAll returns the authentication events matching filter, paginated and sorted by filters.
+func (m AuthEventModel) GetAll(filter AuthEventFilter, filters Filters) ([]*AuthEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, user_id, email, type, success, reason, ip, user_agent
+		FROM auth_events
+		WHERE (user_id = $1 OR $1 = 0)
+		AND (email = $2 OR $2 = '')
+		AND (type = $3 OR $3 = '')
+		AND (ip = $4 OR $4 = '')
+		AND (NOT success OR NOT $5)
+		AND (created_at >= $6 OR $6 IS NULL)
+		AND (created_at < $7 OR $7 IS NULL)
+		ORDER BY %s %s, id DESC
+		LIMIT $8 OFFSET $9`,
+		filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{
+		filter.UserID, filter.Email, filter.Type, filter.IP, filter.Failed,
+		nullTime(filter.Since), nullTime(filter.Until), filters.limit(), filters.offset(),
+	}
+
+	ctx, cancel := queryContext("AuthEventModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	events := []*AuthEvent{}
+
+	for rows.Next() {
+		var event AuthEvent
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.UserID,
+			&event.Email,
+			&event.Type,
+			&event.Success,
+			&event.Reason,
+			&event.IP,
+			&event.UserAgent,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
@@ -0,0 +1,221 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// Bulk operation kinds and statuses.
+const (
+	BulkKindMovieDelete = "movies.delete"
+
+	BulkStatusPreview   = "preview"
+	BulkStatusRunning   = "running"
+	BulkStatusCompleted = "completed"
+	BulkStatusFailed    = "failed"
+)
+
+// ScopeBulkConfirmation is the scope of the confirmation tokens returned by a bulk operation
+// dry-run. They are stored in the bulk_operations table alongside the filters they confirm.
+const ScopeBulkConfirmation = "bulk-confirmation"
+
+// MovieFilters holds the title and genres filters that the movie listing accepts. It's used
+// to describe the set of movies a bulk operation applies to. OrgID is the organization whose
+// catalogue they are selected from, or 0 for the shared catalogue.
+type MovieFilters struct {
+	Title  string   `json:"title"`
+	Genres []string `json:"genres"`
+	OrgID  int64    `json:"org_id,omitempty"`
+}
+
+// Equal reports whether two sets of filters select the same movies.
+func (f MovieFilters) Equal(other MovieFilters) bool {
+	if f.Title != other.Title || f.OrgID != other.OrgID || len(f.Genres) != len(other.Genres) {
+		return false
+	}
+
+	for i := range f.Genres {
+		if f.Genres[i] != other.Genres[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// BulkOperation tracks a bulk operation on the catalogue, including its progress.
+type BulkOperation struct {
+	ID         int64        `json:"id"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UserID     int64        `json:"user_id"`
+	Kind       string       `json:"kind"`
+	Filters    MovieFilters `json:"filters"`
+	Status     string       `json:"status"`
+	Expiry     time.Time    `json:"-"`
+	Total      int          `json:"total"`
+	MaxMovieID int64        `json:"-"` // the highest id counted by the dry-run, see MovieModel.DeleteBatch
+	Processed  int          `json:"processed"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  *time.Time   `json:"started_at,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+// BulkOperationModel struct wraps a sql.DB connection pool and allows us to work with the
+// BulkOperation struct type and the bulk_operations table in our database.
+type BulkOperationModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// InsertPreview records a dry-run of a bulk operation and returns the confirmation token that
+// has to be presented to actually execute it. The token expires after ttl.
+func (m BulkOperationModel) InsertPreview(op *BulkOperation, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(op.UserID, ttl, ScopeBulkConfirmation)
+	if err != nil {
+		return nil, err
+	}
+
+	filters, err := json.Marshal(op.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	op.Status = BulkStatusPreview
+	op.Expiry = token.Expiry
+
+	query := `
+		INSERT INTO bulk_operations (user_id, kind, filters, status, confirmation_hash, expiry, total,
+			max_movie_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{op.UserID, op.Kind, filters, op.Status, token.Hash, op.Expiry, op.Total, op.MaxMovieID}
+
+	ctx, cancel := queryContext("BulkOperationModel.InsertPreview", 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&op.ID, &op.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// StartForToken looks up the unexpired preview matching a confirmation token, user and kind,
+// and atomically marks it as running. The confirmation token is cleared at the same time, so
+// it can only ever be used once. ErrRecordNotFound is returned if there is no such preview.
+func (m BulkOperationModel) StartForToken(userID int64, kind, tokenPlaintext string) (*BulkOperation, error) {
+	query := `
+		UPDATE bulk_operations
+		SET status = $1, started_at = NOW(), confirmation_hash = NULL
+		WHERE confirmation_hash = ANY($2) AND user_id = $3 AND kind = $4 AND status = $5
+			AND expiry > NOW()
+		RETURNING id, created_at, user_id, kind, filters, status, expiry, total, max_movie_id,
+			processed, error, started_at, finished_at
+		`
+
+	args := []interface{}{BulkStatusRunning, tokenHashes(tokenPlaintext), userID, kind, BulkStatusPreview}
+
+	ctx, cancel := queryContext("BulkOperationModel.StartForToken", 3*time.Second)
+	defer cancel()
+
+	return m.scan(m.DB.QueryRowContext(ctx, query, args...))
+}
+
+// Get fetches a bulk operation by id.
+func (m BulkOperationModel) Get(id int64) (*BulkOperation, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, user_id, kind, filters, status, expiry, total, max_movie_id,
+			processed, error, started_at, finished_at
+		FROM bulk_operations
+		WHERE id = $1
+		`
+
+	ctx, cancel := queryContext("BulkOperationModel.Get", 3*time.Second)
+	defer cancel()
+
+	return m.scan(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// UpdateProgress records how many records a running operation has processed so far.
+func (m BulkOperationModel) UpdateProgress(id int64, processed int) error {
+	query := `
+		UPDATE bulk_operations
+		SET processed = $1
+		WHERE id = $2
+		`
+
+	ctx, cancel := queryContext("BulkOperationModel.UpdateProgress", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	return err
+}
+
+// Finish marks an operation as completed, or as failed if opErr is not nil.
+func (m BulkOperationModel) Finish(id int64, processed int, opErr error) error {
+	status, message := BulkStatusCompleted, ""
+	if opErr != nil {
+		status, message = BulkStatusFailed, opErr.Error()
+	}
+
+	query := `
+		UPDATE bulk_operations
+		SET status = $1, processed = $2, error = $3, finished_at = NOW()
+		WHERE id = $4
+		`
+
+	ctx, cancel := queryContext("BulkOperationModel.Finish", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, status, processed, message, id)
+	return err
+}
+
+func (m BulkOperationModel) scan(row *sql.Row) (*BulkOperation, error) {
+	var (
+		op      BulkOperation
+		filters []byte
+	)
+
+	err := row.Scan(
+		&op.ID,
+		&op.CreatedAt,
+		&op.UserID,
+		&op.Kind,
+		&filters,
+		&op.Status,
+		&op.Expiry,
+		&op.Total,
+		&op.MaxMovieID,
+		&op.Processed,
+		&op.Error,
+		&op.StartedAt,
+		&op.FinishedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(filters, &op.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return &op, nil
+}
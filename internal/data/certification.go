@@ -0,0 +1,85 @@
+package data
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCertification is returned when a certification isn't in the "<country>:<rating>"
+// format, or isn't a rating we know about for that country. This is used in our
+// Certification.UnmarshalJSON() method.
+var ErrInvalidCertification = errors.New("invalid certification, must be in the format \"<country>:<rating>\", e.g. \"US:PG-13\"")
+
+// Certifications holds the age ratings we accept, keyed by the ISO 3166-1 alpha-2 code of the
+// country whose rating board issues them.
+var Certifications = map[string][]string{
+	"AU": {"G", "PG", "M", "MA15+", "R18+", "X18+"},
+	"CA": {"G", "PG", "14A", "18A", "R", "A"},
+	"DE": {"0", "6", "12", "16", "18"},
+	"FR": {"U", "10", "12", "16", "18"},
+	"GB": {"U", "PG", "12A", "12", "15", "18", "R18"},
+	"IN": {"U", "UA", "A", "S"},
+	"US": {"G", "PG", "PG-13", "R", "NC-17"},
+}
+
+// Certification is the age rating of a movie, such as "US:PG-13" or "GB:12A". Like Runtime it
+// has custom JSON encoding, but here the point is validation: only ratings listed in
+// Certifications can be decoded. The zero value means the movie has no certification.
+type Certification string
+
+// ParseCertification parses a certification in the "<country>:<rating>" format. Both parts are
+// case-insensitive, so "us:pg-13" is parsed as "US:PG-13".
+func ParseCertification(s string) (Certification, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	country, rating, ok := strings.Cut(strings.ToUpper(strings.TrimSpace(s)), ":")
+	if !ok {
+		return "", ErrInvalidCertification
+	}
+
+	for _, known := range Certifications[country] {
+		if rating == known {
+			return Certification(country + ":" + rating), nil
+		}
+	}
+
+	return "", ErrInvalidCertification
+}
+
+// Country returns the country part of the certification, e.g. "US".
+func (c Certification) Country() string {
+	country, _, _ := strings.Cut(string(c), ":")
+	return country
+}
+
+// Rating returns the rating part of the certification, e.g. "PG-13".
+func (c Certification) Rating() string {
+	_, rating, _ := strings.Cut(string(c), ":")
+	return rating
+}
+
+// MarshalJSON encodes the certification as a JSON string.
+func (c Certification) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(string(c))), nil
+}
+
+// UnmarshalJSON decodes a certification from a JSON string, normalizing its case and
+// returning ErrInvalidCertification if it isn't a known rating.
+func (c *Certification) UnmarshalJSON(jsonValue []byte) error {
+	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidCertification
+	}
+
+	certification, err := ParseCertification(unquotedJSONValue)
+	if err != nil {
+		return err
+	}
+
+	*c = certification
+
+	return nil
+}
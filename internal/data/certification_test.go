@@ -0,0 +1,29 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseCertification(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Certification
+		err   error
+	}{
+		{"", "", nil},
+		{"US:PG-13", "US:PG-13", nil},
+		{"gb:12a", "GB:12A", nil},
+		{" us:r ", "US:R", nil},
+		{"PG-13", "", ErrInvalidCertification},
+		{"US:12A", "", ErrInvalidCertification},
+		{"XX:PG", "", ErrInvalidCertification},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseCertification(tt.input)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("ParseCertification(%q): want %q, %v; got %q, %v", tt.input, tt.want, tt.err, got, err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Operations recorded in the movie change feed.
+const (
+	ChangeOpInsert = "insert"
+	ChangeOpUpdate = "update"
+	ChangeOpDelete = "delete"
+)
+
+// MovieChange is a single entry from the movie_changes feed. Data holds the JSON snapshot of
+// the movies row as written by the database trigger, and is deliberately kept as raw JSON so
+// that backups round-trip it exactly, whatever columns the table has at the time.
+type MovieChange struct {
+	Seq       int64           `json:"change_seq"`
+	ChangedAt time.Time       `json:"changed_at"`
+	MovieID   int64           `json:"movie_id"`
+	Op        string          `json:"op"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// ChangeModel struct wraps a sql.DB connection pool and allows us to read the movie change
+// feed and to replay changes from it.
+type ChangeModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// ChangeWatermark is a point in the change feed. A change_seq is taken when a change is
+// recorded, but the change only becomes visible when its transaction commits, so a change with
+// a lower change_seq can show up after one with a higher change_seq. Once every transaction
+// that was running when the watermark was taken has finished, which Settled reports, every
+// change up to Seq is visible, or rolled back for good, and can be read without missing any.
+type ChangeWatermark struct {
+	// Seq is the last change_seq handed out when the watermark was taken.
+	Seq int64
+	// XMax is the first transaction ID that was not yet assigned then.
+	XMax int64
+}
+
+// Watermark returns the current ChangeWatermark.
+func (m ChangeModel) Watermark() (ChangeWatermark, error) {
+	// The sequence is read before the snapshot is taken, by a separate statement, so that every
+	// transaction which took a change_seq up to Seq already had its ID, below XMax. last_value
+	// is the initial value until the sequence is first used, when is_called is set.
+	seqQuery := `SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM movie_changes_change_seq_seq`
+	xmaxQuery := `SELECT txid_snapshot_xmax(txid_current_snapshot())`
+
+	ctx, cancel := queryContext("ChangeModel.Watermark", 3*time.Second)
+	defer cancel()
+
+	var w ChangeWatermark
+	err := m.DB.QueryRowContext(ctx, seqQuery).Scan(&w.Seq)
+	if err != nil {
+		return ChangeWatermark{}, err
+	}
+
+	err = m.DB.QueryRowContext(ctx, xmaxQuery).Scan(&w.XMax)
+	return w, err
+}
+
+// Settled reports whether every transaction running when w was taken has finished.
+func (m ChangeModel) Settled(w ChangeWatermark) (bool, error) {
+	query := `SELECT txid_snapshot_xmin(txid_current_snapshot()) >= $1`
+
+	ctx, cancel := queryContext("ChangeModel.Settled", 3*time.Second)
+	defer cancel()
+
+	var settled bool
+	err := m.DB.QueryRowContext(ctx, query, w.XMax).Scan(&settled)
+	return settled, err
+}
+
+// GetSince returns up to limit changes with a change_seq greater than seq and at most upTo,
+// oldest first. upTo should be the Seq of a settled ChangeWatermark, so that no change in the
+// range can still become visible later.
+func (m ChangeModel) GetSince(seq, upTo int64, limit int) ([]*MovieChange, error) {
+	query := `
+		SELECT change_seq, changed_at, movie_id, op, data
+		FROM movie_changes
+		WHERE change_seq > $1 AND change_seq <= $2
+		ORDER BY change_seq
+		LIMIT $3
+		`
+
+	ctx, cancel := queryContext("ChangeModel.GetSince", 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, seq, upTo, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	changes := []*MovieChange{}
+
+	for rows.Next() {
+		var change MovieChange
+
+		err := rows.Scan(&change.Seq, &change.ChangedAt, &change.MovieID, &change.Op, &change.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, &change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// ChangeRestore is a transaction replaying backed up changes against the movies table. The
+// changes it applies aren't recorded in the change feed again, as they are already backed up.
+type ChangeRestore struct {
+	tx     *sql.Tx
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// BeginRestore starts a new ChangeRestore. The whole restore must finish within timeout, and
+// the caller must always call either Commit() or Rollback().
+func (m ChangeModel) BeginRestore(timeout time.Duration) (*ChangeRestore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// The movies_change_feed trigger skips the changes made while this is on.
+	_, err = tx.ExecContext(ctx, `SET LOCAL greenlight.restoring = 'on'`)
+	if err != nil {
+		_ = tx.Rollback()
+		cancel()
+		return nil, err
+	}
+
+	return &ChangeRestore{tx: tx, ctx: ctx, cancel: cancel}, nil
+}
+
+// Apply replays a change against the movies table. Inserts and updates are applied as an
+// upsert of the snapshot, so replaying the same change twice is harmless. Users and
+// organizations aren't backed up, so the snapshot can refer to ones which don't exist in the
+// database restored into: created_by is then cleared, as it would be had the user been
+// deleted, and the movies of a missing organization are skipped, as they would have been
+// deleted with it. Apply reports whether the change was applied.
+func (r *ChangeRestore) Apply(change *MovieChange) (bool, error) {
+	var (
+		query string
+		args  []interface{}
+	)
+
+	switch change.Op {
+	case ChangeOpInsert, ChangeOpUpdate:
+		// jsonb_populate_record() turns the snapshot back into a movies row. The snapshot is
+		// to_jsonb() of the whole row, so it holds every column, but the columns an update
+		// overwrites have to be listed, and kept in sync with the table, here. Snapshots taken
+		// before a column was added leave it NULL.
+		query = `
+			INSERT INTO movies
+			SELECT * FROM jsonb_populate_record(NULL::movies, CASE
+				WHEN EXISTS (SELECT 1 FROM users WHERE id = ($1::jsonb->>'created_by')::bigint) THEN $1::jsonb
+				ELSE $1::jsonb || '{"created_by": null}'
+			END)
+			WHERE $1::jsonb->>'org_id' IS NULL
+			OR EXISTS (SELECT 1 FROM organizations WHERE id = ($1::jsonb->>'org_id')::bigint)
+			ON CONFLICT (id) DO UPDATE
+			SET (created_at, title, year, runtime, genres, certification, version, created_by, org_id) = (
+				EXCLUDED.created_at, EXCLUDED.title, EXCLUDED.year, EXCLUDED.runtime,
+				EXCLUDED.genres, EXCLUDED.certification, EXCLUDED.version, EXCLUDED.created_by,
+				EXCLUDED.org_id
+			)
+			`
+		args = []interface{}{[]byte(change.Data)}
+	case ChangeOpDelete:
+		query = `DELETE FROM movies WHERE id = $1`
+		args = []interface{}{change.MovieID}
+	default:
+		return false, fmt.Errorf("unknown change operation %q", change.Op)
+	}
+
+	result, err := r.tx.ExecContext(r.ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	if change.Op == ChangeOpDelete {
+		return true, nil
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// Commit commits the changes applied by the restore.
+func (r *ChangeRestore) Commit() error {
+	defer r.cancel()
+	return r.tx.Commit()
+}
+
+// Rollback aborts the restore. It is safe to call after Commit().
+func (r *ChangeRestore) Rollback() error {
+	defer r.cancel()
+
+	err := r.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
+
+// ResetSequences moves the movies id sequence past the highest restored id, so that new movies
+// don't collide with restored ones, and the change feed sequence past lastSeq, the last change
+// in the backup, so that the next backup picks up the changes made after the restore.
+func (m ChangeModel) ResetSequences(lastSeq int64) error {
+	query := `
+		SELECT setval('movies_id_seq', GREATEST((SELECT MAX(id) FROM movies), 1)),
+		setval('movie_changes_change_seq_seq', GREATEST($1, (SELECT last_value FROM movie_changes_change_seq_seq)))
+		`
+
+	ctx, cancel := queryContext("ChangeModel.ResetSequences", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, lastSeq)
+	return err
+}
@@ -0,0 +1,392 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// ErrEditWindowClosed is returned when the author of a comment tries to edit it after the
+// configured edit window has passed.
+var ErrEditWindowClosed = errors.New("edit window closed")
+
+// DeletedCommentBody replaces the body of the comments deleted by their author while they had
+// replies, see CommentModel.Withdraw.
+const DeletedCommentBody = "[this comment has been deleted]"
+
+// Comment is a free-text comment left by a user on a movie. Comments can be replied to, so a
+// Comment may carry its direct replies (which may in turn carry their own replies).
+type Comment struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	MovieID   int64      `json:"movie_id"`
+	UserID    int64      `json:"user_id"`
+	ParentID  *int64     `json:"parent_id,omitempty"` // nil for top-level comments
+	Body      string     `json:"body"`
+	Hidden    bool       `json:"hidden"`
+	Deleted   bool       `json:"deleted"`
+	Version   int32      `json:"version"`
+	Replies   []*Comment `json:"replies,omitempty"`
+}
+
+// Editable reports whether the comment can still be edited by its author, i.e. whether it was
+// created less than window ago.
+func (c *Comment) Editable(window time.Duration) bool {
+	return time.Since(c.CreatedAt) < window
+}
+
+// CommentModel struct wraps a sql.DB connection pool and allows us to work with the Comment
+// struct type and the comments table in our database.
+type CommentModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert inserts a new comment. If the comment is a reply, the parent comment must belong to
+// the same movie, otherwise ErrRecordNotFound is returned.
+func (m CommentModel) Insert(comment *Comment) error {
+	// The INSERT ... SELECT form lets us check that the parent belongs to the same movie in
+	// the same statement. If it doesn't, no row is inserted and Scan() returns sql.ErrNoRows.
+	query := `
+		INSERT INTO comments (movie_id, user_id, parent_id, body)
+		SELECT $1::bigint, $2::bigint, $3::bigint, $4::text
+		WHERE $3::bigint IS NULL
+			OR EXISTS (SELECT 1 FROM comments WHERE id = $3 AND movie_id = $1)
+		RETURNING id, created_at, updated_at, version
+		`
+
+	args := []interface{}{comment.MovieID, comment.UserID, comment.ParentID, comment.Body}
+
+	ctx, cancel := queryContext("CommentModel.Insert", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&comment.ID,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get fetches a single comment (without its replies) belonging to a specific movie.
+func (m CommentModel) Get(movieID, id int64) (*Comment, error) {
+	if id < 1 || movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, updated_at, movie_id, user_id, parent_id, body, hidden, deleted,
+			version
+		FROM comments
+		WHERE id = $1 AND movie_id = $2
+		`
+
+	ctx, cancel := queryContext("CommentModel.Get", 3*time.Second)
+	defer cancel()
+
+	comment, err := scanComment(m.DB.QueryRowContext(ctx, query, id, movieID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return comment, nil
+}
+
+// GetThreadsForMovie returns a page of top-level comments for a movie, each with its full tree
+// of replies attached. Pagination only applies to the top-level comments so that a thread is
+// never split across pages.
+func (m CommentModel) GetThreadsForMovie(movieID int64, filters Filters) ([]*Comment, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, updated_at, movie_id, user_id, parent_id, body,
+			hidden, deleted, version
+		FROM comments
+		WHERE movie_id = $1 AND parent_id IS NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := queryContext("CommentModel.GetThreadsForMovie", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	threads := []*Comment{}
+	ids := []int64{}
+
+	for rows.Next() {
+		var comment Comment
+
+		err := rows.Scan(
+			&totalRecords,
+			&comment.ID,
+			&comment.CreatedAt,
+			&comment.UpdatedAt,
+			&comment.MovieID,
+			&comment.UserID,
+			&comment.ParentID,
+			&comment.Body,
+			&comment.Hidden,
+			&comment.Deleted,
+			&comment.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		threads = append(threads, &comment)
+		ids = append(ids, comment.ID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if len(ids) > 0 {
+		err = m.attachReplies(ctx, threads, ids)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return threads, metadata, nil
+}
+
+// attachReplies loads every descendant of the given root comments with a recursive query and
+// links each reply to its parent, oldest replies first.
+func (m CommentModel) attachReplies(ctx context.Context, roots []*Comment, rootIDs []int64) error {
+	query := `
+		WITH RECURSIVE replies AS (
+			SELECT c.* FROM comments c WHERE c.parent_id = ANY($1)
+			UNION ALL
+			SELECT c.* FROM comments c INNER JOIN replies r ON c.parent_id = r.id
+		)
+		SELECT id, created_at, updated_at, movie_id, user_id, parent_id, body, hidden, deleted,
+			version
+		FROM replies
+		ORDER BY created_at ASC, id ASC
+		`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(rootIDs))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	byID := make(map[int64]*Comment, len(roots))
+	for _, root := range roots {
+		byID[root.ID] = root
+	}
+
+	// Replies are collected first and linked afterwards, because a reply is not guaranteed
+	// to be returned after its parent when both were created in the same second.
+	var replies []*Comment
+	for rows.Next() {
+		reply, err := scanComment(rows)
+		if err != nil {
+			return err
+		}
+		byID[reply.ID] = reply
+		replies = append(replies, reply)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, reply := range replies {
+		if parent, ok := byID[*reply.ParentID]; ok {
+			parent.Replies = append(parent.Replies, reply)
+		}
+	}
+
+	return nil
+}
+
+// Update updates the body of a comment, using the version number for optimistic locking in the
+// same way as MovieModel.Update.
+func (m CommentModel) Update(comment *Comment) error {
+	query := `
+		UPDATE comments
+		SET body = $1, hidden = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING updated_at, version
+		`
+
+	args := []interface{}{comment.Body, comment.Hidden, comment.ID, comment.Version}
+
+	ctx, cancel := queryContext("CommentModel.Update", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&comment.UpdatedAt, &comment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a comment together with all of its replies. Authors deleting their own
+// comments use Withdraw instead, which keeps the replies.
+func (m CommentModel) Delete(movieID, id int64) error {
+	if id < 1 || movieID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM comments
+		WHERE id = $1 AND movie_id = $2
+		`
+
+	ctx, cancel := queryContext("CommentModel.Delete", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Withdraw deletes a comment for its author. A comment with replies is only emptied and marked
+// as deleted, as deleting it would delete the replies, which may be other users', with it.
+// Withdraw reports whether the comment was kept as such a tombstone.
+func (m CommentModel) Withdraw(movieID, id int64) (bool, error) {
+	if id < 1 || movieID < 1 {
+		return false, ErrRecordNotFound
+	}
+
+	ctx, cancel := queryContext("CommentModel.Withdraw", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Locking the comment makes replies to it wait until it is either emptied or deleted, so a
+	// reply can't be posted in between and be deleted with it.
+	query := `
+		SELECT EXISTS (SELECT 1 FROM comments WHERE parent_id = $1)
+		FROM comments
+		WHERE id = $1 AND movie_id = $2 AND NOT deleted
+		FOR UPDATE
+		`
+
+	var hasReplies bool
+	err = tx.QueryRowContext(ctx, query, id, movieID).Scan(&hasReplies)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	if hasReplies {
+		query = `
+			UPDATE comments
+			SET body = $1, deleted = TRUE, updated_at = NOW(), version = version + 1
+			WHERE id = $2
+			`
+		_, err = tx.ExecContext(ctx, query, DeletedCommentBody, id)
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM comments WHERE id = $1`, id)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return hasReplies, tx.Commit()
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanComment(row rowScanner) (*Comment, error) {
+	var comment Comment
+
+	err := row.Scan(
+		&comment.ID,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.MovieID,
+		&comment.UserID,
+		&comment.ParentID,
+		&comment.Body,
+		&comment.Hidden,
+		&comment.Deleted,
+		&comment.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+// ValidateComment runs validation checks on the Comment type.
+func ValidateComment(v *validator.Validator, comment *Comment) {
+	v.Check(comment.Body != "", "body", "must be provided")
+	v.Check(len(comment.Body) <= 5000, "body", "must not be more than 5000 bytes long")
+
+	if comment.ParentID != nil {
+		v.Check(*comment.ParentID > 0, "parent_id", "must be a positive integer")
+	}
+}
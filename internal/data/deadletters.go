@@ -0,0 +1,187 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// DeadLetter is an email which couldn't be sent once every attempt failed. Data is kept to
+// requeue the email but never returned by the API, as emails may carry plaintext tokens.
+type DeadLetter struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	OutboxID   *int64          `json:"outbox_id"`
+	Recipient  string          `json:"recipient"`
+	Template   string          `json:"template"`
+	Data       json.RawMessage `json:"-"`
+	Attempts   int             `json:"attempts"`
+	Error      string          `json:"error"`
+	RequeuedAt *time.Time      `json:"requeued_at"`
+}
+
+// NewDeadLetter returns the dead letter of an email to recipient, rendered from template with
+// data, which failed with err after attempts attempts.
+func NewDeadLetter(recipient, template string, data interface{}, attempts int, err error) (*DeadLetter, error) {
+	js, jsErr := json.Marshal(data)
+	if jsErr != nil {
+		return nil, jsErr
+	}
+
+	return &DeadLetter{Recipient: recipient, Template: template, Data: js, Attempts: attempts, Error: err.Error()}, nil
+}
+
+// DeadLetterModel struct wraps a sql.DB connection pool and allows us to work with the
+// email_dead_letters table in our database.
+type DeadLetterModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert records a dead letter.
+func (m DeadLetterModel) Insert(letter *DeadLetter) error {
+	query := `
+		INSERT INTO email_dead_letters (outbox_id, recipient, template, data, attempts, error)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{letter.OutboxID, letter.Recipient, letter.Template, letter.Data, letter.Attempts, letter.Error}
+
+	ctx, cancel := queryContext("DeadLetterModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&letter.ID, &letter.CreatedAt)
+}
+
+// Get returns the dead letter with id.
+func (m DeadLetterModel) Get(id int64) (*DeadLetter, error) {
+	query := `
+		SELECT id, created_at, outbox_id, recipient, template, data, attempts, error, requeued_at
+		FROM email_dead_letters
+		WHERE id = $1
+		`
+
+	ctx, cancel := queryContext("DeadLetterModel.Get", 3*time.Second)
+	defer cancel()
+
+	var letter DeadLetter
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&letter.ID,
+		&letter.CreatedAt,
+		&letter.OutboxID,
+		&letter.Recipient,
+		&letter.Template,
+		&letter.Data,
+		&letter.Attempts,
+		&letter.Error,
+		&letter.RequeuedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &letter, nil
+}
+
+// GetAll returns the latest dead letters, newest first. Unless requeued is set, the ones which
+// have been requeued are left out.
+func (m DeadLetterModel) GetAll(requeued bool, limit int) ([]*DeadLetter, error) {
+	query := `
+		SELECT id, created_at, outbox_id, recipient, template, attempts, error, requeued_at
+		FROM email_dead_letters
+		WHERE requeued_at IS NULL OR $1
+		ORDER BY id DESC
+		LIMIT $2
+		`
+
+	ctx, cancel := queryContext("DeadLetterModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, requeued, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	letters := []*DeadLetter{}
+
+	for rows.Next() {
+		var letter DeadLetter
+
+		err := rows.Scan(
+			&letter.ID,
+			&letter.CreatedAt,
+			&letter.OutboxID,
+			&letter.Recipient,
+			&letter.Template,
+			&letter.Attempts,
+			&letter.Error,
+			&letter.RequeuedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		letters = append(letters, &letter)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return letters, nil
+}
+
+// Requeue queues the email of a dead letter in the outbox again and marks the dead letter as
+// requeued, in a single statement. It returns the outbox message, or ErrEditConflict if the
+// dead letter doesn't exist or was already requeued.
+func (m DeadLetterModel) Requeue(letter *DeadLetter) (*OutboxMessage, error) {
+	msg, err := newOutboxMessage(OutboxEmail, struct {
+		Recipient string          `json:"recipient"`
+		Template  string          `json:"template"`
+		Data      json.RawMessage `json:"data"`
+	}{letter.Recipient, letter.Template, letter.Data})
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		WITH letter AS (
+			UPDATE email_dead_letters
+			SET requeued_at = NOW()
+			WHERE id = $1 AND requeued_at IS NULL
+			RETURNING requeued_at
+		)
+		INSERT INTO outbox (kind, payload)
+		SELECT $2, $3 FROM letter
+		RETURNING id, created_at, status, (SELECT requeued_at FROM letter)
+		`
+
+	ctx, cancel := queryContext("DeadLetterModel.Requeue", 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, letter.ID, msg.Kind, msg.Payload).Scan(&msg.ID, &msg.CreatedAt, &msg.Status, &letter.RequeuedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,77 @@
+package data
+
+import "strings"
+
+// EmailPolicy describes how email addresses are normalized before they are validated, stored
+// or looked up. Normalizing in a single place means that "Alice@Example.com" and
+// "alice@example.com" are treated as the same account everywhere.
+type EmailPolicy struct {
+	// Lowercase folds the whole address to lower case. Strictly speaking the local part of an
+	// address may be case-sensitive, but in practice no mainstream provider treats it that way.
+	Lowercase bool
+	// FoldGmailAliases removes dots and "+tag" suffixes from the local part of gmail.com and
+	// googlemail.com addresses, since Gmail delivers all of these variants to the same inbox.
+	FoldGmailAliases bool
+}
+
+// EmailNormalization is the policy applied by NormalizeEmail. It is set once at startup from
+// the application config, in the same way as validator.EmailRX is a package level value.
+var EmailNormalization = EmailPolicy{Lowercase: true}
+
+// gmailDomains are the domains whose addresses are folded when FoldGmailAliases is enabled.
+var gmailDomains = []string{"gmail.com", "googlemail.com"}
+
+// NormalizeEmail returns the canonical form of an email address according to the configured
+// EmailNormalization policy. Addresses that don't contain an "@" are returned trimmed but
+// otherwise unchanged, and are left for ValidateEmail to reject.
+func NormalizeEmail(email string) string {
+	return EmailNormalization.Normalize(email)
+}
+
+// LookupForms returns the forms an address is looked up by, the normalized form first. Gmail
+// alias folding isn't backfilled, so when FoldGmailAliases changes the address, it is also
+// looked up as it was stored before folding was enabled.
+func (p EmailPolicy) LookupForms(email string) []string {
+	normalized := p.Normalize(email)
+
+	if p.FoldGmailAliases {
+		unfolded := EmailPolicy{Lowercase: p.Lowercase}.Normalize(email)
+		if unfolded != normalized {
+			return []string{normalized, unfolded}
+		}
+	}
+
+	return []string{normalized}
+}
+
+// Normalize returns the canonical form of an email address according to the policy.
+func (p EmailPolicy) Normalize(email string) string {
+	email = strings.TrimSpace(email)
+
+	if p.Lowercase {
+		email = strings.ToLower(email)
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return email
+	}
+
+	local, domain := email[:at], email[at+1:]
+
+	if p.FoldGmailAliases {
+		for _, d := range gmailDomains {
+			if strings.EqualFold(domain, d) {
+				if plus := strings.Index(local, "+"); plus >= 0 {
+					local = local[:plus]
+				}
+				local = strings.ReplaceAll(local, ".", "")
+				// googlemail.com is an alias of gmail.com, so fold it as well.
+				domain = "gmail.com"
+				break
+			}
+		}
+	}
+
+	return local + "@" + domain
+}
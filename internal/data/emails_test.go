@@ -0,0 +1,57 @@
+package data
+
+import "testing"
+
+func TestEmailPolicyNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy EmailPolicy
+		email  string
+		want   string
+	}{
+		{"unchanged", EmailPolicy{}, "Alice@Example.com", "Alice@Example.com"},
+		{"trim", EmailPolicy{}, "  alice@example.com ", "alice@example.com"},
+		{"lowercase", EmailPolicy{Lowercase: true}, "Alice@Example.COM", "alice@example.com"},
+		{"gmail folding off", EmailPolicy{Lowercase: true}, "a.lice+news@gmail.com", "a.lice+news@gmail.com"},
+		{"gmail folding", EmailPolicy{Lowercase: true, FoldGmailAliases: true}, "A.Lice+news@Gmail.com", "alice@gmail.com"},
+		{"googlemail folding", EmailPolicy{FoldGmailAliases: true}, "a.lice@googlemail.com", "alice@gmail.com"},
+		{"other domains untouched", EmailPolicy{FoldGmailAliases: true}, "a.lice+news@example.com", "a.lice+news@example.com"},
+		{"no at sign", EmailPolicy{Lowercase: true}, "Not-An-Email", "not-an-email"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Normalize(tt.email); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEmailPolicyLookupForms(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy EmailPolicy
+		email  string
+		want   []string
+	}{
+		{"no folding", EmailPolicy{Lowercase: true}, "A.Lice+news@Gmail.com", []string{"a.lice+news@gmail.com"}},
+		{"folded", EmailPolicy{Lowercase: true, FoldGmailAliases: true}, "A.Lice+news@Gmail.com", []string{"alice@gmail.com", "a.lice+news@gmail.com"}},
+		{"already folded", EmailPolicy{Lowercase: true, FoldGmailAliases: true}, "alice@gmail.com", []string{"alice@gmail.com"}},
+		{"other domains", EmailPolicy{Lowercase: true, FoldGmailAliases: true}, "a.lice+news@example.com", []string{"a.lice+news@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.LookupForms(tt.email)
+			if len(got) != len(tt.want) {
+				t.Fatalf("want %q; got %q", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("want %q; got %q", tt.want, got)
+				}
+			}
+		})
+	}
+}
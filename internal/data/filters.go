@@ -4,15 +4,37 @@ import (
 	"math"
 	"strings"
 
+	"github.com/lib/pq"
 	"github.com/saalikmubeen/greenlight/internal/validator"
 )
 
+// Collations maps the collation names that clients can ask for with ?collation= to the
+// PostgreSQL collations used for sorting. The ICU collations ("-x-icu") sort accented letters
+// next to their unaccented forms, so that "Élite" sorts with the E's rather than after "Z".
+// "c" gives plain byte order, which is what you get when no collation is requested.
+var Collations = map[string]string{
+	"c":  "C",
+	"da": "da-x-icu",
+	"de": "de-x-icu",
+	"en": "en-x-icu",
+	"es": "es-x-icu",
+	"fr": "fr-x-icu",
+	"it": "it-x-icu",
+	"nl": "nl-x-icu",
+	"pl": "pl-x-icu",
+	"pt": "pt-x-icu",
+	"sv": "sv-x-icu",
+	"tr": "tr-x-icu",
+}
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafeList []string // A list of allowed values for the Sort field:
 	// "id", "title", "year", "runtime", "-id", "-title", "-year" or "-runtime".
+	Collation      string   // Optional key of the Collations map used to sort text columns.
+	CollateColumns []string // The sort columns that Collation applies to, e.g. "title".
 }
 
 // Metadata holds pagination metadata.
@@ -52,15 +74,33 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	// Check that the collation, if any, is one we know about.
+	if f.Collation != "" {
+		_, ok := Collations[f.Collation]
+		v.Check(ok, "collation", "invalid collation value")
+	}
 }
 
 // sortColumn checks that the client-provided Sort field matches one of the entries in our
 // SortSafeList and if it does, it extracts the column name from the Sort field by stripping the
-// leading hyphen character (if one exists).
+// leading hyphen character (if one exists). If a collation was requested and the column is one
+// of the CollateColumns, a COLLATE clause with the quoted collation name is appended, so the
+// result can be interpolated into the ORDER BY clause as it is.
 func (f Filters) sortColumn() string {
 	for _, safeValue := range f.SortSafeList {
 		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+			column := strings.TrimPrefix(f.Sort, "-")
+
+			if f.Collation != "" && validator.In(column, f.CollateColumns...) {
+				collation, ok := Collations[f.Collation]
+				if !ok {
+					panic("unsafe collation parameter:" + f.Collation)
+				}
+				return column + " COLLATE " + pq.QuoteIdentifier(collation)
+			}
+
+			return column
 		}
 	}
 
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// IdentityModel struct wraps a sql.DB connection pool and allows us to work with the
+// idp_identities table, which links local accounts to users of an external identity provider.
+type IdentityModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// GetAllForProvider returns the IDs of the accounts linked to the provider, keyed by the
+// provider's external ID.
+func (m IdentityModel) GetAllForProvider(provider string) (map[string]int64, error) {
+	query := `
+		SELECT external_id, user_id
+		FROM idp_identities
+		WHERE provider = $1
+		`
+
+	ctx, cancel := queryContext("IdentityModel.GetAllForProvider", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	identities := make(map[string]int64)
+
+	for rows.Next() {
+		var (
+			externalID string
+			userID     int64
+		)
+
+		err := rows.Scan(&externalID, &userID)
+		if err != nil {
+			return nil, err
+		}
+
+		identities[externalID] = userID
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// Link links an account to a user of the provider, replacing any previous link of the account.
+func (m IdentityModel) Link(userID int64, provider, externalID string) error {
+	query := `
+		INSERT INTO idp_identities (user_id, provider, external_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id)
+		DO UPDATE SET provider = EXCLUDED.provider, external_id = EXCLUDED.external_id, linked_at = NOW()
+		`
+
+	ctx, cancel := queryContext("IdentityModel.Link", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, provider, externalID)
+	return err
+}
@@ -0,0 +1,555 @@
+package data
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// The visibility of a movie list:
+// 'public' lists are shown on the owner's profile, 'unlisted' lists can be seen by anyone who has
+// the link and 'private' lists only by the owner and the collaborators.
+const (
+	ListPublic   = "public"
+	ListUnlisted = "unlisted"
+	ListPrivate  = "private"
+)
+
+// The role a user has on a movie list, see MovieListModel.Role.
+const (
+	ListRoleOwner        = "owner"
+	ListRoleCollaborator = "collaborator"
+	ListRoleInvited      = "invited"
+)
+
+// ErrDuplicateCollaborator is returned when inviting a user who is already a collaborator on, or
+// already invited to, a list.
+var ErrDuplicateCollaborator = errors.New("duplicate collaborator")
+
+// MovieList is a named list of movies kept by a user, which can be shared through its slug.
+type MovieList struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	OwnerID     int64     `json:"owner_id"`
+	Slug        string    `json:"slug"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Visibility  string    `json:"visibility"`
+	Version     int32     `json:"version"`
+	Movies      []*Movie  `json:"movies,omitempty"`
+}
+
+// ListCollaborator is a user invited to edit a movie list. AcceptedAt is nil until the
+// invitation has been accepted.
+type ListCollaborator struct {
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	InvitedAt  time.Time  `json:"invited_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+}
+
+// MovieListModel struct wraps a sql.DB connection pool and allows us to work with the MovieList
+// struct type and the movie_lists, movie_list_items and movie_list_collaborators tables.
+type MovieListModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert creates a new list with a freshly generated slug.
+func (m MovieListModel) Insert(list *MovieList) error {
+	slug, err := generateListSlug()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_lists (owner_id, slug, name, description, visibility)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at, version
+		`
+
+	args := []interface{}{list.OwnerID, slug, list.Name, list.Description, list.Visibility}
+
+	ctx, cancel := queryContext("MovieListModel.Insert", 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&list.ID,
+		&list.CreatedAt,
+		&list.UpdatedAt,
+		&list.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	list.Slug = slug
+
+	return nil
+}
+
+// GetBySlug fetches a list (without its movies) by its slug.
+func (m MovieListModel) GetBySlug(slug string) (*MovieList, error) {
+	query := `
+		SELECT id, created_at, updated_at, owner_id, slug, name, description, visibility, version
+		FROM movie_lists
+		WHERE slug = $1
+		`
+
+	ctx, cancel := queryContext("MovieListModel.GetBySlug", 3*time.Second)
+	defer cancel()
+
+	list, err := scanMovieList(m.DB.QueryRowContext(ctx, query, slug))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// GetAllForUser returns the lists a user owns or has accepted an invitation to edit, most
+// recently updated first.
+func (m MovieListModel) GetAllForUser(userID int64) ([]*MovieList, error) {
+	query := `
+		SELECT id, created_at, updated_at, owner_id, slug, name, description, visibility, version
+		FROM movie_lists
+		WHERE owner_id = $1
+			OR id IN (
+				SELECT list_id FROM movie_list_collaborators
+				WHERE user_id = $1 AND accepted_at IS NOT NULL
+			)
+		ORDER BY updated_at DESC, id DESC
+		`
+
+	return m.query(query, userID)
+}
+
+// GetPublicForOwner returns the public lists of a user, most recently updated first.
+func (m MovieListModel) GetPublicForOwner(ownerID int64) ([]*MovieList, error) {
+	query := `
+		SELECT id, created_at, updated_at, owner_id, slug, name, description, visibility, version
+		FROM movie_lists
+		WHERE owner_id = $1 AND visibility = 'public'
+		ORDER BY updated_at DESC, id DESC
+		`
+
+	return m.query(query, ownerID)
+}
+
+func (m MovieListModel) query(query string, args ...interface{}) ([]*MovieList, error) {
+	ctx, cancel := queryContext("MovieListModel.query", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	lists := []*MovieList{}
+
+	for rows.Next() {
+		list, err := scanMovieList(rows)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+// Update updates the name, description and visibility of a list, using the version number for
+// optimistic locking in the same way as MovieModel.Update.
+func (m MovieListModel) Update(list *MovieList) error {
+	query := `
+		UPDATE movie_lists
+		SET name = $1, description = $2, visibility = $3, updated_at = NOW(), version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING updated_at, version
+		`
+
+	args := []interface{}{list.Name, list.Description, list.Visibility, list.ID, list.Version}
+
+	ctx, cancel := queryContext("MovieListModel.Update", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.UpdatedAt, &list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a list together with its movies and collaborators.
+func (m MovieListModel) Delete(id int64) error {
+	query := `
+		DELETE FROM movie_lists
+		WHERE id = $1
+		`
+
+	ctx, cancel := queryContext("MovieListModel.Delete", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetMovies returns the movies on a list in the order they were added.
+func (m MovieListModel) GetMovies(listID int64) ([]*Movie, error) {
+	query := `
+		SELECT m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.certification, m.version
+		FROM movie_list_items i
+		INNER JOIN movies m ON m.id = i.movie_id
+		WHERE i.list_id = $1
+		ORDER BY i.added_at ASC, m.id ASC
+		`
+
+	ctx, cancel := queryContext("MovieListModel.GetMovies", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Certification,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// AddMovie adds a movie to a list and bumps the list's updated_at. Adding a movie which is
+// already on the list is not an error.
+func (m MovieListModel) AddMovie(listID, movieID, userID int64) error {
+	query := `
+		WITH added AS (
+			INSERT INTO movie_list_items (list_id, movie_id, added_by)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (list_id, movie_id) DO NOTHING
+			RETURNING list_id
+		)
+		UPDATE movie_lists SET updated_at = NOW()
+		WHERE id IN (SELECT list_id FROM added)
+		`
+
+	ctx, cancel := queryContext("MovieListModel.AddMovie", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, listID, movieID, userID)
+	return err
+}
+
+// RemoveMovie removes a movie from a list, or returns ErrRecordNotFound if it isn't on it.
+func (m MovieListModel) RemoveMovie(listID, movieID int64) error {
+	query := `
+		WITH removed AS (
+			DELETE FROM movie_list_items
+			WHERE list_id = $1 AND movie_id = $2
+			RETURNING list_id
+		)
+		UPDATE movie_lists SET updated_at = NOW()
+		WHERE id IN (SELECT list_id FROM removed)
+		`
+
+	ctx, cancel := queryContext("MovieListModel.RemoveMovie", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, listID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Role returns the role a user has on a list: ListRoleOwner, ListRoleCollaborator,
+// ListRoleInvited or "" if the user has no role.
+func (m MovieListModel) Role(list *MovieList, userID int64) (string, error) {
+	if list.OwnerID == userID {
+		return ListRoleOwner, nil
+	}
+
+	query := `
+		SELECT accepted_at IS NOT NULL
+		FROM movie_list_collaborators
+		WHERE list_id = $1 AND user_id = $2
+		`
+
+	var accepted bool
+
+	ctx, cancel := queryContext("MovieListModel.Role", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, list.ID, userID).Scan(&accepted)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", nil
+		default:
+			return "", err
+		}
+	}
+
+	if accepted {
+		return ListRoleCollaborator, nil
+	}
+
+	return ListRoleInvited, nil
+}
+
+// InviteCollaborator records an invitation for a user to edit a list. It returns
+// ErrDuplicateCollaborator if the user has already been invited.
+func (m MovieListModel) InviteCollaborator(listID, userID int64) (*ListCollaborator, error) {
+	query := `
+		INSERT INTO movie_list_collaborators (list_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (list_id, user_id) DO NOTHING
+		RETURNING user_id, invited_at
+		`
+
+	var collaborator ListCollaborator
+
+	ctx, cancel := queryContext("MovieListModel.InviteCollaborator", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, listID, userID).Scan(
+		&collaborator.UserID,
+		&collaborator.InvitedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrDuplicateCollaborator
+		default:
+			return nil, err
+		}
+	}
+
+	return &collaborator, nil
+}
+
+// AcceptInvitation marks the invitation of a user to edit a list as accepted. It returns
+// ErrRecordNotFound if there is no pending invitation.
+func (m MovieListModel) AcceptInvitation(listID, userID int64) error {
+	query := `
+		UPDATE movie_list_collaborators
+		SET accepted_at = NOW()
+		WHERE list_id = $1 AND user_id = $2 AND accepted_at IS NULL
+		`
+
+	ctx, cancel := queryContext("MovieListModel.AcceptInvitation", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, listID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RemoveCollaborator removes a collaborator, or withdraws a pending invitation.
+func (m MovieListModel) RemoveCollaborator(listID, userID int64) error {
+	query := `
+		DELETE FROM movie_list_collaborators
+		WHERE list_id = $1 AND user_id = $2
+		`
+
+	ctx, cancel := queryContext("MovieListModel.RemoveCollaborator", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, listID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetCollaborators returns the collaborators of a list, including pending invitations, in the
+// order they were invited.
+func (m MovieListModel) GetCollaborators(listID int64) ([]*ListCollaborator, error) {
+	query := `
+		SELECT c.user_id, u.name, c.invited_at, c.accepted_at
+		FROM movie_list_collaborators c
+		INNER JOIN users u ON u.id = c.user_id
+		WHERE c.list_id = $1
+		ORDER BY c.invited_at ASC, c.user_id ASC
+		`
+
+	ctx, cancel := queryContext("MovieListModel.GetCollaborators", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	collaborators := []*ListCollaborator{}
+
+	for rows.Next() {
+		var collaborator ListCollaborator
+
+		err := rows.Scan(
+			&collaborator.UserID,
+			&collaborator.Name,
+			&collaborator.InvitedAt,
+			&collaborator.AcceptedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		collaborators = append(collaborators, &collaborator)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collaborators, nil
+}
+
+func scanMovieList(row rowScanner) (*MovieList, error) {
+	var list MovieList
+
+	err := row.Scan(
+		&list.ID,
+		&list.CreatedAt,
+		&list.UpdatedAt,
+		&list.OwnerID,
+		&list.Slug,
+		&list.Name,
+		&list.Description,
+		&list.Visibility,
+		&list.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// generateListSlug returns a random 16 character slug. It carries 80 bits of randomness, so the
+// slugs of unlisted lists can't be guessed.
+func generateListSlug() (string, error) {
+	randomBytes := make([]byte, 10)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)), nil
+}
+
+// ValidateMovieList runs validation checks on the MovieList type.
+func ValidateMovieList(v *validator.Validator, list *MovieList) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(list.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+	v.Check(validator.In(list.Visibility, ListPublic, ListUnlisted, ListPrivate), "visibility", "must be public, unlisted or private")
+}
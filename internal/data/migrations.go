@@ -0,0 +1,53 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MigrationStatus is the state of the database schema as recorded by golang-migrate. Dirty
+// is set when a migration failed part way through and the schema needs fixing by hand.
+type MigrationStatus struct {
+	Version int64 `json:"version"`
+	Dirty   bool  `json:"dirty"`
+}
+
+// MigrationModel struct wraps a sql.DB connection pool and allows us to read the
+// schema_migrations table golang-migrate keeps in our database.
+type MigrationModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Status returns the version of the latest migration applied to the database. A database
+// which migrations were never run against is at version 0.
+func (m MigrationModel) Status() (MigrationStatus, error) {
+	query := `
+		SELECT version, dirty
+		FROM schema_migrations
+		LIMIT 1`
+
+	ctx, cancel := queryContext("MigrationModel.Status", 3*time.Second)
+	defer cancel()
+
+	var status MigrationStatus
+	err := m.DB.QueryRowContext(ctx, query).Scan(&status.Version, &status.Dirty)
+	if err != nil {
+		var pgErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return MigrationStatus{}, nil
+		case errors.As(err, &pgErr) && pgErr.Code == "42P01": // undefined_table
+			return MigrationStatus{}, nil
+		default:
+			return MigrationStatus{}, err
+		}
+	}
+
+	return status, nil
+}
@@ -1,5 +1,10 @@
 package data
 
+import (
+	"sync"
+	"time"
+)
+
 // Mocking models
 
 type Models2 struct {
@@ -8,37 +13,230 @@ type Models2 struct {
 	Movies interface {
 		Insert(movie *Movie) error
 		Get(id int64) (*Movie, error)
+		GetByTitle(title string, year int32) (*Movie, error)
 		Update(movie *Movie) error
 		Delete(id int64) error
 	}
+	Users interface {
+		Insert(user *User) error
+		Get(id int64) (*User, error)
+		GetByEmail(email string) (*User, error)
+	}
+	Tokens interface {
+		Insert(token *Token) error
+	}
+	Permissions interface {
+		GetAllForUser(userID int64) (Permissions, error)
+		AddForUser(userID int64, codes ...string) error
+	}
+}
+
+// Interfaces returns the real models as a Models2, so that code written against Models2 (such
+// as the test fixtures) works with both the real and the mock models.
+func (m Models) Interfaces() Models2 {
+	return Models2{
+		Movies:      m.Movies,
+		Users:       m.Users,
+		Tokens:      m.Tokens,
+		Permissions: m.Permissions,
+	}
 }
 
 // Create a helper function which returns a Models instance containing the mock models // only.
+// The mock models keep their records in memory, in a store shared between them.
 func NewMockModels() Models2 {
+	store := &mockStore{
+		movies:      make(map[int64]*Movie),
+		users:       make(map[int64]*User),
+		permissions: make(map[int64]Permissions),
+	}
+
 	return Models2{
-		Movies: MockMovieModel{},
+		Movies:      MockMovieModel{store: store},
+		Users:       MockUserModel{store: store},
+		Tokens:      MockTokenModel{store: store},
+		Permissions: MockPermissionModel{store: store},
 	}
 }
 
-type MockMovieModel struct{}
+// mockStore holds the records of the mock models. Records are copied in and out, so callers
+// can't change a stored record without going through the model, just like with the database.
+type mockStore struct {
+	mu          sync.Mutex
+	lastID      int64
+	movies      map[int64]*Movie
+	users       map[int64]*User
+	tokens      []Token
+	permissions map[int64]Permissions
+}
+
+func (s *mockStore) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+type MockMovieModel struct {
+	store *mockStore
+}
 
 func (m MockMovieModel) Insert(movie *Movie) error {
-	// Mock the action...
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	movie.ID = m.store.nextID()
+	movie.CreatedAt = time.Now()
+	movie.Version = 1
+
+	stored := *movie
+	m.store.movies[movie.ID] = &stored
 	return nil
 }
 
 func (m MockMovieModel) Get(id int64) (*Movie, error) {
-	// Mock the action...
-	return nil, nil
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	movie, ok := m.store.movies[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	found := *movie
+	return &found, nil
+}
+
+func (m MockMovieModel) GetByTitle(title string, year int32) (*Movie, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var found *Movie
+	for _, movie := range m.store.movies {
+		if movie.Title == title && movie.Year == year && (found == nil || movie.ID < found.ID) {
+			found = movie
+		}
+	}
+	if found == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	movie := *found
+	return &movie, nil
 }
 
 func (m MockMovieModel) Update(movie *Movie) error {
-	// Mock the action...
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.movies[movie.ID]
+	if !ok || stored.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	movie.Version++
+
+	updated := *movie
+	m.store.movies[movie.ID] = &updated
 	return nil
 }
 
 func (m MockMovieModel) Delete(id int64) error {
-	// Mock the action...
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.movies[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.movies, id)
+	return nil
+}
+
+type MockUserModel struct {
+	store *mockStore
+}
+
+func (m MockUserModel) Insert(user *User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	user.Email = NormalizeEmail(user.Email)
+
+	for _, existing := range m.store.users {
+		if existing.Email == user.Email {
+			return ErrDuplicateEmail
+		}
+	}
+
+	user.ID = m.store.nextID()
+	user.CreatedAt = time.Now()
+	user.Version = 1
+
+	stored := *user
+	m.store.users[user.ID] = &stored
+	return nil
+}
+
+func (m MockUserModel) Get(id int64) (*User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	user, ok := m.store.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	found := *user
+	return &found, nil
+}
+
+func (m MockUserModel) GetByEmail(email string) (*User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, email := range EmailNormalization.LookupForms(email) {
+		for _, user := range m.store.users {
+			if user.Email == email {
+				found := *user
+				return &found, nil
+			}
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+type MockTokenModel struct {
+	store *mockStore
+}
+
+func (m MockTokenModel) Insert(token *Token) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.tokens = append(m.store.tokens, *token)
+	return nil
+}
+
+type MockPermissionModel struct {
+	store *mockStore
+}
+
+func (m MockPermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return append(Permissions(nil), m.store.permissions[userID]...), nil
+}
+
+func (m MockPermissionModel) AddForUser(userID int64, codes ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, code := range codes {
+		if !m.store.permissions[userID].Contains(code) {
+			m.store.permissions[userID] = append(m.store.permissions[userID], code)
+		}
+	}
 	return nil
 }
 
@@ -33,21 +33,63 @@ type Models struct {
 	Users       UserModel
 	Tokens      TokenModel
 	Permissions PermissionModel
+	Comments    CommentModel
+	Notes       NoteModel
+	TrialTokens TrialTokenModel
+	BulkOps     BulkOperationModel
+	Changes     ChangeModel
+	ViewAs      ViewAsAuditModel
+	Webhooks    WebhookEventModel
+	Suppressed  SuppressionModel
+	Lists       MovieListModel
+	Identities  IdentityModel
+	// Subscriptions and Deliveries hold the outbound webhook subscriptions and their deliveries.
+	Subscriptions WebhookSubscriptionModel
+	Deliveries    WebhookDeliveryModel
+	// Outbox holds the emails and webhook events to be sent once their transaction commits.
+	Outbox OutboxModel
+	// AuditLog records every change made through the API.
+	AuditLog AuditLogModel
+	// Migrations reports which schema migrations have been applied.
+	Migrations MigrationModel
+	// Usage counts the requests of each user per month, for the monthly quotas.
+	Usage UsageModel
+	// Popularity holds the popularity scores recomputed by the scheduled job.
+	Popularity PopularityModel
+	// DeadLetters holds the emails which couldn't be sent, for administrators to requeue.
+	DeadLetters DeadLetterModel
+	// Organizations holds the teams sharing the deployment, and their members.
+	Organizations OrganizationModel
+	// AuthEvents records the logins, activations and password resets of the users.
+	AuthEvents AuthEventModel
+
+	// db is the connection pool transactions are started on, see Begin.
+	db *sql.DB
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns the models for the db connection pool. If replica isn't nil, the read-only
+// queries which can tolerate replication lag are sent to it instead, see ReadPool.
+func NewModels(db, replica *sql.DB) Models {
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	var reads *ReadPool
+	if replica != nil {
+		reads = NewReadPool(db, replica, errorLog)
+	}
+
 	return Models{
 		Movies: MovieModel{
 			DB:       db,
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
+			Replica:  reads,
 		},
 		Users: UserModel{
 			DB:       db,
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
+			Replica:  reads,
 		},
 		Tokens: TokenModel{
 			DB:       db,
@@ -59,5 +101,106 @@ func NewModels(db *sql.DB) Models {
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
 		},
+		Comments: CommentModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Notes: NoteModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		TrialTokens: TrialTokenModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		BulkOps: BulkOperationModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Changes: ChangeModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		ViewAs: ViewAsAuditModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Webhooks: WebhookEventModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Suppressed: SuppressionModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Lists: MovieListModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Identities: IdentityModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Subscriptions: WebhookSubscriptionModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Deliveries: WebhookDeliveryModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Outbox: OutboxModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		AuditLog: AuditLogModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Migrations: MigrationModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Usage: UsageModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Popularity: PopularityModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		DeadLetters: DeadLetterModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Organizations: OrganizationModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		AuthEvents: AuthEventModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		db: db,
 	}
 }
@@ -0,0 +1,224 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieBatch runs a series of movie reads, updates and deletes in a single database
+// transaction. Movies read with Get() are locked until the batch is committed or rolled back,
+// so the checks a caller makes on them still hold when the changes are committed.
+type MovieBatch struct {
+	tx     *sql.Tx
+	ctx    context.Context
+	cancel context.CancelFunc
+	// cache is the MovieCache the changes are applied to once the batch commits.
+	cache *MovieCache
+	// owner is the user whose movies the batch is restricted to, see MovieModel.OwnedBy.
+	owner int64
+	// org is the catalogue the batch is restricted to, see MovieModel.orgArg.
+	org     int64
+	updated []*Movie
+	deleted []int64
+}
+
+// BeginBatch starts a new MovieBatch. The whole batch must finish within timeout, and the
+// caller must always call either Commit() or Rollback().
+func (m MovieModel) BeginBatch(timeout time.Duration) (*MovieBatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &MovieBatch{tx: tx, ctx: ctx, cancel: cancel, cache: m.Cache, owner: m.owner, org: m.orgArg()}, nil
+}
+
+// Lock locks the rows of the movies with the given ids for the rest of the batch, in ascending
+// id order whatever the order of ids. Batches lock the rows they change one by one as they go,
+// so two batches changing the same movies in opposite orders would deadlock; locking them all
+// in the same order up front rules that out. Ids of missing movies are ignored.
+func (b *MovieBatch) Lock(ids []int64) error {
+	query := `
+		SELECT id
+		FROM movies
+		WHERE id = ANY($1)
+		AND ($2 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($2, 0))
+		ORDER BY id
+		FOR UPDATE
+		`
+
+	rows, err := b.tx.QueryContext(b.ctx, query, pq.Array(sortedUniqueIDs(ids)), b.org)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// The rows are locked as they are read.
+	for rows.Next() {
+	}
+
+	return rows.Err()
+}
+
+// sortedUniqueIDs returns ids in ascending order, without duplicates.
+func sortedUniqueIDs(ids []int64) []int64 {
+	sorted := make([]int64, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			sorted = append(sorted, id)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return sorted
+}
+
+// Get fetches a movie and locks its row for the rest of the batch.
+func (b *MovieBatch) Get(id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, certification, version,
+			COALESCE(created_by, 0), COALESCE(org_id, 0)
+		FROM movies
+		WHERE id = $1
+		AND ($2 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($2, 0))
+		FOR UPDATE
+		`
+
+	var movie Movie
+
+	err := b.tx.QueryRowContext(b.ctx, query, id, b.org).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Certification,
+		&movie.Version,
+		&movie.CreatedBy,
+		&movie.OrgID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// Update updates a movie within the batch, using the same optimistic locking as
+// MovieModel.Update.
+func (b *MovieBatch) Update(movie *Movie) error {
+	query := `
+		UPDATE movies
+		SET title = $1, year = $2, runtime = $3, genres = $4, certification = $5,
+			version = version + 1
+		WHERE id = $6 AND version = $7 AND (created_by = $8 OR $8 = 0)
+		AND ($9 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($9, 0))
+		RETURNING version
+		`
+
+	args := []interface{}{
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(movie.Genres),
+		movie.Certification,
+		movie.ID,
+		movie.Version,
+		b.owner,
+		b.org,
+	}
+
+	err := b.tx.QueryRowContext(b.ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	b.updated = append(b.updated, copyMovie(movie))
+	return nil
+}
+
+// Delete deletes a movie within the batch.
+func (b *MovieBatch) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM movies
+		WHERE id = $1 AND (created_by = $2 OR $2 = 0)
+		AND ($3 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($3, 0))
+		`
+
+	result, err := b.tx.ExecContext(b.ctx, query, id, b.owner, b.org)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	b.deleted = append(b.deleted, id)
+	return nil
+}
+
+// Commit commits every change made in the batch.
+func (b *MovieBatch) Commit() error {
+	defer b.cancel()
+
+	err := b.tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range b.updated {
+		b.cache.Add(movie)
+	}
+	for _, id := range b.deleted {
+		b.cache.Delete(id)
+	}
+	return nil
+}
+
+// Rollback discards every change made in the batch. It is safe to call after Commit(), in
+// which case it does nothing, so it can be deferred.
+func (b *MovieBatch) Rollback() error {
+	defer b.cancel()
+
+	err := b.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,15 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortedUniqueIDs(t *testing.T) {
+	got := sortedUniqueIDs([]int64{42, 7, 19, 7, 1})
+	want := []int64{1, 7, 19, 42}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
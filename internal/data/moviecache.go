@@ -0,0 +1,183 @@
+package data
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// MovieCacheStats counts the lookups made in a MovieCache.
+type MovieCacheStats struct {
+	Entries int   `json:"entries"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+// movieCacheEntry is a movie held by a MovieCache. A nil movie records that the movie was
+// deleted, so that a read from a lagging replica can't bring it back.
+type movieCacheEntry struct {
+	id      int64
+	movie   *Movie
+	expires time.Time
+}
+
+// MovieCache holds the most recently used movies in memory, in front of MovieModel.Get. Every
+// entry is replaced only by a newer version of the movie, so that a read which raced with an
+// update can't put back the version it replaced. Entries expire after a while, which bounds how
+// long a change made somewhere the cache doesn't hear about, such as another instance without
+// the database listener, goes unseen.
+//
+// A nil *MovieCache is valid and caches nothing. Create one with NewMovieCache.
+type MovieCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[int64]*list.Element
+	// lru holds the entries, the most recently used first.
+	lru    *list.List
+	hits   int64
+	misses int64
+	now    func() time.Time
+}
+
+// NewMovieCache returns a cache holding up to size movies for ttl each.
+func NewMovieCache(size int, ttl time.Duration) *MovieCache {
+	return &MovieCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[int64]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get returns a copy of the movie with id. found is false if it isn't in the cache, and movie
+// is nil if the movie is known to have been deleted.
+func (c *MovieCache) Get(id int64) (movie *Movie, found bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if ok && !c.now().Before(el.Value.(*movieCacheEntry).expires) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.lru.MoveToFront(el)
+	return copyMovie(el.Value.(*movieCacheEntry).movie), true
+}
+
+// Add stores a copy of movie, unless the cache already holds the same or a newer version of it
+// or knows it was deleted.
+func (c *MovieCache) Add(movie *Movie) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[movie.ID]; ok {
+		cached := el.Value.(*movieCacheEntry)
+		if c.now().Before(cached.expires) && (cached.movie == nil || cached.movie.Version >= movie.Version) {
+			return
+		}
+	}
+
+	c.set(movie.ID, copyMovie(movie))
+}
+
+// Delete records that the movie with id was deleted.
+func (c *MovieCache) Delete(id int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(id, nil)
+}
+
+// Expire drops the movie with id if the cache holds a version of it older than version. It
+// keeps a record of the movie being deleted.
+func (c *MovieCache) Expire(id int64, version int32) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		if movie := el.Value.(*movieCacheEntry).movie; movie != nil && movie.Version < version {
+			c.remove(el)
+		}
+	}
+}
+
+// Purge drops every movie.
+func (c *MovieCache) Purge() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[int64]*list.Element)
+	c.lru.Init()
+}
+
+// Stats returns the number of movies held and the lookups made so far.
+func (c *MovieCache) Stats() MovieCacheStats {
+	if c == nil {
+		return MovieCacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return MovieCacheStats{Entries: c.lru.Len(), Hits: c.hits, Misses: c.misses}
+}
+
+// set stores movie under id, evicting the least recently used entries if the cache is full.
+// The caller must hold c.mu.
+func (c *MovieCache) set(id int64, movie *Movie) {
+	if el, ok := c.entries[id]; ok {
+		c.remove(el)
+	}
+	if c.size < 1 {
+		return
+	}
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+
+	c.entries[id] = c.lru.PushFront(&movieCacheEntry{id: id, movie: movie, expires: c.now().Add(c.ttl)})
+}
+
+// remove drops the entry held by el. The caller must hold c.mu.
+func (c *MovieCache) remove(el *list.Element) {
+	delete(c.entries, c.lru.Remove(el).(*movieCacheEntry).id)
+}
+
+// copyMovie returns a copy of movie which shares nothing with it, or nil if movie is nil.
+func copyMovie(movie *Movie) *Movie {
+	if movie == nil {
+		return nil
+	}
+
+	cp := *movie
+	cp.Genres = append([]string(nil), movie.Genres...)
+	return &cp
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMovieCache(t *testing.T) {
+	c := NewMovieCache(2, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Add(&Movie{ID: 1, Title: "Moana", Genres: []string{"animation"}, Version: 2})
+
+	movie, found := c.Get(1)
+	if !found || movie.Title != "Moana" {
+		t.Fatalf("got %+v, %t; want the movie added", movie, found)
+	}
+	movie.Genres[0] = "changed"
+	if movie, _ := c.Get(1); movie.Genres[0] != "animation" {
+		t.Error("want Get to return a copy the caller can change")
+	}
+
+	c.Add(&Movie{ID: 1, Title: "Stale", Version: 1})
+	if movie, _ := c.Get(1); movie.Version != 2 {
+		t.Errorf("got version %d; want an older version not to replace a newer one", movie.Version)
+	}
+	c.Add(&Movie{ID: 1, Title: "Moana 2", Version: 3})
+	if movie, _ := c.Get(1); movie.Version != 3 {
+		t.Errorf("got version %d; want the newer version", movie.Version)
+	}
+
+	c.Delete(1)
+	c.Add(&Movie{ID: 1, Title: "Moana 2", Version: 3})
+	if movie, found := c.Get(1); !found || movie != nil {
+		t.Errorf("got %+v, %t; want the movie known to be deleted", movie, found)
+	}
+
+	c.Add(&Movie{ID: 2, Version: 1})
+	c.Add(&Movie{ID: 3, Version: 1})
+	if _, found := c.Get(1); found {
+		t.Error("want the least recently used movie evicted")
+	}
+
+	now = now.Add(time.Minute)
+	if _, found := c.Get(2); found {
+		t.Error("want the movie expired after the TTL")
+	}
+
+	if got := c.Stats(); got.Hits != 5 || got.Misses != 2 || got.Entries != 1 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestMovieCacheExpire(t *testing.T) {
+	c := NewMovieCache(10, time.Minute)
+	c.Add(&Movie{ID: 1, Version: 2})
+	c.Add(&Movie{ID: 2, Version: 1})
+	c.Delete(3)
+
+	c.Expire(1, 2)
+	c.Expire(2, 2)
+	c.Expire(3, 2)
+
+	if _, found := c.Get(1); !found {
+		t.Error("want the movie kept when it's already at the version")
+	}
+	if _, found := c.Get(2); found {
+		t.Error("want the older version dropped")
+	}
+	if movie, found := c.Get(3); !found || movie != nil {
+		t.Error("want the record of the deletion kept")
+	}
+
+	c.Purge()
+	if got := c.Stats().Entries; got != 0 {
+		t.Errorf("got %d movies after Purge; want 0", got)
+	}
+}
+
+func TestNilMovieCache(t *testing.T) {
+	var c *MovieCache
+	c.Add(&Movie{ID: 1, Version: 1})
+	c.Delete(1)
+	if _, found := c.Get(1); found {
+		t.Error("want a nil cache to hold nothing")
+	}
+}
@@ -24,8 +24,16 @@ type Movie struct {
 	Year      int32     `json:"year,omitempty"` // Movie release year0
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"` // The version number starts at 1 and is incremented each
+	// Certification is the age rating, e.g. "US:PG-13". It's optional, so omitted when empty.
+	Certification Certification `json:"certification,omitempty"`
+	Version       int32         `json:"version"` // The version number starts at 1 and is incremented each
 	// time the movie information is updated.
+	// CreatedBy is the ID of the user who created the movie, or 0 if it isn't known, for the
+	// movies created before it was recorded or whose creator was deleted.
+	CreatedBy int64 `json:"-"`
+	// OrgID is the ID of the organization whose catalogue the movie belongs to, or 0 for the
+	// shared catalogue.
+	OrgID int64 `json:"-"`
 }
 
 // MovieModel struct wraps a sql.DB connection pool and allows us to work with Movie struct type
@@ -34,14 +42,87 @@ type MovieModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	// Replica, if set, is where Get, GetAll and Count read from.
+	Replica *ReadPool
+	// Cache, if set, holds the movies Get returned recently, see MovieCache.
+	Cache *MovieCache
+	// primary is set on the models returned by Primary.
+	primary bool
+	// owner is set on the models returned by OwnedBy.
+	owner int64
+	// org and orgScoped are set on the models returned by InOrg.
+	org       int64
+	orgScoped bool
+}
+
+// reader returns what the read-only queries run on: the replica if there is one, or else the
+// primary.
+func (m MovieModel) reader() readQuerier {
+	if m.Replica != nil {
+		return m.Replica
+	}
+	return m.DB
+}
+
+// Primary returns a MovieModel which reads from the primary, for reads which must see writes
+// the replica may not have caught up with yet, such as the version an update is checked against.
+// Its Get bypasses the cache for the same reason, but still refreshes it.
+func (m MovieModel) Primary() MovieModel {
+	m.Replica = nil
+	m.primary = true
+	return m
+}
+
+// OwnedBy returns a MovieModel whose Update, Delete, DeleteVersion and BeginBatch only change
+// the movies created by the user userID, for the users who may only change their own movies.
+// The other movies are treated as if they had been changed or deleted in the meantime. The
+// callers check the ownership first to tell the user why, this is the guarantee in the queries.
+func (m MovieModel) OwnedBy(userID int64) MovieModel {
+	m.owner = userID
+	return m
+}
+
+// InOrg returns a MovieModel restricted to the catalogue of the organization orgID, or to the
+// shared catalogue if orgID is 0. Its Get reports the movies of the other catalogues as not
+// found, GetAll, Count and DeleteBatch only see the movies of the catalogue, and Update,
+// Delete, DeleteVersion and BeginBatch only change them. The unrestricted MovieModel sees every
+// catalogue, for the background jobs which aren't run on behalf of a team.
+func (m MovieModel) InOrg(orgID int64) MovieModel {
+	m.org = orgID
+	m.orgScoped = true
+	return m
+}
+
+// orgArg returns the argument of the org_id conditions in the queries: the organization the
+// model is restricted to, 0 for the shared catalogue, or -1 for every catalogue.
+func (m MovieModel) orgArg() int64 {
+	if !m.orgScoped {
+		return -1
+	}
+	return m.org
+}
+
+// inCatalogue reports whether movie belongs to the catalogue the model is restricted to.
+func (m MovieModel) inCatalogue(movie *Movie) bool {
+	return !m.orgScoped || movie.OrgID == m.org
 }
 
 // Insert accepts a pointer to a movie struct, which should contain the data for the
 // new record and inserts the record into the movies table.
 func (m MovieModel) Insert(movie *Movie) error {
+	// Create a context with a 3-second timeout.
+	ctx, cancel := queryContext("MovieModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return insertMovie(ctx, m.DB, movie)
+}
+
+// insertMovie runs the query of MovieModel.Insert on q, which is either the connection pool or
+// a transaction, see Tx.
+func insertMovie(ctx context.Context, q querier, movie *Movie) error {
 	query := `
-		INSERT INTO movies (title, year, runtime, genres) 
-		VALUES ($1, $2, $3, $4) 
+		INSERT INTO movies (title, year, runtime, genres, certification, created_by, org_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7, 0))
 		RETURNING id, created_at, version
 		`
 
@@ -49,19 +130,15 @@ func (m MovieModel) Insert(movie *Movie) error {
 	// (it’s not part of the SQL standard) that you can use to return values from any record
 	// that is being manipulated by an INSERT, UPDATE or DELETE statement
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	// Create an args slice containing the values for the placeholder parameters from the movie
 	// struct. Declaring this slice immediately next to our SQL query helps to make it nice and
 	// clear *what values are being user where* in the query
 
 	// You can also use the pq.Array() adapter function in the same way with []bool, []byte,
 	//  []int32, []int64, []float32 and []float64 slices in your Go code.
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.Certification, movie.CreatedBy, movie.OrgID}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return q.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
 
 // Get fetches a record from the movies table and returns the corresponding Movie struct.
@@ -75,6 +152,15 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		return nil, ErrRecordNotFound
 	}
 
+	if !m.primary {
+		if movie, found := m.Cache.Get(id); found {
+			if movie == nil || !m.inCatalogue(movie) {
+				return nil, ErrRecordNotFound
+			}
+			return movie, nil
+		}
+	}
+
 	// query := `
 	// 	SELECT pg_sleep(10) id, created_at, title, year, runtime, genres, version
 	//     FROM movies
@@ -82,17 +168,19 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	// 	`
 
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT id, created_at, title, year, runtime, genres, certification, version,
+			COALESCE(created_by, 0), COALESCE(org_id, 0)
         FROM movies
  		WHERE id = $1
  		`
 
 	var movie Movie
 
-	// Use the context.WithTimeout() function to create a context.Context which carries a 3-second
-	// timeout deadline. Note, that we're using the empty context.Background() as the
-	// 'parent' context.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Use queryContext(), which wraps the context.WithTimeout() function, to create a
+	// context.Context which carries a 3-second timeout deadline. Note, that it uses the empty
+	// context.Background() as the 'parent' context. Its cancel function also records how long
+	// the method took, see querystats.go.
+	ctx, cancel := queryContext("MovieModel.Get", 3*time.Second)
 	//
 	// ** Defer cancel() **
 	// Defer cancel to make sure that we cancel the context before the Get() method returns
@@ -114,14 +202,17 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	// Use the QueryRowContext() method to execute the query, passing in the context with the
 	// deadline ctx as the first argument.
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.reader().QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
-		&movie.Version)
+		&movie.Certification,
+		&movie.Version,
+		&movie.CreatedBy,
+		&movie.OrgID)
 
 	// Handle any errors. If there was no matching movie found, Scan() will return a sql.ErrNoRows
 	// error. We check for this and return our custom ErrRecordNotFound error instead.
@@ -134,12 +225,74 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		}
 	}
 
+	// The movie is cached whichever catalogue it belongs to, as the cache is shared by them all.
+	m.Cache.Add(&movie)
+
+	if !m.inCatalogue(&movie) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &movie, nil
+}
+
+// GetByTitle fetches the movie with exactly title and year, which is how the seed data tells
+// whether a movie was already added. It reads from the primary.
+func (m MovieModel) GetByTitle(title string, year int32) (*Movie, error) {
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, certification, version,
+			COALESCE(created_by, 0), COALESCE(org_id, 0)
+		FROM movies
+		WHERE title = $1 AND year = $2
+		AND ($3 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($3, 0))
+		ORDER BY id
+		LIMIT 1`
+
+	ctx, cancel := queryContext("MovieModel.GetByTitle", 3*time.Second)
+	defer cancel()
+
+	var movie Movie
+	err := m.DB.QueryRowContext(ctx, query, title, year, m.orgArg()).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Certification,
+		&movie.Version,
+		&movie.CreatedBy,
+		&movie.OrgID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
 	return &movie, nil
 }
 
 // Update updates a specific movie in the movies table.
 func (m MovieModel) Update(movie *Movie) error {
+	// Create a context with a 3-second timeout.
+	ctx, cancel := queryContext("MovieModel.Update", 3*time.Second)
+	defer cancel()
 
+	err := updateMovie(ctx, m.DB, movie, m.owner, m.orgArg())
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Add(movie)
+	return nil
+}
+
+// updateMovie runs the query of MovieModel.Update on q, which is either the connection pool or
+// a transaction, see Tx. Unless owner is 0, only a movie created by the user owner is updated,
+// and unless org is -1, only a movie of the catalogue of org, see MovieModel.orgArg.
+func updateMovie(ctx context.Context, q querier, movie *Movie, owner, org int64) error {
 	// ** Optimistic Concurrency Control
 	// The update is only executed if the version number in the database is still
 	// the same as the version number that was passed in with the movie struct
@@ -150,8 +303,10 @@ func (m MovieModel) Update(movie *Movie) error {
 	// version = version = uuid_generate_v4() // version is a UUID
 	query := `
 		UPDATE movies
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6 
+		SET title = $1, year = $2, runtime = $3, genres = $4, certification = $5,
+			version = version + 1
+		WHERE id = $6 AND version = $7 AND (created_by = $8 OR $8 = 0)
+		AND ($9 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($9, 0))
 		RETURNING version
 		`
 
@@ -161,17 +316,16 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.Certification,
 		movie.ID,
 		movie.Version, // Add the expected movie version.
+		owner,
+		org,
 	}
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	// Execute the SQL query. If no matching row could be found, we know the movie version
 	// has changed (or the record has been deleted) and we return ErrEditConflict.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -193,17 +347,18 @@ func (m MovieModel) Delete(id int64) error {
 
 	query := `
 		DELETE FROM movies
-		WHERE id = $1
+		WHERE id = $1 AND (created_by = $2 OR $2 = 0)
+		AND ($3 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($3, 0))
 		`
 
 	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext("MovieModel.Delete", 3*time.Second)
 	defer cancel()
 
 	// Execute the SQL query using the Exec() method,
 	// passing in the id variable as the value for the placeholder parameter. The Exec(
 	// ) method returns a sql.Result object.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, m.owner, m.orgArg())
 	if err != nil {
 		return err
 	}
@@ -222,12 +377,47 @@ func (m MovieModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 
+	m.Cache.Delete(id)
+	return nil
+}
+
+// DeleteVersion deletes a movie only if it is still at the given version. If the movie has
+// been changed or deleted in the meantime, ErrEditConflict is returned.
+func (m MovieModel) DeleteVersion(id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM movies
+		WHERE id = $1 AND version = $2 AND (created_by = $3 OR $3 = 0)
+		AND ($4 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($4, 0))
+		`
+
+	ctx, cancel := queryContext("MovieModel.DeleteVersion", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, version, m.owner, m.orgArg())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	m.Cache.Delete(id)
 	return nil
 }
 
 // GetAll returns a list of movies in the form of a string of Movie type
 // based on a set of provided filters.
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MovieModel) GetAll(title string, genres []string, certification Certification, filters Filters) ([]*Movie, Metadata, error) {
 	// This SQL query is designed so that each of the filters behaves like it is ‘optional’.
 	// Add an ORDER BY clause and interpolate the sort column and direction using fmt.Sprintf.
 	// Importantly, notice that we also include a secondary sort on the movie ID to ensure
@@ -237,24 +427,26 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// Complete list of postgres array functions and operators:
 	// https://www.postgresql.org/docs/9.6/functions-array.html
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, certification, version
 		FROM movies
 		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (genres @> $2 OR $2 = '{}')
+		AND (certification = $3 OR $3 = '')
+		AND ($6 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($6, 0))
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`,
+		LIMIT $4 OFFSET $5`,
 		filters.sortColumn(), filters.sortDirection())
 
 	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext("MovieModel.GetAll", 3*time.Second)
 	defer cancel()
 
-	// Organize our four placeholder parameter values in a slice.
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	// Organize our six placeholder parameter values in a slice.
+	args := []interface{}{title, pq.Array(genres), certification, filters.limit(), filters.offset(), m.orgArg()}
 
 	// Use QueryContext to execute the query. This returns a sql.Rows result set containing
 	// the result.
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.reader().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -288,6 +480,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&movie.Certification,
 			&movie.Version,
 		)
 		if err != nil {
@@ -312,6 +505,132 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	return movies, metadata, nil
 }
 
+// Count returns the number of movies matching the same title, genres and certification filters
+// as GetAll, without fetching any of the movie records.
+func (m MovieModel) Count(title string, genres []string, certification Certification) (int, error) {
+	query := `
+		SELECT count(*)
+		FROM movies
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (genres @> $2 OR $2 = '{}')
+		AND (certification = $3 OR $3 = '')
+		AND ($4 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($4, 0))`
+
+	ctx, cancel := queryContext("MovieModel.Count", 3*time.Second)
+	defer cancel()
+
+	var total int
+	err := m.reader().QueryRowContext(ctx, query, title, pq.Array(genres), certification, m.orgArg()).Scan(&total)
+	return total, err
+}
+
+// CountForDelete returns the number of movies matching the title and genres filters, like Count,
+// along with the highest id among them, or 0 if there are none, which DeleteBatch takes.
+func (m MovieModel) CountForDelete(title string, genres []string) (int, int64, error) {
+	query := `
+		SELECT count(*), COALESCE(MAX(id), 0)
+		FROM movies
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (genres @> $2 OR $2 = '{}')
+		AND ($3 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($3, 0))`
+
+	ctx, cancel := queryContext("MovieModel.CountForDelete", 3*time.Second)
+	defer cancel()
+
+	var (
+		total int
+		maxID int64
+	)
+	err := m.reader().QueryRowContext(ctx, query, title, pq.Array(genres), m.orgArg()).Scan(&total, &maxID)
+	return total, maxID, err
+}
+
+// CatalogueStats holds high-level numbers about the whole movie catalogue.
+type CatalogueStats struct {
+	TotalMovies    int        `json:"total_movies"`
+	TotalGenres    int        `json:"total_genres"`
+	NewestAddition *time.Time `json:"newest_addition"` // nil while the catalogue is empty
+	// Popular holds the most popular movies, see PopularityModel. It is filled in by the
+	// caller rather than by Stats.
+	Popular []PopularMovie `json:"popular"`
+}
+
+// Stats returns the CatalogueStats of the movies table.
+func (m MovieModel) Stats() (*CatalogueStats, error) {
+	query := `
+		SELECT
+			(SELECT count(*) FROM movies),
+			(SELECT count(DISTINCT genre) FROM movies, unnest(genres) AS genre),
+			(SELECT max(created_at) FROM movies)`
+
+	ctx, cancel := queryContext("MovieModel.Stats", 3*time.Second)
+	defer cancel()
+
+	var stats CatalogueStats
+	err := m.DB.QueryRowContext(ctx, query).Scan(&stats.TotalMovies, &stats.TotalGenres, &stats.NewestAddition)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// DeleteBatch deletes up to limit movies matching the title and genres filters whose id is at
+// most maxID, and returns the ids of the movies deleted. Bulk deletes call it repeatedly until it
+// returns none, so that no single statement holds locks on a large part of the table; maxID, the
+// highest id counted by their dry-run, keeps the movies added since out of them.
+func (m MovieModel) DeleteBatch(title string, genres []string, maxID int64, limit int) ([]int64, error) {
+	query := `
+		DELETE FROM movies
+		WHERE id IN (
+			SELECT id
+			FROM movies
+			WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+			AND (genres @> $2 OR $2 = '{}')
+			AND ($4 = -1 OR org_id IS NOT DISTINCT FROM NULLIF($4, 0))
+			AND id <= $5
+			ORDER BY id
+			LIMIT $3
+		)
+		RETURNING id`
+
+	ctx, cancel := queryContext("MovieModel.DeleteBatch", 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres), limit, m.orgArg(), maxID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		m.Cache.Delete(id)
+	}
+
+	return ids, nil
+}
+
 // ValidateMovie runs validation checks on the Movie type.
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	// Check movie.Title
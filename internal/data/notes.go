@@ -0,0 +1,112 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// Note is a private, free-text annotation that a user keeps about a movie. Notes are only ever
+// shown to the user who wrote them.
+type Note struct {
+	UserID    int64     `json:"-"`
+	MovieID   int64     `json:"movie_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Body      string    `json:"body"`
+}
+
+// NoteModel struct wraps a sql.DB connection pool and allows us to work with the Note struct
+// type and the movie_notes table in our database.
+type NoteModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Upsert creates the note for the (user, movie) pair, or replaces the body of the existing one.
+func (m NoteModel) Upsert(note *Note) error {
+	query := `
+		INSERT INTO movie_notes (user_id, movie_id, body)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, movie_id)
+		DO UPDATE SET body = EXCLUDED.body, updated_at = NOW()
+		RETURNING created_at, updated_at
+		`
+
+	ctx, cancel := queryContext("NoteModel.Upsert", 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, note.UserID, note.MovieID, note.Body).Scan(
+		&note.CreatedAt,
+		&note.UpdatedAt,
+	)
+}
+
+// Get returns the note a user has written about a movie, or ErrRecordNotFound if there is none.
+func (m NoteModel) Get(userID, movieID int64) (*Note, error) {
+	query := `
+		SELECT user_id, movie_id, created_at, updated_at, body
+		FROM movie_notes
+		WHERE user_id = $1 AND movie_id = $2
+		`
+
+	var note Note
+
+	ctx, cancel := queryContext("NoteModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(
+		&note.UserID,
+		&note.MovieID,
+		&note.CreatedAt,
+		&note.UpdatedAt,
+		&note.Body,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &note, nil
+}
+
+// Delete removes the note a user has written about a movie.
+func (m NoteModel) Delete(userID, movieID int64) error {
+	query := `
+		DELETE FROM movie_notes
+		WHERE user_id = $1 AND movie_id = $2
+		`
+
+	ctx, cancel := queryContext("NoteModel.Delete", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ValidateNote runs validation checks on the Note type.
+func ValidateNote(v *validator.Validator, note *Note) {
+	v.Check(note.Body != "", "body", "must be provided")
+	v.Check(len(note.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
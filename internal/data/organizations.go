@@ -0,0 +1,305 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// The role a user has in an organization. Owners and admins manage the members, and only owners
+// can make other members owners or admins, or remove them.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// ErrDuplicateOrgSlug is returned when creating an organization with a slug which is taken.
+var ErrDuplicateOrgSlug = errors.New("duplicate organization slug")
+
+// Organization is a team sharing the deployment, with its own movie catalogue. Role is the role
+// of the user the organization was fetched for, see GetAllForUser.
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Version   int32     `json:"version"`
+	Role      string    `json:"role,omitempty"`
+}
+
+// OrgMember is a member of an organization.
+type OrgMember struct {
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrganizationModel struct wraps a sql.DB connection pool and allows us to work with the
+// Organization struct type and the organizations and organization_members tables.
+type OrganizationModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert creates a new organization with the user ownerID as its owner, in a single transaction.
+// It returns ErrDuplicateOrgSlug if the slug is taken.
+func (m OrganizationModel) Insert(org *Organization, ownerID int64) error {
+	ctx, cancel := queryContext("OrganizationModel.Insert", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organizations (slug, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version
+		`
+
+	err = tx.QueryRowContext(ctx, query, org.Slug, org.Name).Scan(&org.ID, &org.CreatedAt, &org.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "organizations_slug_key"`:
+			return ErrDuplicateOrgSlug
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO organization_members (org_id, user_id, role) VALUES ($1, $2, $3)`,
+		org.ID, ownerID, OrgRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	org.Role = OrgRoleOwner
+	return tx.Commit()
+}
+
+// GetBySlug fetches the organization with slug.
+func (m OrganizationModel) GetBySlug(slug string) (*Organization, error) {
+	query := `
+		SELECT id, created_at, slug, name, version
+		FROM organizations
+		WHERE slug = $1
+		`
+
+	var org Organization
+
+	ctx, cancel := queryContext("OrganizationModel.GetBySlug", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&org.ID, &org.CreatedAt, &org.Slug, &org.Name, &org.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &org, nil
+}
+
+// GetAllForUser returns the organizations a user is a member of, with their role, by name.
+func (m OrganizationModel) GetAllForUser(userID int64) ([]*Organization, error) {
+	query := `
+		SELECT o.id, o.created_at, o.slug, o.name, o.version, om.role
+		FROM organizations o
+		INNER JOIN organization_members om ON om.org_id = o.id
+		WHERE om.user_id = $1
+		ORDER BY o.name ASC, o.id ASC
+		`
+
+	ctx, cancel := queryContext("OrganizationModel.GetAllForUser", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	orgs := []*Organization{}
+
+	for rows.Next() {
+		var org Organization
+
+		err := rows.Scan(&org.ID, &org.CreatedAt, &org.Slug, &org.Name, &org.Version, &org.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		orgs = append(orgs, &org)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// Role returns the role a user has in an organization, or "" if the user isn't a member.
+func (m OrganizationModel) Role(orgID, userID int64) (string, error) {
+	query := `
+		SELECT role
+		FROM organization_members
+		WHERE org_id = $1 AND user_id = $2
+		`
+
+	var role string
+
+	ctx, cancel := queryContext("OrganizationModel.Role", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, orgID, userID).Scan(&role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", nil
+		default:
+			return "", err
+		}
+	}
+
+	return role, nil
+}
+
+// SetMember adds a user to an organization with role, or changes the role of a member.
+func (m OrganizationModel) SetMember(orgID, userID int64, role string) (*OrgMember, error) {
+	query := `
+		INSERT INTO organization_members (org_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		RETURNING user_id, role, created_at
+		`
+
+	var member OrgMember
+
+	ctx, cancel := queryContext("OrganizationModel.SetMember", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, orgID, userID, role).Scan(&member.UserID, &member.Role, &member.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// RemoveMember removes a user from an organization. It returns ErrRecordNotFound if the user
+// isn't a member.
+func (m OrganizationModel) RemoveMember(orgID, userID int64) error {
+	query := `
+		DELETE FROM organization_members
+		WHERE org_id = $1 AND user_id = $2
+		`
+
+	ctx, cancel := queryContext("OrganizationModel.RemoveMember", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// CountOwners returns the number of owners of an organization, which must never drop to zero.
+func (m OrganizationModel) CountOwners(orgID int64) (int, error) {
+	query := `
+		SELECT count(*)
+		FROM organization_members
+		WHERE org_id = $1 AND role = $2
+		`
+
+	var count int
+
+	ctx, cancel := queryContext("OrganizationModel.CountOwners", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, orgID, OrgRoleOwner).Scan(&count)
+	return count, err
+}
+
+// GetMembers returns the members of an organization, in the order they joined.
+func (m OrganizationModel) GetMembers(orgID int64) ([]*OrgMember, error) {
+	query := `
+		SELECT om.user_id, u.name, om.role, om.created_at
+		FROM organization_members om
+		INNER JOIN users u ON u.id = om.user_id
+		WHERE om.org_id = $1
+		ORDER BY om.created_at ASC, om.user_id ASC
+		`
+
+	ctx, cancel := queryContext("OrganizationModel.GetMembers", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	members := []*OrgMember{}
+
+	for rows.Next() {
+		var member OrgMember
+
+		err := rows.Scan(&member.UserID, &member.Name, &member.Role, &member.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, &member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// ValidateOrganization runs validation checks on the Organization type.
+func ValidateOrganization(v *validator.Validator, org *Organization) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.Check(len(org.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(org.Slug != "", "slug", "must be provided")
+	v.Check(len(org.Slug) <= 50, "slug", "must not be more than 50 bytes long")
+	v.Check(validator.Slug(org.Slug), "slug", "must only contain lowercase letters, digits and single dashes, e.g. my-team")
+}
+
+// ValidOrgRole reports whether role is one of the organization roles.
+func ValidOrgRole(role string) bool {
+	return validator.In(role, OrgRoleOwner, OrgRoleAdmin, OrgRoleMember)
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ScopeOrgInvitation is the scope of the tokens emailed to the people invited to join an
+// organization. They are issued to the member who sent the invitation, and the org_invitations
+// table holds what they were invited to.
+const ScopeOrgInvitation = "org-invitation"
+
+var (
+	// ErrDuplicateOrgInvitation is returned when inviting an email address which already has a
+	// pending invitation to the organization.
+	ErrDuplicateOrgInvitation = errors.New("duplicate organization invitation")
+
+	// ErrInvitationEmailMismatch is returned when a user accepts an invitation which was sent
+	// to another email address.
+	ErrInvitationEmailMismatch = errors.New("invitation sent to another email address")
+)
+
+// OrgInvitation is a pending invitation to join an organization with a role.
+type OrgInvitation struct {
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"-"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy int64     `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// Invite records an invitation to join an organization, and returns the token which accepts it,
+// which expires after ttl. The expired invitations of the same email address are replaced, and
+// ErrDuplicateOrgInvitation is returned if there is a pending one.
+func (m OrganizationModel) Invite(inv *OrgInvitation, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(inv.InvitedBy, ttl, ScopeOrgInvitation)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := queryContext("OrganizationModel.Invite", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM tokens
+		WHERE expiry <= $3 AND hash IN (
+			SELECT token_hash FROM org_invitations WHERE org_id = $1 AND email = $2
+		)
+		`
+
+	_, err = tx.ExecContext(ctx, query, inv.OrgID, inv.Email, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertToken(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO org_invitations (token_hash, org_id, email, role, invited_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{token.Hash, inv.OrgID, inv.Email, inv.Role, inv.InvitedBy}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&inv.ID, &inv.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "org_invitations_org_id_email_key"`:
+			return nil, ErrDuplicateOrgInvitation
+		default:
+			return nil, err
+		}
+	}
+
+	inv.Expiry = token.Expiry
+
+	return token, tx.Commit()
+}
+
+// GetInvitations returns the pending invitations of an organization, the oldest first. The
+// expired ones are left out.
+func (m OrganizationModel) GetInvitations(orgID int64) ([]*OrgInvitation, error) {
+	query := `
+		SELECT i.id, i.org_id, i.email, i.role, i.invited_by, i.created_at, t.expiry
+		FROM org_invitations i
+		INNER JOIN tokens t ON t.hash = i.token_hash
+		WHERE i.org_id = $1 AND t.expiry > $2
+		ORDER BY i.created_at ASC, i.id ASC
+		`
+
+	ctx, cancel := queryContext("OrganizationModel.GetInvitations", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orgID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	invitations := []*OrgInvitation{}
+
+	for rows.Next() {
+		var inv OrgInvitation
+
+		err := rows.Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.CreatedAt, &inv.Expiry)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &inv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation deletes the invitation id of an organization along with its token, so that
+// it can't be accepted anymore. It returns ErrRecordNotFound if there is no such invitation.
+func (m OrganizationModel) RevokeInvitation(orgID, id int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE hash = (SELECT token_hash FROM org_invitations WHERE id = $1 AND org_id = $2)
+		`
+
+	ctx, cancel := queryContext("OrganizationModel.RevokeInvitation", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AcceptInvitation makes user a member of an organization with the role of the invitation the
+// token accepts, and uses the token up. The members who are invited again keep their role. It
+// returns the role the user has in the organization, ErrRecordNotFound if the token doesn't
+// accept a pending invitation to the organization, and ErrInvitationEmailMismatch if the
+// invitation was sent to another email address than the one of user.
+func (m OrganizationModel) AcceptInvitation(orgID int64, tokenPlaintext string, user *User) (string, error) {
+	ctx, cancel := queryContext("OrganizationModel.AcceptInvitation", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT i.token_hash, i.email, i.role
+		FROM org_invitations i
+		INNER JOIN tokens t ON t.hash = i.token_hash
+		WHERE i.token_hash = ANY($1) AND i.org_id = $2 AND t.scope = $3 AND t.expiry > $4
+		FOR UPDATE OF i
+		`
+
+	var (
+		tokenHash   []byte
+		email, role string
+	)
+
+	err = tx.QueryRowContext(ctx, query, tokenHashes(tokenPlaintext), orgID, ScopeOrgInvitation, time.Now()).Scan(&tokenHash, &email, &role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	if !strings.EqualFold(email, user.Email) {
+		return "", ErrInvitationEmailMismatch
+	}
+
+	query = `
+		INSERT INTO organization_members (org_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET role = organization_members.role
+		RETURNING role
+		`
+
+	err = tx.QueryRowContext(ctx, query, orgID, user.ID, role).Scan(&role)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE hash = $1`, tokenHash)
+	if err != nil {
+		return "", err
+	}
+
+	return role, tx.Commit()
+}
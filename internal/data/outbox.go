@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Kinds of outbox messages.
+const (
+	OutboxEmail   = "email"
+	OutboxWebhook = "webhook"
+)
+
+// Statuses of an outbox message.
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxMessage is an email or webhook event waiting to be sent. Payload holds an
+// OutboxEmailPayload or an OutboxWebhookPayload, depending on Kind.
+type OutboxMessage struct {
+	ID            int64           `json:"id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Kind          string          `json:"kind"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// OutboxEmailPayload is the payload of an email message: the template to render, the data to
+// render it with and who to send it to.
+type OutboxEmailPayload struct {
+	Recipient string                 `json:"recipient"`
+	Template  string                 `json:"template"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// OutboxWebhookPayload is the payload of a webhook message: an event to be delivered to every
+// webhook subscription to its type. Body is the complete request body of the deliveries.
+type OutboxWebhookPayload struct {
+	EventID   string          `json:"event_id"`
+	EventType string          `json:"event_type"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// NewOutboxEmail returns an outbox message which sends an email.
+func NewOutboxEmail(recipient, template string, data map[string]interface{}) (*OutboxMessage, error) {
+	return newOutboxMessage(OutboxEmail, OutboxEmailPayload{Recipient: recipient, Template: template, Data: data})
+}
+
+// NewOutboxWebhook returns an outbox message which delivers a webhook event.
+func NewOutboxWebhook(eventID, eventType string, body []byte) (*OutboxMessage, error) {
+	return newOutboxMessage(OutboxWebhook, OutboxWebhookPayload{EventID: eventID, EventType: eventType, Body: body})
+}
+
+func newOutboxMessage(kind string, payload interface{}) (*OutboxMessage, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxMessage{Kind: kind, Payload: js, Status: OutboxPending}, nil
+}
+
+// OutboxModel struct wraps a sql.DB connection pool and allows us to work with the outbox
+// table in our database. Messages which belong to a change should be written with Tx.Enqueue,
+// in the transaction making the change.
+type OutboxModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert adds a message to the outbox, outside of any transaction.
+func (m OutboxModel) Insert(msg *OutboxMessage) error {
+	ctx, cancel := queryContext("OutboxModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return insertOutboxMessage(ctx, m.DB, msg)
+}
+
+// insertOutboxMessage runs the query of OutboxModel.Insert on q, which is either the connection
+// pool or a transaction, see Tx.
+func insertOutboxMessage(ctx context.Context, q querier, msg *OutboxMessage) error {
+	query := `
+		INSERT INTO outbox (kind, payload)
+		VALUES ($1, $2)
+		RETURNING id, created_at, status
+		`
+
+	return q.QueryRowContext(ctx, query, msg.Kind, msg.Payload).Scan(&msg.ID, &msg.CreatedAt, &msg.Status)
+}
+
+// ClaimDue returns up to limit pending messages whose next attempt is due, oldest first. Their
+// next attempt is pushed back by lease, so that no other instance of the API claims them while
+// they are being sent; RecordAttempt then sets the real time of the next attempt.
+func (m OutboxModel) ClaimDue(limit int, lease time.Duration) ([]*OutboxMessage, error) {
+	query := `
+		UPDATE outbox
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, kind, payload, status, attempts
+		`
+
+	ctx, cancel := queryContext("OutboxModel.ClaimDue", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	messages := []*OutboxMessage{}
+
+	for rows.Next() {
+		var msg OutboxMessage
+
+		err := rows.Scan(&msg.ID, &msg.CreatedAt, &msg.Kind, &msg.Payload, &msg.Status, &msg.Attempts)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, &msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// RecordAttempt saves the outcome of an attempt to send a message: its status, number of
+// attempts, the time of the next attempt (nil unless it is still pending) and the error, if
+// any. The payload of a sent message is cleared, as emails may carry plaintext tokens.
+func (m OutboxModel) RecordAttempt(msg *OutboxMessage) error {
+	query := `
+		UPDATE outbox
+		SET status = $1, attempts = $2, next_attempt_at = COALESCE($3, next_attempt_at), error = $4,
+			sent_at = CASE WHEN $1 = 'sent' THEN NOW() END,
+			payload = CASE WHEN $1 = 'sent' THEN '{}' ELSE payload END
+		WHERE id = $5
+		`
+
+	args := []interface{}{msg.Status, msg.Attempts, msg.NextAttemptAt, msg.Error, msg.ID}
+
+	ctx, cancel := queryContext("OutboxModel.RecordAttempt", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,167 @@
+package data
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// PermissionCacheStats counts the lookups made in a PermissionCache.
+type PermissionCacheStats struct {
+	Entries int   `json:"entries"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+// permissionCacheEntry is the permissions of a user held by a PermissionCache.
+type permissionCacheEntry struct {
+	userID      int64
+	permissions Permissions
+	expires     time.Time
+}
+
+// PermissionCache holds the permissions of the most recently seen users in memory, in front of
+// PermissionModel.GetAllForUser, which every permission check would otherwise query. The
+// permissions changed through PermissionModel are dropped at once, and the entries expire after
+// a short while, which bounds how long a change made elsewhere, such as by another instance or
+// directly in the database, goes unseen.
+//
+// A nil *PermissionCache is valid and caches nothing. Create one with NewPermissionCache.
+type PermissionCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[int64]*list.Element
+	// lru holds the entries, the most recently used first.
+	lru *list.List
+	// generation is incremented by every Delete and Purge, so that Add can tell whether the
+	// permissions it was given may have been read before a change.
+	generation uint64
+	hits       int64
+	misses     int64
+	now        func() time.Time
+}
+
+// NewPermissionCache returns a cache holding the permissions of up to size users for ttl each.
+func NewPermissionCache(size int, ttl time.Duration) *PermissionCache {
+	return &PermissionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[int64]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get returns a copy of the permissions of the user userID. found is false if they aren't in
+// the cache.
+func (c *PermissionCache) Get(userID int64) (permissions Permissions, found bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[userID]
+	if ok && !c.now().Before(el.Value.(*permissionCacheEntry).expires) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.lru.MoveToFront(el)
+	return append(Permissions(nil), el.Value.(*permissionCacheEntry).permissions...), true
+}
+
+// Generation returns the current generation of the cache, which must be read before the
+// permissions passed to Add are queried.
+func (c *PermissionCache) Generation() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// Add stores a copy of the permissions of the user userID, read from the database at the
+// generation of the cache. They are dropped if permissions were changed since, as the query may
+// have seen the permissions from before the change.
+func (c *PermissionCache) Add(userID int64, permissions Permissions, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation || c.size < 1 {
+		return
+	}
+
+	if el, ok := c.entries[userID]; ok {
+		c.remove(el)
+	}
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+
+	c.entries[userID] = c.lru.PushFront(&permissionCacheEntry{
+		userID:      userID,
+		permissions: append(Permissions(nil), permissions...),
+		expires:     c.now().Add(c.ttl),
+	})
+}
+
+// Delete drops the permissions of the user userID, after they were changed.
+func (c *PermissionCache) Delete(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if el, ok := c.entries[userID]; ok {
+		c.remove(el)
+	}
+}
+
+// Purge drops the permissions of every user.
+func (c *PermissionCache) Purge() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[int64]*list.Element)
+	c.lru.Init()
+}
+
+// Stats returns the number of users whose permissions are held and the lookups made so far.
+func (c *PermissionCache) Stats() PermissionCacheStats {
+	if c == nil {
+		return PermissionCacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return PermissionCacheStats{Entries: c.lru.Len(), Hits: c.hits, Misses: c.misses}
+}
+
+// remove drops the entry held by el. The caller must hold c.mu.
+func (c *PermissionCache) remove(el *list.Element) {
+	delete(c.entries, c.lru.Remove(el).(*permissionCacheEntry).userID)
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPermissionCache(t *testing.T) {
+	c := NewPermissionCache(2, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Add(1, Permissions{"movies:read"}, c.Generation())
+
+	permissions, found := c.Get(1)
+	if !found || !permissions.Include("movies:read") {
+		t.Fatalf("got %v, %t; want the permissions added", permissions, found)
+	}
+	permissions[0] = "changed"
+	if permissions, _ := c.Get(1); permissions[0] != "movies:read" {
+		t.Error("want Get to return a copy the caller can change")
+	}
+
+	c.Delete(1)
+	if _, found := c.Get(1); found {
+		t.Error("want the permissions dropped after a change")
+	}
+
+	// Permissions read before a change mustn't be cached after it.
+	generation := c.Generation()
+	c.Delete(2)
+	c.Add(1, Permissions{"movies:read"}, generation)
+	if _, found := c.Get(1); found {
+		t.Error("want permissions read before a change not to be cached")
+	}
+
+	c.Add(1, Permissions{"movies:read"}, c.Generation())
+	c.Add(2, Permissions{}, c.Generation())
+	c.Add(3, Permissions{}, c.Generation())
+	if _, found := c.Get(1); found {
+		t.Error("want the least recently used permissions evicted")
+	}
+
+	now = now.Add(time.Minute)
+	if _, found := c.Get(2); found {
+		t.Error("want the permissions expired after the TTL")
+	}
+
+	if got := c.Stats(); got.Hits != 2 || got.Misses != 4 || got.Entries != 1 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestNilPermissionCache(t *testing.T) {
+	var c *PermissionCache
+	c.Add(1, Permissions{"movies:read"}, c.Generation())
+	if _, found := c.Get(1); found {
+		t.Error("want a nil cache to hold nothing")
+	}
+	c.Delete(1)
+	c.Purge()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -12,8 +13,25 @@ import (
 // Permissions holds the permission codes for a single user.
 type Permissions []string
 
-// Include checks whether the Permissions slice contains a specific permission code.
+// Include checks whether the Permissions slice grants a specific permission code, either
+// itself, through a permission it is nested under, or through a wildcard. The parts of a code
+// are separated by colons, and a permission grants the codes nested under it, so
+// "movies:write" grants "movies:write:own". A "*" part matches any part of the code, so
+// "movies:*" grants every permission on movies, "*:read" the read permission on every resource
+// and "*" alone everything, including the permissions of resources added later.
 func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if matchPermission(p[i], code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Contains checks whether the Permissions slice holds a specific permission code itself,
+// without resolving wildcards, e.g. to tell which codes still have to be granted to a user.
+func (p Permissions) Contains(code string) bool {
 	for i := range p {
 		if code == p[i] {
 			return true
@@ -23,14 +41,45 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+// matchPermission reports whether the permission pattern, which may contain wildcards, grants
+// the permission code.
+func matchPermission(pattern, code string) bool {
+	if pattern == code {
+		return true
+	}
+
+	patternParts := strings.Split(pattern, ":")
+	codeParts := strings.Split(code, ":")
+	if len(patternParts) > len(codeParts) {
+		return false
+	}
+
+	for i, part := range patternParts {
+		if part != "*" && part != codeParts[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
 type PermissionModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	// Cache holds the permissions of recently seen users, see PermissionCache. It is nil when
+	// the permission cache is disabled.
+	Cache *PermissionCache
 }
 
-// GetAllForUser returns all permission codes for a specific user in a Permissions slice.
+// GetAllForUser returns all permission codes for a specific user in a Permissions slice. They
+// are served from the Cache when it holds them.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	if permissions, found := m.Cache.Get(userID); found {
+		return permissions, nil
+	}
+	generation := m.Cache.Generation()
+
 	query := `
 		SELECT permissions.code
 		FROM permissions
@@ -39,7 +88,7 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		WHERE users.id = $1
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext("PermissionModel.GetAllForUser", 3*time.Second)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
@@ -69,6 +118,48 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		return nil, err
 	}
 
+	m.Cache.Add(userID, permissions, generation)
+
+	return permissions, nil
+}
+
+// GetAll returns the code of every permission there is, in alphabetical order.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code`
+
+	ctx, cancel := queryContext("PermissionModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return permissions, nil
 }
 
@@ -76,14 +167,39 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 // We're using a variadic parameter for the codes so that we can assign multiple
 // permissions in a single call.
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	ctx, cancel := queryContext("PermissionModel.AddForUser", 3*time.Second)
+	defer cancel()
+
+	defer m.Cache.Delete(userID)
+
+	return addPermissionsForUser(ctx, m.DB, userID, codes...)
+}
+
+// addPermissionsForUser runs the query of PermissionModel.AddForUser on q, which is either the
+// connection pool or a transaction, see Tx.
+func addPermissionsForUser(ctx context.Context, q querier, userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// RemoveForUser removes the permissions with the provided codes from a specific user.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		WHERE user_id = $1
+		AND permission_id IN (SELECT id FROM permissions WHERE code = ANY($2))
+		`
+
+	ctx, cancel := queryContext("PermissionModel.RemoveForUser", 3*time.Second)
 	defer cancel()
 
+	defer m.Cache.Delete(userID)
+
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
@@ -0,0 +1,46 @@
+package data
+
+import "testing"
+
+func TestPermissionsInclude(t *testing.T) {
+	tests := []struct {
+		permissions Permissions
+		code        string
+		want        bool
+	}{
+		{Permissions{"movies:read"}, "movies:read", true},
+		{Permissions{"movies:read"}, "movies:write", false},
+		{Permissions{"movies:*"}, "movies:write", true},
+		{Permissions{"movies:*"}, "comments:moderate", false},
+		{Permissions{"*:read"}, "admin:read", true},
+		{Permissions{"*:read"}, "admin:write", false},
+		{Permissions{"*"}, "users:view-as", true},
+		{Permissions{"admin:*"}, "admin:emails:requeue", true},
+		{Permissions{"*:read"}, "admin:emails:read", false},
+		{Permissions{"movies"}, "movies:read", true},
+		{Permissions{"movies:write"}, "movies:write:own", true},
+		{Permissions{"movies:write:own"}, "movies:write", false},
+		{Permissions{"movies:write:own"}, "movies:write:any", false},
+		{Permissions{"moviesx"}, "movies:read", false},
+		{Permissions{"movies:read:*"}, "movies:read", false},
+		{Permissions{"comments:moderate", "webhooks:*"}, "webhooks:write", true},
+		{nil, "movies:read", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.permissions.Include(tt.code); got != tt.want {
+			t.Errorf("%v.Include(%q): want %v; got %v", tt.permissions, tt.code, tt.want, got)
+		}
+	}
+}
+
+func TestPermissionsContains(t *testing.T) {
+	p := Permissions{"movies:*", "admin:read"}
+
+	if !p.Contains("movies:*") || !p.Contains("admin:read") {
+		t.Errorf("%v.Contains: want true for the codes held", p)
+	}
+	if p.Contains("movies:read") {
+		t.Errorf("%v.Contains(%q): want false for a code only granted by a wildcard", p, "movies:read")
+	}
+}
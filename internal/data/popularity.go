@@ -0,0 +1,86 @@
+package data
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// PopularityModel struct wraps a sql.DB connection pool and allows us to work with the
+// movie_popularity table in our database.
+type PopularityModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// PopularMovie is a movie with its popularity score.
+type PopularMovie struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Score int64  `json:"score"`
+}
+
+// Recompute scores the movies from the activity since the given time: every time a movie was
+// added to a list counts for 2 points, and every comment on it for 1. The scores of movies with
+// no activity since then are removed. It returns the number of movies scored.
+func (m PopularityModel) Recompute(since time.Time) (int64, error) {
+	query := `
+		WITH scores AS (
+			SELECT movie_id, sum(points) AS score
+			FROM (
+				SELECT movie_id, 2 AS points FROM movie_list_items WHERE added_at >= $1
+				UNION ALL
+				SELECT movie_id, 1 AS points FROM comments WHERE created_at >= $1
+			) AS activity
+			GROUP BY movie_id
+		), stale AS (
+			DELETE FROM movie_popularity
+			WHERE movie_id NOT IN (SELECT movie_id FROM scores)
+		)
+		INSERT INTO movie_popularity (movie_id, score, computed_at)
+		SELECT movie_id, score, NOW() FROM scores
+		ON CONFLICT (movie_id) DO UPDATE
+		SET score = EXCLUDED.score, computed_at = EXCLUDED.computed_at`
+
+	ctx, cancel := queryContext("PopularityModel.Recompute", time.Minute)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, since)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Top returns the limit movies with the highest scores, the most popular first.
+func (m PopularityModel) Top(limit int) ([]PopularMovie, error) {
+	query := `
+		SELECT movies.id, movies.title, movie_popularity.score
+		FROM movie_popularity
+		INNER JOIN movies ON movies.id = movie_popularity.movie_id
+		ORDER BY movie_popularity.score DESC, movies.id
+		LIMIT $1`
+
+	ctx, cancel := queryContext("PopularityModel.Top", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []PopularMovie{}
+	for rows.Next() {
+		var movie PopularMovie
+		err := rows.Scan(&movie.ID, &movie.Title, &movie.Score)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, movie)
+	}
+
+	return movies, rows.Err()
+}
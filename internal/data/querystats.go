@@ -0,0 +1,78 @@
+package data
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// queryStats holds, for each model method, the number of times it ran, their total duration and
+// how many were slow. It's published in /debug/vars as "database_queries", keyed by method name
+// such as "MovieModel.Get", so the average latency of each is total_µs / count.
+var (
+	queryStats   = expvar.NewMap("database_queries")
+	queryStatsMu sync.Mutex
+)
+
+// slowQueryThreshold is the duration, in nanoseconds, from which a query is logged as slow. 0
+// disables the slow query log.
+var slowQueryThreshold int64
+
+// slowQueryLog holds the func(name string, duration time.Duration) slow queries are logged with.
+var slowQueryLog atomic.Value
+
+// SetSlowQueryLog logs every query which takes threshold or longer with log, passing it the name
+// of the model method which ran the query and how long it took. The query itself is never
+// passed, as its parameters may hold personal data or secrets. A threshold of 0 disables it.
+func SetSlowQueryLog(threshold time.Duration, log func(name string, duration time.Duration)) {
+	slowQueryLog.Store(log)
+	atomic.StoreInt64(&slowQueryThreshold, int64(threshold))
+}
+
+// queryContext returns a context for the queries of the model method name, which is canceled
+// after timeout. Calling its cancel function also records how long the method took, so it must
+// be called once, when the method returns.
+func queryContext(name string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	start := time.Now()
+
+	return ctx, func() {
+		cancel()
+		observeQuery(name, time.Since(start))
+	}
+}
+
+// observeQuery records that the model method name took duration.
+func observeQuery(name string, duration time.Duration) {
+	stats := queryStatsFor(name)
+	stats.Add("count", 1)
+	stats.Add("total_µs", duration.Microseconds())
+
+	threshold := time.Duration(atomic.LoadInt64(&slowQueryThreshold))
+	if threshold > 0 && duration >= threshold {
+		stats.Add("slow", 1)
+		if log, ok := slowQueryLog.Load().(func(string, time.Duration)); ok {
+			log(name, duration)
+		}
+	}
+}
+
+// queryStatsFor returns the stats of the model method name, creating them on its first query.
+func queryStatsFor(name string) *expvar.Map {
+	if stats, ok := queryStats.Get(name).(*expvar.Map); ok {
+		return stats
+	}
+
+	queryStatsMu.Lock()
+	defer queryStatsMu.Unlock()
+
+	if stats, ok := queryStats.Get(name).(*expvar.Map); ok {
+		return stats
+	}
+
+	stats := new(expvar.Map).Init()
+	queryStats.Set(name, stats)
+	return stats
+}
@@ -0,0 +1,31 @@
+package data
+
+import (
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestObserveQuery(t *testing.T) {
+	var logged []string
+	SetSlowQueryLog(100*time.Millisecond, func(name string, duration time.Duration) {
+		logged = append(logged, name)
+	})
+	defer SetSlowQueryLog(0, nil)
+
+	observeQuery("TestModel.Get", 20*time.Millisecond)
+	observeQuery("TestModel.Get", 150*time.Millisecond)
+	observeQuery("TestModel.Update", 10*time.Millisecond)
+
+	stats := queryStatsFor("TestModel.Get")
+	want := map[string]int64{"count": 2, "total_µs": 170000, "slow": 1}
+	for key, n := range want {
+		if got := stats.Get(key).(*expvar.Int).Value(); got != n {
+			t.Errorf("got %s = %d; want %d", key, got, n)
+		}
+	}
+
+	if len(logged) != 1 || logged[0] != "TestModel.Get" {
+		t.Errorf("got slow queries %v logged; want [TestModel.Get]", logged)
+	}
+}
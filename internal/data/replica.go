@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// readQuerier is the part of querier that read-only queries need, which both *sql.DB and
+// ReadPool implement.
+type readQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// replicaRetryAfter is how long reads go to the primary after the replica failed, before the
+// replica is tried again.
+const replicaRetryAfter = 30 * time.Second
+
+// ReadPool sends queries to a read replica, and falls back to the primary when the replica is
+// unavailable. After a failure the replica is left alone for replicaRetryAfter, so that a
+// replica which is down doesn't slow down every read. Reads from the replica may lag behind
+// the primary, so callers which must see their own writes use the primary directly.
+type ReadPool struct {
+	Primary  *sql.DB
+	Replica  *sql.DB
+	ErrorLog *log.Logger
+
+	// downUntil is the time, in Unix nanoseconds, until which reads skip the replica.
+	downUntil int64
+}
+
+// NewReadPool returns a ReadPool for the primary and replica connection pools.
+func NewReadPool(primary, replica *sql.DB, errorLog *log.Logger) *ReadPool {
+	return &ReadPool{Primary: primary, Replica: replica, ErrorLog: errorLog}
+}
+
+// QueryContext runs the query on the replica, or on the primary if the replica is unavailable.
+func (p *ReadPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if p.replicaUp() {
+		rows, err := p.Replica.QueryContext(ctx, query, args...)
+		if !isUnavailable(err) {
+			return rows, err
+		}
+		p.markDown(err)
+	}
+
+	return p.Primary.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs the query on the replica, or on the primary if the replica is
+// unavailable.
+func (p *ReadPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if p.replicaUp() {
+		row := p.Replica.QueryRowContext(ctx, query, args...)
+		if !isUnavailable(row.Err()) {
+			return row
+		}
+		p.markDown(row.Err())
+	}
+
+	return p.Primary.QueryRowContext(ctx, query, args...)
+}
+
+// MarkDown sends reads to the primary for replicaRetryAfter, for when the replica is known to
+// be unavailable, e.g. it couldn't be reached on startup.
+func (p *ReadPool) MarkDown(err error) {
+	p.markDown(err)
+}
+
+func (p *ReadPool) replicaUp() bool {
+	return time.Now().UnixNano() >= atomic.LoadInt64(&p.downUntil)
+}
+
+func (p *ReadPool) markDown(err error) {
+	atomic.StoreInt64(&p.downUntil, time.Now().Add(replicaRetryAfter).UnixNano())
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf("read replica unavailable, reading from the primary for %v: %v", replicaRetryAfter, err)
+	}
+}
+
+// isUnavailable reports whether err means the database couldn't be reached or is shutting
+// down, rather than that the query failed.
+func isUnavailable(err error) bool {
+	// A query which ran out of time isn't retried on the primary, as there'd be no time left
+	// for it there either. context.DeadlineExceeded is a net.Error, so it's checked first.
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var pgErr *pq.Error
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection exceptions, and 57P01-57P03 are the server shutting down or
+		// not accepting connections yet.
+		return pgErr.Code.Class() == "08" || pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
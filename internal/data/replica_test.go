@@ -0,0 +1,51 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestIsUnavailable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{sql.ErrNoRows, false},
+		{context.DeadlineExceeded, false},
+		{&pq.Error{Code: "23505"}, false}, // unique_violation
+		{&pq.Error{Code: "08006"}, true},  // connection_failure
+		{&pq.Error{Code: "57P01"}, true},  // admin_shutdown
+		{&pq.Error{Code: "57P03"}, true},  // cannot_connect_now
+		{driver.ErrBadConn, true},
+		{io.ErrUnexpectedEOF, true},
+		{fmt.Errorf("query: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+	}
+
+	for _, tt := range tests {
+		if got := isUnavailable(tt.err); got != tt.want {
+			t.Errorf("isUnavailable(%v) = %t; want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestReadPoolMarkDown(t *testing.T) {
+	p := NewReadPool(nil, nil, nil)
+
+	if !p.replicaUp() {
+		t.Fatal("want a new pool to read from the replica")
+	}
+
+	p.MarkDown(driver.ErrBadConn)
+	if p.replicaUp() {
+		t.Error("want reads sent to the primary after the replica is marked down")
+	}
+}
@@ -0,0 +1,414 @@
+package data
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// The types of the events published by the API.
+const (
+	EventMovieCreated  = "movie.created"
+	EventMovieUpdated  = "movie.updated"
+	EventMovieDeleted  = "movie.deleted"
+	EventUserActivated = "user.activated"
+)
+
+// EventTypes lists every event type which can be subscribed to with a webhook subscription.
+var EventTypes = []string{EventMovieCreated, EventMovieUpdated, EventUserActivated}
+
+// Statuses of an outbound webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookSubscription registers a URL to be called for every event of the given types. Secret
+// is used to sign the deliveries, and is only shown to the user when the subscription is
+// created.
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"user_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+}
+
+// WebhookDelivery is a single event sent, or to be sent, to a subscription. URL and Secret are
+// copied from the subscription when a delivery is claimed for sending.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	SubscriptionID int64           `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	URL            string          `json:"-"`
+	Secret         string          `json:"-"`
+}
+
+// WebhookSubscriptionModel struct wraps a sql.DB connection pool and allows us to work with the
+// webhook_subscriptions table in our database.
+type WebhookSubscriptionModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert creates a new subscription with a freshly generated secret.
+func (m WebhookSubscriptionModel) Insert(sub *WebhookSubscription) error {
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO webhook_subscriptions (user_id, url, events, secret)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{sub.UserID, sub.URL, pq.Array(sub.Events), secret}
+
+	ctx, cancel := queryContext("WebhookSubscriptionModel.Insert", 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&sub.ID, &sub.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	sub.Secret = secret
+
+	return nil
+}
+
+// Get fetches a subscription by its id.
+func (m WebhookSubscriptionModel) Get(id int64) (*WebhookSubscription, error) {
+	query := `
+		SELECT id, created_at, user_id, url, events, secret
+		FROM webhook_subscriptions
+		WHERE id = $1
+		`
+
+	var sub WebhookSubscription
+
+	ctx, cancel := queryContext("WebhookSubscriptionModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&sub.ID,
+		&sub.CreatedAt,
+		&sub.UserID,
+		&sub.URL,
+		pq.Array(&sub.Events),
+		&sub.Secret,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &sub, nil
+}
+
+// GetAllForUser returns the subscriptions of a user, oldest first.
+func (m WebhookSubscriptionModel) GetAllForUser(userID int64) ([]*WebhookSubscription, error) {
+	query := `
+		SELECT id, created_at, user_id, url, events, secret
+		FROM webhook_subscriptions
+		WHERE user_id = $1
+		ORDER BY id
+		`
+
+	ctx, cancel := queryContext("WebhookSubscriptionModel.GetAllForUser", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	subs := []*WebhookSubscription{}
+
+	for rows.Next() {
+		var sub WebhookSubscription
+
+		err := rows.Scan(
+			&sub.ID,
+			&sub.CreatedAt,
+			&sub.UserID,
+			&sub.URL,
+			pq.Array(&sub.Events),
+			&sub.Secret,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		subs = append(subs, &sub)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// Delete removes a subscription together with its delivery log.
+func (m WebhookSubscriptionModel) Delete(id int64) error {
+	query := `
+		DELETE FROM webhook_subscriptions
+		WHERE id = $1
+		`
+
+	ctx, cancel := queryContext("WebhookSubscriptionModel.Delete", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// WebhookDeliveryModel struct wraps a sql.DB connection pool and allows us to work with the
+// webhook_deliveries table in our database.
+type WebhookDeliveryModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Enqueue creates a pending delivery of an event for every subscription to its type, and
+// returns the number of deliveries created.
+func (m WebhookDeliveryModel) Enqueue(eventID, eventType string, payload []byte) (int64, error) {
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3
+		FROM webhook_subscriptions
+		WHERE events @> ARRAY[$2]
+		`
+
+	ctx, cancel := queryContext("WebhookDeliveryModel.Enqueue", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, eventID, eventType, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ClaimDue returns up to limit pending deliveries whose next attempt is due, together with the
+// URL and secret of their subscription. Their next attempt is pushed back by lease, so that
+// no other instance of the API claims them while they are being sent; RecordAttempt then sets
+// the real time of the next attempt.
+func (m WebhookDeliveryModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		FROM webhook_subscriptions s
+		WHERE s.id = d.subscription_id
+			AND d.id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE status = 'pending' AND next_attempt_at <= NOW()
+				ORDER BY next_attempt_at
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+		RETURNING d.id, d.created_at, d.subscription_id, d.event_id, d.event_type, d.payload,
+			d.status, d.attempts, s.url, s.secret
+		`
+
+	ctx, cancel := queryContext("WebhookDeliveryModel.ClaimDue", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var d WebhookDelivery
+
+		err := rows.Scan(
+			&d.ID,
+			&d.CreatedAt,
+			&d.SubscriptionID,
+			&d.EventID,
+			&d.EventType,
+			&d.Payload,
+			&d.Status,
+			&d.Attempts,
+			&d.URL,
+			&d.Secret,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RecordAttempt saves the outcome of an attempt to send a delivery: its status, number of
+// attempts, the time of the next attempt (nil unless it is still pending), the response status
+// and the error, if any.
+func (m WebhookDeliveryModel) RecordAttempt(d *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, next_attempt_at = COALESCE($3, next_attempt_at),
+			response_status = $4, error = $5,
+			delivered_at = CASE WHEN $1 = 'delivered' THEN NOW() END
+		WHERE id = $6
+		`
+
+	args := []interface{}{d.Status, d.Attempts, d.NextAttemptAt, d.ResponseStatus, d.Error, d.ID}
+
+	ctx, cancel := queryContext("WebhookDeliveryModel.RecordAttempt", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetAllForSubscription returns the latest deliveries to a subscription, newest first.
+func (m WebhookDeliveryModel) GetAllForSubscription(subscriptionID int64, limit int) ([]*WebhookDelivery, error) {
+	query := `
+		SELECT id, created_at, subscription_id, event_id, event_type, payload, status, attempts,
+			CASE WHEN status = 'pending' THEN next_attempt_at END, response_status, error, delivered_at
+		FROM webhook_deliveries
+		WHERE subscription_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+		`
+
+	ctx, cancel := queryContext("WebhookDeliveryModel.GetAllForSubscription", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var d WebhookDelivery
+		var responseStatus sql.NullInt32
+
+		err := rows.Scan(
+			&d.ID,
+			&d.CreatedAt,
+			&d.SubscriptionID,
+			&d.EventID,
+			&d.EventType,
+			&d.Payload,
+			&d.Status,
+			&d.Attempts,
+			&d.NextAttemptAt,
+			&responseStatus,
+			&d.Error,
+			&d.DeliveredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if responseStatus.Valid {
+			status := int(responseStatus.Int32)
+			d.ResponseStatus = &status
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// generateWebhookSecret returns a random 32 byte secret, hex encoded.
+func generateWebhookSecret() (string, error) {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// ValidateWebhookSubscription runs validation checks on the WebhookSubscription type.
+func ValidateWebhookSubscription(v *validator.Validator, sub *WebhookSubscription) {
+	v.Check(sub.URL != "", "url", "must be provided")
+	v.Check(len(sub.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+
+	u, err := url.Parse(sub.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	v.Check(len(sub.Events) > 0, "events", "must contain at least 1 event type")
+	v.Check(validator.Unique(sub.Events), "events", "must not contain duplicate values")
+	for _, event := range sub.Events {
+		v.Check(validator.In(event, EventTypes...), "events", "must only contain movie.created, movie.updated or user.activated")
+	}
+}
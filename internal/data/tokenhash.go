@@ -0,0 +1,84 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+
+	"github.com/lib/pq"
+)
+
+// The algorithms tokens can be hashed with.
+const (
+	TokenHashSHA256 = "sha256"
+	TokenHashSHA512 = "sha512"
+)
+
+// TokenHashAlgorithms are the algorithms tokens can be hashed with, for validating the
+// configuration.
+var TokenHashAlgorithms = []string{TokenHashSHA256, TokenHashSHA512}
+
+// TokenHasher describes how the plaintext tokens are hashed before they are stored or looked
+// up. The tokens are high-entropy random strings, so a fast hash is enough to keep them from
+// being read off the database. With a Pepper, a secret kept out of the database, the hash is
+// an HMAC keyed with it, so that someone who can read or write the database alone can't forge
+// a token either, as they can't compute the hash of a plaintext of their choosing.
+type TokenHasher struct {
+	Algorithm string
+	Pepper    []byte
+	// Previous, if set, is how the tokens were hashed before the algorithm or the pepper were
+	// changed. The tokens hashed that way are still accepted, so that the users don't have to
+	// log in again, until Previous is unset once they have all expired.
+	Previous *TokenHasher
+}
+
+// TokenHashing is the hasher used by the models for every token. It is set once at startup
+// from the application config, like EmailNormalization. The default is SHA-256 without a
+// pepper, which every token was hashed with before the hashing could be configured.
+var TokenHashing = TokenHasher{Algorithm: TokenHashSHA256}
+
+// HashToken returns the hash a plaintext token is stored under, using TokenHashing.
+func HashToken(plaintext string) []byte {
+	return TokenHashing.Hash(plaintext)
+}
+
+// tokenHashes returns the hashes a plaintext token may be stored under as a query argument,
+// for looking it up with "hash = ANY($1)".
+func tokenHashes(plaintext string) interface{} {
+	return pq.ByteaArray(TokenHashing.Candidates(plaintext))
+}
+
+// Hash returns the hash of a plaintext token. It panics if the algorithm is unknown, which the
+// config validation rules out.
+func (h TokenHasher) Hash(plaintext string) []byte {
+	var newHash func() hash.Hash
+	switch h.Algorithm {
+	case TokenHashSHA256:
+		newHash = sha256.New
+	case TokenHashSHA512:
+		newHash = sha512.New
+	default:
+		panic(fmt.Sprintf("data: unknown token hash algorithm %q", h.Algorithm))
+	}
+
+	var mac hash.Hash
+	if len(h.Pepper) > 0 {
+		mac = hmac.New(newHash, h.Pepper)
+	} else {
+		mac = newHash()
+	}
+	mac.Write([]byte(plaintext))
+	return mac.Sum(nil)
+}
+
+// Candidates returns the hashes a plaintext token may be stored under: its hash, and then its
+// hash by the previous hasher, if there is one.
+func (h TokenHasher) Candidates(plaintext string) [][]byte {
+	candidates := [][]byte{h.Hash(plaintext)}
+	if h.Previous != nil {
+		candidates = append(candidates, h.Previous.Hash(plaintext))
+	}
+	return candidates
+}
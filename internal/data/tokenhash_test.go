@@ -0,0 +1,46 @@
+package data
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestTokenHasherHash(t *testing.T) {
+	legacy := sha256.Sum256([]byte("Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"))
+
+	plain := TokenHasher{Algorithm: TokenHashSHA256}
+	if got := plain.Hash("Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"); !bytes.Equal(got, legacy[:]) {
+		t.Errorf("want the unpeppered SHA-256 hash to match the legacy hash, got %x", got)
+	}
+
+	peppered := TokenHasher{Algorithm: TokenHashSHA256, Pepper: []byte("0123456789abcdef0123456789abcdef")}
+	if got := peppered.Hash("Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"); bytes.Equal(got, legacy[:]) {
+		t.Error("want the peppered hash to differ from the plain hash")
+	}
+
+	sha512 := TokenHasher{Algorithm: TokenHashSHA512}
+	if got := sha512.Hash("Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"); len(got) != 64 {
+		t.Errorf("want a 64-byte SHA-512 hash, got %d bytes", len(got))
+	}
+}
+
+func TestTokenHasherCandidates(t *testing.T) {
+	previous := TokenHasher{Algorithm: TokenHashSHA256}
+	current := TokenHasher{Algorithm: TokenHashSHA512, Pepper: []byte("pepper"), Previous: &previous}
+
+	got := current.Candidates("token")
+	if len(got) != 2 {
+		t.Fatalf("want 2 candidates, got %d", len(got))
+	}
+	if !bytes.Equal(got[0], current.Hash("token")) {
+		t.Error("want the current hash first")
+	}
+	if !bytes.Equal(got[1], previous.Hash("token")) {
+		t.Error("want the previous hash second")
+	}
+
+	if got := previous.Candidates("token"); len(got) != 1 {
+		t.Errorf("want 1 candidate without a previous hasher, got %d", len(got))
+	}
+}
@@ -3,7 +3,6 @@ package data
 import (
 	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
 	"log"
@@ -68,6 +67,15 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 
 // Insert inserts a new token record into the tokens table.
 func (m TokenModel) Insert(token *Token) error {
+	ctx, cancel := queryContext("TokenModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
+// insertToken runs the query of TokenModel.Insert on q, which is either the connection pool or
+// a transaction, see Tx.
+func insertToken(ctx context.Context, q querier, token *Token) error {
 	query := `
 		INSERT INTO tokens (hash, user_id, expiry, scope)
 		VALUES ($1, $2, $3, $4)
@@ -75,10 +83,7 @@ func (m TokenModel) Insert(token *Token) error {
 
 	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := q.ExecContext(ctx, query, args...)
 	return err
 }
 
@@ -89,13 +94,69 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 		WHERE scope = $1 AND user_id = $2
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext("TokenModel.DeleteAllForUser", 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
 
+// DeleteAllScopesForUser deletes all tokens for a specific user, whatever their scope.
+func (m TokenModel) DeleteAllScopesForUser(userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1
+		`
+
+	ctx, cancel := queryContext("TokenModel.DeleteAllScopesForUser", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteExpired deletes up to limit tokens past their expiry time, and returns how many were
+// deleted. Callers purging every expired token call it repeatedly until it deletes fewer than
+// limit, so that no single statement holds locks on a large part of the table.
+func (m TokenModel) DeleteExpired(limit int) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE hash IN (
+			SELECT hash FROM tokens
+			WHERE expiry < $1
+			LIMIT $2
+		)
+		`
+
+	ctx, cancel := queryContext("TokenModel.DeleteExpired", 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// IsExpired reports whether a token with the given scope exists but has passed its expiry
+// time. It is used to tell expired tokens apart from unknown ones, since GetForToken treats
+// both as ErrRecordNotFound.
+func (m TokenModel) IsExpired(scope, tokenPlaintext string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM tokens WHERE hash = ANY($1) AND scope = $2 AND expiry <= $3
+		)
+		`
+
+	ctx, cancel := queryContext("TokenModel.IsExpired", 3*time.Second)
+	defer cancel()
+
+	var expired bool
+	err := m.DB.QueryRowContext(ctx, query, tokenHashes(tokenPlaintext), scope, time.Now()).Scan(&expired)
+	return expired, err
+}
+
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	// Create a Token instance containing the user ID, expiry, and scope information.
 	// Notice that we add the provided ttl (time-to-live) duration parameter to the
@@ -128,15 +189,9 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	// the WithPadding(base32.NoPadding) method in the line below to omit them.
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
-	// Generate a SHA-256 hash of the plaintext token string. This will be the value
-	// that we store in the `hash` field of our tokens table.
-	// Note, that the sha256.Sum256() function returns an *array* of length 32,
-	// so to make it easier to work with we convert it to a slice using the [:]
-	// operator before operating on it.
-	// This is the hash that we will store in the hash column of the tokens table
-	// in our database.
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	// Hash the plaintext token string, with SHA-256 unless TokenHashing says otherwise. This
+	// is the hash that we will store in the hash column of the tokens table in our database.
+	token.Hash = HashToken(token.Plaintext)
 
 	return token, nil
 }
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrTrialLimit is returned when an IP address has already been issued as many trial tokens as
+// it may within the limit's window.
+var ErrTrialLimit = errors.New("trial token limit reached")
+
+// ScopeTrial is the scope of anonymous trial tokens. Trial tokens are stored in the
+// trial_tokens table rather than the tokens table, because they don't belong to a user.
+const ScopeTrial = "trial"
+
+// TrialPermissions are the permissions granted to requests authenticated with a trial token.
+// Trial tokens are strictly read-only.
+var TrialPermissions = Permissions{"movies:read"}
+
+// TrialTokenModel struct wraps a sql.DB connection pool and allows us to work with the
+// trial_tokens table in our database.
+type TrialTokenModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// New generates a new trial token for the given client IP and inserts it into the trial_tokens
+// table, unless the IP address has been issued max tokens since the given time already, in
+// which case ErrTrialLimit is returned. The plaintext token has the same format as the other
+// tokens, so it can be sent in an "Authorization: Bearer <token>" header in exactly the same way.
+func (m TrialTokenModel) New(ip string, ttl time.Duration, since time.Time, max int) (*Token, error) {
+	token, err := generateToken(0, ttl, ScopeTrial)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := queryContext("TrialTokenModel.New", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Concurrent requests from the same IP address take turns, so that they can't all see a
+	// count below the limit before any of them has inserted its token.
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('trial_tokens:' || $1))`, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO trial_tokens (hash, ip, expiry)
+		SELECT $1, $2, $3
+		WHERE (SELECT count(*) FROM trial_tokens WHERE ip = $2 AND created_at >= $4) < $5
+		`
+
+	result, err := tx.ExecContext(ctx, query, token.Hash, ip, token.Expiry, since, max)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if inserted == 0 {
+		return nil, ErrTrialLimit
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Valid reports whether the plaintext token matches an unexpired trial token.
+func (m TrialTokenModel) Valid(tokenPlaintext string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM trial_tokens WHERE hash = ANY($1) AND expiry > $2
+		)
+		`
+
+	ctx, cancel := queryContext("TrialTokenModel.Valid", 3*time.Second)
+	defer cancel()
+
+	var valid bool
+	err := m.DB.QueryRowContext(ctx, query, tokenHashes(tokenPlaintext), time.Now()).Scan(&valid)
+	return valid, err
+}
+
+// DeleteExpired deletes the trial tokens past their expiry time which were issued before the
+// given time, and returns how many were deleted. Tokens issued since then are kept even once
+// expired, because New still counts them towards the limit of their IP address.
+func (m TrialTokenModel) DeleteExpired(issuedBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM trial_tokens
+		WHERE expiry < $1 AND created_at < $2
+		`
+
+	ctx, cancel := queryContext("TrialTokenModel.DeleteExpired", 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), issuedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
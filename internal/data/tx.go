@@ -0,0 +1,126 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// querier is the part of *sql.DB and *sql.Tx that queries run on. Queries which are needed
+// both on their own and in a Tx are written once against it.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Tx runs a series of writes in a single database transaction. It exists so that a change and
+// the outbox messages announcing it are committed together or not at all, see OutboxModel.
+type Tx struct {
+	tx     *sql.Tx
+	ctx    context.Context
+	cancel context.CancelFunc
+	// movies is the cache the movies updated in the transaction are added to once it commits.
+	movies  *MovieCache
+	updated []*Movie
+	// permissions is the cache the users whose permissions changed in the transaction are
+	// dropped from once it commits.
+	permissions *PermissionCache
+	granted     []int64
+}
+
+// Begin starts a new transaction. The whole transaction must finish within timeout, and the
+// caller must always call either Commit() or Rollback().
+func (m Models) Begin(timeout time.Duration) (*Tx, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &Tx{tx: tx, ctx: ctx, cancel: cancel, movies: m.Movies.Cache, permissions: m.Permissions.Cache}, nil
+}
+
+// InsertMovie works like MovieModel.Insert, within the transaction.
+func (t *Tx) InsertMovie(movie *Movie) error {
+	return insertMovie(t.ctx, t.tx, movie)
+}
+
+// UpdateMovie works like MovieModel.Update, within the transaction.
+func (t *Tx) UpdateMovie(movie *Movie) error {
+	return t.UpdateOwnMovie(movie, 0)
+}
+
+// UpdateOwnMovie works like UpdateMovie, but only updates the movie if it was created by the
+// user userID, like the MovieModel returned by MovieModel.OwnedBy. A userID of 0 updates any.
+// Either way, the movie is only updated while it belongs to the catalogue it was read from.
+func (t *Tx) UpdateOwnMovie(movie *Movie, userID int64) error {
+	err := updateMovie(t.ctx, t.tx, movie, userID, movie.OrgID)
+	if err != nil {
+		return err
+	}
+
+	t.updated = append(t.updated, copyMovie(movie))
+	return nil
+}
+
+// InsertUser works like UserModel.Insert, within the transaction.
+func (t *Tx) InsertUser(user *User) error {
+	return insertUser(t.ctx, t.tx, user)
+}
+
+// AddPermissionsForUser works like PermissionModel.AddForUser, within the transaction.
+func (t *Tx) AddPermissionsForUser(userID int64, codes ...string) error {
+	t.granted = append(t.granted, userID)
+	return addPermissionsForUser(t.ctx, t.tx, userID, codes...)
+}
+
+// NewToken works like TokenModel.New, within the transaction.
+func (t *Tx) NewToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertToken(t.ctx, t.tx, token)
+	return token, err
+}
+
+// Enqueue works like OutboxModel.Insert, within the transaction.
+func (t *Tx) Enqueue(msg *OutboxMessage) error {
+	return insertOutboxMessage(t.ctx, t.tx, msg)
+}
+
+// Commit commits the transaction.
+func (t *Tx) Commit() error {
+	defer t.cancel()
+
+	err := t.tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range t.updated {
+		t.movies.Add(movie)
+	}
+	for _, userID := range t.granted {
+		t.permissions.Delete(userID)
+	}
+	return nil
+}
+
+// Rollback discards every change made in the transaction. It is safe to call after Commit(), in
+// which case it does nothing, so it can be deferred.
+func (t *Tx) Rollback() error {
+	defer t.cancel()
+
+	err := t.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+
+	return err
+}
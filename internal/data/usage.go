@@ -0,0 +1,74 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// UsageMonth returns the first instant of the calendar month, in UTC, that t falls in. Usage
+// is counted per UsageMonth.
+func UsageMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// UsageModel struct wraps a sql.DB connection pool and allows us to work with the user_usage
+// table in our database.
+type UsageModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Consume counts a request by the user in month, unless they already made limit requests that
+// month. A limit of 0 means no limit. It returns the number of requests counted in month and
+// whether this one was within the limit. The check and the count are a single statement, so
+// concurrent requests can't overshoot the limit.
+func (m UsageModel) Consume(userID int64, month time.Time, limit int64) (int64, bool, error) {
+	query := `
+		INSERT INTO user_usage (user_id, month, requests)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (user_id, month) DO UPDATE
+		SET requests = user_usage.requests + 1
+		WHERE $3 = 0 OR user_usage.requests < $3
+		RETURNING requests`
+
+	ctx, cancel := queryContext("UsageModel.Consume", 3*time.Second)
+	defer cancel()
+
+	var requests int64
+	err := m.DB.QueryRowContext(ctx, query, userID, month, limit).Scan(&requests)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// The WHERE clause of the update didn't match: the limit is reached.
+			return limit, false, nil
+		}
+		return 0, false, err
+	}
+
+	return requests, true, nil
+}
+
+// Get returns the number of requests counted for the user in month.
+func (m UsageModel) Get(userID int64, month time.Time) (int64, error) {
+	query := `
+		SELECT requests
+		FROM user_usage
+		WHERE user_id = $1 AND month = $2`
+
+	ctx, cancel := queryContext("UsageModel.Get", 3*time.Second)
+	defer cancel()
+
+	var requests int64
+	err := m.DB.QueryRowContext(ctx, query, userID, month).Scan(&requests)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return requests, nil
+}
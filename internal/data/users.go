@@ -2,12 +2,12 @@ package data
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"log"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/saalikmubeen/greenlight/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -31,6 +31,9 @@ type User struct {
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
 	Version   int       `json:"-"`
+
+	// trial is true for the stand-in users created by NewTrialUser.
+	trial bool
 }
 
 // Check if a User instance is the AnonymousUser.
@@ -38,6 +41,17 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// NewTrialUser returns the stand-in user for a request authenticated with an anonymous trial
+// token. It has no ID and is treated as activated, but only holds the TrialPermissions.
+func NewTrialUser() *User {
+	return &User{Name: "Trial", Activated: true, trial: true}
+}
+
+// IsTrial checks if a User instance was authenticated with a trial token.
+func (u *User) IsTrial() bool {
+	return u.trial
+}
+
 // AnonymousUser.IsAnonymous() // → Returns true
 // otherUser := &User{}
 // otherUser.IsAnonymous() // → Returns false
@@ -48,6 +62,8 @@ type UserModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	// Replica, if set, is where GetForToken reads authentication tokens from.
+	Replica *ReadPool
 }
 
 // password tyep is a struct containing the plaintext and hashed version of a password for a User.
@@ -93,22 +109,32 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 // the RETURNING clause to read them into the User struct after the insert. Also, we check
 // if our table already contains the same email address and if so return ErrDuplicateEmail error.
 func (m UserModel) Insert(user *User) error {
+	ctx, cancel := queryContext("UserModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return insertUser(ctx, m.DB, user)
+}
+
+// insertUser runs the query of UserModel.Insert on q, which is either the connection pool or a
+// transaction, see Tx.
+func insertUser(ctx context.Context, q querier, user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
 		RETURNING id, created_at, version
 		`
 
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
+	// Store the normalized form of the email address, so that the UNIQUE constraint on the
+	// email column also catches variants of an existing address.
+	user.Email = NormalizeEmail(user.Email)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 
 	// If the table already contains a record with this email address, then when we try to
 	// perform the insert there will be a violation of the UNIQUE "users_email_key" constraint
 	// that we set up in the previous chapter. We check for this error specifically, and return
 	// ErrDuplicateEmail error instead.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -121,22 +147,67 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Get retrieves the User details from the database based on the user's ID.
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1
+		`
+
+	var user User
+
+	ctx, cancel := queryContext("UserModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // GetByEmail retrieves the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this query will only return one record,
-// or none at all, upon which we return a ErrRecordNotFound error).
+// or none at all, upon which we return a ErrRecordNotFound error). The users registered before
+// Gmail alias folding was enabled are found by the address they registered with, see
+// EmailPolicy.LookupForms, but a user registered with the folded address takes precedence.
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
 		SELECT id, created_at, name, email, password_hash, activated, version
 		FROM users
-		WHERE email = $1
+		WHERE email = ANY($1)
+		ORDER BY email = $2 DESC
+		LIMIT 1
 		`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext("UserModel.GetByEmail", 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+	// Look the user up using the same normalized form that Insert() stores.
+	forms := EmailNormalization.LookupForms(email)
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(forms), forms[0]).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
@@ -169,6 +240,8 @@ func (m UserModel) Update(user *User) error {
 		RETURNING version
 		`
 
+	user.Email = NormalizeEmail(user.Email)
+
 	args := []interface{}{
 		user.Name,
 		user.Email,
@@ -178,7 +251,7 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext("UserModel.Update", 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -200,9 +273,9 @@ func (m UserModel) Update(user *User) error {
 // GetForToken retrieves a user record from the users table for
 // an associated token and token scope in the tokens table.
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
-	// Calculate the SHA-256 hash for the plaintext token provided by the client.
-	// Note, that this will return a byte *array* with length 32, not a slice.
-	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	// Calculate the hashes the plaintext token provided by the client may be stored under,
+	// see TokenHashing.
+	tokenHashes := tokenHashes(tokenPlaintext)
 
 	// Because the token hash is also a primary key, we will always be left
 	// with exactly one record which contains the details of the user associated
@@ -214,7 +287,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		FROM       users
         INNER JOIN tokens
 			ON users.id = tokens.user_id
-        WHERE tokens.hash = $1  -- <-- Note: this is potentially vulnerable to a timing attack,
+        WHERE tokens.hash = ANY($1)  -- <-- Note: this is potentially vulnerable to a timing attack,
 		    -- because PostgreSQL’s evaluation of the tokens.hash = $1 condition is not 
 		    -- performed in constant-time. 
             -- But if successful the attacker would only be able to retrieve a *hashed* token 
@@ -224,28 +297,42 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 			AND tokens.expiry > $3
 		`
 
-	// Create a slice containing the query args. Note, that we use the [:]
-	// operator to get a slice containing the token hash, since the pq driver does
-	// not support passing in an array. Also, we pass the current time as the
+	// Create a slice containing the query args. Also, we pass the current time as the
 	// value to check against the token expiry.
-	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
+	args := []interface{}{tokenHashes, tokenScope, time.Now()}
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext("UserModel.GetForToken", 3*time.Second)
 	defer cancel()
 
+	// Authentication tokens are looked up on the replica when there is one. A token which was
+	// only just created may not have reached it yet, so one which isn't found there is looked
+	// for again on the primary. The other scopes are always read from the primary, since the
+	// user they return is then updated, which needs their current version.
+	onReplica := m.Replica != nil && tokenScope == ScopeAuthentication
+
 	// Execute the query, scanning the return values into a User struct.
 	// If no matching record is found we return an ErrRecordNotFound error.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
-		&user.ID,
-		&user.CreatedAt,
-		&user.Name,
-		&user.Email,
-		&user.Password.hash,
-		&user.Activated,
-		&user.Version,
-	)
+	scan := func(q readQuerier) error {
+		return q.QueryRowContext(ctx, query, args...).Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+	}
+
+	var err error
+	if onReplica {
+		err = scan(m.Replica)
+	}
+	if !onReplica || errors.Is(err, sql.ErrNoRows) {
+		err = scan(m.DB)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -259,9 +346,41 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	return &user, nil
 }
 
+// DeleteUnactivated deletes up to limit users who registered before the given time and never
+// activated their account, and returns how many were deleted, which frees their email address
+// for a new registration. Users who still hold an unexpired activation token, because they
+// asked for a new one, are kept until it expires. Their tokens are deleted along with them.
+// Like TokenModel.DeleteExpired, it is called repeatedly until it deletes fewer than limit.
+func (m UserModel) DeleteUnactivated(registeredBefore time.Time, limit int) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE id IN (
+			SELECT id FROM users
+			WHERE NOT activated AND created_at < $1
+			AND NOT EXISTS (
+				SELECT 1 FROM tokens
+				WHERE tokens.user_id = users.id AND tokens.scope = $2 AND tokens.expiry > NOW()
+			)
+			LIMIT $3
+		)`
+
+	ctx, cancel := queryContext("UserModel.DeleteUnactivated", 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, registeredBefore, ScopeActivation, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 // ValidateEmail checks that the Email field is not an empty string and that it matches the regex
-// for email addresses, validator.EmailRX.
+// for email addresses, validator.EmailRX. The address is normalized first, so the checks apply
+// to the form which will actually be stored or looked up.
 func ValidateEmail(v *validator.Validator, email string) {
+	email = NormalizeEmail(email)
+
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be valid email address")
 }
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// ViewAsAudit is a record of a single request made by an administrator while viewing the API
+// as another user.
+type ViewAsAudit struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	AdminID      int64     `json:"admin_id"`
+	TargetUserID int64     `json:"target_user_id"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+}
+
+// ViewAsAuditModel struct wraps a sql.DB connection pool and allows us to work with the
+// view_as_audits table in our database.
+type ViewAsAuditModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert records a view-as request.
+func (m ViewAsAuditModel) Insert(audit *ViewAsAudit) error {
+	query := `
+		INSERT INTO view_as_audits (admin_id, target_user_id, method, path)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{audit.AdminID, audit.TargetUserID, audit.Method, audit.Path}
+
+	ctx, cancel := queryContext("ViewAsAuditModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&audit.ID, &audit.CreatedAt)
+}
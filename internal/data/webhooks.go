@@ -0,0 +1,124 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrDuplicateWebhookEvent is returned when a webhook event with the same provider and event id
+// has been received before, i.e. the delivery is a replay.
+var ErrDuplicateWebhookEvent = errors.New("duplicate webhook event")
+
+// Statuses of a received webhook event.
+const (
+	WebhookStatusPending   = "pending"
+	WebhookStatusProcessed = "processed"
+	WebhookStatusFailed    = "failed"
+)
+
+// WebhookEvent is an event delivered to us by a third-party integration.
+type WebhookEvent struct {
+	Provider    string          `json:"provider"`
+	EventID     string          `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+}
+
+// WebhookEventModel struct wraps a sql.DB connection pool and allows us to work with the
+// webhook_events table in our database.
+type WebhookEventModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert records a newly received event. If the event has already been received,
+// ErrDuplicateWebhookEvent is returned and nothing is written.
+func (m WebhookEventModel) Insert(event *WebhookEvent) error {
+	query := `
+		INSERT INTO webhook_events (provider, event_id, event_type, payload)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, event_id) DO NOTHING
+		RETURNING received_at, status
+		`
+
+	args := []interface{}{event.Provider, event.EventID, event.EventType, []byte(event.Payload)}
+
+	ctx, cancel := queryContext("WebhookEventModel.Insert", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ReceivedAt, &event.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateWebhookEvent
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Finish marks an event as processed, or as failed if procErr is not nil.
+func (m WebhookEventModel) Finish(provider, eventID string, procErr error) error {
+	status, message := WebhookStatusProcessed, ""
+	if procErr != nil {
+		status, message = WebhookStatusFailed, procErr.Error()
+	}
+
+	query := `
+		UPDATE webhook_events
+		SET status = $1, error = $2, processed_at = NOW()
+		WHERE provider = $3 AND event_id = $4
+		`
+
+	ctx, cancel := queryContext("WebhookEventModel.Finish", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, status, message, provider, eventID)
+	return err
+}
+
+// SuppressionModel struct wraps a sql.DB connection pool and allows us to work with the
+// email_suppressions table in our database.
+type SuppressionModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert adds an email address to the suppression list. Adding an address which is already on
+// the list just updates the reason.
+func (m SuppressionModel) Insert(email, reason string) error {
+	query := `
+		INSERT INTO email_suppressions (email, reason)
+		VALUES ($1, $2)
+		ON CONFLICT (email) DO UPDATE SET reason = EXCLUDED.reason
+		`
+
+	ctx, cancel := queryContext("SuppressionModel.Insert", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, NormalizeEmail(email), reason)
+	return err
+}
+
+// Exists reports whether an email address is on the suppression list.
+func (m SuppressionModel) Exists(email string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM email_suppressions WHERE email = $1)`
+
+	ctx, cancel := queryContext("SuppressionModel.Exists", 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, NormalizeEmail(email)).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,250 @@
+// Package errorreport forwards the errors of the API server to Sentry, with their stack traces,
+// the request they happened in and the user making it, so that they can be grouped and
+// triaged. The events are sent in the background with Sentry's envelope API, and dropped
+// rather than slowing the requests down when Sentry can't keep up.
+package errorreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// eventBuffer is how many events can wait to be sent before the next ones are dropped.
+const eventBuffer = 100
+
+// Options configures a Reporter.
+type Options struct {
+	// DSN is the client key of the Sentry project, such as
+	// https://<key>@o0.ingest.sentry.io/<project>.
+	DSN string
+	// Environment, Release and ServerName describe the application the events come from.
+	Environment string
+	Release     string
+	ServerName  string
+	// SampleRate is the fraction of the errors reported, between 0 and 1.
+	SampleRate float64
+	// SendPII includes the email address and IP address of the users, and the query string of
+	// the requests, in the events. The user ID is always included.
+	SendPII bool
+}
+
+// User is the user making the request an error happened in.
+type User struct {
+	ID    int64
+	Email string
+}
+
+// Stats counts the events sent, the events dropped because the buffer was full and the events
+// Sentry couldn't be reached for or refused.
+type Stats struct {
+	Sent    int64 `json:"sent"`
+	Dropped int64 `json:"dropped"`
+	Failed  int64 `json:"failed"`
+}
+
+// Reporter sends errors to Sentry. Create one with New. A nil *Reporter reports nothing, so
+// that the callers don't have to check whether error reporting is enabled.
+type Reporter struct {
+	opts     Options
+	endpoint string
+	auth     string
+	client   *http.Client
+	// sample returns a number in [0, 1) to compare with the sample rate.
+	sample func() float64
+
+	events   chan event
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	stopped  atomic.Bool
+	sent     atomic.Int64
+	dropped  atomic.Int64
+	failed   atomic.Int64
+}
+
+// New returns a Reporter sending the events to the Sentry project of opts.DSN.
+func New(opts Options) (*Reporter, error) {
+	endpoint, key, err := parseDSN(opts.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reporter{
+		opts:     opts,
+		endpoint: endpoint,
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=greenlight-errorreport/1.0, sentry_key=%s", key),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		sample:   mathrand.Float64,
+		events:   make(chan event, eventBuffer),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r, nil
+}
+
+// CheckDSN returns an error if dsn isn't a valid Sentry DSN.
+func CheckDSN(dsn string) error {
+	_, _, err := parseDSN(dsn)
+	return err
+}
+
+// parseDSN returns the envelope endpoint and the public key of a Sentry DSN, which reads
+// scheme://key@host[/path]/project.
+func parseDSN(dsn string) (endpoint, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("errorreport: invalid DSN: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return "", "", errors.New("errorreport: invalid DSN: want scheme://key@host/project")
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	project := path[i+1:]
+	if project == "" {
+		return "", "", errors.New("errorreport: invalid DSN: missing project ID")
+	}
+
+	endpoint = fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, path[:i], project)
+	return endpoint, u.User.Username(), nil
+}
+
+// Report sends err, which happened while handling req on behalf of user, to Sentry. req and
+// user may be nil. The stack trace is the one of the caller, or the one of the panic for the
+// errors returned by Recovered.
+func (r *Reporter) Report(err error, req *http.Request, user *User) {
+	if r == nil || err == nil {
+		return
+	}
+	if r.opts.SampleRate < 1 && r.sample() >= r.opts.SampleRate {
+		return
+	}
+
+	e := r.newEvent(err, callers(3), req, user)
+	if r.stopped.Load() {
+		r.dropped.Add(1)
+		return
+	}
+
+	select {
+	case r.events <- e:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// run sends the queued events until the reporter is shut down, and then the ones left.
+func (r *Reporter) run() {
+	defer close(r.done)
+
+	for {
+		select {
+		case e := <-r.events:
+			r.send(e)
+		case <-r.stop:
+			// Only run receives from events, so they don't block while it isn't empty.
+			for len(r.events) > 0 {
+				r.send(<-r.events)
+			}
+			return
+		}
+	}
+}
+
+// send posts an event in an envelope, counting it as failed if Sentry couldn't take it.
+func (r *Reporter) send(e event) {
+	if err := r.post(e); err != nil {
+		r.failed.Add(1)
+		return
+	}
+	r.sent.Add(1)
+}
+
+func (r *Reporter) post(e event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	header, err := json.Marshal(map[string]string{
+		"event_id": e.EventID,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	body.Write(header)
+	fmt.Fprintf(&body, "\n{\"type\":\"event\",\"length\":%d}\n", len(payload))
+	body.Write(payload)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("errorreport: %s", resp.Status)
+	}
+	return nil
+}
+
+// Stats returns the counts of the events sent, dropped and failed so far.
+func (r *Reporter) Stats() Stats {
+	if r == nil {
+		return Stats{}
+	}
+	return Stats{Sent: r.sent.Load(), Dropped: r.dropped.Load(), Failed: r.failed.Load()}
+}
+
+// Shutdown stops the reporter, and waits until the events reported so far are sent or ctx is
+// done. The errors reported afterwards are dropped.
+func (r *Reporter) Shutdown(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	r.stopOnce.Do(func() {
+		r.stopped.Store(true)
+		close(r.stop)
+	})
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newEventID returns a random event ID, 32 hex digits.
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
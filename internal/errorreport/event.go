@@ -0,0 +1,237 @@
+package errorreport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// modulePath is the import path prefix of the application's own packages, whose frames are
+// marked as in app, so that Sentry groups the events by them.
+const modulePath = "github.com/saalikmubeen/greenlight/"
+
+// filtered replaces the values scrubbed from the events, as Sentry's own scrubbing does.
+const filtered = "[Filtered]"
+
+// forwardedHeaders are the request headers included in the events. The others, among which
+// Authorization and Cookie, are left out.
+var forwardedHeaders = []string{"Accept", "Accept-Language", "Content-Type", "Origin", "Referer", "User-Agent"}
+
+// sensitiveParams are the words which, in the name of a query parameter, make it a secret
+// whose value is scrubbed.
+var sensitiveParams = []string{"password", "token", "secret", "key", "signature"}
+
+// event is the JSON payload of a Sentry error event.
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Transaction string            `json:"transaction,omitempty"`
+	Exception   exceptions        `json:"exception"`
+	Request     *eventRequest     `json:"request,omitempty"`
+	User        map[string]string `json:"user,omitempty"`
+}
+
+type exceptions struct {
+	Values []exception `json:"values"`
+}
+
+type exception struct {
+	Type       string     `json:"type"`
+	Value      string     `json:"value"`
+	Stacktrace stacktrace `json:"stacktrace"`
+}
+
+type stacktrace struct {
+	Frames []frame `json:"frames"`
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type eventRequest struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+// PanicError is a panic recovered while handling a request. Its stack trace is the one of the
+// panic rather than the one of the code reporting it.
+type PanicError struct {
+	Value interface{}
+	stack []uintptr
+}
+
+// Recovered returns an error for the value v returned by recover. It must be called from the
+// deferred function which recovered, while the stack still holds the frames which panicked.
+func Recovered(v interface{}) error {
+	return &PanicError{Value: v, stack: callers(3)}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprint(e.Value)
+}
+
+// callers returns the program counters of the stack of the caller, skipping skip frames as
+// runtime.Callers does.
+func callers(skip int) []uintptr {
+	pc := make([]uintptr, 64)
+	return pc[:runtime.Callers(skip, pc)]
+}
+
+// newEvent returns the event of err, reported with the stack pc.
+func (r *Reporter) newEvent(err error, pc []uintptr, req *http.Request, user *User) event {
+	exc := exception{Type: fmt.Sprintf("%T", err), Value: err.Error()}
+	level := "error"
+
+	var pe *PanicError
+	if errors.As(err, &pe) {
+		exc.Type = "panic"
+		pc = pe.stack
+		level = "fatal"
+	} else if inner := errors.Unwrap(err); inner != nil {
+		// Group the wrapped errors by the type of the innermost one, e.g. *pq.Error, rather
+		// than by the one of fmt.Errorf.
+		for errors.Unwrap(inner) != nil {
+			inner = errors.Unwrap(inner)
+		}
+		exc.Type = fmt.Sprintf("%T", inner)
+	}
+	exc.Stacktrace.Frames = frames(pc)
+
+	e := event{
+		EventID:     newEventID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Platform:    "go",
+		Environment: r.opts.Environment,
+		Release:     r.opts.Release,
+		ServerName:  r.opts.ServerName,
+		Exception:   exceptions{Values: []exception{exc}},
+	}
+
+	if req != nil {
+		e.Transaction = req.Method + " " + req.URL.Path
+		e.Request = r.request(req)
+	}
+
+	if user != nil {
+		e.User = map[string]string{"id": fmt.Sprint(user.ID)}
+		if r.opts.SendPII && user.Email != "" {
+			e.User["email"] = user.Email
+		}
+	}
+	if req != nil && r.opts.SendPII {
+		if e.User == nil {
+			e.User = map[string]string{}
+		}
+		if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			e.User["ip_address"] = host
+		}
+	}
+
+	return e
+}
+
+// frames returns the stack frames of pc, the outermost first as Sentry expects.
+func frames(pc []uintptr) []frame {
+	var fs []frame
+	it := runtime.CallersFrames(pc)
+	for {
+		f, more := it.Next()
+		if f.Function != "" {
+			module, function := splitFunction(f.Function)
+			fs = append(fs, frame{
+				Function: function,
+				Module:   module,
+				AbsPath:  f.File,
+				Lineno:   f.Line,
+				InApp:    strings.HasPrefix(f.Function, modulePath) && !strings.Contains(f.File, "/vendor/"),
+			})
+		}
+		if !more {
+			break
+		}
+	}
+
+	for i, j := 0, len(fs)-1; i < j; i, j = i+1, j-1 {
+		fs[i], fs[j] = fs[j], fs[i]
+	}
+	return fs
+}
+
+// splitFunction splits the name of a function, such as
+// github.com/saalikmubeen/greenlight/internal/data.(*MovieModel).Get, into its package path
+// and its name within the package.
+func splitFunction(name string) (module, function string) {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	dot += slash + 1
+	return name[:dot], name[dot+1:]
+}
+
+// request returns the part of req included in the events: the URL without the query string,
+// the method and the forwardedHeaders, plus the query string and the client IP address when
+// opts.SendPII is set. The values of the sensitiveParams are scrubbed from the query string.
+func (r *Reporter) request(req *http.Request) *eventRequest {
+	u := *req.URL
+	u.RawQuery, u.Fragment, u.User = "", "", nil
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+
+	er := &eventRequest{URL: u.String(), Method: req.Method, Headers: map[string]string{}}
+	for _, name := range forwardedHeaders {
+		if v := req.Header.Get(name); v != "" {
+			er.Headers[name] = v
+		}
+	}
+
+	if r.opts.SendPII {
+		er.QueryString = scrubQuery(req.URL.Query())
+		er.Env = map[string]string{"REMOTE_ADDR": req.RemoteAddr}
+	}
+	return er
+}
+
+// scrubQuery returns the encoded query, with the values of the parameters named after one of
+// the sensitiveParams filtered.
+func scrubQuery(query url.Values) string {
+	for name, values := range query {
+		lower := strings.ToLower(name)
+		for _, word := range sensitiveParams {
+			if strings.Contains(lower, word) {
+				for i := range values {
+					values[i] = filtered
+				}
+				break
+			}
+		}
+	}
+	return query.Encode()
+}
@@ -0,0 +1,210 @@
+// Package events implements the in-process event bus which the API publishes catalogue
+// changes on, and which long-lived consumers such as the Server-Sent Events feed subscribe to.
+// Consumers which must see every event, such as caches, register a Handler instead.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event is a single message published on the bus. IDs are assigned by the bus in publishing
+// order, starting at 1, so a subscriber which has seen an event can resume after it. IDs are
+// only meaningful on the bus that assigned them, see Bus.Epoch.
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Subscription receives the events published after it was created. C is closed when the
+// subscription is cancelled, when the bus is closed, or when the subscriber falls so far behind
+// that its buffer fills up; in the last case it can resubscribe from the last event it saw.
+type Subscription struct {
+	C <-chan Event
+	// Start is the id of the last event published before the subscription was created.
+	Start int64
+
+	c    chan Event
+	bus  *Bus
+	once sync.Once
+}
+
+// Cancel stops the subscription and closes C. It is safe to call more than once.
+func (s *Subscription) Cancel() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	s.bus.remove(s)
+}
+
+// Handler is called with every event published on the bus, before Publish returns. Unlike
+// subscribers, handlers are never dropped, so they suit consumers which must not miss an event,
+// such as caches which invalidate their entries. They must return quickly, and must not publish
+// on the bus themselves.
+type Handler func(Event)
+
+// Bus fans events out to every subscriber and keeps the most recent ones, so that subscribers
+// which reconnect can catch up on what they missed. The zero value is not usable, create a Bus
+// with NewBus.
+type Bus struct {
+	epoch       string
+	mu          sync.Mutex
+	lastID      int64
+	history     []Event
+	historySize int
+	bufferSize  int
+	subs        map[*Subscription]struct{}
+	handlers    []Handler
+	closed      bool
+}
+
+// NewBus returns a bus which keeps the last historySize events and buffers up to bufferSize
+// events for each subscriber.
+func NewBus(historySize, bufferSize int) *Bus {
+	randomBytes := make([]byte, 8)
+	// A failure leaves the epoch all zeroes, which only makes it less likely to be unique.
+	_, _ = rand.Read(randomBytes)
+
+	return &Bus{
+		epoch:       hex.EncodeToString(randomBytes),
+		historySize: historySize,
+		bufferSize:  bufferSize,
+		subs:        make(map[*Subscription]struct{}),
+	}
+}
+
+// Epoch returns a random string which identifies this bus. Event ids restart at 1 on every
+// bus, so a subscriber resuming from an event id must check that it got the id from a bus with
+// the same epoch, i.e. from the same process.
+func (b *Bus) Epoch() string {
+	return b.epoch
+}
+
+// Publish assigns the next id to an event, records it in the history and sends it to every
+// subscriber. It never blocks: subscribers whose buffer is full are dropped.
+func (b *Bus) Publish(eventType string, data json.RawMessage) Event {
+	event, handlers := b.publish(eventType, data)
+
+	for _, h := range handlers {
+		h(event)
+	}
+
+	return event
+}
+
+// publish records an event and sends it to the subscribers, and returns it together with the
+// handlers to call.
+func (b *Bus) publish(eventType string, data json.RawMessage) (Event, []Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+
+	if b.historySize > 0 {
+		if len(b.history) == b.historySize {
+			copy(b.history, b.history[1:])
+			b.history = b.history[:len(b.history)-1]
+		}
+		b.history = append(b.history, event)
+	}
+
+	for s := range b.subs {
+		select {
+		case s.c <- event:
+		default:
+			b.remove(s)
+		}
+	}
+
+	return event, b.handlers
+}
+
+// Announce calls the handlers with an event without publishing it: the event has no id, isn't
+// recorded in the history and isn't sent to the subscribers. It is for events which reach the
+// bus later some other way, such as through the database listener, but which the handlers
+// should see right away.
+func (b *Bus) Announce(eventType string, data json.RawMessage) {
+	b.mu.Lock()
+	handlers := b.handlers
+	b.mu.Unlock()
+
+	event := Event{Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+	for _, h := range handlers {
+		h(event)
+	}
+}
+
+// Handle registers h to be called with every event published or announced from now on.
+func (b *Bus) Handle(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Copy the handlers rather than appending in place, as Publish and Announce may be calling
+	// the current slice.
+	b.handlers = append(append([]Handler(nil), b.handlers...), h)
+}
+
+// Subscribe returns a subscription to the events published from now on, together with the
+// events after lastID which are still in the history. A lastID of 0 means the subscriber has
+// seen nothing and wants no backlog. complete is false if some of the events after lastID have
+// already dropped out of the history, or if lastID is ahead of the bus, e.g. because it came
+// from before a restart; the subscriber should then reload its state instead of relying on the
+// backlog. If the bus is closed the returned subscription's C is already closed.
+func (b *Bus) Subscribe(lastID int64) (sub *Subscription, backlog []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan Event, b.bufferSize)
+	sub = &Subscription{C: c, Start: b.lastID, c: c, bus: b}
+
+	if b.closed {
+		sub.once.Do(func() { close(c) })
+		return sub, nil, false
+	}
+
+	b.subs[sub] = struct{}{}
+
+	if lastID <= 0 {
+		return sub, nil, true
+	}
+	if lastID > b.lastID {
+		return sub, nil, false
+	}
+
+	complete = true
+	if len(b.history) == 0 || b.history[0].ID > lastID+1 {
+		complete = lastID == b.lastID
+	}
+
+	for _, event := range b.history {
+		if event.ID > lastID {
+			backlog = append(backlog, event)
+		}
+	}
+
+	return sub, backlog, complete
+}
+
+// Close cancels every subscription. Events published afterwards are still recorded in the
+// history, but subscribing returns an already closed subscription.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		b.remove(s)
+	}
+}
+
+// remove cancels a subscription. The caller must hold b.mu.
+func (b *Bus) remove(s *Subscription) {
+	delete(b.subs, s)
+	s.once.Do(func() { close(s.c) })
+}
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBusPublishSubscribe(t *testing.T) {
+	bus := NewBus(10, 10)
+
+	sub, backlog, complete := bus.Subscribe(0)
+	defer sub.Cancel()
+
+	if len(backlog) != 0 || !complete {
+		t.Fatalf("new subscription: got backlog %v, complete %v", backlog, complete)
+	}
+
+	published := bus.Publish("movie.created", json.RawMessage(`{"id":1}`))
+
+	got := <-sub.C
+	if got.ID != 1 || got.ID != published.ID || got.Type != "movie.created" || string(got.Data) != `{"id":1}` {
+		t.Errorf("got %+v; want %+v", got, published)
+	}
+
+	sub.Cancel()
+	sub.Cancel()
+
+	if _, ok := <-sub.C; ok {
+		t.Error("channel is still open after Cancel")
+	}
+}
+
+func TestBusResume(t *testing.T) {
+	bus := NewBus(3, 10)
+
+	for i := 0; i < 5; i++ {
+		bus.Publish("movie.updated", json.RawMessage(`{}`))
+	}
+
+	tests := []struct {
+		name     string
+		lastID   int64
+		wantIDs  []int64
+		complete bool
+	}{
+		{"no last id", 0, nil, true},
+		{"in history", 3, []int64{4, 5}, true},
+		{"just before history", 2, []int64{3, 4, 5}, true},
+		{"up to date", 5, nil, true},
+		{"dropped out of history", 1, []int64{3, 4, 5}, false},
+		{"ahead of the bus", 9, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, backlog, complete := bus.Subscribe(tt.lastID)
+			defer sub.Cancel()
+
+			var ids []int64
+			for _, event := range backlog {
+				ids = append(ids, event.ID)
+			}
+
+			if complete != tt.complete || len(ids) != len(tt.wantIDs) {
+				t.Fatalf("got %v, complete %v; want %v, complete %v", ids, complete, tt.wantIDs, tt.complete)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Fatalf("got %v; want %v", ids, tt.wantIDs)
+				}
+			}
+
+			if sub.Start != 5 {
+				t.Errorf("got start %d; want 5", sub.Start)
+			}
+		})
+	}
+}
+
+func TestBusDropsSlowSubscribers(t *testing.T) {
+	bus := NewBus(0, 1)
+
+	slow, _, _ := bus.Subscribe(0)
+
+	bus.Publish("movie.created", nil)
+	bus.Publish("movie.created", nil)
+
+	if event, ok := <-slow.C; !ok || event.ID != 1 {
+		t.Fatalf("got %+v, %v; want the first event", event, ok)
+	}
+	if _, ok := <-slow.C; ok {
+		t.Error("slow subscriber was not dropped")
+	}
+
+	slow.Cancel()
+}
+
+func TestBusClose(t *testing.T) {
+	bus := NewBus(10, 10)
+
+	sub, _, _ := bus.Subscribe(0)
+	bus.Close()
+
+	if _, ok := <-sub.C; ok {
+		t.Error("channel is still open after Close")
+	}
+
+	late, _, complete := bus.Subscribe(0)
+	if _, ok := <-late.C; ok || complete {
+		t.Error("subscribing to a closed bus returned an open subscription")
+	}
+	late.Cancel()
+}
+
+func TestBusEpoch(t *testing.T) {
+	a, b := NewBus(0, 0), NewBus(0, 0)
+
+	if len(a.Epoch()) != 16 || a.Epoch() == b.Epoch() {
+		t.Errorf("got epochs %q and %q; want two different 16 character epochs", a.Epoch(), b.Epoch())
+	}
+}
+
+func TestBusHandlers(t *testing.T) {
+	bus := NewBus(10, 1)
+
+	var seen []Event
+	bus.Handle(func(e Event) { seen = append(seen, e) })
+
+	// A subscriber which never reads is dropped, but handlers see every event.
+	sub, _, _ := bus.Subscribe(0)
+	defer sub.Cancel()
+
+	for i := 0; i < 3; i++ {
+		bus.Publish("movie.updated", json.RawMessage(`{}`))
+	}
+	bus.Announce("movie.deleted", json.RawMessage(`{}`))
+
+	if len(seen) != 4 {
+		t.Fatalf("got %d events handled; want 4", len(seen))
+	}
+	if seen[2].ID != 3 || seen[3].ID != 0 || seen[3].Type != "movie.deleted" {
+		t.Errorf("got %+v; want the published events with their ids, and the announced one without", seen)
+	}
+
+	if _, backlog, _ := bus.Subscribe(3); len(backlog) != 0 {
+		t.Errorf("got backlog %v; want announced events left out of the history", backlog)
+	}
+}
@@ -0,0 +1,291 @@
+// Package fixtures loads declarative seed data for tests. A fixture file is a JSON document
+// describing users (with their permissions), tokens and movies, which can be loaded into the
+// real database models for integration tests or into the mock models for unit tests:
+//
+//	{
+//		"users": [{"ref": "alice", "name": "Alice", "email": "alice@example.com",
+//			"password": "pa55word", "activated": true, "permissions": ["movies:read"]}],
+//		"tokens": [{"ref": "alice-auth", "user": "alice", "scope": "authentication",
+//			"token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ", "ttl": "24h"}],
+//		"movies": [{"ref": "casablanca", "title": "Casablanca", "year": 1942,
+//			"runtime": "102 mins", "genres": ["drama", "romance"]}]
+//	}
+//
+// Records refer to each other by their "ref", and every reference is checked before anything
+// is loaded.
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+	"github.com/saalikmubeen/greenlight/internal/validator"
+)
+
+// File is a parsed fixture file.
+type File struct {
+	Users  []User  `json:"users"`
+	Tokens []Token `json:"tokens"`
+	Movies []Movie `json:"movies"`
+}
+
+// User describes a user and the permissions granted to them.
+type User struct {
+	Ref         string   `json:"ref"`
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	Password    string   `json:"password"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+}
+
+// Token describes a token of the user with the ref User. TTL is a time.Duration string and may
+// be negative to create an expired token.
+type Token struct {
+	Ref   string `json:"ref"`
+	User  string `json:"user"`
+	Scope string `json:"scope"`
+	Token string `json:"token"`
+	TTL   string `json:"ttl"`
+}
+
+// Movie describes a movie. Runtime and Certification use the same formats as the API.
+type Movie struct {
+	Ref           string             `json:"ref"`
+	Title         string             `json:"title"`
+	Year          int32              `json:"year"`
+	Runtime       data.Runtime       `json:"runtime"`
+	Genres        []string           `json:"genres"`
+	Certification data.Certification `json:"certification"`
+}
+
+// Loaded holds the records created by Load, keyed by their ref.
+type Loaded struct {
+	Users  map[string]*data.User
+	Tokens map[string]*data.Token
+	Movies map[string]*data.Movie
+}
+
+// ValidationError is returned when a fixture file is invalid. Errors maps a path such as
+// "users[alice].email" to a message, in the same way as validator.Validator.
+type ValidationError struct {
+	Errors map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("fixtures: invalid file:")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\n\t%s: %s", key, e.Errors[key])
+	}
+	return b.String()
+}
+
+var permissionRX = regexp.MustCompile(`^[a-z-]+:[a-z-]+$`)
+
+var tokenScopes = []string{data.ScopeActivation, data.ScopeAuthentication, data.ScopePasswordReset}
+
+// Parse reads and validates a fixture file. Unknown fields are an error, so that a typo doesn't
+// silently leave a field at its zero value.
+func Parse(r io.Reader) (*File, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var f File
+	err := dec.Decode(&f)
+	if err != nil {
+		return nil, fmt.Errorf("fixtures: %w", err)
+	}
+
+	err = f.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// ParseFile reads and validates the fixture file at path.
+func ParseFile(path string) (*File, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(bytes.NewReader(b))
+}
+
+// Validate checks every record with the same validation as the API, and checks that refs are
+// unique and that every reference points at a record in the file.
+func (f *File) Validate() error {
+	v := validator.New()
+
+	users := make(map[string]bool)
+	emails := make(map[string]bool)
+
+	for i, u := range f.Users {
+		key := recordKey("users", i, u.Ref)
+		checkRef(v, key, u.Ref, users)
+
+		uv := validator.New()
+		data.ValidateEmail(uv, u.Email)
+		data.ValidatePasswordPlaintext(uv, u.Password)
+		uv.Check(u.Name != "", "name", "must be provided")
+		for _, code := range u.Permissions {
+			uv.Check(permissionRX.MatchString(code), "permissions", fmt.Sprintf("%q is not a permission code", code))
+		}
+		addErrors(v, key, uv)
+
+		email := data.NormalizeEmail(u.Email)
+		v.Check(!emails[email], key+".email", "duplicate email address")
+		emails[email] = true
+	}
+
+	tokens := make(map[string]bool)
+
+	for i, t := range f.Tokens {
+		key := recordKey("tokens", i, t.Ref)
+		checkRef(v, key, t.Ref, tokens)
+
+		tv := validator.New()
+		data.ValidateTokenPlaintext(tv, t.Token)
+		tv.Check(users[t.User], "user", fmt.Sprintf("no user with ref %q", t.User))
+		tv.Check(validator.In(t.Scope, tokenScopes...), "scope", "must be activation, authentication or password-reset")
+		_, err := time.ParseDuration(t.TTL)
+		tv.Check(err == nil, "ttl", "must be a duration such as 24h")
+		addErrors(v, key, tv)
+	}
+
+	movies := make(map[string]bool)
+
+	for i, m := range f.Movies {
+		key := recordKey("movies", i, m.Ref)
+		checkRef(v, key, m.Ref, movies)
+
+		mv := validator.New()
+		data.ValidateMovie(mv, m.movie())
+		addErrors(v, key, mv)
+	}
+
+	if !v.Valid() {
+		return &ValidationError{Errors: v.Errors}
+	}
+
+	return nil
+}
+
+// Load inserts every record of the file using models, which can be the real models (see
+// data.Models.Interfaces) or the mock models from data.NewMockModels. The file is validated
+// first, so nothing is inserted for an invalid file.
+func (f *File) Load(models data.Models2) (*Loaded, error) {
+	err := f.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := &Loaded{
+		Users:  make(map[string]*data.User),
+		Tokens: make(map[string]*data.Token),
+		Movies: make(map[string]*data.Movie),
+	}
+
+	for _, u := range f.Users {
+		user := &data.User{Name: u.Name, Email: u.Email, Activated: u.Activated}
+
+		err := user.Password.Set(u.Password)
+		if err != nil {
+			return nil, err
+		}
+
+		err = models.Users.Insert(user)
+		if err != nil {
+			return nil, fmt.Errorf("fixtures: inserting user %q: %w", u.Ref, err)
+		}
+
+		if len(u.Permissions) > 0 {
+			err = models.Permissions.AddForUser(user.ID, u.Permissions...)
+			if err != nil {
+				return nil, fmt.Errorf("fixtures: adding permissions of user %q: %w", u.Ref, err)
+			}
+		}
+
+		loaded.Users[u.Ref] = user
+	}
+
+	for _, t := range f.Tokens {
+		// The TTL has been checked by Validate.
+		ttl, _ := time.ParseDuration(t.TTL)
+		token := &data.Token{
+			Plaintext: t.Token,
+			Hash:      data.HashToken(t.Token),
+			UserID:    loaded.Users[t.User].ID,
+			Expiry:    time.Now().Add(ttl),
+			Scope:     t.Scope,
+		}
+
+		err := models.Tokens.Insert(token)
+		if err != nil {
+			return nil, fmt.Errorf("fixtures: inserting token %q: %w", t.Ref, err)
+		}
+
+		loaded.Tokens[t.Ref] = token
+	}
+
+	for _, m := range f.Movies {
+		movie := m.movie()
+
+		err := models.Movies.Insert(movie)
+		if err != nil {
+			return nil, fmt.Errorf("fixtures: inserting movie %q: %w", m.Ref, err)
+		}
+
+		loaded.Movies[m.Ref] = movie
+	}
+
+	return loaded, nil
+}
+
+func (m Movie) movie() *data.Movie {
+	return &data.Movie{
+		Title:         m.Title,
+		Year:          m.Year,
+		Runtime:       m.Runtime,
+		Genres:        m.Genres,
+		Certification: m.Certification,
+	}
+}
+
+// recordKey names a record in validation errors by its ref, or by its index if it has none.
+func recordKey(kind string, i int, ref string) string {
+	if ref == "" {
+		return fmt.Sprintf("%s[%d]", kind, i)
+	}
+	return fmt.Sprintf("%s[%s]", kind, ref)
+}
+
+func checkRef(v *validator.Validator, key, ref string, seen map[string]bool) {
+	v.Check(ref != "", key+".ref", "must be provided")
+	v.Check(!seen[ref], key+".ref", "duplicate ref")
+	seen[ref] = true
+}
+
+// addErrors copies the errors of a record's validator into v, prefixed with the record's key.
+func addErrors(v *validator.Validator, key string, record *validator.Validator) {
+	for field, message := range record.Errors {
+		v.AddError(key+"."+field, message)
+	}
+}
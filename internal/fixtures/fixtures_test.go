@@ -0,0 +1,90 @@
+package fixtures
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestLoadIntoMockModels(t *testing.T) {
+	f, err := ParseFile("testdata/basic.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := data.NewMockModels()
+
+	loaded, err := f.Load(models)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err := models.Users.GetByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.ID != loaded.Users["alice"].ID || !alice.Activated {
+		t.Errorf("GetByEmail(alice) = %+v; want the activated fixture user", alice)
+	}
+
+	permissions, err := models.Permissions.GetAllForUser(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (data.Permissions{"movies:read", "movies:write"}); !reflect.DeepEqual(permissions, want) {
+		t.Errorf("permissions = %v; want %v", permissions, want)
+	}
+
+	if token := loaded.Tokens["alice-auth"]; token.UserID != alice.ID || len(token.Hash) != 32 {
+		t.Errorf("alice-auth token = %+v", token)
+	}
+
+	movie, err := models.Movies.Get(loaded.Movies["casablanca"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.Title != "Casablanca" || movie.Runtime != 102 || movie.Certification != "US:PG" {
+		t.Errorf("casablanca = %+v", movie)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := `{
+		"users": [
+			{"ref": "alice", "name": "Alice", "email": "alice@example.com", "password": "pa55word"},
+			{"ref": "alice", "name": "Alice 2", "email": "ALICE@example.com", "password": "pa55word",
+				"permissions": ["everything"]}
+		],
+		"tokens": [
+			{"ref": "t", "user": "carol", "scope": "login", "token": "short", "ttl": "1 day"}
+		],
+		"movies": [
+			{"title": "", "year": 1942, "runtime": "102 mins", "genres": ["drama"]}
+		]
+	}`
+
+	_, err := Parse(strings.NewReader(invalid))
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Parse() error = %v; want a *ValidationError", err)
+	}
+
+	for _, key := range []string{
+		"users[alice].ref", "users[alice].email", "users[alice].permissions",
+		"tokens[t].user", "tokens[t].scope", "tokens[t].token", "tokens[t].ttl",
+		"movies[0].ref", "movies[0].title",
+	} {
+		if _, ok := verr.Errors[key]; !ok {
+			t.Errorf("missing validation error for %s, got %v", key, verr.Errors)
+		}
+	}
+
+	_, err = Parse(strings.NewReader(`{"users": [{"ref": "a", "nmae": "typo"}]}`))
+	if err == nil || errors.As(err, &verr) {
+		t.Errorf("Parse() with an unknown field error = %v; want a decoding error", err)
+	}
+}
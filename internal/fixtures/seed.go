@@ -0,0 +1,173 @@
+package fixtures
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+// SeedPassword is the password of the users created by Generate.
+const SeedPassword = "pa55word"
+
+// Seed users, with the permissions they're created with:
+//
+//	admin@example.com   every permission
+//	editor@example.com  movies:read, movies:write
+//	viewer@example.com  movies:read
+var seedUsers = []User{
+	{Ref: "admin", Name: "Admin", Email: "admin@example.com", Permissions: []string{
+		"movies:read", "movies:write", "comments:moderate", "users:view-as", "admin:read", "webhooks:write",
+	}},
+	{Ref: "editor", Name: "Editor", Email: "editor@example.com", Permissions: []string{"movies:read", "movies:write"}},
+	{Ref: "viewer", Name: "Viewer", Email: "viewer@example.com", Permissions: []string{"movies:read"}},
+}
+
+var (
+	seedAdjectives = []string{
+		"Silent", "Crimson", "Forgotten", "Golden", "Broken", "Hidden", "Last", "Midnight", "Electric",
+		"Distant", "Frozen", "Wild", "Iron", "Hollow", "Burning", "Endless", "Secret", "Velvet",
+		"Savage", "Lonely",
+	}
+	seedNouns = []string{
+		"Harbor", "Empire", "Garden", "Horizon", "Station", "Kingdom", "River", "Detective",
+		"Orchard", "Machine", "Frontier", "Symphony", "Lighthouse", "Circus", "Voyage", "Witness",
+		"Mountain", "Carnival", "Signal", "Dynasty",
+	}
+	seedGenres = []string{
+		"action", "adventure", "animation", "comedy", "crime", "documentary", "drama", "family",
+		"fantasy", "history", "horror", "musical", "mystery", "romance", "sci-fi", "thriller",
+		"war", "western",
+	}
+)
+
+// Generate returns a fixture file of development data: the seed users, all activated and with
+// the password SeedPassword, and n movies with made-up titles and a spread of years, runtimes,
+// genres and certifications. It always returns the same movies for the same n, and the first n
+// movies of a larger n, so that seeding again adds nothing new.
+func Generate(n int) *File {
+	f := &File{}
+
+	for _, u := range seedUsers {
+		u.Password = SeedPassword
+		u.Activated = true
+		f.Users = append(f.Users, u)
+	}
+
+	countries := make([]string, 0, len(data.Certifications))
+	for country := range data.Certifications {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+
+	pairs := len(seedAdjectives) * len(seedNouns)
+
+	for i := 0; i < n; i++ {
+		// Seeding each movie's generator with its index keeps movie i the same whatever n is.
+		rnd := rand.New(rand.NewSource(int64(i)))
+
+		title := fmt.Sprintf("The %s %s", seedAdjectives[i%len(seedAdjectives)], seedNouns[(i/len(seedAdjectives))%len(seedNouns)])
+		if part := i / pairs; part > 0 {
+			title = fmt.Sprintf("%s %d", title, part+1)
+		}
+
+		genres := make([]string, 0, 3)
+		for _, g := range rnd.Perm(len(seedGenres))[:1+rnd.Intn(3)] {
+			genres = append(genres, seedGenres[g])
+		}
+
+		movie := Movie{
+			Ref:     fmt.Sprintf("movie-%d", i+1),
+			Title:   title,
+			Year:    int32(1930 + rnd.Intn(91)),
+			Runtime: data.Runtime(80 + rnd.Intn(100)),
+			Genres:  genres,
+		}
+
+		// Leave every fourth movie without a certification.
+		if rnd.Intn(4) > 0 {
+			country := countries[rnd.Intn(len(countries))]
+			ratings := data.Certifications[country]
+			movie.Certification = data.Certification(country + ":" + ratings[rnd.Intn(len(ratings))])
+		}
+
+		f.Movies = append(f.Movies, movie)
+	}
+
+	return f
+}
+
+// Seed loads the file like Load, except that it skips the users whose email address is already
+// registered, along with their permissions and tokens, and the movies with the same title and
+// year as one which already exists. Loading the same file again therefore adds nothing. The
+// Loaded records include the existing ones which were skipped, and created counts the records
+// which were inserted.
+func (f *File) Seed(models data.Models2) (loaded *Loaded, created int, err error) {
+	err = f.Validate()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// missing holds the records which don't exist yet.
+	var missing File
+
+	loaded = &Loaded{
+		Users:  make(map[string]*data.User),
+		Tokens: make(map[string]*data.Token),
+		Movies: make(map[string]*data.Movie),
+	}
+	skippedUsers := make(map[string]bool)
+
+	for _, u := range f.Users {
+		user, err := models.Users.GetByEmail(u.Email)
+		switch {
+		case err == nil:
+			loaded.Users[u.Ref] = user
+			skippedUsers[u.Ref] = true
+		case errors.Is(err, data.ErrRecordNotFound):
+			missing.Users = append(missing.Users, u)
+		default:
+			return nil, 0, fmt.Errorf("fixtures: looking up user %q: %w", u.Ref, err)
+		}
+	}
+
+	for _, m := range f.Movies {
+		movie, err := models.Movies.GetByTitle(m.Title, m.Year)
+		switch {
+		case err == nil:
+			loaded.Movies[m.Ref] = movie
+		case errors.Is(err, data.ErrRecordNotFound):
+			missing.Movies = append(missing.Movies, m)
+		default:
+			return nil, 0, fmt.Errorf("fixtures: looking up movie %q: %w", m.Ref, err)
+		}
+	}
+
+	// Tokens are only created for the users being created, since there is no telling whether
+	// an existing user's tokens are still there.
+	for _, t := range f.Tokens {
+		if !skippedUsers[t.User] {
+			missing.Tokens = append(missing.Tokens, t)
+		}
+	}
+
+	inserted, err := missing.Load(models)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	created = len(inserted.Users) + len(inserted.Tokens) + len(inserted.Movies)
+	for ref, user := range inserted.Users {
+		loaded.Users[ref] = user
+	}
+	for ref, token := range inserted.Tokens {
+		loaded.Tokens[ref] = token
+	}
+	for ref, movie := range inserted.Movies {
+		loaded.Movies[ref] = movie
+	}
+
+	return loaded, created, nil
+}
@@ -0,0 +1,58 @@
+package fixtures
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/saalikmubeen/greenlight/internal/data"
+)
+
+func TestGenerate(t *testing.T) {
+	f := Generate(450)
+	if err := f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := make(map[string]bool)
+	for _, m := range f.Movies {
+		if titles[m.Title] {
+			t.Fatalf("duplicate title %q", m.Title)
+		}
+		titles[m.Title] = true
+	}
+
+	if small := Generate(10); !reflect.DeepEqual(small.Movies, f.Movies[:10]) {
+		t.Error("want the movies of a smaller seed to be the first movies of a larger one")
+	}
+}
+
+func TestSeedIsIdempotent(t *testing.T) {
+	models := data.NewMockModels()
+
+	_, created, err := Generate(5).Seed(models)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(seedUsers) + 5; created != want {
+		t.Errorf("got %d records created by the first seed; want %d", created, want)
+	}
+
+	loaded, created, err := Generate(8).Seed(models)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 3 {
+		t.Errorf("got %d records created by the second seed; want the 3 new movies", created)
+	}
+	if len(loaded.Users) != len(seedUsers) || len(loaded.Movies) != 8 {
+		t.Errorf("got %d users and %d movies loaded; want every record, new or not", len(loaded.Users), len(loaded.Movies))
+	}
+
+	admin, err := models.Users.GetByEmail("admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := admin.Password.Matches(SeedPassword); !ok || !admin.Activated {
+		t.Errorf("want the admin activated with password %q", SeedPassword)
+	}
+}
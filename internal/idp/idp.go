@@ -0,0 +1,200 @@
+// Package idp reads users and their group memberships from an external identity provider, so
+// that accounts and permissions can be kept in sync with it.
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User is a user as known to the identity provider.
+type User struct {
+	// ExternalID is the identity provider's stable ID for the user. Email addresses can
+	// change, so accounts are linked to users by this ID.
+	ExternalID string
+	Email      string
+	Name       string
+	// Active is false for users who have been suspended or deprovisioned.
+	Active bool
+	// Groups are the display names of the groups the user is a member of.
+	Groups []string
+}
+
+// Source is a directory of users, such as a SCIM endpoint.
+type Source interface {
+	// Name identifies the source, e.g. "scim". It is stored with each linked account.
+	Name() string
+	// Users returns every user in the directory.
+	Users(ctx context.Context) ([]User, error)
+}
+
+// SCIMSource reads users from a SCIM 2.0 service provider (RFC 7644), authenticating with a
+// bearer token. Group memberships are read from the "groups" attribute of each user.
+type SCIMSource struct {
+	baseURL  string
+	token    string
+	client   *http.Client
+	pageSize int
+}
+
+// NewSCIMSource returns a SCIMSource for the SCIM base URL, e.g. "https://idp.example.com/scim/v2".
+func NewSCIMSource(baseURL, token string) *SCIMSource {
+	return &SCIMSource{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		pageSize: 100,
+	}
+}
+
+// Name implements Source.
+func (s *SCIMSource) Name() string {
+	return "scim"
+}
+
+// scimListResponse is a page of a SCIM list response.
+type scimListResponse struct {
+	TotalResults int            `json:"totalResults"`
+	StartIndex   int            `json:"startIndex"`
+	ItemsPerPage int            `json:"itemsPerPage"`
+	Resources    []scimUserJSON `json:"Resources"`
+}
+
+type scimUserJSON struct {
+	ID          string `json:"id"`
+	UserName    string `json:"userName"`
+	DisplayName string `json:"displayName"`
+	Name        struct {
+		Formatted string `json:"formatted"`
+	} `json:"name"`
+	Emails []struct {
+		Value   string `json:"value"`
+		Primary bool   `json:"primary"`
+	} `json:"emails"`
+	// Active is a pointer because SCIM treats a missing "active" attribute as active.
+	Active *bool `json:"active"`
+	Groups []struct {
+		Value   string `json:"value"`
+		Display string `json:"display"`
+	} `json:"groups"`
+}
+
+// Users implements Source. It follows the SCIM pagination until every user has been read.
+func (s *SCIMSource) Users(ctx context.Context) ([]User, error) {
+	var users []User
+
+	for startIndex := 1; ; {
+		page, err := s.fetchUsers(ctx, startIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resource := range page.Resources {
+			users = append(users, resource.toUser())
+		}
+
+		startIndex += len(page.Resources)
+		if len(page.Resources) == 0 || startIndex > page.TotalResults {
+			return users, nil
+		}
+	}
+}
+
+func (s *SCIMSource) fetchUsers(ctx context.Context, startIndex int) (*scimListResponse, error) {
+	query := url.Values{}
+	query.Set("startIndex", strconv.Itoa(startIndex))
+	query.Set("count", strconv.Itoa(s.pageSize))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/Users?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("Accept", "application/scim+json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scim: GET /Users returned %s", resp.Status)
+	}
+
+	var page scimListResponse
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	if err != nil {
+		return nil, fmt.Errorf("scim: decoding /Users response: %w", err)
+	}
+
+	return &page, nil
+}
+
+func (u scimUserJSON) toUser() User {
+	user := User{
+		ExternalID: u.ID,
+		Name:       u.DisplayName,
+		Active:     u.Active == nil || *u.Active,
+	}
+
+	if user.Name == "" {
+		user.Name = u.Name.Formatted
+	}
+	if user.Name == "" {
+		user.Name = u.UserName
+	}
+
+	for _, email := range u.Emails {
+		if email.Primary || user.Email == "" {
+			user.Email = email.Value
+		}
+	}
+	// Most identity providers use the email address as the userName.
+	if user.Email == "" && strings.Contains(u.UserName, "@") {
+		user.Email = u.UserName
+	}
+
+	for _, group := range u.Groups {
+		name := group.Display
+		if name == "" {
+			name = group.Value
+		}
+		user.Groups = append(user.Groups, name)
+	}
+
+	return user
+}
+
+// ErrInvalidGroupMapping is returned by ParseGroupPermissions for a malformed mapping.
+var ErrInvalidGroupMapping = errors.New("invalid group mapping, must be group=permission[,permission...]")
+
+// ParseGroupPermissions parses a space-separated list of group=permission[,permission...]
+// pairs, e.g. "editors=movies:read,movies:write ops=admin:read", into a map from group name
+// to permission codes.
+func ParseGroupPermissions(s string) (map[string][]string, error) {
+	mapping := make(map[string][]string)
+
+	for _, pair := range strings.Fields(s) {
+		group, codes, ok := strings.Cut(pair, "=")
+		if !ok || group == "" || codes == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidGroupMapping, pair)
+		}
+
+		for _, code := range strings.Split(codes, ",") {
+			if code == "" {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidGroupMapping, pair)
+			}
+			mapping[group] = append(mapping[group], code)
+		}
+	}
+
+	return mapping, nil
+}
@@ -0,0 +1,87 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSCIMSourceUsers(t *testing.T) {
+	resources := []map[string]interface{}{
+		{"id": "1", "userName": "alice@example.com", "displayName": "Alice",
+			"groups": []map[string]string{{"value": "g1", "display": "editors"}}},
+		{"id": "2", "userName": "bob", "name": map[string]string{"formatted": "Bob B"},
+			"emails": []map[string]interface{}{{"value": "b@old.example.com"}, {"value": "bob@example.com", "primary": true}},
+			"active": false},
+		{"id": "3", "userName": "carol@example.com"},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		start, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+		end := start - 1 + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"totalResults": len(resources),
+			"startIndex":   start,
+			"itemsPerPage": count,
+			"Resources":    resources[start-1 : end],
+		})
+	}))
+	defer srv.Close()
+
+	source := NewSCIMSource(srv.URL+"/", "s3cr3t")
+	source.pageSize = 2
+
+	users, err := source.Users(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []User{
+		{ExternalID: "1", Email: "alice@example.com", Name: "Alice", Active: true, Groups: []string{"editors"}},
+		{ExternalID: "2", Email: "bob@example.com", Name: "Bob B", Active: false},
+		{ExternalID: "3", Email: "carol@example.com", Name: "carol@example.com", Active: true},
+	}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("Users() = %+v; want %+v", users, want)
+	}
+
+	_, err = NewSCIMSource(srv.URL, "wrong").Users(context.Background())
+	if err == nil {
+		t.Error("Users() with a wrong token succeeded; want error")
+	}
+}
+
+func TestParseGroupPermissions(t *testing.T) {
+	got, err := ParseGroupPermissions("editors=movies:read,movies:write  ops=admin:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"editors": {"movies:read", "movies:write"},
+		"ops":     {"admin:read"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseGroupPermissions() = %v; want %v", got, want)
+	}
+
+	for _, invalid := range []string{"editors", "=movies:read", "editors=", "editors=movies:read,"} {
+		if _, err := ParseGroupPermissions(invalid); err == nil {
+			t.Errorf("ParseGroupPermissions(%q) succeeded; want error", invalid)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package jsonlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The exporter sends the entries in batches of up to exportBatchSize, at least every
+// exportInterval while there are some.
+const (
+	exportBatchSize = 100
+	exportInterval  = time.Second
+)
+
+// exportEntry is an entry waiting to be exported.
+type exportEntry struct {
+	level Level
+	time  time.Time
+	entry LogEntry
+}
+
+// ExporterStats counts the entries exported, the entries dropped because the buffer was full
+// and the entries lost because the endpoint couldn't be reached or refused them. LastError is
+// the error of the latest batch which failed, as it can't be logged: the entry would be
+// exported too.
+type ExporterStats struct {
+	Exported  int64  `json:"exported"`
+	Dropped   int64  `json:"dropped"`
+	Failed    int64  `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Exporter forwards the entries of a Logger to a central log store, such as Loki or an
+// OpenTelemetry collector, in the background, so that writing an entry never waits for the
+// network. The entries are buffered, and dropped when the buffer is full or after the store
+// failed to take them, rather than slowing the application down. Create one with
+// NewLokiExporter or NewOTLPExporter and add it with Logger.SetExporter; it is safe for
+// concurrent use.
+type Exporter struct {
+	url    string
+	client *http.Client
+	// encode returns the body of the request exporting a batch.
+	encode func(batch []exportEntry) ([]byte, error)
+
+	entries   chan exportEntry
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+	stopped   atomic.Bool
+	exported  atomic.Int64
+	dropped   atomic.Int64
+	failed    atomic.Int64
+	lastError atomic.Pointer[string]
+}
+
+func newExporter(url string, buffer int, encode func([]exportEntry) ([]byte, error)) *Exporter {
+	e := &Exporter{
+		url:     url,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		encode:  encode,
+		entries: make(chan exportEntry, buffer),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// export queues an entry, or drops it if the buffer is full or the exporter is shut down.
+func (e *Exporter) export(entry exportEntry) {
+	if e.stopped.Load() {
+		e.dropped.Add(1)
+		return
+	}
+
+	select {
+	case e.entries <- entry:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// run sends the queued entries until the exporter is shut down, and then the ones left.
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]exportEntry, 0, exportBatchSize)
+	for {
+		select {
+		case entry := <-e.entries:
+			batch = append(batch, entry)
+			if len(batch) < exportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.stop:
+			// Only run receives from entries, so they don't block while it isn't empty.
+			for len(e.entries) > 0 {
+				batch = append(batch, <-e.entries)
+				if len(batch) == exportBatchSize {
+					e.send(batch)
+					batch = batch[:0]
+				}
+			}
+			e.send(batch)
+			return
+		}
+
+		e.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// send exports a batch, counting its entries as failed if it couldn't be.
+func (e *Exporter) send(batch []exportEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := e.post(batch)
+	if err != nil {
+		e.failed.Add(int64(len(batch)))
+		msg := err.Error()
+		e.lastError.Store(&msg)
+		return
+	}
+	e.exported.Add(int64(len(batch)))
+}
+
+func (e *Exporter) post(batch []exportEntry) error {
+	body, err := e.encode(batch)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("log export: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Stats returns the counts of the entries exported, dropped and failed so far.
+func (e *Exporter) Stats() ExporterStats {
+	stats := ExporterStats{
+		Exported: e.exported.Load(),
+		Dropped:  e.dropped.Load(),
+		Failed:   e.failed.Load(),
+	}
+	if msg := e.lastError.Load(); msg != nil {
+		stats.LastError = *msg
+	}
+	return stats
+}
+
+// Shutdown stops the exporter, and waits until the entries buffered so far are exported or ctx
+// is done. The entries written afterwards are dropped.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() {
+		e.stopped.Store(true)
+		close(e.stop)
+	})
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewLokiExporter returns an Exporter pushing the entries to the Loki push API at url, such as
+// http://localhost:3100/loki/api/v1/push, buffering up to buffer entries. The entries are
+// pushed as JSON lines, in streams labelled with labels and their level.
+func NewLokiExporter(url string, labels map[string]string, buffer int) *Exporter {
+	return newExporter(url, buffer, func(batch []exportEntry) ([]byte, error) {
+		return encodeLoki(labels, batch)
+	})
+}
+
+func encodeLoki(labels map[string]string, batch []exportEntry) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	// Keep one stream per level, in the order they first appear.
+	var streams []*stream
+	byLevel := make(map[Level]*stream)
+	for _, e := range batch {
+		s := byLevel[e.level]
+		if s == nil {
+			s = &stream{Stream: map[string]string{"level": strings.ToLower(e.level.String())}}
+			for k, v := range labels {
+				s.Stream[k] = v
+			}
+			byLevel[e.level] = s
+			streams = append(streams, s)
+		}
+
+		line, err := json.Marshal(e.entry)
+		if err != nil {
+			return nil, err
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), string(line)})
+	}
+
+	return json.Marshal(map[string]interface{}{"streams": streams})
+}
+
+// NewOTLPExporter returns an Exporter sending the entries to the OTLP/HTTP logs endpoint at
+// url, such as http://localhost:4318/v1/logs, with the JSON encoding, buffering up to buffer
+// entries. The resource attributes, such as service.name, describe the application. The
+// properties of the entries become the attributes of the log records, and their stack traces
+// the exception.stacktrace attribute.
+func NewOTLPExporter(url string, resource map[string]string, buffer int) *Exporter {
+	return newExporter(url, buffer, func(batch []exportEntry) ([]byte, error) {
+		return encodeOTLP(resource, batch)
+	})
+}
+
+// otlpSeverity returns the OpenTelemetry severity number of level.
+func otlpSeverity(level Level) int {
+	switch level {
+	case LevelDebug:
+		return 5
+	case LevelWarn:
+		return 13
+	case LevelError:
+		return 17
+	case LevelFatal:
+		return 21
+	default:
+		return 9
+	}
+}
+
+func encodeOTLP(resource map[string]string, batch []exportEntry) ([]byte, error) {
+	type anyValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	type keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	type logRecord struct {
+		TimeUnixNano   string     `json:"timeUnixNano"`
+		SeverityNumber int        `json:"severityNumber"`
+		SeverityText   string     `json:"severityText"`
+		Body           anyValue   `json:"body"`
+		Attributes     []keyValue `json:"attributes,omitempty"`
+	}
+
+	// attributes returns m as attributes sorted by key, so that the requests are reproducible.
+	attributes := func(m map[string]string) []keyValue {
+		var kvs []keyValue
+		for k, v := range m {
+			kvs = append(kvs, keyValue{Key: k, Value: anyValue{StringValue: v}})
+		}
+		sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+		return kvs
+	}
+
+	records := make([]logRecord, 0, len(batch))
+	for _, e := range batch {
+		attrs := attributes(e.entry.Properties)
+		if e.entry.Trace != "" {
+			attrs = append(attrs, keyValue{Key: "exception.stacktrace", Value: anyValue{StringValue: e.entry.Trace}})
+		}
+		records = append(records, logRecord{
+			TimeUnixNano:   strconv.FormatInt(e.time.UnixNano(), 10),
+			SeverityNumber: otlpSeverity(e.level),
+			SeverityText:   e.level.String(),
+			Body:           anyValue{StringValue: e.entry.Message},
+			Attributes:     attrs,
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"resourceLogs": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": attributes(resource)},
+				"scopeLogs": []interface{}{
+					map[string]interface{}{
+						"scope":      map[string]string{"name": "github.com/saalikmubeen/greenlight/internal/jsonlog"},
+						"logRecords": records,
+					},
+				},
+			},
+		},
+	})
+}
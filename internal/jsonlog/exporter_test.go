@@ -0,0 +1,120 @@
+package jsonlog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// collector is a log store receiving the request bodies of an exporter.
+type collector struct {
+	mu     sync.Mutex
+	bodies []string
+	status int
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bodies = append(c.bodies, string(body))
+	if c.status != 0 {
+		http.Error(w, "ingestion limit reached", c.status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestLokiExporter(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	logger := NewLogger(io.Discard, LevelInfo)
+	e := NewLokiExporter(srv.URL, map[string]string{"service": "greenlight"}, 10)
+	logger.SetExporter(e)
+
+	logger.PrintDebug("not exported", nil)
+	logger.PrintInfo("starting server", map[string]string{"smtp_password": "s3cr3t"})
+	logger.PrintError(errors.New("failed"), nil)
+
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats := e.Stats(); stats != (ExporterStats{Exported: 2}) {
+		t.Errorf("got %+v; want 2 entries exported", stats)
+	}
+
+	var push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	if len(c.bodies) != 1 {
+		t.Fatalf("got %d requests; want 1", len(c.bodies))
+	}
+	if err := json.Unmarshal([]byte(c.bodies[0]), &push); err != nil {
+		t.Fatal(err)
+	}
+	if len(push.Streams) != 2 || push.Streams[0].Stream["level"] != "info" || push.Streams[0].Stream["service"] != "greenlight" ||
+		push.Streams[1].Stream["level"] != "error" {
+		t.Fatalf("got %+v; want an info and an error stream", push.Streams)
+	}
+	line := push.Streams[0].Values[0][1]
+	if !strings.Contains(line, `"message":"starting server"`) || strings.Contains(line, "s3cr3t") {
+		t.Errorf("got line %q; want the redacted entry", line)
+	}
+
+	logger.PrintInfo("after shutdown", nil)
+	if stats := e.Stats(); stats.Dropped != 1 {
+		t.Errorf("got %+v; want the entry written after the shutdown dropped", stats)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	c := &collector{status: http.StatusTooManyRequests}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	logger := NewLogger(io.Discard, LevelInfo)
+	e := NewOTLPExporter(srv.URL, map[string]string{"service.name": "greenlight"}, 10)
+	logger.SetExporter(e)
+	logger.PrintWarn("redis unreachable", map[string]string{"service": "redis"})
+
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stats := e.Stats()
+	if stats.Failed != 1 || !strings.Contains(stats.LastError, "429") {
+		t.Errorf("got %+v; want the entry failed with the status", stats)
+	}
+
+	body := c.bodies[0]
+	for _, want := range []string{
+		`"key":"service.name","value":{"stringValue":"greenlight"}`,
+		`"severityNumber":13,"severityText":"WARN","body":{"stringValue":"redis unreachable"}`,
+		`"attributes":[{"key":"service","value":{"stringValue":"redis"}}]`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("got %s; want it to contain %s", body, want)
+		}
+	}
+}
+
+func TestExporterOverflow(t *testing.T) {
+	// Without run receiving the entries, the buffer fills up.
+	e := &Exporter{entries: make(chan exportEntry, 1)}
+	e.export(exportEntry{})
+	e.export(exportEntry{})
+
+	if stats := e.Stats(); stats.Dropped != 1 {
+		t.Errorf("got %+v; want 1 entry dropped", stats)
+	}
+}
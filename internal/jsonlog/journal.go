@@ -0,0 +1,88 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+)
+
+// journalSocket is where journald receives the entries of its native protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// JournalWriter writes log entries to journald with its native protocol, each entry as the
+// MESSAGE field along with the PRIORITY of its level and the SYSLOG_IDENTIFIER, so that
+// journalctl can filter them with -p and -t.
+type JournalWriter struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+// NewJournal connects to the local journald, writing the entries with identifier.
+func NewJournal(identifier string) (*JournalWriter, error) {
+	return dialJournal(journalSocket, identifier)
+}
+
+func dialJournal(socket, identifier string) (*JournalWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournalWriter{conn: conn, identifier: identifier}, nil
+}
+
+// priority returns the syslog priority of level, which journald uses too.
+func priority(level Level) int {
+	switch level {
+	case LevelDebug:
+		return 7
+	case LevelWarn:
+		return 4
+	case LevelError:
+		return 3
+	case LevelFatal:
+		return 2
+	default:
+		return 6
+	}
+}
+
+// Write writes an entry at the INFO priority.
+func (j *JournalWriter) Write(entry []byte) (int, error) {
+	return j.WriteLevel(LevelInfo, entry)
+}
+
+// WriteLevel writes an entry at the priority of level, as syslog does: DEBUG is 7, INFO 6,
+// WARN 4, ERROR 3 and FATAL 2.
+func (j *JournalWriter) WriteLevel(level Level, entry []byte) (int, error) {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "PRIORITY", []byte(strconv.Itoa(priority(level))))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", []byte(j.identifier))
+	writeJournalField(&buf, "MESSAGE", bytes.TrimSuffix(entry, []byte("\n")))
+
+	if _, err := j.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(entry), nil
+}
+
+// writeJournalField writes a field of the native protocol: NAME=value on a line, or, when the
+// value spans lines, the name on its own line followed by the length of the value as a little
+// endian 64 bit integer and the value.
+func writeJournalField(buf *bytes.Buffer, name string, value []byte) {
+	buf.WriteString(name)
+	if bytes.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.Write(value)
+	} else {
+		buf.WriteByte('\n')
+		binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+		buf.Write(value)
+	}
+	buf.WriteByte('\n')
+}
+
+// Close closes the connection to journald.
+func (j *JournalWriter) Close() error {
+	return j.conn.Close()
+}
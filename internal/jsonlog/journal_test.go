@@ -0,0 +1,56 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	w, err := dialJournal(path, "greenlight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	logger := NewLogger(w, LevelInfo)
+	logger.PrintWarn("redis unreachable", map[string]string{"service": "redis"})
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	if !strings.HasPrefix(got, "PRIORITY=4\nSYSLOG_IDENTIFIER=greenlight\nMESSAGE={") || !strings.HasSuffix(got, "}\n") {
+		t.Errorf("got %q; want the entry at priority 4", got)
+	}
+	if !strings.Contains(got, `"message":"redis unreachable"`) {
+		t.Errorf("got %q; want the JSON entry as the message", got)
+	}
+
+	var field bytes.Buffer
+	writeJournalField(&field, "MESSAGE", []byte("two\nlines"))
+	want := []byte("MESSAGE\n")
+	want = binary.LittleEndian.AppendUint64(want, 9)
+	want = append(want, "two\nlines\n"...)
+	if !bytes.Equal(field.Bytes(), want) {
+		t.Errorf("got %q; want %q", field.Bytes(), want)
+	}
+
+	logger.PrintError(errors.New("failed"), nil)
+	if n, err = conn.Read(buf); err != nil || !strings.HasPrefix(string(buf[:n]), "PRIORITY=3\n") {
+		t.Errorf("got %q, %v; want the entry at priority 3", buf[:n], err)
+	}
+}
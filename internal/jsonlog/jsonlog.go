@@ -1,11 +1,15 @@
 package jsonlog
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,25 +17,31 @@ import (
 // https://github.com/rs/zerolog
 
 // Level represents the severity level of a log entry.
-// In this project we will use the following three severity
+// In this project we will use the following five severity
 // levels, ordered from least to most severe:
 type Level int8
 
 // Initialize constants which represent a specific severity level using the "iota" keyword
-// as a shortcut to assign successive integer values to the constants.
-// Could be extended to support additional severity levels such as DEBUG and WARNING.
+// as a shortcut to assign successive integer values to the constants. LevelDebug is below
+// zero so that the zero value of Level is still LevelInfo.
 const (
-	LevelInfo  Level = iota // Has the value of 0.
-	LevelError              // Has the value of 1.
-	LevelFatal              // Has the value of 2.
-	LevelOff                // Has the value of 3.
+	LevelDebug Level = iota - 1 // Has the value of -1.
+	LevelInfo                   // Has the value of 0.
+	LevelWarn                   // Has the value of 1.
+	LevelError                  // Has the value of 2.
+	LevelFatal                  // Has the value of 3.
+	LevelOff                    // Has the value of 4.
 )
 
 // String returns a human-friendly string for the severity level.
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
@@ -41,22 +51,81 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel returns the level named by s, which is "debug", "info", "warn", "error", "fatal"
+// or "off" in any case.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	case "fatal":
+		return LevelFatal, nil
+	case "off":
+		return LevelOff, nil
+	}
+
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
 // Logger is the custom logger. It holds the output destination that the log entries will be
 // written to, the minimum severity level that log entries will be written for, and a mutex
 // for coordination the writes.
 type Logger struct {
 	out      io.Writer // The output destination for the log entries.
-	minLevel Level
+	minLevel int32     // The minimum Level, read and written atomically so it can be changed at any time.
 	mu       sync.Mutex
+	redactor atomic.Pointer[redactor] // Masks the credentials in the entries, see SetRedactedKeys.
+	exporter atomic.Pointer[Exporter] // Forwards the entries to a log store, see SetExporter.
+}
+
+// LevelWriter is an output destination which records the level of the entries, such as the
+// system log, which records it as their priority. The Logger writes to it with WriteLevel,
+// passing each entry without the trailing newline.
+type LevelWriter interface {
+	io.Writer
+	WriteLevel(level Level, entry []byte) (int, error)
 }
 
 // NewLogger returns a new Logger instance which writes log entries at or above a minimum severity
-// level to a specific output destination.
+// level to a specific output destination. It redacts the DefaultRedactedKeys.
 func NewLogger(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
+	l := &Logger{
 		out:      out,
-		minLevel: minLevel,
+		minLevel: int32(minLevel),
 	}
+	l.SetRedactedKeys(DefaultRedactedKeys)
+	return l
+}
+
+// SetLevel changes the minimum severity level of the entries written from now on.
+func (l *Logger) SetLevel(minLevel Level) {
+	atomic.StoreInt32(&l.minLevel, int32(minLevel))
+}
+
+// SetRedactedKeys changes the keys whose values are masked in the entries written from now on.
+// The properties named after a key, or ending with one like "smtp_password", are replaced with
+// Redacted, and so are the values following a key in the messages and the other properties,
+// such as "password=pa55word", along with the passwords in URLs. No keys only leaves the URLs
+// redacted.
+func (l *Logger) SetRedactedKeys(keys []string) {
+	l.redactor.Store(newRedactor(keys))
+}
+
+// SetExporter makes the logger forward the entries written from now on to e as well, or stop
+// forwarding them when e is nil. The entries are forwarded once redacted.
+func (l *Logger) SetExporter(e *Exporter) {
+	l.exporter.Store(e)
+}
+
+// PrintDebug is a helper that writes Debug level log entries, for diagnostics too verbose to
+// be written unless they are asked for with the debug level.
+func (l *Logger) PrintDebug(message string, properties map[string]string) {
+	l.print(LevelDebug, message, properties)
 }
 
 // PrintInfo is a helper that writes Info level log entries.
@@ -64,6 +133,12 @@ func (l *Logger) PrintInfo(message string, properties map[string]string) {
 	l.print(LevelInfo, message, properties)
 }
 
+// PrintWarn is a helper that writes Warn level log entries, for the problems the application
+// works around, such as a backing service being unreachable while there is a fallback.
+func (l *Logger) PrintWarn(message string, properties map[string]string) {
+	l.print(LevelWarn, message, properties)
+}
+
 // PrintError is a helper that writes Error level log entries.
 func (l *Logger) PrintError(err error, properties map[string]string) {
 	l.print(LevelError, err.Error(), properties)
@@ -73,6 +148,13 @@ func (l *Logger) PrintError(err error, properties map[string]string) {
 // It also terminates the application.
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
 	l.print(LevelFatal, err.Error(), properties)
+
+	// Give the exporter a moment to forward the entry, which is likely the most useful one.
+	if e := l.exporter.Load(); e != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		e.Shutdown(ctx)
+		cancel()
+	}
 	os.Exit(1)
 }
 
@@ -80,7 +162,7 @@ type LogEntry struct {
 	// indicating the severity of the log entry
 	Level string `json:"level"`
 	// The UTC time that the log entry was made with second precision.
-	Time string `json:"time"`
+	Time string `json:"time,omitempty"`
 	// A string containing the free-text information or error message.
 	Message string `json:"message"`
 	// Any additional information relevant to the log entry in string key/value pairs (optional).
@@ -89,21 +171,38 @@ type LogEntry struct {
 	Trace string `json:"trace,omitempty"`
 }
 
-// print is an internal method for writing a log entry.
+// enabled reports whether entries at level are written.
+func (l *Logger) enabled(level Level) bool {
+	return int32(level) >= atomic.LoadInt32(&l.minLevel)
+}
+
+// print is an internal method for writing a log entry made now.
 func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+	return l.write(level, time.Now(), message, properties)
+}
+
+// write writes a log entry made at t. The Print helpers and the slog Handler both write their
+// entries with it, so that they share the format, the output and the minimum level.
+func (l *Logger) write(level Level, t time.Time, message string, properties map[string]string) (int, error) {
 	// If the log is not of severe enough level to be logged, then return with no further action.
 	// If the severity level of the log entry is below the minimum severity for the logger
 	// then return with no further action
-	if level < l.minLevel {
+	if !l.enabled(level) {
 		return 0, nil
 	}
 
-	// Declare an anonymous struct holding the data for the log entry.
+	// Declare an anonymous struct holding the data for the log entry. Only the slog records
+	// made without a time have none.
+	// The message and properties are redacted first, so that credentials logged by accident,
+	// such as an error quoting a connection string, don't end up in the output.
+	r := l.redactor.Load()
 	aux := LogEntry{
 		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC3339),
-		Message:    message,
-		Properties: properties,
+		Message:    r.text(message),
+		Properties: r.properties(properties),
+	}
+	if !t.IsZero() {
+		aux.Time = t.UTC().Format(time.RFC3339)
 	}
 
 	// Include a stack trace for entries at the ERROR and FATAL levels.
@@ -111,6 +210,13 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		aux.Trace = string(debug.Stack())
 	}
 
+	if e := l.exporter.Load(); e != nil {
+		if t.IsZero() {
+			t = time.Now()
+		}
+		e.export(exportEntry{level: level, time: t, entry: aux})
+	}
+
 	// Declare a line variable for holding the actual log entry text.
 	var line []byte
 
@@ -128,7 +234,11 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	// Write the log entry followed by a newline.
+	// Write the log entry followed by a newline, or with its level to the outputs which
+	// record it.
+	if lw, ok := l.out.(LevelWriter); ok {
+		return lw.WriteLevel(level, line)
+	}
 	return l.out.Write(append(line, '\n'))
 }
 
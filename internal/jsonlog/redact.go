@@ -0,0 +1,98 @@
+package jsonlog
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the sensitive values in the log entries.
+const Redacted = "REDACTED"
+
+// DefaultRedactedKeys are the keys a new Logger redacts the values of.
+var DefaultRedactedKeys = []string{"password", "token", "authorization", "dsn"}
+
+// credentialsRX matches the password in the user information of a URL, such as a PostgreSQL
+// connection string, which is redacted whatever the keys.
+var credentialsRX = regexp.MustCompile(`(\b[a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]*:)[^@/\s]+@`)
+
+// redactor masks the values of sensitive keys in the properties and the message of the log
+// entries. A property is sensitive when its name is one of the keys or ends with one, such as
+// "smtp_password" or "request.authorization", and a message is redacted where it reads like
+// "password=..." or "Authorization: Bearer ...".
+type redactor struct {
+	keys   []string
+	textRX *regexp.Regexp
+}
+
+// newRedactor returns a redactor for keys, which are compared in any case, with dashes, dots
+// and underscores considered the same.
+func newRedactor(keys []string) *redactor {
+	r := &redactor{}
+	var alternatives []string
+	for _, key := range keys {
+		key = normalizeKey(key)
+		if key == "" {
+			continue
+		}
+		r.keys = append(r.keys, key)
+		alternatives = append(alternatives, strings.ReplaceAll(regexp.QuoteMeta(key), "_", "[-._]"))
+	}
+
+	if len(alternatives) > 0 {
+		r.textRX = regexp.MustCompile(`(?i)(\b(?:[\w.-]*[-._])?(?:` + strings.Join(alternatives, "|") + `)"?\s*[:=]\s*)` +
+			`(?:(?:bearer|basic)\s+)?(?:"[^"]*"|'[^']*'|[^\s,;&"'}]+)`)
+	}
+	return r
+}
+
+// normalizeKey returns key in lower case, with the dashes and dots replaced by underscores.
+func normalizeKey(key string) string {
+	return strings.NewReplacer("-", "_", ".", "_").Replace(strings.ToLower(strings.TrimSpace(key)))
+}
+
+// sensitive reports whether the property name is one of the keys or ends with one.
+func (r *redactor) sensitive(name string) bool {
+	name = normalizeKey(name)
+	for _, key := range r.keys {
+		if name == key || strings.HasSuffix(name, "_"+key) {
+			return true
+		}
+	}
+	return false
+}
+
+// text returns s with the values of the keys and the passwords of URLs masked.
+func (r *redactor) text(s string) string {
+	if r.textRX != nil {
+		s = r.textRX.ReplaceAllString(s, "${1}"+Redacted)
+	}
+	return credentialsRX.ReplaceAllString(s, "${1}"+Redacted+"@")
+}
+
+// properties returns the properties with the values of the sensitive ones masked, and the
+// others redacted like messages. The map passed in is left as is, as it belongs to the caller.
+func (r *redactor) properties(properties map[string]string) map[string]string {
+	var redacted map[string]string
+	for k, v := range properties {
+		masked := r.text(v)
+		if r.sensitive(k) && v != "" {
+			masked = Redacted
+		}
+		if masked == v {
+			continue
+		}
+
+		if redacted == nil {
+			redacted = make(map[string]string, len(properties))
+			for k, v := range properties {
+				redacted[k] = v
+			}
+		}
+		redacted[k] = masked
+	}
+
+	if redacted == nil {
+		return properties
+	}
+	return redacted
+}
@@ -0,0 +1,109 @@
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Handler is a slog.Handler writing the records through a Logger, so that the libraries logging
+// with log/slog, and with the log package once it's set as the default handler, write the same
+// JSON entries as the application, filtered by the same minimum level. Create one with
+// Logger.Handler.
+//
+// The attributes of a record become the properties of the entry, formatted as strings. The
+// attributes in groups are named after the groups, such as "request.method".
+type Handler struct {
+	logger *Logger
+	// attrs are the attributes added with WithAttrs, already flattened, and prefix is the groups
+	// opened with WithGroup, each followed by a dot.
+	attrs  map[string]string
+	prefix string
+}
+
+// Handler returns a slog.Handler writing through l, for use with slog.New.
+func (l *Logger) Handler() *Handler {
+	return &Handler{logger: l}
+}
+
+// level returns the Level of a slog level. The levels between the slog ones count as the one
+// below them, and there is no fatal level in slog.
+func level(l slog.Level) Level {
+	switch {
+	case l < slog.LevelInfo:
+		return LevelDebug
+	case l < slog.LevelWarn:
+		return LevelInfo
+	case l < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+// Enabled reports whether the logger writes entries at level.
+func (h *Handler) Enabled(_ context.Context, l slog.Level) bool {
+	return h.logger.enabled(level(l))
+}
+
+// Handle writes r as a log entry.
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	var properties map[string]string
+	if len(h.attrs) > 0 || r.NumAttrs() > 0 {
+		properties = make(map[string]string, len(h.attrs)+r.NumAttrs())
+		for k, v := range h.attrs {
+			properties[k] = v
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			addAttr(properties, h.prefix, a)
+			return true
+		})
+	}
+
+	_, err := h.logger.write(level(r.Level), r.Time, r.Message, properties)
+	return err
+}
+
+// WithAttrs returns a handler adding attrs to every entry.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = make(map[string]string, len(h.attrs)+len(attrs))
+	for k, v := range h.attrs {
+		h2.attrs[k] = v
+	}
+	for _, a := range attrs {
+		addAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+// WithGroup returns a handler naming the attributes which follow after the group name.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+// addAttr adds a to properties, named after prefix. The attributes of a group are added one by
+// one, and empty attributes are left out, as slog handlers should.
+func addAttr(properties map[string]string, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if a.Key != "" {
+			groupPrefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			addAttr(properties, groupPrefix, ga)
+		}
+		return
+	}
+
+	properties[prefix+a.Key] = a.Value.String()
+}
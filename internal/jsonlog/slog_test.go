@@ -0,0 +1,75 @@
+package jsonlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/slogtest"
+)
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelInfo)
+
+	// slogtest expects the entries as maps with the groups nested, so the properties named
+	// after their groups are nested again.
+	results := func() []map[string]interface{} {
+		var ms []map[string]interface{}
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			var entry LogEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				t.Fatal(err)
+			}
+
+			m := map[string]interface{}{slog.LevelKey: entry.Level, slog.MessageKey: entry.Message}
+			if entry.Time != "" {
+				m[slog.TimeKey] = entry.Time
+			}
+			for k, v := range entry.Properties {
+				names := strings.Split(k, ".")
+				group := m
+				for _, name := range names[:len(names)-1] {
+					sub, ok := group[name].(map[string]interface{})
+					if !ok {
+						sub = map[string]interface{}{}
+						group[name] = sub
+					}
+					group = sub
+				}
+				group[names[len(names)-1]] = v
+			}
+			ms = append(ms, m)
+		}
+		return ms
+	}
+
+	if err := slogtest.TestHandler(logger.Handler(), results); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelWarn)
+	log := slog.New(logger.Handler())
+
+	log.Info("not written")
+	log.Warn("cache unreachable", "attempts", 3)
+	log.Error("query failed", slog.Group("db", "table", "movies"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"level":"WARN"`) || !strings.Contains(lines[0], `"attempts":"3"`) ||
+		!strings.Contains(lines[1], `"level":"ERROR"`) || !strings.Contains(lines[1], `"db.table":"movies"`) ||
+		!strings.Contains(lines[1], `"trace"`) {
+		t.Errorf("got %q", buf.String())
+	}
+
+	// The level set on the logger applies to slog too.
+	logger.SetLevel(LevelDebug)
+	if !log.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("want debug records enabled after SetLevel(LevelDebug)")
+	}
+}
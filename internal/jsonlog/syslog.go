@@ -0,0 +1,58 @@
+//go:build !windows && !plan9
+
+package jsonlog
+
+import "log/syslog"
+
+// SyslogWriter writes log entries to the system log, with the priorities of their levels.
+type SyslogWriter struct {
+	w *syslog.Writer
+}
+
+// NewSyslog connects to the local system log, writing the entries tagged with tag and at the
+// daemon facility.
+func NewSyslog(tag string) (*SyslogWriter, error) {
+	return dialSyslog("", "", tag)
+}
+
+// dialSyslog connects to the system log server at addr on network, the local one when both are
+// empty.
+func dialSyslog(network, addr, tag string) (*SyslogWriter, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogWriter{w: w}, nil
+}
+
+// Write writes an entry at the INFO priority.
+func (s *SyslogWriter) Write(entry []byte) (int, error) {
+	return s.WriteLevel(LevelInfo, entry)
+}
+
+// WriteLevel writes an entry at the priority of level: DEBUG and INFO are written as debug and
+// info, WARN as warning, ERROR as err and FATAL as crit.
+func (s *SyslogWriter) WriteLevel(level Level, entry []byte) (int, error) {
+	var err error
+	switch m := string(entry); level {
+	case LevelDebug:
+		err = s.w.Debug(m)
+	case LevelWarn:
+		err = s.w.Warning(m)
+	case LevelError:
+		err = s.w.Err(m)
+	case LevelFatal:
+		err = s.w.Crit(m)
+	default:
+		err = s.w.Info(m)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(entry), nil
+}
+
+// Close closes the connection to the system log.
+func (s *SyslogWriter) Close() error {
+	return s.w.Close()
+}
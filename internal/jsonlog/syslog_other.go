@@ -0,0 +1,25 @@
+//go:build windows || plan9
+
+package jsonlog
+
+import "errors"
+
+// SyslogWriter writes log entries to the system log, which there is none of on this platform.
+type SyslogWriter struct{}
+
+// NewSyslog returns an error, as there is no system log on this platform.
+func NewSyslog(tag string) (*SyslogWriter, error) {
+	return nil, errors.New("jsonlog: syslog is not supported on this platform")
+}
+
+func (s *SyslogWriter) Write(entry []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (s *SyslogWriter) WriteLevel(level Level, entry []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (s *SyslogWriter) Close() error {
+	return nil
+}
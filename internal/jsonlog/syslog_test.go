@@ -0,0 +1,48 @@
+//go:build !windows && !plan9
+
+package jsonlog
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyslog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	w, err := dialSyslog("unixgram", path, "greenlight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	logger := NewLogger(w, LevelDebug)
+	buf := make([]byte, 4096)
+
+	// The priority is the daemon facility, 3, times 8 plus the severity.
+	for _, tt := range []struct {
+		print func()
+		want  string
+	}{
+		{func() { logger.PrintDebug("debug", nil) }, "<31>"},
+		{func() { logger.PrintInfo("info", nil) }, "<30>"},
+		{func() { logger.PrintWarn("warn", nil) }, "<28>"},
+	} {
+		tt.print()
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(buf[:n])
+		if !strings.HasPrefix(got, tt.want) || !strings.Contains(got, " greenlight[") || !strings.HasSuffix(got, "}\n") {
+			t.Errorf("got %q; want a %s entry", got, tt.want)
+		}
+	}
+}
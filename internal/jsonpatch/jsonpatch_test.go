@@ -0,0 +1,75 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	doc := `{"title":"Moana","genres":["animation","adventure"]}`
+
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+		err   error
+	}{
+		{"add to end", `[{"op":"add","path":"/genres/-","value":"family"}]`,
+			`{"genres":["animation","adventure","family"],"title":"Moana"}`, nil},
+		{"insert", `[{"op":"add","path":"/genres/0","value":"family"}]`,
+			`{"genres":["family","animation","adventure"],"title":"Moana"}`, nil},
+		{"remove", `[{"op":"remove","path":"/genres/1"}]`,
+			`{"genres":["animation"],"title":"Moana"}`, nil},
+		{"replace", `[{"op":"replace","path":"/title","value":"Vaiana"}]`,
+			`{"genres":["animation","adventure"],"title":"Vaiana"}`, nil},
+		{"move", `[{"op":"move","from":"/genres/0","path":"/genres/-"}]`,
+			`{"genres":["adventure","animation"],"title":"Moana"}`, nil},
+		{"copy", `[{"op":"copy","from":"/title","path":"/original_title"}]`,
+			`{"genres":["animation","adventure"],"original_title":"Moana","title":"Moana"}`, nil},
+		{"test passes", `[{"op":"test","path":"/title","value":"Moana"}]`,
+			`{"genres":["animation","adventure"],"title":"Moana"}`, nil},
+		{"test fails", `[{"op":"test","path":"/title","value":"Cars"}]`, "", ErrTestFailed},
+		{"missing path", `[{"op":"remove","path":"/year"}]`, "", ErrPathNotFound},
+		{"index out of range", `[{"op":"replace","path":"/genres/2","value":"x"}]`, "", ErrPathNotFound},
+		{"unknown op", `[{"op":"frobnicate","path":"/title"}]`, "", ErrInvalidPatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []Operation
+			if err := json.Unmarshal([]byte(tt.patch), &ops); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Apply([]byte(doc), ops)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("want error %v; got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("want %s; got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	doc := `{"title":"Moana","year":2016,"genres":["animation"]}`
+	patch := `{"year":null,"genres":["animation","family"]}`
+
+	got, err := MergePatch([]byte(doc), []byte(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"genres":["animation","family"],"title":"Moana"}`
+	if string(got) != want {
+		t.Errorf("want %s; got %s", want, got)
+	}
+}
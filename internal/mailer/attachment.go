@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxAttachmentSize is the largest file which can be attached to an email.
+	MaxAttachmentSize = 5 << 20
+	// MaxAttachmentsSize is the largest total size of the files attached to an email, inline
+	// images included. It leaves room under the 10MB limit of SES for the bodies and the base64
+	// encoding of the files.
+	MaxAttachmentsSize = 7 << 20
+)
+
+// Attachment is a file sent with an email. Inline attachments are images embedded in the HTML
+// body, which refers to them as "cid:" followed by their Filename, such as
+// <img src="cid:logo.png">; the others are listed as attachments by email clients.
+type Attachment struct {
+	Filename string
+	// ContentType is the media type of Data. If it's empty, it is detected from the extension of
+	// Filename, or else from Data itself.
+	ContentType string
+	Data        []byte
+	Inline      bool
+}
+
+// prepareAttachments checks that the attachments have a plain filename and are within the size
+// limits, and sets their missing content types. The errors are permanent, as sending the same
+// attachments again would fail the same way.
+func prepareAttachments(attachments []Attachment) error {
+	total := 0
+	for i := range attachments {
+		a := &attachments[i]
+
+		if a.Filename == "" || a.Filename != filepath.Base(a.Filename) || strings.ContainsAny(a.Filename, `"\/<>`) {
+			return &PermanentError{fmt.Errorf("invalid attachment filename %q", a.Filename)}
+		}
+		if len(a.Data) > MaxAttachmentSize {
+			return &PermanentError{fmt.Errorf("attachment %q is larger than %d bytes", a.Filename, MaxAttachmentSize)}
+		}
+		total += len(a.Data)
+		if total > MaxAttachmentsSize {
+			return &PermanentError{fmt.Errorf("attachments are larger than %d bytes in total", MaxAttachmentsSize)}
+		}
+
+		if a.ContentType == "" {
+			a.ContentType = detectContentType(a.Filename, a.Data)
+		}
+		if a.Inline && !strings.HasPrefix(a.ContentType, "image/") {
+			return &PermanentError{fmt.Errorf("inline attachment %q is a %s, not an image", a.Filename, a.ContentType)}
+		}
+	}
+
+	return nil
+}
+
+// detectContentType returns the media type of a file from the extension of its name, or else
+// by sniffing its content.
+func detectContentType(filename string, data []byte) string {
+	if ct := mime.TypeByExtension(filepath.Ext(filename)); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(data)
+}
+
+// cidRx matches the references to inline images in an HTML body.
+var cidRx = regexp.MustCompile(`cid:([\w-][\w.-]*)`)
+
+// templateImages returns the images in the images directory of templates which htmlBody refers to
+// with "cid:" URLs, as inline attachments, skipping those already in attachments.
+func templateImages(templates fs.FS, htmlBody string, attachments []Attachment) ([]Attachment, error) {
+	seen := make(map[string]bool)
+	for _, a := range attachments {
+		seen[a.Filename] = true
+	}
+
+	var images []Attachment
+	for _, match := range cidRx.FindAllStringSubmatch(htmlBody, -1) {
+		name := match[1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		data, err := fs.ReadFile(templates, path.Join("images", name))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, &PermanentError{fmt.Errorf("inline image %q not found", name)}
+			}
+			return nil, err
+		}
+		images = append(images, Attachment{Filename: name, Data: data, Inline: true})
+	}
+
+	return images, nil
+}
@@ -0,0 +1,162 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrepareAttachments(t *testing.T) {
+	pdf := []byte("%PDF-1.4 ...")
+	attachments := []Attachment{
+		{Filename: "receipt.pdf", Data: pdf},
+		{Filename: "export", Data: []byte("id,title\n1,Moana\n")},
+		{Filename: "logo.png", ContentType: "image/png", Data: []byte{0x89}, Inline: true},
+	}
+	if err := prepareAttachments(attachments); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"application/pdf", "text/plain; charset=utf-8", "image/png"} {
+		if got := attachments[i].ContentType; got != want {
+			t.Errorf("%s: got content type %q; want %q", attachments[i].Filename, got, want)
+		}
+	}
+
+	big := make([]byte, MaxAttachmentSize)
+	for _, tt := range []struct {
+		name        string
+		attachments []Attachment
+	}{
+		{"empty filename", []Attachment{{Data: pdf}}},
+		{"path", []Attachment{{Filename: "../etc/passwd", Data: pdf}}},
+		{"quote", []Attachment{{Filename: `a".pdf`, Data: pdf}}},
+		{"too large", []Attachment{{Filename: "a.bin", Data: append(big, 0)}}},
+		{"too large in total", []Attachment{{Filename: "a.bin", Data: big}, {Filename: "b.bin", Data: big}}},
+		{"inline pdf", []Attachment{{Filename: "receipt.pdf", Data: pdf, Inline: true}}},
+	} {
+		if err := prepareAttachments(tt.attachments); !IsPermanent(err) {
+			t.Errorf("%s: got %v; want a permanent error", tt.name, err)
+		}
+	}
+}
+
+func TestSendAttachments(t *testing.T) {
+	var sent *Message
+	m := New(senderFunc(func(msg *Message) error {
+		sent = msg
+		return nil
+	}), "Greenlight <no-reply@example.com>", DefaultRetryPolicy)
+
+	receipt := Attachment{Filename: "receipt.pdf", Data: []byte("%PDF-1.4 ...")}
+	err := m.Send("alice@example.com", "user_welcome.tmpl", nil, receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sent.Attachments) != 2 {
+		t.Fatalf("got %d attachments; want the receipt and the logo", len(sent.Attachments))
+	}
+	if a := sent.Attachments[0]; a.Filename != "receipt.pdf" || a.ContentType != "application/pdf" || a.Inline {
+		t.Errorf("got %+v; want the receipt attached", a)
+	}
+	if a := sent.Attachments[1]; a.Filename != "logo.png" || a.ContentType != "image/png" || !a.Inline || len(a.Data) == 0 {
+		t.Errorf("got %+v; want the logo embedded from templates/images", a)
+	}
+	if receipt.ContentType != "" {
+		t.Error("want the caller's attachment left unchanged")
+	}
+
+	if _, err := templateImages(embeddedTemplates, `<img src="cid:missing.png">`, nil); !IsPermanent(err) {
+		t.Errorf("got %v; want a permanent error for an image which doesn't exist", err)
+	}
+}
+
+func TestSendersAttachments(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	msg := &Message{
+		From: "Greenlight <no-reply@example.com>", To: "alice@example.com", Subject: "Hi",
+		PlainBody: "plain", HTMLBody: `<img src="cid:logo.png">`,
+		Attachments: []Attachment{
+			{Filename: "logo.png", ContentType: "image/png", Data: []byte("PNG"), Inline: true},
+			{Filename: "receipt.pdf", ContentType: "application/pdf", Data: []byte("PDF")},
+		},
+	}
+
+	var raw bytes.Buffer
+	if _, err := newMIMEMessage(msg).WriteTo(&raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Content-ID: <logo.png>", `Content-Disposition: inline; filename="logo.png"`,
+		`Content-Disposition: attachment; filename="receipt.pdf"`, base64.StdEncoding.EncodeToString([]byte("PDF"))} {
+		if !strings.Contains(raw.String(), want) {
+			t.Errorf("smtp: want %q in the MIME message", want)
+		}
+	}
+
+	sendGrid := NewSendGrid("SG.key")
+	sendGrid.endpoint = ts.URL
+	if err := sendGrid.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Attachments []sendGridAttachment `json:"attachments"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if a := payload.Attachments; len(a) != 2 || a[0].Disposition != "inline" || a[0].ContentID != "logo.png" ||
+		a[1].Disposition != "attachment" || string(a[1].Content) != "PDF" {
+		t.Errorf("sendgrid: got %+v", a)
+	}
+
+	mailgun := NewMailgun(ts.URL, "mg.example.com", "key-1")
+	if err := mailgun.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(got.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Value["to"][0] != "alice@example.com" || len(form.File["inline"]) != 1 || form.File["inline"][0].Filename != "logo.png" ||
+		len(form.File["attachment"]) != 1 || form.File["attachment"][0].Header.Get("Content-Type") != "application/pdf" {
+		t.Errorf("mailgun: got %+v %+v", form.Value, form.File)
+	}
+
+	ses := NewSES("eu-west-1", "AKID", "secret")
+	ses.endpoint = ts.URL
+	if err := ses.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	var email struct {
+		Content struct {
+			Raw struct {
+				Data []byte
+			}
+		}
+	}
+	if err := json.Unmarshal(body, &email); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(email.Content.Raw.Data), "Content-ID: <logo.png>") {
+		t.Errorf("ses: got %s; want a raw MIME message", body)
+	}
+}
@@ -0,0 +1,167 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dkimHeaders are the header fields signed, when the message has them. From is always there.
+var dkimHeaders = []string{"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"}
+
+// DKIMSigner signs emails with DKIM (RFC 6376), so that the receiving servers can check that
+// they come from domain, whose DNS has the public key in a TXT record at
+// <selector>._domainkey.<domain>. The signatures are rsa-sha256, with the relaxed
+// canonicalization of both the header and the body, which survives the whitespace changes made
+// by some relays. Create one with NewDKIMSigner.
+type DKIMSigner struct {
+	domain   string
+	selector string
+	key      *rsa.PrivateKey
+	now      func() time.Time
+}
+
+// NewDKIMSigner returns a signer for domain and selector, with the PEM encoded RSA private key,
+// in PKCS #1 or PKCS #8 form. The key must be at least 1024 bits, as receivers ignore the
+// signatures made with shorter ones.
+func NewDKIMSigner(domain, selector string, privateKeyPEM []byte) (*DKIMSigner, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM encoded private key found")
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("dkim: %w", err)
+		}
+		key = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("dkim: %w", err)
+		}
+		rsaKey, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("dkim: %T keys aren't supported, only RSA ones", k)
+		}
+		key = rsaKey
+	default:
+		return nil, fmt.Errorf("dkim: unexpected PEM block %q", block.Type)
+	}
+
+	if key.N.BitLen() < 1024 {
+		return nil, fmt.Errorf("dkim: the key is %d bits, it must be at least 1024", key.N.BitLen())
+	}
+
+	return &DKIMSigner{domain: domain, selector: selector, key: key, now: time.Now}, nil
+}
+
+// Sign returns message, a raw email with CRLF line endings, with a DKIM-Signature header field
+// prepended.
+func (s *DKIMSigner) Sign(message []byte) ([]byte, error) {
+	i := bytes.Index(message, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, errors.New("dkim: no end to the message header")
+	}
+	fields := splitHeader(string(message[:i+2]))
+	body := message[i+4:]
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	// The signature covers the last instance of each signed field, then the DKIM-Signature
+	// field itself with an empty b= tag.
+	var signed []string
+	var headerHash strings.Builder
+	for _, name := range dkimHeaders {
+		for j := len(fields) - 1; j >= 0; j-- {
+			if strings.EqualFold(fieldName(fields[j]), name) {
+				signed = append(signed, strings.ToLower(name))
+				headerHash.WriteString(relaxedHeader(fields[j]))
+				break
+			}
+		}
+	}
+
+	signature := fmt.Sprintf("DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=%s; s=%s;\r\n"+
+		"\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		s.domain, s.selector, s.now().Unix(), strings.Join(signed, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	headerHash.WriteString(strings.TrimSuffix(relaxedHeader(signature), "\r\n"))
+
+	digest := sha256.Sum256([]byte(headerHash.String()))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(signature)
+	out.WriteString(base64.StdEncoding.EncodeToString(sig))
+	out.WriteString("\r\n")
+	out.Write(message)
+	return out.Bytes(), nil
+}
+
+// splitHeader splits the header of an email into its fields, each with its continuation lines
+// and its final CRLF.
+func splitHeader(header string) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(header, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// fieldName returns the name of a header field.
+func fieldName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.TrimSpace(name)
+}
+
+// wspRx matches the runs of whitespace which relaxed canonicalization reduces to one space.
+var wspRx = regexp.MustCompile(`[ \t]+`)
+
+// relaxedHeader returns the relaxed canonicalization of a header field: its name in lower case,
+// and its value unfolded, with the runs of whitespace reduced to one space and trimmed.
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.TrimSpace(wspRx.ReplaceAllString(value, " "))
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// relaxedBody returns the relaxed canonicalization of a body: the runs of whitespace reduced to
+// one space and removed at the end of the lines, and the empty lines at the end removed.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(wspRx.ReplaceAllString(line, " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
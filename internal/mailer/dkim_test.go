@@ -0,0 +1,117 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelaxedCanonicalization(t *testing.T) {
+	// The example of RFC 6376, section 3.4.6.
+	fields := splitHeader("A: X\r\nB : Y\t\r\n\tZ  \r\n")
+	var header string
+	for _, field := range fields {
+		header += relaxedHeader(field)
+	}
+	if want := "a:X\r\nb:Y Z\r\n"; header != want {
+		t.Errorf("got header %q; want %q", header, want)
+	}
+
+	if got, want := string(relaxedBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"))), " C\r\nD E\r\n"; got != want {
+		t.Errorf("got body %q; want %q", got, want)
+	}
+	if got := relaxedBody([]byte("\r\n\r\n")); len(got) != 0 {
+		t.Errorf("got body %q; want an empty body", got)
+	}
+}
+
+func TestDKIMSign(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	signer, err := NewDKIMSigner("example.com", "greenlight", keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	var raw bytes.Buffer
+	msg := &Message{From: "Greenlight <no-reply@example.com>", To: "alice@example.com", Subject: "Hi", PlainBody: "plain", HTMLBody: "<p>html</p>"}
+	if _, err := newMIMEMessage(msg).WriteTo(&raw); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.Sign(raw.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the signature the way a receiver would.
+	i := bytes.Index(signed, []byte("\r\n\r\n"))
+	fields := splitHeader(string(signed[:i+2]))
+	signature := fields[0]
+	tags := map[string]string{}
+	for _, tag := range strings.Split(strings.TrimPrefix(relaxedHeader(signature), "dkim-signature:"), ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(tag), "=")
+		tags[name] = strings.TrimSpace(value)
+	}
+
+	if tags["d"] != "example.com" || tags["s"] != "greenlight" || tags["t"] != "1700000000" ||
+		tags["h"] != "from:to:subject:date:mime-version:content-type" {
+		t.Errorf("got tags %v", tags)
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(signed[i+4:]))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		t.Errorf("got bh=%s; want the hash of the body", tags["bh"])
+	}
+
+	var header strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		for _, field := range fields[1:] {
+			if strings.EqualFold(fieldName(field), name) {
+				header.WriteString(relaxedHeader(field))
+			}
+		}
+	}
+	unsigned := regexp.MustCompile(`b=[\w+/=\s]+$`).ReplaceAllString(strings.TrimSuffix(signature, "\r\n"), "b=")
+	header.WriteString(strings.TrimSuffix(relaxedHeader(unsigned), "\r\n"))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(header.String()))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("the signature doesn't verify: %v", err)
+	}
+}
+
+func TestNewDKIMSignerErrors(t *testing.T) {
+	keys := map[string][]byte{
+		"not PEM":       []byte("not a key"),
+		"certificate":   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("x")}),
+		"corrupt PKCS8": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("x")}),
+	}
+	// Newer Go versions refuse to generate keys this small.
+	if small, err := rsa.GenerateKey(rand.Reader, 512); err == nil {
+		keys["small key"] = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(small)})
+	}
+
+	for name, keyPEM := range keys {
+		if _, err := NewDKIMSigner("example.com", "greenlight", keyPEM); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
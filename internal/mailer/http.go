@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// httpClient is the client the HTTP API providers are called with.
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// maxErrorBody is how much of the body of an error response is kept in the error.
+const maxErrorBody = 512
+
+// doRequest sends req to an email provider's API, and returns an error if the response isn't a
+// success. The provider refusing the email with a 4xx status is a permanent failure, except
+// for 408 Request Timeout and 429 Too Many Requests, which are worth retrying like 5xx statuses
+// and network errors.
+func doRequest(provider string, req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", provider, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("%s: %s: %s", provider, resp.Status, strings.TrimSpace(string(body)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &PermanentError{err}
+	}
+
+	return err
+}
+
+// parseFrom splits the sender of msg into its name and address.
+func parseFrom(msg *Message) (*mail.Address, error) {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return nil, &PermanentError{fmt.Errorf("invalid sender address %q: %w", msg.From, err)}
+	}
+
+	return from, nil
+}
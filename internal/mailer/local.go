@@ -0,0 +1,84 @@
+package mailer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// InfoLogger is the logger the LogSender writes the emails to, such as a *jsonlog.Logger.
+type InfoLogger interface {
+	PrintInfo(message string, properties map[string]string)
+}
+
+// LogSender writes the emails to a log instead of sending them, for local development. Create
+// one with NewLog.
+type LogSender struct {
+	logger InfoLogger
+}
+
+// NewLog returns a sender writing the emails to logger.
+func NewLog(logger InfoLogger) *LogSender {
+	return &LogSender{logger: logger}
+}
+
+// Send logs msg, with its plain-text body, which has the tokens and links of the email. The
+// HTML body and the content of the attachments are left out.
+func (s *LogSender) Send(msg *Message) error {
+	properties := map[string]string{
+		"from":       msg.From,
+		"to":         msg.To,
+		"subject":    strings.TrimSpace(msg.Subject),
+		"plain_body": msg.PlainBody,
+	}
+	if len(msg.Attachments) > 0 {
+		names := make([]string, len(msg.Attachments))
+		for i, a := range msg.Attachments {
+			names[i] = a.Filename
+		}
+		properties["attachments"] = strings.Join(names, ", ")
+	}
+
+	s.logger.PrintInfo("email not sent, logged by the log mail provider", properties)
+	return nil
+}
+
+// FileSender writes the emails as .eml files to a directory instead of sending them, for local
+// development. The files open in most email clients. Create one with NewFile.
+type FileSender struct {
+	dir string
+	now func() time.Time
+}
+
+// NewFile returns a sender writing the emails to dir, which is created if needed.
+func NewFile(dir string) *FileSender {
+	return &FileSender{dir: dir, now: time.Now}
+}
+
+// unsafeFilenameRx matches the characters of an address which are left out of a filename.
+var unsafeFilenameRx = regexp.MustCompile(`[^\w.@+-]`)
+
+// Send writes msg to a file named after the time and its recipient, readable only by the
+// owner as the emails have tokens in them.
+func (s *FileSender) Send(msg *Message) error {
+	err := os.MkdirAll(s.dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	m := newMIMEMessage(msg)
+	m.SetDateHeader("Date", now)
+
+	var buf bytes.Buffer
+	_, err = m.WriteTo(&buf)
+	if err != nil {
+		return err
+	}
+
+	name := now.UTC().Format("20060102T150405.000000000Z") + "-" + unsafeFilenameRx.ReplaceAllString(msg.To, "_") + ".eml"
+	return os.WriteFile(filepath.Join(s.dir, name), buf.Bytes(), 0o600)
+}
@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingLogger is an InfoLogger keeping the properties of the last message.
+type recordingLogger struct {
+	properties map[string]string
+}
+
+func (l *recordingLogger) PrintInfo(message string, properties map[string]string) {
+	l.properties = properties
+}
+
+func TestLogSender(t *testing.T) {
+	logger := &recordingLogger{}
+	m := New(NewLog(logger), "Greenlight <no-reply@example.com>", DefaultRetryPolicy)
+
+	err := m.Send("alice@example.com", "token_activation.tmpl", map[string]interface{}{"activationToken": "T0K3N"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := logger.properties; p["to"] != "alice@example.com" || p["subject"] != "Activate your Greenlight account" ||
+		!strings.Contains(p["plain_body"], "T0K3N") {
+		t.Errorf("got %v; want the email logged", p)
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "emails")
+	sender := NewFile(dir)
+	sender.now = func() time.Time { return time.Date(2024, 1, 15, 10, 20, 30, 0, time.UTC) }
+
+	msg := &Message{
+		From: "Greenlight <no-reply@example.com>", To: "alice/bob@example.com", Subject: "Hi",
+		PlainBody: "plain", HTMLBody: "<p>html</p>",
+	}
+	if err := sender.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "20240115T102030.000000000Z-alice_bob@example.com.eml")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: alice/bob@example.com", "Subject: Hi", "Date: Mon, 15 Jan 2024 10:20:30 +0000", "<p>html</p>"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("want %q in the file", want)
+		}
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("got %v, %v; want the file only readable by its owner", info.Mode(), err)
+	}
+}
@@ -3,10 +3,13 @@ package mailer
 import (
 	"bytes"
 	"embed"
+	"errors"
+	"fmt"
 	"html/template"
+	"io/fs"
+	"math/rand"
+	"net/mail"
 	"time"
-
-	"github.com/go-mail/mail/v2"
 )
 
 // Below we declare a new variable with the type embed.FS (embedded file system) to hold
@@ -23,35 +26,133 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
-// Mailer contains a mail.Dialer instance (used to connect to an SMTP server)
-// and the sender information for our emails (the name and address we want the email to be from,
-// such as "Alice Smith <alice@example.com>").
-type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+// RetryPolicy says how Send retries the failures to send an email which may be transient, such
+// as the SMTP server being unreachable. Attempts is the total number of attempts. The delay
+// before a retry starts at Backoff and doubles with every failed attempt, up to MaxBackoff, and
+// is then spread randomly by up to Jitter times itself either way, so that emails which failed
+// together aren't all retried at the same instant.
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64
+}
+
+// DefaultRetryPolicy makes 3 attempts, 500ms apart.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Backoff: 500 * time.Millisecond, MaxBackoff: 500 * time.Millisecond}
+
+// delay returns how long to wait before the retry following the attempt-th attempt. r is a
+// random number in [0, 1) which sets the jitter.
+func (p RetryPolicy) delay(attempt int, r float64) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	return time.Duration(float64(d) * (1 + p.Jitter*(2*r-1)))
+}
+
+// PermanentError is returned by Send for failures which retrying won't fix, such as an invalid
+// recipient address or one the SMTP server rejects with a 5xx reply. Send doesn't retry them,
+// and callers shouldn't either.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent reports whether err is, or wraps, a PermanentError.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// Message is an email, rendered and ready to be handed to a Sender.
+type Message struct {
+	// From is the sender, such as "Alice Smith <alice@example.com>", and To the recipient's
+	// address.
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+	// Attachments have their content type set, and are within the size limits.
+	Attachments []Attachment
+}
+
+// Sender delivers emails, over SMTP or through the HTTP API of an email provider. It returns a
+// PermanentError for the failures which retrying won't fix.
+type Sender interface {
+	Send(msg *Message) error
+}
+
+// Checker is implemented by the senders which can check that they are able to send emails
+// without sending one, such as by connecting to their server.
+type Checker interface {
+	Check() error
 }
 
-// New initializes a new mail.Dialer instance with the given SMTP server settings and a 5-second
-// timeout whenever we send an email. It returns a Mailer instance containing the dialer and sender
-// information.
-func New(host string, port int, username, password, sender string) Mailer {
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
+// Mailer renders emails from the templates and sends them with a Sender, retrying the failures
+// which may be transient. It also holds the sender information for our emails (the name and
+// address we want the email to be from, such as "Alice Smith <alice@example.com>").
+type Mailer struct {
+	sender Sender
+	from   string
+	retry  RetryPolicy
+	// templates holds the templates and, under images, the images embedded in the emails.
+	templates fs.FS
+}
 
+// New returns a Mailer sending the emails from the given address with sender, and retrying
+// failed sends according to retry.
+func New(sender Sender, from string, retry RetryPolicy) Mailer {
 	return Mailer{
-		dialer: dialer,
-		sender: sender,
+		sender:    sender,
+		from:      from,
+		retry:     retry,
+		templates: embeddedTemplates,
+	}
+}
+
+// Check checks that the sender is able to send emails, if it implements Checker. The senders
+// which don't are assumed to be.
+func (m Mailer) Check() error {
+	if c, ok := m.sender.(Checker); ok {
+		return c.Check()
 	}
+	return nil
 }
 
 // Send takes a recipient email address, name of a template file, and any dynamic data and
-// sends the executed template as an email.
-func (m Mailer) Send(recipientEmail, templateFileName string, data interface{}) error {
-	// Use the ParseFS() method to parse the required template file
-	// from the embedded file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFileName)
+// sends the executed template as an email, with the given attachments. The template images which the HTML body refers to with "cid:" URLs, such as
+// <img src="cid:logo.png">, are embedded in the email too.
+//
+// The outcome is counted in the "email_deliveries" stats of the template, see deliveryStats.
+func (m Mailer) Send(recipientEmail, templateFileName string, data interface{}, attachments ...Attachment) (err error) {
+	retries := 0
+	defer func() {
+		recordDelivery(templateFileName, retries, err)
+	}()
+
+	// An invalid address, or template, fails the same way every time.
+	if _, err := mail.ParseAddress(recipientEmail); err != nil {
+		return &PermanentError{fmt.Errorf("invalid recipient address %q: %w", recipientEmail, err)}
+	}
+
+	// Use the ParseFS() method to parse the required template file from the embedded file
+	// system, or the template directory overriding it.
+	tmpl, err := template.New("email").ParseFS(m.templates, templateFileName)
 	if err != nil {
-		return err
+		return &PermanentError{err}
 	}
 
 	// Execute the named template "subject" defined inside "user_welcome.tmpl",
@@ -59,7 +160,7 @@ func (m Mailer) Send(recipientEmail, templateFileName string, data interface{})
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return &PermanentError{err}
 	}
 
 	// Execute the named template "plainBody" defined inside "user_welcome.tmpl"
@@ -67,44 +168,55 @@ func (m Mailer) Send(recipientEmail, templateFileName string, data interface{})
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return &PermanentError{err}
 	}
 
 	// Execute the named template "htmlBody" defined inside "user_welcome.tmpl" similar to above.
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return &PermanentError{err}
+	}
+
+	// Copy the attachments, so that setting their content types doesn't change the caller's.
+	attachments = append([]Attachment(nil), attachments...)
+	images, err := templateImages(m.templates, htmlBody.String(), attachments)
+	if err != nil {
+		return err
+	}
+	attachments = append(attachments, images...)
+	err = prepareAttachments(attachments)
 	if err != nil {
 		return err
 	}
 
-	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
-	// Then use the SetHeader() method to set the mail recipient, sender, and subject headers,
-	// the SetBody() method to set the plain-text body, and the AddAlternative() method to set
-	// the HTML body. It's important to note that AddAlernative() should always be called *after*
-	// SetBody().
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipientEmail)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	// Try sending the email up to 3 times before aborting and returning the final error. We sleep
-	// for 500 ms between each attempt. Note, we check for send failure with `if nil == err`
-	// because its more visually jarring and less likely to be confused with `if err != nil`
-	for i := 1; i <= 3; i++ {
-		// Call the DialAndSend() method on the dialer, passing in the message to send.
-		// This opens a connection to the SMTP server, sends the message, then closes the connection.
-		// If there is a timeout, it will return a "dial tcp: i/o timeout" error.
-		err = m.dialer.DialAndSend(msg)
+	msg := &Message{
+		From:        m.from,
+		To:          recipientEmail,
+		Subject:     subject.String(),
+		PlainBody:   plainBody.String(),
+		HTMLBody:    htmlBody.String(),
+		Attachments: attachments,
+	}
+
+	// Try sending the email up to m.retry.Attempts times before aborting and returning the
+	// final error, waiting longer after every failure. Permanent failures aren't retried. Note,
+	// we check for send failure with `if nil == err` because its more visually jarring and less
+	// likely to be confused with `if err != nil`
+	for i := 1; ; i++ {
+		err = m.sender.Send(msg)
 		if nil == err {
 			return nil
 		}
 
-		// If it didn't work, sleep for a short time and retyr.
-		time.Sleep(500 * time.Millisecond)
-	}
+		// Return the error if it's permanent, or if we haven't been able to send the email
+		// after the last attempt.
+		if IsPermanent(err) || i >= m.retry.Attempts {
+			return err
+		}
 
-	// return err if we haven't been able to send the email after 3 tries.
-	return err
+		// If it didn't work, sleep for a while and retry.
+		time.Sleep(m.retry.delay(i, rand.Float64()))
+		retries++
+	}
 }
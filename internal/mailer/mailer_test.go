@@ -0,0 +1,223 @@
+package mailer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: 5 * time.Second, Jitter: 0.5}
+
+	tests := []struct {
+		attempt int
+		r       float64
+		want    time.Duration
+	}{
+		{1, 0.5, time.Second},
+		{2, 0.5, 2 * time.Second},
+		{3, 0.5, 4 * time.Second},
+		{4, 0.5, 5 * time.Second},
+		{1, 0, 500 * time.Millisecond},
+		{2, 0.75, 2500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := p.delay(tt.attempt, tt.r); got != tt.want {
+			t.Errorf("delay(%d, %g) = %v; want %v", tt.attempt, tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestIsPermanentSMTPError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&textproto.Error{Code: 550, Msg: "no such user"}, true},
+		{&mail.SendError{Cause: &textproto.Error{Code: 553, Msg: "bad address"}}, true},
+		{fmt.Errorf("sending: %w", &textproto.Error{Code: 421, Msg: "try again later"}), false},
+		{mail.StartTLSUnsupportedError{Policy: mail.MandatoryStartTLS}, true},
+		{errors.New("dial tcp: i/o timeout"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isPermanentSMTPError(tt.err); got != tt.want {
+			t.Errorf("isPermanentSMTPError(%v) = %t; want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+// senderFunc is a Sender calling a function.
+type senderFunc func(msg *Message) error
+
+func (f senderFunc) Send(msg *Message) error {
+	return f(msg)
+}
+
+func TestSend(t *testing.T) {
+	var sent []*Message
+	fail := 1
+	m := New(senderFunc(func(msg *Message) error {
+		sent = append(sent, msg)
+		if len(sent) <= fail {
+			return errors.New("unavailable")
+		}
+		return nil
+	}), "Greenlight <no-reply@example.com>", RetryPolicy{Attempts: 3})
+
+	err := m.Send("alice@example.com", "token_activation.tmpl", map[string]interface{}{"activationToken": "T0K3N"})
+	if err != nil || len(sent) != 2 {
+		t.Fatalf("got %v after %d attempts; want the email sent on the second attempt", err, len(sent))
+	}
+	if msg := sent[1]; msg.To != "alice@example.com" || msg.From != "Greenlight <no-reply@example.com>" ||
+		msg.Subject == "" || !strings.Contains(msg.PlainBody, "T0K3N") || !strings.Contains(msg.HTMLBody, "T0K3N") {
+		t.Errorf("got %+v; want the rendered template", msg)
+	}
+
+	sent, fail = nil, 5
+	if err := m.Send("alice@example.com", "token_activation.tmpl", nil); err == nil || len(sent) != 3 {
+		t.Errorf("got %v after %d attempts; want the error after the last attempt", err, len(sent))
+	}
+}
+
+func TestSendPermanentErrors(t *testing.T) {
+	attempts := 0
+	m := New(senderFunc(func(msg *Message) error {
+		attempts++
+		return &PermanentError{errors.New("550 no such user")}
+	}), "Greenlight <no-reply@example.com>", DefaultRetryPolicy)
+
+	if err := m.Send("alice@example.com", "user_welcome.tmpl", nil); !IsPermanent(err) || attempts != 1 {
+		t.Errorf("got %v after %d attempts; want a permanent error which isn't retried", err, attempts)
+	}
+
+	if err := m.Send("not an address", "user_welcome.tmpl", nil); !IsPermanent(err) || attempts != 1 {
+		t.Errorf("got %v; want a permanent error, without trying to send the email", err)
+	}
+}
+
+func TestHTTPSenders(t *testing.T) {
+	var got *http.Request
+	var body string
+	status := http.StatusAccepted
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, body = r, string(b)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	msg := &Message{From: "Greenlight <no-reply@example.com>", To: "alice@example.com", Subject: "Hi", PlainBody: "plain", HTMLBody: "<p>html</p>"}
+
+	sendGrid := NewSendGrid("SG.key")
+	sendGrid.endpoint = ts.URL + "/v3/mail/send"
+	if err := sendGrid.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if got.Header.Get("Authorization") != "Bearer SG.key" || !strings.Contains(body, `"email":"alice@example.com"`) ||
+		!strings.Contains(body, `"name":"Greenlight"`) {
+		t.Errorf("sendgrid: got %s with %v", body, got.Header)
+	}
+
+	mailgun := NewMailgun(ts.URL, "mg.example.com", "key-1")
+	if err := mailgun.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if user, pass, _ := got.BasicAuth(); user != "api" || pass != "key-1" || got.URL.Path != "/v3/mg.example.com/messages" ||
+		!strings.Contains(body, "to=alice%40example.com") {
+		t.Errorf("mailgun: got %s %s", got.URL.Path, body)
+	}
+
+	ses := NewSES("eu-west-1", "AKID", "secret")
+	ses.endpoint = ts.URL + "/v2/email/outbound-emails"
+	if err := ses.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(body, `"ToAddresses":["alice@example.com"]`) {
+		t.Errorf("ses: got %s with %v", body, got.Header)
+	}
+
+	for code, permanent := range map[int]bool{400: true, 401: true, 429: false, 500: false, 503: false} {
+		status = code
+		if err := sendGrid.Send(msg); err == nil || IsPermanent(err) != permanent {
+			t.Errorf("status %d: got %v; want permanent %t", code, err, permanent)
+		}
+	}
+}
+
+func TestSignV4(t *testing.T) {
+	// The "get-vanilla" case of the AWS Signature Version 4 test suite.
+	req := httptest.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+// fakeSMTPServer accepts one connection, greets it with greeting, and then answers EHLO and QUIT.
+func fakeSMTPServer(t *testing.T, greeting string) (string, int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, greeting+"\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				fmt.Fprint(conn, "250-localhost\r\n250 8BITMIME\r\n")
+			case strings.HasPrefix(line, "QUIT"):
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "502 not implemented\r\n")
+			}
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestSMTPCheck(t *testing.T) {
+	host, port := fakeSMTPServer(t, "220 localhost ESMTP")
+	if err := NewSMTP(host, port, "", "").Check(); err != nil {
+		t.Errorf("got %v; want the check to pass", err)
+	}
+
+	host, port = fakeSMTPServer(t, "554 no service")
+	if err := NewSMTP(host, port, "", "").Check(); err == nil {
+		t.Error("want an error when the server refuses the connection")
+	}
+}
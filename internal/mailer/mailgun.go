@@ -0,0 +1,101 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// MailgunSender sends emails through the Mailgun messages API. Create one with NewMailgun.
+type MailgunSender struct {
+	apiKey   string
+	endpoint string
+}
+
+// NewMailgun returns a sender sending from domain, authenticating to Mailgun with apiKey. apiBase
+// is the base URL of the API, https://api.mailgun.net or https://api.eu.mailgun.net for domains
+// in the EU region.
+func NewMailgun(apiBase, domain, apiKey string) *MailgunSender {
+	return &MailgunSender{
+		apiKey:   apiKey,
+		endpoint: strings.TrimSuffix(apiBase, "/") + "/v3/" + url.PathEscape(domain) + "/messages",
+	}
+}
+
+// Send sends msg. An email with attachments is posted as a multipart form, with the files in
+// "attachment" and, for inline images, "inline" fields; Mailgun gives the inline images their
+// filename as Content-ID.
+func (s *MailgunSender) Send(msg *Message) error {
+	form := url.Values{
+		"from":    {msg.From},
+		"to":      {msg.To},
+		"subject": {msg.Subject},
+		"text":    {msg.PlainBody},
+		"html":    {msg.HTMLBody},
+	}
+
+	body := []byte(form.Encode())
+	contentType := "application/x-www-form-urlencoded"
+	if len(msg.Attachments) > 0 {
+		var err error
+		body, contentType, err = mailgunMultipart(form, msg.Attachments)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("api", s.apiKey)
+	req.Header.Set("Content-Type", contentType)
+
+	return doRequest("mailgun", req)
+}
+
+// mailgunMultipart encodes form and the attachments as a multipart form, and returns it with its
+// content type.
+func mailgunMultipart(form url.Values, attachments []Attachment) ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for name, values := range form {
+		for _, value := range values {
+			err := w.WriteField(name, value)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	for _, a := range attachments {
+		field := "attachment"
+		if a.Inline {
+			field = "inline"
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, field, a.Filename))
+		header.Set("Content-Type", a.ContentType)
+		part, err := w.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		_, err = part.Write(a.Data)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
@@ -0,0 +1,84 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// SendGridSender sends emails through the SendGrid v3 Mail Send API. Create one with
+// NewSendGrid.
+type SendGridSender struct {
+	apiKey string
+	// endpoint is the URL of the Mail Send API.
+	endpoint string
+}
+
+// NewSendGrid returns a sender authenticating to SendGrid with apiKey.
+func NewSendGrid(apiKey string) *SendGridSender {
+	return &SendGridSender{apiKey: apiKey, endpoint: "https://api.sendgrid.com/v3/mail/send"}
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// sendGridAttachment is an attachment in the Mail Send API. Content is marshalled as base64.
+type sendGridAttachment struct {
+	Content     []byte `json:"content"`
+	Type        string `json:"type"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+// Send sends msg.
+func (s *SendGridSender) Send(msg *Message) error {
+	from, err := parseFrom(msg)
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"personalizations": []map[string]interface{}{
+			{"to": []sendGridAddress{{Email: msg.To}}},
+		},
+		"from":    sendGridAddress{Email: from.Address, Name: from.Name},
+		"subject": msg.Subject,
+		"content": []sendGridContent{
+			{Type: "text/plain", Value: msg.PlainBody},
+			{Type: "text/html", Value: msg.HTMLBody},
+		},
+	}
+	if len(msg.Attachments) > 0 {
+		attachments := make([]sendGridAttachment, len(msg.Attachments))
+		for i, a := range msg.Attachments {
+			attachments[i] = sendGridAttachment{Content: a.Data, Type: a.ContentType, Filename: a.Filename, Disposition: "attachment"}
+			if a.Inline {
+				attachments[i].Disposition = "inline"
+				attachments[i].ContentID = a.Filename
+			}
+		}
+		payload["attachments"] = attachments
+	}
+
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	return doRequest("sendgrid", req)
+}
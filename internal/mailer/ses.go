@@ -0,0 +1,140 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SESSender sends emails through the Amazon SES v2 API, signing the requests with AWS
+// Signature Version 4. Create one with NewSES.
+type SESSender struct {
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	// endpoint is the URL of the SendEmail action.
+	endpoint string
+	now      func() time.Time
+}
+
+// NewSES returns a sender calling SES in region with the given IAM credentials.
+func NewSES(region, accessKeyID, secretAccessKey string) *SESSender {
+	return &SESSender{
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		endpoint:        fmt.Sprintf("https://email.%s.amazonaws.com/v2/email/outbound-emails", region),
+		now:             time.Now,
+	}
+}
+
+type sesContent struct {
+	Data    string `json:"Data"`
+	Charset string `json:"Charset"`
+}
+
+// Send sends msg. An email with attachments is sent as a raw MIME message, as the simple content
+// of SES only has a subject and bodies.
+func (s *SESSender) Send(msg *Message) error {
+	content := map[string]interface{}{
+		"Simple": map[string]interface{}{
+			"Subject": sesContent{msg.Subject, "UTF-8"},
+			"Body": map[string]sesContent{
+				"Text": {msg.PlainBody, "UTF-8"},
+				"Html": {msg.HTMLBody, "UTF-8"},
+			},
+		},
+	}
+	if len(msg.Attachments) > 0 {
+		var raw bytes.Buffer
+		_, err := newMIMEMessage(msg).WriteTo(&raw)
+		if err != nil {
+			return err
+		}
+		// []byte is marshalled as base64, which is what SES expects.
+		content = map[string]interface{}{"Raw": map[string][]byte{"Data": raw.Bytes()}}
+	}
+
+	js, err := json.Marshal(map[string]interface{}{
+		"FromEmailAddress": msg.From,
+		"Destination":      map[string][]string{"ToAddresses": {msg.To}},
+		"Content":          content,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signV4(req, js, s.accessKeyID, s.secretAccessKey, s.region, "ses", s.now())
+
+	return doRequest("ses", req)
+}
+
+// signV4 signs req, whose body is body, with AWS Signature Version 4 for service in region. The
+// host, X-Amz-Date and, if set, Content-Type headers are signed.
+func signV4(req *http.Request, body []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host, "x-amz-date": amzDate}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers["content-type"] = ct
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,144 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/textproto"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// SMTPSender sends emails through an SMTP server. Create one with NewSMTP.
+type SMTPSender struct {
+	dialer *mail.Dialer
+	// dkim signs the emails, if set with SetDKIMSigner.
+	dkim *DKIMSigner
+}
+
+// NewSMTP initializes a new mail.Dialer instance with the given SMTP server settings and a
+// 5-second timeout whenever we send an email.
+func NewSMTP(host string, port int, username, password string) *SMTPSender {
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTPSender{dialer: dialer}
+}
+
+// Send sends msg. A 5xx reply from the server is a permanent failure.
+func (s *SMTPSender) Send(msg *Message) error {
+	m := newMIMEMessage(msg)
+
+	// Call the DialAndSend() method on the dialer, passing in the message to send.
+	// This opens a connection to the SMTP server, sends the message, then closes the connection.
+	// If there is a timeout, it will return a "dial tcp: i/o timeout" error.
+	var err error
+	if s.dkim == nil {
+		err = s.dialer.DialAndSend(m)
+	} else {
+		err = s.sendSigned(m, msg)
+	}
+	if err != nil && isPermanentSMTPError(err) {
+		return &PermanentError{err}
+	}
+
+	return err
+}
+
+// Check connects and authenticates to the SMTP server, then disconnects without sending an
+// email. It times out like Send.
+func (s *SMTPSender) Check() error {
+	sc, err := s.dialer.Dial()
+	if err != nil {
+		return err
+	}
+	return sc.Close()
+}
+
+// SetDKIMSigner signs the emails sent from now on with signer.
+func (s *SMTPSender) SetDKIMSigner(signer *DKIMSigner) {
+	s.dkim = signer
+}
+
+// sendSigned sends m, the MIME message of msg, with a DKIM signature. The message is written
+// out to be signed, so it's sent as it is rather than through DialAndSend, which would write it
+// again with a new Date.
+func (s *SMTPSender) sendSigned(m *mail.Message, msg *Message) error {
+	from, err := parseFrom(msg)
+	if err != nil {
+		return err
+	}
+
+	var raw bytes.Buffer
+	_, err = m.WriteTo(&raw)
+	if err != nil {
+		return err
+	}
+	signed, err := s.dkim.Sign(raw.Bytes())
+	if err != nil {
+		return &PermanentError{err}
+	}
+
+	sc, err := s.dialer.Dial()
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+
+	return sc.Send(from.Address, []string{msg.To}, bytes.NewReader(signed))
+}
+
+// newMIMEMessage builds the MIME message for msg, which is also what the SES sender sends for
+// emails with attachments.
+func newMIMEMessage(msg *Message) *mail.Message {
+	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
+	// Then use the SetHeader() method to set the mail recipient, sender, and subject headers,
+	// the SetBody() method to set the plain-text body, and the AddAlternative() method to set
+	// the HTML body. It's important to note that AddAlernative() should always be called *after*
+	// SetBody().
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+
+	// The inline images get a Content-ID of <filename>, which the "cid:" URLs refer to. The
+	// content is copied from the attachment every time, as a retry writes the message again.
+	for _, a := range msg.Attachments {
+		a := a
+		settings := []mail.FileSetting{
+			mail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}}),
+			mail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(a.Data)
+				return err
+			}),
+		}
+		if a.Inline {
+			m.Embed(a.Filename, settings...)
+		} else {
+			m.Attach(a.Filename, settings...)
+		}
+	}
+
+	return m
+}
+
+// isPermanentSMTPError reports whether err, returned by the SMTP dialer, is a permanent failure:
+// a 5xx reply from the server, or a server without STARTTLS when it is required.
+func isPermanentSMTPError(err error) bool {
+	// SendError doesn't implement Unwrap.
+	var sendErr *mail.SendError
+	if errors.As(err, &sendErr) {
+		err = sendErr.Cause
+	}
+
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code >= 500
+	}
+
+	var tlsErr mail.StartTLSUnsupportedError
+	return errors.As(err, &tlsErr)
+}
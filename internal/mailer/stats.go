@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// deliveryStats holds, for each template, the number of emails sent, the number which failed
+// for good, how many of those failures were permanent, and the number of retries, along with
+// the Unix times of the last email sent and the last failure. It's published in /debug/vars as
+// "email_deliveries", keyed by template name such as "user_welcome.tmpl", so that a template
+// whose failures keep growing while nothing is sent stands out.
+var (
+	deliveryStats   = expvar.NewMap("email_deliveries")
+	deliveryStatsMu sync.Mutex
+)
+
+// recordDelivery records the outcome of sending an email from the template name, which was
+// retried retries times.
+func recordDelivery(name string, retries int, err error) {
+	stats := deliveryStatsFor(name)
+	if retries > 0 {
+		stats.Add("retried", int64(retries))
+	}
+
+	if err == nil {
+		stats.Add("sent", 1)
+		setStat(stats, "last_sent", time.Now().Unix())
+		return
+	}
+
+	stats.Add("failed", 1)
+	if IsPermanent(err) {
+		stats.Add("failed_permanent", 1)
+	}
+	setStat(stats, "last_failed", time.Now().Unix())
+}
+
+// setStat sets the integer key of stats to value.
+func setStat(stats *expvar.Map, key string, value int64) {
+	if v, ok := stats.Get(key).(*expvar.Int); ok {
+		v.Set(value)
+		return
+	}
+
+	v := new(expvar.Int)
+	v.Set(value)
+	stats.Set(key, v)
+}
+
+// deliveryStatsFor returns the stats of the template name, creating them on its first email.
+func deliveryStatsFor(name string) *expvar.Map {
+	if stats, ok := deliveryStats.Get(name).(*expvar.Map); ok {
+		return stats
+	}
+
+	deliveryStatsMu.Lock()
+	defer deliveryStatsMu.Unlock()
+
+	if stats, ok := deliveryStats.Get(name).(*expvar.Map); ok {
+		return stats
+	}
+
+	stats := new(expvar.Map).Init()
+	deliveryStats.Set(name, stats)
+	return stats
+}
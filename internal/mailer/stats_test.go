@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+)
+
+// statValue returns the integer key of the stats of the template name, or 0.
+func statValue(name, key string) int64 {
+	if v, ok := deliveryStatsFor(name).Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestDeliveryStats(t *testing.T) {
+	const name = "token_password_reset.tmpl"
+	before := map[string]int64{}
+	for _, key := range []string{"sent", "failed", "failed_permanent", "retried"} {
+		before[key] = statValue(name, key)
+	}
+
+	var errs []error
+	m := New(senderFunc(func(msg *Message) error {
+		err := errs[0]
+		errs = errs[1:]
+		return err
+	}), "Greenlight <no-reply@example.com>", RetryPolicy{Attempts: 3})
+
+	// Sent on the second attempt, failed after three, and failed for good on the first.
+	errs = []error{errors.New("unavailable"), nil, errors.New("unavailable"), errors.New("unavailable"),
+		errors.New("unavailable"), &PermanentError{errors.New("550 no such user")}}
+	for i := 0; i < 3; i++ {
+		_ = m.Send("alice@example.com", name, nil)
+	}
+
+	for key, want := range map[string]int64{"sent": 1, "failed": 2, "failed_permanent": 1, "retried": 3} {
+		if got := statValue(name, key) - before[key]; got != want {
+			t.Errorf("%s: got %d; want %d", key, got, want)
+		}
+	}
+	if statValue(name, "last_sent") == 0 || statValue(name, "last_failed") == 0 {
+		t.Error("want the times of the last email sent and failure recorded")
+	}
+}
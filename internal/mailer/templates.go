@@ -0,0 +1,133 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// embeddedTemplates is the templates directory of templateFS, which the templates and images
+// are read from unless they are overridden with WithTemplateDir.
+var embeddedTemplates = func() fs.FS {
+	sub, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}()
+
+// overlayFS reads the files from top, and those which aren't in top from base.
+type overlayFS struct {
+	top  fs.FS
+	base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	return o.base.Open(name)
+}
+
+// WithTemplateDir returns a copy of the mailer whose templates and images are read from dir,
+// falling back to the embedded ones for the files dir doesn't have. dir has the same layout as
+// the embedded templates: the templates at its root, named like the one they override, and the
+// images in an images subdirectory. The files are read when an email is sent, so that they can
+// be edited without a restart, but they are checked now: an error is returned for a file which
+// doesn't override anything, a template which doesn't parse or lacks one of the subject,
+// plainBody and htmlBody templates, an image which isn't one or is too large, or a "cid:" URL
+// to an image which doesn't exist. Files whose name starts with a dot are ignored.
+func (m Mailer) WithTemplateDir(dir string) (Mailer, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return m, err
+	}
+	if !info.IsDir() {
+		return m, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	templates := overlayFS{top: os.DirFS(dir), base: embeddedTemplates}
+	err = fs.WalkDir(templates.top, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if name != "." && name != "images" {
+				return fmt.Errorf("%s: unexpected directory, only images can have one", name)
+			}
+			return nil
+		}
+
+		if path.Dir(name) == "images" {
+			return checkTemplateImage(templates.top, name)
+		}
+		if _, err := fs.Stat(embeddedTemplates, name); err != nil || path.Ext(name) != ".tmpl" {
+			return fmt.Errorf("%s: not one of the email templates", name)
+		}
+		return checkTemplate(templates, name)
+	})
+	if err != nil {
+		return m, err
+	}
+
+	m.templates = templates
+	return m, nil
+}
+
+// checkTemplate checks that the template name in templates parses, defines the templates Send
+// executes, and only refers to images which exist.
+func checkTemplate(templates fs.FS, name string) error {
+	tmpl, err := template.New("email").ParseFS(templates, name)
+	if err != nil {
+		return err
+	}
+	for _, t := range []string{"subject", "plainBody", "htmlBody"} {
+		if tmpl.Lookup(t) == nil {
+			return fmt.Errorf("%s: no %q template defined", name, t)
+		}
+	}
+
+	source, err := fs.ReadFile(templates, name)
+	if err != nil {
+		return err
+	}
+	for _, match := range cidRx.FindAllStringSubmatch(string(source), -1) {
+		if _, err := fs.Stat(templates, path.Join("images", match[1])); err != nil {
+			return fmt.Errorf("%s: inline image %q not found", name, match[1])
+		}
+	}
+
+	return nil
+}
+
+// checkTemplateImage checks that the file name in templates is an image which can be embedded in
+// an email.
+func checkTemplateImage(templates fs.FS, name string) error {
+	data, err := fs.ReadFile(templates, name)
+	if err != nil {
+		return err
+	}
+
+	// The extension may not match the content, so the content is checked too.
+	if ct := http.DetectContentType(data); !strings.HasPrefix(ct, "image/") {
+		return fmt.Errorf("%s: not an image, but %s", name, ct)
+	}
+
+	attachment := []Attachment{{Filename: path.Base(name), Data: data, Inline: true}}
+	if err := prepareAttachments(attachment); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testWelcome = `{{define "subject"}}Welcome aboard{{end}}
+{{define "plainBody"}}Your user ID is {{.userID}}.{{end}}
+{{define "htmlBody"}}<img src="cid:banner.png"><p>Your user ID is {{.userID}}.</p>{{end}}`
+
+// writeFiles writes files, keyed by their path relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWithTemplateDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"user_welcome.tmpl": testWelcome,
+		"images/banner.png": "\x89PNG\r\n\x1a\n",
+		".gitkeep":          "",
+	})
+
+	var sent *Message
+	m, err := New(senderFunc(func(msg *Message) error {
+		sent = msg
+		return nil
+	}), "Greenlight <no-reply@example.com>", DefaultRetryPolicy).WithTemplateDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Send("alice@example.com", "user_welcome.tmpl", map[string]interface{}{"userID": 42}); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Subject != "Welcome aboard" || sent.PlainBody != "Your user ID is 42." ||
+		len(sent.Attachments) != 1 || sent.Attachments[0].Filename != "banner.png" {
+		t.Errorf("got %+v; want the overriding template", sent)
+	}
+
+	// The templates which aren't overridden are the embedded ones.
+	if err := m.Send("alice@example.com", "token_activation.tmpl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sent.Subject, "Activate") {
+		t.Errorf("got subject %q; want the embedded template", sent.Subject)
+	}
+
+	// The files are read when an email is sent.
+	writeFiles(t, dir, map[string]string{"user_welcome.tmpl": strings.Replace(testWelcome, "aboard", "back", 1)})
+	if err := m.Send("alice@example.com", "user_welcome.tmpl", nil); err != nil || sent.Subject != "Welcome back" {
+		t.Errorf("got %q, %v; want the edited template", sent.Subject, err)
+	}
+}
+
+func TestWithTemplateDirErrors(t *testing.T) {
+	m := New(nil, "Greenlight <no-reply@example.com>", DefaultRetryPolicy)
+
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{"unknown template", map[string]string{"user_welcom.tmpl": testWelcome}},
+		{"not a template", map[string]string{"README.md": "# Templates"}},
+		{"parse error", map[string]string{"user_welcome.tmpl": `{{define "subject"}}{{.userID}`}},
+		{"missing htmlBody", map[string]string{"user_welcome.tmpl": `{{define "subject"}}a{{end}}{{define "plainBody"}}b{{end}}`}},
+		{"missing image", map[string]string{"user_welcome.tmpl": testWelcome}},
+		{"image not an image", map[string]string{"images/banner.png": "hello"}},
+		{"unexpected directory", map[string]string{"drafts/user_welcome.tmpl": testWelcome}},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, tt.files)
+		if _, err := m.WithTemplateDir(dir); err == nil {
+			t.Errorf("%s: want an error", tt.name)
+		}
+	}
+
+	if _, err := m.WithTemplateDir(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing directory: want an error")
+	}
+}
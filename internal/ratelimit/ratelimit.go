@@ -0,0 +1,250 @@
+// Package ratelimit implements per-client rate limiting with interchangeable algorithms. Every
+// algorithm is configured with the same two numbers, a rate in requests per second and a
+// burst, but they differ in how a client may spend them:
+//
+//   - TokenBucket refills one token every 1/rps seconds, up to burst tokens, so a client can
+//     burst and then continue at the steady rate.
+//   - FixedWindow allows burst requests in each window of burst/rps seconds, aligned to the
+//     clock. It's the cheapest, but a client can make 2*burst requests around a window edge.
+//   - SlidingLog allows burst requests in any burst/rps seconds, by remembering the time of
+//     each request. It's exact, at the cost of storing up to burst times per client.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Algorithm names a rate limiting algorithm.
+type Algorithm string
+
+const (
+	TokenBucket Algorithm = "token-bucket"
+	FixedWindow Algorithm = "fixed-window"
+	SlidingLog  Algorithm = "sliding-log"
+)
+
+// ParseAlgorithm returns the algorithm named s.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(s); a {
+	case TokenBucket, FixedWindow, SlidingLog:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown rate limiting algorithm %q", s)
+}
+
+// Result is the outcome of a call to Limiter.Allow, with what clients need to know to pace
+// their requests.
+type Result struct {
+	// Allowed is whether the request is allowed.
+	Allowed bool
+	// Limit is the number of requests a client can make in a burst.
+	Limit int
+	// Remaining is the number of requests the client can still make right away.
+	Remaining int
+	// Reset is the time until the client can make a full burst of Limit requests again.
+	Reset time.Duration
+	// RetryAfter is the time until the client can make its next request, which is zero when
+	// the request is allowed.
+	RetryAfter time.Duration
+}
+
+// Limiter limits the requests of a single client.
+type Limiter interface {
+	// Allow reports whether a request made at now is allowed, and counts it if it is.
+	Allow(now time.Time) Result
+}
+
+// New returns a limiter using algorithm which allows rps requests per second, with bursts of
+// up to burst requests. rps and burst must be greater than zero.
+func New(algorithm Algorithm, rps float64, burst int) Limiter {
+	window := time.Duration(float64(burst) / rps * float64(time.Second))
+
+	switch algorithm {
+	case FixedWindow:
+		return &fixedWindow{window: window, limit: burst}
+	case SlidingLog:
+		return &slidingLog{window: window, limit: burst}
+	default:
+		return &tokenBucket{rps: rps, burst: burst, tokens: float64(burst)}
+	}
+}
+
+type tokenBucket struct {
+	rps    float64
+	burst  int
+	tokens float64
+	last   time.Time // time tokens was last brought up to date
+}
+
+func (b *tokenBucket) Allow(now time.Time) Result {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(float64(b.burst), b.tokens+now.Sub(b.last).Seconds()*b.rps)
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+
+	res := Result{Limit: b.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = b.refill(1 - b.tokens)
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = b.refill(float64(b.burst) - b.tokens)
+	return res
+}
+
+// refill returns the time it takes to add tokens to the bucket.
+func (b *tokenBucket) refill(tokens float64) time.Duration {
+	return time.Duration(tokens / b.rps * float64(time.Second))
+}
+
+type fixedWindow struct {
+	window time.Duration
+	limit  int
+	start  time.Time
+	count  int
+}
+
+func (w *fixedWindow) Allow(now time.Time) Result {
+	if start := now.Truncate(w.window); !start.Equal(w.start) {
+		w.start = start
+		w.count = 0
+	}
+
+	res := Result{Limit: w.limit, Reset: w.start.Add(w.window).Sub(now)}
+	if w.count < w.limit {
+		w.count++
+		res.Allowed = true
+	} else {
+		res.RetryAfter = res.Reset
+	}
+
+	res.Remaining = w.limit - w.count
+	return res
+}
+
+type slidingLog struct {
+	window time.Duration
+	limit  int
+	times  []time.Time // times of the allowed requests in the current window, oldest first
+}
+
+func (l *slidingLog) Allow(now time.Time) Result {
+	expired := 0
+	for expired < len(l.times) && now.Sub(l.times[expired]) >= l.window {
+		expired++
+	}
+	l.times = l.times[expired:]
+
+	res := Result{Limit: l.limit}
+	if len(l.times) < l.limit {
+		l.times = append(l.times, now)
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.times[0].Add(l.window).Sub(now)
+	}
+
+	res.Remaining = l.limit - len(l.times)
+	res.Reset = l.times[len(l.times)-1].Add(l.window).Sub(now)
+	return res
+}
+
+// ClientStore limits the requests of many clients, each identified by a key such as an IP
+// address. Store keeps the limiters in the process, and RedisStore shares them between the
+// instances of the application through Redis.
+type ClientStore interface {
+	// Allow reports whether the client identified by key may make a request now.
+	Allow(key string) Result
+	// Configure changes the algorithm and limits used from now on.
+	Configure(algorithm Algorithm, rps float64, burst int)
+	// StartCleanup drops the limiters of the clients which haven't made a request for maxIdle,
+	// every interval, for the lifetime of the process.
+	StartCleanup(interval, maxIdle time.Duration)
+}
+
+// Store holds a limiter per client, keyed by a string such as an IP address. It's safe for
+// concurrent use.
+type Store struct {
+	mu        sync.Mutex
+	algorithm Algorithm
+	rps       float64
+	burst     int
+	clients   map[string]*client
+}
+
+type client struct {
+	limiter  Limiter
+	lastSeen time.Time
+}
+
+// NewStore returns a store whose limiters use algorithm, rps and burst as in New.
+func NewStore(algorithm Algorithm, rps float64, burst int) *Store {
+	return &Store{
+		algorithm: algorithm,
+		rps:       rps,
+		burst:     burst,
+		clients:   make(map[string]*client),
+	}
+}
+
+// Allow reports whether the client identified by key may make a request now.
+func (s *Store) Allow(key string) Result {
+	return s.allow(key, time.Now())
+}
+
+func (s *Store) allow(key string, now time.Time) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, found := s.clients[key]
+	if !found {
+		c = &client{limiter: New(s.algorithm, s.rps, s.burst)}
+		s.clients[key] = c
+	}
+
+	c.lastSeen = now
+	return c.limiter.Allow(now)
+}
+
+// Configure changes the algorithm and limits used from now on. If any of them changed, the
+// limiters of every client are dropped, so that each client starts afresh under the new
+// settings.
+func (s *Store) Configure(algorithm Algorithm, rps float64, burst int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if algorithm == s.algorithm && rps == s.rps && burst == s.burst {
+		return
+	}
+
+	s.algorithm, s.rps, s.burst = algorithm, rps, burst
+	s.clients = make(map[string]*client)
+}
+
+// Cleanup removes the limiters of the clients which haven't made a request for maxIdle.
+func (s *Store) Cleanup(maxIdle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, c := range s.clients {
+		if time.Since(c.lastSeen) > maxIdle {
+			delete(s.clients, key)
+		}
+	}
+}
+
+// StartCleanup calls Cleanup(maxIdle) every interval, for the lifetime of the process.
+func (s *Store) StartCleanup(interval, maxIdle time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			s.Cleanup(maxIdle)
+		}
+	}()
+}
@@ -0,0 +1,165 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// allowed returns how many of n requests made at once at now are allowed.
+func allowed(l Limiter, now time.Time, n int) int {
+	count := 0
+	for i := 0; i < n; i++ {
+		if l.Allow(now).Allowed {
+			count++
+		}
+	}
+	return count
+}
+
+func TestBurst(t *testing.T) {
+	// Every algorithm allows a burst of 4 requests from a new client, and then a rate of 2
+	// per second, but they recover from the burst differently.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		algorithm Algorithm
+		after     time.Duration // time since the burst
+		want      int           // requests allowed at that time
+	}{
+		// A token is back every 500ms.
+		{TokenBucket, 500 * time.Millisecond, 1},
+		{TokenBucket, 2 * time.Second, 4},
+		// The 2s window is all used up until the next one starts.
+		{FixedWindow, 500 * time.Millisecond, 0},
+		{FixedWindow, 2 * time.Second, 4},
+		// The burst is forgotten 2s after it was made.
+		{SlidingLog, 500 * time.Millisecond, 0},
+		{SlidingLog, 2 * time.Second, 4},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.algorithm, 2, 4)
+
+		if got := allowed(l, start, 10); got != 4 {
+			t.Errorf("%s: got %d of a burst of 10 allowed; want 4", tt.algorithm, got)
+		}
+		if got := allowed(l, start.Add(tt.after), 10); got != tt.want {
+			t.Errorf("%s: got %d allowed %v after the burst; want %d", tt.algorithm, got, tt.after, tt.want)
+		}
+	}
+}
+
+func TestWindowEdge(t *testing.T) {
+	// Two bursts either side of the edge of a 2s window: a fixed window allows both, a
+	// sliding log and a token bucket only the first.
+	start := time.Date(2024, 1, 1, 0, 0, 1, int(900*time.Millisecond), time.UTC)
+	edge := start.Add(200 * time.Millisecond)
+
+	tests := map[Algorithm]int{
+		FixedWindow: 8,
+		SlidingLog:  4,
+		TokenBucket: 4,
+	}
+
+	for algorithm, want := range tests {
+		l := New(algorithm, 2, 4)
+		if got := allowed(l, start, 4) + allowed(l, edge, 4); got != want {
+			t.Errorf("%s: got %d allowed across the window edge; want %d", algorithm, got, want)
+		}
+	}
+}
+
+func TestResult(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// After a burst of 4 at 2 requests per second, each algorithm reports when the client
+	// may try again, and when it may make a full burst again.
+	tests := []struct {
+		algorithm  Algorithm
+		now        time.Time
+		retryAfter time.Duration
+		reset      time.Duration
+	}{
+		{TokenBucket, start, 500 * time.Millisecond, 2 * time.Second},
+		{FixedWindow, start.Add(500 * time.Millisecond), 1500 * time.Millisecond, 1500 * time.Millisecond},
+		{SlidingLog, start.Add(500 * time.Millisecond), 1500 * time.Millisecond, 1500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.algorithm, 2, 4)
+
+		res := l.Allow(start)
+		if !res.Allowed || res.Limit != 4 || res.Remaining != 3 {
+			t.Errorf("%s: got %+v for the first request; want allowed with 3 of 4 remaining", tt.algorithm, res)
+		}
+		allowed(l, start, 3)
+
+		res = l.Allow(tt.now)
+		want := Result{Limit: 4, RetryAfter: tt.retryAfter, Reset: tt.reset}
+		if res != want {
+			t.Errorf("%s: got %+v; want %+v", tt.algorithm, res, want)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore(SlidingLog, 1, 1)
+	now := time.Now()
+
+	if !s.allow("a", now).Allowed || s.allow("a", now).Allowed {
+		t.Fatal("want the first request of a allowed and the second denied")
+	}
+	if !s.allow("b", now).Allowed {
+		t.Error("want clients limited separately")
+	}
+
+	s.Configure(SlidingLog, 1, 1)
+	if s.allow("a", now).Allowed {
+		t.Error("want limiters kept when the settings don't change")
+	}
+
+	s.Configure(FixedWindow, 1, 2)
+	if !s.allow("a", now).Allowed {
+		t.Error("want limiters reset when the settings change")
+	}
+
+	if _, err := ParseAlgorithm("leaky-bucket"); err == nil {
+		t.Error("want an error for an unknown algorithm")
+	}
+}
+
+func TestLimiterState(t *testing.T) {
+	// A limiter restored from the state saved by another, as RedisStore does on every request,
+	// carries on exactly where the other left off.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, algorithm := range []Algorithm{TokenBucket, FixedWindow, SlidingLog} {
+		want := New(algorithm, 2, 4)
+		var state *limiterState
+
+		for i := 0; i < 20; i++ {
+			now := start.Add(time.Duration(i) * 300 * time.Millisecond)
+
+			// A new client has no state yet.
+			got := New(algorithm, 2, 4)
+			if state != nil {
+				js, err := json.Marshal(state)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var loaded limiterState
+				if err := json.Unmarshal(js, &loaded); err != nil {
+					t.Fatal(err)
+				}
+				loadState(got, loaded)
+			}
+
+			if res, wantRes := got.Allow(now), want.Allow(now); res != wantRes {
+				t.Fatalf("%s: request %d got %+v; want %+v", algorithm, i, res, wantRes)
+			}
+			saved := saveState(got)
+			state = &saved
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisTimeout is how long a limiter may take to be updated in Redis.
+	redisTimeout = 500 * time.Millisecond
+	// redisRetries is how many times a limiter updated concurrently by another request is
+	// read again before giving up.
+	redisRetries = 5
+	// redisDownTime is how long the limiters are kept in the process after Redis returned an
+	// error.
+	redisDownTime = 30 * time.Second
+)
+
+// limiterState is the state of a limiter as it is stored in Redis. Each algorithm only uses
+// the fields it needs.
+type limiterState struct {
+	Tokens float64     `json:"tokens,omitempty"`
+	Last   time.Time   `json:"last"`
+	Start  time.Time   `json:"start"`
+	Count  int         `json:"count,omitempty"`
+	Times  []time.Time `json:"times,omitempty"`
+}
+
+// saveState returns the state of l.
+func saveState(l Limiter) limiterState {
+	switch l := l.(type) {
+	case *tokenBucket:
+		return limiterState{Tokens: l.tokens, Last: l.last}
+	case *fixedWindow:
+		return limiterState{Start: l.start, Count: l.count}
+	case *slidingLog:
+		return limiterState{Times: l.times}
+	}
+	return limiterState{}
+}
+
+// loadState restores the state of l from s.
+func loadState(l Limiter, s limiterState) {
+	switch l := l.(type) {
+	case *tokenBucket:
+		l.tokens, l.last = s.Tokens, s.Last
+	case *fixedWindow:
+		l.start, l.count = s.Start, s.Count
+	case *slidingLog:
+		l.times = s.Times
+	}
+}
+
+// RedisStore is a ClientStore which keeps the state of every client's limiter in Redis, so
+// that a client's requests count against the same limit on every instance of the application.
+// The limiters run the same algorithms as in the process: each request reads the state, updates
+// it and writes it back in a Redis transaction, which is retried if another request changed the
+// state in the meantime.
+//
+// While Redis is unavailable, the limiters are kept in the process instead, so that clients
+// are still limited, if only per instance. Create a RedisStore with NewRedisStore.
+type RedisStore struct {
+	client  *redis.Client
+	prefix  string
+	onError func(error)
+
+	mu        sync.Mutex
+	algorithm Algorithm
+	rps       float64
+	burst     int
+
+	// fallback holds the limiters while Redis is unavailable.
+	fallback *Store
+	// downUntil is the time, in Unix nanoseconds, until which Redis isn't used.
+	downUntil int64
+}
+
+// NewRedisStore returns a store keeping the limiters, which use algorithm, rps and burst as in
+// New, in client under keys starting with prefix. onError, if not nil, is called with the
+// errors returned by Redis.
+func NewRedisStore(client *redis.Client, prefix string, algorithm Algorithm, rps float64, burst int, onError func(error)) *RedisStore {
+	return &RedisStore{
+		client:    client,
+		prefix:    prefix,
+		onError:   onError,
+		algorithm: algorithm,
+		rps:       rps,
+		burst:     burst,
+		fallback:  NewStore(algorithm, rps, burst),
+	}
+}
+
+// Allow reports whether the client identified by key may make a request now.
+func (s *RedisStore) Allow(key string) Result {
+	now := time.Now()
+	if now.UnixNano() < atomic.LoadInt64(&s.downUntil) {
+		return s.fallback.Allow(key)
+	}
+
+	res, err := s.allow(key, now)
+	if err != nil {
+		atomic.StoreInt64(&s.downUntil, now.Add(redisDownTime).UnixNano())
+		if s.onError != nil {
+			s.onError(err)
+		}
+		return s.fallback.Allow(key)
+	}
+
+	return res
+}
+
+func (s *RedisStore) allow(key string, now time.Time) (Result, error) {
+	s.mu.Lock()
+	algorithm, rps, burst := s.algorithm, s.rps, s.burst
+	s.mu.Unlock()
+
+	// The settings are part of the key, so that every client starts afresh when they change,
+	// like with Store.Configure. The state of a limiter is back to that of a new one after a
+	// full window, so it expires then.
+	redisKey := fmt.Sprintf("%s%s:%g:%d:%s", s.prefix, algorithm, rps, burst, key)
+	ttl := time.Duration(float64(burst)/rps*float64(time.Second)) + time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var res Result
+	update := func(tx *redis.Tx) error {
+		l := New(algorithm, rps, burst)
+
+		js, err := tx.Get(ctx, redisKey).Bytes()
+		switch {
+		case err == nil:
+			var state limiterState
+			if err := json.Unmarshal(js, &state); err != nil {
+				return err
+			}
+			loadState(l, state)
+		case !errors.Is(err, redis.Nil):
+			return err
+		}
+
+		res = l.Allow(now)
+
+		js, err = json.Marshal(saveState(l))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, redisKey, js, ttl)
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for i := 0; i < redisRetries; i++ {
+		err = s.client.Watch(ctx, update, redisKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+
+	return res, err
+}
+
+// Configure changes the algorithm and limits used from now on.
+func (s *RedisStore) Configure(algorithm Algorithm, rps float64, burst int) {
+	s.mu.Lock()
+	s.algorithm, s.rps, s.burst = algorithm, rps, burst
+	s.mu.Unlock()
+
+	s.fallback.Configure(algorithm, rps, burst)
+}
+
+// StartCleanup drops the limiters kept in the process while Redis was unavailable. The ones in
+// Redis expire on their own.
+func (s *RedisStore) StartCleanup(interval, maxIdle time.Duration) {
+	s.fallback.StartCleanup(interval, maxIdle)
+}
@@ -0,0 +1,31 @@
+// Package secrets reads secrets, such as database credentials, from an external secret store
+// at startup, so that they never have to be written down in the configuration of a deployment.
+package secrets
+
+import (
+	"context"
+	"time"
+)
+
+// Secret is a set of values read from a secret store.
+type Secret struct {
+	// Path is where the secret was read from, e.g. "database/creds/greenlight".
+	Path string
+	Data map[string]string
+	// LeaseID identifies the lease of a secret which expires, such as dynamic database
+	// credentials, after LeaseDuration unless it is renewed. It is empty for static secrets.
+	LeaseID       string
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// Provider is a secret store, such as Vault.
+type Provider interface {
+	// Name identifies the store in errors and the log, e.g. "vault".
+	Name() string
+	// Read returns the secret at path.
+	Read(ctx context.Context, path string) (*Secret, error)
+	// Renew extends the lease of a secret by increment, and returns the duration the lease
+	// was actually extended by, which the store can cap.
+	Renew(ctx context.Context, leaseID string, increment time.Duration) (time.Duration, error)
+}
@@ -0,0 +1,151 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault reads secrets from HashiCorp Vault over its HTTP API, authenticating with a token.
+// Both the static secrets of the KV secrets engine, version 1 or 2, and the dynamic secrets of
+// engines such as the database one, which come with a lease, can be read.
+type Vault struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+// NewVault returns a Vault for the server at addr, e.g. "https://vault.example.com:8200".
+// namespace is the Vault Enterprise namespace, or "" for none.
+func NewVault(addr, token, namespace string) *Vault {
+	return &Vault{
+		addr:      strings.TrimSuffix(addr, "/"),
+		token:     token,
+		namespace: namespace,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name implements Provider.
+func (v *Vault) Name() string {
+	return "vault"
+}
+
+// vaultResponse is the envelope of Vault's responses. Data is only set when reading a secret.
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Errors        []string               `json:"errors"`
+}
+
+// Read implements Provider. The secrets of the KV engine version 2 are nested in a "data"
+// object next to their "metadata", and are unwrapped, so that a path such as
+// "secret/data/greenlight" reads the same values as "secret/greenlight" in version 1. Values
+// which aren't strings are returned as JSON.
+func (v *Vault) Read(ctx context.Context, path string) (*Secret, error) {
+	path = strings.Trim(path, "/")
+
+	resp, err := v.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Data == nil {
+		return nil, fmt.Errorf("vault: no secret at %s", path)
+	}
+
+	values := resp.Data
+	if nested, ok := values["data"].(map[string]interface{}); ok {
+		if _, ok := values["metadata"]; ok {
+			values = nested
+		}
+	}
+
+	secret := &Secret{
+		Path:          path,
+		Data:          make(map[string]string, len(values)),
+		LeaseID:       resp.LeaseID,
+		LeaseDuration: time.Duration(resp.LeaseDuration) * time.Second,
+		Renewable:     resp.Renewable,
+	}
+	for key, value := range values {
+		if s, ok := value.(string); ok {
+			secret.Data[key] = s
+			continue
+		}
+		js, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("vault: encoding %s of %s: %w", key, path, err)
+		}
+		secret.Data[key] = string(js)
+	}
+
+	return secret, nil
+}
+
+// Renew implements Provider.
+func (v *Vault) Renew(ctx context.Context, leaseID string, increment time.Duration) (time.Duration, error) {
+	body := map[string]interface{}{
+		"lease_id":  leaseID,
+		"increment": int(increment / time.Second),
+	}
+
+	resp, err := v.do(ctx, http.MethodPut, "sys/leases/renew", body)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(resp.LeaseDuration) * time.Second, nil
+}
+
+// do sends a request to the Vault API at path, with body encoded as JSON if it isn't nil, and
+// decodes the response. The errors Vault reports are included in the returned error.
+func (v *Vault) do(ctx context.Context, method, path string, body interface{}) (*vaultResponse, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&reqBody).Encode(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+path, &reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var vr vaultResponse
+	err = json.NewDecoder(resp.Body).Decode(&vr)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("vault: decoding %s /v1/%s response: %w", method, path, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if len(vr.Errors) > 0 {
+			return nil, fmt.Errorf("vault: %s /v1/%s returned %s: %s", method, path, resp.Status, strings.Join(vr.Errors, "; "))
+		}
+		return nil, fmt.Errorf("vault: %s /v1/%s returned %s", method, path, resp.Status)
+	}
+
+	return &vr, nil
+}
@@ -0,0 +1,86 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVaultRead(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/greenlight/smtp":
+			w.Write([]byte(`{"data":{"data":{"username":"mailer","password":"s3cr3t","port":587},"metadata":{"version":3}}}`))
+		case "/v1/database/creds/greenlight":
+			w.Write([]byte(`{"lease_id":"database/creds/greenlight/abc","lease_duration":3600,"renewable":true,"data":{"username":"v-greenlight","password":"pa55word"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	vault := NewVault(srv.URL+"/", "s.token", "team")
+
+	smtp, err := vault.Read(context.Background(), "/secret/data/greenlight/smtp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smtp.Data["username"] != "mailer" || smtp.Data["password"] != "s3cr3t" || smtp.Data["port"] != "587" || smtp.LeaseID != "" {
+		t.Errorf("got %+v", smtp)
+	}
+
+	db, err := vault.Read(context.Background(), "database/creds/greenlight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Data["username"] != "v-greenlight" || db.LeaseID != "database/creds/greenlight/abc" || db.LeaseDuration != time.Hour || !db.Renewable {
+		t.Errorf("got %+v", db)
+	}
+
+	_, err = vault.Read(context.Background(), "secret/data/missing")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got error %v; want a 404", err)
+	}
+
+	_, err = NewVault(srv.URL, "wrong", "team").Read(context.Background(), "database/creds/greenlight")
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("got error %v; want the error reported by Vault", err)
+	}
+}
+
+func TestVaultRenew(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			LeaseID   string `json:"lease_id"`
+			Increment int    `json:"increment"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		if r.Method != http.MethodPut || r.URL.Path != "/v1/sys/leases/renew" || body.LeaseID != "database/creds/greenlight/abc" || body.Increment != 3600 {
+			t.Errorf("got %s %s with %+v", r.Method, r.URL.Path, body)
+		}
+
+		// The lease is capped by its maximum TTL.
+		w.Write([]byte(`{"lease_id":"database/creds/greenlight/abc","lease_duration":1800,"renewable":true}`))
+	}))
+	defer srv.Close()
+
+	got, err := NewVault(srv.URL, "s.token", "").Renew(context.Background(), "database/creds/greenlight/abc", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 30*time.Minute {
+		t.Errorf("got lease duration %s; want 30m", got)
+	}
+}
@@ -0,0 +1,82 @@
+// Package systemd implements the two parts of the systemd service protocol the API server
+// uses: inheriting a listening socket from socket activation, and reporting its state with
+// sd_notify. Both are no-ops when the process wasn't started by systemd.
+package systemd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Notification states sent to the service manager, see sd_notify(3).
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// Listener returns the listening socket passed by systemd socket activation, or nil if there
+// is none. Only the first socket is used, and the LISTEN_* variables are cleared so that child
+// processes don't inherit them.
+//
+// Because systemd keeps the socket open while the server restarts, connections made during
+// the restart wait in the backlog instead of being refused.
+func Listener() (net.Listener, error) {
+	return listener(os.Getenv, os.Unsetenv, os.Getpid())
+}
+
+func listener(getenv func(string) string, unsetenv func(string) error, pid int) (net.Listener, error) {
+	listenPID, err := strconv.Atoi(getenv("LISTEN_PID"))
+	if err != nil || listenPID != pid {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		unsetenv(name)
+	}
+
+	f := os.NewFile(listenFDsStart, "systemd-socket")
+	if f == nil {
+		return nil, errors.New("systemd: invalid socket activation file descriptor")
+	}
+	defer f.Close()
+
+	// FileListener duplicates the descriptor, so f is closed either way.
+	return net.FileListener(f)
+}
+
+// Notify sends state, such as Ready, to the service manager. It does nothing and returns nil
+// when NOTIFY_SOCKET isn't set, i.e. when the service isn't run with Type=notify.
+func Notify(state string) error {
+	return notify(os.Getenv("NOTIFY_SOCKET"), state)
+}
+
+func notify(socket, state string) error {
+	if socket == "" {
+		return nil
+	}
+
+	// A leading @ names a socket in the abstract namespace.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
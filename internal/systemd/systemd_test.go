@@ -0,0 +1,53 @@
+package systemd
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestNotify(t *testing.T) {
+	if err := notify("", Ready); err != nil {
+		t.Fatalf("got %v without a notify socket; want nil", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := notify(path, Stopping); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != Stopping {
+		t.Errorf("got %q; want %q", got, Stopping)
+	}
+}
+
+func TestListenerNotActivated(t *testing.T) {
+	tests := []map[string]string{
+		{},
+		{"LISTEN_PID": "1", "LISTEN_FDS": "1"},
+		{"LISTEN_PID": "42", "LISTEN_FDS": "0"},
+	}
+
+	for _, env := range tests {
+		unset := func(string) error {
+			t.Errorf("unset the environment of %v", env)
+			return nil
+		}
+
+		ln, err := listener(func(k string) string { return env[k] }, unset, 42)
+		if ln != nil || err != nil {
+			t.Errorf("got %v, %v for %v; want no listener", ln, err, env)
+		}
+	}
+}
@@ -1,13 +1,31 @@
 package validator
 
-import "regexp"
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
 
 var (
 	// EmailRX is a regex for sanity checking the format of email addresses.
 	// The regex pattern used is taken from  https://html.spec.whatwg.org/#valid-e-mail-address.
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+	// UUIDRX is a regex for UUIDs in their canonical form, e.g.
+	// 0b8a1c5e-9f3d-4c2b-8a7e-6d5f4e3c2b1a, in either case.
+	UUIDRX = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+	// SlugRX is a regex for URL slugs: lowercase letters and digits, in words separated by
+	// single dashes, e.g. the-shawshank-redemption.
+	SlugRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
 )
 
+// DateLayout is the layout of the dates checked by Date, e.g. 2024-03-31.
+const DateLayout = "2006-01-02"
+
 // Validator struct type contains a map of validation errors.
 type Validator struct {
 	Errors map[string]string
@@ -38,6 +56,37 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// Merge adds the errors of child, a validator of a nested object or array element, with their
+// keys nested under key: the error of "name" merged under "cast[0]" becomes the error of
+// "cast[0].name". Like AddError, it doesn't replace the errors v already has.
+func (v *Validator) Merge(key string, child *Validator) {
+	for childKey, message := range child.Errors {
+		v.AddError(Field(key, childKey), message)
+	}
+}
+
+// Index returns the key of the element at index i of the array at key, e.g. "genres[2]", so
+// that the client can tell which element failed.
+func Index(key string, i int) string {
+	return key + "[" + strconv.Itoa(i) + "]"
+}
+
+// Field returns the key of a field of the object at key, e.g. "cast[0].name". Either key or
+// field may be empty, and a field which is itself an index, such as "[1]", isn't preceded by a
+// dot.
+func Field(key, field string) string {
+	switch {
+	case key == "":
+		return field
+	case field == "":
+		return key
+	case strings.HasPrefix(field, "["):
+		return key + field
+	default:
+		return key + "." + field
+	}
+}
+
 // In returns true if a specific value is in a list of strings.
 func In(value string, list ...string) bool {
 	for i := range list {
@@ -63,3 +112,80 @@ func Unique(values []string) bool {
 
 	return len(values) == len(uniqueValues)
 }
+
+// URL returns true if a string value is an absolute URL with a host, and one of the schemes,
+// or http or https when there are none.
+func URL(value string, schemes ...string) bool {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	return In(strings.ToLower(u.Scheme), schemes...)
+}
+
+// UUID returns true if a string value is a UUID in its canonical form.
+func UUID(value string) bool {
+	return UUIDRX.MatchString(value)
+}
+
+// Date returns true if a string value is a calendar date in the DateLayout, which rules out
+// dates such as 2023-02-29.
+func Date(value string) bool {
+	_, err := time.Parse(DateLayout, value)
+	return err == nil
+}
+
+// Slug returns true if a string value is a URL slug.
+func Slug(value string) bool {
+	return SlugRX.MatchString(value)
+}
+
+// CountryCode returns true if a string value is an ISO 3166-1 alpha-2 country code, in upper
+// case, e.g. GB.
+func CountryCode(value string) bool {
+	return len(value) == 2 && strings.Contains(countryCodes, " "+value+" ")
+}
+
+// countryCodes lists the officially assigned ISO 3166-1 alpha-2 codes, each surrounded by
+// spaces.
+const countryCodes = " " +
+	"AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ " +
+	"BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ " +
+	"CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ " +
+	"DE DJ DK DM DO DZ " +
+	"EC EE EG EH ER ES ET " +
+	"FI FJ FK FM FO FR " +
+	"GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY " +
+	"HK HM HN HR HT HU " +
+	"ID IE IL IM IN IO IQ IR IS IT " +
+	"JE JM JO JP " +
+	"KE KG KH KI KM KN KP KR KW KY KZ " +
+	"LA LB LC LI LK LR LS LT LU LV LY " +
+	"MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ " +
+	"NA NC NE NF NG NI NL NO NP NR NU NZ " +
+	"OM " +
+	"PA PE PF PG PH PK PL PM PN PR PS PT PW PY " +
+	"QA " +
+	"RE RO RS RU RW " +
+	"SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ " +
+	"TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ " +
+	"UA UG UM US UY UZ " +
+	"VA VC VE VG VI VN VU " +
+	"WF WS " +
+	"YE YT " +
+	"ZA ZM ZW "
+
+// MinRunes returns true if a string value is at least n characters long. Unlike len(), it
+// counts the characters rather than the bytes, so that "Amélie" is 6 characters long.
+func MinRunes(value string, n int) bool {
+	return utf8.RuneCountInString(value) >= n
+}
+
+// MaxRunes returns true if a string value is at most n characters long, counting the
+// characters rather than the bytes.
+func MaxRunes(value string, n int) bool {
+	return utf8.RuneCountInString(value) <= n
+}
@@ -0,0 +1,89 @@
+package validator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChecks(t *testing.T) {
+	tests := []struct {
+		name  string
+		check func(string) bool
+		valid []string
+		wrong []string
+	}{
+		{"URL", func(s string) bool { return URL(s) },
+			[]string{"https://example.com/poster.jpg", "HTTP://example.com"},
+			[]string{"", "example.com/poster.jpg", "/poster.jpg", "ftp://example.com/poster.jpg", "https://"}},
+		{"URL ftp", func(s string) bool { return URL(s, "ftp") },
+			[]string{"ftp://example.com/poster.jpg"},
+			[]string{"https://example.com/poster.jpg"}},
+		{"UUID", UUID,
+			[]string{"0b8a1c5e-9f3d-4c2b-8a7e-6d5f4e3c2b1a", "0B8A1C5E-9F3D-4C2B-8A7E-6D5F4E3C2B1A"},
+			[]string{"", "0b8a1c5e9f3d4c2b8a7e6d5f4e3c2b1a", "{0b8a1c5e-9f3d-4c2b-8a7e-6d5f4e3c2b1a}", "0b8a1c5e-9f3d-4c2b-8a7e-6d5f4e3c2b1g"}},
+		{"Date", Date,
+			[]string{"2024-02-29", "1895-12-28"},
+			[]string{"", "2023-02-29", "2024-13-01", "28/12/1895", "2024-02-29T00:00:00Z"}},
+		{"Slug", Slug,
+			[]string{"moana", "the-shawshank-redemption", "2001-a-space-odyssey"},
+			[]string{"", "Moana", "the--matrix", "-moana", "moana-", "amélie"}},
+		{"CountryCode", CountryCode,
+			[]string{"GB", "US", "FR", "ZW", "AD"},
+			[]string{"", "gb", "UK", "XX", "GBR", "G", " G"}},
+	}
+
+	for _, tt := range tests {
+		for _, s := range tt.valid {
+			if !tt.check(s) {
+				t.Errorf("%s(%q) = false; want true", tt.name, s)
+			}
+		}
+		for _, s := range tt.wrong {
+			if tt.check(s) {
+				t.Errorf("%s(%q) = true; want false", tt.name, s)
+			}
+		}
+	}
+
+	if n := len(strings.Fields(countryCodes)); n != 249 {
+		t.Errorf("got %d country codes; want the 249 assigned ones", n)
+	}
+}
+
+func TestRunes(t *testing.T) {
+	if !MaxRunes("Amélie", 6) || MaxRunes("Amélie", 5) {
+		t.Error("want MaxRunes to count the characters of Amélie")
+	}
+	if !MinRunes("千と千尋", 4) || MinRunes("千と千尋", 5) {
+		t.Error("want MinRunes to count the characters of 千と千尋")
+	}
+}
+
+func TestNestedKeys(t *testing.T) {
+	if got := Field(Index("cast", 0), "name"); got != "cast[0].name" {
+		t.Errorf("got %q; want cast[0].name", got)
+	}
+	if got := Field("matrix", "[1]"); got != "matrix[1]" {
+		t.Errorf("got %q; want matrix[1]", got)
+	}
+
+	child := New()
+	child.AddError("name", "must be provided")
+	child.AddError("", "must be an object")
+
+	v := New()
+	v.AddError("cast[0].name", "must not be more than 500 characters long")
+	v.Merge("cast[0]", child)
+	v.Merge("cast[1]", child)
+
+	want := map[string]string{
+		"cast[0].name": "must not be more than 500 characters long",
+		"cast[0]":      "must be an object",
+		"cast[1].name": "must be provided",
+		"cast[1]":      "must be an object",
+	}
+	if !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("got  %v\nwant %v", v.Errors, want)
+	}
+}
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'comments:moderate';
+DROP TABLE IF EXISTS comments;
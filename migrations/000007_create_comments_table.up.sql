@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS comments
+(
+	id         BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	movie_id   BIGINT                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+	user_id    BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	-- parent_id is NULL for top-level comments and points at the comment being replied to
+	-- otherwise. Deleting a comment deletes the whole sub-thread below it.
+	parent_id  BIGINT                      REFERENCES comments ON DELETE CASCADE,
+	body       TEXT                        NOT NULL,
+	-- Hidden comments are kept so that the thread structure stays intact, but their body
+	-- is only shown to moderators.
+	hidden     BOOL                        NOT NULL DEFAULT FALSE,
+	version    INTEGER                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS comments_movie_id_parent_id_idx ON comments (movie_id, parent_id);
+
+INSERT INTO permissions (code) VALUES ('comments:moderate');
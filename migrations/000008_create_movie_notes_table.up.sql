@@ -0,0 +1,11 @@
+-- A private note that a user keeps about a movie. Each user can have at most one note per
+-- movie, so (user_id, movie_id) is the primary key.
+CREATE TABLE IF NOT EXISTS movie_notes
+(
+	user_id    BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	movie_id   BIGINT                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	body       TEXT                        NOT NULL,
+	PRIMARY KEY (user_id, movie_id)
+);
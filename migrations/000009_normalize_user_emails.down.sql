@@ -0,0 +1 @@
+-- The original spelling of the email addresses isn't kept, so there is nothing to undo.
@@ -0,0 +1,31 @@
+-- Backfill the lower case form of every email address, matching the default email
+-- normalization policy (-email-lowercase=true). The email column is CITEXT UNIQUE, so case
+-- variants can't collide here, but it also means we have to compare as TEXT to find the rows
+-- which still need updating. Gmail alias folding (-email-fold-gmail) is opt-in and isn't
+-- backfilled: the addresses registered before it was enabled are still found as they are stored,
+-- see UserModel.GetByEmail.
+--
+-- Trimming can make two addresses the same, e.g. 'a@x.com ' and 'a@x.com', which the UNIQUE
+-- constraint rejects. Those addresses are left as they are, and reported, so that the accounts
+-- can be merged by hand.
+DO $$
+DECLARE
+	duplicates TEXT;
+BEGIN
+	SELECT string_agg(format('%L (user %s)', email::text, id), ', ' ORDER BY id) INTO duplicates
+	FROM users
+	WHERE LOWER(TRIM(email::text)) IN (
+		SELECT LOWER(TRIM(email::text)) FROM users GROUP BY 1 HAVING COUNT(*) > 1
+	);
+
+	IF duplicates IS NOT NULL THEN
+		RAISE WARNING 'these email addresses are the same once normalized and have been left as they are: %', duplicates;
+	END IF;
+END
+$$;
+
+UPDATE users SET email = LOWER(TRIM(email::text))
+WHERE email::text <> LOWER(TRIM(email::text))
+AND LOWER(TRIM(email::text)) IN (
+	SELECT LOWER(TRIM(email::text)) FROM users GROUP BY 1 HAVING COUNT(*) = 1
+);
@@ -0,0 +1,12 @@
+-- Anonymous, read-only trial tokens. They aren't associated with a user, so they live in their
+-- own table rather than in tokens (whose user_id is NOT NULL). The client IP is kept so that
+-- the number of trial tokens issued per IP can be capped.
+CREATE TABLE IF NOT EXISTS trial_tokens
+(
+	hash       BYTEA PRIMARY KEY,
+	ip         TEXT                        NOT NULL,
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	expiry     TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS trial_tokens_ip_created_at_idx ON trial_tokens (ip, created_at);
@@ -0,0 +1,22 @@
+-- bulk_operations records every bulk operation, from the dry-run preview through to its
+-- completion. Rows are never deleted, so the table doubles as the audit trail of who retired
+-- which part of the catalogue, when, and with which filters.
+CREATE TABLE IF NOT EXISTS bulk_operations
+(
+	id                BIGSERIAL PRIMARY KEY,
+	created_at        TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	user_id           BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	kind              TEXT                        NOT NULL, -- e.g. 'movies.delete'
+	filters           JSONB                       NOT NULL,
+	-- 'preview', 'running', 'completed' or 'failed'
+	status            TEXT                        NOT NULL,
+	-- SHA-256 hash of the confirmation token handed out with the dry-run preview.
+	confirmation_hash BYTEA UNIQUE,
+	-- The confirmation token can't be used after this time.
+	expiry            TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+	total             INTEGER                     NOT NULL DEFAULT 0,
+	processed         INTEGER                     NOT NULL DEFAULT 0,
+	error             TEXT                        NOT NULL DEFAULT '',
+	started_at        TIMESTAMP(0) WITH TIME ZONE,
+	finished_at       TIMESTAMP(0) WITH TIME ZONE
+);
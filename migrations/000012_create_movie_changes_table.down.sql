@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS movies_change_feed ON movies;
+DROP FUNCTION IF EXISTS record_movie_change();
+DROP TABLE IF EXISTS movie_changes;
@@ -0,0 +1,28 @@
+-- movie_changes is an append-only change feed for the movies table. Every insert, update and
+-- delete is recorded by a trigger with a monotonically increasing change_seq, together with a
+-- JSON snapshot of the row (the old row for deletes). Differential backups read the feed from
+-- the last change_seq they have seen.
+CREATE TABLE IF NOT EXISTS movie_changes
+(
+	change_seq BIGSERIAL PRIMARY KEY,
+	changed_at TIMESTAMP(6) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	movie_id   BIGINT                      NOT NULL,
+	op         TEXT                        NOT NULL, -- 'insert', 'update' or 'delete'
+	data       JSONB                       NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION record_movie_change() RETURNS TRIGGER AS $$
+BEGIN
+	IF (TG_OP = 'DELETE') THEN
+		INSERT INTO movie_changes (movie_id, op, data) VALUES (OLD.id, 'delete', to_jsonb(OLD));
+		RETURN OLD;
+	END IF;
+
+	INSERT INTO movie_changes (movie_id, op, data) VALUES (NEW.id, LOWER(TG_OP), to_jsonb(NEW));
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_change_feed
+	AFTER INSERT OR UPDATE OR DELETE ON movies
+	FOR EACH ROW EXECUTE FUNCTION record_movie_change();
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'users:view-as';
+DROP TABLE IF EXISTS view_as_audits;
@@ -0,0 +1,15 @@
+-- view_as_audits records every request an administrator makes while viewing the API as another
+-- user with the X-View-As header.
+CREATE TABLE IF NOT EXISTS view_as_audits
+(
+	id             BIGSERIAL PRIMARY KEY,
+	created_at     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	admin_id       BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	target_user_id BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	method         TEXT                        NOT NULL,
+	path           TEXT                        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS view_as_audits_admin_id_idx ON view_as_audits (admin_id);
+
+INSERT INTO permissions (code) VALUES ('users:view-as');
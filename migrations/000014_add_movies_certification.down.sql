@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS movies_certification_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS certification;
@@ -0,0 +1,7 @@
+-- certification holds the age rating of a movie in the "<country>:<rating>" format, e.g.
+-- "US:PG-13". An empty string means the movie hasn't been rated. The list of valid ratings is
+-- kept in the application (data.Certifications), so it can grow without a migration.
+ALTER TABLE movies
+	ADD COLUMN IF NOT EXISTS certification TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS movies_certification_idx ON movies (certification);
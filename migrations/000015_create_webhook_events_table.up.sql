@@ -0,0 +1,23 @@
+-- webhook_events records every inbound webhook event we accept. The primary key on the
+-- provider and the provider's own event id is what protects us against replayed deliveries.
+CREATE TABLE IF NOT EXISTS webhook_events
+(
+	provider     TEXT                        NOT NULL,
+	event_id     TEXT                        NOT NULL,
+	event_type   TEXT                        NOT NULL,
+	received_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	payload      JSONB                       NOT NULL,
+	status       TEXT                        NOT NULL DEFAULT 'pending', -- 'pending', 'processed' or 'failed'
+	error        TEXT                        NOT NULL DEFAULT '',
+	processed_at TIMESTAMP(0) WITH TIME ZONE,
+	PRIMARY KEY (provider, event_id)
+);
+
+-- email_suppressions holds addresses that we must not send email to any more, because the
+-- mail provider told us they bounced or the recipient complained.
+CREATE TABLE IF NOT EXISTS email_suppressions
+(
+	email      CITEXT PRIMARY KEY,
+	reason     TEXT                        NOT NULL,
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+-- admin:read grants access to the read-only operational endpoints under /v1/admin.
+INSERT INTO permissions (code) VALUES ('admin:read');
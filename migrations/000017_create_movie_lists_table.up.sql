@@ -0,0 +1,39 @@
+-- A named list of movies kept by a user. The slug is a random, unguessable string used in the
+-- URL of the list, so that unlisted lists can be shared with anyone who has the link.
+CREATE TABLE IF NOT EXISTS movie_lists
+(
+	id          BIGSERIAL PRIMARY KEY,
+	created_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	updated_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	owner_id    BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	slug        TEXT                        NOT NULL UNIQUE,
+	name        TEXT                        NOT NULL,
+	description TEXT                        NOT NULL DEFAULT '',
+	visibility  TEXT                        NOT NULL DEFAULT 'private'
+		CHECK (visibility IN ('public', 'unlisted', 'private')),
+	version     INTEGER                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS movie_lists_owner_id_idx ON movie_lists (owner_id);
+
+CREATE TABLE IF NOT EXISTS movie_list_items
+(
+	list_id  BIGINT                      NOT NULL REFERENCES movie_lists ON DELETE CASCADE,
+	movie_id BIGINT                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+	added_by BIGINT                      REFERENCES users ON DELETE SET NULL,
+	added_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (list_id, movie_id)
+);
+
+-- Users invited by the owner to edit a list. accepted_at is NULL until the invitation has been
+-- accepted, and only then can the user add and remove movies.
+CREATE TABLE IF NOT EXISTS movie_list_collaborators
+(
+	list_id     BIGINT                      NOT NULL REFERENCES movie_lists ON DELETE CASCADE,
+	user_id     BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	invited_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	accepted_at TIMESTAMP(0) WITH TIME ZONE,
+	PRIMARY KEY (list_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_list_collaborators_user_id_idx ON movie_list_collaborators (user_id);
@@ -0,0 +1,11 @@
+-- Links local accounts to users of an external identity provider. Accounts with a row here are
+-- managed by the identity provider sync: they are created, deactivated and given permissions
+-- according to the directory.
+CREATE TABLE IF NOT EXISTS idp_identities
+(
+	user_id     BIGINT                      PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+	provider    TEXT                        NOT NULL,
+	external_id TEXT                        NOT NULL,
+	linked_at   TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	UNIQUE (provider, external_id)
+);
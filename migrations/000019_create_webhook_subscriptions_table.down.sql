@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'webhooks:write';
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
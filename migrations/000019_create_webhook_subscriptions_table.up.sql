@@ -0,0 +1,39 @@
+-- Outbound webhook subscriptions. Every event of one of the subscribed types is sent to url
+-- in a POST request signed with secret.
+CREATE TABLE IF NOT EXISTS webhook_subscriptions
+(
+	id         BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	user_id    BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	url        TEXT                        NOT NULL,
+	events     TEXT[]                      NOT NULL,
+	secret     TEXT                        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_subscriptions_user_id_idx ON webhook_subscriptions (user_id);
+CREATE INDEX IF NOT EXISTS webhook_subscriptions_events_idx ON webhook_subscriptions USING GIN (events);
+
+-- One row for every event sent to a subscription. Pending deliveries are retried with backoff
+-- until they succeed or run out of attempts, and the rows are kept as the delivery log.
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+	id              BIGSERIAL PRIMARY KEY,
+	created_at      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	subscription_id BIGINT                      NOT NULL REFERENCES webhook_subscriptions ON DELETE CASCADE,
+	event_id        TEXT                        NOT NULL,
+	event_type      TEXT                        NOT NULL,
+	payload         JSONB                       NOT NULL,
+	status          TEXT                        NOT NULL DEFAULT 'pending'
+		CHECK (status IN ('pending', 'delivered', 'failed')),
+	attempts        INTEGER                     NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	response_status INTEGER,
+	error           TEXT                        NOT NULL DEFAULT '',
+	delivered_at    TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_id_idx ON webhook_deliveries (subscription_id, id);
+
+-- webhooks:write allows a user to manage their own webhook subscriptions.
+INSERT INTO permissions (code) VALUES ('webhooks:write');
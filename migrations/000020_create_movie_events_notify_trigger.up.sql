@@ -0,0 +1,18 @@
+-- Every committed change to the movies table is announced on the movie_events channel, so that
+-- every API instance can publish it on its change feed. The payload only carries the operation
+-- and the movie id, as NOTIFY payloads are limited to 8000 bytes.
+CREATE OR REPLACE FUNCTION notify_movie_event() RETURNS TRIGGER AS $$
+BEGIN
+	IF (TG_OP = 'DELETE') THEN
+		PERFORM pg_notify('movie_events', json_build_object('op', 'delete', 'id', OLD.id)::text);
+		RETURN OLD;
+	END IF;
+
+	PERFORM pg_notify('movie_events', json_build_object('op', LOWER(TG_OP), 'id', NEW.id)::text);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_notify_events
+	AFTER INSERT OR UPDATE OR DELETE ON movies
+	FOR EACH ROW EXECUTE FUNCTION notify_movie_event();
@@ -0,0 +1,19 @@
+-- outbox holds the side effects of a change, such as the welcome email of a new user or the
+-- webhook event of a new movie, written in the same transaction as the change itself. A
+-- background dispatcher sends them once committed and retries them until they succeed, so
+-- they can't be lost if the process stops between the commit and the send.
+CREATE TABLE IF NOT EXISTS outbox
+(
+	id              BIGSERIAL PRIMARY KEY,
+	created_at      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	kind            TEXT                        NOT NULL CHECK (kind IN ('email', 'webhook')),
+	payload         JSONB                       NOT NULL,
+	status          TEXT                        NOT NULL DEFAULT 'pending'
+		CHECK (status IN ('pending', 'sent', 'failed')),
+	attempts        INTEGER                     NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	error           TEXT                        NOT NULL DEFAULT '',
+	sent_at         TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS outbox_due_idx ON outbox (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,22 @@
+-- audit_log records every successful POST, PUT, PATCH and DELETE request: who made it, which
+-- route and entity it changed and, where the handler provides them, the entity before and
+-- after the change.
+CREATE TABLE IF NOT EXISTS audit_log
+(
+	id         BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	actor_id   BIGINT REFERENCES users ON DELETE SET NULL,
+	method     TEXT                        NOT NULL,
+	route      TEXT                        NOT NULL,
+	path       TEXT                        NOT NULL,
+	status     INTEGER                     NOT NULL,
+	entity     TEXT                        NOT NULL,
+	entity_id  TEXT                        NOT NULL DEFAULT '',
+	before     JSONB,
+	after      JSONB,
+	ip         TEXT                        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id);
@@ -0,0 +1,9 @@
+-- user_usage counts the API requests each user makes per calendar month (UTC), for the
+-- monthly quotas. month is the first day of the month.
+CREATE TABLE IF NOT EXISTS user_usage
+(
+	user_id  BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+	month    DATE   NOT NULL,
+	requests BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, month)
+);
@@ -0,0 +1,11 @@
+-- movie_popularity holds the popularity score of the movies added to lists or commented on
+-- recently. It is recomputed by a scheduled job rather than on every change, so movies with no
+-- recent activity simply have no row.
+CREATE TABLE IF NOT EXISTS movie_popularity
+(
+	movie_id    BIGINT PRIMARY KEY          REFERENCES movies ON DELETE CASCADE,
+	score       BIGINT                      NOT NULL,
+	computed_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS movie_popularity_score_idx ON movie_popularity (score DESC);
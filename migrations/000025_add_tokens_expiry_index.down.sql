@@ -0,0 +1 @@
+DROP INDEX IF EXISTS tokens_expiry_idx;
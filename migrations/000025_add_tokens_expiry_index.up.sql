@@ -0,0 +1,2 @@
+-- The scheduled token purge looks up the expired tokens by expiry.
+CREATE INDEX IF NOT EXISTS tokens_expiry_idx ON tokens (expiry);
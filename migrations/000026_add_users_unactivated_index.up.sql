@@ -0,0 +1,3 @@
+-- The scheduled account cleanup looks up the unactivated accounts by when they registered.
+-- Only a small share of the users are unactivated, so the index is partial.
+CREATE INDEX IF NOT EXISTS users_unactivated_created_at_idx ON users (created_at) WHERE NOT activated;
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'admin:write';
+DROP TABLE IF EXISTS email_dead_letters;
@@ -0,0 +1,20 @@
+-- email_dead_letters holds the emails which couldn't be sent, once every attempt has failed, so
+-- that an administrator can look into them and requeue them. outbox_id is the outbox message
+-- which gave up, or NULL for emails sent without the outbox. requeued_at is set once the email
+-- has been queued again.
+CREATE TABLE IF NOT EXISTS email_dead_letters
+(
+	id          BIGSERIAL PRIMARY KEY,
+	created_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	outbox_id   BIGINT                      REFERENCES outbox ON DELETE SET NULL,
+	recipient   TEXT                        NOT NULL,
+	template    TEXT                        NOT NULL,
+	data        JSONB                       NOT NULL,
+	attempts    INTEGER                     NOT NULL,
+	error       TEXT                        NOT NULL,
+	requeued_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+-- admin:write grants access to the operational endpoints under /v1/admin which change things,
+-- such as requeueing dead letters.
+INSERT INTO permissions (code) VALUES ('admin:write');
@@ -0,0 +1 @@
+DELETE FROM permissions WHERE code IN ('*', 'movies:*', 'comments:*', 'users:*', 'admin:*', 'webhooks:*', '*:read', '*:write');
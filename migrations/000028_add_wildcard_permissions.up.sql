@@ -0,0 +1,12 @@
+-- Wildcard permissions grant every permission they match, see data.Permissions.Include: '*'
+-- grants everything, '<resource>:*' every permission on a resource and '*:read' and '*:write'
+-- the read or write permission on every resource, including the ones added later.
+INSERT INTO permissions (code) VALUES
+	('*'),
+	('movies:*'),
+	('comments:*'),
+	('users:*'),
+	('admin:*'),
+	('webhooks:*'),
+	('*:read'),
+	('*:write');
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code IN ('movies:write:own', 'movies:write:any');
+
+DROP INDEX IF EXISTS movies_created_by_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS created_by;
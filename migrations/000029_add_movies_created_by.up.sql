@@ -0,0 +1,9 @@
+-- created_by is the user who created the movie. It is NULL for the movies created before it was
+-- recorded, and once their creator is deleted, which then only movies:write:any can change.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by BIGINT REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS movies_created_by_idx ON movies (created_by);
+
+-- movies:write:own lets users change the movies they created, and movies:write:any every movie.
+-- movies:write grants both, see data.Permissions.Include.
+INSERT INTO permissions (code) VALUES ('movies:write:own'), ('movies:write:any');
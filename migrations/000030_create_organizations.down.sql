@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS movies_org_id_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS org_id;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,30 @@
+-- Organizations let several teams share one deployment, each with its own movie catalogue. The
+-- slug names the organization in the X-Organization header and the /orgs/<slug> path prefix.
+CREATE TABLE IF NOT EXISTS organizations
+(
+	id         BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	slug       TEXT                        NOT NULL UNIQUE,
+	name       TEXT                        NOT NULL,
+	version    INTEGER                     NOT NULL DEFAULT 1
+);
+
+-- The members of an organization. Owners and admins manage the members, and only owners can
+-- make other members owners or admins.
+CREATE TABLE IF NOT EXISTS organization_members
+(
+	org_id     BIGINT                      NOT NULL REFERENCES organizations ON DELETE CASCADE,
+	user_id    BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	role       TEXT                        NOT NULL DEFAULT 'member'
+		CHECK (role IN ('owner', 'admin', 'member')),
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON organization_members (user_id);
+
+-- org_id is the organization whose catalogue a movie belongs to. The movies without one make up
+-- the shared catalogue, served to the requests which don't name an organization.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS movies_org_id_idx ON movies (org_id);
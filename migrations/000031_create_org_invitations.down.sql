@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS org_invitations;
+
+DELETE FROM tokens WHERE scope = 'org-invitation';
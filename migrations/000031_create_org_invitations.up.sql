@@ -0,0 +1,15 @@
+-- The pending invitations to join an organization. The invitation token is a row of the tokens
+-- table with the 'org-invitation' scope, issued to the member who sent the invitation, so the
+-- invitations expire and are purged with it, and revoking one deletes its token.
+CREATE TABLE IF NOT EXISTS org_invitations
+(
+	id         BIGSERIAL PRIMARY KEY,
+	token_hash BYTEA                       NOT NULL UNIQUE REFERENCES tokens (hash) ON DELETE CASCADE,
+	org_id     BIGINT                      NOT NULL REFERENCES organizations ON DELETE CASCADE,
+	email      CITEXT                      NOT NULL,
+	role       TEXT                        NOT NULL DEFAULT 'member'
+		CHECK (role IN ('owner', 'admin', 'member')),
+	invited_by BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	UNIQUE (org_id, email)
+);
@@ -0,0 +1,21 @@
+-- auth_events records the authentication events of the users: logins, whether they succeeded
+-- or not, the activation and password reset tokens issued to them, and the activations and
+-- password resets, with the client's IP address and user agent, for investigating incidents.
+-- The failed logins with an unknown email address have no user, but keep the address.
+CREATE TABLE IF NOT EXISTS auth_events
+(
+	id         BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	user_id    BIGINT REFERENCES users ON DELETE SET NULL,
+	email      CITEXT                      NOT NULL DEFAULT '',
+	type       TEXT                        NOT NULL,
+	success    BOOLEAN                     NOT NULL,
+	reason     TEXT                        NOT NULL DEFAULT '',
+	ip         TEXT                        NOT NULL,
+	user_agent TEXT                        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS auth_events_created_at_idx ON auth_events (created_at);
+CREATE INDEX IF NOT EXISTS auth_events_user_id_idx ON auth_events (user_id);
+CREATE INDEX IF NOT EXISTS auth_events_email_idx ON auth_events (email);
+CREATE INDEX IF NOT EXISTS auth_events_ip_idx ON auth_events (ip);
@@ -0,0 +1,11 @@
+CREATE OR REPLACE FUNCTION record_movie_change() RETURNS TRIGGER AS $$
+BEGIN
+	IF (TG_OP = 'DELETE') THEN
+		INSERT INTO movie_changes (movie_id, op, data) VALUES (OLD.id, 'delete', to_jsonb(OLD));
+		RETURN OLD;
+	END IF;
+
+	INSERT INTO movie_changes (movie_id, op, data) VALUES (NEW.id, LOWER(TG_OP), to_jsonb(NEW));
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
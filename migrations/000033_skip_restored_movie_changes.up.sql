@@ -0,0 +1,17 @@
+-- Restores replay backed up changes with the greenlight.restoring setting turned on for their
+-- transaction. Those changes are already in the backup, so the trigger doesn't record them again.
+CREATE OR REPLACE FUNCTION record_movie_change() RETURNS TRIGGER AS $$
+BEGIN
+	IF (current_setting('greenlight.restoring', true) = 'on') THEN
+		RETURN NULL;
+	END IF;
+
+	IF (TG_OP = 'DELETE') THEN
+		INSERT INTO movie_changes (movie_id, op, data) VALUES (OLD.id, 'delete', to_jsonb(OLD));
+		RETURN OLD;
+	END IF;
+
+	INSERT INTO movie_changes (movie_id, op, data) VALUES (NEW.id, LOWER(TG_OP), to_jsonb(NEW));
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
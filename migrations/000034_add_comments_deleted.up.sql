@@ -0,0 +1,3 @@
+-- Comments deleted by their author while they have replies are kept as tombstones, so that the
+-- replies, which may be other users', aren't deleted with them. Their body is replaced.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted BOOL NOT NULL DEFAULT FALSE;
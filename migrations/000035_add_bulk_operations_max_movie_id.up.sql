@@ -0,0 +1,4 @@
+-- max_movie_id is the highest id among the movies a dry-run counted. The bulk operation only
+-- deletes movies up to it, so the movies created after the dry-run are never deleted, even in
+-- the same second. Previews made before this column existed delete nothing.
+ALTER TABLE bulk_operations ADD COLUMN IF NOT EXISTS max_movie_id BIGINT NOT NULL DEFAULT 0;
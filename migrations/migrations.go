@@ -0,0 +1,41 @@
+// Package migrations embeds the SQL migrations, so that the API can apply them itself with
+// golang-migrate (see "api migrate") and tell whether the database schema is up to date.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Files holds the up and down migrations, named like 000001_create_movies_table.up.sql.
+//
+//go:embed *.sql
+var Files embed.FS
+
+// Latest returns the version of the newest up migration in Files.
+func Latest() (int64, error) {
+	return latest(Files)
+}
+
+func latest(fsys fs.FS) (int64, error) {
+	names, err := fs.Glob(fsys, "*.up.sql")
+	if err != nil {
+		return 0, err
+	}
+
+	var version int64
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(name, "_")
+		v, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		if v > version {
+			version = v
+		}
+	}
+
+	return version, nil
+}
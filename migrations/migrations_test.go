@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+func TestLatest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000001_create_movies_table.up.sql":   {},
+		"000001_create_movies_table.down.sql": {},
+		"000012_add_movies_index.up.sql":      {},
+		"000013_drop_movies_index.down.sql":   {},
+		"README.up.sql":                       {},
+	}
+
+	got, err := latest(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 12 {
+		t.Errorf("got %d; want 12", got)
+	}
+
+	got, err = Latest()
+	if err != nil || got < 22 {
+		t.Errorf("got %d, %v for the embedded migrations; want at least 22", got, err)
+	}
+}
+
+func TestFilesReadable(t *testing.T) {
+	// Walk the embedded migrations the way "api migrate up" does, checking that every one has both
+	// an up and a down migration.
+	src, err := iofs.New(Files, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := src.First()
+	for err == nil {
+		for _, read := range []func(uint) (io.ReadCloser, string, error){src.ReadUp, src.ReadDown} {
+			r, _, err := read(version)
+			if err != nil {
+				t.Errorf("migration %d: %v", version, err)
+				continue
+			}
+			r.Close()
+		}
+		version, err = src.Next(version)
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v after the last migration; want fs.ErrNotExist", err)
+	}
+}